@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/spf13/pflag"
+)
+
+const decodeCookieUsage = `usage:
+  oauth2-proxy decode-cookie --cookie-secret <secret> [--cookie-secret <old secret>] [--cookie-name _oauth2_proxy] [--show-tokens] <value>
+
+Split cookies (<name>_0, <name>_1, ...) must be joined in order before decoding.`
+
+// redactedToken replaces tokens in the decoded session unless --show-tokens
+// is given
+const redactedToken = "<redacted>"
+
+// decodedCookie is the output of the decode-cookie command
+type decodedCookie struct {
+	Name        string                 `json:"name"`
+	SecretIndex int                    `json:"secretIndex"`
+	SignedAt    time.Time              `json:"signedAt"`
+	Age         string                 `json:"age"`
+	Ticket      string                 `json:"ticket,omitempty"`
+	Session     *sessions.SessionState `json:"session,omitempty"`
+}
+
+// runDecodeCookieCommand decodes a session cookie for debugging
+func runDecodeCookieCommand(args []string) error {
+	return decodeCookie(os.Stdout, args)
+}
+
+// decodeCookie validates the signature of a cookie value with each of the
+// given secrets in turn and writes the decoded session and signature
+// metadata as JSON.
+func decodeCookie(w io.Writer, args []string) error {
+	flagSet := pflag.NewFlagSet("decode-cookie", pflag.ContinueOnError)
+	secrets := flagSet.StringSlice("cookie-secret", []string{}, "the secret used to sign and encrypt the cookie (may be given multiple times to try each)")
+	name := flagSet.String("cookie-name", "_oauth2_proxy", "the name of the cookie")
+	showTokens := flagSet.Bool("show-tokens", false, "print access, ID and refresh tokens instead of redacting them")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 || len(*secrets) == 0 {
+		return errors.New(decodeCookieUsage)
+	}
+
+	cookie := &http.Cookie{
+		Name:  *name,
+		Value: strings.TrimSpace(flagSet.Arg(0)),
+	}
+
+	for i, secret := range *secrets {
+		value, signedAt, ok := encryption.ParseSignedValue(cookie, secret)
+		if !ok {
+			continue
+		}
+
+		decoded := &decodedCookie{
+			Name:        cookie.Name,
+			SecretIndex: i,
+			SignedAt:    signedAt.UTC(),
+			Age:         time.Since(signedAt).Truncate(time.Second).String(),
+		}
+
+		// Server side session stores only keep a ticket in the cookie
+		if isSessionTicket(cookie.Name, value) {
+			decoded.Ticket = string(value)
+			if !*showTokens {
+				decoded.Ticket = redactTicket(decoded.Ticket)
+			}
+		} else {
+			cipher, err := encryption.NewCFBCipher(encryption.SecretBytes(secret))
+			if err != nil {
+				return fmt.Errorf("unable to create cipher from cookie secret %d: %v", i, err)
+			}
+			integrity, err := sessions.NewIntegrityCheck([]byte(secret), false)
+			if err != nil {
+				return err
+			}
+			cipher = sessions.WithIntegrityCheck(cipher, integrity)
+			session, err := sessions.DecodeSessionState(value, cipher, true)
+			if err != nil {
+				return fmt.Errorf("cookie signature is valid but the session could not be decoded: %v", err)
+			}
+			if !*showTokens {
+				redactSessionTokens(session)
+			}
+			decoded.Session = session
+		}
+
+		out, err := json.MarshalIndent(decoded, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to marshal decoded cookie: %v", err)
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+
+	return fmt.Errorf("cookie %q could not be validated with any of the given secrets", cookie.Name)
+}
+
+// isSessionTicket checks whether a cookie value is a server side session
+// ticket (<cookie name>-<hex id>.<base64 secret>)
+func isSessionTicket(name string, value []byte) bool {
+	parts := strings.Split(string(value), ".")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], name+"-") {
+		return false
+	}
+	if _, err := hex.DecodeString(strings.TrimPrefix(parts[0], name+"-")); err != nil {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(parts[1])
+	return err == nil
+}
+
+// redactTicket removes the per session encryption secret from a ticket,
+// leaving the ID used as the key in the session store.
+func redactTicket(ticket string) string {
+	return strings.SplitN(ticket, ".", 2)[0] + "." + redactedToken
+}
+
+func redactSessionTokens(s *sessions.SessionState) {
+	for _, token := range []*string{&s.AccessToken, &s.IDToken, &s.RefreshToken} {
+		if *token != "" {
+			*token = redactedToken
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decode Cookie Command Suite", func() {
+	const (
+		secret    = "0123456789abcdef"
+		oldSecret = "fedcba9876543210"
+	)
+
+	var value string
+
+	BeforeEach(func() {
+		session := &sessions.SessionState{
+			Email:        "user@example.com",
+			User:         "user",
+			AccessToken:  "access",
+			RefreshToken: "refresh",
+		}
+		cipher, err := encryption.NewCFBCipher(encryption.SecretBytes(secret))
+		Expect(err).ToNot(HaveOccurred())
+		encoded, err := session.EncodeSessionState(cipher, true)
+		Expect(err).ToNot(HaveOccurred())
+
+		value, err = encryption.SignedValue(secret, "_oauth2_proxy", encoded, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	decode := func(args ...string) *decodedCookie {
+		buf := bytes.NewBuffer(nil)
+		Expect(decodeCookie(buf, args)).To(Succeed())
+
+		decoded := &decodedCookie{}
+		Expect(json.Unmarshal(buf.Bytes(), decoded)).To(Succeed())
+		return decoded
+	}
+
+	It("decodes the session and redacts tokens", func() {
+		decoded := decode("--cookie-secret", secret, value)
+		Expect(decoded.Name).To(Equal("_oauth2_proxy"))
+		Expect(decoded.SecretIndex).To(Equal(0))
+		Expect(decoded.Session.Email).To(Equal("user@example.com"))
+		Expect(decoded.Session.AccessToken).To(Equal(redactedToken))
+		Expect(decoded.Session.RefreshToken).To(Equal(redactedToken))
+		Expect(decoded.Session.IDToken).To(BeEmpty())
+	})
+
+	It("shows tokens with --show-tokens", func() {
+		decoded := decode("--cookie-secret", secret, "--show-tokens", value)
+		Expect(decoded.Session.AccessToken).To(Equal("access"))
+		Expect(decoded.Session.RefreshToken).To(Equal("refresh"))
+	})
+
+	It("reports which secret validated the cookie", func() {
+		decoded := decode("--cookie-secret", oldSecret, "--cookie-secret", secret, value)
+		Expect(decoded.SecretIndex).To(Equal(1))
+	})
+
+	It("decodes server side session tickets", func() {
+		ticketValue, err := encryption.SignedValue(secret, "_oauth2_proxy", []byte("_oauth2_proxy-0123abcd.c2VjcmV0"), time.Now())
+		Expect(err).ToNot(HaveOccurred())
+
+		decoded := decode("--cookie-secret", secret, ticketValue)
+		Expect(decoded.Session).To(BeNil())
+		Expect(decoded.Ticket).To(Equal("_oauth2_proxy-0123abcd." + redactedToken))
+	})
+
+	It("fails when no secret validates the cookie", func() {
+		err := decodeCookie(bytes.NewBuffer(nil), []string{"--cookie-secret", oldSecret, value})
+		Expect(err).To(MatchError(`cookie "_oauth2_proxy" could not be validated with any of the given secrets`))
+	})
+
+	It("fails when the cookie name does not match", func() {
+		err := decodeCookie(bytes.NewBuffer(nil), []string{"--cookie-secret", secret, "--cookie-name", "other", value})
+		Expect(err).To(MatchError(`cookie "other" could not be validated with any of the given secrets`))
+	})
+
+	It("requires a secret and a value", func() {
+		Expect(decodeCookie(bytes.NewBuffer(nil), []string{value})).To(MatchError(decodeCookieUsage))
+	})
+})
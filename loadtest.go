@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const loadTestUsage = `usage:
+  oauth2-proxy loadtest --url <proxy url> --cookie <session cookie> [--cookie-name _oauth2_proxy]
+    [--refresh-cookie <session cookie>] [--allowlisted-path /ping] [--authenticated-path /]
+    [--refresh-path /] [--requests 100] [--concurrency 10] [--timeout 10s]
+
+The session cookie can be copied from a browser after signing in. Requests
+made with the --refresh-cookie should use a session that is older than
+--cookie-refresh so that the proxy refreshes it.`
+
+// loadTestClass is a kind of request generated by the loadtest command
+type loadTestClass struct {
+	name   string
+	path   string
+	cookie string
+}
+
+// loadTestResult collects the latencies of the requests in a class
+type loadTestResult struct {
+	class     loadTestClass
+	latencies []time.Duration
+	errors    int
+}
+
+// runLoadTestCommand generates traffic against a running proxy and reports
+// the latency of each class of request
+func runLoadTestCommand(args []string) error {
+	return loadTest(os.Stdout, args)
+}
+
+// loadTest sends requests to allowlisted, authenticated and refresh
+// triggering paths in turn and writes latency percentiles for each.
+// Any response other than a 2xx is counted as an error, as the proxy
+// redirects or rejects requests where the session is not accepted.
+func loadTest(w io.Writer, args []string) error {
+	flagSet := pflag.NewFlagSet("loadtest", pflag.ContinueOnError)
+	proxyURL := flagSet.String("url", "", "the base URL of the running proxy")
+	cookieName := flagSet.String("cookie-name", "_oauth2_proxy", "the name of the session cookie")
+	cookie := flagSet.String("cookie", "", "a valid session cookie value, reused for every authenticated request")
+	refreshCookie := flagSet.String("refresh-cookie", "", "a session cookie value that is due to be refreshed (refresh requests are skipped if unset)")
+	allowlistedPath := flagSet.String("allowlisted-path", "/ping", "a path that does not require authentication (skipped if empty)")
+	authenticatedPath := flagSet.String("authenticated-path", "/", "a path that requires authentication")
+	refreshPath := flagSet.String("refresh-path", "/", "a path that requires authentication, requested with the refresh cookie")
+	requests := flagSet.Int("requests", 100, "the number of requests of each class")
+	concurrency := flagSet.Int("concurrency", 10, "the number of concurrent requests")
+	timeout := flagSet.Duration("timeout", 10*time.Second, "the timeout of each request")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if *proxyURL == "" || *cookie == "" {
+		return errors.New(loadTestUsage)
+	}
+	if *requests < 1 || *concurrency < 1 {
+		return errors.New("--requests and --concurrency must be greater than 0")
+	}
+
+	classes := []loadTestClass{}
+	if *allowlistedPath != "" {
+		classes = append(classes, loadTestClass{name: "allowlisted", path: *allowlistedPath})
+	}
+	classes = append(classes, loadTestClass{name: "authenticated", path: *authenticatedPath, cookie: *cookie})
+	if *refreshCookie != "" {
+		classes = append(classes, loadTestClass{name: "refresh", path: *refreshPath, cookie: *refreshCookie})
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		// Redirects to the sign in page are failures, not requests to follow
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	base := strings.TrimSuffix(*proxyURL, "/")
+
+	results := make([]*loadTestResult, 0, len(classes))
+	for _, class := range classes {
+		result, err := runLoadTestClass(client, base, *cookieName, class, *requests, *concurrency)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+
+	return writeLoadTestResults(w, results)
+}
+
+// runLoadTestClass sends the requests for a class across the workers
+func runLoadTestClass(client *http.Client, base, cookieName string, class loadTestClass, requests, concurrency int) (*loadTestResult, error) {
+	// Validate the URL once rather than counting every request as an error
+	if _, err := newLoadTestRequest(base, cookieName, class); err != nil {
+		return nil, fmt.Errorf("invalid %s request: %v", class.name, err)
+	}
+
+	result := &loadTestResult{class: class}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	work := make(chan struct{})
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				latency, ok := doLoadTestRequest(client, base, cookieName, class)
+
+				mutex.Lock()
+				result.latencies = append(result.latencies, latency)
+				if !ok {
+					result.errors++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < requests; i++ {
+		work <- struct{}{}
+	}
+	close(work)
+	wg.Wait()
+
+	return result, nil
+}
+
+func newLoadTestRequest(base, cookieName string, class loadTestClass) (*http.Request, error) {
+	req, err := http.NewRequest("GET", base+class.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if class.cookie != "" {
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: class.cookie})
+	}
+	return req, nil
+}
+
+// doLoadTestRequest makes a single request, returning its latency and
+// whether it succeeded
+func doLoadTestRequest(client *http.Client, base, cookieName string, class loadTestClass) (time.Duration, bool) {
+	req, err := newLoadTestRequest(base, cookieName, class)
+	if err != nil {
+		return 0, false
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), false
+	}
+	// Read the whole body so the latency includes the response
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	return latency, err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func writeLoadTestResults(w io.Writer, results []*loadTestResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLASS\tPATH\tREQUESTS\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, result := range results {
+		sort.Slice(result.latencies, func(i, j int) bool {
+			return result.latencies[i] < result.latencies[j]
+		})
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			result.class.name,
+			result.class.path,
+			len(result.latencies),
+			result.errors,
+			percentile(result.latencies, 50),
+			percentile(result.latencies, 90),
+			percentile(result.latencies, 99),
+			percentile(result.latencies, 100),
+		)
+	}
+	return tw.Flush()
+}
+
+// percentile returns the nearest rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Microsecond)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load Test Command Suite", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/ping" {
+				rw.WriteHeader(http.StatusOK)
+				return
+			}
+			cookie, err := req.Cookie("_oauth2_proxy")
+			if err != nil {
+				http.Redirect(rw, req, "/oauth2/sign_in", http.StatusFound)
+				return
+			}
+			switch cookie.Value {
+			case "valid", "stale":
+				rw.WriteHeader(http.StatusOK)
+			default:
+				rw.WriteHeader(http.StatusForbidden)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// run returns the report rows keyed by class
+	run := func(args ...string) map[string][]string {
+		buf := bytes.NewBuffer(nil)
+		Expect(loadTest(buf, append([]string{"--url", server.URL}, args...))).To(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(strings.Fields(lines[0])).To(Equal([]string{"CLASS", "PATH", "REQUESTS", "ERRORS", "P50", "P90", "P99", "MAX"}))
+
+		rows := map[string][]string{}
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			rows[fields[0]] = fields
+		}
+		return rows
+	}
+
+	It("reports each class of request", func() {
+		rows := run("--cookie", "valid", "--refresh-cookie", "stale", "--requests", "20", "--concurrency", "4")
+		Expect(rows).To(HaveLen(3))
+		Expect(rows["allowlisted"][1:4]).To(Equal([]string{"/ping", "20", "0"}))
+		Expect(rows["authenticated"][1:4]).To(Equal([]string{"/", "20", "0"}))
+		Expect(rows["refresh"][1:4]).To(Equal([]string{"/", "20", "0"}))
+	})
+
+	It("skips the allowlisted and refresh classes when unset", func() {
+		rows := run("--cookie", "valid", "--allowlisted-path", "", "--requests", "5")
+		Expect(rows).To(HaveLen(1))
+		Expect(rows).To(HaveKey("authenticated"))
+	})
+
+	It("counts rejected sessions as errors", func() {
+		rows := run("--cookie", "invalid", "--requests", "5")
+		Expect(rows["authenticated"][3]).To(Equal("5"))
+	})
+
+	It("requires a url and a cookie", func() {
+		Expect(loadTest(bytes.NewBuffer(nil), []string{"--url", server.URL})).To(MatchError(loadTestUsage))
+	})
+
+	DescribeTable("percentile",
+		func(p float64, expected time.Duration) {
+			latencies := []time.Duration{}
+			for i := 1; i <= 10; i++ {
+				latencies = append(latencies, time.Duration(i)*time.Millisecond)
+			}
+			Expect(percentile(latencies, p)).To(Equal(expected))
+		},
+		Entry("p0", 0.0, time.Millisecond),
+		Entry("p50", 50.0, 5*time.Millisecond),
+		Entry("p90", 90.0, 9*time.Millisecond),
+		Entry("p99", 99.0, 10*time.Millisecond),
+		Entry("max", 100.0, 10*time.Millisecond),
+	)
+})
@@ -4,21 +4,45 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"os/signal"
 	"runtime"
-	"syscall"
 	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/events"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
-	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/server"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/service"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/version"
 	"github.com/spf13/pflag"
 )
 
+// subcommands are run in place of the proxy when their name is given as the
+// first argument
+var subcommands = map[string]func(args []string) error{
+	"secret":        runSecretCommand,
+	"decode-cookie": runDecodeCookieCommand,
+	"loadtest":      runLoadTestCommand,
+	"rules":         runRulesCommand,
+	"policy-test":   runPolicyTestCommand,
+}
+
 func main() {
 	logger.SetFlags(logger.Lshortfile)
 
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	configFlagSet := pflag.NewFlagSet("oauth2-proxy", pflag.ContinueOnError)
 	config := configFlagSet.String("config", "", "path to config file")
 	alphaConfig := configFlagSet.String("alpha-config", "", "path to alpha config file (use at your own risk - the structure in this config file may change between minor releases)")
@@ -27,7 +51,7 @@ func main() {
 	configFlagSet.Parse(os.Args[1:])
 
 	if *showVersion {
-		fmt.Printf("oauth2-proxy %s (built with %s)\n", VERSION, runtime.Version())
+		fmt.Printf("oauth2-proxy %s (built with %s)\n", version.VERSION, runtime.Version())
 		return
 	}
 
@@ -48,34 +72,62 @@ func main() {
 		return
 	}
 
-	err = validation.Validate(opts)
+	snapshot, err := reportConfiguration(opts, *alphaConfig != "")
 	if err != nil {
-		logger.Printf("%s", err)
+		logger.Printf("ERROR: %v", err)
 		os.Exit(1)
 	}
 
-	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
-	oauthproxy, err := NewOAuthProxy(opts, validator)
+	requests.SetMaxResponseBodySize(int64(opts.MaxResponseBodySize))
+	legacyCutoff, err := sessionsapi.ParseLegacyCutoff(opts.Session.LegacyCutoff)
 	if err != nil {
-		logger.Errorf("ERROR: Failed to initialise OAuth2 Proxy: %v", err)
+		logger.Printf("ERROR: %v", err)
 		os.Exit(1)
 	}
+	sessionsapi.SetLegacyCutoff(legacyCutoff)
+	if opts.MemoryLimitMB > 0 {
+		memory.Start(uint64(opts.MemoryLimitMB) << 20)
+	}
+
+	proxy, err := server.NewProxy(opts)
+	if err != nil {
+		logger.Printf("%s", err)
+		os.Exit(1)
+	}
+	logConfigReport(opts.GetConfigReport())
+
+	if opts.ConfigSnapshotFile != "" {
+		if err := snapshot.WriteFile(opts.ConfigSnapshotFile); err != nil {
+			logger.Errorf("ERROR: %v", err)
+		}
+	}
 
 	rand.Seed(time.Now().UnixNano())
 
-	s := &Server{
-		Handler: oauthproxy,
-		Opts:    opts,
-		stop:    make(chan struct{}, 1),
-	}
-	// Observe signals in background goroutine.
-	go func() {
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
-		<-sigint
-		s.stop <- struct{}{} // notify having caught signal
-	}()
-	s.ListenAndServe()
+	listeners, err := service.Listeners()
+	if err != nil {
+		logger.Fatalf("ERROR: %v", err)
+	}
+
+	s := server.NewServer(proxy, opts)
+	s.AdminHandler = proxy.AdminHandler()
+	s.AdminService = proxy.AdminService()
+	s.Listeners = listeners
+	s.Ready = service.NotifyReady
+	// Label auth and request logs with the provider and tenant
+	logger.SetProvider(opts.ProviderType, opts.Tenant)
+	// Stream auth events to the admin API
+	logger.SetAuthObserver(events.Publish)
+	// Serve until stopped by a signal or the service manager
+	err = service.Run(service.Handler{
+		Serve:  s.ListenAndServe,
+		Stop:   s.Stop,
+		Reload: s.Reload,
+	})
+	proxy.Stop()
+	if err != nil {
+		logger.Fatalf("ERROR: %v", err)
+	}
 }
 
 // loadConfiguration will load in the user's configuration.
@@ -148,6 +200,57 @@ func loadOptions(config string, extraFlags *pflag.FlagSet, args []string) (*opti
 	return opts, nil
 }
 
+// reportConfiguration compares the loaded configuration with the defaults and
+// with the snapshot saved by the previous load, and sets the resulting report
+// on the options.
+// It returns the snapshot of the loaded configuration.
+func reportConfiguration(opts *options.Options, alpha bool) (options.ConfigSnapshot, error) {
+	defaults := options.NewOptions()
+	if !alpha {
+		var err error
+		defaults, err = options.NewLegacyOptions().ToOptions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert default config: %v", err)
+		}
+	}
+	defaultSnapshot, err := options.NewConfigSnapshot(defaults)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := options.NewConfigSnapshot(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous options.ConfigSnapshot
+	if opts.ConfigSnapshotFile != "" {
+		previous, err = options.LoadConfigSnapshot(opts.ConfigSnapshotFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts.SetConfigReport(options.NewConfigReport(defaultSnapshot, snapshot, previous))
+	return snapshot, nil
+}
+
+// logConfigReport logs the options that differ from the defaults and from
+// the previous load
+func logConfigReport(report *options.ConfigReport) {
+	logger.Printf("oauth2-proxy %s: %d options differ from the defaults", version.VERSION, len(report.Defaults))
+	for _, change := range report.Defaults {
+		logger.Printf("  %s", change)
+	}
+
+	if report.Previous == nil {
+		return
+	}
+	logger.Printf("%d options changed since the previous load", len(report.Previous))
+	for _, change := range report.Previous {
+		logger.Printf("  %s", change)
+	}
+}
+
 // printConvertedConfig extracts alpha options from the loaded configuration
 // and renders these to stdout in YAML format.
 func printConvertedConfig(opts *options.Options) error {
@@ -0,0 +1,60 @@
+package allowlist
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// logMessagePrefix is the common prefix of allowlist log messages
+const logMessagePrefix = "Skipping auth - "
+
+// All trusts requests only when every one of its allowlists trusts them.
+// This allows conditions to be combined in a single entry, for example a
+// route that may only skip authentication from a trusted IP range.
+type All struct {
+	allowlists []Allowlist
+}
+
+// NewAll constructs an allowlist that requires all of the given allowlists
+// to trust a request
+func NewAll(allowlists ...Allowlist) *All {
+	return &All{
+		allowlists: allowlists,
+	}
+}
+
+// IsTrusted checks whether the request is trusted by every allowlist
+func (a *All) IsTrusted(req *http.Request) (string, bool) {
+	if len(a.allowlists) == 0 {
+		return "", false
+	}
+
+	entries := make([]string, 0, len(a.allowlists))
+	for _, allowlist := range a.allowlists {
+		entry, ok := allowlist.IsTrusted(req)
+		if !ok {
+			return "", false
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, " and "), true
+}
+
+// LogMessages describes the combined conditions as a single message
+func (a *All) LogMessages() []string {
+	conditions := make([]string, 0, len(a.allowlists))
+	for _, allowlist := range a.allowlists {
+		msgs := allowlist.LogMessages()
+		for i, msg := range msgs {
+			msgs[i] = strings.TrimPrefix(msg, logMessagePrefix)
+		}
+
+		condition := strings.Join(msgs, " OR ")
+		if len(msgs) > 1 {
+			condition = fmt.Sprintf("(%s)", condition)
+		}
+		conditions = append(conditions, condition)
+	}
+	return []string{logMessagePrefix + strings.Join(conditions, " AND ")}
+}
@@ -0,0 +1,80 @@
+package allowlist
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("All Suite", func() {
+	var all *All
+
+	BeforeEach(func() {
+		routes := NewRoutes()
+		Expect(routes.AddRoute("POST=^/internal/.*")).To(Succeed())
+
+		ips := NewIPs(nil)
+		Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+		Expect(ips.Add("192.168.0.0/16")).To(Succeed())
+
+		all = NewAll(routes, ips)
+	})
+
+	type isTrustedTableInput struct {
+		method        string
+		path          string
+		remoteAddr    string
+		expectedEntry string
+		expectTrusted bool
+	}
+
+	DescribeTable("IsTrusted",
+		func(in isTrustedTableInput) {
+			req := httptest.NewRequest(in.method, in.path, nil)
+			req.RemoteAddr = in.remoteAddr
+
+			entry, trusted := all.IsTrusted(req)
+			Expect(trusted).To(Equal(in.expectTrusted))
+			Expect(entry).To(Equal(in.expectedEntry))
+		},
+		Entry("with a matching route from a trusted IP", isTrustedTableInput{
+			method:        "POST",
+			path:          "/internal/jobs",
+			remoteAddr:    "10.1.2.3:43670",
+			expectedEntry: "route POST=^/internal/.* and trusted IP 10.1.2.3",
+			expectTrusted: true,
+		}),
+		Entry("with a matching route from an untrusted IP", isTrustedTableInput{
+			method:        "POST",
+			path:          "/internal/jobs",
+			remoteAddr:    "172.16.0.1:43670",
+			expectTrusted: false,
+		}),
+		Entry("with a non-matching method from a trusted IP", isTrustedTableInput{
+			method:        "GET",
+			path:          "/internal/jobs",
+			remoteAddr:    "10.1.2.3:43670",
+			expectTrusted: false,
+		}),
+		Entry("with a non-matching path from a trusted IP", isTrustedTableInput{
+			method:        "POST",
+			path:          "/public",
+			remoteAddr:    "192.168.1.1:43670",
+			expectTrusted: false,
+		}),
+	)
+
+	It("never trusts requests without any allowlists", func() {
+		req := httptest.NewRequest("GET", "/", nil)
+		_, trusted := NewAll().IsTrusted(req)
+		Expect(trusted).To(BeFalse())
+	})
+
+	It("combines the conditions in a single log message", func() {
+		Expect(all.LogMessages()).To(Equal([]string{
+			"Skipping auth - Method: POST | Path: ^/internal/.* AND (Trusted IP: 10.0.0.0/8 OR Trusted IP: 192.168.0.0/16)",
+		}))
+	})
+})
@@ -0,0 +1,18 @@
+package allowlist
+
+import (
+	"net/http"
+)
+
+// Allowlist determines whether a request is trusted and may skip
+// authentication.
+type Allowlist interface {
+	// IsTrusted checks whether the request is trusted by an entry in the
+	// allowlist.
+	// If it is, a description of the matching entry is returned.
+	IsTrusted(req *http.Request) (string, bool)
+
+	// LogMessages describes each of the entries in the allowlist so that
+	// they can be logged when the proxy starts.
+	LogMessages() []string
+}
@@ -0,0 +1,51 @@
+package allowlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// AuditRecord describes a request that was trusted by an allowlist
+type AuditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Entry     string    `json:"entry"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	ClientIP  string    `json:"clientIP"`
+}
+
+// NewAuditRecord constructs an AuditRecord for a request trusted by the
+// given allowlist entry
+func NewAuditRecord(req *http.Request, entry string, realClientIPParser ipapi.RealClientIPParser) AuditRecord {
+	return AuditRecord{
+		Timestamp: time.Now(),
+		Entry:     entry,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		ClientIP:  ip.GetClientString(realClientIPParser, req, true),
+	}
+}
+
+// AuditSink receives an AuditRecord for each request that is trusted by an
+// allowlist
+type AuditSink interface {
+	Record(AuditRecord)
+}
+
+// LoggerAuditSink writes audit records to the standard logger as JSON
+type LoggerAuditSink struct{}
+
+// Record logs the audit record
+func (LoggerAuditSink) Record(record AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.Errorf("Error marshalling allowlist audit record: %v", err)
+		return
+	}
+	logger.Printf("Allowlist audit: %s", data)
+}
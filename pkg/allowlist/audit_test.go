@@ -0,0 +1,37 @@
+package allowlist
+
+import (
+	"bytes"
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit Suite", func() {
+	It("builds an audit record from the request", func() {
+		req := httptest.NewRequest("POST", "/skip?foo=bar", nil)
+		req.RemoteAddr = "10.1.2.3:43670"
+
+		record := NewAuditRecord(req, "route POST=^/skip", nil)
+		Expect(record.Entry).To(Equal("route POST=^/skip"))
+		Expect(record.Method).To(Equal("POST"))
+		Expect(record.Path).To(Equal("/skip"))
+		Expect(record.ClientIP).To(Equal("10.1.2.3"))
+		Expect(record.Timestamp.IsZero()).To(BeFalse())
+	})
+
+	It("logs audit records as JSON with the LoggerAuditSink", func() {
+		buf := bytes.NewBuffer(nil)
+		logger.SetOutput(buf)
+		defer logger.SetOutput(GinkgoWriter)
+
+		req := httptest.NewRequest("GET", "/skip", nil)
+		req.RemoteAddr = "10.1.2.3:43670"
+		LoggerAuditSink{}.Record(NewAuditRecord(req, "route GET=^/skip", nil))
+
+		Expect(buf.String()).To(ContainSubstring(`Allowlist audit: {"timestamp":`))
+		Expect(buf.String()).To(ContainSubstring(`"entry":"route GET=^/skip","method":"GET","path":"/skip","clientIP":"10.1.2.3"}`))
+	})
+})
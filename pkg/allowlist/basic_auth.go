@@ -0,0 +1,44 @@
+package allowlist
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/basic"
+)
+
+// BasicAuth trusts requests with HTTP Basic credentials that are valid
+// against a validator, typically an htpasswd file.
+// This is intended for clients such as CI systems which cannot complete an
+// OAuth flow but can present static credentials.
+type BasicAuth struct {
+	validator basic.Validator
+	source    string
+}
+
+// NewBasicAuth constructs a Basic credentials allowlist.
+// The source describes where the credentials are loaded from for logging.
+func NewBasicAuth(validator basic.Validator, source string) *BasicAuth {
+	return &BasicAuth{
+		validator: validator,
+		source:    source,
+	}
+}
+
+// IsTrusted checks whether the request has valid Basic credentials
+func (b *BasicAuth) IsTrusted(req *http.Request) (string, bool) {
+	user, password, ok := req.BasicAuth()
+	if !ok || user == "" {
+		return "", false
+	}
+
+	if !b.validator.Validate(user, password) {
+		return "", false
+	}
+	return fmt.Sprintf("basic auth user %s", user), true
+}
+
+// LogMessages describes the source of the Basic credentials
+func (b *BasicAuth) LogMessages() []string {
+	return []string{fmt.Sprintf("Skipping auth - Basic auth users: %s", b.source)}
+}
@@ -0,0 +1,68 @@
+package allowlist
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fakeValidator accepts a fixed user and password
+type fakeValidator map[string]string
+
+func (f fakeValidator) Validate(user, password string) bool {
+	realPassword, ok := f[user]
+	return ok && realPassword == password
+}
+
+var _ = Describe("BasicAuth Suite", func() {
+	type isTrustedTableInput struct {
+		authorization string
+		expectedEntry string
+		expectTrusted bool
+	}
+
+	DescribeTable("IsTrusted",
+		func(in isTrustedTableInput) {
+			basicAuth := NewBasicAuth(fakeValidator{"ci": "s3cr3t"}, "/etc/htpasswd")
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if in.authorization != "" {
+				req.Header.Set("Authorization", in.authorization)
+			}
+
+			entry, trusted := basicAuth.IsTrusted(req)
+			Expect(trusted).To(Equal(in.expectTrusted))
+			Expect(entry).To(Equal(in.expectedEntry))
+		},
+		Entry("with valid credentials", isTrustedTableInput{
+			// ci:s3cr3t
+			authorization: "Basic Y2k6czNjcjN0",
+			expectedEntry: "basic auth user ci",
+			expectTrusted: true,
+		}),
+		Entry("with an invalid password", isTrustedTableInput{
+			// ci:wrong
+			authorization: "Basic Y2k6d3Jvbmc=",
+			expectTrusted: false,
+		}),
+		Entry("with an unknown user", isTrustedTableInput{
+			// other:s3cr3t
+			authorization: "Basic b3RoZXI6czNjcjN0",
+			expectTrusted: false,
+		}),
+		Entry("with a bearer token", isTrustedTableInput{
+			authorization: "Bearer s3cr3t",
+			expectTrusted: false,
+		}),
+		Entry("without credentials", isTrustedTableInput{
+			expectTrusted: false,
+		}),
+	)
+
+	It("describes the credentials source in the log messages", func() {
+		basicAuth := NewBasicAuth(fakeValidator{}, "/etc/htpasswd")
+		Expect(basicAuth.LogMessages()).To(Equal([]string{"Skipping auth - Basic auth users: /etc/htpasswd"}))
+	})
+})
@@ -0,0 +1,123 @@
+package allowlist
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// IPs trusts requests based on the IP address of the client.
+// When a RealClientIPParser is given, the client IP is taken from the
+// request headers rather than the remote address of the connection.
+// IP ranges may be added and removed while requests are being checked.
+type IPs struct {
+	realClientIPParser ipapi.RealClientIPParser
+
+	mu      sync.RWMutex
+	netSet  *ip.NetSet
+	entries []string
+}
+
+// NewIPs constructs an empty IP allowlist
+func NewIPs(realClientIPParser ipapi.RealClientIPParser) *IPs {
+	return &IPs{
+		realClientIPParser: realClientIPParser,
+		netSet:             ip.NewNetSet(),
+	}
+}
+
+// Add adds an IP address or CIDR range to the allowlist
+func (i *IPs) Add(ipStr string) error {
+	ipNet := ip.ParseIPNet(ipStr)
+	if ipNet == nil {
+		return fmt.Errorf("could not parse IP network (%s)", ipStr)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.netSet.AddIPNet(*ipNet)
+	i.entries = append(i.entries, ipNet.String())
+	return nil
+}
+
+// Remove removes an IP address or CIDR range from the allowlist.
+// It reports whether the range was present.
+func (i *IPs) Remove(ipStr string) (bool, error) {
+	ipNet := ip.ParseIPNet(ipStr)
+	if ipNet == nil {
+		return false, fmt.Errorf("could not parse IP network (%s)", ipStr)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// The NetSet does not support removal, so rebuild it from the remaining
+	// entries
+	netSet := ip.NewNetSet()
+	entries := make([]string, 0, len(i.entries))
+	for _, entry := range i.entries {
+		if entry == ipNet.String() {
+			continue
+		}
+		netSet.AddIPNet(*ip.ParseIPNet(entry))
+		entries = append(entries, entry)
+	}
+	removed := len(entries) != len(i.entries)
+	i.netSet = netSet
+	i.entries = entries
+	return removed, nil
+}
+
+// Entries returns each of the IP ranges in the allowlist in CIDR format
+func (i *IPs) Entries() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	entries := make([]string, len(i.entries))
+	copy(entries, i.entries)
+	return entries
+}
+
+// Has checks whether the IP address is within any of the IP ranges in the
+// allowlist
+func (i *IPs) Has(addr net.IP) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return addr != nil && i.netSet.Has(addr)
+}
+
+// IsTrusted checks whether the client IP of the request is within any of the
+// IP ranges in the allowlist
+func (i *IPs) IsTrusted(req *http.Request) (string, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if len(i.entries) == 0 {
+		return "", false
+	}
+
+	remoteAddr, err := ip.GetClientIP(i.realClientIPParser, req)
+	if err != nil {
+		logger.Errorf("Error obtaining real IP for trusted IP list: %v", err)
+		// Possibly spoofed X-Real-IP header
+		return "", false
+	}
+
+	if remoteAddr == nil || !i.netSet.Has(remoteAddr) {
+		return "", false
+	}
+	return fmt.Sprintf("trusted IP %s", remoteAddr), true
+}
+
+// LogMessages describes each of the IP ranges in the allowlist
+func (i *IPs) LogMessages() []string {
+	entries := i.Entries()
+	msgs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		msgs = append(msgs, fmt.Sprintf("Skipping auth - Trusted IP: %s", entry))
+	}
+	return msgs
+}
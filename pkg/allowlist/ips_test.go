@@ -0,0 +1,93 @@
+package allowlist
+
+import (
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPs Suite", func() {
+	It("trusts requests from addresses within the configured ranges", func() {
+		ips := NewIPs(nil)
+		Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+		Expect(ips.Add("::1")).To(Succeed())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.1.2.3:43670"
+		entry, trusted := ips.IsTrusted(req)
+		Expect(trusted).To(BeTrue())
+		Expect(entry).To(Equal("trusted IP 10.1.2.3"))
+
+		req.RemoteAddr = "192.168.1.1:43670"
+		_, trusted = ips.IsTrusted(req)
+		Expect(trusted).To(BeFalse())
+	})
+
+	It("uses the real client IP parser when given", func() {
+		parser, err := ip.GetRealClientIPParser("X-Real-IP")
+		Expect(err).ToNot(HaveOccurred())
+
+		ips := NewIPs(parser)
+		Expect(ips.Add("127.0.0.1")).To(Succeed())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "127.0.0.1:43670"
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		_, trusted := ips.IsTrusted(req)
+		Expect(trusted).To(BeFalse())
+
+		req.Header.Set("X-Real-IP", "127.0.0.1")
+		_, trusted = ips.IsTrusted(req)
+		Expect(trusted).To(BeTrue())
+	})
+
+	It("does not trust requests with an unparseable client IP", func() {
+		ips := NewIPs(nil)
+		Expect(ips.Add("127.0.0.1")).To(Succeed())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "adsfljk29242as!!"
+		_, trusted := ips.IsTrusted(req)
+		Expect(trusted).To(BeFalse())
+	})
+
+	It("removes IP ranges at runtime", func() {
+		ips := NewIPs(nil)
+		Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+		Expect(ips.Add("127.0.0.1")).To(Succeed())
+
+		removed, err := ips.Remove("10.0.0.0/8")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeTrue())
+		Expect(ips.Entries()).To(Equal([]string{"127.0.0.1/32"}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.1.2.3:43670"
+		_, trusted := ips.IsTrusted(req)
+		Expect(trusted).To(BeFalse())
+
+		req.RemoteAddr = "127.0.0.1:43670"
+		_, trusted = ips.IsTrusted(req)
+		Expect(trusted).To(BeTrue())
+
+		removed, err = ips.Remove("10.0.0.0/8")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeFalse())
+	})
+
+	It("returns an error for an invalid IP", func() {
+		Expect(NewIPs(nil).Add("not-an-ip")).To(MatchError("could not parse IP network (not-an-ip)"))
+	})
+
+	It("describes each IP range in the log messages", func() {
+		ips := NewIPs(nil)
+		Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+		Expect(ips.Add("127.0.0.1")).To(Succeed())
+		Expect(ips.LogMessages()).To(Equal([]string{
+			"Skipping auth - Trusted IP: 10.0.0.0/8",
+			"Skipping auth - Trusted IP: 127.0.0.1/32",
+		}))
+	})
+})
@@ -0,0 +1,26 @@
+package allowlist
+
+import (
+	"net/http"
+)
+
+// Preflight trusts all CORS preflight (OPTIONS) requests.
+type Preflight struct{}
+
+// NewPreflight constructs an allowlist that trusts OPTIONS requests
+func NewPreflight() *Preflight {
+	return &Preflight{}
+}
+
+// IsTrusted returns true for all OPTIONS requests
+func (p *Preflight) IsTrusted(req *http.Request) (string, bool) {
+	if req.Method == http.MethodOptions {
+		return "preflight", true
+	}
+	return "", false
+}
+
+// LogMessages describes the preflight allowlist
+func (p *Preflight) LogMessages() []string {
+	return []string{"Skipping auth - Method: OPTIONS | Path: ALL"}
+}
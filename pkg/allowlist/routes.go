@@ -0,0 +1,223 @@
+package allowlist
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
+)
+
+// route is a method and path regex pair.
+// An empty method matches all methods.
+type route struct {
+	method    string
+	pathRegex *regexp.Regexp
+
+	// description optionally explains why the route is trusted
+	description string
+}
+
+// String describes the route in the same method=path format it is configured
+// with
+func (r route) String() string {
+	if r.method == "" {
+		if strings.Contains(r.pathRegex.String(), "=") {
+			// A leading = is needed to parse the route back to all methods
+			return fmt.Sprintf("=%s", r.pathRegex.String())
+		}
+		return r.pathRegex.String()
+	}
+	return fmt.Sprintf("%s=%s", r.method, r.pathRegex.String())
+}
+
+// entry describes the route in the format it is configured with, including
+// its description
+func (r route) entry() string {
+	if r.description == "" {
+		return r.String()
+	}
+	return fmt.Sprintf("%s # %s", r, r.description)
+}
+
+// Routes trusts requests based on their method and path.
+// Routes may be added and removed while requests are being checked.
+type Routes struct {
+	mu     sync.RWMutex
+	routes []route
+}
+
+// NewRoutes constructs an empty route allowlist
+func NewRoutes() *Routes {
+	return &Routes{}
+}
+
+// MethodPolicy determines which methods a global path regex is trusted for
+type MethodPolicy int
+
+const (
+	// AllMethods trusts a path regex for all methods
+	AllMethods MethodPolicy = iota
+
+	// SafeMethods only trusts a path regex for GET, HEAD and OPTIONS
+	// requests
+	SafeMethods
+)
+
+var safeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// AddGlobalRegex adds a path regex that is trusted for the methods allowed
+// by the method policy.
+// This is used for the legacy SkipAuthRegex option.
+func (r *Routes) AddGlobalRegex(regex string, policy MethodPolicy) error {
+	compiledRegex, err := regexp.Compile(regex)
+	if err != nil {
+		return err
+	}
+
+	if policy == SafeMethods {
+		for _, method := range safeMethods {
+			r.add(route{
+				method:    method,
+				pathRegex: compiledRegex,
+			})
+		}
+		return nil
+	}
+
+	r.add(route{
+		method:    "",
+		pathRegex: compiledRegex,
+	})
+	return nil
+}
+
+// AddRoute adds a route in the format method=path_regex, optionally followed
+// by " # description".
+// If no method is given, the path regex is trusted for all methods.
+func (r *Routes) AddRoute(methodPath string) error {
+	rt, err := parseRoute(methodPath)
+	if err != nil {
+		return err
+	}
+	r.add(rt)
+	return nil
+}
+
+// RemoveRoute removes all routes matching the method=path_regex given,
+// regardless of their description.
+// It reports whether any routes were removed.
+func (r *Routes) RemoveRoute(methodPath string) (bool, error) {
+	rt, err := parseRoute(methodPath)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Copy rather than filter in place so that readers holding the previous
+	// slice are unaffected
+	routes := make([]route, 0, len(r.routes))
+	for _, existing := range r.routes {
+		if existing.String() != rt.String() {
+			routes = append(routes, existing)
+		}
+	}
+	removed := len(routes) != len(r.routes)
+	r.routes = routes
+	return removed, nil
+}
+
+// Entries returns each of the routes in the allowlist in method=path_regex
+// format, followed by their descriptions
+func (r *Routes) Entries() []string {
+	routes := r.snapshot()
+	entries := make([]string, 0, len(routes))
+	for _, route := range routes {
+		entries = append(entries, route.entry())
+	}
+	return entries
+}
+
+func (r *Routes) add(rt route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, rt)
+}
+
+func (r *Routes) snapshot() []route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.routes
+}
+
+// NormalizeRoute returns the route in the method=path_regex format it is
+// listed in by Entries
+func NormalizeRoute(methodPath string) (string, error) {
+	rt, err := parseRoute(methodPath)
+	if err != nil {
+		return "", err
+	}
+	return rt.entry(), nil
+}
+
+// parseRoute parses a route in the format method=path_regex, optionally
+// followed by " # description"
+func parseRoute(entry string) (route, error) {
+	var (
+		method string
+		path   string
+	)
+
+	methodPath, description := util.SplitDescription(entry)
+	parts := strings.SplitN(methodPath, "=", 2)
+	if len(parts) == 1 {
+		method = ""
+		path = parts[0]
+	} else {
+		method = strings.ToUpper(parts[0])
+		path = parts[1]
+	}
+
+	compiledRegex, err := regexp.Compile(path)
+	if err != nil {
+		return route{}, err
+	}
+	return route{
+		method:      method,
+		pathRegex:   compiledRegex,
+		description: description,
+	}, nil
+}
+
+// IsTrusted checks whether the request method and path match any of the
+// routes in the allowlist
+func (r *Routes) IsTrusted(req *http.Request) (string, bool) {
+	for _, route := range r.snapshot() {
+		if (route.method == "" || req.Method == route.method) && route.pathRegex.MatchString(req.URL.Path) {
+			return fmt.Sprintf("route %s", route.entry()), true
+		}
+	}
+	return "", false
+}
+
+// LogMessages describes each of the routes in the allowlist
+func (r *Routes) LogMessages() []string {
+	routes := r.snapshot()
+	msgs := make([]string, 0, len(routes))
+	for _, route := range routes {
+		method := route.method
+		if method == "" {
+			method = "ALL"
+		}
+		msg := fmt.Sprintf("Skipping auth - Method: %s | Path: %s", method, route.pathRegex.String())
+		if route.description != "" {
+			msg = fmt.Sprintf("%s | Description: %s", msg, route.description)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
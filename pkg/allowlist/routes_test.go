@@ -0,0 +1,156 @@
+package allowlist
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routes Suite", func() {
+	var routes *Routes
+
+	BeforeEach(func() {
+		routes = NewRoutes()
+		Expect(routes.AddGlobalRegex("^/skip/auth/regex$", AllMethods)).To(Succeed())
+		Expect(routes.AddRoute("GET=^/skip/auth/routes/get")).To(Succeed())
+		Expect(routes.AddRoute("^/all/methods$")).To(Succeed())
+	})
+
+	type isTrustedTableInput struct {
+		method        string
+		path          string
+		expectedEntry string
+		expectTrusted bool
+	}
+
+	DescribeTable("IsTrusted",
+		func(in isTrustedTableInput) {
+			req := httptest.NewRequest(in.method, in.path, nil)
+			entry, trusted := routes.IsTrusted(req)
+			Expect(trusted).To(Equal(in.expectTrusted))
+			Expect(entry).To(Equal(in.expectedEntry))
+		},
+		Entry("with a global regex and any method", isTrustedTableInput{
+			method:        "POST",
+			path:          "/skip/auth/regex",
+			expectedEntry: "route ^/skip/auth/regex$",
+			expectTrusted: true,
+		}),
+		Entry("with a matching method and path", isTrustedTableInput{
+			method:        "GET",
+			path:          "/skip/auth/routes/get",
+			expectedEntry: "route GET=^/skip/auth/routes/get",
+			expectTrusted: true,
+		}),
+		Entry("with a route without a method", isTrustedTableInput{
+			method:        "DELETE",
+			path:          "/all/methods",
+			expectedEntry: "route ^/all/methods$",
+			expectTrusted: true,
+		}),
+		Entry("with the wrong method", isTrustedTableInput{
+			method:        "PATCH",
+			path:          "/skip/auth/routes/get",
+			expectTrusted: false,
+		}),
+		Entry("with the wrong path", isTrustedTableInput{
+			method:        "GET",
+			path:          "/wrong/path",
+			expectTrusted: false,
+		}),
+	)
+
+	It("describes each route in the log messages", func() {
+		Expect(routes.LogMessages()).To(Equal([]string{
+			"Skipping auth - Method: ALL | Path: ^/skip/auth/regex$",
+			"Skipping auth - Method: GET | Path: ^/skip/auth/routes/get",
+			"Skipping auth - Method: ALL | Path: ^/all/methods$",
+		}))
+	})
+
+	It("lists each route in method=path format", func() {
+		Expect(routes.AddGlobalRegex("^/with=equals$", AllMethods)).To(Succeed())
+		Expect(routes.Entries()).To(Equal([]string{
+			"^/skip/auth/regex$",
+			"GET=^/skip/auth/routes/get",
+			"^/all/methods$",
+			"=^/with=equals$",
+		}))
+	})
+
+	It("removes routes at runtime", func() {
+		removed, err := routes.RemoveRoute("get=^/skip/auth/routes/get")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeTrue())
+
+		_, trusted := routes.IsTrusted(httptest.NewRequest("GET", "/skip/auth/routes/get", nil))
+		Expect(trusted).To(BeFalse())
+		Expect(routes.Entries()).To(Equal([]string{"^/skip/auth/regex$", "^/all/methods$"}))
+
+		removed, err = routes.RemoveRoute("GET=^/skip/auth/routes/get")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeFalse())
+	})
+
+	Context("with descriptions", func() {
+		BeforeEach(func() {
+			routes = NewRoutes()
+			Expect(routes.AddRoute("POST=^/webhooks/stripe$ # exempt Stripe webhook")).To(Succeed())
+		})
+
+		It("includes the description in the trusted entry", func() {
+			entry, trusted := routes.IsTrusted(httptest.NewRequest("POST", "/webhooks/stripe", nil))
+			Expect(trusted).To(BeTrue())
+			Expect(entry).To(Equal("route POST=^/webhooks/stripe$ # exempt Stripe webhook"))
+		})
+
+		It("describes the route in the log messages and entries", func() {
+			Expect(routes.LogMessages()).To(Equal([]string{
+				"Skipping auth - Method: POST | Path: ^/webhooks/stripe$ | Description: exempt Stripe webhook",
+			}))
+			Expect(routes.Entries()).To(Equal([]string{"POST=^/webhooks/stripe$ # exempt Stripe webhook"}))
+		})
+
+		It("removes routes regardless of their description", func() {
+			removed, err := routes.RemoveRoute("POST=^/webhooks/stripe$")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(BeTrue())
+			Expect(routes.Entries()).To(BeEmpty())
+		})
+	})
+
+	Context("with the safe methods policy", func() {
+		BeforeEach(func() {
+			routes = NewRoutes()
+			Expect(routes.AddGlobalRegex("^/safe$", SafeMethods)).To(Succeed())
+		})
+
+		DescribeTable("IsTrusted",
+			func(method string, expectTrusted bool) {
+				_, trusted := routes.IsTrusted(httptest.NewRequest(method, "/safe", nil))
+				Expect(trusted).To(Equal(expectTrusted))
+			},
+			Entry("with GET", "GET", true),
+			Entry("with HEAD", "HEAD", true),
+			Entry("with OPTIONS", "OPTIONS", true),
+			Entry("with POST", "POST", false),
+			Entry("with PUT", "PUT", false),
+			Entry("with DELETE", "DELETE", false),
+		)
+
+		It("lists a route for each safe method", func() {
+			Expect(routes.Entries()).To(Equal([]string{
+				"GET=^/safe$",
+				"HEAD=^/safe$",
+				"OPTIONS=^/safe$",
+			}))
+		})
+	})
+
+	It("returns an error for an invalid regex", func() {
+		Expect(routes.AddRoute("PUT=(bad[regex")).ToNot(Succeed())
+		Expect(routes.AddGlobalRegex("(bad[regex", AllMethods)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,75 @@
+package allowlist
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// UserAgents trusts requests based on their User-Agent header.
+// This is intended for monitoring agents and health checkers which cannot
+// complete an OAuth flow.
+// As the User-Agent is set by the client, an IP allowlist may be given which
+// the client must also be trusted by.
+type UserAgents struct {
+	regexes []*regexp.Regexp
+	ips     *IPs
+}
+
+// NewUserAgents constructs an empty User-Agent allowlist.
+// If ips is not nil, requests must also come from a trusted IP.
+func NewUserAgents(ips *IPs) *UserAgents {
+	return &UserAgents{
+		ips: ips,
+	}
+}
+
+// Add adds a User-Agent regex to the allowlist
+func (u *UserAgents) Add(regex string) error {
+	compiledRegex, err := regexp.Compile(regex)
+	if err != nil {
+		return err
+	}
+	u.regexes = append(u.regexes, compiledRegex)
+	return nil
+}
+
+// IsTrusted checks whether the User-Agent of the request matches any of the
+// regexes in the allowlist, and if required, that the client IP is trusted
+func (u *UserAgents) IsTrusted(req *http.Request) (string, bool) {
+	userAgent := req.UserAgent()
+	if userAgent == "" {
+		return "", false
+	}
+
+	for _, regex := range u.regexes {
+		if !regex.MatchString(userAgent) {
+			continue
+		}
+
+		if u.ips == nil {
+			return fmt.Sprintf("user agent %s", regex), true
+		}
+		ipEntry, ok := u.ips.IsTrusted(req)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("user agent %s from %s", regex, ipEntry), true
+	}
+	return "", false
+}
+
+// LogMessages describes each of the User-Agent regexes in the allowlist
+func (u *UserAgents) LogMessages() []string {
+	var ipsMsg string
+	if u.ips != nil {
+		ipsMsg = fmt.Sprintf(" | IPs: %s", strings.Join(u.ips.Entries(), ", "))
+	}
+
+	msgs := make([]string, 0, len(u.regexes))
+	for _, regex := range u.regexes {
+		msgs = append(msgs, fmt.Sprintf("Skipping auth - User-Agent: %s%s", regex, ipsMsg))
+	}
+	return msgs
+}
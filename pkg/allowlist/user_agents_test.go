@@ -0,0 +1,83 @@
+package allowlist
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UserAgents Suite", func() {
+	type isTrustedTableInput struct {
+		trustedIPs    []string
+		userAgent     string
+		remoteAddr    string
+		expectedEntry string
+		expectTrusted bool
+	}
+
+	DescribeTable("IsTrusted",
+		func(in isTrustedTableInput) {
+			var ips *IPs
+			if in.trustedIPs != nil {
+				ips = NewIPs(nil)
+				for _, ipStr := range in.trustedIPs {
+					Expect(ips.Add(ipStr)).To(Succeed())
+				}
+			}
+
+			userAgents := NewUserAgents(ips)
+			Expect(userAgents.Add("^kube-probe/.*")).To(Succeed())
+			Expect(userAgents.Add("^Pingdom.*")).To(Succeed())
+
+			req := httptest.NewRequest("GET", "/healthz", nil)
+			req.Header.Set("User-Agent", in.userAgent)
+			req.RemoteAddr = in.remoteAddr
+
+			entry, trusted := userAgents.IsTrusted(req)
+			Expect(trusted).To(Equal(in.expectTrusted))
+			Expect(entry).To(Equal(in.expectedEntry))
+		},
+		Entry("with a matching User-Agent", isTrustedTableInput{
+			userAgent:     "kube-probe/1.19",
+			remoteAddr:    "192.168.1.1:43670",
+			expectedEntry: "user agent ^kube-probe/.*",
+			expectTrusted: true,
+		}),
+		Entry("with a non-matching User-Agent", isTrustedTableInput{
+			userAgent:     "Mozilla/5.0",
+			remoteAddr:    "192.168.1.1:43670",
+			expectTrusted: false,
+		}),
+		Entry("with no User-Agent", isTrustedTableInput{
+			userAgent:     "",
+			remoteAddr:    "192.168.1.1:43670",
+			expectTrusted: false,
+		}),
+		Entry("with a matching User-Agent from a trusted IP", isTrustedTableInput{
+			trustedIPs:    []string{"10.0.0.0/8"},
+			userAgent:     "Pingdom.com_bot_version_1.4",
+			remoteAddr:    "10.1.2.3:43670",
+			expectedEntry: "user agent ^Pingdom.* from trusted IP 10.1.2.3",
+			expectTrusted: true,
+		}),
+		Entry("with a matching User-Agent from an untrusted IP", isTrustedTableInput{
+			trustedIPs:    []string{"10.0.0.0/8"},
+			userAgent:     "kube-probe/1.19",
+			remoteAddr:    "192.168.1.1:43670",
+			expectTrusted: false,
+		}),
+	)
+
+	It("describes each User-Agent regex in the log messages", func() {
+		ips := NewIPs(nil)
+		Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+
+		userAgents := NewUserAgents(ips)
+		Expect(userAgents.Add("^kube-probe/.*")).To(Succeed())
+		Expect(userAgents.LogMessages()).To(Equal([]string{
+			"Skipping auth - User-Agent: ^kube-probe/.* | IPs: 10.0.0.0/8",
+		}))
+	})
+})
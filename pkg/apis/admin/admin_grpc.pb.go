@@ -0,0 +1,345 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: admin.proto
+
+package admin
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	// ListAllowlist lists the entries of an allowlist
+	ListAllowlist(ctx context.Context, in *ListAllowlistRequest, opts ...grpc.CallOption) (*AllowlistEntries, error)
+	// AddAllowlistEntry adds an entry to an allowlist, and returns the entries
+	// of the allowlist
+	AddAllowlistEntry(ctx context.Context, in *AllowlistEntryRequest, opts ...grpc.CallOption) (*AllowlistEntries, error)
+	// RemoveAllowlistEntry removes an entry from an allowlist, and returns the
+	// entries of the allowlist
+	RemoveAllowlistEntry(ctx context.Context, in *AllowlistEntryRequest, opts ...grpc.CallOption) (*AllowlistEntries, error)
+	// ListRules lists the rules of a deny rule set in the order they are
+	// checked
+	ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*Rules, error)
+	// AddRule adds a deny rule to a deny rule set, and returns its rules
+	AddRule(ctx context.Context, in *AddRuleRequest, opts ...grpc.CallOption) (*Rules, error)
+	// RemoveRule removes a deny rule from a deny rule set, and returns its
+	// rules
+	RemoveRule(ctx context.Context, in *RemoveRuleRequest, opts ...grpc.CallOption) (*Rules, error)
+	// CheckRequest decides how the proxy would handle a request with its
+	// current deny rules and allowlists, without making the request
+	CheckRequest(ctx context.Context, in *CheckRequestRequest, opts ...grpc.CallOption) (*RulesCheck, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListAllowlist(ctx context.Context, in *ListAllowlistRequest, opts ...grpc.CallOption) (*AllowlistEntries, error) {
+	out := new(AllowlistEntries)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/ListAllowlist", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddAllowlistEntry(ctx context.Context, in *AllowlistEntryRequest, opts ...grpc.CallOption) (*AllowlistEntries, error) {
+	out := new(AllowlistEntries)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/AddAllowlistEntry", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveAllowlistEntry(ctx context.Context, in *AllowlistEntryRequest, opts ...grpc.CallOption) (*AllowlistEntries, error) {
+	out := new(AllowlistEntries)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/RemoveAllowlistEntry", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/ListRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddRule(ctx context.Context, in *AddRuleRequest, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/AddRule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveRule(ctx context.Context, in *RemoveRuleRequest, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/RemoveRule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CheckRequest(ctx context.Context, in *CheckRequestRequest, opts ...grpc.CallOption) (*RulesCheck, error) {
+	out := new(RulesCheck)
+	err := c.cc.Invoke(ctx, "/oauth2_proxy.admin.Admin/CheckRequest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	// ListAllowlist lists the entries of an allowlist
+	ListAllowlist(context.Context, *ListAllowlistRequest) (*AllowlistEntries, error)
+	// AddAllowlistEntry adds an entry to an allowlist, and returns the entries
+	// of the allowlist
+	AddAllowlistEntry(context.Context, *AllowlistEntryRequest) (*AllowlistEntries, error)
+	// RemoveAllowlistEntry removes an entry from an allowlist, and returns the
+	// entries of the allowlist
+	RemoveAllowlistEntry(context.Context, *AllowlistEntryRequest) (*AllowlistEntries, error)
+	// ListRules lists the rules of a deny rule set in the order they are
+	// checked
+	ListRules(context.Context, *ListRulesRequest) (*Rules, error)
+	// AddRule adds a deny rule to a deny rule set, and returns its rules
+	AddRule(context.Context, *AddRuleRequest) (*Rules, error)
+	// RemoveRule removes a deny rule from a deny rule set, and returns its
+	// rules
+	RemoveRule(context.Context, *RemoveRuleRequest) (*Rules, error)
+	// CheckRequest decides how the proxy would handle a request with its
+	// current deny rules and allowlists, without making the request
+	CheckRequest(context.Context, *CheckRequestRequest) (*RulesCheck, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListAllowlist(context.Context, *ListAllowlistRequest) (*AllowlistEntries, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAllowlist not implemented")
+}
+func (UnimplementedAdminServer) AddAllowlistEntry(context.Context, *AllowlistEntryRequest) (*AllowlistEntries, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddAllowlistEntry not implemented")
+}
+func (UnimplementedAdminServer) RemoveAllowlistEntry(context.Context, *AllowlistEntryRequest) (*AllowlistEntries, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveAllowlistEntry not implemented")
+}
+func (UnimplementedAdminServer) ListRules(context.Context, *ListRulesRequest) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRules not implemented")
+}
+func (UnimplementedAdminServer) AddRule(context.Context, *AddRuleRequest) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddRule not implemented")
+}
+func (UnimplementedAdminServer) RemoveRule(context.Context, *RemoveRuleRequest) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveRule not implemented")
+}
+func (UnimplementedAdminServer) CheckRequest(context.Context, *CheckRequestRequest) (*RulesCheck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckRequest not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListAllowlist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAllowlistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListAllowlist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/ListAllowlist",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListAllowlist(ctx, req.(*ListAllowlistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddAllowlistEntry_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllowlistEntryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddAllowlistEntry(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/AddAllowlistEntry",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddAllowlistEntry(ctx, req.(*AllowlistEntryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveAllowlistEntry_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllowlistEntryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveAllowlistEntry(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/RemoveAllowlistEntry",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveAllowlistEntry(ctx, req.(*AllowlistEntryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/ListRules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListRules(ctx, req.(*ListRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRuleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/AddRule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddRule(ctx, req.(*AddRuleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRuleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/RemoveRule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveRule(ctx, req.(*RemoveRuleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_CheckRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).CheckRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/oauth2_proxy.admin.Admin/CheckRequest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).CheckRequest(ctx, req.(*CheckRequestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "oauth2_proxy.admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListAllowlist",
+			Handler:    _Admin_ListAllowlist_Handler,
+		},
+		{
+			MethodName: "AddAllowlistEntry",
+			Handler:    _Admin_AddAllowlistEntry_Handler,
+		},
+		{
+			MethodName: "RemoveAllowlistEntry",
+			Handler:    _Admin_RemoveAllowlistEntry_Handler,
+		},
+		{
+			MethodName: "ListRules",
+			Handler:    _Admin_ListRules_Handler,
+		},
+		{
+			MethodName: "AddRule",
+			Handler:    _Admin_AddRule_Handler,
+		},
+		{
+			MethodName: "RemoveRule",
+			Handler:    _Admin_RemoveRule_Handler,
+		},
+		{
+			MethodName: "CheckRequest",
+			Handler:    _Admin_CheckRequest_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
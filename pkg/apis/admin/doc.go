@@ -0,0 +1,5 @@
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative admin.proto
+
+// Package admin defines the gRPC admin API, which manages the allowlists and
+// deny rules of a running proxy.
+package admin
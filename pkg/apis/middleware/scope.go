@@ -24,6 +24,10 @@ type RequestScope struct {
 	// Session details the authenticated users information (if it exists).
 	Session *sessions.SessionState
 
+	// StoredSession indicates whether the session was loaded from the session
+	// store, rather than from credentials presented with the request.
+	StoredSession bool
+
 	// SaveSession indicates whether the session storage should attempt to save
 	// the session or not.
 	SaveSession bool
@@ -34,6 +38,11 @@ type RequestScope struct {
 	// SessionRevalidated indicates whether the session has been revalidated since
 	// it was loaded or not.
 	SessionRevalidated bool
+
+	// RefreshInProgress indicates that the session could not be loaded because
+	// it has expired and is being refreshed by a concurrent request.
+	// The client should retry the request once the refresh completes.
+	RefreshInProgress bool
 }
 
 // GetRequestScope returns the current request scope from the given request
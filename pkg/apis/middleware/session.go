@@ -18,6 +18,14 @@ type VerifyFunc func(ctx context.Context, token string) (*oidc.IDToken, error)
 // CreateTokenToSessionFunc provides a handler that is a default implementation
 // for converting a JWT into a session.
 func CreateTokenToSessionFunc(verify VerifyFunc) TokenToSessionFunc {
+	return CreateTokenToSessionFuncWithGroups(verify, "")
+}
+
+// CreateTokenToSessionFuncWithGroups converts a JWT into a session in the same
+// way as CreateTokenToSessionFunc, additionally loading the session groups
+// from the named claim (if given).
+// The groups claim may be either a single string or a list of strings.
+func CreateTokenToSessionFuncWithGroups(verify VerifyFunc, groupsClaim string) TokenToSessionFunc {
 	return func(ctx context.Context, token string) (*sessionsapi.SessionState, error) {
 		var claims struct {
 			Subject           string `json:"sub"`
@@ -53,6 +61,41 @@ func CreateTokenToSessionFunc(verify VerifyFunc) TokenToSessionFunc {
 			ExpiresOn:         &idToken.Expiry,
 		}
 
+		if groupsClaim != "" {
+			groups, err := getGroupsClaim(idToken, groupsClaim)
+			if err != nil {
+				return nil, err
+			}
+			newSession.Groups = groups
+		}
+
 		return newSession, nil
 	}
 }
+
+// getGroupsClaim extracts the groups from the named claim of the token
+func getGroupsClaim(idToken *oidc.IDToken, groupsClaim string) ([]string, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse bearer token claims: %v", err)
+	}
+
+	switch value := claims[groupsClaim].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		groups := make([]string, 0, len(value))
+		for _, group := range value {
+			groupStr, ok := group.(string)
+			if !ok {
+				return nil, fmt.Errorf("bearer token claim %q contains a non-string group: %v", groupsClaim, group)
+			}
+			groups = append(groups, groupStr)
+		}
+		return groups, nil
+	default:
+		return nil, fmt.Errorf("bearer token claim %q must be a string or list of strings", groupsClaim)
+	}
+}
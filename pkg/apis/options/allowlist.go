@@ -0,0 +1,14 @@
+package options
+
+// TrustedRequest is an allowlist entry that allows requests to skip
+// authentication only when all of its conditions match.
+type TrustedRequest struct {
+	// Routes is a list of routes in the format method=path_regex, as used by
+	// skip_auth_routes.
+	// The request must match at least one of the routes.
+	Routes []string `json:"routes,omitempty"`
+
+	// IPs is a list of IPs or CIDR ranges, as used by trusted_ips.
+	// The request must come from at least one of the IPs.
+	IPs []string `json:"ips,omitempty"`
+}
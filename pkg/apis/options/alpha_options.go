@@ -28,6 +28,11 @@ type AlphaOptions struct {
 	// Headers may source values from either the authenticated user's session
 	// or from a static secret value.
 	InjectResponseHeaders []Header `json:"injectResponseHeaders,omitempty"`
+
+	// TrustedRequests is used to configure requests that may skip
+	// authentication when they match all of the conditions of an entry,
+	// for example a route that may only skip authentication from an IP range.
+	TrustedRequests []TrustedRequest `json:"trustedRequests,omitempty"`
 }
 
 // MergeInto replaces alpha options in the Options struct with the values
@@ -36,6 +41,7 @@ func (a *AlphaOptions) MergeInto(opts *Options) {
 	opts.UpstreamServers = a.Upstreams
 	opts.InjectRequestHeaders = a.InjectRequestHeaders
 	opts.InjectResponseHeaders = a.InjectResponseHeaders
+	opts.TrustedRequests = a.TrustedRequests
 }
 
 // ExtractFrom populates the fields in the AlphaOptions with the values from
@@ -44,4 +50,5 @@ func (a *AlphaOptions) ExtractFrom(opts *Options) {
 	a.Upstreams = opts.UpstreamServers
 	a.InjectRequestHeaders = opts.InjectRequestHeaders
 	a.InjectResponseHeaders = opts.InjectResponseHeaders
+	a.TrustedRequests = opts.TrustedRequests
 }
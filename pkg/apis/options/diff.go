@@ -0,0 +1,226 @@
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// redactedValue replaces the values of secrets in config snapshots
+const redactedValue = "<redacted>"
+
+// secretOptionName matches the config names of options that hold secrets
+var secretOptionName = regexp.MustCompile(`(secret|password|token|_key)$`)
+
+// ConfigSnapshot is the effective configuration keyed by config option name,
+// with secrets redacted.
+// Alpha options are keyed by their name in the alpha configuration.
+type ConfigSnapshot map[string]interface{}
+
+// ConfigChange is an option with a different value in two configurations
+type ConfigChange struct {
+	Option string      `json:"option"`
+	From   interface{} `json:"from"`
+	To     interface{} `json:"to"`
+}
+
+// String describes the change as "option: from -> to"
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Option, formatConfigValue(c.From), formatConfigValue(c.To))
+}
+
+// ConfigReport describes how the effective configuration differs from the
+// defaults and from the previous load
+type ConfigReport struct {
+	Defaults []ConfigChange `json:"defaults"`
+
+	// Previous is nil if there is no previous load to compare with
+	Previous []ConfigChange `json:"previous"`
+}
+
+// NewConfigReport compares the current configuration with the defaults and
+// with the previous configuration, if any
+func NewConfigReport(defaults, current, previous ConfigSnapshot) *ConfigReport {
+	report := &ConfigReport{
+		Defaults: DiffConfig(defaults, current),
+	}
+	if previous != nil {
+		report.Previous = DiffConfig(previous, current)
+	}
+	return report
+}
+
+// NewConfigSnapshot captures the options with their secrets redacted.
+// Values are normalized to their JSON types so that snapshots can be
+// compared with those written to a file by a previous load.
+func NewConfigSnapshot(o *Options) (ConfigSnapshot, error) {
+	values := map[string]interface{}{}
+	addConfigValues(values, reflect.ValueOf(o).Elem())
+
+	alpha := &AlphaOptions{}
+	alpha.ExtractFrom(o)
+	alphaValues, err := toJSONValue(alpha)
+	if err != nil {
+		return nil, fmt.Errorf("error capturing alpha options: %v", err)
+	}
+	for name, value := range alphaValues.(map[string]interface{}) {
+		values[name] = redactSecretSources(value)
+	}
+
+	snapshot, err := toJSONValue(values)
+	if err != nil {
+		return nil, fmt.Errorf("error capturing options: %v", err)
+	}
+	return ConfigSnapshot(snapshot.(map[string]interface{})), nil
+}
+
+// LoadConfigSnapshot reads a snapshot written by WriteFile.
+// It returns nil if the file does not exist.
+func LoadConfigSnapshot(path string) (ConfigSnapshot, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config snapshot: %v", err)
+	}
+	snapshot := ConfigSnapshot{}
+	if err := json.Unmarshal(contents, &snapshot); err != nil {
+		return nil, fmt.Errorf("could not parse config snapshot %s: %v", path, err)
+	}
+	return snapshot, nil
+}
+
+// WriteFile writes the snapshot as JSON, so that it can be compared with the
+// next load
+func (s ConfigSnapshot) WriteFile(path string) error {
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding config snapshot: %v", err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		return fmt.Errorf("could not write config snapshot: %v", err)
+	}
+	return nil
+}
+
+// DiffConfig lists the options with different values in the snapshots,
+// sorted by option name
+func DiffConfig(from, to ConfigSnapshot) []ConfigChange {
+	names := map[string]struct{}{}
+	for name := range from {
+		names[name] = struct{}{}
+	}
+	for name := range to {
+		names[name] = struct{}{}
+	}
+
+	changes := []ConfigChange{}
+	for name := range names {
+		if !reflect.DeepEqual(from[name], to[name]) {
+			changes = append(changes, ConfigChange{Option: name, From: from[name], To: to[name]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Option < changes[j].Option
+	})
+	return changes
+}
+
+// addConfigValues adds the fields of the options struct by their cfg tag,
+// descending into squashed structs
+func addConfigValues(values map[string]interface{}, val reflect.Value) {
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := field.Tag.Get("cfg")
+		switch {
+		case field.PkgPath != "" || name == "" || name == ",internal":
+			// Unexported and internal fields are not set by config options
+			continue
+		case name == ",squash":
+			addConfigValues(values, val.Field(i))
+		default:
+			values[name] = configValue(name, val.Field(i).Interface())
+		}
+	}
+}
+
+// configValue redacts secrets and the passwords of URLs, and formats
+// durations as strings
+func configValue(name string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case string:
+		if v != "" && secretOptionName.MatchString(name) {
+			return redactedValue
+		}
+		return redactURLPassword(v)
+	case []string:
+		redacted := make([]string, 0, len(v))
+		for _, s := range v {
+			redacted = append(redacted, redactURLPassword(s))
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+func redactURLPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
+
+// redactSecretSources redacts the value of each SecretSource in the alpha
+// options, which are the only fields encoded as "value"
+func redactSecretSources(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if key == "value" {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactSecretSources(nested)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactSecretSources(nested)
+		}
+	}
+	return value
+}
+
+func toJSONValue(in interface{}) (interface{}, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func formatConfigValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
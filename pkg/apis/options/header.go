@@ -33,6 +33,18 @@ type ClaimSource struct {
 	// loaded from.
 	Claim string `json:"claim,omitempty"`
 
+	// Part selects a part of the claim value.
+	// Either "localPart" or "domain" of an email address, or the whole value
+	// when empty.
+	Part string `json:"part,omitempty"`
+
+	// Matches is an optional regex that the value must match to be used.
+	Matches string `json:"matches,omitempty"`
+
+	// Fallbacks are claims the value is loaded from, in order, when the claim
+	// and the previous fallbacks have no value.
+	Fallbacks []ClaimFallback `json:"fallbacks,omitempty"`
+
 	// Prefix is an optional prefix that will be prepended to the value of the
 	// claim if it is non-empty.
 	Prefix string `json:"prefix,omitempty"`
@@ -42,3 +54,27 @@ type ClaimSource struct {
 	// basicAuthPassword will be used as the password value.
 	BasicAuthPassword *SecretSource `json:"basicAuthPassword,omitempty"`
 }
+
+// ClaimFallback is a claim a header value is loaded from when the preceding
+// claims of a ClaimSource have no value
+type ClaimFallback struct {
+	// Claim is the name of the claim in the session that the value should be
+	// loaded from.
+	Claim string `json:"claim,omitempty"`
+
+	// Part selects a part of the claim value.
+	// Either "localPart" or "domain" of an email address, or the whole value
+	// when empty.
+	Part string `json:"part,omitempty"`
+
+	// Matches is an optional regex that the value must match to be used.
+	Matches string `json:"matches,omitempty"`
+}
+
+const (
+	// ClaimPartLocal selects the part of an email address before the @
+	ClaimPartLocal = "localPart"
+
+	// ClaimPartDomain selects the part of an email address after the @
+	ClaimPartDomain = "domain"
+)
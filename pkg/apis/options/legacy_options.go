@@ -145,6 +145,7 @@ type LegacyHeaders struct {
 	PassAccessToken   bool `flag:"pass-access-token" cfg:"pass_access_token"`
 	PassUserHeaders   bool `flag:"pass-user-headers" cfg:"pass_user_headers"`
 	PassAuthorization bool `flag:"pass-authorization-header" cfg:"pass_authorization_header"`
+	PassExpiryHeaders bool `flag:"pass-expiry-headers" cfg:"pass_expiry_headers"`
 
 	SetBasicAuth     bool `flag:"set-basic-auth" cfg:"set_basic_auth"`
 	SetXAuthRequest  bool `flag:"set-xauthrequest" cfg:"set_xauthrequest"`
@@ -162,6 +163,7 @@ func legacyHeadersFlagSet() *pflag.FlagSet {
 	flagSet.Bool("pass-access-token", false, "pass OAuth access_token to upstream via X-Forwarded-Access-Token header")
 	flagSet.Bool("pass-user-headers", true, "pass X-Forwarded-User and X-Forwarded-Email information to upstream")
 	flagSet.Bool("pass-authorization-header", false, "pass the Authorization Header to upstream")
+	flagSet.Bool("pass-expiry-headers", false, "pass X-Auth-Request-Token-Expires-In and X-Auth-Request-Session-Expires-At headers to upstream, derived from the access token expiry")
 
 	flagSet.Bool("set-basic-auth", false, "set HTTP Basic Auth information in response (useful in Nginx auth_request mode)")
 	flagSet.Bool("set-xauthrequest", false, "set X-Auth-Request-User and X-Auth-Request-Email response headers (useful in Nginx auth_request mode)")
@@ -201,6 +203,10 @@ func (l *LegacyHeaders) getRequestHeaders() []Header {
 		requestHeaders = append(requestHeaders, getAuthorizationHeader())
 	}
 
+	if l.PassExpiryHeaders {
+		requestHeaders = append(requestHeaders, getExpiryHeaders()...)
+	}
+
 	for i := range requestHeaders {
 		requestHeaders[i].PreserveRequestValue = !l.SkipAuthStripHeaders
 	}
@@ -216,6 +222,9 @@ func (l *LegacyHeaders) getResponseHeaders() []Header {
 		if l.PassAccessToken {
 			responseHeaders = append(responseHeaders, getXAuthRequestAccessTokenHeader())
 		}
+		if l.PassExpiryHeaders {
+			responseHeaders = append(responseHeaders, getExpiryHeaders()...)
+		}
 	}
 
 	if l.SetBasicAuth {
@@ -403,3 +412,30 @@ func getXAuthRequestAccessTokenHeader() Header {
 		},
 	}
 }
+
+// getExpiryHeaders returns headers describing when the access token expires
+// so that clients can refresh the session before it does
+func getExpiryHeaders() []Header {
+	return []Header{
+		{
+			Name: "X-Auth-Request-Token-Expires-In",
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim: "expires_in",
+					},
+				},
+			},
+		},
+		{
+			Name: "X-Auth-Request-Session-Expires-At",
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim: "expires_at",
+					},
+				},
+			},
+		},
+	}
+}
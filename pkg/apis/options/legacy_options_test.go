@@ -438,6 +438,30 @@ var _ = Describe("Legacy Options", func() {
 			},
 		}
 
+		xAuthRequestTokenExpiresIn := Header{
+			Name:                 "X-Auth-Request-Token-Expires-In",
+			PreserveRequestValue: false,
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim: "expires_in",
+					},
+				},
+			},
+		}
+
+		xAuthRequestSessionExpiresAt := Header{
+			Name:                 "X-Auth-Request-Session-Expires-At",
+			PreserveRequestValue: false,
+			Values: []HeaderValue{
+				{
+					ClaimSource: &ClaimSource{
+						Claim: "expires_at",
+					},
+				},
+			},
+		}
+
 		authorizationHeader := Header{
 			Name:                 "Authorization",
 			PreserveRequestValue: false,
@@ -693,6 +717,57 @@ var _ = Describe("Legacy Options", func() {
 					xAuthRequestAccessToken,
 				},
 			}),
+			Entry("with passExpiryHeaders", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassBasicAuth:     false,
+					PassAccessToken:   false,
+					PassUserHeaders:   false,
+					PassAuthorization: false,
+					PassExpiryHeaders: true,
+
+					SetBasicAuth:     false,
+					SetXAuthRequest:  false,
+					SetAuthorization: false,
+
+					PreferEmailToUser:    false,
+					BasicAuthPassword:    "",
+					SkipAuthStripHeaders: true,
+				},
+				expectedRequestHeaders: []Header{
+					xAuthRequestTokenExpiresIn,
+					xAuthRequestSessionExpiresAt,
+				},
+				expectedResponseHeaders: []Header{},
+			}),
+			Entry("with passExpiryHeaders and setXAuthRequest", legacyHeadersTableInput{
+				legacyHeaders: &LegacyHeaders{
+					PassBasicAuth:     false,
+					PassAccessToken:   false,
+					PassUserHeaders:   false,
+					PassAuthorization: false,
+					PassExpiryHeaders: true,
+
+					SetBasicAuth:     false,
+					SetXAuthRequest:  true,
+					SetAuthorization: false,
+
+					PreferEmailToUser:    false,
+					BasicAuthPassword:    "",
+					SkipAuthStripHeaders: true,
+				},
+				expectedRequestHeaders: []Header{
+					xAuthRequestTokenExpiresIn,
+					xAuthRequestSessionExpiresAt,
+				},
+				expectedResponseHeaders: []Header{
+					xAuthRequestUser,
+					xAuthRequestEmail,
+					xAuthRequestGroups,
+					xAuthRequestPreferredUsername,
+					xAuthRequestTokenExpiresIn,
+					xAuthRequestSessionExpiresAt,
+				},
+			}),
 			Entry("with passAcessToken and SkipAuthStripHeaders disabled", legacyHeadersTableInput{
 				legacyHeaders: &LegacyHeaders{
 					PassBasicAuth:     false,
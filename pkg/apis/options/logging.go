@@ -17,6 +17,7 @@ type Logging struct {
 	ExcludePaths    []string       `flag:"exclude-logging-path" cfg:"exclude_logging_paths"`
 	LocalTime       bool           `flag:"logging-local-time" cfg:"logging_local_time"`
 	SilencePing     bool           `flag:"silence-ping-logging" cfg:"silence_ping_logging"`
+	RedactIdentity  string         `flag:"logging-redact-identity" cfg:"logging_redact_identity"`
 	File            LogFileOptions `cfg:",squash"`
 }
 
@@ -43,6 +44,7 @@ func loggingFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("exclude-logging-path", []string{}, "Exclude logging requests to paths (eg: '/path1,/path2,/path3')")
 	flagSet.Bool("logging-local-time", true, "If the time in log files and backup filenames are local or UTC time")
 	flagSet.Bool("silence-ping-logging", false, "Disable logging of requests to ping endpoint")
+	flagSet.String("logging-redact-identity", "none", "how email addresses and user names are written to the auth, request and standard logs: none, mask (first character and email domain) or hash (keyed with the cookie secret)")
 
 	flagSet.String("logging-filename", "", "File to log requests to, empty for stdout")
 	flagSet.Int("logging-max-size", 100, "Maximum size in megabytes of the log file before rotation")
@@ -59,6 +61,7 @@ func loggingDefaults() Logging {
 		ExcludePaths:    nil,
 		LocalTime:       true,
 		SilencePing:     false,
+		RedactIdentity:  "none",
 		AuthEnabled:     true,
 		AuthFormat:      logger.DefaultAuthLoggingFormat,
 		RequestEnabled:  true,
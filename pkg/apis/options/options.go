@@ -3,9 +3,11 @@ package options
 import (
 	"crypto"
 	"net/url"
+	"time"
 
 	oidc "github.com/coreos/go-oidc"
 	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
 	"github.com/spf13/pflag"
 )
@@ -19,21 +21,50 @@ type SignatureData struct {
 // Options holds Configuration Options that can be set by Command Line Flag,
 // or Config File
 type Options struct {
-	ProxyPrefix        string   `flag:"proxy-prefix" cfg:"proxy_prefix"`
-	PingPath           string   `flag:"ping-path" cfg:"ping_path"`
-	PingUserAgent      string   `flag:"ping-user-agent" cfg:"ping_user_agent"`
-	HTTPAddress        string   `flag:"http-address" cfg:"http_address"`
-	HTTPSAddress       string   `flag:"https-address" cfg:"https_address"`
-	ReverseProxy       bool     `flag:"reverse-proxy" cfg:"reverse_proxy"`
-	RealClientIPHeader string   `flag:"real-client-ip-header" cfg:"real_client_ip_header"`
-	TrustedIPs         []string `flag:"trusted-ip" cfg:"trusted_ips"`
-	ForceHTTPS         bool     `flag:"force-https" cfg:"force_https"`
-	RawRedirectURL     string   `flag:"redirect-url" cfg:"redirect_url"`
-	ClientID           string   `flag:"client-id" cfg:"client_id"`
-	ClientSecret       string   `flag:"client-secret" cfg:"client_secret"`
-	ClientSecretFile   string   `flag:"client-secret-file" cfg:"client_secret_file"`
-	TLSCertFile        string   `flag:"tls-cert-file" cfg:"tls_cert_file"`
-	TLSKeyFile         string   `flag:"tls-key-file" cfg:"tls_key_file"`
+	ProxyPrefix            string        `flag:"proxy-prefix" cfg:"proxy_prefix"`
+	PingPath               string        `flag:"ping-path" cfg:"ping_path"`
+	PingUserAgent          string        `flag:"ping-user-agent" cfg:"ping_user_agent"`
+	HTTPAddress            string        `flag:"http-address" cfg:"http_address"`
+	HTTPSAddress           string        `flag:"https-address" cfg:"https_address"`
+	HTTP2                  bool          `flag:"http2" cfg:"http2"`
+	H2C                    bool          `flag:"h2c" cfg:"h2c"`
+	HTTPReadHeaderTimeout  time.Duration `flag:"http-read-header-timeout" cfg:"http_read_header_timeout"`
+	HTTPIdleTimeout        time.Duration `flag:"http-idle-timeout" cfg:"http_idle_timeout"`
+	HTTP2IdleTimeout       time.Duration `flag:"http2-idle-timeout" cfg:"http2_idle_timeout"`
+	HTTP2MaxStreams        int           `flag:"http2-max-streams" cfg:"http2_max_streams"`
+	AdminAddress           string        `flag:"admin-address" cfg:"admin_address"`
+	AdminTokenFile         string        `flag:"admin-token-file" cfg:"admin_token_file"`
+	MetricsRouteTemplates  []string      `flag:"metrics-route-template" cfg:"metrics_route_templates"`
+	ConfigSnapshotFile     string        `flag:"config-snapshot-file" cfg:"config_snapshot_file"`
+	ReverseProxy           bool          `flag:"reverse-proxy" cfg:"reverse_proxy"`
+	RealClientIPHeader     string        `flag:"real-client-ip-header" cfg:"real_client_ip_header"`
+	DenySpoofedClientIP    bool          `flag:"deny-spoofed-client-ip" cfg:"deny_spoofed_client_ip"`
+	UpstreamXRealIP        bool          `flag:"upstream-x-real-ip" cfg:"upstream_x_real_ip"`
+	UpstreamForwardedFor   string        `flag:"upstream-forwarded-for" cfg:"upstream_forwarded_for"`
+	UpstreamAllowedHosts   []string      `flag:"upstream-allowed-host" cfg:"upstream_allowed_hosts"`
+	TrustedIPs             []string      `flag:"trusted-ip" cfg:"trusted_ips"`
+	TrustedIPCloudProvider string        `flag:"trusted-ip-cloud-provider" cfg:"trusted_ip_cloud_provider"`
+	TrustedIPCloudRefresh  time.Duration `flag:"trusted-ip-cloud-refresh" cfg:"trusted_ip_cloud_refresh"`
+	ForceHTTPS             bool          `flag:"force-https" cfg:"force_https"`
+	RawRedirectURL         string        `flag:"redirect-url" cfg:"redirect_url"`
+	MaxStateLength         int           `flag:"max-state-length" cfg:"max_state_length"`
+	MaxRedirectLength      int           `flag:"max-redirect-length" cfg:"max_redirect_length"`
+	MaxLoginHintLength     int           `flag:"max-login-hint-length" cfg:"max_login_hint_length"`
+	ClientID               string        `flag:"client-id" cfg:"client_id"`
+	ClientSecret           string        `flag:"client-secret" cfg:"client_secret"`
+	ClientSecretFile       string        `flag:"client-secret-file" cfg:"client_secret_file"`
+	TLSCertFile            string        `flag:"tls-cert-file" cfg:"tls_cert_file"`
+	TLSKeyFile             string        `flag:"tls-key-file" cfg:"tls_key_file"`
+	AuthRequestCacheTTL    time.Duration `flag:"auth-request-cache-ttl" cfg:"auth_request_cache_ttl"`
+	ShareLinkMaxExpiry     time.Duration `flag:"share-link-max-expiry" cfg:"share_link_max_expiry"`
+	MemoryLimitMB          int           `flag:"memory-limit-mb" cfg:"memory_limit_mb"`
+	MaxResponseBodySize    int           `flag:"max-response-body-size" cfg:"max_response_body_size"`
+
+	AdminGRPCAddress      string   `flag:"admin-grpc-address" cfg:"admin_grpc_address"`
+	AdminGRPCTLSCertFile  string   `flag:"admin-grpc-tls-cert-file" cfg:"admin_grpc_tls_cert_file"`
+	AdminGRPCTLSKeyFile   string   `flag:"admin-grpc-tls-key-file" cfg:"admin_grpc_tls_key_file"`
+	AdminGRPCClientCAFile string   `flag:"admin-grpc-client-ca-file" cfg:"admin_grpc_client_ca_file"`
+	AdminGRPCClients      []string `flag:"admin-grpc-allowed-client" cfg:"admin_grpc_allowed_clients"`
 
 	AuthenticatedEmailsFile  string   `flag:"authenticated-emails-file" cfg:"authenticated_emails_file"`
 	KeycloakGroups           []string `flag:"keycloak-group" cfg:"keycloak_groups"`
@@ -41,7 +72,11 @@ type Options struct {
 	BitbucketTeam            string   `flag:"bitbucket-team" cfg:"bitbucket_team"`
 	BitbucketRepository      string   `flag:"bitbucket-repository" cfg:"bitbucket_repository"`
 	EmailDomains             []string `flag:"email-domain" cfg:"email_domains"`
+	IdentityLowercase        bool     `flag:"identity-lowercase" cfg:"identity_lowercase"`
+	IdentityStripPlusAddress bool     `flag:"identity-strip-plus-address" cfg:"identity_strip_plus_address"`
+	IdentityDomainAliases    []string `flag:"identity-domain-alias" cfg:"identity_domain_aliases"`
 	WhitelistDomains         []string `flag:"whitelist-domain" cfg:"whitelist_domains"`
+	AuthDomain               string   `flag:"auth-domain" cfg:"auth_domain"`
 	GitHubOrg                string   `flag:"github-org" cfg:"github_org"`
 	GitHubTeam               string   `flag:"github-team" cfg:"github_team"`
 	GitHubRepo               string   `flag:"github-repo" cfg:"github_repo"`
@@ -57,31 +92,72 @@ type Options struct {
 	CustomTemplatesDir       string   `flag:"custom-templates-dir" cfg:"custom_templates_dir"`
 	Banner                   string   `flag:"banner" cfg:"banner"`
 	Footer                   string   `flag:"footer" cfg:"footer"`
+	MiddlewarePlugins        []string `flag:"middleware-plugin" cfg:"middleware_plugins"`
 
-	Cookie  Cookie         `cfg:",squash"`
-	Session SessionOptions `cfg:",squash"`
-	Logging Logging        `cfg:",squash"`
+	Cookie    Cookie         `cfg:",squash"`
+	Session   SessionOptions `cfg:",squash"`
+	Logging   Logging        `cfg:",squash"`
+	Telemetry Telemetry      `cfg:",squash"`
 
 	// Not used in the legacy config, name not allowed to match an external key (upstreams)
 	// TODO(JoelSpeed): Rename when legacy config is removed
 	UpstreamServers Upstreams `cfg:",internal"`
 
+	PublishedRoutesRefresh time.Duration `flag:"published-routes-refresh" cfg:"published_routes_refresh"`
+	DenyRuleSetRefresh     time.Duration `flag:"deny-rule-set-refresh" cfg:"deny_rule_set_refresh"`
+	DenyIPFeedRefresh      time.Duration `flag:"deny-ip-feed-refresh" cfg:"deny_ip_feed_refresh"`
+
 	InjectRequestHeaders  []Header `cfg:",internal"`
 	InjectResponseHeaders []Header `cfg:",internal"`
 
-	SkipAuthRegex         []string `flag:"skip-auth-regex" cfg:"skip_auth_regex"`
-	SkipAuthRoutes        []string `flag:"skip-auth-route" cfg:"skip_auth_routes"`
-	SkipJwtBearerTokens   bool     `flag:"skip-jwt-bearer-tokens" cfg:"skip_jwt_bearer_tokens"`
-	ExtraJwtIssuers       []string `flag:"extra-jwt-issuers" cfg:"extra_jwt_issuers"`
-	SkipProviderButton    bool     `flag:"skip-provider-button" cfg:"skip_provider_button"`
-	SSLInsecureSkipVerify bool     `flag:"ssl-insecure-skip-verify" cfg:"ssl_insecure_skip_verify"`
-	SkipAuthPreflight     bool     `flag:"skip-auth-preflight" cfg:"skip_auth_preflight"`
+	IDTokenClaims []string `flag:"id-token-claims" cfg:"id_token_claims"`
+
+	TrustedRequests []TrustedRequest `cfg:",internal"`
+
+	SkipAuthRegex             []string `flag:"skip-auth-regex" cfg:"skip_auth_regex"`
+	SkipAuthRegexSafeMethods  bool     `flag:"skip-auth-regex-safe-methods" cfg:"skip_auth_regex_safe_methods"`
+	SkipAuthRoutes            []string `flag:"skip-auth-route" cfg:"skip_auth_routes"`
+	StrictRegex               bool     `flag:"strict-regex" cfg:"strict_regex"`
+	DenyRoutes                []string `flag:"deny-route" cfg:"deny_routes"`
+	DenyIPs                   []string `flag:"deny-ip" cfg:"deny_ips"`
+	DenyIPFeeds               []string `flag:"deny-ip-feed" cfg:"deny_ip_feeds"`
+	DenyActions               []string `flag:"deny-action" cfg:"deny_actions"`
+	DenyShadowRules           []string `flag:"deny-shadow-rule" cfg:"deny_shadow_rules"`
+	DenyUpstreams             []string `flag:"deny-upstream" cfg:"deny_upstreams"`
+	ReorderDenyRules          bool     `flag:"reorder-deny-rules" cfg:"reorder_deny_rules"`
+	DenyRulesCacheSize        int      `flag:"deny-rules-cache-size" cfg:"deny_rules_cache_size"`
+	DenyRulesOptimize         bool     `flag:"deny-rules-optimize" cfg:"deny_rules_optimize"`
+	DenyRulesDisabledIndices  []string `flag:"deny-rules-disabled-index" cfg:"deny_rules_disabled_indices"`
+	DenyRulesIndexThreshold   int      `flag:"deny-rules-index-threshold" cfg:"deny_rules_index_threshold"`
+	DenyRulesIndexReorderRate float64  `flag:"deny-rules-index-reorder-rate" cfg:"deny_rules_index_reorder_rate"`
+	DenyRuleHeader            bool     `flag:"deny-rule-header" cfg:"deny_rule_header"`
+	DenyRuleSets              []string `flag:"deny-rule-set" cfg:"deny_rule_sets"`
+	ActiveDenyRuleSet         string   `flag:"active-deny-rule-set" cfg:"active_deny_rule_set"`
+	DenyRuleSetURLs           []string `flag:"deny-rule-set-url" cfg:"deny_rule_set_urls"`
+	SkipAuthUserAgents        []string `flag:"skip-auth-user-agent" cfg:"skip_auth_user_agents"`
+	SkipAuthUserAgentIPs      []string `flag:"skip-auth-user-agent-ip" cfg:"skip_auth_user_agent_ips"`
+	SkipAuthHtpasswdFile      string   `flag:"skip-auth-htpasswd-file" cfg:"skip_auth_htpasswd_file"`
+	SkipJwtBearerTokens       bool     `flag:"skip-jwt-bearer-tokens" cfg:"skip_jwt_bearer_tokens"`
+	ExtraJwtIssuers           []string `flag:"extra-jwt-issuers" cfg:"extra_jwt_issuers"`
+	SkipProviderButton        bool     `flag:"skip-provider-button" cfg:"skip_provider_button"`
+	CaptivePortal             bool     `flag:"captive-portal" cfg:"captive_portal"`
+	SSLInsecureSkipVerify     bool     `flag:"ssl-insecure-skip-verify" cfg:"ssl_insecure_skip_verify"`
+	SkipAuthPreflight         bool     `flag:"skip-auth-preflight" cfg:"skip_auth_preflight"`
+
+	DenyWebhooks        []string      `flag:"deny-webhook" cfg:"deny_webhooks"`
+	DenyWebhookTimeout  time.Duration `flag:"deny-webhook-timeout" cfg:"deny_webhook_timeout"`
+	DenyWebhookFailOpen bool          `flag:"deny-webhook-fail-open" cfg:"deny_webhook_fail_open"`
+	DenyWebhookCacheTTL time.Duration `flag:"deny-webhook-cache-ttl" cfg:"deny_webhook_cache_ttl"`
+	DenyOPAPolicies     []string      `flag:"deny-opa-policy" cfg:"deny_opa_policies"`
+	DenyExpressions     []string      `flag:"deny-expression" cfg:"deny_expressions"`
 
 	// These options allow for other providers besides Google, with
 	// potential overrides.
 	ProviderType                       string   `flag:"provider" cfg:"provider"`
 	ProviderName                       string   `flag:"provider-display-name" cfg:"provider_display_name"`
+	Tenant                             string   `flag:"tenant" cfg:"tenant"`
 	ProviderCAFiles                    []string `flag:"provider-ca-file" cfg:"provider_ca_files"`
+	EgressProxies                      []string `flag:"egress-proxy" cfg:"egress_proxies"`
 	OIDCIssuerURL                      string   `flag:"oidc-issuer-url" cfg:"oidc_issuer_url"`
 	InsecureOIDCAllowUnverifiedEmail   bool     `flag:"insecure-oidc-allow-unverified-email" cfg:"insecure_oidc_allow_unverified_email"`
 	InsecureOIDCSkipIssuerVerification bool     `flag:"insecure-oidc-skip-issuer-verification" cfg:"insecure_oidc_skip_issuer_verification"`
@@ -100,37 +176,53 @@ type Options struct {
 	UserIDClaim                        string   `flag:"user-id-claim" cfg:"user_id_claim"`
 	AllowedGroups                      []string `flag:"allowed-group" cfg:"allowed_groups"`
 
-	SignatureKey    string `flag:"signature-key" cfg:"signature_key"`
-	AcrValues       string `flag:"acr-values" cfg:"acr_values"`
-	JWTKey          string `flag:"jwt-key" cfg:"jwt_key"`
-	JWTKeyFile      string `flag:"jwt-key-file" cfg:"jwt_key_file"`
-	PubJWKURL       string `flag:"pubjwk-url" cfg:"pubjwk_url"`
-	GCPHealthChecks bool   `flag:"gcp-healthchecks" cfg:"gcp_healthchecks"`
+	SignatureKey               string        `flag:"signature-key" cfg:"signature_key"`
+	ChainedIdentityKey         string        `flag:"chained-identity-key" cfg:"chained_identity_key"`
+	ChainedIdentityFormat      string        `flag:"chained-identity-format" cfg:"chained_identity_format"`
+	ChainedIdentityAudience    string        `flag:"chained-identity-audience" cfg:"chained_identity_audience"`
+	ChainedIdentityMaxLifetime time.Duration `flag:"chained-identity-max-lifetime" cfg:"chained_identity_max_lifetime"`
+	AcrValues                  string        `flag:"acr-values" cfg:"acr_values"`
+	JWTKey                     string        `flag:"jwt-key" cfg:"jwt_key"`
+	JWTKeyFile                 string        `flag:"jwt-key-file" cfg:"jwt_key_file"`
+	PubJWKURL                  string        `flag:"pubjwk-url" cfg:"pubjwk_url"`
+	GCPHealthChecks            bool          `flag:"gcp-healthchecks" cfg:"gcp_healthchecks"`
 
 	// internal values that are set after config validation
-	redirectURL        *url.URL
-	provider           providers.Provider
-	signatureData      *SignatureData
-	oidcVerifier       *oidc.IDTokenVerifier
-	jwtBearerVerifiers []*oidc.IDTokenVerifier
-	realClientIPParser ipapi.RealClientIPParser
+	redirectURL          *url.URL
+	provider             providers.Provider
+	signatureData        *SignatureData
+	chainedSignatureData *SignatureData
+	oidcVerifier         *oidc.IDTokenVerifier
+	jwtBearerVerifiers   []*oidc.IDTokenVerifier
+	realClientIPParser   ipapi.RealClientIPParser
+	authorizationRules   *authorization.RuleSets
+	ruleSyncers          []authorization.Syncer
+	configReport         *ConfigReport
 }
 
 // Options for Getting internal values
 func (o *Options) GetRedirectURL() *url.URL                        { return o.redirectURL }
 func (o *Options) GetProvider() providers.Provider                 { return o.provider }
 func (o *Options) GetSignatureData() *SignatureData                { return o.signatureData }
+func (o *Options) GetChainedSignatureData() *SignatureData         { return o.chainedSignatureData }
 func (o *Options) GetOIDCVerifier() *oidc.IDTokenVerifier          { return o.oidcVerifier }
 func (o *Options) GetJWTBearerVerifiers() []*oidc.IDTokenVerifier  { return o.jwtBearerVerifiers }
 func (o *Options) GetRealClientIPParser() ipapi.RealClientIPParser { return o.realClientIPParser }
+func (o *Options) GetAuthorizationRules() *authorization.RuleSets  { return o.authorizationRules }
+func (o *Options) GetRuleSyncers() []authorization.Syncer          { return o.ruleSyncers }
+func (o *Options) GetConfigReport() *ConfigReport                  { return o.configReport }
 
 // Options for Setting internal values
 func (o *Options) SetRedirectURL(s *url.URL)                        { o.redirectURL = s }
 func (o *Options) SetProvider(s providers.Provider)                 { o.provider = s }
 func (o *Options) SetSignatureData(s *SignatureData)                { o.signatureData = s }
+func (o *Options) SetChainedSignatureData(s *SignatureData)         { o.chainedSignatureData = s }
 func (o *Options) SetOIDCVerifier(s *oidc.IDTokenVerifier)          { o.oidcVerifier = s }
 func (o *Options) SetJWTBearerVerifiers(s []*oidc.IDTokenVerifier)  { o.jwtBearerVerifiers = s }
 func (o *Options) SetRealClientIPParser(s ipapi.RealClientIPParser) { o.realClientIPParser = s }
+func (o *Options) SetAuthorizationRules(s *authorization.RuleSets)  { o.authorizationRules = s }
+func (o *Options) SetRuleSyncers(s []authorization.Syncer)          { o.ruleSyncers = s }
+func (o *Options) SetConfigReport(s *ConfigReport)                  { o.configReport = s }
 
 // NewOptions constructs a new Options with defaulted values
 func NewOptions() *Options {
@@ -141,7 +233,22 @@ func NewOptions() *Options {
 		HTTPAddress:                      "127.0.0.1:4180",
 		HTTPSAddress:                     ":443",
 		RealClientIPHeader:               "X-Real-IP",
+		UpstreamForwardedFor:             ForwardedForAppend,
+		TrustedIPCloudRefresh:            time.Duration(5) * time.Minute,
+		PublishedRoutesRefresh:           time.Duration(1) * time.Minute,
+		DenyRuleSetRefresh:               time.Duration(5) * time.Minute,
+		DenyIPFeedRefresh:                time.Duration(1) * time.Hour,
+		DenyRulesOptimize:                true,
+		DenyRulesIndexThreshold:          authorization.DefaultEngineOptions.OptimizeThreshold,
+		DenyRulesIndexReorderRate:        authorization.DefaultEngineOptions.ReorderSampleRate,
+		DenyWebhookTimeout:               time.Second,
 		ForceHTTPS:                       false,
+		ChainedIdentityFormat:            string(IdentityFormatJWT),
+		ChainedIdentityMaxLifetime:       time.Minute,
+		MaxStateLength:                   4096,
+		MaxRedirectLength:                2048,
+		MaxLoginHintLength:               256,
+		MaxResponseBodySize:              10 << 20,
 		DisplayHtpasswdForm:              true,
 		Cookie:                           cookieDefaults(),
 		Session:                          sessionOptionsDefaults(),
@@ -152,6 +259,7 @@ func NewOptions() *Options {
 		InsecureOIDCAllowUnverifiedEmail: false,
 		SkipOIDCDiscovery:                false,
 		Logging:                          loggingDefaults(),
+		Telemetry:                        telemetryDefaults(),
 		UserIDClaim:                      providers.OIDCEmailClaim, // Deprecated: Use OIDCEmailClaim
 		OIDCEmailClaim:                   providers.OIDCEmailClaim,
 		OIDCGroupsClaim:                  providers.OIDCGroupsClaim,
@@ -164,23 +272,84 @@ func NewFlagSet() *pflag.FlagSet {
 
 	flagSet.String("http-address", "127.0.0.1:4180", "[http://]<addr>:<port> or unix://<path> to listen on for HTTP clients")
 	flagSet.String("https-address", ":443", "<addr>:<port> to listen on for HTTPS clients")
+	flagSet.Bool("http2", false, "serve HTTP/2 to HTTPS clients that negotiate it")
+	flagSet.Bool("h2c", false, "serve HTTP/2 without TLS on the HTTP address to clients with prior knowledge or that upgrade. Only for trusted internal networks")
+	flagSet.Duration("http-read-header-timeout", time.Duration(0), "the maximum time to read the headers of an HTTP/1 request (0 for no limit)")
+	flagSet.Duration("http-idle-timeout", time.Duration(0), "the maximum time an idle HTTP/1 keep-alive connection is kept open (0 for no limit)")
+	flagSet.Duration("http2-idle-timeout", time.Duration(0), "the maximum time an idle HTTP/2 connection is kept open (0 to use --http-idle-timeout)")
+	flagSet.Int("http2-max-streams", 0, "the maximum number of concurrent streams per HTTP/2 connection (0 for the default of 250)")
+	flagSet.String("admin-address", "", "<addr>:<port> or unix://<path> to serve the admin API on. Must be a loopback address or unix socket (disabled if empty)")
+	flagSet.String("admin-token-file", "", "the file with the bearer token required to access the admin API")
+	flagSet.String("admin-grpc-address", "", "<addr>:<port> to serve the gRPC admin service on with mutual TLS (disabled if empty)")
+	flagSet.String("admin-grpc-tls-cert-file", "", "path to the certificate file of the gRPC admin service")
+	flagSet.String("admin-grpc-tls-key-file", "", "path to the private key file of the gRPC admin service")
+	flagSet.String("admin-grpc-client-ca-file", "", "path to the CA certificates that client certificates of the gRPC admin service must be signed by")
+	flagSet.StringSlice("admin-grpc-allowed-client", []string{}, "a common name or DNS name of client certificates allowed to use the gRPC admin service (may be given multiple times; all clients signed by the CA are allowed if empty)")
+	flagSet.StringSlice("metrics-route-template", []string{}, "a route template such as /api/users/{id} that request metrics label matching paths with (may be given multiple times, matched in order)")
+	flagSet.String("config-snapshot-file", "", "the file to save the redacted effective configuration to, so that the next start logs the options that changed")
 	flagSet.Bool("reverse-proxy", false, "are we running behind a reverse proxy, controls whether headers like X-Real-Ip are accepted")
 	flagSet.String("real-client-ip-header", "X-Real-IP", "Header used to determine the real IP of the client (one of: X-Forwarded-For, X-Real-IP, or X-ProxyUser-IP)")
+	flagSet.Bool("deny-spoofed-client-ip", false, "deny requests whose real client IP header appears spoofed, rather than only logging them (requires --reverse-proxy)")
+	flagSet.Bool("upstream-x-real-ip", false, "set the X-Real-IP header of requests to upstreams to the real client IP, overwriting any value from the client")
+	flagSet.String("upstream-forwarded-for", ForwardedForAppend, "how the client IP is passed to upstreams (one of: append to X-Forwarded-For, replace X-Forwarded-For with the real client IP, or an RFC 7239 forwarded header)")
+	flagSet.StringSlice("upstream-allowed-host", []string{}, "a host name, IP or CIDR range that requests may be proxied to, refusing any other upstream host (may be given multiple times). Link-local and cloud metadata addresses are refused unless allowed by a CIDR range")
 	flagSet.StringSlice("trusted-ip", []string{}, "list of IPs or CIDR ranges to allow to bypass authentication. WARNING: trusting by IP has inherent security flaws, read the configuration documentation for more information.")
+	flagSet.String("trusted-ip-cloud-provider", "", "cloud provider (one of: aws, gcp, azure) to discover the VPC and load balancer CIDR ranges to trust from (disabled if empty)")
+	flagSet.Duration("trusted-ip-cloud-refresh", time.Duration(5)*time.Minute, "the interval between refreshes of the trusted CIDR ranges from cloud provider metadata")
 	flagSet.Bool("force-https", false, "force HTTPS redirect for HTTP requests")
 	flagSet.String("tls-cert-file", "", "path to certificate file")
 	flagSet.String("tls-key-file", "", "path to private key file")
+	flagSet.Duration("share-link-max-expiry", time.Duration(0), "let authenticated users mint signed links to a path at /oauth2/share, which skip authentication until they expire, for at most this long (0 to disable share links)")
+	flagSet.Duration("auth-request-cache-ttl", time.Duration(0), "allow caches in front of the auth endpoint to reuse authenticated (202) responses per cookie for this long (0 to disable)")
 	flagSet.String("redirect-url", "", "the OAuth Redirect URL. ie: \"https://internalapp.yourcompany.com/oauth2/callback\"")
+	flagSet.Int("max-state-length", 4096, "the maximum length in bytes of the state parameter of OAuth callbacks (0 for no limit)")
+	flagSet.Int("max-redirect-length", 2048, "the maximum length in bytes of the rd redirect parameter (0 for no limit)")
+	flagSet.Int("max-login-hint-length", 256, "the maximum length in bytes of the login_hint parameter (0 for no limit)")
+	flagSet.Int("memory-limit-mb", 0, "the soft limit in MiB of the memory used by the proxy. Over the limit, garbage is collected more often and caches and queues stop growing (0 to disable)")
+	flagSet.Int("max-response-body-size", 10<<20, "the largest body in bytes buffered from responses to requests made by the proxy, such as to the provider (0 for no limit)")
 	flagSet.StringSlice("skip-auth-regex", []string{}, "(DEPRECATED for --skip-auth-route) bypass authentication for requests path's that match (may be given multiple times)")
+	flagSet.Bool("skip-auth-regex-safe-methods", false, "only bypass authentication for GET, HEAD and OPTIONS requests matching --skip-auth-regex. Use --skip-auth-route to allow other methods")
 	flagSet.StringSlice("skip-auth-route", []string{}, "bypass authentication for requests that match the method & path. Format: method=path_regex OR path_regex alone for all methods")
+	flagSet.Bool("strict-regex", false, "reject --skip-auth-route and --skip-auth-regex path regexes that are not anchored with ^ and $, instead of logging a warning")
+	flagSet.StringSlice("deny-route", []string{}, "deny requests matching the method=path regex, before any allowlist is checked (may be given multiple times)")
+	flagSet.StringSlice("deny-ip", []string{}, "deny requests from IPs or CIDR ranges, before any allowlist is checked (may be given multiple times)")
+	flagSet.StringSlice("deny-ip-feed", []string{}, "deny requests from the IPs and CIDR ranges listed one per line in a local file or at an http(s) URL, which is reloaded every --deny-ip-feed-refresh (may be given multiple times)")
+	flagSet.Duration("deny-ip-feed-refresh", time.Duration(1)*time.Hour, "the interval between reloads of the --deny-ip-feed blocklists")
+	flagSet.StringSlice("deny-action", []string{}, "how to respond to requests denied by a --deny-route or --deny-ip rule, in the format rule-id=action, where rule-id is * for all rules (may be given multiple times). One of error_page, forbidden, unauthorized, sign_in or json")
+	flagSet.StringSlice("deny-shadow-rule", []string{}, "the ID of a deny rule to log and count the requests of without denying them, to try the rule out before it is enforced (may be given multiple times)")
+	flagSet.StringSlice("deny-upstream", []string{}, "scope a deny rule to requests routed to an upstream, in the format rule-id=upstream-id, so that it does not apply to other upstreams (may be given multiple times)")
+	flagSet.Bool("reorder-deny-rules", false, "check the deny rules that match more requests first, rather than always in the configured order")
+	flagSet.Bool("deny-rules-optimize", true, "index the deny rules and reorder the indices by use when there are enough rules. Disable to check every rule in order, for reproducible evaluation")
+	flagSet.Int("deny-rules-index-threshold", 5, "the number of deny rules above which they are indexed")
+	flagSet.Float64("deny-rules-index-reorder-rate", 0.01, "the fraction of requests matching a deny rule, between 0 and 1, after which the indices are reordered by use (0 to never reorder them)")
+	flagSet.StringSlice("deny-rules-disabled-index", []string{}, "an index of path, host, methods, headers or ips not to narrow down the deny rules with (may be given multiple times)")
+	flagSet.Int("deny-rules-cache-size", 0, "the number of method, host, path and client IP combinations to cache deny rule results for (0 to disable)")
+	flagSet.Bool("deny-rule-header", false, "add an X-OAuth2-Proxy-Rule header with the ID of the matching deny rule to denied responses, for debugging")
+	flagSet.StringSlice("deny-rule-set", []string{}, "a named set of deny rules loaded from a YAML file with deny_routes and deny_ips, in the format name=path (may be given multiple times)")
+	flagSet.StringSlice("deny-rule-set-url", []string{}, "a named set of deny rules fetched from an http(s), s3 or gs URL and signed with the --signature-key, in the format name=url (may be given multiple times)")
+	flagSet.StringSlice("deny-webhook", []string{}, "deny authenticated requests when this URL responds with 403 Forbidden to a JSON description of the request and session, or allow them when it responds with 200 OK (may be given multiple times)")
+	flagSet.Duration("deny-webhook-timeout", time.Second, "the maximum time to wait for a --deny-webhook response")
+	flagSet.Bool("deny-webhook-fail-open", false, "allow requests when a --deny-webhook cannot be reached, times out or responds with another status, instead of denying them")
+	flagSet.Duration("deny-webhook-cache-ttl", time.Duration(0), "how long to cache --deny-webhook decisions for identical requests and sessions (0 disables caching)")
+	flagSet.StringSlice("deny-opa-policy", []string{}, "deny authenticated requests unless the data.oauth2_proxy.allow rule of this Rego policy file is true for the request and session (may be given multiple times)")
+	flagSet.StringSlice("deny-expression", []string{}, "deny authenticated requests for which this CEL expression of the request and session is true, e.g. request.path.startsWith('/admin') && !('admins' in session.groups) (may be given multiple times)")
+	flagSet.String("active-deny-rule-set", "", "the name of the deny rule set that is active when the proxy starts (defaults to the rules from --deny-route and --deny-ip)")
+	flagSet.StringSlice("skip-auth-user-agent", []string{}, "bypass authentication for requests with a User-Agent matching the regex (may be given multiple times)")
+	flagSet.StringSlice("skip-auth-user-agent-ip", []string{}, "restrict --skip-auth-user-agent to clients from these IPs or CIDR ranges (may be given multiple times)")
+	flagSet.String("skip-auth-htpasswd-file", "", "bypass authentication for requests with HTTP Basic credentials valid against this htpasswd file. Entries should be created with \"htpasswd -B\" for bcrypt encryption")
 	flagSet.Bool("skip-provider-button", false, "will skip sign-in-page to directly reach the next step: oauth/start")
+	flagSet.Bool("captive-portal", false, "answer unauthenticated requests of clients that are not browsers, such as the captive portal detection of operating systems, with 511 Network Authentication Required and a refresh to the sign in page")
 	flagSet.Bool("skip-auth-preflight", false, "will skip authentication for OPTIONS requests")
 	flagSet.Bool("ssl-insecure-skip-verify", false, "skip validation of certificates presented when using HTTPS providers")
 	flagSet.Bool("skip-jwt-bearer-tokens", false, "will skip requests that have verified JWT bearer tokens (default false)")
 	flagSet.StringSlice("extra-jwt-issuers", []string{}, "if skip-jwt-bearer-tokens is set, a list of extra JWT issuer=audience pairs (where the issuer URL has a .well-known/openid-configuration or a .well-known/jwks.json)")
 
 	flagSet.StringSlice("email-domain", []string{}, "authenticate emails with the specified domain (may be given multiple times). Use * to authenticate any email")
+	flagSet.Bool("identity-lowercase", false, "lowercase the email and user of sessions before authorization and header injection")
+	flagSet.Bool("identity-strip-plus-address", false, "strip the +tag from emails of sessions before authorization and header injection (eg user+tag@example.com becomes user@example.com)")
+	flagSet.StringSlice("identity-domain-alias", []string{}, "rewrite the domain of emails of sessions before authorization and header injection (eg old.example.com=new.example.com) (may be given multiple times)")
 	flagSet.StringSlice("whitelist-domain", []string{}, "allowed domains for redirection after authentication. Prefix domain with a . to allow subdomains (eg .example.com)")
+	flagSet.String("auth-domain", "", "the host that handles sign in for all applications (eg auth.example.com). Other hosts redirect users to it and receive their session through a signed handoff redirect")
 	flagSet.StringSlice("keycloak-group", []string{}, "restrict logins to members of these groups (may be given multiple times)")
 	flagSet.String("azure-tenant", "common", "go to a tenant-specific or common (tenant-independent) endpoint.")
 	flagSet.String("bitbucket-team", "", "restrict logins to members of this team")
@@ -204,11 +373,40 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.String("custom-templates-dir", "", "path to custom html templates")
 	flagSet.String("banner", "", "custom banner string. Use \"-\" to disable default banner.")
 	flagSet.String("footer", "", "custom footer string. Use \"-\" to disable default footer.")
+	flagSet.StringSlice("middleware-plugin", []string{}, "path to a Go plugin exporting middleware Hooks to run at the pre-auth, post-auth and pre-proxy stages (may be given multiple times)")
 	flagSet.String("proxy-prefix", "/oauth2", "the url root path that this proxy should be nested under (e.g. /<oauth2>/sign_in)")
 	flagSet.String("ping-path", "/ping", "the ping endpoint that can be used for basic health checks")
 	flagSet.String("ping-user-agent", "", "special User-Agent that will be used for basic health checks")
 	flagSet.String("session-store-type", "cookie", "the session storage provider to use")
+	flagSet.StringSlice("session-fingerprint", []string{}, "bind sessions to a fingerprint of the client they were created by and reject session cookies presented by other clients: ip and/or user-agent (may be given multiple times)")
+	flagSet.Bool("session-integrity-strict", false, "reject sessions without an integrity MAC over their identity and expiry, rather than only those with a MAC that does not match. Enable once all sessions have been saved by a version that adds the MAC")
+	flagSet.String("session-encoding", "msgpack", "the encoding of sessions stored in redis: msgpack or protobuf, for other services that read the sessions. Sessions in either encoding can be loaded")
+	flagSet.Duration("session-clock-skew", time.Duration(0), "how long after their expiry sessions are still accepted, to tolerate a provider clock that runs ahead of this one")
+	flagSet.String("session-legacy-cutoff", "", "the date (e.g. 2006-01-02) or RFC3339 time until which legacy sessions, saved before sessions had a schema version, are decoded. They are refused if empty")
+	flagSet.Duration("session-refresh-backoff", time.Duration(0), "how long to wait after the provider failed to refresh a session before refreshing it again, doubling with each failure in a row. Sessions are used as they are until they expire in the meantime. 0 clears sessions that fail to refresh")
+	flagSet.Duration("session-refresh-backoff-max", 5*time.Minute, "the longest wait between refreshes of a session the provider fails to refresh, with --session-refresh-backoff")
+	flagSet.Duration("session-write-batch-interval", time.Duration(0), "batch updates to existing sessions in redis, writing each session at most once per interval (0 to write updates immediately)")
+	flagSet.Duration("session-load-latency-budget", time.Duration(0), "how long loading a session from redis should take. Slower loads are logged when they happen --session-load-budget-violations times in a row (0 to disable)")
+	flagSet.Int("session-load-budget-violations", 5, "the number of session loads in a row over the --session-load-latency-budget after which they are logged, and the session store is bypassed if --session-degraded-mode-window is set")
+	flagSet.Duration("session-degraded-mode-window", time.Duration(0), "how long to validate sessions by cookie signature only, using the sessions this instance last loaded or saved, once session loads are over the latency budget (0 to never bypass the session store)")
+	flagSet.String("session-annotation-header", "", "the upstream response header that annotates the session with key=value, for one of --session-annotation-keys. Annotations are injected as X-Forwarded-Annotation-<key> request headers")
+	flagSet.StringSlice("session-annotation-keys", []string{}, "the session annotation keys upstreams may set with --session-annotation-header (may be given multiple times)")
+	flagSet.Int("session-annotations-max-size", 512, "the largest total size in bytes of the keys and values of the annotations of a session. Annotations that would exceed it are ignored (0 for no limit)")
+	flagSet.StringSlice("session-claims", []string{}, "provider specific ID token claims to carry in sessions for headers and authorization rules (may be given multiple times)")
+	flagSet.Int("session-claims-max-size", 1024, "the largest size in bytes of the claims carried in a session. Claims that would exceed it are left out of the session (0 for no limit)")
 	flagSet.Bool("session-cookie-minimal", false, "strip OAuth tokens from cookie session stores if they aren't needed (cookie session store only)")
+	flagSet.String("session-cookie-compression", "lz4", "the algorithm to compress cookie sessions with: lz4, zstd, snappy or none. Sessions compressed with any algorithm can be read (cookie session store only)")
+	flagSet.Int("session-cookie-compression-level", 0, "the level to compress cookie sessions at: 0 to 9 for lz4 and 0 to 22 for zstd, where 0 is the default level of the algorithm (cookie session store only)")
+	flagSet.Int("session-cookie-compression-threshold", 0, "the size in bytes of the smallest sessions to compress in cookie session stores. Sessions are only compressed if it makes them smaller (cookie session store only)")
+	flagSet.StringSlice("session-cookie-fields", []string{}, "the session fields to store in cookie sessions, of access_token, id_token, refresh_token, email, user, groups, preferred_username and claims. All fields are stored if empty (cookie session store only)")
+	flagSet.Int("session-cookie-max-size", 0, "the largest total size in bytes of the session Set-Cookie headers accepted by the CDN or WAF in front of the proxy. Larger sessions are logged with a warning. 0 disables the check (cookie session store only)")
+	flagSet.Int("session-cookie-size-warning-threshold", 4096, "the size in bytes of the session cookies above which a warning is logged, as browsers limit each cookie to 4096 bytes. 0 disables the warning (cookie session store only)")
+	flagSet.Bool("session-cookie-overflow-to-redis", false, "store sessions larger than --session-cookie-max-size in redis, using the redis options, with only a ticket in the cookie (cookie session store only)")
+	flagSet.Bool("session-cookie-split-tokens", false, "store the access, refresh and ID tokens of sessions in redis, using the redis options, with only the identity and a ticket to the tokens in the cookies (cookie session store only)")
+	flagSet.String("session-cookie-format", "encrypted", "how sessions are stored in cookies: encrypted, or jwt for a JWT signed with --session-cookie-jwt-key-file that other services can validate with the key set at /oauth2/jwks.json (cookie session store only)")
+	flagSet.String("session-cookie-jwt-key-file", "", "path to the PEM encoded RSA or ECDSA private key that JWT cookie sessions are signed with")
+	flagSet.String("session-cookie-jwt-audience", "", "the audience of JWT cookie sessions, their \"aud\" claim, which services that read them should check")
+	flagSet.String("session-cookie-jwt-encryption-key", "", "the key to encrypt JWT cookie sessions with, of 16, 24 or 32 bytes, for services that are given the key. JWT sessions are only signed if empty")
 	flagSet.String("redis-connection-url", "", "URL of redis server for redis session storage (eg: redis://HOST[:PORT])")
 	flagSet.String("redis-password", "", "Redis password. Applicable for all Redis configurations. Will override any password set in `--redis-connection-url`")
 	flagSet.Bool("redis-use-sentinel", false, "Connect to redis via sentinels. Must set --redis-sentinel-master-name and --redis-sentinel-connection-urls to use this feature")
@@ -221,8 +419,10 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("redis-cluster-connection-urls", []string{}, "List of Redis cluster connection URLs (eg redis://HOST[:PORT]). Used in conjunction with --redis-use-cluster")
 
 	flagSet.String("provider", "google", "OAuth provider")
+	flagSet.String("tenant", "", "a name for the tenant this proxy serves, added to metrics and available in logs so that a shared fleet can be split by tenant")
 	flagSet.String("provider-display-name", "", "Provider display name")
 	flagSet.StringSlice("provider-ca-file", []string{}, "One or more paths to CA certificates that should be used when connecting to the provider.  If not specified, the default Go trust sources are used instead.")
+	flagSet.StringSlice("egress-proxy", []string{}, "Proxy to use for requests to the provider for a destination host (host=proxy-url or host=direct). Other requests use the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables")
 	flagSet.String("oidc-issuer-url", "", "OpenID Connect issuer URL (ie: https://accounts.google.com)")
 	flagSet.Bool("insecure-oidc-allow-unverified-email", false, "Don't fail if an email address in an id_token is not verified")
 	flagSet.Bool("insecure-oidc-skip-issuer-verification", false, "Do not verify if issuer matches OIDC discovery URL")
@@ -240,6 +440,13 @@ func NewFlagSet() *pflag.FlagSet {
 	flagSet.String("approval-prompt", "force", "OAuth approval_prompt")
 
 	flagSet.String("signature-key", "", "GAP-Signature request signature key (algorithm:secretkey)")
+	flagSet.String("chained-identity-key", "", "the signature key (algorithm:secretkey) of an oauth2-proxy in front of this one, to accept the identity it passes on as a session instead of logging in again")
+	flagSet.String("chained-identity-format", "jwt", "the identity format of the oauth2-proxy in front of this one: jwt (the signed X-Forwarded-Identity JWT) or headers (the identity headers verified with the GAP-Signature)")
+	flagSet.String("chained-identity-audience", "", "the audience that identity JWTs of the oauth2-proxy in front of this one must be signed for: the scheme and host of this proxy in its upstream URI (required with the jwt chained identity format)")
+	flagSet.Duration("chained-identity-max-lifetime", time.Minute, "how long after it was issued an identity JWT of the oauth2-proxy in front of this one is accepted, whatever its expiry")
+	flagSet.StringSlice("id-token-claims", []string{}, "strip the ID token passed to upstreams down to these claims, re-signed with the --signature-key (may be given multiple times)")
+	flagSet.Duration("published-routes-refresh", time.Duration(1)*time.Minute, "the interval between polls of the routes published by upstreams with publishRoutes set")
+	flagSet.Duration("deny-rule-set-refresh", time.Duration(5)*time.Minute, "the interval between polls of the rule sets fetched with --deny-rule-set-url")
 	flagSet.String("acr-values", "", "acr values string:  optional")
 	flagSet.String("jwt-key", "", "private key in PEM format used to sign JWT, so that you can say something like -jwt-key=\"${OAUTH2_PROXY_JWT_KEY}\": required by login.gov")
 	flagSet.String("jwt-key-file", "", "path to the private key file in PEM format used to sign the JWT so that you can say something like -jwt-key-file=/etc/ssl/private/jwt_signing_key.pem: required by login.gov")
@@ -251,6 +458,7 @@ func NewFlagSet() *pflag.FlagSet {
 
 	flagSet.AddFlagSet(cookieFlagSet())
 	flagSet.AddFlagSet(loggingFlagSet())
+	flagSet.AddFlagSet(telemetryFlagSet())
 
 	return flagSet
 }
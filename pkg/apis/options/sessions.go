@@ -1,10 +1,44 @@
 package options
 
+import "time"
+
 // SessionOptions contains configuration options for the SessionStore providers.
 type SessionOptions struct {
-	Type   string             `flag:"session-store-type" cfg:"session_store_type"`
-	Cookie CookieStoreOptions `cfg:",squash"`
-	Redis  RedisStoreOptions  `cfg:",squash"`
+	Type               string             `flag:"session-store-type" cfg:"session_store_type"`
+	Fingerprint        []string           `flag:"session-fingerprint" cfg:"session_fingerprint"`
+	IntegrityStrict    bool               `flag:"session-integrity-strict" cfg:"session_integrity_strict"`
+	WriteBatchInterval time.Duration      `flag:"session-write-batch-interval" cfg:"session_write_batch_interval"`
+	Encoding           string             `flag:"session-encoding" cfg:"session_encoding"`
+	ClockSkew          time.Duration      `flag:"session-clock-skew" cfg:"session_clock_skew"`
+	LegacyCutoff       string             `flag:"session-legacy-cutoff" cfg:"session_legacy_cutoff"`
+	Cookie             CookieStoreOptions `cfg:",squash"`
+	Redis              RedisStoreOptions  `cfg:",squash"`
+
+	// LoadLatencyBudget is how long loading a session from redis should take.
+	// After LoadBudgetViolations slower loads in a row, sessions are validated
+	// by cookie signature only for the DegradedModeWindow, if set.
+	LoadLatencyBudget    time.Duration `flag:"session-load-latency-budget" cfg:"session_load_latency_budget"`
+	LoadBudgetViolations int           `flag:"session-load-budget-violations" cfg:"session_load_budget_violations"`
+	DegradedModeWindow   time.Duration `flag:"session-degraded-mode-window" cfg:"session_degraded_mode_window"`
+
+	// RefreshBackoff is how long to wait after the provider failed to refresh
+	// a session before refreshing it again, doubling with each failure in a
+	// row up to RefreshBackoffMax. Sessions are used as they are until they
+	// expire in the meantime.
+	RefreshBackoff    time.Duration `flag:"session-refresh-backoff" cfg:"session_refresh_backoff"`
+	RefreshBackoffMax time.Duration `flag:"session-refresh-backoff-max" cfg:"session_refresh_backoff_max"`
+
+	// Claims are the provider specific ID token claims to carry in sessions,
+	// up to ClaimsMaxSize bytes once encoded
+	Claims        []string `flag:"session-claims" cfg:"session_claims"`
+	ClaimsMaxSize int      `flag:"session-claims-max-size" cfg:"session_claims_max_size"`
+
+	// AnnotationHeader is the upstream response header that sets the
+	// annotations of the session with one of the AnnotationKeys, up to
+	// AnnotationsMaxSize bytes
+	AnnotationHeader   string   `flag:"session-annotation-header" cfg:"session_annotation_header"`
+	AnnotationKeys     []string `flag:"session-annotation-keys" cfg:"session_annotation_keys"`
+	AnnotationsMaxSize int      `flag:"session-annotations-max-size" cfg:"session_annotations_max_size"`
 }
 
 // CookieSessionStoreType is used to indicate the CookieSessionStore should be
@@ -15,9 +49,52 @@ var CookieSessionStoreType = "cookie"
 // used for storing sessions.
 var RedisSessionStoreType = "redis"
 
+const (
+	// FingerprintClientIP binds sessions to the IP of the client
+	FingerprintClientIP = "ip"
+
+	// FingerprintUserAgent binds sessions to the User-Agent of the client
+	FingerprintUserAgent = "user-agent"
+)
+
+const (
+	// CookieFormatEncrypted stores sessions encrypted with the cookie secret
+	CookieFormatEncrypted = "encrypted"
+
+	// CookieFormatJWT stores sessions as signed JWTs, that other services can
+	// validate with the JWKS of the proxy
+	CookieFormatJWT = "jwt"
+)
+
 // CookieStoreOptions contains configuration options for the CookieSessionStore.
 type CookieStoreOptions struct {
-	Minimal bool `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
+	Minimal bool     `flag:"session-cookie-minimal" cfg:"session_cookie_minimal"`
+	Fields  []string `flag:"session-cookie-fields" cfg:"session_cookie_fields"`
+
+	Compression          string `flag:"session-cookie-compression" cfg:"session_cookie_compression"`
+	CompressionLevel     int    `flag:"session-cookie-compression-level" cfg:"session_cookie_compression_level"`
+	CompressionThreshold int    `flag:"session-cookie-compression-threshold" cfg:"session_cookie_compression_threshold"`
+
+	// MaxSize is the largest total size of the session Set-Cookie headers
+	// that the edge in front of the proxy passes through
+	MaxSize         int  `flag:"session-cookie-max-size" cfg:"session_cookie_max_size"`
+	OverflowToRedis bool `flag:"session-cookie-overflow-to-redis" cfg:"session_cookie_overflow_to_redis"`
+
+	// SplitTokens stores the OAuth tokens of sessions in redis, and only the
+	// identity in the cookies
+	SplitTokens bool `flag:"session-cookie-split-tokens" cfg:"session_cookie_split_tokens"`
+
+	// SizeWarningThreshold is the size of the session cookies above which a
+	// warning is logged
+	SizeWarningThreshold int `flag:"session-cookie-size-warning-threshold" cfg:"session_cookie_size_warning_threshold"`
+
+	// Format is how sessions are serialized in the cookies. JWT sessions are
+	// signed with the private key in JWTKeyFile for the JWTAudience, and
+	// encrypted with the JWTEncryptionKey if set.
+	Format           string `flag:"session-cookie-format" cfg:"session_cookie_format"`
+	JWTKeyFile       string `flag:"session-cookie-jwt-key-file" cfg:"session_cookie_jwt_key_file"`
+	JWTEncryptionKey string `flag:"session-cookie-jwt-encryption-key" cfg:"session_cookie_jwt_encryption_key"`
+	JWTAudience      string `flag:"session-cookie-jwt-audience" cfg:"session_cookie_jwt_audience"`
 }
 
 // RedisStoreOptions contains configuration options for the RedisSessionStore.
@@ -36,9 +113,17 @@ type RedisStoreOptions struct {
 
 func sessionOptionsDefaults() SessionOptions {
 	return SessionOptions{
-		Type: CookieSessionStoreType,
+		Type:                 CookieSessionStoreType,
+		Encoding:             "msgpack",
+		ClaimsMaxSize:        1024,
+		AnnotationsMaxSize:   512,
+		LoadBudgetViolations: 5,
+		RefreshBackoffMax:    5 * time.Minute,
 		Cookie: CookieStoreOptions{
-			Minimal: false,
+			Minimal:              false,
+			Format:               CookieFormatEncrypted,
+			Compression:          "lz4",
+			SizeWarningThreshold: 4096,
 		},
 	}
 }
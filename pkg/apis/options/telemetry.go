@@ -0,0 +1,61 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// ECSFormat formats SIEM events as Elastic Common Schema JSON documents
+	ECSFormat = "ecs"
+
+	// CEFFormat formats SIEM events in the ArcSight Common Event Format
+	CEFFormat = "cef"
+)
+
+// Telemetry contains the options for streaming authorization decisions to
+// external systems
+type Telemetry struct {
+	SIEM SIEMOptions `cfg:",squash"`
+}
+
+// SIEMOptions contains the options for streaming authorization decisions to
+// a SIEM HTTP endpoint
+type SIEMOptions struct {
+	URL             string        `flag:"siem-url" cfg:"siem_url"`
+	Format          string        `flag:"siem-format" cfg:"siem_format"`
+	AllowSampleRate float64       `flag:"siem-allow-sample-rate" cfg:"siem_allow_sample_rate"`
+	BatchSize       int           `flag:"siem-batch-size" cfg:"siem_batch_size"`
+	FlushInterval   time.Duration `flag:"siem-flush-interval" cfg:"siem_flush_interval"`
+	QueueSize       int           `flag:"siem-queue-size" cfg:"siem_queue_size"`
+	Timeout         time.Duration `flag:"siem-timeout" cfg:"siem_timeout"`
+}
+
+func telemetryFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("telemetry", pflag.ExitOnError)
+
+	flagSet.String("siem-url", "", "the http or https endpoint to stream denied requests to (disabled if empty)")
+	flagSet.String("siem-format", ECSFormat, "the format of the events streamed to the SIEM endpoint (one of: ecs, cef)")
+	flagSet.Float64("siem-allow-sample-rate", 0, "the fraction of allowed authenticated requests to also stream to the SIEM endpoint, between 0 and 1")
+	flagSet.Int("siem-batch-size", 100, "the largest number of events sent to the SIEM endpoint in one request")
+	flagSet.Duration("siem-flush-interval", time.Duration(5)*time.Second, "the longest time events wait before they are sent to the SIEM endpoint")
+	flagSet.Int("siem-queue-size", 10000, "the number of events waiting to be sent to the SIEM endpoint before new events are dropped")
+	flagSet.Duration("siem-timeout", time.Duration(10)*time.Second, "the timeout of each request to the SIEM endpoint")
+
+	return flagSet
+}
+
+// telemetryDefaults creates a Telemetry structure, populating each field with
+// its default value
+func telemetryDefaults() Telemetry {
+	return Telemetry{
+		SIEM: SIEMOptions{
+			Format:        ECSFormat,
+			BatchSize:     100,
+			FlushInterval: time.Duration(5) * time.Second,
+			QueueSize:     10000,
+			Timeout:       time.Duration(10) * time.Second,
+		},
+	}
+}
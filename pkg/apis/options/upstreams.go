@@ -7,6 +7,47 @@ const (
 	DefaultUpstreamFlushInterval = 1 * time.Second
 )
 
+// IdentityFormat determines how the identity of the authenticated user is
+// conveyed to an upstream.
+type IdentityFormat string
+
+const (
+	// IdentityFormatHeaders passes the headers configured in
+	// injectRequestHeaders.
+	IdentityFormatHeaders IdentityFormat = "headers"
+
+	// IdentityFormatIDToken passes the ID token as an Authorization Bearer
+	// token.
+	IdentityFormatIDToken IdentityFormat = "idToken"
+
+	// IdentityFormatAccessToken passes the access token as an Authorization
+	// Bearer token.
+	IdentityFormatAccessToken IdentityFormat = "accessToken"
+
+	// IdentityFormatJWT passes a JWT signed with the signature key in the
+	// X-Forwarded-Identity header.
+	IdentityFormatJWT IdentityFormat = "jwt"
+
+	// IdentityFormatNone does not pass the identity of the user.
+	IdentityFormatNone IdentityFormat = "none"
+)
+
+// The values of UpstreamForwardedFor, which determine how the client IP is
+// passed to upstreams.
+const (
+	// ForwardedForAppend appends the address of the connecting client to any
+	// X-Forwarded-For header of the request.
+	ForwardedForAppend = "append"
+
+	// ForwardedForReplace replaces the X-Forwarded-For header of the request
+	// with the real client IP.
+	ForwardedForReplace = "replace"
+
+	// ForwardedForRFC7239 passes the real client IP in an RFC 7239 Forwarded
+	// header instead of an X-Forwarded-For header.
+	ForwardedForRFC7239 = "forwarded"
+)
+
 // Upstreams is a collection of definitions for upstream servers.
 type Upstreams []Upstream
 
@@ -62,4 +103,22 @@ type Upstream struct {
 	// ProxyWebSockets enables proxying of websockets to upstream servers
 	// Defaults to true.
 	ProxyWebSockets *bool `json:"proxyWebSockets,omitempty"`
+
+	// IdentityFormat determines how the identity of the user is conveyed to
+	// the upstream.
+	// One of "headers", "idToken", "accessToken", "jwt" or "none".
+	// Any format other than "headers" removes the headers configured in
+	// injectRequestHeaders from requests to this upstream.
+	// The "jwt" format requires a signature key to be configured, and the
+	// audience of its JWTs is the scheme and host of the upstream URI.
+	// Defaults to "headers".
+	IdentityFormat IdentityFormat `json:"identityFormat,omitempty"`
+
+	// PublishRoutes enables polling the upstream for the routes it publishes
+	// at /.well-known/oauth2-proxy-routes.json, which are added to the skip
+	// auth routes.
+	// The routes must be signed with the signature key and must be within
+	// the upstream path, which must end in a slash.
+	// This option can only be used with HTTP(S) upstreams.
+	PublishRoutes bool `json:"publishRoutes,omitempty"`
 }
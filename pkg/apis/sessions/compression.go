@@ -0,0 +1,185 @@
+package sessions
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is an algorithm that encoded sessions can be compressed with
+type Compression string
+
+const (
+	// LZ4Compression is the fastest to compress and decompress, and the
+	// only algorithm supported by older versions
+	LZ4Compression Compression = "lz4"
+
+	// ZstdCompression compresses large sessions the most
+	ZstdCompression Compression = "zstd"
+
+	// SnappyCompression is fast, with less compression than zstd
+	SnappyCompression Compression = "snappy"
+
+	// NoCompression never compresses sessions
+	NoCompression Compression = "none"
+)
+
+// IsCompression checks whether the name is a supported compression algorithm
+func IsCompression(name string) bool {
+	switch Compression(name) {
+	case LZ4Compression, ZstdCompression, SnappyCompression, NoCompression:
+		return true
+	default:
+		return false
+	}
+}
+
+// CompressionLevels returns the lowest and highest compression levels of the
+// algorithm. Level 0 is the default level of every algorithm, and snappy has
+// no other levels.
+func CompressionLevels(compression Compression) (int, int) {
+	switch compression {
+	case LZ4Compression:
+		return 0, 9
+	case ZstdCompression:
+		return 0, 22
+	default:
+		return 0, 0
+	}
+}
+
+// The magic numbers that start each compressed format.
+// MessagePack encoded sessions start with a map header instead, so the magic
+// number records whether, and with which algorithm, an encoded session was
+// compressed.
+var (
+	lz4FrameMagic     = []byte{0x04, 0x22, 0x4d, 0x18}
+	zstdFrameMagic    = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyStreamMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// zstdEncoder and zstdDecoder are shared, as EncodeAll and DecodeAll are safe
+// for concurrent use. The encoders of other levels are created when first
+// used.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+	zstdEncoders   sync.Map
+)
+
+// compressAbove compresses the payload with the algorithm if it is at least
+// threshold bytes and compressing it makes it smaller
+func compressAbove(packed []byte, compression Compression, level int, threshold int) ([]byte, error) {
+	if compression == NoCompression || len(packed) < threshold {
+		recordCompression(len(packed), len(packed))
+		return packed, nil
+	}
+
+	compressed, err := compress(packed, compression, level)
+	if err != nil {
+		return nil, err
+	}
+	recordCompression(len(packed), len(compressed))
+	if len(compressed) >= len(packed) {
+		return packed, nil
+	}
+	return compressed, nil
+}
+
+// compress compresses the payload with the algorithm at the compression level
+func compress(payload []byte, compression Compression, level int) ([]byte, error) {
+	if !IsCompression(string(compression)) {
+		return nil, fmt.Errorf("unknown session compression %q", compression)
+	}
+	if min, max := CompressionLevels(compression); level < min || level > max {
+		return nil, fmt.Errorf("session compression level %d of %s must be between %d and %d", level, compression, min, max)
+	}
+
+	switch compression {
+	case LZ4Compression:
+		return lz4Compress(payload, level)
+	case ZstdCompression:
+		encoder, err := zstdEncoderAt(level)
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(payload, nil), nil
+	case SnappyCompression:
+		return snappyCompress(payload)
+	default:
+		return nil, fmt.Errorf("unknown session compression %q", compression)
+	}
+}
+
+// zstdEncoderAt returns the shared zstd encoder of the compression level,
+// where level 0 is the default level
+func zstdEncoderAt(level int) (*zstd.Encoder, error) {
+	if level == 0 {
+		return zstdEncoder, nil
+	}
+	if encoder, ok := zstdEncoders.Load(level); ok {
+		return encoder.(*zstd.Encoder), nil
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd encoder: %w", err)
+	}
+	actual, _ := zstdEncoders.LoadOrStore(level, encoder)
+	return actual.(*zstd.Encoder), nil
+}
+
+// decompress decompresses the payload with the algorithm its magic number
+// shows it was compressed with, or returns it unchanged if it was not
+// compressed
+func decompress(payload []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(payload, lz4FrameMagic):
+		return lz4Decompress(payload)
+	case bytes.HasPrefix(payload, zstdFrameMagic):
+		decompressed, err := zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing zstd frame: %w", err)
+		}
+		return decompressed, nil
+	case bytes.HasPrefix(payload, snappyStreamMagic):
+		return snappyDecompress(payload)
+	default:
+		return payload, nil
+	}
+}
+
+// isCompressed checks whether the payload was compressed with any of the
+// algorithms
+func isCompressed(payload []byte) bool {
+	return bytes.HasPrefix(payload, lz4FrameMagic) ||
+		bytes.HasPrefix(payload, zstdFrameMagic) ||
+		bytes.HasPrefix(payload, snappyStreamMagic)
+}
+
+// snappyCompress compresses with the snappy framing format, which starts with
+// a magic number unlike snappy blocks
+func snappyCompress(payload []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := snappy.NewBufferedWriter(buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, fmt.Errorf("error writing snappy stream: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("error closing snappy writer: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// snappyDecompress decompresses the snappy framing format
+func snappyDecompress(compressed []byte) ([]byte, error) {
+	payload, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading snappy stream: %w", err)
+	}
+	return payload, nil
+}
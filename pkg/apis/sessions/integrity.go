@@ -0,0 +1,126 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrIntegrityCheckFailed is returned when decoding a session whose identity
+// and expiry do not match the integrity MAC it was encoded with, such as a
+// partially corrupted session in a store
+var ErrIntegrityCheckFailed = errors.New("session integrity check failed")
+
+// integrityKeyLabel is the HKDF info that the integrity MAC key is derived
+// from the cookie secret with, so that it differs from the encryption key
+const integrityKeyLabel = "integrity"
+
+// IntegrityCheck is the key of the integrity MAC that sessions are encoded
+// with, and whether sessions without one are rejected when decoded.
+// Sessions with a MAC that does not match are always rejected.
+type IntegrityCheck struct {
+	key    []byte
+	strict bool
+}
+
+// NewIntegrityCheck derives the key of the integrity MAC from the cookie
+// secret with HKDF-SHA256
+func NewIntegrityCheck(secret []byte, strict bool) (*IntegrityCheck, error) {
+	key := make([]byte, sha256.Size)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(integrityKeyLabel)), key)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving the session integrity key: %w", err)
+	}
+	return &IntegrityCheck{key: key, strict: strict}, nil
+}
+
+// integrityCipher is a Cipher that sessions are sealed and verified with the
+// integrity check of
+type integrityCipher struct {
+	encryption.Cipher
+	check *IntegrityCheck
+}
+
+// WithIntegrityCheck returns the cipher with the integrity check, so that
+// sessions encoded with it are sealed with an integrity MAC and sessions
+// decoded with it are verified. Sessions are neither sealed nor verified
+// with other ciphers.
+func WithIntegrityCheck(c encryption.Cipher, check *IntegrityCheck) encryption.Cipher {
+	return &integrityCipher{Cipher: c, check: check}
+}
+
+// integrityCheckOf returns the integrity check of the cipher, if any
+func integrityCheckOf(c encryption.Cipher) *IntegrityCheck {
+	if ic, ok := c.(*integrityCipher); ok {
+		return ic.check
+	}
+	return nil
+}
+
+// sealed returns a copy of the session with the integrity MAC of its current
+// fields, for encoding. Sessions are not sealed without an integrity check.
+func (s *SessionState) sealed(check *IntegrityCheck) *SessionState {
+	sealed := *s
+	sealed.Integrity = nil
+	if check != nil {
+		sealed.Integrity = s.integrityMAC(check.key)
+	}
+	return &sealed
+}
+
+// verifyIntegrity checks the integrity MAC of a decoded session, and clears
+// it so that the decoded session equals the one that was encoded
+func (s *SessionState) verifyIntegrity(check *IntegrityCheck) error {
+	mac := s.Integrity
+	s.Integrity = nil
+	if check == nil {
+		return nil
+	}
+	if len(mac) == 0 {
+		if check.strict {
+			return fmt.Errorf("%w: the session has no integrity MAC", ErrIntegrityCheckFailed)
+		}
+		return nil
+	}
+	if !hmac.Equal(mac, s.integrityMAC(check.key)) {
+		return ErrIntegrityCheckFailed
+	}
+	return nil
+}
+
+// integrityMAC is the HMAC-SHA256 of the identity and expiry of the session.
+// Each field is length prefixed, so that values can't be shifted between
+// fields without changing the MAC.
+func (s *SessionState) integrityMAC(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	writeIntegrityField(mac, s.Email)
+	writeIntegrityField(mac, s.User)
+	writeIntegrityField(mac, integrityTime(s.ExpiresOn))
+	return mac.Sum(nil)
+}
+
+// writeIntegrityField writes a length prefixed field to the MAC
+func writeIntegrityField(mac hash.Hash, value string) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+	mac.Write(length[:])
+	mac.Write([]byte(value))
+}
+
+// integrityTime formats a time for the integrity MAC, in the nanosecond
+// precision that both encodings keep. Nil times are empty.
+func integrityTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,102 @@
+package sessions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	ss := &SessionState{
+		Email:     "username@example.com",
+		User:      "username",
+		ExpiresOn: timePtr(time.Unix(1600000000, 123456789)),
+	}
+
+	withCheck := func(secret string, strict bool) encryption.Cipher {
+		check, err := NewIntegrityCheck([]byte(secret), strict)
+		assert.NoError(t, err)
+		return WithIntegrityCheck(c, check)
+	}
+
+	// encodeRaw encodes a session as it is, without sealing it
+	encodeRaw := func(s *SessionState) []byte {
+		packed, err := msgpack.Marshal(s)
+		assert.NoError(t, err)
+		encrypted, err := c.Encrypt(append([]byte{versionedEnvelopeType, SchemaVersion}, packed...))
+		assert.NoError(t, err)
+		return encrypted
+	}
+
+	t.Run("the MAC key is derived from the secret", func(t *testing.T) {
+		check, err := NewIntegrityCheck([]byte("secret"), false)
+		assert.NoError(t, err)
+		assert.Len(t, check.key, 32)
+		assert.NotEqual(t, []byte("secret"), check.key)
+
+		other, err := NewIntegrityCheck([]byte("secret"), true)
+		assert.NoError(t, err)
+		assert.Equal(t, check.key, other.key)
+	})
+
+	t.Run("sessions decode with their integrity MAC", func(t *testing.T) {
+		sc := withCheck("secret", true)
+		for _, encoding := range []Encoding{MsgpackEncoding, ProtobufEncoding} {
+			encoded, err := ss.EncodeSessionStateAs(sc, encoding)
+			assert.NoError(t, err)
+			decoded, err := DecodeSessionState(encoded, sc, false)
+			assert.NoError(t, err)
+			compareSessionStates(t, ss, decoded)
+			assert.Nil(t, decoded.Integrity)
+		}
+	})
+
+	t.Run("sessions with changed fields are rejected", func(t *testing.T) {
+		check, err := NewIntegrityCheck([]byte("secret"), false)
+		assert.NoError(t, err)
+		for _, change := range []func(*SessionState){
+			func(s *SessionState) { s.Email = "other@example.com" },
+			func(s *SessionState) { s.User = "other" },
+			func(s *SessionState) { s.ExpiresOn = timePtr(s.ExpiresOn.Add(time.Nanosecond)) },
+			func(s *SessionState) { s.Email, s.User = s.Email+s.User, "" },
+		} {
+			sealed := ss.sealed(check)
+			change(sealed)
+			_, err := DecodeSessionState(encodeRaw(sealed), WithIntegrityCheck(c, check), false)
+			assert.Equal(t, ErrIntegrityCheckFailed, err)
+		}
+	})
+
+	t.Run("sessions sealed with another secret are rejected", func(t *testing.T) {
+		encoded, err := ss.EncodeSessionState(withCheck("secret", false), false)
+		assert.NoError(t, err)
+
+		_, err = DecodeSessionState(encoded, withCheck("other secret", false), false)
+		assert.Equal(t, ErrIntegrityCheckFailed, err)
+	})
+
+	t.Run("sessions without a MAC are only rejected when strict", func(t *testing.T) {
+		encoded := encodeRaw(ss)
+
+		decoded, err := DecodeSessionState(encoded, withCheck("secret", false), false)
+		assert.NoError(t, err)
+		compareSessionStates(t, ss, decoded)
+
+		_, err = DecodeSessionState(encoded, withCheck("secret", true), false)
+		assert.True(t, errors.Is(err, ErrIntegrityCheckFailed))
+	})
+
+	t.Run("sessions are not sealed without an integrity check", func(t *testing.T) {
+		encoded, err := ss.EncodeSessionState(c, false)
+		assert.NoError(t, err)
+
+		_, err = DecodeSessionState(encoded, withCheck("secret", true), false)
+		assert.True(t, errors.Is(err, ErrIntegrityCheckFailed))
+	})
+}
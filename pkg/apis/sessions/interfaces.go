@@ -1,7 +1,43 @@
 package sessions
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+)
+
+var (
+	// ErrSignatureNotValid is returned by session stores when the session
+	// cookie was not signed with the cookie secret
+	ErrSignatureNotValid = errors.New("cookie signature not valid")
+
+	// ErrCookieExpired is returned by session stores when the session cookie
+	// is signed but older than the cookie expiry
+	ErrCookieExpired = errors.New("cookie has expired")
+
+	// ErrStoreUnavailable is wrapped by session stores when the backend the
+	// sessions are persisted in cannot be reached. The session may still be
+	// valid once the backend is available again.
+	ErrStoreUnavailable = errors.New("session store unavailable")
+
+	// ErrTokenExpired is wrapped by providers when the provider rejects the
+	// refresh token of a session as expired or revoked, so that the session
+	// can no longer be refreshed
+	ErrTokenExpired = errors.New("token has expired or was revoked")
+
+	// ErrNotAuthorized is wrapped by providers when the user of a session is
+	// no longer authorized, e.g. after being removed from a required group
+	ErrNotAuthorized = errors.New("user is not authorized")
+
+	// ErrLockNotObtained is returned by lockers when the lock is already held
+	ErrLockNotObtained = errors.New("lock is held by another request")
+
+	// ErrCodeNotFound is returned by code stores when the code was never
+	// saved, has expired or was already taken
+	ErrCodeNotFound = errors.New("code not found")
 )
 
 // SessionStore is an interface to storing user sessions in the proxy
@@ -10,3 +46,40 @@ type SessionStore interface {
 	Load(req *http.Request) (*SessionState, error)
 	Clear(rw http.ResponseWriter, req *http.Request) error
 }
+
+// Locker locks sessions by key while they are refreshed, so that concurrent
+// requests with the same session do not each redeem its refresh token
+type Locker interface {
+	// Obtain obtains the lock with the key, which expires after the
+	// expiration unless it is released first by calling the returned function.
+	// It returns ErrLockNotObtained if the lock is already held.
+	Obtain(ctx context.Context, key string, expiration time.Duration) (func(), error)
+
+	// Peek checks whether the lock with the key is held
+	Peek(ctx context.Context, key string) (bool, error)
+}
+
+// CodeStore stores values under single use codes, such as the sessions an
+// auth domain hands off to applications
+type CodeStore interface {
+	// Save stores the value under the code until the expiration
+	Save(ctx context.Context, code string, value []byte, expiration time.Duration) error
+
+	// Take returns the value of the code and removes it, so that each code
+	// can only be taken once.
+	// It returns ErrCodeNotFound if there is no value for the code.
+	Take(ctx context.Context, code string) ([]byte, error)
+}
+
+// ValidateCookie checks the signature and age of a session cookie and returns
+// its value, or ErrSignatureNotValid or ErrCookieExpired
+func ValidateCookie(c *http.Cookie, secret string, expire time.Duration) ([]byte, error) {
+	value, _, ok := encryption.Validate(c, secret, expire)
+	if ok {
+		return value, nil
+	}
+	if _, _, signed := encryption.ParseSignedValue(c, secret); signed {
+		return nil, ErrCookieExpired
+	}
+	return nil, ErrSignatureNotValid
+}
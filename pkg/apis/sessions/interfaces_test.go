@@ -0,0 +1,29 @@
+package sessions
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCookie(t *testing.T) {
+	const secret = "0123456789abcdef"
+	signed := func(seed string, created time.Time) *http.Cookie {
+		value, err := encryption.SignedValue(seed, "_oauth2_proxy", []byte("session"), created)
+		assert.NoError(t, err)
+		return &http.Cookie{Name: "_oauth2_proxy", Value: value}
+	}
+
+	value, err := ValidateCookie(signed(secret, time.Now()), secret, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("session"), value)
+
+	_, err = ValidateCookie(signed(secret, time.Now().Add(-2*time.Hour)), secret, time.Hour)
+	assert.Equal(t, ErrCookieExpired, err)
+
+	_, err = ValidateCookie(signed("fedcba9876543210", time.Now()), secret, time.Hour)
+	assert.Equal(t, ErrSignatureNotValid, err)
+}
@@ -0,0 +1,224 @@
+package sessions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// jwtNotBeforeLeeway is the clock skew allowed between the instances that
+// encode and decode session JWTs, for their "nbf" claim
+const jwtNotBeforeLeeway = time.Minute
+
+// JWTKeys sign, and optionally encrypt, sessions encoded as JWTs, so that
+// other services can validate them with the PublicKeySet
+type JWTKeys struct {
+	signer        jose.Signer
+	publicKey     jose.JSONWebKey
+	encrypter     jose.Encrypter
+	encryptionKey []byte
+}
+
+// NewJWTKeys creates the JWTKeys of a PEM encoded RSA or ECDSA private key.
+// If the encryption key is set, sessions are also encrypted with it, which
+// takes 16, 24 or 32 bytes for AES-128, AES-192 or AES-256 GCM.
+func NewJWTKeys(privateKey []byte, encryptionKey []byte) (*JWTKeys, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key ID is the thumbprint of the public key, so that it changes
+	// with the key
+	publicKey := jose.JSONWebKey{Key: key.Public(), Algorithm: string(algorithm), Use: "sig"}
+	thumbprint, err := publicKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("error computing the session JWT key ID: %v", err)
+	}
+	publicKey.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: jose.JSONWebKey{Key: key, KeyID: publicKey.KeyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the session JWT signer: %v", err)
+	}
+
+	keys := &JWTKeys{signer: signer, publicKey: publicKey}
+	if len(encryptionKey) == 0 {
+		return keys, nil
+	}
+
+	var encryption jose.ContentEncryption
+	switch len(encryptionKey) {
+	case 16:
+		encryption = jose.A128GCM
+	case 24:
+		encryption = jose.A192GCM
+	case 32:
+		encryption = jose.A256GCM
+	default:
+		return nil, fmt.Errorf("the session JWT encryption key must be 16, 24 or 32 bytes, not %d", len(encryptionKey))
+	}
+	keys.encrypter, err = jose.NewEncrypter(
+		encryption,
+		jose.Recipient{Algorithm: jose.DIRECT, Key: encryptionKey},
+		(&jose.EncrypterOptions{}).WithType("JWT").WithContentType("JWT"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the session JWT encrypter: %v", err)
+	}
+	keys.encryptionKey = encryptionKey
+	return keys, nil
+}
+
+// PublicKeySet is the JWKS that sessions encoded as JWTs are validated with
+func (k *JWTKeys) PublicKeySet() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{k.publicKey}}
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1 RSA, SEC 1 EC or PKCS #8
+// private key
+func parsePrivateKey(privateKey []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("the session JWT key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the session JWT key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the session JWT key of type %T cannot sign", key)
+	}
+	return signer, nil
+}
+
+// signatureAlgorithm is the JWS algorithm sessions are signed with by the key
+func signatureAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return jose.ES256, nil
+		case 384:
+			return jose.ES384, nil
+		case 521:
+			return jose.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported session JWT key curve %s", key.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported session JWT key of type %T, it must be an RSA or ECDSA key", key)
+	}
+}
+
+// EncodeSessionStateJWT returns the session as a JWT signed, and encrypted if
+// enabled, with the keys, for the audience and valid until the expiry. The
+// session fields are claims by their claim names, along with the user as the
+// "sub" claim and the creation of the session as the "iat" and "nbf" claims.
+// The expiry is the "exp" claim, while the expiry of the session tokens is
+// the "expires_on" claim.
+func (s *SessionState) EncodeSessionStateJWT(keys *JWTKeys, audience string, expiry time.Time) (string, error) {
+	standard := jwt.Claims{
+		Subject:  s.User,
+		Audience: jwt.Audience{audience},
+		Expiry:   jwt.NewNumericDate(expiry),
+	}
+	if s.CreatedAt != nil {
+		standard.IssuedAt = jwt.NewNumericDate(*s.CreatedAt)
+		standard.NotBefore = jwt.NewNumericDate(*s.CreatedAt)
+	}
+
+	var token string
+	var err error
+	if keys.encrypter != nil {
+		token, err = jwt.SignedAndEncrypted(keys.signer, keys.encrypter).Claims(standard).Claims(s).CompactSerialize()
+	} else {
+		token, err = jwt.Signed(keys.signer).Claims(standard).Claims(s).CompactSerialize()
+	}
+	if err != nil {
+		return "", fmt.Errorf("error encoding session state as a JWT: %w", err)
+	}
+	return token, nil
+}
+
+// DecodeSessionStateJWT decodes a session encoded by EncodeSessionStateJWT
+// with the keys, if it is for the audience and valid at the time.
+// Sessions past their "exp" claim return ErrCookieExpired. The expiry of the
+// session tokens is not checked, so that their sessions can be refreshed.
+func DecodeSessionStateJWT(token string, keys *JWTKeys, audience string, now time.Time) (*SessionState, error) {
+	var signed *jwt.JSONWebToken
+	var err error
+	if keys.encrypter != nil {
+		var nested *jwt.NestedJSONWebToken
+		nested, err = jwt.ParseSignedAndEncrypted(token)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the session JWT: %w", err)
+		}
+		signed, err = nested.Decrypt(keys.encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting the session JWT: %w", err)
+		}
+	} else {
+		signed, err = jwt.ParseSigned(token)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the session JWT: %w", err)
+		}
+	}
+
+	standard := jwt.Claims{}
+	ss := &SessionState{}
+	if err := signed.Claims(keys.publicKey.Key, &standard, ss); err != nil {
+		return nil, fmt.Errorf("error validating the session JWT: %w", err)
+	}
+	if err := validateJWTClaims(standard, audience, now); err != nil {
+		return nil, err
+	}
+	if err := ss.validate(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// validateJWTClaims checks that a session JWT is for the audience and valid
+// at the time. Unlike jwt.Claims.Validate, the "exp" and "nbf" claims are
+// required, and only the "nbf" claim has a leeway for clock skew.
+func validateJWTClaims(claims jwt.Claims, audience string, now time.Time) error {
+	if claims.Expiry == nil || !now.Before(claims.Expiry.Time()) {
+		return ErrCookieExpired
+	}
+	if claims.NotBefore == nil || now.Add(jwtNotBeforeLeeway).Before(claims.NotBefore.Time()) {
+		return errors.New("the session JWT is not valid yet")
+	}
+	if !claims.Audience.Contains(audience) {
+		return fmt.Errorf("the session JWT audience %q is not %q", claims.Audience, audience)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package sessions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func newTestJWTKey(t *testing.T, curve elliptic.Curve) []byte {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestNewJWTKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		privateKey    []byte
+		encryptionKey []byte
+		algorithm     string
+		err           string
+	}{
+		{"PKCS #1 RSA key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil, "RS256", ""},
+		{"PKCS #8 RSA key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), nil, "RS256", ""},
+		{"P-256 key", newTestJWTKey(t, elliptic.P256()), nil, "ES256", ""},
+		{"P-384 key", newTestJWTKey(t, elliptic.P384()), []byte("0123456789abcdef"), "ES384", ""},
+		{"not PEM", []byte("secret"), nil, "", "the session JWT key is not PEM encoded"},
+		{"short encryption key", newTestJWTKey(t, elliptic.P256()), []byte("secret"), "", "the session JWT encryption key must be 16, 24 or 32 bytes, not 6"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := NewJWTKeys(tc.privateKey, tc.encryptionKey)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			jwks := keys.PublicKeySet()
+			assert.Len(t, jwks.Keys, 1)
+			assert.Equal(t, tc.algorithm, jwks.Keys[0].Algorithm)
+			_, private := jwks.Keys[0].Key.(crypto.Signer)
+			assert.False(t, private)
+			assert.NotEmpty(t, jwks.Keys[0].KeyID)
+		})
+	}
+}
+
+func TestEncodeAndDecodeSessionStateJWT(t *testing.T) {
+	created := time.Unix(1600000000, 0)
+	expires := created.Add(time.Hour)
+	cookieExpiry := created.Add(7 * 24 * time.Hour)
+	now := created.Add(2 * time.Hour)
+	ss := &SessionState{
+		CreatedAt:   &created,
+		ExpiresOn:   &expires,
+		AccessToken: "access.token",
+		Email:       "username@example.com",
+		User:        "username",
+		Groups:      []string{"admins"},
+	}
+
+	for _, encryptionKey := range [][]byte{nil, []byte("0123456789abcdef0123456789abcdef")} {
+		keys, err := NewJWTKeys(newTestJWTKey(t, elliptic.P256()), encryptionKey)
+		assert.NoError(t, err)
+
+		token, err := ss.EncodeSessionStateJWT(keys, "sidecars", cookieExpiry)
+		assert.NoError(t, err)
+		// Sessions with expired tokens decode, so that they can be refreshed
+		decoded, err := DecodeSessionStateJWT(token, keys, "sidecars", now)
+		assert.NoError(t, err)
+		compareSessionStates(t, ss, decoded)
+
+		// The session can't be decoded with other keys
+		otherKeys, err := NewJWTKeys(newTestJWTKey(t, elliptic.P256()), encryptionKey)
+		assert.NoError(t, err)
+		_, err = DecodeSessionStateJWT(token, otherKeys, "sidecars", now)
+		assert.Error(t, err)
+
+		// The session is only valid for its audience, from its creation until
+		// its expiry
+		_, err = DecodeSessionStateJWT(token, keys, "other", now)
+		assert.EqualError(t, err, `the session JWT audience ["sidecars"] is not "other"`)
+		_, err = DecodeSessionStateJWT(token, keys, "sidecars", created.Add(-2*time.Minute))
+		assert.EqualError(t, err, "the session JWT is not valid yet")
+		_, err = DecodeSessionStateJWT(token, keys, "sidecars", cookieExpiry)
+		assert.Equal(t, ErrCookieExpired, err)
+
+		if encryptionKey != nil {
+			continue
+		}
+		// Signed sessions can be validated with the JWKS alone
+		parsed, err := jwt.ParseSigned(token)
+		assert.NoError(t, err)
+		claims := jwt.Claims{}
+		custom := map[string]interface{}{}
+		assert.NoError(t, parsed.Claims(keys.PublicKeySet().Keys[0].Key, &claims, &custom))
+		assert.Equal(t, "username", claims.Subject)
+		assert.Equal(t, jwt.Audience{"sidecars"}, claims.Audience)
+		assert.Equal(t, cookieExpiry, claims.Expiry.Time())
+		assert.Equal(t, created, claims.IssuedAt.Time())
+		assert.Equal(t, created, claims.NotBefore.Time())
+		assert.Equal(t, "username@example.com", custom["email"])
+	}
+}
+
+func TestDecodeSessionStateJWTRequiresExpiry(t *testing.T) {
+	created := time.Unix(1600000000, 0)
+	keys, err := NewJWTKeys(newTestJWTKey(t, elliptic.P256()), nil)
+	assert.NoError(t, err)
+
+	// A JWT signed with the keys, but without an "exp" claim
+	token, err := jwt.Signed(keys.signer).Claims(jwt.Claims{
+		Subject:   "username",
+		Audience:  jwt.Audience{"sidecars"},
+		NotBefore: jwt.NewNumericDate(created),
+	}).CompactSerialize()
+	assert.NoError(t, err)
+	_, err = DecodeSessionStateJWT(token, keys, "sidecars", created.Add(time.Hour))
+	assert.Equal(t, ErrCookieExpired, err)
+}
@@ -0,0 +1,71 @@
+package sessions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Legacy sessions are the MessagePack sessions encoded before sessions had a
+// schema version, lz4 compressed or not. They are decoded until the legacy
+// cutoff, and refused if there is no cutoff, so that the legacy decoding
+// can be removed once they stop being loaded.
+var (
+	legacyCutoffMutex sync.RWMutex
+	legacyCutoff      time.Time
+)
+
+// SetLegacyCutoff sets the time until which legacy sessions are decoded.
+// Legacy sessions are refused if the cutoff is the zero time.
+func SetLegacyCutoff(cutoff time.Time) {
+	legacyCutoffMutex.Lock()
+	defer legacyCutoffMutex.Unlock()
+	legacyCutoff = cutoff
+}
+
+// ParseLegacyCutoff parses the time until which legacy sessions are decoded,
+// given as a date, which is midnight UTC, or an RFC3339 time.
+// The cutoff is the zero time if it is empty.
+func ParseLegacyCutoff(cutoff string) (time.Time, error) {
+	if cutoff == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", cutoff); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, cutoff)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("session_legacy_cutoff (%s) must be a date, e.g. 2006-01-02, or an RFC3339 time", cutoff)
+	}
+	return t, nil
+}
+
+// decodeLegacy decodes a legacy session, given decrypted and decompressed,
+// if the legacy cutoff is set and has not passed, and counts whether it was
+// decoded or refused
+func decodeLegacy(decrypted, packed []byte, now time.Time) (*SessionState, error) {
+	// Older versions only compressed sessions with lz4
+	if isCompressed(decrypted) && !bytes.HasPrefix(decrypted, lz4FrameMagic) {
+		return nil, errors.New("legacy sessions are only compressed with lz4")
+	}
+	ss, err := unmarshalMsgpack(packed)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling legacy session: %w", err)
+	}
+
+	legacyCutoffMutex.RLock()
+	cutoff := legacyCutoff
+	legacyCutoffMutex.RUnlock()
+	if cutoff.IsZero() {
+		recordLegacyDecode(true)
+		return nil, errors.New("legacy sessions are refused without a session_legacy_cutoff")
+	}
+	if !now.Before(cutoff) {
+		recordLegacyDecode(true)
+		return nil, fmt.Errorf("legacy sessions are refused since %s", cutoff.Format(time.RFC3339))
+	}
+	recordLegacyDecode(false)
+	return ss, nil
+}
@@ -0,0 +1,95 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestLegacySessions(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	created := time.Now().Truncate(time.Second)
+	ss := &SessionState{CreatedAt: &created, Email: "username@example.com", User: "username"}
+
+	// Older versions encoded sessions with MessagePack and no schema version,
+	// compressed with lz4 in cookies
+	packed, err := msgpack.Marshal(ss)
+	assert.NoError(t, err)
+	compressed, err := lz4Compress(packed, 0)
+	assert.NoError(t, err)
+	legacy, err := c.Encrypt(compressed)
+	assert.NoError(t, err)
+	legacyUncompressed, err := c.Encrypt(packed)
+	assert.NoError(t, err)
+
+	defer SetLegacyCutoff(time.Time{})
+	decodedBefore := testutil.ToFloat64(legacyDecodes.WithLabelValues(legacyDecoded))
+	refusedBefore := testutil.ToFloat64(legacyDecodes.WithLabelValues(legacyRefused))
+
+	t.Run("refused without a cutoff", func(t *testing.T) {
+		SetLegacyCutoff(time.Time{})
+		_, err := DecodeSessionState(legacy, c, true)
+		assert.EqualError(t, err, "legacy sessions are refused without a session_legacy_cutoff")
+	})
+
+	t.Run("decoded until the cutoff", func(t *testing.T) {
+		SetLegacyCutoff(time.Now().Add(time.Hour))
+		decoded, err := DecodeSessionState(legacy, c, true)
+		assert.NoError(t, err)
+		assert.Equal(t, ss.Email, decoded.Email)
+		assert.Equal(t, ss.User, decoded.User)
+		assert.True(t, created.Equal(*decoded.CreatedAt))
+
+		decoded, err = DecodeSessionState(legacyUncompressed, c, false)
+		assert.NoError(t, err)
+		assert.Equal(t, ss.Email, decoded.Email)
+	})
+
+	t.Run("refused after the cutoff", func(t *testing.T) {
+		SetLegacyCutoff(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		_, err := DecodeSessionState(legacy, c, true)
+		assert.EqualError(t, err, "legacy sessions are refused since 2020-01-01T00:00:00Z")
+
+		// Current sessions are still decoded after the cutoff
+		encoded, err := ss.EncodeSessionState(c, true)
+		assert.NoError(t, err)
+		decoded, err := DecodeSessionState(encoded, c, true)
+		assert.NoError(t, err)
+		assert.Equal(t, ss.Email, decoded.Email)
+	})
+
+	t.Run("only lz4 compressed", func(t *testing.T) {
+		SetLegacyCutoff(time.Now().Add(time.Hour))
+		zstdCompressed, err := compress(packed, ZstdCompression, 0)
+		assert.NoError(t, err)
+		encrypted, err := c.Encrypt(zstdCompressed)
+		assert.NoError(t, err)
+		_, err = DecodeSessionState(encrypted, c, true)
+		assert.EqualError(t, err, "legacy sessions are only compressed with lz4")
+	})
+
+	assert.Equal(t, decodedBefore+2, testutil.ToFloat64(legacyDecodes.WithLabelValues(legacyDecoded)))
+	assert.Equal(t, refusedBefore+2, testutil.ToFloat64(legacyDecodes.WithLabelValues(legacyRefused)))
+}
+
+func TestParseLegacyCutoff(t *testing.T) {
+	cutoff, err := ParseLegacyCutoff("")
+	assert.NoError(t, err)
+	assert.True(t, cutoff.IsZero())
+
+	cutoff, err = ParseLegacyCutoff("2021-06-30")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 6, 30, 0, 0, 0, 0, time.UTC), cutoff)
+
+	cutoff, err = ParseLegacyCutoff("2021-06-30T12:00:00+02:00")
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2021, 6, 30, 10, 0, 0, 0, time.UTC).Equal(cutoff))
+
+	_, err = ParseLegacyCutoff("30/06/2021")
+	assert.EqualError(t, err, "session_legacy_cutoff (30/06/2021) must be a date, e.g. 2006-01-02, or an RFC3339 time")
+}
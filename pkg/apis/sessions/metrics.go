@@ -0,0 +1,105 @@
+package sessions
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sessionSizeBuckets are the upper bounds in bytes of the session size
+// histogram buckets. Browsers limit each cookie to 4096 bytes.
+var sessionSizeBuckets = []float64{256, 512, 1024, 2048, 4096, 8192, 16384}
+
+// The stages of encoding a session that its size is recorded at
+const (
+	encodedStage    = "encoded"
+	compressedStage = "compressed"
+	encryptedStage  = "encrypted"
+	cookieStage     = "cookie"
+)
+
+// The results of loading legacy sessions
+const (
+	legacyDecoded = "decoded"
+	legacyRefused = "refused"
+)
+
+var (
+	sessionSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "oauth2_proxy_session_size_bytes",
+		Help:    "The size of encoded sessions before compression, after compression, after encryption, and of the cookies of cookie sessions.",
+		Buckets: sessionSizeBuckets,
+	}, []string{"stage"})
+
+	sessionEncodings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth2_proxy_session_encodings_total",
+		Help: "The number of sessions encoded with compression enabled, by whether they were compressed.",
+	}, []string{"compressed"})
+
+	compressionInputBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_proxy_session_compression_input_bytes_total",
+		Help: "The size of compressed sessions before compression.",
+	})
+
+	compressionSavedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oauth2_proxy_session_compression_saved_bytes_total",
+		Help: "The number of bytes saved by compressing sessions.",
+	})
+
+	legacyDecodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth2_proxy_session_legacy_decodes_total",
+		Help: "The number of legacy sessions, encoded before sessions had a schema version, that were loaded, by whether they were decoded or refused.",
+	}, []string{"result"})
+)
+
+func init() {
+	// Every series is exported from the start, so that rates can be
+	// computed from the first sessions encoded
+	for _, stage := range []string{encodedStage, compressedStage, encryptedStage, cookieStage} {
+		sessionSizeBytes.WithLabelValues(stage)
+	}
+	sessionEncodings.WithLabelValues("true")
+	sessionEncodings.WithLabelValues("false")
+	legacyDecodes.WithLabelValues(legacyDecoded)
+	legacyDecodes.WithLabelValues(legacyRefused)
+}
+
+// MetricsCollectors returns the collectors of the compression and the size of
+// encoded sessions, and of the legacy sessions loaded
+func MetricsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{sessionSizeBytes, sessionEncodings, compressionInputBytes, compressionSavedBytes, legacyDecodes}
+}
+
+// recordCompression counts an encoded session by whether it was compressed,
+// and the bytes saved if it was
+func recordCompression(packed, compressed int) {
+	if compressed >= packed {
+		sessionEncodings.WithLabelValues("false").Inc()
+		return
+	}
+	sessionEncodings.WithLabelValues("true").Inc()
+	compressionInputBytes.Add(float64(packed))
+	compressionSavedBytes.Add(float64(packed - compressed))
+}
+
+// recordSessionSizes records the size of an encoded session before
+// compression, after compression, and after encryption
+func recordSessionSizes(encoded, compressed, encrypted int) {
+	sessionSizeBytes.WithLabelValues(encodedStage).Observe(float64(encoded))
+	sessionSizeBytes.WithLabelValues(compressedStage).Observe(float64(compressed))
+	sessionSizeBytes.WithLabelValues(encryptedStage).Observe(float64(encrypted))
+}
+
+// ObserveCookieSessionSize records the total size of the cookies of a session
+// in the cookie session store
+func ObserveCookieSessionSize(size int) {
+	sessionSizeBytes.WithLabelValues(cookieStage).Observe(float64(size))
+}
+
+// recordLegacyDecode counts a legacy session that was loaded, by whether it
+// was refused
+func recordLegacyDecode(refused bool) {
+	if refused {
+		legacyDecodes.WithLabelValues(legacyRefused).Inc()
+		return
+	}
+	legacyDecodes.WithLabelValues(legacyDecoded).Inc()
+}
@@ -0,0 +1,333 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Encoding is a serialization format of encoded sessions
+type Encoding string
+
+const (
+	// MsgpackEncoding is the compact default format, versioned by
+	// SchemaVersion
+	MsgpackEncoding Encoding = "msgpack"
+
+	// ProtobufEncoding is for other services that read the sessions in
+	// redis. The schema is documented with the redis session store.
+	ProtobufEncoding Encoding = "protobuf"
+)
+
+// IsEncoding checks whether the name is a supported session encoding
+func IsEncoding(name string) bool {
+	switch Encoding(name) {
+	case MsgpackEncoding, ProtobufEncoding:
+		return true
+	default:
+		return false
+	}
+}
+
+// protobufEnvelopeType is the type byte before protobuf encoded sessions.
+// Protobuf sessions have no schema version, as new fields get new field
+// numbers and unknown fields are skipped.
+const protobufEnvelopeType byte = 0x01
+
+// The field numbers of the protobuf SessionState message. 11 held the TLS
+// binding of a removed option, and is not reused.
+const (
+	protobufCreatedAt         protowire.Number = 1
+	protobufExpiresOn         protowire.Number = 2
+	protobufAccessToken       protowire.Number = 3
+	protobufIDToken           protowire.Number = 4
+	protobufRefreshToken      protowire.Number = 5
+	protobufEmail             protowire.Number = 6
+	protobufUser              protowire.Number = 7
+	protobufGroups            protowire.Number = 8
+	protobufPreferredUsername protowire.Number = 9
+	protobufClaims            protowire.Number = 10
+	protobufAnnotations       protowire.Number = 12
+	protobufFingerprint       protowire.Number = 13
+	protobufProviderData      protowire.Number = 14
+	protobufIntegrity         protowire.Number = 15
+	protobufLastRefresh       protowire.Number = 16
+	protobufRefreshFailures   protowire.Number = 17
+)
+
+// The field numbers of the map entries of the annotations and the provider
+// data
+const (
+	protobufKey   protowire.Number = 1
+	protobufValue protowire.Number = 2
+)
+
+// The field numbers of the google.protobuf.Timestamp message
+const (
+	protobufSeconds protowire.Number = 1
+	protobufNanos   protowire.Number = 2
+)
+
+// isProtobuf checks whether an encoded session has the protobuf type byte
+func isProtobuf(packed []byte) bool {
+	return len(packed) > 0 && packed[0] == protobufEnvelopeType
+}
+
+// marshalProtobuf encodes the session as a protobuf SessionState message
+// after the protobuf type byte, with its integrity MAC if sealed. Claims are encoded as
+// a JSON object.
+func (s *SessionState) marshalProtobuf() ([]byte, error) {
+	b := []byte{protobufEnvelopeType}
+	b = appendProtobufTime(b, protobufCreatedAt, s.CreatedAt)
+	b = appendProtobufTime(b, protobufExpiresOn, s.ExpiresOn)
+	b = appendProtobufString(b, protobufAccessToken, s.AccessToken)
+	b = appendProtobufString(b, protobufIDToken, s.IDToken)
+	b = appendProtobufString(b, protobufRefreshToken, s.RefreshToken)
+	b = appendProtobufString(b, protobufEmail, s.Email)
+	b = appendProtobufString(b, protobufUser, s.User)
+	for _, group := range s.Groups {
+		b = protowire.AppendTag(b, protobufGroups, protowire.BytesType)
+		b = protowire.AppendString(b, group)
+	}
+	b = appendProtobufString(b, protobufPreferredUsername, s.PreferredUsername)
+	if len(s.Claims) > 0 {
+		claims, err := json.Marshal(s.Claims)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling session claims to JSON: %w", err)
+		}
+		b = protowire.AppendTag(b, protobufClaims, protowire.BytesType)
+		b = protowire.AppendBytes(b, claims)
+	}
+
+	// Map entries are written in key order, so that sessions encode the same
+	keys := make([]string, 0, len(s.Annotations))
+	for key := range s.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = appendProtobufString(entry, protobufKey, key)
+		entry = appendProtobufString(entry, protobufValue, s.Annotations[key])
+		b = protowire.AppendTag(b, protobufAnnotations, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = appendProtobufString(b, protobufFingerprint, s.Fingerprint)
+
+	keys = keys[:0]
+	for key := range s.ProviderData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = appendProtobufString(entry, protobufKey, key)
+		entry = protowire.AppendTag(entry, protobufValue, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, s.ProviderData[key])
+		b = protowire.AppendTag(b, protobufProviderData, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if len(s.Integrity) > 0 {
+		b = protowire.AppendTag(b, protobufIntegrity, protowire.BytesType)
+		b = protowire.AppendBytes(b, s.Integrity)
+	}
+	b = appendProtobufTime(b, protobufLastRefresh, s.LastRefreshAttempt)
+	if s.RefreshFailures > 0 {
+		b = protowire.AppendTag(b, protobufRefreshFailures, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(s.RefreshFailures))
+	}
+	return b, nil
+}
+
+// unmarshalProtobuf decodes a session encoded by marshalProtobuf, skipping
+// unknown fields
+func unmarshalProtobuf(packed []byte) (*SessionState, error) {
+	if !isProtobuf(packed) {
+		return nil, errors.New("missing protobuf type byte")
+	}
+	b := packed[1:]
+
+	ss := &SessionState{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == protobufRefreshFailures && typ == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			ss.RefreshFailures = int(value)
+			continue
+		}
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var err error
+		switch num {
+		case protobufCreatedAt:
+			ss.CreatedAt, err = parseProtobufTime(value)
+		case protobufExpiresOn:
+			ss.ExpiresOn, err = parseProtobufTime(value)
+		case protobufAccessToken:
+			ss.AccessToken = string(value)
+		case protobufIDToken:
+			ss.IDToken = string(value)
+		case protobufRefreshToken:
+			ss.RefreshToken = string(value)
+		case protobufEmail:
+			ss.Email = string(value)
+		case protobufUser:
+			ss.User = string(value)
+		case protobufGroups:
+			ss.Groups = append(ss.Groups, string(value))
+		case protobufPreferredUsername:
+			ss.PreferredUsername = string(value)
+		case protobufClaims:
+			err = json.Unmarshal(value, &ss.Claims)
+		case protobufAnnotations:
+			var key string
+			var annotation []byte
+			key, annotation, err = parseProtobufMapEntry(value)
+			if err == nil {
+				if ss.Annotations == nil {
+					ss.Annotations = map[string]string{}
+				}
+				ss.Annotations[key] = string(annotation)
+			}
+		case protobufFingerprint:
+			ss.Fingerprint = string(value)
+		case protobufProviderData:
+			var key string
+			var data []byte
+			key, data, err = parseProtobufMapEntry(value)
+			if err == nil {
+				if ss.ProviderData == nil {
+					ss.ProviderData = map[string][]byte{}
+				}
+				ss.ProviderData[key] = data
+			}
+		case protobufIntegrity:
+			ss.Integrity = append([]byte{}, value...)
+		case protobufLastRefresh:
+			ss.LastRefreshAttempt, err = parseProtobufTime(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling protobuf field %d: %w", num, err)
+		}
+	}
+	return ss, nil
+}
+
+// parseProtobufMapEntry parses a map entry with a string key and a string or
+// bytes value
+func parseProtobufMapEntry(b []byte) (string, []byte, error) {
+	var key string
+	var value []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return "", nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		field, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case protobufKey:
+			key = string(field)
+		case protobufValue:
+			value = append([]byte{}, field...)
+		}
+	}
+	return key, value, nil
+}
+
+// appendProtobufString appends a string field, unless it is empty
+func appendProtobufString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+// appendProtobufTime appends a google.protobuf.Timestamp field, unless the
+// time is nil
+func appendProtobufTime(b []byte, num protowire.Number, t *time.Time) []byte {
+	if t == nil {
+		return b
+	}
+	var timestamp []byte
+	timestamp = protowire.AppendTag(timestamp, protobufSeconds, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(t.Unix()))
+	timestamp = protowire.AppendTag(timestamp, protobufNanos, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(t.Nanosecond()))
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, timestamp)
+}
+
+// parseProtobufTime parses a google.protobuf.Timestamp message
+func parseProtobufTime(b []byte) (*time.Time, error) {
+	var seconds, nanos int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case protobufSeconds:
+			seconds = int64(value)
+		case protobufNanos:
+			nanos = int64(int32(value))
+		}
+	}
+	t := time.Unix(seconds, nanos)
+	return &t, nil
+}
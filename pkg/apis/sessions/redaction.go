@@ -0,0 +1,89 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// Redaction is how the identity fields of sessions, such as email addresses
+// and user names, are written to logs
+type Redaction string
+
+const (
+	// NoRedaction logs identities as they are
+	NoRedaction Redaction = "none"
+
+	// MaskRedaction logs the first character of identities, and the domain
+	// of email addresses
+	MaskRedaction Redaction = "mask"
+
+	// HashRedaction logs a keyed hash of identities, so that the log lines
+	// of a user can be correlated without revealing who they are
+	HashRedaction Redaction = "hash"
+)
+
+// hashedIdentityLength is the number of hex characters of the hash of
+// redacted identities
+const hashedIdentityLength = 16
+
+// IsRedaction checks whether the redaction is supported
+func IsRedaction(redaction string) bool {
+	switch Redaction(redaction) {
+	case NoRedaction, MaskRedaction, HashRedaction:
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	redactionMutex sync.RWMutex
+	redaction      = NoRedaction
+	redactionKey   []byte
+)
+
+// SetIdentityRedaction sets how identities are redacted in logs. The key is
+// used to hash identities with the hash redaction.
+func SetIdentityRedaction(r Redaction, key []byte) {
+	redactionMutex.Lock()
+	defer redactionMutex.Unlock()
+	redaction = r
+	redactionKey = key
+}
+
+// RedactIdentity redacts an email address or user name for logging
+func RedactIdentity(identity string) string {
+	redactionMutex.RLock()
+	defer redactionMutex.RUnlock()
+	if identity == "" {
+		return identity
+	}
+
+	switch redaction {
+	case MaskRedaction:
+		return maskIdentity(identity)
+	case HashRedaction:
+		mac := hmac.New(sha256.New, redactionKey)
+		mac.Write([]byte(identity))
+		return "h:" + hex.EncodeToString(mac.Sum(nil))[:hashedIdentityLength]
+	default:
+		return identity
+	}
+}
+
+// maskIdentity keeps the first character of an identity, and the domain of
+// an email address
+func maskIdentity(identity string) string {
+	masked := ""
+	for _, first := range identity {
+		masked = string(first) + "***"
+		break
+	}
+	if at := strings.LastIndex(identity, "@"); at >= 0 {
+		masked += identity[at:]
+	}
+	return masked
+}
@@ -0,0 +1,44 @@
+package sessions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactIdentity(t *testing.T) {
+	defer SetIdentityRedaction(NoRedaction, nil)
+
+	testCases := []struct {
+		name      string
+		redaction Redaction
+		identity  string
+		expected  string
+	}{
+		{"none", NoRedaction, "alice@example.com", "alice@example.com"},
+		{"mask email", MaskRedaction, "alice@example.com", "a***@example.com"},
+		{"mask user", MaskRedaction, "alice", "a***"},
+		{"mask empty", MaskRedaction, "", ""},
+		{"hash email", HashRedaction, "alice@example.com", "h:a398d49ce1980b36"},
+		{"hash empty", HashRedaction, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			SetIdentityRedaction(tc.redaction, []byte("secret"))
+			assert.Equal(t, tc.expected, RedactIdentity(tc.identity))
+		})
+	}
+}
+
+func TestStringRedactsIdentity(t *testing.T) {
+	defer SetIdentityRedaction(NoRedaction, nil)
+	SetIdentityRedaction(MaskRedaction, nil)
+
+	ss := &SessionState{
+		Email:             "alice@example.com",
+		User:              "alice",
+		PreferredUsername: "Alice",
+		AccessToken:       "access.token",
+	}
+	assert.Equal(t, "Session{email:a***@example.com user:a*** PreferredUsername:A*** token:true}", ss.String())
+}
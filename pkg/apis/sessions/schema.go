@@ -0,0 +1,110 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// SchemaVersion is the version of the MessagePack SessionState schema that
+// sessions are encoded with. Increment it and register a migration from the
+// previous version whenever a change to SessionState means sessions encoded
+// by older versions would no longer decode correctly, such as renaming or
+// retyping a field.
+const SchemaVersion uint8 = 1
+
+// versionedEnvelopeType is the type byte before MessagePack encoded sessions,
+// followed by their schema version byte. Sessions encoded before there was a
+// schema version start with a MessagePack map header instead, and are schema
+// version 0.
+const versionedEnvelopeType byte = 0x02
+
+// Migration upgrades the fields of a MessagePack encoded session, keyed by
+// their msgpack names, from one schema version to the next
+type Migration func(fields map[string]interface{}) error
+
+// migrations are the registered migrations, keyed by the schema version they
+// upgrade from
+var migrations = map[uint8]Migration{
+	// The first versioned schema only added the envelope
+	0: func(map[string]interface{}) error { return nil },
+}
+
+// registerMigration registers the migration from a schema version to the
+// next. It panics if there already is one, as that is a programming error.
+func registerMigration(from uint8, migration Migration) {
+	if _, ok := migrations[from]; ok {
+		panic(fmt.Sprintf("session schema migration from version %d is already registered", from))
+	}
+	migrations[from] = migration
+}
+
+// isVersioned checks whether an encoded session has the versioned envelope
+// type byte
+func isVersioned(packed []byte) bool {
+	return len(packed) > 0 && packed[0] == versionedEnvelopeType
+}
+
+// marshalMsgpack encodes the session with MessagePack after the versioned
+// envelope type byte and the current schema version, with its integrity MAC
+// if sealed
+func (s *SessionState) marshalMsgpack() ([]byte, error) {
+	packed, err := msgpack.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{versionedEnvelopeType, SchemaVersion}, packed...), nil
+}
+
+// unmarshalMsgpack decodes a MessagePack encoded session, migrating sessions
+// of older schema versions to the current one
+func unmarshalMsgpack(packed []byte) (*SessionState, error) {
+	version := uint8(0)
+	if isVersioned(packed) {
+		if len(packed) < 2 {
+			return nil, errors.New("missing session schema version")
+		}
+		version = packed[1]
+		packed = packed[2:]
+	}
+
+	ss := &SessionState{}
+	switch {
+	case version == SchemaVersion:
+		if err := msgpack.Unmarshal(packed, ss); err != nil {
+			return nil, err
+		}
+		return ss, nil
+	case version > SchemaVersion:
+		return nil, fmt.Errorf("session schema version %d is newer than the supported version %d", version, SchemaVersion)
+	}
+
+	migrated, err := migrate(packed, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := msgpack.Unmarshal(migrated, ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// migrate applies the migrations from the schema version to the current one
+// to the fields of a MessagePack encoded session
+func migrate(packed []byte, version uint8) ([]byte, error) {
+	fields := map[string]interface{}{}
+	if err := msgpack.Unmarshal(packed, &fields); err != nil {
+		return nil, err
+	}
+	for ; version < SchemaVersion; version++ {
+		migration, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no session schema migration from version %d", version)
+		}
+		if err := migration(fields); err != nil {
+			return nil, fmt.Errorf("error migrating session from schema version %d: %w", version, err)
+		}
+	}
+	return msgpack.Marshal(fields)
+}
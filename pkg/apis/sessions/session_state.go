@@ -2,11 +2,13 @@ package sessions
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
+	"strconv"
 	"time"
 	"unicode/utf8"
 
@@ -15,24 +17,84 @@ import (
 	"github.com/vmihailenco/msgpack/v4"
 )
 
-// SessionState is used to store information about the currently authenticated user session
+// SessionState is used to store information about the currently authenticated user session.
+// The JSON names of the fields are the claim names used by GetClaim.
 type SessionState struct {
-	CreatedAt *time.Time `msgpack:"ca,omitempty"`
-	ExpiresOn *time.Time `msgpack:"eo,omitempty"`
+	CreatedAt *time.Time `msgpack:"ca,omitempty" json:"created_at,omitempty"`
+	ExpiresOn *time.Time `msgpack:"eo,omitempty" json:"expires_on,omitempty"`
 
-	AccessToken  string `msgpack:"at,omitempty"`
-	IDToken      string `msgpack:"it,omitempty"`
-	RefreshToken string `msgpack:"rt,omitempty"`
+	AccessToken  string `msgpack:"at,omitempty" json:"access_token,omitempty"`
+	IDToken      string `msgpack:"it,omitempty" json:"id_token,omitempty"`
+	RefreshToken string `msgpack:"rt,omitempty" json:"refresh_token,omitempty"`
 
-	Email             string   `msgpack:"e,omitempty"`
-	User              string   `msgpack:"u,omitempty"`
-	Groups            []string `msgpack:"g,omitempty"`
-	PreferredUsername string   `msgpack:"pu,omitempty"`
+	Email string `msgpack:"e,omitempty" json:"email,omitempty"`
+	User  string `msgpack:"u,omitempty" json:"user,omitempty"`
+	// Groups are the groups the user is a member of, as returned by the
+	// provider. They are the canonical source of group membership for
+	// header injection and authorization rules.
+	Groups            []string `msgpack:"g,omitempty" json:"groups,omitempty"`
+	PreferredUsername string   `msgpack:"pu,omitempty" json:"preferred_username,omitempty"`
+
+	// Claims are the provider specific claims carried in the session for
+	// headers and authorization rules, keyed by claim name
+	Claims map[string]interface{} `msgpack:"cl,omitempty" json:"claims,omitempty"`
+
+	// Fingerprint is a hash of the client IP and/or User-Agent the session
+	// was created by (if session fingerprint binding is enabled)
+	Fingerprint string `msgpack:"fp,omitempty" json:"fingerprint,omitempty"`
+
+	// Annotations are key-value pairs set by upstreams, that are injected as
+	// request headers on later requests of the session
+	Annotations map[string]string `msgpack:"an,omitempty" json:"annotations,omitempty"`
+
+	// ProviderData is provider specific state kept across refreshes, such as
+	// the tenant of the user, keyed by the provider name and a dot, e.g.
+	// "azure.tenant". Providers read and write it with GetProviderData and
+	// SetProviderData rather than adding fields to the session.
+	ProviderData map[string][]byte `msgpack:"pd,omitempty" json:"provider_data,omitempty"`
+
+	// LastRefreshAttempt is when the session was last refreshed, and
+	// RefreshFailures how many refreshes in a row the provider failed since
+	// (if refresh backoff is enabled)
+	LastRefreshAttempt *time.Time `msgpack:"lra,omitempty" json:"last_refresh_attempt,omitempty"`
+	RefreshFailures    int        `msgpack:"rf,omitempty" json:"refresh_failures,omitempty"`
+
+	// Integrity is the HMAC of the identity and expiry of the session. It is
+	// only set while the session is encoded, and checked when it is decoded.
+	Integrity []byte `msgpack:"ig,omitempty" json:"-"`
+}
+
+// GetProviderData returns the provider specific state with the key, or nil
+// if there is none
+func (s *SessionState) GetProviderData(key string) []byte {
+	return s.ProviderData[key]
+}
+
+// SetProviderData sets the provider specific state with the key. An empty
+// value removes it.
+func (s *SessionState) SetProviderData(key string, value []byte) {
+	if len(value) == 0 {
+		delete(s.ProviderData, key)
+		if len(s.ProviderData) == 0 {
+			s.ProviderData = nil
+		}
+		return
+	}
+	if s.ProviderData == nil {
+		s.ProviderData = map[string][]byte{}
+	}
+	s.ProviderData[key] = value
 }
 
 // IsExpired checks whether the session has expired
 func (s *SessionState) IsExpired() bool {
-	if s.ExpiresOn != nil && !s.ExpiresOn.IsZero() && s.ExpiresOn.Before(time.Now()) {
+	return s.IsExpiredWithSkew(0)
+}
+
+// IsExpiredWithSkew checks whether the session expired more than skew ago,
+// tolerating a provider whose clock runs ahead of ours by up to skew
+func (s *SessionState) IsExpiredWithSkew(skew time.Duration) bool {
+	if s.ExpiresOn != nil && !s.ExpiresOn.IsZero() && s.ExpiresOn.Add(skew).Before(time.Now()) {
 		return true
 	}
 	return false
@@ -46,9 +108,10 @@ func (s *SessionState) Age() time.Duration {
 	return 0
 }
 
-// String constructs a summary of the session state
+// String constructs a summary of the session state, with the identity fields
+// redacted for logging
 func (s *SessionState) String() string {
-	o := fmt.Sprintf("Session{email:%s user:%s PreferredUsername:%s", s.Email, s.User, s.PreferredUsername)
+	o := fmt.Sprintf("Session{email:%s user:%s PreferredUsername:%s", RedactIdentity(s.Email), RedactIdentity(s.User), RedactIdentity(s.PreferredUsername))
 	if s.AccessToken != "" {
 		o += " token:true"
 	}
@@ -67,9 +130,24 @@ func (s *SessionState) String() string {
 	if len(s.Groups) > 0 {
 		o += fmt.Sprintf(" groups:%v", s.Groups)
 	}
+	if s.RefreshFailures > 0 {
+		o += fmt.Sprintf(" refresh_failures:%d", s.RefreshFailures)
+	}
 	return o + "}"
 }
 
+// ExpiresIn returns the time until the access token expires, or 0 if it has
+// already expired or has no expiry
+func (s *SessionState) ExpiresIn() time.Duration {
+	if s.ExpiresOn == nil || s.ExpiresOn.IsZero() {
+		return 0
+	}
+	if remaining := time.Until(*s.ExpiresOn); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
 func (s *SessionState) GetClaim(claim string) []string {
 	if s == nil {
 		return []string{}
@@ -83,6 +161,16 @@ func (s *SessionState) GetClaim(claim string) []string {
 		return []string{s.CreatedAt.String()}
 	case "expires_on":
 		return []string{s.ExpiresOn.String()}
+	case "expires_in":
+		if s.ExpiresOn == nil || s.ExpiresOn.IsZero() {
+			return []string{}
+		}
+		return []string{strconv.FormatInt(int64(s.ExpiresIn().Seconds()), 10)}
+	case "expires_at":
+		if s.ExpiresOn == nil || s.ExpiresOn.IsZero() {
+			return []string{}
+		}
+		return []string{s.ExpiresOn.UTC().Format(time.RFC3339)}
 	case "refresh_token":
 		return []string{s.RefreshToken}
 	case "email":
@@ -96,29 +184,175 @@ func (s *SessionState) GetClaim(claim string) []string {
 	case "preferred_username":
 		return []string{s.PreferredUsername}
 	default:
+		return claimValues(s.Claims[claim])
+	}
+}
+
+// claimValues formats a claim carried in the session as strings, with one
+// value per element of a list. Values that aren't strings are formatted as
+// JSON.
+func claimValues(claim interface{}) []string {
+	if claim == nil {
 		return []string{}
 	}
+	elements, ok := claim.([]interface{})
+	if !ok {
+		elements = []interface{}{claim}
+	}
+
+	values := make([]string, 0, len(elements))
+	for _, element := range elements {
+		if value, ok := element.(string); ok {
+			values = append(values, value)
+			continue
+		}
+		formatted, err := json.Marshal(element)
+		if err != nil {
+			continue
+		}
+		values = append(values, string(formatted))
+	}
+	return values
+}
+
+// ClaimsSize returns the MessagePack encoded size of the claims, which counts
+// towards the size of the session
+func ClaimsSize(claims map[string]interface{}) (int, error) {
+	packed, err := msgpack.Marshal(claims)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling claims to msgpack: %w", err)
+	}
+	return len(packed), nil
+}
+
+// prunableFields clear the fields of a session that may be left out when it is
+// stored, keyed by their claim name
+var prunableFields = map[string]func(*SessionState){
+	"access_token":       func(s *SessionState) { s.AccessToken = "" },
+	"id_token":           func(s *SessionState) { s.IDToken = "" },
+	"refresh_token":      func(s *SessionState) { s.RefreshToken = "" },
+	"email":              func(s *SessionState) { s.Email = "" },
+	"user":               func(s *SessionState) { s.User = "" },
+	"groups":             func(s *SessionState) { s.Groups = nil },
+	"preferred_username": func(s *SessionState) { s.PreferredUsername = "" },
+	"claims":             func(s *SessionState) { s.Claims = nil },
+}
+
+// IsPrunableField checks whether the field, by its claim name, may be left out
+// of a stored session
+func IsPrunableField(field string) bool {
+	_, ok := prunableFields[field]
+	return ok
+}
+
+// IsBuiltinClaim checks whether GetClaim reads the claim from the session
+// fields, rather than from the provider specific Claims
+func IsBuiltinClaim(claim string) bool {
+	switch claim {
+	case "created_at", "expires_on", "expires_in", "expires_at":
+		return true
+	default:
+		return IsPrunableField(claim)
+	}
+}
+
+// PruneFields returns a copy of the session with only the given fields, by
+// their claim name.
+// The creation and expiry times, the fingerprint, the annotations, the
+// provider data and the refresh attempts are always kept.
+func (s *SessionState) PruneFields(keep []string) *SessionState {
+	kept := make(map[string]bool, len(keep))
+	for _, field := range keep {
+		kept[field] = true
+	}
+
+	pruned := *s
+	for field, prune := range prunableFields {
+		if !kept[field] {
+			prune(&pruned)
+		}
+	}
+	return &pruned
 }
 
-// EncodeSessionState returns an encrypted, lz4 compressed, MessagePack encoded session
+// EncodeSessionState returns an encrypted, MessagePack encoded session.
+// When compress is set, the session is lz4 compressed if that makes it
+// smaller.
 func (s *SessionState) EncodeSessionState(c encryption.Cipher, compress bool) ([]byte, error) {
-	packed, err := msgpack.Marshal(s)
+	if compress {
+		return s.EncodeSessionStateAbove(c, 0)
+	}
+	return s.EncodeSessionStateAs(c, MsgpackEncoding)
+}
+
+// EncodeSessionStateAs returns an encrypted, uncompressed session in the
+// encoding. Sessions are MessagePack encoded if the encoding is empty.
+func (s *SessionState) EncodeSessionStateAs(c encryption.Cipher, encoding Encoding) ([]byte, error) {
+	s = s.sealed(integrityCheckOf(c))
+	var packed []byte
+	var err error
+	switch encoding {
+	case MsgpackEncoding, "":
+		packed, err = s.marshalMsgpack()
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling session state to msgpack: %w", err)
+		}
+	case ProtobufEncoding:
+		packed, err = s.marshalProtobuf()
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling session state to protobuf: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown session encoding %q", encoding)
+	}
+
+	encrypted, err := c.Encrypt(packed)
+	if err != nil {
+		return nil, err
+	}
+	recordSessionSizes(len(packed), len(packed), len(encrypted))
+	return encrypted, nil
+}
+
+// EncodeSessionStateAbove returns an encrypted, MessagePack encoded session
+// that is lz4 compressed if it is at least threshold bytes and compressing it
+// makes it smaller.
+// Compression of small sessions costs CPU time and can grow them.
+func (s *SessionState) EncodeSessionStateAbove(c encryption.Cipher, threshold int) ([]byte, error) {
+	return s.EncodeSessionStateWith(c, LZ4Compression, 0, threshold)
+}
+
+// EncodeSessionStateWith is EncodeSessionStateAbove with a choice of
+// compression algorithm and level, within the CompressionLevels of the
+// algorithm
+func (s *SessionState) EncodeSessionStateWith(c encryption.Cipher, compression Compression, level int, threshold int) ([]byte, error) {
+	packed, err := s.sealed(integrityCheckOf(c)).marshalMsgpack()
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling session state to msgpack: %w", err)
 	}
 
-	if !compress {
-		return c.Encrypt(packed)
+	payload, err := compressAbove(packed, compression, level, threshold)
+	if err != nil {
+		return nil, err
 	}
 
-	compressed, err := lz4Compress(packed)
+	encrypted, err := c.Encrypt(payload)
 	if err != nil {
 		return nil, err
 	}
-	return c.Encrypt(compressed)
+	recordSessionSizes(len(packed), len(payload), len(encrypted))
+	return encrypted, nil
 }
 
-// DecodeSessionState decodes a LZ4 compressed MessagePack into a Session State
+// DecodeSessionState decodes an encrypted MessagePack or protobuf encoded
+// session. MessagePack sessions of older schema versions are migrated to the
+// current SchemaVersion. Legacy sessions, from before there was a schema
+// version, are only decoded until the cutoff set by SetLegacyCutoff.
+// When compressed is set, the session may have been compressed by
+// EncodeSessionState, EncodeSessionStateAbove or EncodeSessionStateWith,
+// with any of the compression algorithms.
+// The integrity MAC of the session is checked when the cipher has an
+// integrity check, see WithIntegrityCheck.
 func DecodeSessionState(data []byte, c encryption.Cipher, compressed bool) (*SessionState, error) {
 	decrypted, err := c.Decrypt(data)
 	if err != nil {
@@ -127,16 +361,34 @@ func DecodeSessionState(data []byte, c encryption.Cipher, compressed bool) (*Ses
 
 	packed := decrypted
 	if compressed {
-		packed, err = lz4Decompress(decrypted)
+		packed, err = decompress(decrypted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ss := &SessionState{}
+	switch {
+	case isProtobuf(packed):
+		ss, err = unmarshalProtobuf(packed)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling protobuf to session state: %w", err)
+		}
+	case !isVersioned(packed):
+		ss, err = decodeLegacy(decrypted, packed, time.Now())
 		if err != nil {
 			return nil, err
 		}
+	default:
+		ss, err = unmarshalMsgpack(packed)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling data to session state: %w", err)
+		}
 	}
 
-	var ss SessionState
-	err = msgpack.Unmarshal(packed, &ss)
+	err = ss.verifyIntegrity(integrityCheckOf(c))
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling data to session state: %w", err)
+		return nil, err
 	}
 
 	err = ss.validate()
@@ -144,20 +396,21 @@ func DecodeSessionState(data []byte, c encryption.Cipher, compressed bool) (*Ses
 		return nil, err
 	}
 
-	return &ss, nil
+	return ss, nil
 }
 
 // lz4Compress compresses with LZ4
 //
 // The Compress:Decompress ratio is 1:Many. LZ4 gives fastest decompress speeds
 // at the expense of greater compression compared to other compression
-// algorithms.
-func lz4Compress(payload []byte) ([]byte, error) {
+// algorithms. Level 0 is the fastest, higher levels compress more at the cost
+// of CPU time.
+func lz4Compress(payload []byte, level int) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	zw := lz4.NewWriter(nil)
 	zw.Header = lz4.Header{
 		BlockMaxSize:     65536,
-		CompressionLevel: 0,
+		CompressionLevel: level,
 	}
 	zw.Reset(buf)
 
@@ -4,12 +4,16 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v4"
 )
 
 func timePtr(t time.Time) *time.Time {
@@ -122,6 +126,15 @@ func TestIsExpired(t *testing.T) {
 	assert.Equal(t, false, s.IsExpired())
 }
 
+func TestIsExpiredWithSkew(t *testing.T) {
+	s := &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(-1) * time.Minute))}
+	assert.Equal(t, false, s.IsExpiredWithSkew(2*time.Minute))
+	assert.Equal(t, true, s.IsExpiredWithSkew(30*time.Second))
+
+	s = &SessionState{}
+	assert.Equal(t, false, s.IsExpiredWithSkew(time.Minute))
+}
+
 func TestAge(t *testing.T) {
 	ss := &SessionState{}
 
@@ -133,8 +146,101 @@ func TestAge(t *testing.T) {
 	assert.Equal(t, time.Hour, ss.Age().Round(time.Minute))
 }
 
+func TestExpiresIn(t *testing.T) {
+	s := &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(1) * time.Hour))}
+	assert.Equal(t, time.Hour, s.ExpiresIn().Round(time.Minute))
+
+	s = &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(-1) * time.Minute))}
+	assert.Equal(t, time.Duration(0), s.ExpiresIn())
+
+	s = &SessionState{}
+	assert.Equal(t, time.Duration(0), s.ExpiresIn())
+}
+
+func TestGetClaimExpiry(t *testing.T) {
+	expires, err := time.Parse(time.RFC3339, "2100-01-01T01:00:00+01:00")
+	assert.NoError(t, err)
+
+	s := &SessionState{ExpiresOn: &expires}
+	assert.Equal(t, []string{"2100-01-01T00:00:00Z"}, s.GetClaim("expires_at"))
+	assert.Len(t, s.GetClaim("expires_in"), 1)
+
+	s = &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(-1) * time.Minute))}
+	assert.Equal(t, []string{"0"}, s.GetClaim("expires_in"))
+
+	s = &SessionState{}
+	assert.Equal(t, []string{}, s.GetClaim("expires_in"))
+	assert.Equal(t, []string{}, s.GetClaim("expires_at"))
+}
+
+func TestGetClaimFromClaims(t *testing.T) {
+	g := NewWithT(t)
+	ss := &SessionState{
+		Email: "email@email.email",
+		Claims: map[string]interface{}{
+			"department": "engineering",
+			"roles":      []interface{}{"admin", 1.0},
+			"manager":    map[string]interface{}{"name": "jane"},
+			"on_call":    true,
+		},
+	}
+
+	// The claims should read the same after the session has been stored
+	packed, err := msgpack.Marshal(ss)
+	g.Expect(err).ToNot(HaveOccurred())
+	decoded := &SessionState{}
+	g.Expect(msgpack.Unmarshal(packed, decoded)).To(Succeed())
+
+	for _, s := range []*SessionState{ss, decoded} {
+		g.Expect(s.GetClaim("department")).To(Equal([]string{"engineering"}))
+		g.Expect(s.GetClaim("roles")).To(Equal([]string{"admin", "1"}))
+		g.Expect(s.GetClaim("manager")).To(Equal([]string{`{"name":"jane"}`}))
+		g.Expect(s.GetClaim("on_call")).To(Equal([]string{"true"}))
+		g.Expect(s.GetClaim("missing")).To(Equal([]string{}))
+		g.Expect(s.GetClaim("email")).To(Equal([]string{"email@email.email"}))
+	}
+
+	g.Expect(IsBuiltinClaim("expires_in")).To(BeTrue())
+	g.Expect(IsBuiltinClaim("groups")).To(BeTrue())
+	g.Expect(IsBuiltinClaim("department")).To(BeFalse())
+}
+
 // TestEncodeAndDecodeSessionState encodes & decodes various session states
 // and confirms the operation is 1:1
+func TestPruneFields(t *testing.T) {
+	g := NewWithT(t)
+	created := time.Now()
+	ss := &SessionState{
+		CreatedAt:         &created,
+		ExpiresOn:         &created,
+		AccessToken:       "access.token",
+		IDToken:           "id.token",
+		RefreshToken:      "refresh.token",
+		Email:             "email@email.email",
+		User:              "some.user",
+		Groups:            []string{"admins"},
+		PreferredUsername: "preferred.user",
+		Claims:            map[string]interface{}{"department": "engineering"},
+		Fingerprint:       "fingerprint",
+		Annotations:       map[string]string{"tenant": "acme"},
+		ProviderData:      map[string][]byte{"azure.tenant": []byte("tenant-id")},
+	}
+
+	g.Expect(ss.PruneFields([]string{"access_token", "email"})).To(Equal(&SessionState{
+		CreatedAt:    &created,
+		ExpiresOn:    &created,
+		AccessToken:  "access.token",
+		Email:        "email@email.email",
+		Fingerprint:  "fingerprint",
+		Annotations:  map[string]string{"tenant": "acme"},
+		ProviderData: map[string][]byte{"azure.tenant": []byte("tenant-id")},
+	}))
+	g.Expect(ss.IDToken).To(Equal("id.token"))
+
+	g.Expect(IsPrunableField("groups")).To(BeTrue())
+	g.Expect(IsPrunableField("created_at")).To(BeFalse())
+}
+
 func TestEncodeAndDecodeSessionState(t *testing.T) {
 	created := time.Now()
 	expires := time.Now().Add(time.Duration(1) * time.Hour)
@@ -256,6 +362,290 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 	}
 }
 
+func TestEncodeSessionStateAbove(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	token := strings.Repeat("AccessToken.12349871293847fdsaihf9238h4f91h8fr", 10)
+	large := &SessionState{Email: "username@example.com", AccessToken: token, IDToken: token}
+	small := &SessionState{Email: "username@example.com"}
+
+	testCases := map[string]struct {
+		ss             *SessionState
+		threshold      int
+		expectCompress bool
+	}{
+		"Large session above the threshold": {ss: large, threshold: 512, expectCompress: true},
+		"Large session below the threshold": {ss: large, threshold: 4096, expectCompress: false},
+		"Small session that would grow":     {ss: small, threshold: 0, expectCompress: false},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			encoded, err := tc.ss.EncodeSessionStateAbove(c, tc.threshold)
+			assert.NoError(t, err)
+			decrypted, err := c.Decrypt(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectCompress, isCompressed(decrypted))
+
+			decoded, err := DecodeSessionState(encoded, c, true)
+			assert.NoError(t, err)
+			compareSessionStates(t, tc.ss, decoded)
+		})
+	}
+}
+
+func TestEncodeSessionStateWith(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	token := strings.Repeat("AccessToken.12349871293847fdsaihf9238h4f91h8fr", 10)
+	ss := &SessionState{Email: "username@example.com", AccessToken: token, IDToken: token}
+
+	testCases := map[string]struct {
+		compression    Compression
+		level          int
+		expectCompress bool
+	}{
+		"lz4":              {compression: LZ4Compression, expectCompress: true},
+		"lz4 at level 9":   {compression: LZ4Compression, level: 9, expectCompress: true},
+		"zstd":             {compression: ZstdCompression, expectCompress: true},
+		"zstd at level 1":  {compression: ZstdCompression, level: 1, expectCompress: true},
+		"zstd at level 19": {compression: ZstdCompression, level: 19, expectCompress: true},
+		"snappy":           {compression: SnappyCompression, expectCompress: true},
+		"none":             {compression: NoCompression, expectCompress: false},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			encoded, err := ss.EncodeSessionStateWith(c, tc.compression, tc.level, 0)
+			assert.NoError(t, err)
+			decrypted, err := c.Decrypt(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectCompress, isCompressed(decrypted))
+
+			// Sessions are decoded without knowing the algorithm
+			decoded, err := DecodeSessionState(encoded, c, true)
+			assert.NoError(t, err)
+			compareSessionStates(t, ss, decoded)
+		})
+	}
+
+	_, err = ss.EncodeSessionStateWith(c, Compression("gzip"), 0, 0)
+	assert.EqualError(t, err, "unknown session compression \"gzip\"")
+	_, err = ss.EncodeSessionStateWith(c, ZstdCompression, 23, 0)
+	assert.EqualError(t, err, "session compression level 23 of zstd must be between 0 and 22")
+	_, err = ss.EncodeSessionStateWith(c, SnappyCompression, 1, 0)
+	assert.EqualError(t, err, "session compression level 1 of snappy must be between 0 and 0")
+	assert.True(t, IsCompression("zstd"))
+	assert.False(t, IsCompression("gzip"))
+}
+
+func TestEncodeSessionStateAs(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	created := time.Unix(1600000000, 123456789)
+	expires := created.Add(time.Hour)
+	ss := &SessionState{
+		CreatedAt:          &created,
+		ExpiresOn:          &expires,
+		AccessToken:        "AccessToken",
+		IDToken:            "IDToken",
+		RefreshToken:       "RefreshToken",
+		Email:              "username@example.com",
+		User:               "username",
+		Groups:             []string{"admins", "developers"},
+		PreferredUsername:  "preferred.username",
+		Claims:             map[string]interface{}{"department": "engineering", "roles": []interface{}{"reader"}},
+		Fingerprint:        "fingerprint",
+		Annotations:        map[string]string{"tenant": "acme", "locale": "en"},
+		ProviderData:       map[string][]byte{"azure.tenant": []byte("tenant-id"), "github.orgs": []byte(`["acme"]`)},
+		LastRefreshAttempt: &created,
+		RefreshFailures:    3,
+	}
+
+	for _, encoding := range []Encoding{MsgpackEncoding, ProtobufEncoding} {
+		t.Run(string(encoding), func(t *testing.T) {
+			encoded, err := ss.EncodeSessionStateAs(c, encoding)
+			assert.NoError(t, err)
+			decrypted, err := c.Decrypt(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, encoding == ProtobufEncoding, isProtobuf(decrypted))
+
+			// Sessions are decoded without knowing the encoding
+			decoded, err := DecodeSessionState(encoded, c, false)
+			assert.NoError(t, err)
+			compareSessionStates(t, ss, decoded)
+		})
+	}
+
+	_, err = ss.EncodeSessionStateAs(c, Encoding("json"))
+	assert.EqualError(t, err, "unknown session encoding \"json\"")
+	assert.True(t, IsEncoding("protobuf"))
+	assert.False(t, IsEncoding("json"))
+}
+
+func TestProviderData(t *testing.T) {
+	ss := &SessionState{}
+	assert.Nil(t, ss.GetProviderData("azure.tenant"))
+
+	ss.SetProviderData("azure.tenant", []byte("tenant-id"))
+	ss.SetProviderData("github.orgs", []byte(`["acme"]`))
+	assert.Equal(t, []byte("tenant-id"), ss.GetProviderData("azure.tenant"))
+	assert.Equal(t, []byte(`["acme"]`), ss.GetProviderData("github.orgs"))
+
+	ss.SetProviderData("azure.tenant", nil)
+	assert.Nil(t, ss.GetProviderData("azure.tenant"))
+	assert.Equal(t, map[string][]byte{"github.orgs": []byte(`["acme"]`)}, ss.ProviderData)
+
+	ss.SetProviderData("github.orgs", []byte{})
+	assert.Nil(t, ss.ProviderData)
+}
+
+func TestUnmarshalProtobufSkipsUnknownFields(t *testing.T) {
+	ss := &SessionState{Email: "username@example.com"}
+	packed, err := ss.marshalProtobuf()
+	assert.NoError(t, err)
+
+	// A varint field 18 and a string field 19 added by a later schema
+	packed = append(packed, 0x90, 0x01, 0x01, 0x9a, 0x01, 0x01, 'x')
+	decoded, err := unmarshalProtobuf(packed)
+	assert.NoError(t, err)
+	assert.Equal(t, ss, decoded)
+
+	_, err = unmarshalProtobuf(packed[:len(packed)-1])
+	assert.Error(t, err)
+}
+
+func TestSessionSchemaVersion(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	ss := &SessionState{Email: "username@example.com", User: "username"}
+
+	encoded, err := ss.EncodeSessionStateAs(c, MsgpackEncoding)
+	assert.NoError(t, err)
+	decrypted, err := c.Decrypt(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{versionedEnvelopeType, SchemaVersion}, decrypted[:2])
+
+	t.Run("unversioned sessions are schema version 0", func(t *testing.T) {
+		SetLegacyCutoff(time.Now().Add(time.Hour))
+		defer SetLegacyCutoff(time.Time{})
+
+		packed, err := msgpack.Marshal(ss)
+		assert.NoError(t, err)
+		encrypted, err := c.Encrypt(packed)
+		assert.NoError(t, err)
+
+		decoded, err := DecodeSessionState(encrypted, c, true)
+		assert.NoError(t, err)
+		assert.Equal(t, ss, decoded)
+	})
+
+	t.Run("newer schema versions are rejected", func(t *testing.T) {
+		packed := append([]byte{}, decrypted...)
+		packed[1] = SchemaVersion + 1
+		encrypted, err := c.Encrypt(packed)
+		assert.NoError(t, err)
+
+		_, err = DecodeSessionState(encrypted, c, false)
+		assert.EqualError(t, err, fmt.Sprintf("error unmarshalling data to session state: session schema version %d is newer than the supported version %d", SchemaVersion+1, SchemaVersion))
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	previous := migrations[SchemaVersion-1]
+	defer func() { migrations[SchemaVersion-1] = previous }()
+	delete(migrations, SchemaVersion-1)
+
+	// A previous schema that named the email field "email"
+	registerMigration(SchemaVersion-1, func(fields map[string]interface{}) error {
+		if email, ok := fields["email"]; ok {
+			fields["e"] = email
+			delete(fields, "email")
+		}
+		return nil
+	})
+	assert.Panics(t, func() {
+		registerMigration(SchemaVersion-1, func(map[string]interface{}) error { return nil })
+	})
+
+	packed, err := msgpack.Marshal(map[string]interface{}{"email": "username@example.com", "u": "username"})
+	assert.NoError(t, err)
+	packed = append([]byte{versionedEnvelopeType, SchemaVersion - 1}, packed...)
+
+	decoded, err := unmarshalMsgpack(packed)
+	assert.NoError(t, err)
+	assert.Equal(t, &SessionState{Email: "username@example.com", User: "username"}, decoded)
+
+	migrations[SchemaVersion-1] = func(map[string]interface{}) error { return fmt.Errorf("broken") }
+	_, err = unmarshalMsgpack(packed)
+	assert.EqualError(t, err, fmt.Sprintf("error migrating session from schema version %d: broken", SchemaVersion-1))
+}
+
+func TestSizeMetrics(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	token := strings.Repeat("AccessToken.12349871293847fdsaihf9238h4f91h8fr", 100)
+
+	encodedBefore, _ := sessionSizeHistogram(t, encodedStage)
+	_, compressedBefore := sessionSizeHistogram(t, compressedStage)
+	_, err = (&SessionState{AccessToken: token}).EncodeSessionStateWith(c, ZstdCompression, 0, 0)
+	assert.NoError(t, err)
+	encoded, _ := sessionSizeHistogram(t, encodedStage)
+	assert.Equal(t, encodedBefore+1, encoded)
+	// The repetitive token compresses to less than 256 bytes
+	_, compressed := sessionSizeHistogram(t, compressedStage)
+	assert.Equal(t, compressedBefore+1, compressed)
+
+	cookiesBefore, smallCookiesBefore := sessionSizeHistogram(t, cookieStage)
+	ObserveCookieSessionSize(5000)
+	cookies, smallCookies := sessionSizeHistogram(t, cookieStage)
+	assert.Equal(t, cookiesBefore+1, cookies)
+	assert.Equal(t, smallCookiesBefore, smallCookies)
+}
+
+// sessionSizeHistogram returns the number of sessions observed at the stage,
+// and how many of them were at most 256 bytes
+func sessionSizeHistogram(t *testing.T, stage string) (uint64, uint64) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(sessionSizeBytes)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == stage {
+				histogram := metric.GetHistogram()
+				return histogram.GetSampleCount(), histogram.GetBucket()[0].GetCumulativeCount()
+			}
+		}
+	}
+	t.Fatalf("no session sizes observed at the %s stage", stage)
+	return 0, 0
+}
+
+func TestCompressionMetrics(t *testing.T) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	token := strings.Repeat("AccessToken.12349871293847fdsaihf9238h4f91h8fr", 10)
+
+	compressedBefore := testutil.ToFloat64(sessionEncodings.WithLabelValues("true"))
+	uncompressedBefore := testutil.ToFloat64(sessionEncodings.WithLabelValues("false"))
+	inputBefore := testutil.ToFloat64(compressionInputBytes)
+	savedBefore := testutil.ToFloat64(compressionSavedBytes)
+
+	_, err = (&SessionState{AccessToken: token}).EncodeSessionStateWith(c, ZstdCompression, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, compressedBefore+1, testutil.ToFloat64(sessionEncodings.WithLabelValues("true")))
+	assert.Greater(t, testutil.ToFloat64(compressionInputBytes), inputBefore)
+	assert.Greater(t, testutil.ToFloat64(compressionSavedBytes), savedBefore)
+
+	_, err = (&SessionState{AccessToken: token}).EncodeSessionStateWith(c, NoCompression, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uncompressedBefore+1, testutil.ToFloat64(sessionEncodings.WithLabelValues("false")))
+
+	registry := prometheus.NewRegistry()
+	for _, collector := range MetricsCollectors() {
+		assert.NoError(t, registry.Register(collector))
+	}
+}
+
 func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionState) {
 	if expected.CreatedAt != nil {
 		assert.NotNil(t, actual.CreatedAt)
@@ -269,13 +659,79 @@ func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionS
 	} else {
 		assert.Nil(t, actual.ExpiresOn)
 	}
+	if expected.LastRefreshAttempt != nil {
+		assert.NotNil(t, actual.LastRefreshAttempt)
+		assert.Equal(t, true, expected.LastRefreshAttempt.Equal(*actual.LastRefreshAttempt))
+	} else {
+		assert.Nil(t, actual.LastRefreshAttempt)
+	}
 
 	// Compare sessions without *time.Time fields
 	exp := *expected
 	exp.CreatedAt = nil
 	exp.ExpiresOn = nil
+	exp.LastRefreshAttempt = nil
 	act := *actual
 	act.CreatedAt = nil
 	act.ExpiresOn = nil
+	act.LastRefreshAttempt = nil
 	assert.Equal(t, exp, act)
 }
+
+func BenchmarkEncodeSessionState(b *testing.B) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdefghijklmnopqrstuv"))
+	assert.NoError(b, err)
+	ss := benchmarkSessionState()
+
+	for _, compress := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compress=%t", compress), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ss.EncodeSessionState(c, compress); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeSessionState(b *testing.B) {
+	c, err := encryption.NewCFBCipher([]byte("0123456789abcdefghijklmnopqrstuv"))
+	assert.NoError(b, err)
+	ss := benchmarkSessionState()
+
+	for _, compress := range []bool{false, true} {
+		encoded, err := ss.EncodeSessionState(c, compress)
+		assert.NoError(b, err)
+
+		b.Run(fmt.Sprintf("compress=%t", compress), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeSessionState(encoded, c, compress); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkSessionState is a session with tokens of a typical size
+func benchmarkSessionState() *SessionState {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	token := make([]byte, 800)
+	for i := range token {
+		token[i] = 'a' + byte(i%26)
+	}
+	return &SessionState{
+		CreatedAt:         &now,
+		ExpiresOn:         &expires,
+		AccessToken:       string(token),
+		IDToken:           string(token),
+		RefreshToken:      string(token[:200]),
+		Email:             "john.doe@example.com",
+		User:              "123456789",
+		Groups:            []string{"admins", "developers"},
+		PreferredUsername: "john.doe",
+	}
+}
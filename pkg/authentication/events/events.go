@@ -0,0 +1,152 @@
+package events
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	// recentEvents is the number of events replayed to new subscribers
+	recentEvents = 100
+
+	// subscriberBuffer is the number of events buffered for each subscriber.
+	// Events are dropped for subscribers that fall further behind, so that
+	// a slow client cannot hold up authentication.
+	subscriberBuffer = 256
+)
+
+// Event is a redacted authentication or authorization event
+type Event struct {
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Username string    `json:"username,omitempty"`
+	Client   string    `json:"client"`
+	Method   string    `json:"method"`
+	Host     string    `json:"host"`
+	Path     string    `json:"path"`
+	Message  string    `json:"message"`
+}
+
+// Stream keeps the recent authentication events and passes new ones on to
+// its subscribers
+type Stream struct {
+	mu sync.Mutex
+
+	// recent is a ring of the latest events, with next the index the next
+	// event is written to
+	recent []Event
+	next   int
+
+	subscribers map[chan Event]struct{}
+}
+
+// NewStream constructs a Stream without any events
+func NewStream() *Stream {
+	return &Stream{
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Publish redacts an auth log entry, and passes it on to every subscriber
+// that is keeping up
+func (s *Stream) Publish(e logger.AuthEvent) {
+	event := Event{
+		Time:     e.Time,
+		Status:   string(e.Status),
+		Username: redactUsername(e.Username),
+		Client:   e.Client,
+		Method:   e.Method,
+		Host:     e.Host,
+		Path:     e.Path,
+		Message:  redact(e.Message),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.recent) < recentEvents {
+		s.recent = append(s.recent, event)
+	} else {
+		s.recent[s.next] = event
+	}
+	s.next = (s.next + 1) % recentEvents
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the recent events, oldest first, and a channel of the
+// events published from now on. The cancel function must be called once the
+// subscriber is done.
+func (s *Stream) Subscribe() ([]Event, <-chan Event, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recent := make([]Event, 0, len(s.recent))
+	for i := len(s.recent); i > 0; i-- {
+		recent = append(recent, s.recent[(s.next-i+len(s.recent))%len(s.recent)])
+	}
+
+	subscriber := make(chan Event, subscriberBuffer)
+	s.subscribers[subscriber] = struct{}{}
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers, subscriber)
+	}
+	return recent, subscriber, cancel
+}
+
+// emailPattern matches email addresses, capturing the first character of the
+// local part and the domain
+var emailPattern = regexp.MustCompile(`([A-Za-z0-9])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+
+// sessionUserPattern matches the user names in session summaries, capturing
+// the field and the name
+var sessionUserPattern = regexp.MustCompile(`\b(user|PreferredUsername):([^ }]+)`)
+
+// redact masks the local part of email addresses, keeping its first
+// character and the domain, and the user names of session summaries, so that
+// operators can tell users and providers apart without the stream disclosing
+// who they are
+func redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, "$1***@$2")
+	return sessionUserPattern.ReplaceAllStringFunc(s, func(field string) string {
+		match := sessionUserPattern.FindStringSubmatch(field)
+		return match[1] + ":" + redactUsername(match[2])
+	})
+}
+
+// redactUsername masks usernames other than email addresses to their first
+// character
+func redactUsername(username string) string {
+	if strings.Contains(username, "@") {
+		return emailPattern.ReplaceAllString(username, "$1***@$2")
+	}
+	for _, first := range username {
+		return string(first) + "***"
+	}
+	return ""
+}
+
+// defaultStream streams the events of the proxy
+var defaultStream = NewStream()
+
+// Publish publishes an auth log entry to the default stream. It is a
+// logger.AuthObserver.
+func Publish(e logger.AuthEvent) {
+	defaultStream.Publish(e)
+}
+
+// Subscribe subscribes to the default stream
+func Subscribe() ([]Event, <-chan Event, func()) {
+	return defaultStream.Subscribe()
+}
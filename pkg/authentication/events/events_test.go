@@ -0,0 +1,96 @@
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events Suite", func() {
+	var (
+		stream *Stream
+		now    time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+		stream = NewStream()
+	})
+
+	authEvent := func(message string) logger.AuthEvent {
+		return logger.AuthEvent{
+			Time:     now,
+			Client:   "10.0.0.1",
+			Host:     "app.example.com",
+			Method:   "GET",
+			Path:     "/oauth2/callback",
+			Username: "alice@example.com",
+			Status:   logger.AuthFailure,
+			Message:  message,
+		}
+	}
+
+	It("replays the recent events to new subscribers, oldest first", func() {
+		for i := 0; i < recentEvents+2; i++ {
+			stream.Publish(authEvent(fmt.Sprintf("event %d", i)))
+		}
+
+		recent, _, cancel := stream.Subscribe()
+		defer cancel()
+		Expect(recent).To(HaveLen(recentEvents))
+		Expect(recent[0].Message).To(Equal("event 2"))
+		Expect(recent[recentEvents-1]).To(Equal(Event{
+			Time:     now,
+			Status:   "AuthFailure",
+			Username: "a***@example.com",
+			Client:   "10.0.0.1",
+			Method:   "GET",
+			Host:     "app.example.com",
+			Path:     "/oauth2/callback",
+			Message:  fmt.Sprintf("event %d", recentEvents+1),
+		}))
+	})
+
+	It("passes new events on to subscribers until they cancel", func() {
+		_, events, cancel := stream.Subscribe()
+		stream.Publish(authEvent("first"))
+		var event Event
+		Expect(events).To(Receive(&event))
+		Expect(event.Message).To(Equal("first"))
+
+		cancel()
+		stream.Publish(authEvent("second"))
+		Expect(events).ToNot(Receive())
+	})
+
+	It("drops events for subscribers that fall behind", func() {
+		_, events, cancel := stream.Subscribe()
+		defer cancel()
+		for i := 0; i < subscriberBuffer+1; i++ {
+			stream.Publish(authEvent(fmt.Sprintf("event %d", i)))
+		}
+		Expect(events).To(HaveLen(subscriberBuffer))
+	})
+
+	DescribeTable("redacts",
+		func(username, message, expectedUsername, expectedMessage string) {
+			stream.Publish(logger.AuthEvent{Username: username, Message: message})
+			recent, _, cancel := stream.Subscribe()
+			defer cancel()
+			Expect(recent[0].Username).To(Equal(expectedUsername))
+			Expect(recent[0].Message).To(Equal(expectedMessage))
+		},
+		Entry("email addresses", "alice@example.com", "Invalid authentication via OAuth2: unauthorized",
+			"a***@example.com", "Invalid authentication via OAuth2: unauthorized"),
+		Entry("user names", "alice", "Authenticated via HtpasswdFile",
+			"a***", "Authenticated via HtpasswdFile"),
+		Entry("session summaries", "bob@example.com", "Authenticated via OAuth2: Session{email:bob@example.com user:bob PreferredUsername:bobby token:true}",
+			"b***@example.com", "Authenticated via OAuth2: Session{email:b***@example.com user:b*** PreferredUsername:b*** token:true}"),
+		Entry("no user", "", "Request denied by rule block of authorization rule set \"default\"",
+			"", "Request denied by rule block of authorization rule set \"default\""),
+	)
+})
@@ -0,0 +1,212 @@
+package failures
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cause classifies why authentication failed
+type Cause string
+
+const (
+	// BadSignature failures present a session cookie that was not signed
+	// with the cookie secret, e.g. after the secret was rotated
+	BadSignature Cause = "bad_signature"
+
+	// ExpiredCookie failures present a session cookie older than the cookie
+	// expiry
+	ExpiredCookie Cause = "expired_cookie"
+
+	// CSRFMismatch failures return from the provider without the CSRF cookie
+	// or with a different CSRF token
+	CSRFMismatch Cause = "csrf_mismatch"
+
+	// StateMismatch failures return from the provider with an invalid state
+	StateMismatch Cause = "state_mismatch"
+
+	// ProviderError failures are errors returned by the provider, or when
+	// redeeming, enriching or refreshing sessions with it
+	ProviderError Cause = "provider_error"
+)
+
+// Causes are all the causes failures are classified into
+var Causes = []Cause{BadSignature, ExpiredCookie, CSRFMismatch, StateMismatch, ProviderError}
+
+const (
+	// recentFailures is the number of failures kept for the report
+	recentFailures = 50
+
+	// buckets is the number of minutes failures are counted for in the
+	// rolling report
+	buckets = 60
+)
+
+// Failure is an authentication failure in the report
+type Failure struct {
+	Time   time.Time `json:"time"`
+	Cause  Cause     `json:"cause"`
+	Method string    `json:"method"`
+	Host   string    `json:"host"`
+	Path   string    `json:"path"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Report summarises the authentication failures by cause
+type Report struct {
+	// Total are the failures since the proxy started
+	Total map[Cause]uint64 `json:"total"`
+
+	// LastHour are the failures in the last hour
+	LastHour map[Cause]uint64 `json:"lastHour"`
+
+	// Recent are the latest failures, newest first
+	Recent []Failure `json:"recent"`
+}
+
+// Tracker counts authentication failures by cause, and keeps a rolling count
+// for the last hour and the most recent failures to speed up triage
+type Tracker struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	total map[Cause]uint64
+
+	// minutes are the counts of each minute of the last hour, indexed by
+	// the minute modulo buckets
+	minutes [buckets]minuteCounts
+
+	// recent is a ring of the latest failures, with next the index the next
+	// failure is written to
+	recent []Failure
+	next   int
+}
+
+type minuteCounts struct {
+	start  time.Time
+	counts map[Cause]uint64
+}
+
+// NewTracker constructs an empty Tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		now:   time.Now,
+		total: map[Cause]uint64{},
+	}
+}
+
+// Record counts an authentication failure of the request.
+// The detail describes the failure in the report, and must not contain
+// secrets.
+func (t *Tracker) Record(cause Cause, req *http.Request, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.total[cause]++
+
+	start := now.Truncate(time.Minute)
+	minute := &t.minutes[start.Unix()/60%buckets]
+	if !minute.start.Equal(start) {
+		minute.start = start
+		minute.counts = map[Cause]uint64{}
+	}
+	minute.counts[cause]++
+
+	failure := Failure{
+		Time:   now,
+		Cause:  cause,
+		Method: req.Method,
+		Host:   req.Host,
+		Path:   req.URL.Path,
+		Detail: detail,
+	}
+	if len(t.recent) < recentFailures {
+		t.recent = append(t.recent, failure)
+	} else {
+		t.recent[t.next] = failure
+	}
+	t.next = (t.next + 1) % recentFailures
+}
+
+// Report returns the failures by cause in total and in the last hour, and
+// the recent failures
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := Report{
+		Total:    map[Cause]uint64{},
+		LastHour: map[Cause]uint64{},
+		Recent:   make([]Failure, 0, len(t.recent)),
+	}
+	for _, cause := range Causes {
+		report.Total[cause] = t.total[cause]
+		report.LastHour[cause] = 0
+	}
+
+	since := t.now().Add(-buckets * time.Minute)
+	for _, minute := range t.minutes {
+		if minute.start.After(since) {
+			for cause, count := range minute.counts {
+				report.LastHour[cause] += count
+			}
+		}
+	}
+
+	for i := 1; i <= len(t.recent); i++ {
+		report.Recent = append(report.Recent, t.recent[(t.next-i+len(t.recent))%len(t.recent)])
+	}
+	return report
+}
+
+// failuresDesc describes the failures by cause
+var failuresDesc = prometheus.NewDesc(
+	"oauth2_proxy_authentication_failures_total",
+	"The number of authentication failures, by cause.",
+	[]string{"cause"}, nil,
+)
+
+// Ensure Tracker implements the interface
+var _ prometheus.Collector = &Tracker{}
+
+// Describe sends the description of the failures metric
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- failuresDesc
+}
+
+// Collect sends the failures by cause
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	totals := make([]uint64, len(Causes))
+	for i, cause := range Causes {
+		totals[i] = t.total[cause]
+	}
+	t.mu.Unlock()
+
+	for i, cause := range Causes {
+		ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(totals[i]), string(cause))
+	}
+}
+
+// defaultTracker records the failures of the proxy
+var defaultTracker = NewTracker()
+
+// Record counts an authentication failure of the request in the default
+// tracker
+func Record(cause Cause, req *http.Request, detail string) {
+	defaultTracker.Record(cause, req, detail)
+}
+
+// GetReport returns the report of the default tracker
+func GetReport() Report {
+	return defaultTracker.Report()
+}
+
+// MetricsCollector returns the collector of the failures by cause of the
+// default tracker
+func MetricsCollector() prometheus.Collector {
+	return defaultTracker
+}
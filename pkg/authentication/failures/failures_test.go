@@ -0,0 +1,90 @@
+package failures
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Failures Suite", func() {
+	var (
+		tracker *Tracker
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+		tracker = NewTracker()
+		tracker.now = func() time.Time { return now }
+	})
+
+	It("counts failures by cause", func() {
+		req := httptest.NewRequest("GET", "http://app.example.com/admin", nil)
+		tracker.Record(BadSignature, req, "cookie signature not valid")
+		tracker.Record(BadSignature, req, "cookie signature not valid")
+		tracker.Record(CSRFMismatch, req, "CSRF cookie missing")
+
+		report := tracker.Report()
+		Expect(report.Total).To(Equal(map[Cause]uint64{
+			BadSignature:  2,
+			ExpiredCookie: 0,
+			CSRFMismatch:  1,
+			StateMismatch: 0,
+			ProviderError: 0,
+		}))
+		Expect(report.LastHour).To(Equal(report.Total))
+		Expect(report.Recent).To(HaveLen(3))
+		Expect(report.Recent[0]).To(Equal(Failure{
+			Time:   now,
+			Cause:  CSRFMismatch,
+			Method: "GET",
+			Host:   "app.example.com",
+			Path:   "/admin",
+			Detail: "CSRF cookie missing",
+		}))
+	})
+
+	It("only reports the last hour in the rolling counts", func() {
+		req := httptest.NewRequest("GET", "/", nil)
+		tracker.Record(ExpiredCookie, req, "")
+		now = now.Add(30 * time.Minute)
+		tracker.Record(ExpiredCookie, req, "")
+		now = now.Add(45 * time.Minute)
+		tracker.Record(ProviderError, req, "")
+
+		report := tracker.Report()
+		Expect(report.Total[ExpiredCookie]).To(Equal(uint64(2)))
+		Expect(report.LastHour[ExpiredCookie]).To(Equal(uint64(1)))
+		Expect(report.LastHour[ProviderError]).To(Equal(uint64(1)))
+	})
+
+	It("keeps the most recent failures, newest first", func() {
+		for i := 0; i < recentFailures+5; i++ {
+			tracker.Record(StateMismatch, httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil), "")
+		}
+
+		recent := tracker.Report().Recent
+		Expect(recent).To(HaveLen(recentFailures))
+		Expect(recent[0].Path).To(Equal(fmt.Sprintf("/%d", recentFailures+4)))
+		Expect(recent[recentFailures-1].Path).To(Equal("/5"))
+	})
+
+	It("collects the failures as Prometheus metrics", func() {
+		tracker.Record(ProviderError, httptest.NewRequest("GET", "/", nil), "")
+
+		Expect(testutil.CollectAndCompare(tracker, strings.NewReader(`
+# HELP oauth2_proxy_authentication_failures_total The number of authentication failures, by cause.
+# TYPE oauth2_proxy_authentication_failures_total counter
+oauth2_proxy_authentication_failures_total{cause="bad_signature"} 0
+oauth2_proxy_authentication_failures_total{cause="expired_cookie"} 0
+oauth2_proxy_authentication_failures_total{cause="csrf_mismatch"} 0
+oauth2_proxy_authentication_failures_total{cause="state_mismatch"} 0
+oauth2_proxy_authentication_failures_total{cause="provider_error"} 1
+`))).To(Succeed())
+	})
+})
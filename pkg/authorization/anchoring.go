@@ -0,0 +1,157 @@
+package authorization
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// unexpectedSegment is added around the paths a regex is meant to match to
+// build the examples of paths it unexpectedly matches
+const unexpectedSegment = "/unexpected"
+
+// CheckPathAnchoring checks whether a path regex is anchored at the start
+// with ^ and at the end with $, or explicitly matches any prefix or suffix
+// with .*
+// If it is not, it also returns example paths that the regex matches
+// although they only contain the intended path, e.g. /unexpected/health for
+// /health.
+func CheckPathAnchoring(expr string) (bool, []string, error) {
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return false, nil, err
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return false, nil, err
+	}
+	re = re.Simplify()
+	if anchoredStart(re) && anchoredEnd(re) {
+		return true, nil, nil
+	}
+
+	branches := []*syntax.Regexp{re}
+	if re.Op == syntax.OpAlternate {
+		branches = re.Sub
+	}
+
+	examples := []string{}
+	for _, branch := range branches {
+		sample := samplePath(branch)
+		candidates := []string{}
+		if !anchoredStart(branch) {
+			candidates = append(candidates, unexpectedSegment+sample)
+		}
+		if !anchoredEnd(branch) {
+			candidates = append(candidates, sample+unexpectedSegment)
+		}
+		for _, candidate := range candidates {
+			// Only report examples that the regex really matches
+			if compiled.MatchString(candidate) {
+				examples = append(examples, candidate)
+			}
+		}
+	}
+	return false, examples, nil
+}
+
+// anchoredStart checks whether every match of the regex must start at the
+// beginning of the path
+func anchoredStart(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpBeginLine:
+		return true
+	case syntax.OpStar:
+		return matchesAnyChar(re.Sub[0])
+	case syntax.OpConcat:
+		return len(re.Sub) > 0 && anchoredStart(re.Sub[0])
+	case syntax.OpCapture:
+		return anchoredStart(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !anchoredStart(sub) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// anchoredEnd checks whether every match of the regex must end at the end of
+// the path
+func anchoredEnd(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEndText, syntax.OpEndLine:
+		return true
+	case syntax.OpStar:
+		return matchesAnyChar(re.Sub[0])
+	case syntax.OpConcat:
+		return len(re.Sub) > 0 && anchoredEnd(re.Sub[len(re.Sub)-1])
+	case syntax.OpCapture:
+		return anchoredEnd(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !anchoredEnd(sub) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// matchesAnyChar checks whether the regex is . so that .* matches any prefix
+// or suffix of the path
+func matchesAnyChar(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpAnyChar || re.Op == syntax.OpAnyCharNotNL
+}
+
+// samplePath builds a short path matched by the regex, taking the first
+// alternative and the minimum number of repetitions
+func samplePath(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCharClass:
+		return string(sampleRune(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "x"
+	case syntax.OpCapture, syntax.OpPlus:
+		return samplePath(re.Sub[0])
+	case syntax.OpRepeat:
+		return strings.Repeat(samplePath(re.Sub[0]), re.Min)
+	case syntax.OpConcat:
+		var sample strings.Builder
+		for _, sub := range re.Sub {
+			sample.WriteString(samplePath(sub))
+		}
+		return sample.String()
+	case syntax.OpAlternate:
+		return samplePath(re.Sub[0])
+	default:
+		// Empty width assertions, and operators that can match nothing
+		return ""
+	}
+}
+
+// sampleRune picks a rune from a character class, preferring a letter so
+// that the examples are readable
+func sampleRune(ranges []rune) rune {
+	if len(ranges) == 0 {
+		return 'x'
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i] <= 'x' && 'x' <= ranges[i+1] {
+			return 'x'
+		}
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i] > ' ' {
+			return ranges[i]
+		}
+	}
+	return ranges[0]
+}
@@ -0,0 +1,65 @@
+package authorization
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Anchoring Suite", func() {
+	type checkPathAnchoringTableInput struct {
+		regex            string
+		expectedAnchored bool
+		expectedExamples []string
+	}
+
+	DescribeTable("CheckPathAnchoring",
+		func(in checkPathAnchoringTableInput) {
+			anchored, examples, err := CheckPathAnchoring(in.regex)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(anchored).To(Equal(in.expectedAnchored))
+			if in.expectedExamples == nil {
+				Expect(examples).To(BeEmpty())
+			} else {
+				Expect(examples).To(Equal(in.expectedExamples))
+			}
+		},
+		Entry("with a fully anchored regex", checkPathAnchoringTableInput{
+			regex:            "^/health$",
+			expectedAnchored: true,
+		}),
+		Entry("with a regex that explicitly matches any suffix", checkPathAnchoringTableInput{
+			regex:            "^/api/.*",
+			expectedAnchored: true,
+		}),
+		Entry("with anchored alternatives", checkPathAnchoringTableInput{
+			regex:            "^/ping$|^/health$",
+			expectedAnchored: true,
+		}),
+		Entry("with an unanchored regex", checkPathAnchoringTableInput{
+			regex:            "/health",
+			expectedAnchored: false,
+			expectedExamples: []string{"/unexpected/health", "/health/unexpected"},
+		}),
+		Entry("with a regex only anchored at the start", checkPathAnchoringTableInput{
+			regex:            "^/users/[0-9]+",
+			expectedAnchored: false,
+			expectedExamples: []string{"/users/0/unexpected"},
+		}),
+		Entry("with a regex only anchored at the end", checkPathAnchoringTableInput{
+			regex:            "/health$",
+			expectedAnchored: false,
+			expectedExamples: []string{"/unexpected/health"},
+		}),
+		Entry("with an unanchored alternative", checkPathAnchoringTableInput{
+			regex:            "^/ping$|/health",
+			expectedAnchored: false,
+			expectedExamples: []string{"/unexpected/health", "/health/unexpected"},
+		}),
+	)
+
+	It("returns an error for an invalid regex", func() {
+		_, _, err := CheckPathAnchoring("/(foo")
+		Expect(err).To(HaveOccurred())
+	})
+})
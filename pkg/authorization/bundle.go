@@ -0,0 +1,176 @@
+package authorization
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// BundleSignatureSuffix is appended to the URL of a policy bundle to fetch
+// its signature: the base64 encoded HMAC of the bundle, created with the
+// signature key
+const BundleSignatureSuffix = ".sig"
+
+// Syncer keeps rules in sync with an external source in the background
+type Syncer interface {
+	Run(done <-chan bool)
+}
+
+// BundleParser builds the rules of a policy bundle from its contents
+type BundleParser func(contents []byte) ([]*Rule, error)
+
+// BundleSyncer periodically fetches a policy bundle, a rules document
+// published at a URL, and replaces the rules of an engine with its rules.
+// The bundle is only parsed again when its ETag changes, and an invalid or
+// unsigned bundle never replaces the rules.
+type BundleSyncer struct {
+	name     string
+	endpoint string
+	hash     crypto.Hash
+	key      []byte
+	engine   *RulesEngine
+	parse    BundleParser
+	interval time.Duration
+	timeout  time.Duration
+
+	// etag is the ETag of the bundle the rules were last loaded from
+	etag string
+}
+
+// NewBundleSyncer constructs a BundleSyncer for the named rule set.
+// The bundle URL is an http or https URL, or an s3://bucket/key or
+// gs://bucket/object URL of an object readable without credentials.
+func NewBundleSyncer(name, bundleURL string, hash crypto.Hash, key string, engine *RulesEngine, parse BundleParser, interval time.Duration) (*BundleSyncer, error) {
+	if key == "" {
+		return nil, fmt.Errorf("rule set %q is fetched from %s, but no signature key is set", name, bundleURL)
+	}
+	endpoint, err := bundleEndpoint(bundleURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BundleSyncer{
+		name:     name,
+		endpoint: endpoint,
+		hash:     hash,
+		key:      []byte(key),
+		engine:   engine,
+		parse:    parse,
+		interval: interval,
+		timeout:  10 * time.Second,
+	}, nil
+}
+
+// bundleEndpoint returns the HTTP endpoint of the bundle URL, mapping S3 and
+// GCS objects to their public endpoints
+func bundleEndpoint(bundleURL string) (string, error) {
+	u, err := url.Parse(bundleURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid bundle URL %q: %v", bundleURL, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid bundle URL %q: missing host or bucket", bundleURL)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return u.String(), nil
+	case "s3":
+		return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", u.Host, strings.TrimPrefix(u.Path, "/")), nil
+	case "gs":
+		return fmt.Sprintf("https://storage.googleapis.com/%s/%s", u.Host, strings.TrimPrefix(u.Path, "/")), nil
+	default:
+		return "", fmt.Errorf("invalid bundle URL %q: unsupported scheme %q", bundleURL, u.Scheme)
+	}
+}
+
+// Run syncs the bundle immediately and then on each interval until done is
+// closed. Errors are logged and the previous rules are kept.
+func (s *BundleSyncer) Run(done <-chan bool) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(); err != nil {
+			logger.Errorf("Error syncing authorization rule set %q from %s: %v", s.name, s.endpoint, err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches the bundle once and replaces the rules of the engine if the
+// bundle has changed since it was last loaded
+func (s *BundleSyncer) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	builder := requests.New(s.endpoint).WithContext(ctx)
+	if s.etag != "" {
+		builder = builder.SetHeader("If-None-Match", s.etag)
+	}
+	result := builder.Do()
+	if result.Error() != nil {
+		return result.Error()
+	}
+	switch result.StatusCode() {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status \"%d\": %s", result.StatusCode(), result.Body())
+	}
+
+	if err := s.verify(ctx, result.Body()); err != nil {
+		return err
+	}
+	rules, err := s.parse(result.Body())
+	if err != nil {
+		return err
+	}
+
+	s.engine.SetRules(rules)
+	s.etag = result.Headers().Get("ETag")
+	logger.Printf("Loaded %d rules of authorization rule set %q from %s", len(rules), s.name, s.endpoint)
+	return nil
+}
+
+// verify fetches the signature of the bundle and checks that it matches the
+// contents
+func (s *BundleSyncer) verify(ctx context.Context, contents []byte) error {
+	result := requests.New(s.endpoint + BundleSignatureSuffix).
+		WithContext(ctx).
+		Do()
+	if result.Error() != nil {
+		return fmt.Errorf("error fetching bundle signature: %v", result.Error())
+	}
+	if result.StatusCode() != http.StatusOK {
+		return fmt.Errorf("unexpected status \"%d\" fetching bundle signature", result.StatusCode())
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(result.Body())))
+	if err != nil || len(signature) == 0 {
+		return errors.New("missing or invalid bundle signature")
+	}
+	h := hmac.New(s.hash.New, s.key)
+	_, _ = h.Write(contents)
+	if !hmac.Equal(signature, h.Sum(nil)) {
+		return errors.New("bundle signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package authorization
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BundleSyncer", func() {
+	const signingKey = "bundle-secret"
+
+	var server *httptest.Server
+	var bundle string
+	var signature string
+	var requests int
+	var engine *RulesEngine
+	var syncer *BundleSyncer
+
+	sign := func(key, body string) string {
+		h := hmac.New(crypto.SHA256.New, []byte(key))
+		_, _ = h.Write([]byte(body))
+		return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+
+	publish := func(paths string) {
+		bundle = paths
+		signature = sign(signingKey, bundle)
+	}
+
+	// parse builds a deny rule for each comma separated path regex
+	parse := func(contents []byte) ([]*Rule, error) {
+		rules := []*Rule{}
+		for _, path := range strings.Split(string(contents), ",") {
+			if path == "" {
+				return nil, errors.New("empty path")
+			}
+			rule, err := NewRule("deny-"+path, DenyPolicy, nil, path, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+		return rules, nil
+	}
+
+	denied := func(path string) bool {
+		return engine.Deny(httptest.NewRequest("GET", path, nil), nil)
+	}
+
+	BeforeEach(func() {
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/bundle.yaml":
+				requests++
+				etag := `"` + sign("etag", bundle) + `"`
+				if req.Header.Get("If-None-Match") == etag {
+					rw.WriteHeader(http.StatusNotModified)
+					return
+				}
+				rw.Header().Set("ETag", etag)
+				_, _ = rw.Write([]byte(bundle))
+			case "/bundle.yaml" + BundleSignatureSuffix:
+				_, _ = rw.Write([]byte(signature + "\n"))
+			default:
+				rw.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		engine = NewRulesEngine([]*Rule{}, nil)
+		var err error
+		syncer, err = NewBundleSyncer("remote", server.URL+"/bundle.yaml", crypto.SHA256, signingKey, engine, parse, 0)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("replaces the rules with the fetched bundle", func() {
+		publish("^/admin/")
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(denied("/admin/users")).To(BeTrue())
+
+		publish("^/api/")
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(denied("/admin/users")).To(BeFalse())
+		Expect(denied("/api/users")).To(BeTrue())
+	})
+
+	It("keeps the rules when the bundle has not changed", func() {
+		publish("^/admin/")
+		Expect(syncer.Sync()).To(Succeed())
+		engine.SetRules([]*Rule{})
+
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(requests).To(Equal(2))
+		Expect(denied("/admin/users")).To(BeFalse())
+	})
+
+	It("keeps the previous rules when the signature does not match", func() {
+		publish("^/admin/")
+		Expect(syncer.Sync()).To(Succeed())
+
+		bundle = "^/api/"
+		Expect(syncer.Sync()).To(MatchError("bundle signature does not match"))
+		Expect(denied("/admin/users")).To(BeTrue())
+		Expect(denied("/api/users")).To(BeFalse())
+	})
+
+	It("keeps the previous rules when the bundle is invalid", func() {
+		publish("^/admin/")
+		Expect(syncer.Sync()).To(Succeed())
+
+		publish("^/api/,")
+		Expect(syncer.Sync()).To(MatchError("empty path"))
+		Expect(denied("/admin/users")).To(BeTrue())
+	})
+
+	It("fails when the bundle cannot be fetched", func() {
+		var err error
+		syncer, err = NewBundleSyncer("remote", server.URL+"/missing.yaml", crypto.SHA256, signingKey, engine, parse, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(syncer.Sync()).To(MatchError(ContainSubstring(`unexpected status "404"`)))
+	})
+
+	It("requires a signature key", func() {
+		_, err := NewBundleSyncer("remote", server.URL+"/bundle.yaml", crypto.SHA256, "", engine, parse, 0)
+		Expect(err).To(MatchError(`rule set "remote" is fetched from ` + server.URL + `/bundle.yaml, but no signature key is set`))
+	})
+
+	DescribeTable("bundleEndpoint",
+		func(bundleURL, expected, expectedErr string) {
+			endpoint, err := bundleEndpoint(bundleURL)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(endpoint).To(Equal(expected))
+		},
+		Entry("an https URL", "https://policy.example.com/rules.yaml", "https://policy.example.com/rules.yaml", ""),
+		Entry("an S3 object", "s3://policies/proxy/rules.yaml", "https://policies.s3.amazonaws.com/proxy/rules.yaml", ""),
+		Entry("a GCS object", "gs://policies/proxy/rules.yaml", "https://storage.googleapis.com/policies/proxy/rules.yaml", ""),
+		Entry("an unsupported scheme", "ftp://policy.example.com/rules.yaml", "", `invalid bundle URL "ftp://policy.example.com/rules.yaml": unsupported scheme "ftp"`),
+		Entry("a missing bucket", "s3:///rules.yaml", "", `invalid bundle URL "s3:///rules.yaml": missing host or bucket`),
+	)
+})
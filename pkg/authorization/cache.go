@@ -0,0 +1,84 @@
+package authorization
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
+)
+
+// resultKey identifies the requests that always have the same result for a
+// policy, when no rule matches query parameters, headers or sessions
+type resultKey struct {
+	policy   Policy
+	upstream string
+	method   string
+	host     string
+	path     string
+	clientIP string
+}
+
+// resultEntry is the value of a resultKey in the cache
+type resultEntry struct {
+	key resultKey
+
+	// matched is the rule that matched the request, or nil if no rule
+	// matched
+	matched *Rule
+}
+
+// resultCache is a least recently used cache of the rule matched by requests
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[resultKey]*list.Element
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[resultKey]*list.Element, size),
+	}
+}
+
+// get returns the rule matched by the key, and whether the key was found
+func (c *resultCache) get(key resultKey) (*Rule, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*resultEntry).matched, true
+}
+
+// add stores the rule matched by the key, evicting the least recently used
+// key if the cache is full
+func (c *resultCache) add(key resultKey, matched *Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*resultEntry).matched = matched
+		c.order.MoveToFront(element)
+		return
+	}
+	if !memory.Allow(memory.AuthorizationResultCache) {
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resultEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&resultEntry{key: key, matched: matched})
+}
+
+// len returns the number of keys in the cache
+func (c *resultCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,112 @@
+package authorization
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization/index"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"google.golang.org/protobuf/proto"
+)
+
+// celEnv declares the variables expressions are evaluated against
+var celEnv *cel.Env
+
+func init() {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewVar("request", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("session", decls.NewMapType(decls.String, decls.Dyn)),
+	))
+	if err != nil {
+		panic(fmt.Sprintf("error creating CEL environment: %v", err))
+	}
+	celEnv = env
+}
+
+// Expression is a CEL expression that matches requests, for conditions that
+// the other matchers of a rule cannot express, e.g.
+// request.path.startsWith('/admin') && !('admins' in session.groups).
+//
+// The expression is given the method, host, path, raw query and client IP of
+// the request as the request map, and the user, email, groups and preferred
+// username of the session as the session map, and must evaluate to a bool.
+type Expression struct {
+	source  string
+	program cel.Program
+}
+
+// NewExpression parses and type checks the CEL expression
+func NewExpression(source string) (*Expression, error) {
+	ast, issues := celEnv.Compile(source)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("error compiling CEL expression %q: %v", source, issues.Err())
+	}
+	if !proto.Equal(ast.ResultType(), decls.Bool) && !proto.Equal(ast.ResultType(), decls.Dyn) {
+		return nil, fmt.Errorf("CEL expression %q must evaluate to a bool", source)
+	}
+
+	program, err := celEnv.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling CEL expression %q: %v", source, err)
+	}
+	return &Expression{
+		source:  source,
+		program: program,
+	}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// matches evaluates the expression for the request and session.
+// Expressions that cannot be evaluated, e.g. because they use a missing
+// session field, or that do not evaluate to a bool match if the policy is
+// DenyPolicy, so that deny rules fail closed.
+func (e *Expression) matches(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy) bool {
+	out, _, err := e.program.Eval(newExpressionInput(req, clientIP, session))
+	if err != nil {
+		logger.Errorf("Error evaluating CEL expression %q: %v", e.source, err)
+		return policy == DenyPolicy
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		logger.Errorf("CEL expression %q evaluated to %v, not a bool", e.source, out.Value())
+		return policy == DenyPolicy
+	}
+	return matched
+}
+
+// newExpressionInput builds the variables of an expression from the same
+// request and session fields that are sent to webhooks
+func newExpressionInput(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState) map[string]interface{} {
+	request := map[string]interface{}{
+		"method":   req.Method,
+		"host":     index.RequestHost(req),
+		"path":     req.URL.Path,
+		"query":    req.URL.RawQuery,
+		"clientIP": "",
+	}
+	if clientIP != nil {
+		request["clientIP"] = clientIP.String()
+	}
+
+	groups := []string{}
+	if session.Groups != nil {
+		groups = session.Groups
+	}
+	return map[string]interface{}{
+		"request": request,
+		"session": map[string]interface{}{
+			"user":              session.User,
+			"email":             session.Email,
+			"groups":            groups,
+			"preferredUsername": session.PreferredUsername,
+		},
+	}
+}
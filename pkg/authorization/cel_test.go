@@ -0,0 +1,118 @@
+package authorization
+
+import (
+	"net"
+	"net/http/httptest"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CEL Expression Suite", func() {
+	type expressionTableInput struct {
+		expression string
+		path       string
+		clientIP   string
+		session    *sessionsapi.SessionState
+		policy     Policy
+		expected   bool
+	}
+
+	DescribeTable("matches",
+		func(in expressionTableInput) {
+			expression, err := NewExpression(in.expression)
+			Expect(err).ToNot(HaveOccurred())
+			rule := &Rule{ID: "cel", Policy: in.policy, Expression: expression}
+
+			req := httptest.NewRequest("GET", "http://app.example.com"+in.path, nil)
+			Expect(rule.matches(req, net.ParseIP(in.clientIP), in.session)).To(Equal(in.expected))
+		},
+		Entry("a path without the group", expressionTableInput{
+			expression: "request.path.startsWith('/admin') && !('admins' in session.groups)",
+			path:       "/admin/users",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com", Groups: []string{"developers"}},
+			policy:     DenyPolicy,
+			expected:   true,
+		}),
+		Entry("a session without groups", expressionTableInput{
+			expression: "request.path.startsWith('/admin') && !('admins' in session.groups)",
+			path:       "/admin/users",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com"},
+			policy:     DenyPolicy,
+			expected:   true,
+		}),
+		Entry("the host, query and client IP", expressionTableInput{
+			expression: "request.host == 'app.example.com' && request.query == 'debug=1' && request.clientIP == '10.0.0.1'",
+			path:       "/?debug=1",
+			clientIP:   "10.0.0.1",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com"},
+			policy:     DenyPolicy,
+			expected:   true,
+		}),
+		Entry("the email", expressionTableInput{
+			expression: "session.email.endsWith('@contractor.example.com')",
+			path:       "/",
+			session:    &sessionsapi.SessionState{Email: "john.doe@contractor.example.com"},
+			policy:     DenyPolicy,
+			expected:   true,
+		}),
+		Entry("not a path with the group", expressionTableInput{
+			expression: "request.path.startsWith('/admin') && !('admins' in session.groups)",
+			path:       "/admin/users",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com", Groups: []string{"admins"}},
+			policy:     DenyPolicy,
+			expected:   false,
+		}),
+		Entry("not a request without a session", expressionTableInput{
+			expression: "request.path.startsWith('/admin')",
+			path:       "/admin/users",
+			policy:     DenyPolicy,
+			expected:   false,
+		}),
+		Entry("a deny rule when the expression fails", expressionTableInput{
+			expression: "session.claims.department == 'finance'",
+			path:       "/",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com"},
+			policy:     DenyPolicy,
+			expected:   true,
+		}),
+		Entry("not an allow rule when the expression fails", expressionTableInput{
+			expression: "session.claims.department == 'finance'",
+			path:       "/",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com"},
+			policy:     AllowPolicy,
+			expected:   false,
+		}),
+		Entry("a deny rule when the expression is not a bool", expressionTableInput{
+			expression: "request.path",
+			path:       "/",
+			session:    &sessionsapi.SessionState{Email: "jane.doe@example.com"},
+			policy:     DenyPolicy,
+			expected:   true,
+		}),
+	)
+
+	DescribeTable("fails to compile",
+		func(expression string, expectedErr string) {
+			_, err := NewExpression(expression)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix(expectedErr))
+		},
+		Entry("a syntax error", "request.path.startsWith(",
+			`error compiling CEL expression "request.path.startsWith(": `),
+		Entry("an undeclared variable", "user.email == 'jane.doe@example.com'",
+			`error compiling CEL expression "user.email == 'jane.doe@example.com'": `),
+		Entry("a result that is not a bool", "size(session.groups)",
+			`CEL expression "size(session.groups)" must evaluate to a bool`),
+	)
+
+	It("makes rules session rules that are not cached", func() {
+		expression, err := NewExpression("'admins' in session.groups")
+		Expect(err).ToNot(HaveOccurred())
+		rule := &Rule{ID: "cel", Policy: DenyPolicy, Expression: expression}
+		Expect(rule.hasSessionConditions()).To(BeTrue())
+		Expect(rule.hasUncachedConditions()).To(BeTrue())
+	})
+})
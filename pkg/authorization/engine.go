@@ -0,0 +1,522 @@
+package authorization
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization/index"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// RulesEngine evaluates requests against a set of allow and deny rules.
+//
+// Rules are checked in order of their priority, and then in the order they
+// were given, so that the evaluation order is predictable.
+// When there are more rules than the optimize threshold, the engine indexes
+// them by method, host, path, headers and client IP so that only candidate
+// rules need to be checked in full.
+// The indices that are consulted more often are moved first after a sample of
+// the matched requests.
+// With optimization disabled, every rule is checked in order instead.
+// With hit reordering enabled, rules that match more often are moved ahead of
+// rules with the same priority so that they are checked first.
+//
+// Requests are checked against an immutable snapshot of the rule and index
+// order without locking. Reordering copies the snapshot and replaces it.
+// With the result cache enabled, the rule matched by each method, host, path
+// and client IP is remembered until the rules are replaced.
+type RulesEngine struct {
+	realClientIPParser ipapi.RealClientIPParser
+	options            EngineOptions
+	reorder            bool
+	cacheSize          int
+	unoptimized        bool
+	disabledIndices    map[string]bool
+
+	// state holds the current *engineState
+	state atomic.Value
+
+	// mu serializes the replacement of the state
+	mu sync.Mutex
+}
+
+// engineState is the order that rules and indices are checked in.
+// It must not be modified once it has been stored in the engine.
+type engineState struct {
+	rules   []*Rule
+	indices []index.Index
+
+	// positions are the indices of the rules, in the order they are
+	// checked, in the rules sorted by priority, which identify them in the
+	// indices. They are part of the state rather than the rules, as the
+	// rules are shared with requests checking previous states.
+	positions []int
+
+	// cache holds the results for the rules, or is nil if the results
+	// cannot be cached
+	cache *resultCache
+}
+
+// EngineOptions tune when a rules engine optimizes the evaluation of its
+// rules
+type EngineOptions struct {
+	// OptimizeThreshold is the number of rules above which the rules are
+	// indexed
+	OptimizeThreshold int
+
+	// ReorderSampleRate is the fraction of matched requests, between 0 and 1,
+	// after which the indices are reordered by use. 0 never reorders them.
+	ReorderSampleRate float64
+}
+
+// DefaultEngineOptions index more than 5 rules, and reorder the indices after
+// 1% of matched requests
+var DefaultEngineOptions = EngineOptions{
+	OptimizeThreshold: 5,
+	ReorderSampleRate: 0.01,
+}
+
+// NewRulesEngine constructs a rules engine from the given rules, sorted by
+// their priority, with the DefaultEngineOptions
+func NewRulesEngine(rules []*Rule, realClientIPParser ipapi.RealClientIPParser) *RulesEngine {
+	e := &RulesEngine{
+		realClientIPParser: realClientIPParser,
+		options:            DefaultEngineOptions,
+	}
+	e.SetRules(rules)
+	return e
+}
+
+// SetRules replaces the rules of the engine, sorted by their priority.
+// Any cached results for the previous rules are discarded.
+func (e *RulesEngine) SetRules(rules []*Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setRulesLocked(rules)
+}
+
+// setRulesLocked replaces the rules of the engine, sorted by their priority.
+// e.mu must be held.
+func (e *RulesEngine) setRulesLocked(rules []*Rule) {
+	sorted := make([]*Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	positions := make([]int, len(sorted))
+	for position := range positions {
+		positions[position] = position
+	}
+	e.state.Store(&engineState{rules: sorted, positions: positions, indices: e.buildIndices(sorted), cache: e.newCache(sorted)})
+}
+
+// ReplaceRule replaces the rule with the same ID as the given rule, keeping
+// the order the rules were given in and the hits of the replaced rule.
+// It returns false if no rule has the ID.
+// The rules are read and replaced under the lock, so that concurrent
+// replacements of different rules do not undo each other.
+func (e *RulesEngine) ReplaceRule(rule *Rule) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := e.load().sortedRules()
+	replaced := false
+	for i, r := range rules {
+		if r.ID != rule.ID {
+			continue
+		}
+		atomic.AddUint64(&rule.hits, r.Hits())
+		rules[i] = rule
+		replaced = true
+	}
+	if replaced {
+		e.setRulesLocked(rules)
+	}
+	return replaced
+}
+
+// AddRule adds a rule, checked after the rules with the same priority.
+// It returns an error if another rule has the same ID.
+func (e *RulesEngine) AddRule(rule *Rule) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := e.load().sortedRules()
+	for _, r := range rules {
+		if r.ID == rule.ID {
+			return fmt.Errorf("rule %q already exists", rule.ID)
+		}
+	}
+	e.setRulesLocked(append(rules, rule))
+	return nil
+}
+
+// RemoveRule removes the rules with the given ID, keeping the order of the
+// other rules. It returns false if no rule has the ID.
+func (e *RulesEngine) RemoveRule(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := e.load().sortedRules()
+	kept := rules[:0]
+	for _, r := range rules {
+		if r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(rules) {
+		return false
+	}
+	e.setRulesLocked(kept)
+	return true
+}
+
+// EnableHitReordering allows rules that match more requests to be checked
+// before other rules with the same priority.
+// It must be called before the engine is used.
+func (e *RulesEngine) EnableHitReordering() {
+	e.reorder = true
+}
+
+// EnableResultCache remembers the rule matched by up to size combinations of
+// request method, host, path and client IP, so that repeated requests do not
+// evaluate the rules again.
+// Results are only cached when no rule or rule group matches query
+// parameters, headers or sessions, as those are not part of the cache key, and no rule is a shadow
+// rule.
+// It must be called before the engine is used.
+func (e *RulesEngine) EnableResultCache(size int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cacheSize = size
+	state := e.load()
+	e.state.Store(&engineState{rules: state.rules, positions: state.positions, indices: state.indices, cache: e.newCache(state.rules)})
+}
+
+// SetOptions changes when the engine optimizes the evaluation of its rules.
+// It must be called before the engine is used.
+func (e *RulesEngine) SetOptions(options EngineOptions) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.options = options
+	state := e.load()
+	e.state.Store(&engineState{rules: state.rules, positions: state.positions, indices: e.buildIndices(state.sortedRules()), cache: state.cache})
+}
+
+// DisableOptimization checks every rule in order, without indices or index
+// reordering, however many rules there are, so that the evaluation is
+// reproducible.
+// It must be called before the engine is used.
+func (e *RulesEngine) DisableOptimization() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unoptimized = true
+	state := e.load()
+	e.state.Store(&engineState{rules: state.rules, positions: state.positions, cache: state.cache})
+}
+
+// DisableIndices stops the engine from using the named indices of
+// IndexNames to narrow down the rules. Unknown names are ignored.
+// It must be called before the engine is used.
+func (e *RulesEngine) DisableIndices(names []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.disabledIndices = map[string]bool{}
+	for _, name := range names {
+		e.disabledIndices[name] = true
+	}
+	state := e.load()
+	e.state.Store(&engineState{rules: state.rules, positions: state.positions, indices: e.buildIndices(state.sortedRules()), cache: state.cache})
+}
+
+// Rules returns the rules in the order they are currently checked
+func (e *RulesEngine) Rules() []*Rule {
+	rules := e.load().rules
+	result := make([]*Rule, len(rules))
+	copy(result, rules)
+	return result
+}
+
+// Allow checks whether the request matches any allow rule.
+// The session is nil for unauthenticated requests.
+func (e *RulesEngine) Allow(req *http.Request, session *sessionsapi.SessionState) bool {
+	return e.MatchAllow(req, session) != nil
+}
+
+// Deny checks whether the request matches any deny rule.
+// The session is nil for unauthenticated requests.
+func (e *RulesEngine) Deny(req *http.Request, session *sessionsapi.SessionState) bool {
+	return e.MatchDeny(req, session) != nil
+}
+
+// MatchAllow returns the first allow rule the request matches, or nil if it
+// matches none.
+// Rules scoped to upstreams are only checked by ForUpstream.
+func (e *RulesEngine) MatchAllow(req *http.Request, session *sessionsapi.SessionState) *Rule {
+	return e.check(req, session, AllowPolicy, "")
+}
+
+// MatchDeny returns the first deny rule the request matches, or nil if it
+// matches none.
+// Rules scoped to upstreams are only checked by ForUpstream.
+func (e *RulesEngine) MatchDeny(req *http.Request, session *sessionsapi.SessionState) *Rule {
+	return e.check(req, session, DenyPolicy, "")
+}
+
+// HasSessionRules checks whether any rule has session conditions, in which
+// case the rules must be checked again once the request is authenticated
+func (e *RulesEngine) HasSessionRules() bool {
+	return e.hasSessionRules("")
+}
+
+// ForUpstream selects the rules for requests routed to the upstream with the
+// given ID: the rules scoped to the upstream and the rules for all upstreams.
+// An empty ID selects only the rules for all upstreams.
+func (e *RulesEngine) ForUpstream(id string) *UpstreamRules {
+	return &UpstreamRules{engine: e, upstream: id}
+}
+
+// UpstreamRules are the rules of an engine for requests routed to an upstream
+type UpstreamRules struct {
+	engine   *RulesEngine
+	upstream string
+}
+
+// MatchAllow returns the first allow rule for the upstream the request
+// matches, or nil if it matches none
+func (u *UpstreamRules) MatchAllow(req *http.Request, session *sessionsapi.SessionState) *Rule {
+	return u.engine.check(req, session, AllowPolicy, u.upstream)
+}
+
+// MatchDeny returns the first deny rule for the upstream the request
+// matches, or nil if it matches none
+func (u *UpstreamRules) MatchDeny(req *http.Request, session *sessionsapi.SessionState) *Rule {
+	return u.engine.check(req, session, DenyPolicy, u.upstream)
+}
+
+// HasSessionRules checks whether any rule for the upstream has session
+// conditions
+func (u *UpstreamRules) HasSessionRules() bool {
+	return u.engine.hasSessionRules(u.upstream)
+}
+
+func (e *RulesEngine) hasSessionRules(upstream string) bool {
+	for _, rule := range e.load().rules {
+		if rule.appliesTo(upstream) && rule.hasSessionConditions() {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RulesEngine) load() *engineState {
+	return e.state.Load().(*engineState)
+}
+
+// sortedRules returns a copy of the rules in the order they were sorted in by
+// their priority, before any reordering
+func (s *engineState) sortedRules() []*Rule {
+	rules := make([]*Rule, len(s.rules))
+	for i, rule := range s.rules {
+		rules[s.positions[i]] = rule
+	}
+	return rules
+}
+
+// newCache creates an empty result cache for the rules, or returns nil if
+// caching is disabled or the results depend on more than the cache key.
+// Shadow rules must see every request, so they also disable the cache.
+func (e *RulesEngine) newCache(rules []*Rule) *resultCache {
+	if e.cacheSize <= 0 {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.hasUncachedConditions() || rule.Shadow {
+			return nil
+		}
+	}
+	return newResultCache(e.cacheSize)
+}
+
+// IndexNames are the names of the indices the engine narrows down the rules
+// with
+var IndexNames = []string{"path", "host", "methods", "headers", "ips"}
+
+// buildIndices indexes the rules by their position in the original order.
+// It returns no indices when there are no more rules than the optimize
+// threshold, or optimization is disabled.
+func (e *RulesEngine) buildIndices(rules []*Rule) []index.Index {
+	if e.unoptimized || len(rules) <= e.options.OptimizeThreshold {
+		return nil
+	}
+
+	paths := index.NewPathIndex()
+	hosts := index.NewHostIndex()
+	methods := index.NewMethodsIndex()
+	headers := index.NewHeadersIndex()
+	ips := index.NewIPsIndex()
+	for position, rule := range rules {
+		paths.Add(position, rule.Path)
+		hosts.Add(position, rule.Hosts, len(rule.HostRegexes) > 0)
+		methods.Add(position, rule.Methods)
+		headers.Add(position, rule.Headers)
+		ips.Add(position, rule.IPs)
+	}
+	indices := []index.Index{}
+	for _, idx := range []index.Index{paths, hosts, methods, headers, ips} {
+		if !e.disabledIndices[idx.Name()] {
+			indices = append(indices, idx)
+		}
+	}
+	return indices
+}
+
+// check evaluates the rules with the given policy for the upstream against the
+// request and returns the rule that matched
+func (e *RulesEngine) check(req *http.Request, session *sessionsapi.SessionState, policy Policy, upstream string) *Rule {
+	clientIP, err := ip.GetClientIP(e.realClientIPParser, req)
+	if err != nil {
+		// Rules with IPs will not match, the rest are still checked
+		logger.Errorf("Error obtaining real IP for authorization rules: %v", err)
+	}
+
+	state := e.load()
+	var matched *Rule
+	if state.cache == nil {
+		matched = state.match(req, clientIP, session, policy, upstream)
+	} else {
+		key := resultKey{
+			policy:   policy,
+			upstream: upstream,
+			method:   req.Method,
+			host:     index.RequestHost(req),
+			path:     req.URL.Path,
+		}
+		if clientIP != nil {
+			key.clientIP = clientIP.String()
+		}
+		var ok bool
+		if matched, ok = state.cache.get(key); !ok {
+			matched = state.match(req, clientIP, session, policy, upstream)
+			state.cache.add(key, matched)
+		}
+	}
+
+	if matched == nil {
+		return nil
+	}
+	matched.hit()
+	if e.reorder {
+		e.prioritizeRule(matched)
+	}
+	if len(state.indices) > 0 && e.options.ReorderSampleRate > 0 && rand.Float64() < e.options.ReorderSampleRate {
+		e.prioritizeIndices()
+	}
+	return matched
+}
+
+// match returns the first enforced rule with the given policy for the upstream
+// that matches the request, or nil if no rule matches.
+// Shadow rules checked before it are counted and logged when they match.
+func (s *engineState) match(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy, upstream string) *Rule {
+	candidates := s.candidates(req, clientIP)
+	for i, rule := range s.rules {
+		if rule.Policy != policy || !rule.appliesTo(upstream) {
+			continue
+		}
+		if candidates != nil && !candidates.Has(s.positions[i]) {
+			continue
+		}
+		if !rule.matches(req, clientIP, session) {
+			continue
+		}
+		if rule.Shadow {
+			rule.hit()
+			logger.Printf("Shadow %s rule %s matched %s %s", rule.Policy, rule, req.Method, req.URL.Path)
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// candidates narrows down the rules using each of the indices.
+// It returns nil if the rules cannot be narrowed down.
+func (s *engineState) candidates(req *http.Request, clientIP net.IP) index.Set {
+	var candidates index.Set
+	for _, idx := range s.indices {
+		set, ok := idx.Candidates(req, clientIP)
+		if !ok {
+			continue
+		}
+		if candidates == nil {
+			candidates = set
+		} else {
+			candidates = candidates.Intersect(set)
+		}
+		if len(candidates) == 0 {
+			break
+		}
+	}
+	return candidates
+}
+
+// prioritizeRule moves the rule ahead of the previous rule if it has the
+// same priority and has matched more requests
+func (e *RulesEngine) prioritizeRule(rule *Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// The order may have changed since the request was checked
+	state := e.load()
+	i := -1
+	for j, r := range state.rules {
+		if r == rule {
+			i = j
+			break
+		}
+	}
+	if i <= 0 {
+		return
+	}
+	previous := state.rules[i-1]
+	if rule.Priority != previous.Priority || rule.Hits() <= previous.Hits() {
+		return
+	}
+
+	rules := make([]*Rule, len(state.rules))
+	copy(rules, state.rules)
+	rules[i], rules[i-1] = rules[i-1], rules[i]
+	positions := make([]int, len(state.positions))
+	copy(positions, state.positions)
+	positions[i], positions[i-1] = positions[i-1], positions[i]
+	e.state.Store(&engineState{rules: rules, positions: positions, indices: state.indices, cache: state.cache})
+}
+
+// prioritizeIndices orders the indices by the number of requests they have
+// found indexed rules for, so that the most useful are consulted first
+func (e *RulesEngine) prioritizeIndices() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Take the counts once as they change while sorting
+	state := e.load()
+	indices := make([]index.Index, len(state.indices))
+	hits := make(map[index.Index]uint64, len(state.indices))
+	for i, idx := range state.indices {
+		indices[i] = idx
+		hits[idx] = idx.Hits()
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return hits[indices[i]] > hits[indices[j]]
+	})
+	e.state.Store(&engineState{rules: state.rules, positions: state.positions, indices: indices, cache: state.cache})
+}
@@ -0,0 +1,594 @@
+package authorization
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RulesEngine Suite", func() {
+	newRule := func(id string, policy Policy, methods []string, path string, ips []string) *Rule {
+		rule, err := NewRule(id, policy, methods, path, nil, ips)
+		Expect(err).ToNot(HaveOccurred())
+		return rule
+	}
+
+	type checkTableInput struct {
+		method        string
+		host          string
+		path          string
+		remoteAddr    string
+		expectAllowed bool
+		expectDenied  bool
+	}
+
+	// Each table is run with few rules, which are checked in order, and with
+	// padding rules that enable the indices
+	for _, padding := range []int{0, 10} {
+		padding := padding
+
+		Context(fmt.Sprintf("with %d additional rules", padding), func() {
+			var engine *RulesEngine
+
+			BeforeEach(func() {
+				denyHost := newRule("deny-host", DenyPolicy, nil, "", nil)
+				Expect(denyHost.AddHosts("legacy.example.com")).To(Succeed())
+
+				rules := []*Rule{
+					newRule("deny-admin", DenyPolicy, nil, "^/admin/", nil),
+					denyHost,
+					newRule("deny-ip", DenyPolicy, nil, "", []string{"192.168.0.0/16"}),
+					newRule("allow-health", AllowPolicy, []string{"GET"}, "^/health$", nil),
+					newRule("allow-internal", AllowPolicy, nil, "", []string{"10.0.0.0/8"}),
+				}
+				for i := 0; i < padding; i++ {
+					rules = append(rules, newRule(fmt.Sprintf("padding-%d", i), AllowPolicy, []string{"PUT"}, fmt.Sprintf("^/padding/%d$", i), nil))
+				}
+				engine = NewRulesEngine(rules, nil)
+				if padding > 0 {
+					Expect(engine.load().indices).ToNot(BeEmpty())
+					engine.EnableHitReordering()
+				} else {
+					Expect(engine.load().indices).To(BeEmpty())
+				}
+			})
+
+			DescribeTable("Allow and Deny",
+				func(in checkTableInput) {
+					req := httptest.NewRequest(in.method, in.path, nil)
+					req.RemoteAddr = in.remoteAddr
+					if in.host != "" {
+						req.Host = in.host
+					}
+
+					// Check more than once so that rules are reordered
+					for i := 0; i < 3; i++ {
+						Expect(engine.Allow(req, nil)).To(Equal(in.expectAllowed))
+						Expect(engine.Deny(req, nil)).To(Equal(in.expectDenied))
+					}
+				},
+				Entry("with a request matching no rules", checkTableInput{
+					method:     "GET",
+					path:       "/",
+					remoteAddr: "127.0.0.1:1234",
+				}),
+				Entry("with a denied path", checkTableInput{
+					method:       "GET",
+					path:         "/admin/users",
+					remoteAddr:   "127.0.0.1:1234",
+					expectDenied: true,
+				}),
+				Entry("with a denied host", checkTableInput{
+					method:       "GET",
+					host:         "legacy.example.com",
+					path:         "/",
+					remoteAddr:   "127.0.0.1:1234",
+					expectDenied: true,
+				}),
+				Entry("with a denied IP", checkTableInput{
+					method:       "GET",
+					path:         "/",
+					remoteAddr:   "192.168.1.1:1234",
+					expectDenied: true,
+				}),
+				Entry("with an allowed method and path", checkTableInput{
+					method:        "GET",
+					path:          "/health",
+					remoteAddr:    "127.0.0.1:1234",
+					expectAllowed: true,
+				}),
+				Entry("with an allowed path and the wrong method", checkTableInput{
+					method:     "POST",
+					path:       "/health",
+					remoteAddr: "127.0.0.1:1234",
+				}),
+				Entry("with an allowed IP", checkTableInput{
+					method:        "POST",
+					path:          "/anything",
+					remoteAddr:    "10.0.0.1:1234",
+					expectAllowed: true,
+				}),
+				Entry("with a request matching allow and deny rules", checkTableInput{
+					method:        "GET",
+					path:          "/admin/",
+					remoteAddr:    "10.0.0.1:1234",
+					expectAllowed: true,
+					expectDenied:  true,
+				}),
+			)
+		})
+	}
+
+	It("returns the rule the request matches", func() {
+		denyAdmin := newRule("deny-admin", DenyPolicy, nil, "^/admin/", nil)
+		denyAll := newRule("deny-all", DenyPolicy, nil, "", nil)
+		allowHealth := newRule("allow-health", AllowPolicy, nil, "^/health$", nil)
+		engine := NewRulesEngine([]*Rule{denyAdmin, denyAll, allowHealth}, nil)
+
+		Expect(engine.MatchDeny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeIdenticalTo(denyAdmin))
+		Expect(engine.MatchDeny(httptest.NewRequest("GET", "/health", nil), nil)).To(BeIdenticalTo(denyAll))
+		Expect(engine.MatchAllow(httptest.NewRequest("GET", "/health", nil), nil)).To(BeIdenticalTo(allowHealth))
+		Expect(engine.MatchAllow(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeNil())
+	})
+
+	It("counts the requests each rule matches", func() {
+		rule := newRule("deny-admin", DenyPolicy, nil, "^/admin/", nil)
+		engine := NewRulesEngine([]*Rule{rule}, nil)
+
+		Expect(engine.Deny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeTrue())
+		Expect(engine.Deny(httptest.NewRequest("GET", "/public", nil), nil)).To(BeFalse())
+		Expect(rule.Hits()).To(Equal(uint64(1)))
+	})
+
+	It("checks session conditions against the session", func() {
+		rule := newRule("deny-contractors", DenyPolicy, nil, "^/admin/", nil)
+		rule.Groups = []string{"contractors"}
+		engine := NewRulesEngine([]*Rule{rule}, nil)
+		Expect(engine.HasSessionRules()).To(BeTrue())
+
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		Expect(engine.Deny(req, nil)).To(BeFalse())
+		Expect(engine.Deny(req, &sessionsapi.SessionState{Groups: []string{"employees"}})).To(BeFalse())
+		Expect(engine.Deny(req, &sessionsapi.SessionState{Groups: []string{"contractors"}})).To(BeTrue())
+	})
+
+	It("counts shadow rules without enforcing them", func() {
+		shadow := newRule("deny-api", DenyPolicy, nil, "^/api/", nil)
+		shadow.Shadow = true
+		enforced := newRule("deny-all", DenyPolicy, nil, "^/", nil)
+		enforced.Priority = 1
+		engine := NewRulesEngine([]*Rule{shadow, newRule("deny-admin", DenyPolicy, nil, "^/admin/", nil), enforced}, nil)
+		engine.EnableResultCache(10)
+		Expect(engine.load().cache).To(BeNil())
+
+		Expect(engine.MatchDeny(httptest.NewRequest("GET", "/api/users", nil), nil)).To(Equal(enforced))
+		Expect(engine.MatchDeny(httptest.NewRequest("GET", "/api/users", nil), nil)).To(Equal(enforced))
+		Expect(engine.MatchDeny(httptest.NewRequest("GET", "/admin/users", nil), nil).ID).To(Equal("deny-admin"))
+		Expect(shadow.Hits()).To(Equal(uint64(2)))
+		Expect(enforced.Hits()).To(Equal(uint64(2)))
+
+		enforced.Shadow = true
+		engine.SetRules([]*Rule{shadow, enforced})
+		Expect(engine.Deny(httptest.NewRequest("GET", "/api/users", nil), nil)).To(BeFalse())
+		Expect(shadow.Hits()).To(Equal(uint64(3)))
+		Expect(enforced.Hits()).To(Equal(uint64(3)))
+	})
+
+	It("checks rules scoped to upstreams only for those upstreams", func() {
+		denyAPIAdmin := newRule("deny-api-admin", DenyPolicy, nil, "^/admin/", nil)
+		denyAPIAdmin.Upstreams = []string{"api"}
+		denyContractors := newRule("deny-contractors", DenyPolicy, nil, "^/admin/", nil)
+		denyContractors.Groups = []string{"contractors"}
+		denyContractors.Upstreams = []string{"web"}
+		denyDebug := newRule("deny-debug", DenyPolicy, nil, "^/debug/", nil)
+		engine := NewRulesEngine([]*Rule{denyAPIAdmin, denyContractors, denyDebug}, nil)
+		engine.EnableResultCache(10)
+
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		Expect(engine.ForUpstream("api").MatchDeny(req, nil)).To(BeIdenticalTo(denyAPIAdmin))
+		Expect(engine.ForUpstream("web").MatchDeny(req, nil)).To(BeNil())
+		Expect(engine.ForUpstream("").MatchDeny(req, nil)).To(BeNil())
+		Expect(engine.MatchDeny(req, nil)).To(BeNil())
+
+		req = httptest.NewRequest("GET", "/debug/vars", nil)
+		Expect(engine.ForUpstream("api").MatchDeny(req, nil)).To(BeIdenticalTo(denyDebug))
+		Expect(engine.ForUpstream("web").MatchDeny(req, nil)).To(BeIdenticalTo(denyDebug))
+
+		Expect(engine.ForUpstream("web").HasSessionRules()).To(BeTrue())
+		Expect(engine.ForUpstream("api").HasSessionRules()).To(BeFalse())
+		Expect(engine.HasSessionRules()).To(BeFalse())
+	})
+
+	It("replaces rules by ID in the configured order", func() {
+		denyAdmin := newRule("deny-admin", DenyPolicy, nil, "^/admin/", nil)
+		denyAPI := newRule("deny-api", DenyPolicy, nil, "^/api/", nil)
+		engine := NewRulesEngine([]*Rule{denyAdmin, denyAPI}, nil)
+		Expect(engine.Deny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeTrue())
+
+		replacement := newRule("deny-admin", DenyPolicy, nil, "^/internal/", nil)
+		Expect(engine.ReplaceRule(replacement)).To(BeTrue())
+		Expect(engine.Rules()).To(Equal([]*Rule{replacement, denyAPI}))
+		Expect(replacement.Hits()).To(Equal(uint64(1)))
+		Expect(engine.Deny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeFalse())
+		Expect(engine.Deny(httptest.NewRequest("GET", "/internal/users", nil), nil)).To(BeTrue())
+
+		Expect(engine.ReplaceRule(newRule("deny-unknown", DenyPolicy, nil, "", nil))).To(BeFalse())
+	})
+
+	It("adds and removes rules by ID", func() {
+		denyAdmin := newRule("deny-admin", DenyPolicy, nil, "^/admin/", nil)
+		engine := NewRulesEngine([]*Rule{denyAdmin}, nil)
+
+		denyAPI := newRule("deny-api", DenyPolicy, nil, "^/api/", nil)
+		Expect(engine.AddRule(denyAPI)).To(Succeed())
+		Expect(engine.Rules()).To(Equal([]*Rule{denyAdmin, denyAPI}))
+		Expect(engine.Deny(httptest.NewRequest("GET", "/api/users", nil), nil)).To(BeTrue())
+		Expect(engine.AddRule(newRule("deny-api", DenyPolicy, nil, "^/v2/", nil))).To(MatchError(`rule "deny-api" already exists`))
+
+		Expect(engine.RemoveRule("deny-admin")).To(BeTrue())
+		Expect(engine.Rules()).To(Equal([]*Rule{denyAPI}))
+		Expect(engine.Deny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeFalse())
+		Expect(engine.RemoveRule("deny-admin")).To(BeFalse())
+	})
+
+	It("keeps concurrent replacements of different rules", func() {
+		rules := []*Rule{}
+		for i := 0; i < 8; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), DenyPolicy, nil, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+
+		replacements := make([]*Rule, len(rules))
+		var wg sync.WaitGroup
+		for i := range rules {
+			replacements[i] = newRule(fmt.Sprintf("rule-%d", i), DenyPolicy, nil, fmt.Sprintf("^/replaced/%d$", i), nil)
+			wg.Add(1)
+			go func(replacement *Rule) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(engine.ReplaceRule(replacement)).To(BeTrue())
+			}(replacements[i])
+		}
+		wg.Wait()
+
+		Expect(engine.Rules()).To(Equal(replacements))
+	})
+
+	It("moves rules that match more often to the front", func() {
+		rules := []*Rule{}
+		for i := 0; i < 6; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, nil, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+		engine.EnableHitReordering()
+
+		Expect(engine.Allow(httptest.NewRequest("GET", "/5", nil), nil)).To(BeTrue())
+		Expect(engine.Rules()[4].ID).To(Equal("rule-5"))
+		Expect(engine.Allow(httptest.NewRequest("GET", "/5", nil), nil)).To(BeTrue())
+		Expect(engine.Rules()[3].ID).To(Equal("rule-5"))
+	})
+
+	It("keeps the configured order without hit reordering", func() {
+		rules := []*Rule{}
+		for i := 0; i < 6; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, nil, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+
+		for i := 0; i < 3; i++ {
+			Expect(engine.Allow(httptest.NewRequest("GET", "/5", nil), nil)).To(BeTrue())
+		}
+		Expect(engine.Rules()[5].ID).To(Equal("rule-5"))
+	})
+
+	It("checks every rule in order with optimization disabled", func() {
+		rules := []*Rule{}
+		for i := 0; i < 10; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, nil, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+		Expect(engine.load().indices).ToNot(BeEmpty())
+		engine.DisableOptimization()
+		Expect(engine.load().indices).To(BeEmpty())
+
+		engine.SetRules(rules)
+		Expect(engine.load().indices).To(BeEmpty())
+		Expect(engine.MatchAllow(httptest.NewRequest("GET", "/9", nil), nil)).To(Equal(rules[9]))
+	})
+
+	It("indexes rules above the optimize threshold", func() {
+		rules := []*Rule{}
+		for i := 0; i < 3; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, nil, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+		Expect(engine.load().indices).To(BeEmpty())
+
+		engine.SetOptions(EngineOptions{OptimizeThreshold: 2})
+		Expect(engine.load().indices).ToNot(BeEmpty())
+		engine.SetOptions(EngineOptions{OptimizeThreshold: 3})
+		Expect(engine.load().indices).To(BeEmpty())
+	})
+
+	It("reorders the indices at the sample rate", func() {
+		rules := []*Rule{}
+		for i := 0; i < 10; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, []string{"GET"}, "", nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+		names := func() []string {
+			names := []string{}
+			for _, idx := range engine.load().indices {
+				names = append(names, idx.Name())
+			}
+			return names
+		}
+
+		engine.SetOptions(EngineOptions{OptimizeThreshold: 5, ReorderSampleRate: 0})
+		for i := 0; i < 10; i++ {
+			Expect(engine.Allow(httptest.NewRequest("GET", "/", nil), nil)).To(BeTrue())
+		}
+		Expect(names()[0]).To(Equal("path"))
+
+		engine.SetOptions(EngineOptions{OptimizeThreshold: 5, ReorderSampleRate: 1})
+		Expect(engine.Allow(httptest.NewRequest("GET", "/", nil), nil)).To(BeTrue())
+		Expect(names()[0]).To(Equal("methods"))
+	})
+
+	It("does not narrow down the rules with disabled indices", func() {
+		rules := []*Rule{}
+		for i := 0; i < 10; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, nil, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+		engine.DisableIndices([]string{"path", "ips"})
+
+		names := []string{}
+		for _, idx := range engine.load().indices {
+			names = append(names, idx.Name())
+		}
+		Expect(names).To(Equal([]string{"host", "methods", "headers"}))
+		Expect(engine.MatchAllow(httptest.NewRequest("GET", "/9", nil), nil)).To(Equal(rules[9]))
+	})
+
+	It("checks rules in order of priority", func() {
+		low := newRule("low", AllowPolicy, nil, "^/", nil)
+		low.Priority = 10
+		high := newRule("high", AllowPolicy, nil, "^/", nil)
+		high.Priority = -1
+		engine := NewRulesEngine([]*Rule{newRule("default", AllowPolicy, nil, "^/", nil), low, high}, nil)
+		Expect([]string{engine.Rules()[0].ID, engine.Rules()[1].ID, engine.Rules()[2].ID}).To(Equal([]string{"high", "default", "low"}))
+
+		Expect(engine.Allow(httptest.NewRequest("GET", "/", nil), nil)).To(BeTrue())
+		Expect(high.Hits()).To(Equal(uint64(1)))
+		Expect(low.Hits()).To(BeZero())
+	})
+
+	It("does not reorder rules ahead of a higher priority", func() {
+		rules := []*Rule{}
+		for i := 0; i < 6; i++ {
+			rule := newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, nil, fmt.Sprintf("^/%d$", i), nil)
+			rule.Priority = i / 3
+			rules = append(rules, rule)
+		}
+		engine := NewRulesEngine(rules, nil)
+		engine.EnableHitReordering()
+
+		for i := 0; i < 5; i++ {
+			Expect(engine.Allow(httptest.NewRequest("GET", "/3", nil), nil)).To(BeTrue())
+		}
+		Expect(engine.Rules()[3].ID).To(Equal("rule-3"))
+	})
+
+	Context("with the result cache", func() {
+		var rule *Rule
+		var engine *RulesEngine
+
+		BeforeEach(func() {
+			rule = newRule("deny-admin", DenyPolicy, nil, "^/admin/", []string{"10.0.0.0/8"})
+			engine = NewRulesEngine([]*Rule{rule}, nil)
+			engine.EnableResultCache(2)
+		})
+
+		newRequest := func(path, remoteAddr string) *http.Request {
+			req := httptest.NewRequest("GET", path, nil)
+			req.RemoteAddr = remoteAddr
+			return req
+		}
+
+		It("caches results by method, host, path and client IP", func() {
+			Expect(engine.Deny(newRequest("/admin/users", "10.0.0.1:1234"), nil)).To(BeTrue())
+			Expect(engine.Deny(newRequest("/admin/users", "10.0.0.1:5678"), nil)).To(BeTrue())
+			Expect(engine.Deny(newRequest("/admin/users", "192.168.0.1:1234"), nil)).To(BeFalse())
+			Expect(engine.load().cache.len()).To(Equal(2))
+			Expect(rule.Hits()).To(Equal(uint64(2)))
+		})
+
+		It("evicts the least recently used result", func() {
+			cache := engine.load().cache
+			Expect(engine.Deny(newRequest("/admin/a", "10.0.0.1:1234"), nil)).To(BeTrue())
+			Expect(engine.Deny(newRequest("/admin/b", "10.0.0.1:1234"), nil)).To(BeTrue())
+			Expect(engine.Deny(newRequest("/admin/a", "10.0.0.1:1234"), nil)).To(BeTrue())
+			Expect(engine.Deny(newRequest("/public", "10.0.0.1:1234"), nil)).To(BeFalse())
+			Expect(cache.len()).To(Equal(2))
+
+			_, ok := cache.get(resultKey{policy: DenyPolicy, method: "GET", host: "example.com", path: "/admin/a", clientIP: "10.0.0.1"})
+			Expect(ok).To(BeTrue())
+			_, ok = cache.get(resultKey{policy: DenyPolicy, method: "GET", host: "example.com", path: "/admin/b", clientIP: "10.0.0.1"})
+			Expect(ok).To(BeFalse())
+		})
+
+		It("discards the results when the rules are replaced", func() {
+			Expect(engine.Deny(newRequest("/admin/users", "10.0.0.1:1234"), nil)).To(BeTrue())
+
+			engine.SetRules([]*Rule{newRule("deny-api", DenyPolicy, nil, "^/api/", nil)})
+			Expect(engine.load().cache.len()).To(BeZero())
+			Expect(engine.Deny(newRequest("/admin/users", "10.0.0.1:1234"), nil)).To(BeFalse())
+			Expect(engine.Deny(newRequest("/api/users", "10.0.0.1:1234"), nil)).To(BeTrue())
+		})
+
+		It("does not cache results of rules with session conditions", func() {
+			rule.Groups = []string{"contractors"}
+			engine.SetRules([]*Rule{rule})
+			Expect(engine.load().cache).To(BeNil())
+
+			req := newRequest("/admin/users", "10.0.0.1:1234")
+			Expect(engine.Deny(req, &sessionsapi.SessionState{Groups: []string{"employees"}})).To(BeFalse())
+			Expect(engine.Deny(req, &sessionsapi.SessionState{Groups: []string{"contractors"}})).To(BeTrue())
+		})
+	})
+
+	It("checks and reorders rules concurrently", func() {
+		rules := []*Rule{}
+		for i := 0; i < 10; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, []string{"GET"}, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+		engine.EnableHitReordering()
+
+		var wg sync.WaitGroup
+		for worker := 0; worker < 8; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					path := fmt.Sprintf("/%d", (worker+i)%10)
+					Expect(engine.Allow(httptest.NewRequest("GET", path, nil), nil)).To(BeTrue())
+					Expect(engine.Deny(httptest.NewRequest("GET", path, nil), nil)).To(BeFalse())
+				}
+			}(worker)
+		}
+		wg.Wait()
+
+		total := uint64(0)
+		for _, rule := range engine.Rules() {
+			total += rule.Hits()
+		}
+		Expect(total).To(Equal(uint64(8 * 500)))
+		Expect(engine.Rules()).To(ConsistOf(rules))
+	})
+
+	It("checks rules while the same rules are set again in another order", func() {
+		rules := []*Rule{}
+		for i := 0; i < 10; i++ {
+			rules = append(rules, newRule(fmt.Sprintf("rule-%d", i), AllowPolicy, []string{"GET"}, fmt.Sprintf("^/%d$", i), nil))
+		}
+		engine := NewRulesEngine(rules, nil)
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+			reordered := make([]*Rule, len(rules))
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for j, rule := range rules {
+					reordered[(j+i)%len(rules)] = rule
+				}
+				engine.SetRules(reordered)
+			}
+		}()
+
+		for i := 0; i < 1000; i++ {
+			Expect(engine.Allow(httptest.NewRequest("GET", fmt.Sprintf("/%d", i%10), nil), nil)).To(BeTrue())
+		}
+		close(done)
+		wg.Wait()
+	})
+})
+
+// benchmarkRules builds deny rules for distinct path prefixes, a quarter of
+// which are also restricted to methods, client IPs or hosts
+func benchmarkRules(b *testing.B, count int) []*Rule {
+	rules := make([]*Rule, 0, count)
+	for i := 0; i < count; i++ {
+		var methods, ips []string
+		switch i % 4 {
+		case 1:
+			methods = []string{"POST", "PUT", "DELETE"}
+		case 2:
+			ips = []string{fmt.Sprintf("10.%d.%d.0/24", i/256%256, i%256)}
+		}
+		rule, err := NewRule(fmt.Sprintf("rule-%d", i), DenyPolicy, methods, fmt.Sprintf("^/service-%d/admin", i), nil, ips)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if i%4 == 3 {
+			if err := rule.AddHosts(fmt.Sprintf("service-%d.example.com", i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func BenchmarkRulesEngine(b *testing.B) {
+	type selection struct {
+		name      string
+		configure func(*RulesEngine)
+	}
+	selections := []selection{
+		{name: "linear scan", configure: func(e *RulesEngine) { e.DisableOptimization() }},
+		{name: "all indices", configure: func(e *RulesEngine) {}},
+	}
+	for _, name := range IndexNames {
+		disabled := []string{}
+		for _, other := range IndexNames {
+			if other != name {
+				disabled = append(disabled, other)
+			}
+		}
+		selections = append(selections, selection{
+			name:      fmt.Sprintf("%s index", name),
+			configure: func(e *RulesEngine) { e.DisableIndices(disabled) },
+		})
+	}
+
+	for _, count := range []int{10, 100, 1000} {
+		rules := benchmarkRules(b, count)
+		// The last of the rules that are only restricted by their path
+		last := (count - 1) / 4 * 4
+
+		requests := []struct {
+			name string
+			req  *http.Request
+		}{
+			{name: "no match", req: httptest.NewRequest("GET", "http://app.example.com/service-1/public", nil)},
+			{name: "unknown prefix", req: httptest.NewRequest("POST", "http://app.example.com/static/app.js", nil)},
+			{name: "last rule", req: httptest.NewRequest("GET", fmt.Sprintf("http://app.example.com/service-%d/admin", last), nil)},
+		}
+
+		for _, s := range selections {
+			engine := NewRulesEngine(rules, nil)
+			// Indices are not reordered, so that the results are comparable
+			engine.SetOptions(EngineOptions{OptimizeThreshold: DefaultEngineOptions.OptimizeThreshold})
+			s.configure(engine)
+
+			for _, r := range requests {
+				req := r.req
+				b.Run(fmt.Sprintf("%d rules/%s/%s", count, s.name, r.name), func(b *testing.B) {
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						engine.MatchDeny(req, nil)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package authorization
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// Operator is how a rule group combines its conditions
+type Operator int
+
+const (
+	// AndOperator groups match requests that match all of their conditions
+	AndOperator Operator = iota
+
+	// OrOperator groups match requests that match any of their conditions
+	OrOperator
+)
+
+// String returns the name of the operator
+func (o Operator) String() string {
+	switch o {
+	case AndOperator:
+		return "and"
+	case OrOperator:
+		return "or"
+	default:
+		return fmt.Sprintf("Operator(%d)", int(o))
+	}
+}
+
+// RuleGroup combines conditions with AND or OR, and can be nested, to match
+// requests that a single Rule cannot express, such as a path AND either an IP
+// range OR a header.
+//
+// Each condition is a Rule whose matchers are combined with AND, as in a
+// rule. Only the matchers of conditions are used: their ID, policy, action,
+// priority, shadow and group are ignored.
+// An AND group without conditions matches all requests, and an OR group
+// without conditions matches none.
+type RuleGroup struct {
+	// Operator combines the conditions and nested groups
+	Operator Operator
+
+	// Conditions are the rules whose matchers are each one condition of the
+	// group
+	Conditions []*Rule
+
+	// Groups are nested groups that are each one condition of the group
+	Groups []*RuleGroup
+}
+
+// matches checks the request and session against the conditions of the
+// group.
+// Webhooks and OPA policies in conditions match when their decision is the
+// policy of the rule the group belongs to.
+func (g *RuleGroup) matches(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy) bool {
+	or := g.Operator == OrOperator
+	for _, condition := range g.Conditions {
+		if condition.matchesConditions(req, clientIP, session, policy) == or {
+			return or
+		}
+	}
+	for _, group := range g.Groups {
+		if group.matches(req, clientIP, session, policy) == or {
+			return or
+		}
+	}
+	return !or
+}
+
+// hasSessionConditions checks whether any condition of the group depends on
+// the session
+func (g *RuleGroup) hasSessionConditions() bool {
+	for _, condition := range g.Conditions {
+		if condition.hasSessionConditions() {
+			return true
+		}
+	}
+	for _, group := range g.Groups {
+		if group.hasSessionConditions() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUncachedConditions checks whether any condition of the group depends on
+// more than the method, host, path and client IP of requests
+func (g *RuleGroup) hasUncachedConditions() bool {
+	for _, condition := range g.Conditions {
+		if condition.hasUncachedConditions() {
+			return true
+		}
+	}
+	for _, group := range g.Groups {
+		if group.hasUncachedConditions() {
+			return true
+		}
+	}
+	return false
+}
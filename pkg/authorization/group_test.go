@@ -0,0 +1,121 @@
+package authorization
+
+import (
+	"net"
+	"net/http/httptest"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rule Group Suite", func() {
+	newCondition := func(path string, ips []string, headers map[string]string) *Rule {
+		condition, err := NewRule("", DenyPolicy, nil, path, nil, ips)
+		Expect(err).ToNot(HaveOccurred())
+		for name, regex := range headers {
+			Expect(condition.AddHeader(name, regex)).To(Succeed())
+		}
+		return condition
+	}
+
+	// newAdminRule matches /admin AND (clients in 192.168.0.0/16 OR requests
+	// with the external header)
+	newAdminRule := func() *Rule {
+		rule := newCondition("^/admin", nil, nil)
+		rule.ID = "admin"
+		rule.Group = &RuleGroup{
+			Operator: AndOperator,
+			Groups: []*RuleGroup{
+				{
+					Operator: OrOperator,
+					Conditions: []*Rule{
+						newCondition("", []string{"192.168.0.0/16"}, nil),
+						newCondition("", nil, map[string]string{"X-External": "^yes$"}),
+					},
+				},
+			},
+		}
+		return rule
+	}
+
+	type groupTableInput struct {
+		path     string
+		clientIP string
+		headers  map[string]string
+		expected bool
+	}
+
+	DescribeTable("matches nested groups",
+		func(in groupTableInput) {
+			req := httptest.NewRequest("GET", in.path, nil)
+			for name, value := range in.headers {
+				req.Header.Set(name, value)
+			}
+			Expect(newAdminRule().matches(req, net.ParseIP(in.clientIP), nil)).To(Equal(in.expected))
+		},
+		Entry("with the path and the first OR condition", groupTableInput{
+			path:     "/admin",
+			clientIP: "192.168.1.1",
+			expected: true,
+		}),
+		Entry("with the path and the second OR condition", groupTableInput{
+			path:     "/admin",
+			clientIP: "10.0.0.1",
+			headers:  map[string]string{"X-External": "yes"},
+			expected: true,
+		}),
+		Entry("with the path and no OR condition", groupTableInput{
+			path:     "/admin",
+			clientIP: "10.0.0.1",
+			expected: false,
+		}),
+		Entry("with an OR condition and a different path", groupTableInput{
+			path:     "/public",
+			clientIP: "192.168.1.1",
+			expected: false,
+		}),
+	)
+
+	DescribeTable("matches empty groups",
+		func(operator Operator, expected bool) {
+			rule := &Rule{Group: &RuleGroup{Operator: operator}}
+			Expect(rule.matches(httptest.NewRequest("GET", "/", nil), nil, nil)).To(Equal(expected))
+		},
+		Entry("with AND", AndOperator, true),
+		Entry("with OR", OrOperator, false),
+	)
+
+	It("matches session conditions in OR groups without a session", func() {
+		rule := &Rule{
+			Policy: DenyPolicy,
+			Group: &RuleGroup{
+				Operator: OrOperator,
+				Conditions: []*Rule{
+					{Groups: []string{"contractors"}},
+					newCondition("^/internal", nil, nil),
+				},
+			},
+		}
+		Expect(rule.hasSessionConditions()).To(BeTrue())
+
+		req := httptest.NewRequest("GET", "/internal", nil)
+		Expect(rule.matches(req, nil, nil)).To(BeTrue())
+
+		req = httptest.NewRequest("GET", "/", nil)
+		Expect(rule.matches(req, nil, nil)).To(BeFalse())
+		Expect(rule.matches(req, nil, &sessionsapi.SessionState{Groups: []string{"contractors"}})).To(BeTrue())
+	})
+
+	It("disables the result cache for groups with headers", func() {
+		engine := NewRulesEngine([]*Rule{newAdminRule()}, nil)
+		engine.EnableResultCache(10)
+		Expect(engine.load().cache).To(BeNil())
+
+		engine = NewRulesEngine([]*Rule{newCondition("^/admin", nil, nil)}, nil)
+		engine.EnableResultCache(10)
+		Expect(engine.load().cache).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,76 @@
+package index
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+// HeadersIndex indexes rules by a request header value they match exactly,
+// e.g. ^internal$ for the X-Tenant header
+type HeadersIndex struct {
+	hits
+	wildcards
+	headers map[string]map[string][]int
+}
+
+// NewHeadersIndex constructs an empty HeadersIndex
+func NewHeadersIndex() *HeadersIndex {
+	return &HeadersIndex{
+		headers: make(map[string]map[string][]int),
+	}
+}
+
+// Name identifies the index
+func (i *HeadersIndex) Name() string {
+	return "headers"
+}
+
+// Add indexes the rule at the given position by one of its header regexes,
+// keyed by canonical header name, that only matches a single literal value.
+// A rule without such a header regex cannot be indexed.
+func (i *HeadersIndex) Add(position int, headers map[string]*regexp.Regexp) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	// Index by the same header each time the rules are indexed
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := literalValue(headers[name])
+		if !ok {
+			continue
+		}
+		if i.headers[name] == nil {
+			i.headers[name] = make(map[string][]int)
+		}
+		i.headers[name][value] = append(i.headers[name][value], position)
+		return
+	}
+	i.addWildcard(position)
+}
+
+// Candidates returns the rules indexed by the values of the request headers
+// and the rules that could not be indexed.
+// A missing header is matched as an empty value, as the rules do.
+func (i *HeadersIndex) Candidates(req *http.Request, _ net.IP) (Set, bool) {
+	if len(i.headers) == 0 {
+		return nil, false
+	}
+	var matches []int
+	for name, values := range i.headers {
+		requestValues := req.Header.Values(name)
+		if len(requestValues) == 0 {
+			requestValues = []string{""}
+		}
+		for _, value := range requestValues {
+			matches = append(matches, values[value]...)
+		}
+	}
+	if len(matches) > 0 {
+		i.hit()
+	}
+	return i.withWildcards(matches), true
+}
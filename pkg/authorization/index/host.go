@@ -0,0 +1,67 @@
+package index
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+// HostIndex indexes rules by the exact hosts they match
+type HostIndex struct {
+	hits
+	wildcards
+	hosts map[string][]int
+}
+
+// NewHostIndex constructs an empty HostIndex
+func NewHostIndex() *HostIndex {
+	return &HostIndex{
+		hosts: make(map[string][]int),
+	}
+}
+
+// Name identifies the index
+func (i *HostIndex) Name() string {
+	return "host"
+}
+
+// Add indexes the rule at the given position by its exact hosts.
+// A rule without hosts, or with host regexes, cannot be indexed.
+func (i *HostIndex) Add(position int, hosts []string, hasRegexes bool) {
+	if len(hosts) == 0 || hasRegexes {
+		i.addWildcard(position)
+		return
+	}
+	for _, host := range hosts {
+		host = NormalizeHost(host)
+		i.hosts[host] = append(i.hosts[host], position)
+	}
+}
+
+// Candidates returns the rules indexed by the request host and the rules
+// that could not be indexed
+func (i *HostIndex) Candidates(req *http.Request, _ net.IP) (Set, bool) {
+	if len(i.hosts) == 0 {
+		return nil, false
+	}
+	matches, ok := i.hosts[RequestHost(req)]
+	if ok {
+		i.hit()
+	}
+	return i.withWildcards(matches), true
+}
+
+// RequestHost returns the normalized host the request was made to
+func RequestHost(req *http.Request) string {
+	return NormalizeHost(util.GetRequestHost(req))
+}
+
+// NormalizeHost lower cases the host and removes any port
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Set holds the positions of the rules that may match a request
+type Set map[int]struct{}
+
+// Has checks whether the rule at the given position is in the set
+func (s Set) Has(position int) bool {
+	_, ok := s[position]
+	return ok
+}
+
+// Intersect returns the positions that are in both sets
+func (s Set) Intersect(other Set) Set {
+	result := Set{}
+	for position := range s {
+		if other.Has(position) {
+			result[position] = struct{}{}
+		}
+	}
+	return result
+}
+
+// Index narrows down the rules that may match a request so that only those
+// rules need to be checked in full.
+// Rules are identified by their position in the rules engine.
+type Index interface {
+	// Name identifies the index in logs and metrics
+	Name() string
+
+	// Candidates returns the positions of the rules that may match the
+	// request.
+	// It returns false if the index cannot narrow down the rules, in which
+	// case all rules must be checked.
+	Candidates(req *http.Request, clientIP net.IP) (Set, bool)
+
+	// Hits is the number of requests the index has found indexed rules for
+	Hits() uint64
+}
+
+// hits counts the number of requests an index found indexed rules for.
+// It must be embedded first in each index so that the count is 64-bit aligned
+// for atomic operations on 32-bit platforms.
+type hits struct {
+	count uint64
+}
+
+func (h *hits) hit() {
+	atomic.AddUint64(&h.count, 1)
+}
+
+// Hits is the number of requests the index has found indexed rules for
+func (h *hits) Hits() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// wildcards holds the positions of rules that the index cannot narrow down,
+// which are candidates for every request
+type wildcards struct {
+	positions []int
+}
+
+func (w *wildcards) addWildcard(position int) {
+	w.positions = append(w.positions, position)
+}
+
+func (w *wildcards) withWildcards(matches []int) Set {
+	set := make(Set, len(matches)+len(w.positions))
+	for _, position := range w.positions {
+		set[position] = struct{}{}
+	}
+	for _, position := range matches {
+		set[position] = struct{}{}
+	}
+	return set
+}
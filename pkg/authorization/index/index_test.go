@@ -0,0 +1,185 @@
+package index
+
+import (
+	"net"
+	"net/http/httptest"
+	"regexp"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Index Suite", func() {
+	set := func(positions ...int) Set {
+		s := Set{}
+		for _, position := range positions {
+			s[position] = struct{}{}
+		}
+		return s
+	}
+
+	Context("PathIndex", func() {
+		var idx *PathIndex
+
+		BeforeEach(func() {
+			idx = NewPathIndex()
+			idx.Add(0, regexp.MustCompile("^/health$"))
+			idx.Add(1, regexp.MustCompile("^/admin/"))
+			idx.Add(2, nil)
+			idx.Add(3, regexp.MustCompile("^/health$"))
+		})
+
+		It("returns the rules for a literal path and the rules it cannot index", func() {
+			candidates, ok := idx.Candidates(httptest.NewRequest("GET", "/health", nil), nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(0, 2, 3)))
+			Expect(idx.Hits()).To(Equal(uint64(1)))
+		})
+
+		It("returns the rules for a prefix of the path", func() {
+			candidates, ok := idx.Candidates(httptest.NewRequest("GET", "/admin/users", nil), nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(1, 2)))
+			Expect(idx.Hits()).To(Equal(uint64(1)))
+		})
+
+		It("returns only the rules it cannot index for other paths", func() {
+			candidates, ok := idx.Candidates(httptest.NewRequest("GET", "/administrator", nil), nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(2)))
+			Expect(idx.Hits()).To(Equal(uint64(0)))
+		})
+
+		It("returns the rules for every prefix of the path", func() {
+			idx = NewPathIndex()
+			idx.Add(0, regexp.MustCompile("^/api/v1/"))
+			idx.Add(1, regexp.MustCompile("^/api/v2/"))
+			idx.Add(2, regexp.MustCompile("^/api/"))
+			idx.Add(3, regexp.MustCompile(`^/api/v1/users/\d+$`))
+			idx.Add(4, regexp.MustCompile("^/apix"))
+
+			candidates, ok := idx.Candidates(httptest.NewRequest("GET", "/api/v1/users/1", nil), nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(0, 2, 3)))
+
+			candidates, _ = idx.Candidates(httptest.NewRequest("GET", "/api/v2", nil), nil)
+			Expect(candidates).To(Equal(set(2)))
+
+			candidates, _ = idx.Candidates(httptest.NewRequest("GET", "/apix/y", nil), nil)
+			Expect(candidates).To(Equal(set(4)))
+		})
+
+		It("cannot narrow rules without literal paths or prefixes", func() {
+			idx = NewPathIndex()
+			idx.Add(0, regexp.MustCompile("/health"))
+			idx.Add(1, regexp.MustCompile("(?i)^/Health"))
+			idx.Add(2, regexp.MustCompile("^/health|^/ping"))
+			_, ok := idx.Candidates(httptest.NewRequest("GET", "/health", nil), nil)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("MethodsIndex", func() {
+		It("returns the rules for the method and the rules without methods", func() {
+			idx := NewMethodsIndex()
+			idx.Add(0, []string{"get", "HEAD"})
+			idx.Add(1, []string{"POST"})
+			idx.Add(2, nil)
+
+			candidates, ok := idx.Candidates(httptest.NewRequest("GET", "/", nil), nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(0, 2)))
+
+			candidates, ok = idx.Candidates(httptest.NewRequest("DELETE", "/", nil), nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(2)))
+			Expect(idx.Hits()).To(Equal(uint64(1)))
+		})
+	})
+
+	Context("HostIndex", func() {
+		It("returns the rules for the host and the rules it cannot index", func() {
+			idx := NewHostIndex()
+			idx.Add(0, []string{"app.example.com"}, false)
+			idx.Add(1, []string{"admin.example.com"}, true)
+			idx.Add(2, nil, false)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = "App.Example.com:443"
+			candidates, ok := idx.Candidates(req, nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(0, 1, 2)))
+
+			req.Host = "other.example.com"
+			candidates, ok = idx.Candidates(req, nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(1, 2)))
+			Expect(idx.Hits()).To(Equal(uint64(1)))
+		})
+
+		It("cannot narrow rules without exact hosts", func() {
+			idx := NewHostIndex()
+			idx.Add(0, nil, true)
+			_, ok := idx.Candidates(httptest.NewRequest("GET", "/", nil), nil)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("HeadersIndex", func() {
+		It("returns the rules for the header values and the rules it cannot index", func() {
+			idx := NewHeadersIndex()
+			idx.Add(0, map[string]*regexp.Regexp{"X-Tenant": regexp.MustCompile("^internal$")})
+			idx.Add(1, map[string]*regexp.Regexp{
+				"X-Tenant":  regexp.MustCompile("^partner-"),
+				"X-Version": regexp.MustCompile("^2$"),
+			})
+			idx.Add(2, nil)
+			idx.Add(3, map[string]*regexp.Regexp{"X-Tenant": regexp.MustCompile("^$")})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Add("X-Tenant", "external")
+			req.Header.Add("X-Tenant", "internal")
+			candidates, ok := idx.Candidates(req, nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(0, 2)))
+
+			req.Header.Del("X-Tenant")
+			req.Header.Set("X-Version", "2")
+			candidates, ok = idx.Candidates(req, nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(1, 2, 3)))
+			Expect(idx.Hits()).To(Equal(uint64(2)))
+		})
+
+		It("cannot narrow rules without literal header values", func() {
+			idx := NewHeadersIndex()
+			idx.Add(0, map[string]*regexp.Regexp{"X-Tenant": regexp.MustCompile("^internal")})
+			_, ok := idx.Candidates(httptest.NewRequest("GET", "/", nil), nil)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("IPsIndex", func() {
+		It("returns the rules containing the client IP and the rules without IPs", func() {
+			internal := ip.NewNetSet()
+			internal.AddIPNet(*ip.ParseIPNet("10.0.0.0/8"))
+
+			idx := NewIPsIndex()
+			idx.Add(0, internal)
+			idx.Add(1, nil)
+
+			candidates, ok := idx.Candidates(nil, net.ParseIP("10.1.2.3"))
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(0, 1)))
+
+			candidates, ok = idx.Candidates(nil, nil)
+			Expect(ok).To(BeTrue())
+			Expect(candidates).To(Equal(set(1)))
+		})
+	})
+
+	It("intersects sets", func() {
+		Expect(set(0, 1, 2).Intersect(set(1, 2, 3))).To(Equal(set(1, 2)))
+	})
+})
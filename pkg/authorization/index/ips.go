@@ -0,0 +1,61 @@
+package index
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+)
+
+// IPsIndex indexes rules by the IP ranges they match
+type IPsIndex struct {
+	hits
+	wildcards
+	ranges []ipRange
+}
+
+type ipRange struct {
+	position int
+	netSet   *ip.NetSet
+}
+
+// NewIPsIndex constructs an empty IPsIndex
+func NewIPsIndex() *IPsIndex {
+	return &IPsIndex{}
+}
+
+// Name identifies the index
+func (i *IPsIndex) Name() string {
+	return "ips"
+}
+
+// Add indexes the rule at the given position by its IP ranges.
+// A nil NetSet matches all client IPs.
+func (i *IPsIndex) Add(position int, netSet *ip.NetSet) {
+	if netSet == nil {
+		i.addWildcard(position)
+		return
+	}
+	i.ranges = append(i.ranges, ipRange{position: position, netSet: netSet})
+}
+
+// Candidates returns the rules with an IP range containing the client IP and
+// the rules without IP ranges
+func (i *IPsIndex) Candidates(_ *http.Request, clientIP net.IP) (Set, bool) {
+	if len(i.ranges) == 0 {
+		return nil, false
+	}
+
+	matches := []int{}
+	if clientIP != nil {
+		for _, r := range i.ranges {
+			if r.netSet.Has(clientIP) {
+				matches = append(matches, r.position)
+			}
+		}
+	}
+	if len(matches) > 0 {
+		i.hit()
+	}
+	return i.withWildcards(matches), true
+}
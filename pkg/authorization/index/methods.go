@@ -0,0 +1,52 @@
+package index
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MethodsIndex indexes rules by the methods they match
+type MethodsIndex struct {
+	hits
+	wildcards
+	methods map[string][]int
+}
+
+// NewMethodsIndex constructs an empty MethodsIndex
+func NewMethodsIndex() *MethodsIndex {
+	return &MethodsIndex{
+		methods: make(map[string][]int),
+	}
+}
+
+// Name identifies the index
+func (i *MethodsIndex) Name() string {
+	return "methods"
+}
+
+// Add indexes the rule at the given position by its methods.
+// A rule without methods matches all methods.
+func (i *MethodsIndex) Add(position int, methods []string) {
+	if len(methods) == 0 {
+		i.addWildcard(position)
+		return
+	}
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		i.methods[method] = append(i.methods[method], position)
+	}
+}
+
+// Candidates returns the rules indexed by the request method and the rules
+// without methods
+func (i *MethodsIndex) Candidates(req *http.Request, _ net.IP) (Set, bool) {
+	if len(i.methods) == 0 {
+		return nil, false
+	}
+	matches, ok := i.methods[req.Method]
+	if ok {
+		i.hit()
+	}
+	return i.withWildcards(matches), true
+}
@@ -0,0 +1,181 @@
+package index
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// PathIndex indexes rules by path when their path regex only matches a
+// single literal path, e.g. ^/healthz$, or only matches paths starting with
+// a literal prefix, e.g. ^/api/v1/
+type PathIndex struct {
+	hits
+	wildcards
+	paths    map[string][]int
+	prefixes *prefixNode
+}
+
+// NewPathIndex constructs an empty PathIndex
+func NewPathIndex() *PathIndex {
+	return &PathIndex{
+		paths:    make(map[string][]int),
+		prefixes: &prefixNode{},
+	}
+}
+
+// Name identifies the index
+func (i *PathIndex) Name() string {
+	return "path"
+}
+
+// Add indexes the rule at the given position by its path regex.
+// A nil regex matches all paths.
+func (i *PathIndex) Add(position int, path *regexp.Regexp) {
+	if literal, ok := literalValue(path); ok {
+		i.paths[literal] = append(i.paths[literal], position)
+		return
+	}
+	if prefix, ok := prefixPath(path); ok {
+		i.prefixes.add(prefix, position)
+		return
+	}
+	i.addWildcard(position)
+}
+
+// Candidates returns the rules indexed by the request path or a prefix of it,
+// and the rules that could not be indexed
+func (i *PathIndex) Candidates(req *http.Request, _ net.IP) (Set, bool) {
+	if len(i.paths) == 0 && i.prefixes.empty() {
+		return nil, false
+	}
+	matches := append(i.prefixes.match(req.URL.Path), i.paths[req.URL.Path]...)
+	if len(matches) > 0 {
+		i.hit()
+	}
+	return i.withWildcards(matches), true
+}
+
+// literalValue returns the only value matched by a regex anchored at both
+// ends with no other metacharacters
+func literalValue(regex *regexp.Regexp) (string, bool) {
+	if regex == nil {
+		return "", false
+	}
+	expr := regex.String()
+	if !strings.HasPrefix(expr, "^") || !strings.HasSuffix(expr, "$") {
+		return "", false
+	}
+
+	inner := strings.TrimSuffix(strings.TrimPrefix(expr, "^"), "$")
+	if regexp.QuoteMeta(inner) != inner {
+		return "", false
+	}
+	return inner, true
+}
+
+// prefixPath returns the literal prefix of the paths matched by a regex
+// anchored at the start, e.g. /api/v1/ for ^/api/v1/.*
+// Case insensitive literals are not used for the prefix.
+func prefixPath(path *regexp.Regexp) (string, bool) {
+	if path == nil {
+		return "", false
+	}
+	re, err := syntax.Parse(path.String(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return "", false
+	}
+
+	var prefix strings.Builder
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix.WriteString(string(sub.Rune))
+	}
+	if prefix.Len() == 0 {
+		return "", false
+	}
+	return prefix.String(), true
+}
+
+// prefixNode is a node of a radix tree of path prefixes.
+// Each node holds the rules whose prefix ends at the node, so that the rules
+// for every prefix of a path are found in a single walk down the tree.
+// The root node has an empty label and never holds rules, as a prefix is
+// never empty.
+type prefixNode struct {
+	// label is the part of the prefix on the edge to this node
+	label     string
+	positions []int
+	children  []*prefixNode
+}
+
+func (n *prefixNode) empty() bool {
+	return len(n.positions) == 0 && len(n.children) == 0
+}
+
+// add indexes the rule at the given position by the prefix, splitting the
+// edges that only partially match it
+func (n *prefixNode) add(prefix string, position int) {
+	for {
+		if prefix == "" {
+			n.positions = append(n.positions, position)
+			return
+		}
+		child := n.child(prefix[0])
+		if child == nil {
+			n.children = append(n.children, &prefixNode{label: prefix, positions: []int{position}})
+			return
+		}
+
+		common := commonPrefixLength(prefix, child.label)
+		if common < len(child.label) {
+			// Split the edge at the end of the common prefix
+			split := &prefixNode{label: child.label[common:], positions: child.positions, children: child.children}
+			child.label = child.label[:common]
+			child.positions = nil
+			child.children = []*prefixNode{split}
+		}
+		n, prefix = child, prefix[common:]
+	}
+}
+
+// match returns the rules for every prefix of the path
+func (n *prefixNode) match(path string) []int {
+	var matches []int
+	for path != "" {
+		child := n.child(path[0])
+		if child == nil || !strings.HasPrefix(path, child.label) {
+			break
+		}
+		matches = append(matches, child.positions...)
+		n, path = child, path[len(child.label):]
+	}
+	return matches
+}
+
+// child returns the child whose label starts with the byte, if any
+func (n *prefixNode) child(b byte) *prefixNode {
+	for _, child := range n.children {
+		if child.label[0] == b {
+			return child
+		}
+	}
+	return nil
+}
+
+// commonPrefixLength returns the length of the common prefix of a and b
+func commonPrefixLength(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,156 @@
+package authorization
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// IPFeedSyncer periodically loads a blocklist of IP addresses and CIDR ranges
+// from a local file or an http(s) URL, and replaces the client IPs of a
+// deny rule with the blocklist.
+// The feed has one address or range per line. Anything after a # or ; is a
+// comment, and lines that cannot be parsed are skipped.
+type IPFeedSyncer struct {
+	source   string
+	engine   *RulesEngine
+	rule     *Rule
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// NewIPFeedRule constructs a deny rule for an IP feed, which denies no client
+// IPs until the feed is first synced
+func NewIPFeedRule(id string) *Rule {
+	return &Rule{
+		ID:     id,
+		Policy: DenyPolicy,
+		IPs:    ip.NewNetSet(),
+	}
+}
+
+// NewIPFeedSyncer constructs an IPFeedSyncer for the rule of the engine,
+// which should be constructed with NewIPFeedRule
+func NewIPFeedSyncer(source string, engine *RulesEngine, rule *Rule, interval time.Duration) *IPFeedSyncer {
+	return &IPFeedSyncer{
+		source:   source,
+		engine:   engine,
+		rule:     rule,
+		interval: interval,
+		timeout:  10 * time.Second,
+	}
+}
+
+// IsURL checks whether the feed is fetched from a URL rather than read from a
+// local file
+func (s *IPFeedSyncer) IsURL() bool {
+	return strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://")
+}
+
+// Run syncs the feed immediately and then on each interval until done is
+// closed. Errors are logged and the previous blocklist is kept.
+func (s *IPFeedSyncer) Run(done <-chan bool) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(); err != nil {
+			logger.Errorf("Error syncing IP feed %s of deny rule %q: %v", s.source, s.rule.ID, err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync loads the feed once and replaces the rule with one denying the
+// addresses in the feed
+func (s *IPFeedSyncer) Sync() error {
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+	netSet, count, skipped := parseIPFeed(contents)
+	if skipped > 0 {
+		logger.Errorf("Skipped %d invalid entries in IP feed %s of deny rule %q", skipped, s.source, s.rule.ID)
+	}
+
+	rule := &Rule{
+		ID:          s.rule.ID,
+		Description: s.rule.Description,
+		Priority:    s.rule.Priority,
+		Policy:      s.rule.Policy,
+		Action:      s.rule.Action,
+		Shadow:      s.rule.Shadow,
+		Upstreams:   s.rule.Upstreams,
+		IPs:         netSet,
+	}
+	if !s.engine.ReplaceRule(rule) {
+		return fmt.Errorf("the rules engine has no rule %q", rule.ID)
+	}
+	s.rule = rule
+	logger.Printf("Loaded %d IP ranges from IP feed %s of deny rule %q", count, s.source, rule.ID)
+	return nil
+}
+
+// load reads the feed from the file or URL
+func (s *IPFeedSyncer) load() ([]byte, error) {
+	if !s.IsURL() {
+		contents, err := ioutil.ReadFile(s.source)
+		if err != nil {
+			return nil, fmt.Errorf("could not read IP feed: %v", err)
+		}
+		return contents, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	result := requests.New(s.source).
+		WithContext(ctx).
+		Do()
+	if result.Error() != nil {
+		return nil, result.Error()
+	}
+	if result.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status \"%d\": %s", result.StatusCode(), result.Body())
+	}
+	return result.Body(), nil
+}
+
+// parseIPFeed parses the addresses and ranges of a feed, returning the number
+// of valid and invalid entries
+func parseIPFeed(contents []byte) (*ip.NetSet, int, int) {
+	netSet := ip.NewNetSet()
+	count, skipped := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ipNet := ip.ParseIPNet(line)
+		if ipNet == nil {
+			skipped++
+			continue
+		}
+		netSet.AddIPNet(*ipNet)
+		count++
+	}
+	return netSet, count, skipped
+}
@@ -0,0 +1,95 @@
+package authorization
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPFeedSyncer", func() {
+	var engine *RulesEngine
+	var rule *Rule
+
+	denied := func(remoteAddr string) bool {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		return engine.Deny(req, nil)
+	}
+
+	BeforeEach(func() {
+		denyAdmin, err := NewRule("deny-admin", DenyPolicy, nil, "^/admin/", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		rule = NewIPFeedRule("deny-ip-feed-0")
+		rule.Action = JSONAction
+		engine = NewRulesEngine([]*Rule{denyAdmin, rule}, nil)
+	})
+
+	It("denies no client IPs until the feed is synced", func() {
+		Expect(denied("10.0.0.1:1234")).To(BeFalse())
+	})
+
+	Context("with a local file", func() {
+		var path string
+
+		writeFeed := func(contents string) {
+			Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "oauth2-proxy-ip-feed")
+			Expect(err).ToNot(HaveOccurred())
+			path = file.Name()
+			Expect(file.Close()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			_ = os.Remove(path)
+		})
+
+		It("replaces the client IPs of the rule with the feed", func() {
+			syncer := NewIPFeedSyncer(path, engine, rule, 0)
+			Expect(syncer.IsURL()).To(BeFalse())
+
+			writeFeed("# scanners\n10.0.0.0/8 ; SBL1\n\n192.168.1.1\nnot-an-ip\n")
+			Expect(syncer.Sync()).To(Succeed())
+			Expect(denied("10.1.2.3:1234")).To(BeTrue())
+			Expect(denied("192.168.1.1:1234")).To(BeTrue())
+			Expect(denied("192.168.1.2:1234")).To(BeFalse())
+
+			writeFeed("192.168.1.2\n")
+			Expect(syncer.Sync()).To(Succeed())
+			Expect(denied("10.1.2.3:1234")).To(BeFalse())
+			Expect(denied("192.168.1.2:1234")).To(BeTrue())
+
+			Expect(engine.Rules()[1].ID).To(Equal("deny-ip-feed-0"))
+			Expect(engine.Rules()[1].Action).To(Equal(JSONAction))
+			Expect(engine.Rules()[1].Hits()).To(Equal(uint64(3)))
+		})
+
+		It("keeps the previous client IPs when the feed cannot be read", func() {
+			syncer := NewIPFeedSyncer(path, engine, rule, 0)
+			writeFeed("10.0.0.0/8\n")
+			Expect(syncer.Sync()).To(Succeed())
+
+			Expect(os.Remove(path)).To(Succeed())
+			Expect(syncer.Sync()).To(MatchError(ContainSubstring("could not read IP feed")))
+			Expect(denied("10.1.2.3:1234")).To(BeTrue())
+		})
+	})
+
+	It("fetches the feed from a URL", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte("10.0.0.0/8\n"))
+		}))
+		defer server.Close()
+
+		syncer := NewIPFeedSyncer(server.URL+"/drop.txt", engine, rule, 0)
+		Expect(syncer.IsURL()).To(BeTrue())
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(denied("10.1.2.3:1234")).To(BeTrue())
+	})
+})
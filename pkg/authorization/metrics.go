@@ -0,0 +1,83 @@
+package authorization
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ruleHitsDesc = prometheus.NewDesc(
+		"oauth2_proxy_authorization_rule_hits_total",
+		"The number of requests an authorization rule has matched.",
+		[]string{"rule_set", "rule", "policy", "shadow"}, nil,
+	)
+	indexHitsDesc = prometheus.NewDesc(
+		"oauth2_proxy_authorization_index_hits_total",
+		"The number of requests an authorization rule index has found candidate rules for.",
+		[]string{"rule_set", "index"}, nil,
+	)
+)
+
+// Ensure RuleSets implements the interface
+var _ prometheus.Collector = &RuleSets{}
+
+// Describe sends the descriptions of the rule and index hit metrics
+func (r *RuleSets) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ruleHitsDesc
+	ch <- indexHitsDesc
+}
+
+// Collect sends the hits of the rules and indices of every rule set, so that
+// rules that match often and rules that never match can be found.
+// The hits of rules sharing an ID, policy and shadow mode are added up.
+// Counts start again when a rule set is loaded.
+func (r *RuleSets) Collect(ch chan<- prometheus.Metric) {
+	names, engines := r.snapshot()
+
+	type ruleKey struct {
+		id     string
+		policy Policy
+		shadow bool
+	}
+	for i, engine := range engines {
+		state := engine.load()
+
+		hits := make(map[ruleKey]uint64)
+		for _, rule := range state.sortedRules() {
+			hits[ruleKey{rule.ID, rule.Policy, rule.Shadow}] += rule.Hits()
+		}
+		for key, count := range hits {
+			ch <- prometheus.MustNewConstMetric(ruleHitsDesc, prometheus.CounterValue, float64(count),
+				names[i], key.id, key.policy.String(), strconv.FormatBool(key.shadow))
+		}
+
+		for _, idx := range state.indices {
+			ch <- prometheus.MustNewConstMetric(indexHitsDesc, prometheus.CounterValue, float64(idx.Hits()),
+				names[i], idx.Name())
+		}
+	}
+}
+
+// snapshot returns the names of the rule sets in alphabetical order and
+// their rules engines, read together so that they match. Rule sets without
+// a rules engine are left out.
+func (r *RuleSets) snapshot() ([]string, []*RulesEngine) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.sets))
+	for name, engine := range r.sets {
+		if engine != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	engines := make([]*RulesEngine, len(names))
+	for i, name := range names {
+		engines[i] = r.sets[name]
+	}
+	return names, engines
+}
@@ -0,0 +1,93 @@
+package authorization
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Metrics Suite", func() {
+	It("collects the hits of each rule and index", func() {
+		rules := []*Rule{}
+		for i := 0; i < 6; i++ {
+			rule, err := NewRule(fmt.Sprintf("rule-%d", i), DenyPolicy, nil, fmt.Sprintf("^/%d$", i), nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			rules = append(rules, rule)
+		}
+		rules[5].Shadow = true
+		shadow, err := NewRule(`rule "quoted"`, AllowPolicy, nil, "", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		ruleSets, err := NewRuleSets("large", map[string]*RulesEngine{
+			"large": NewRulesEngine(rules, nil),
+			"small": NewRulesEngine([]*Rule{shadow}, nil),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, engine := ruleSets.Active()
+		Expect(engine.Deny(httptest.NewRequest("GET", "/1", nil), nil)).To(BeTrue())
+		Expect(engine.Deny(httptest.NewRequest("GET", "/5", nil), nil)).To(BeFalse())
+
+		Expect(testutil.CollectAndCompare(ruleSets, strings.NewReader(`
+# HELP oauth2_proxy_authorization_rule_hits_total The number of requests an authorization rule has matched.
+# TYPE oauth2_proxy_authorization_rule_hits_total counter
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-0",rule_set="large",shadow="false"} 0
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-1",rule_set="large",shadow="false"} 1
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-2",rule_set="large",shadow="false"} 0
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-3",rule_set="large",shadow="false"} 0
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-4",rule_set="large",shadow="false"} 0
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-5",rule_set="large",shadow="true"} 1
+oauth2_proxy_authorization_rule_hits_total{policy="allow",rule="rule \"quoted\"",rule_set="small",shadow="false"} 0
+# HELP oauth2_proxy_authorization_index_hits_total The number of requests an authorization rule index has found candidate rules for.
+# TYPE oauth2_proxy_authorization_index_hits_total counter
+oauth2_proxy_authorization_index_hits_total{index="headers",rule_set="large"} 0
+oauth2_proxy_authorization_index_hits_total{index="host",rule_set="large"} 0
+oauth2_proxy_authorization_index_hits_total{index="ips",rule_set="large"} 0
+oauth2_proxy_authorization_index_hits_total{index="methods",rule_set="large"} 0
+oauth2_proxy_authorization_index_hits_total{index="path",rule_set="large"} 2
+`))).To(Succeed())
+	})
+
+	It("skips rule sets without a rules engine", func() {
+		rule, err := NewRule("rule-0", DenyPolicy, nil, "", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		ruleSets, err := NewRuleSets("default", map[string]*RulesEngine{
+			"default": NewRulesEngine([]*Rule{rule}, nil),
+			"empty":   nil,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(testutil.CollectAndCompare(ruleSets, strings.NewReader(`
+# HELP oauth2_proxy_authorization_rule_hits_total The number of requests an authorization rule has matched.
+# TYPE oauth2_proxy_authorization_rule_hits_total counter
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="rule-0",rule_set="default",shadow="false"} 0
+`), "oauth2_proxy_authorization_rule_hits_total")).To(Succeed())
+	})
+
+	It("adds up the hits of rules sharing an ID", func() {
+		rules := []*Rule{}
+		for _, path := range []string{"^/a$", "^/b$"} {
+			rule, err := NewRule("shared", DenyPolicy, nil, path, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			rules = append(rules, rule)
+		}
+		ruleSets, err := NewRuleSets("default", map[string]*RulesEngine{
+			"default": NewRulesEngine(rules, nil),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, engine := ruleSets.Active()
+		Expect(engine.Deny(httptest.NewRequest("GET", "/a", nil), nil)).To(BeTrue())
+		Expect(engine.Deny(httptest.NewRequest("GET", "/b", nil), nil)).To(BeTrue())
+
+		Expect(testutil.CollectAndCompare(ruleSets, strings.NewReader(`
+# HELP oauth2_proxy_authorization_rule_hits_total The number of requests an authorization rule has matched.
+# TYPE oauth2_proxy_authorization_rule_hits_total counter
+oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="shared",rule_set="default",shadow="false"} 2
+`), "oauth2_proxy_authorization_rule_hits_total")).To(Succeed())
+	})
+})
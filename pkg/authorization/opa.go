@@ -0,0 +1,66 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// opaQuery is the rule of the policy that decides whether a request is allowed
+const opaQuery = "data.oauth2_proxy.allow"
+
+// OPAPolicy evaluates a Rego policy to authorize requests.
+// The policy is given the same input as a Webhook, and allows the request when
+// its data.oauth2_proxy.allow rule is true.
+type OPAPolicy struct {
+	path  string
+	query rego.PreparedEvalQuery
+}
+
+// NewOPAPolicy compiles the Rego policy in the file.
+func NewOPAPolicy(path string) (*OPAPolicy, error) {
+	module, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read OPA policy %s: %v", path, err)
+	}
+
+	query, err := rego.New(
+		rego.Query(opaQuery),
+		rego.Module(path, string(module)),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error compiling OPA policy %s: %v", path, err)
+	}
+
+	return &OPAPolicy{
+		path:  path,
+		query: query,
+	}, nil
+}
+
+// Path is the file the policy was loaded from
+func (p *OPAPolicy) Path() string {
+	return p.path
+}
+
+// allows evaluates whether the policy allows the request.
+// Requests are denied if the allow rule is undefined, is not a boolean or
+// cannot be evaluated.
+func (p *OPAPolicy) allows(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState) bool {
+	results, err := p.query.Eval(req.Context(), rego.EvalInput(newWebhookRequest(req, clientIP, session)))
+	if err != nil {
+		logger.Errorf("Error evaluating OPA policy %s: %v", p.path, err)
+		return false
+	}
+	if len(results) != 1 || len(results[0].Expressions) != 1 {
+		return false
+	}
+	allowed, ok := results[0].Expressions[0].Value.(bool)
+	return ok && allowed
+}
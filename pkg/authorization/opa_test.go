@@ -0,0 +1,116 @@
+package authorization
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const testOPAPolicy = `package oauth2_proxy
+
+default allow = false
+
+allow {
+	input.session.groups[_] == "admins"
+}
+
+allow {
+	not startswith(input.path, "/admin/")
+	endswith(input.session.email, "@example.com")
+}
+`
+
+var _ = Describe("OPA Policy Suite", func() {
+	var dir string
+
+	writePolicy := func(contents string) string {
+		path := filepath.Join(dir, "policy.rego")
+		Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "opa-policy")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	type opaTableInput struct {
+		path     string
+		session  *sessionsapi.SessionState
+		policy   Policy
+		expected bool
+	}
+
+	DescribeTable("matches",
+		func(in opaTableInput) {
+			policy, err := NewOPAPolicy(writePolicy(testOPAPolicy))
+			Expect(err).ToNot(HaveOccurred())
+			rule := &Rule{ID: "opa", Policy: in.policy, OPAPolicy: policy}
+
+			req := httptest.NewRequest("GET", "http://app.example.com"+in.path, nil)
+			Expect(rule.matches(req, net.ParseIP("10.0.0.1"), in.session)).To(Equal(in.expected))
+		},
+		Entry("a deny rule when the policy allows the group", opaTableInput{
+			path:     "/admin/users",
+			session:  &sessionsapi.SessionState{Email: "jane.doe@other.com", Groups: []string{"admins"}},
+			policy:   DenyPolicy,
+			expected: false,
+		}),
+		Entry("a deny rule when the policy allows the email", opaTableInput{
+			path:     "/app/",
+			session:  &sessionsapi.SessionState{Email: "john.doe@example.com"},
+			policy:   DenyPolicy,
+			expected: false,
+		}),
+		Entry("a deny rule when the policy denies the path", opaTableInput{
+			path:     "/admin/users",
+			session:  &sessionsapi.SessionState{Email: "john.doe@example.com"},
+			policy:   DenyPolicy,
+			expected: true,
+		}),
+		Entry("an allow rule when the policy allows", opaTableInput{
+			path:     "/app/",
+			session:  &sessionsapi.SessionState{Email: "john.doe@example.com"},
+			policy:   AllowPolicy,
+			expected: true,
+		}),
+		Entry("a rule without a session", opaTableInput{
+			path:     "/app/",
+			policy:   AllowPolicy,
+			expected: false,
+		}),
+	)
+
+	It("denies requests when the allow rule is undefined", func() {
+		policy, err := NewOPAPolicy(writePolicy("package oauth2_proxy\n\nallow {\n\tinput.method == \"POST\"\n}\n"))
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		Expect(policy.allows(req, nil, &sessionsapi.SessionState{Email: "john.doe@example.com"})).To(BeFalse())
+	})
+
+	It("fails to compile an invalid policy", func() {
+		path := writePolicy("package oauth2_proxy\n\nallow {\n")
+		_, err := NewOPAPolicy(path)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("error compiling OPA policy " + path))
+	})
+
+	It("fails to read a missing policy", func() {
+		_, err := NewOPAPolicy(filepath.Join(dir, "missing.rego"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("could not read OPA policy"))
+	})
+})
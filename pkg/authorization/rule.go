@@ -0,0 +1,492 @@
+package authorization
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization/index"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+)
+
+// Policy is the effect of a rule on the requests it matches
+type Policy int
+
+const (
+	// AllowPolicy rules allow the requests they match
+	AllowPolicy Policy = iota
+
+	// DenyPolicy rules deny the requests they match
+	DenyPolicy
+)
+
+// String returns the name of the policy
+func (p Policy) String() string {
+	switch p {
+	case AllowPolicy:
+		return "allow"
+	case DenyPolicy:
+		return "deny"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// Action is how the proxy responds to requests denied by a rule
+type Action int
+
+const (
+	// ErrorPageAction responds with the 403 error page, which can be
+	// customised with the error.html template
+	ErrorPageAction Action = iota
+
+	// ForbiddenAction responds with a plain 403 Forbidden
+	ForbiddenAction
+
+	// UnauthorizedAction responds with a plain 401 Unauthorized
+	UnauthorizedAction
+
+	// SignInAction sends the user to sign in, as if they were not
+	// authenticated
+	SignInAction
+
+	// JSONAction responds with a 403 Forbidden JSON error
+	JSONAction
+)
+
+// actionNames are the names of the actions in configuration
+var actionNames = map[Action]string{
+	ErrorPageAction:    "error_page",
+	ForbiddenAction:    "forbidden",
+	UnauthorizedAction: "unauthorized",
+	SignInAction:       "sign_in",
+	JSONAction:         "json",
+}
+
+// String returns the name of the action
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ParseAction returns the action with the given name
+func ParseAction(name string) (Action, error) {
+	for action, actionName := range actionNames {
+		if name == actionName {
+			return action, nil
+		}
+	}
+	return ErrorPageAction, fmt.Errorf("unknown action %q", name)
+}
+
+// Rule matches requests by their method, host, path, query parameters,
+// headers and client IP, and by the session of the authenticated user.
+// A request must match each of the matchers that are set, and the rule group
+// if there is one.
+type Rule struct {
+	// hits is the number of requests the rule has matched.
+	// It is the first field so that it is 64-bit aligned for atomic
+	// operations on 32-bit platforms.
+	hits uint64
+
+	// ID identifies the rule in logs
+	ID string
+
+	// Description optionally explains the intent of the rule in logs
+	Description string
+
+	// Policy is the effect of the rule on the requests it matches
+	Policy Policy
+
+	// Action is how the proxy responds to requests denied by the rule
+	Action Action
+
+	// Priority orders the evaluation of rules, lowest first.
+	// Rules with the same priority are evaluated in the order they are given.
+	Priority int
+
+	// Shadow rules are evaluated, counted and logged when they match, but do
+	// not allow or deny requests, so that a rule can be tried out before it
+	// is enforced
+	Shadow bool
+
+	// Methods are the request methods the rule matches.
+	// All methods are matched if empty.
+	Methods []string
+
+	// Hosts are the exact request hosts the rule matches, without ports.
+	// HostRegexes are matched against the host of the request in addition.
+	// All hosts are matched if both are empty.
+	Hosts       []string
+	HostRegexes []*regexp.Regexp
+
+	// Path is a regex the request path must match.
+	// All paths are matched if nil.
+	Path *regexp.Regexp
+
+	// Query are regexes that the values of the named query parameters must
+	// match.
+	// A missing parameter is matched as an empty value.
+	Query map[string]*regexp.Regexp
+
+	// Headers are regexes that the values of the named request headers must
+	// match, keyed by canonical header name.
+	// A missing header is matched as an empty value.
+	Headers map[string]*regexp.Regexp
+
+	// IPs are the client IP ranges the rule matches.
+	// All client IPs are matched if nil.
+	IPs *ip.NetSet
+
+	// EmailDomains are the domains the session email must be in one of,
+	// compared case insensitively. "*" matches any email.
+	// All sessions are matched if empty.
+	EmailDomains []string
+
+	// Groups are the groups the session must contain at least one of.
+	// All sessions are matched if empty.
+	Groups []string
+
+	// Claims are the values the session claims must contain at least one of,
+	// keyed by claim name.
+	Claims map[string][]string
+
+	// Webhook is an external endpoint that decides whether the request is
+	// allowed. The rule matches when the decision of the webhook is the policy
+	// of the rule.
+	// Rules with a webhook only match authenticated requests.
+	Webhook *Webhook
+
+	// OPAPolicy is a Rego policy that decides whether the request is allowed.
+	// The rule matches when the decision of the policy is the policy of the
+	// rule.
+	// Rules with an OPA policy only match authenticated requests.
+	OPAPolicy *OPAPolicy
+
+	// Expression is a CEL expression the request and session must match.
+	// Rules with an expression only match authenticated requests.
+	Expression *Expression
+
+	// Group combines further conditions with AND and OR, which the request
+	// must match in addition to the matchers of the rule.
+	// Groups are not used by the indices, so rules are still indexed by their
+	// own matchers.
+	Group *RuleGroup
+
+	// Upstreams are the IDs of the upstreams the rule is scoped to, so that
+	// it only applies to requests routed to one of them.
+	// The rule applies to requests for all upstreams if empty.
+	Upstreams []string
+}
+
+// NewRule constructs a rule from a list of methods, a path regex, regexes for
+// query parameters and a list of IP addresses or CIDR ranges.
+// Empty values match all requests.
+func NewRule(id string, policy Policy, methods []string, path string, query map[string]string, ips []string) (*Rule, error) {
+	rule := &Rule{
+		ID:     id,
+		Policy: policy,
+	}
+
+	for _, method := range methods {
+		rule.Methods = append(rule.Methods, strings.ToUpper(method))
+	}
+
+	if path != "" {
+		compiled, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling path regex /%s/: %v", path, err)
+		}
+		rule.Path = compiled
+	}
+
+	for param, regex := range query {
+		compiled, err := regexp.Compile(regex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling query parameter %s regex /%s/: %v", param, regex, err)
+		}
+		if rule.Query == nil {
+			rule.Query = make(map[string]*regexp.Regexp)
+		}
+		rule.Query[param] = compiled
+	}
+
+	if len(ips) > 0 {
+		rule.IPs = ip.NewNetSet()
+		for _, ipStr := range ips {
+			ipNet := ip.ParseIPNet(ipStr)
+			if ipNet == nil {
+				return nil, fmt.Errorf("could not parse IP network (%s)", ipStr)
+			}
+			rule.IPs.AddIPNet(*ipNet)
+		}
+	}
+
+	return rule, nil
+}
+
+// AddHosts adds hosts to the rule.
+// Hosts starting with ^ are compiled as regexes, others are matched exactly
+// ignoring case and port.
+func (r *Rule) AddHosts(hosts ...string) error {
+	for _, host := range hosts {
+		if strings.HasPrefix(host, "^") {
+			compiled, err := regexp.Compile(host)
+			if err != nil {
+				return fmt.Errorf("error compiling host regex /%s/: %v", host, err)
+			}
+			r.HostRegexes = append(r.HostRegexes, compiled)
+			continue
+		}
+		r.Hosts = append(r.Hosts, index.NormalizeHost(host))
+	}
+	return nil
+}
+
+// AddHeader adds a regex that the named request header must match
+func (r *Rule) AddHeader(name, regex string) error {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return fmt.Errorf("error compiling header %s regex /%s/: %v", name, regex, err)
+	}
+	if r.Headers == nil {
+		r.Headers = make(map[string]*regexp.Regexp)
+	}
+	r.Headers[http.CanonicalHeaderKey(name)] = compiled
+	return nil
+}
+
+// String identifies the rule by its ID and description
+func (r *Rule) String() string {
+	if r.Description == "" {
+		return fmt.Sprintf("%q", r.ID)
+	}
+	return fmt.Sprintf("%q (%s)", r.ID, r.Description)
+}
+
+// appliesTo checks whether the rule applies to requests routed to the
+// upstream with the given ID
+func (r *Rule) appliesTo(upstream string) bool {
+	if len(r.Upstreams) == 0 {
+		return true
+	}
+	for _, id := range r.Upstreams {
+		if id == upstream {
+			return true
+		}
+	}
+	return false
+}
+
+// Hits is the number of requests the rule has matched
+func (r *Rule) Hits() uint64 {
+	return atomic.LoadUint64(&r.hits)
+}
+
+// matches checks the request and session against each of the matchers of the
+// rule, and its group.
+// Rules with session conditions never match requests without a session,
+// unless the session conditions are in an OR group.
+func (r *Rule) matches(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState) bool {
+	return r.matchesConditions(req, clientIP, session, r.Policy) &&
+		(r.Group == nil || r.Group.matches(req, clientIP, session, r.Policy))
+}
+
+// matchesConditions checks the request and session against each of the
+// matchers of the rule. Webhooks and OPA policies match when their decision
+// is the given policy.
+func (r *Rule) matchesConditions(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy) bool {
+	return r.matchesMethod(req) && r.matchesHost(req) && r.matchesPath(req) &&
+		r.matchesQuery(req) && r.matchesHeaders(req) && r.matchesIP(clientIP) &&
+		r.matchesSession(session) && r.matchesExpression(req, clientIP, session, policy) &&
+		r.matchesOPAPolicy(req, clientIP, session, policy) &&
+		r.matchesWebhook(req, clientIP, session, policy)
+}
+
+// hasSessionConditions checks whether the rule, or its group, depends on the
+// session of authenticated requests
+func (r *Rule) hasSessionConditions() bool {
+	return r.hasOwnSessionConditions() || (r.Group != nil && r.Group.hasSessionConditions())
+}
+
+// hasOwnSessionConditions checks whether the rule can only match
+// authenticated requests
+func (r *Rule) hasOwnSessionConditions() bool {
+	return len(r.EmailDomains) > 0 || len(r.Groups) > 0 || len(r.Claims) > 0 ||
+		r.Expression != nil || r.OPAPolicy != nil || r.Webhook != nil
+}
+
+// hasUncachedConditions checks whether the rule, or its group, depends on
+// more than the method, host, path and client IP of requests
+func (r *Rule) hasUncachedConditions() bool {
+	return len(r.Query) > 0 || len(r.Headers) > 0 || r.hasSessionConditions() ||
+		(r.Group != nil && r.Group.hasUncachedConditions())
+}
+
+func (r *Rule) matchesSession(session *sessionsapi.SessionState) bool {
+	if !r.hasOwnSessionConditions() {
+		return true
+	}
+	if session == nil {
+		return false
+	}
+
+	if len(r.EmailDomains) > 0 && !r.matchesEmailDomain(session.Email) {
+		return false
+	}
+	if len(r.Groups) > 0 && !containsAny(session.Groups, r.Groups) {
+		return false
+	}
+	for claim, values := range r.Claims {
+		if !containsAny(session.GetClaim(claim), values) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesExpression checks whether the session and request match the CEL
+// expression
+func (r *Rule) matchesExpression(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy) bool {
+	if r.Expression == nil {
+		return true
+	}
+	if session == nil {
+		return false
+	}
+	return r.Expression.matches(req, clientIP, session, policy)
+}
+
+// matchesOPAPolicy checks whether the OPA policy decision is the policy
+func (r *Rule) matchesOPAPolicy(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy) bool {
+	if r.OPAPolicy == nil {
+		return true
+	}
+	return r.OPAPolicy.allows(req, clientIP, session) == (policy == AllowPolicy)
+}
+
+// matchesWebhook checks whether the webhook decision is the policy.
+// It is checked last, as it calls an external endpoint.
+func (r *Rule) matchesWebhook(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState, policy Policy) bool {
+	if r.Webhook == nil {
+		return true
+	}
+	return r.Webhook.allows(req, clientIP, session) == (policy == AllowPolicy)
+}
+
+func (r *Rule) matchesEmailDomain(email string) bool {
+	if email == "" {
+		return false
+	}
+	email = strings.ToLower(email)
+	for _, domain := range r.EmailDomains {
+		if domain == "*" || strings.HasSuffix(email, "@"+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMethod(req *http.Request) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, method := range r.Methods {
+		if req.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesHost(req *http.Request) bool {
+	if len(r.Hosts) == 0 && len(r.HostRegexes) == 0 {
+		return true
+	}
+	host := index.RequestHost(req)
+	for _, h := range r.Hosts {
+		if host == h {
+			return true
+		}
+	}
+	for _, regex := range r.HostRegexes {
+		if regex.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesPath(req *http.Request) bool {
+	return r.Path == nil || r.Path.MatchString(req.URL.Path)
+}
+
+// matchesQuery checks that each query parameter has a value matching its
+// regex
+func (r *Rule) matchesQuery(req *http.Request) bool {
+	if len(r.Query) == 0 {
+		return true
+	}
+	query := req.URL.Query()
+	for param, regex := range r.Query {
+		values := query[param]
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		if !matchesAny(regex, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesHeaders checks that each header has a value matching its regex
+func (r *Rule) matchesHeaders(req *http.Request) bool {
+	for name, regex := range r.Headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		if !matchesAny(regex, values) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(regex *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if regex.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesIP(clientIP net.IP) bool {
+	if r.IPs == nil {
+		return true
+	}
+	return clientIP != nil && r.IPs.Has(clientIP)
+}
+
+func (r *Rule) hit() {
+	atomic.AddUint64(&r.hits, 1)
+}
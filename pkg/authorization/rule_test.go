@@ -0,0 +1,300 @@
+package authorization
+
+import (
+	"net"
+	"net/http/httptest"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rule Suite", func() {
+	type matchesTableInput struct {
+		methods  []string
+		hosts    []string
+		path     string
+		query    map[string]string
+		headers  map[string]string
+		ips      []string
+		method   string
+		host     string
+		reqHdrs  map[string]string
+		reqPath  string
+		clientIP string
+		expected bool
+	}
+
+	DescribeTable("matches",
+		func(in matchesTableInput) {
+			rule, err := NewRule("rule", DenyPolicy, in.methods, in.path, in.query, in.ips)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.AddHosts(in.hosts...)).To(Succeed())
+			for name, regex := range in.headers {
+				Expect(rule.AddHeader(name, regex)).To(Succeed())
+			}
+
+			req := httptest.NewRequest(in.method, in.reqPath, nil)
+			if in.host != "" {
+				req.Host = in.host
+			}
+			for name, value := range in.reqHdrs {
+				req.Header.Add(name, value)
+			}
+			Expect(rule.matches(req, net.ParseIP(in.clientIP), nil)).To(Equal(in.expected))
+		},
+		Entry("with no matchers", matchesTableInput{
+			method:   "GET",
+			reqPath:  "/",
+			clientIP: "10.0.0.1",
+			expected: true,
+		}),
+		Entry("with a matching method", matchesTableInput{
+			methods:  []string{"get", "head"},
+			method:   "GET",
+			reqPath:  "/",
+			expected: true,
+		}),
+		Entry("with a different method", matchesTableInput{
+			methods:  []string{"GET"},
+			method:   "POST",
+			reqPath:  "/",
+			expected: false,
+		}),
+		Entry("with a matching host", matchesTableInput{
+			hosts:    []string{"app.example.com", "Admin.Example.com"},
+			method:   "GET",
+			host:     "admin.example.com:8443",
+			reqPath:  "/",
+			expected: true,
+		}),
+		Entry("with a different host", matchesTableInput{
+			hosts:    []string{"admin.example.com"},
+			method:   "GET",
+			host:     "app.example.com",
+			reqPath:  "/",
+			expected: false,
+		}),
+		Entry("with a matching host regex", matchesTableInput{
+			hosts:    []string{"admin.example.com", `^[a-z]+\.internal\.example\.com$`},
+			method:   "GET",
+			host:     "grafana.internal.example.com",
+			reqPath:  "/",
+			expected: true,
+		}),
+		Entry("with a different host regex", matchesTableInput{
+			hosts:    []string{`^[a-z]+\.internal\.example\.com$`},
+			method:   "GET",
+			host:     "internal.example.com",
+			reqPath:  "/",
+			expected: false,
+		}),
+		Entry("with a matching path", matchesTableInput{
+			path:     "^/admin/",
+			method:   "GET",
+			reqPath:  "/admin/users",
+			expected: true,
+		}),
+		Entry("with a different path", matchesTableInput{
+			path:     "^/admin/",
+			method:   "GET",
+			reqPath:  "/public",
+			expected: false,
+		}),
+		Entry("with a matching query parameter", matchesTableInput{
+			path:     "^/export$",
+			query:    map[string]string{"format": "^csv$"},
+			method:   "GET",
+			reqPath:  "/export?format=csv&page=2",
+			expected: true,
+		}),
+		Entry("with a different query parameter", matchesTableInput{
+			path:     "^/export$",
+			query:    map[string]string{"format": "^csv$"},
+			method:   "GET",
+			reqPath:  "/export?format=all",
+			expected: false,
+		}),
+		Entry("with a missing query parameter", matchesTableInput{
+			query:    map[string]string{"format": "^csv$"},
+			method:   "GET",
+			reqPath:  "/export",
+			expected: false,
+		}),
+		Entry("with matching headers", matchesTableInput{
+			headers:  map[string]string{"content-type": "^application/json", "X-Requested-With": "^XMLHttpRequest$"},
+			method:   "POST",
+			reqPath:  "/",
+			reqHdrs:  map[string]string{"Content-Type": "application/json; charset=utf-8", "X-Requested-With": "XMLHttpRequest"},
+			expected: true,
+		}),
+		Entry("with a header not matching", matchesTableInput{
+			headers:  map[string]string{"Content-Type": "^application/json", "X-Requested-With": "^XMLHttpRequest$"},
+			method:   "POST",
+			reqPath:  "/",
+			reqHdrs:  map[string]string{"Content-Type": "application/json"},
+			expected: false,
+		}),
+		Entry("with a missing header matching an empty value", matchesTableInput{
+			headers:  map[string]string{"X-Internal": "^$"},
+			method:   "GET",
+			reqPath:  "/",
+			expected: true,
+		}),
+		Entry("with a matching IP", matchesTableInput{
+			ips:      []string{"10.0.0.0/8"},
+			method:   "GET",
+			reqPath:  "/",
+			clientIP: "10.1.2.3",
+			expected: true,
+		}),
+		Entry("with a different IP", matchesTableInput{
+			ips:      []string{"10.0.0.0/8"},
+			method:   "GET",
+			reqPath:  "/",
+			clientIP: "192.168.0.1",
+			expected: false,
+		}),
+		Entry("with IPs and no client IP", matchesTableInput{
+			ips:      []string{"10.0.0.0/8"},
+			method:   "GET",
+			reqPath:  "/",
+			expected: false,
+		}),
+		Entry("with all matchers matching", matchesTableInput{
+			methods:  []string{"POST"},
+			path:     "^/admin/",
+			ips:      []string{"10.0.0.0/8"},
+			method:   "POST",
+			reqPath:  "/admin/users",
+			clientIP: "10.1.2.3",
+			expected: true,
+		}),
+		Entry("with one matcher not matching", matchesTableInput{
+			methods:  []string{"POST"},
+			path:     "^/admin/",
+			ips:      []string{"10.0.0.0/8"},
+			method:   "GET",
+			reqPath:  "/admin/users",
+			clientIP: "10.1.2.3",
+			expected: false,
+		}),
+	)
+
+	type matchesSessionTableInput struct {
+		domains  []string
+		groups   []string
+		claims   map[string][]string
+		session  *sessionsapi.SessionState
+		expected bool
+	}
+
+	DescribeTable("matches sessions",
+		func(in matchesSessionTableInput) {
+			rule, err := NewRule("rule", AllowPolicy, nil, "", nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			rule.EmailDomains = in.domains
+			rule.Groups = in.groups
+			rule.Claims = in.claims
+
+			req := httptest.NewRequest("GET", "/", nil)
+			Expect(rule.matches(req, nil, in.session)).To(Equal(in.expected))
+		},
+		Entry("with no session conditions and no session", matchesSessionTableInput{
+			expected: true,
+		}),
+		Entry("with session conditions and no session", matchesSessionTableInput{
+			groups:   []string{"admins"},
+			expected: false,
+		}),
+		Entry("with a matching email domain", matchesSessionTableInput{
+			domains:  []string{"example.org", "Corp.com"},
+			session:  &sessionsapi.SessionState{Email: "Admin@corp.com"},
+			expected: true,
+		}),
+		Entry("with a subdomain of the email domain", matchesSessionTableInput{
+			domains:  []string{"corp.com"},
+			session:  &sessionsapi.SessionState{Email: "admin@eu.corp.com"},
+			expected: false,
+		}),
+		Entry("with the wildcard email domain and no email", matchesSessionTableInput{
+			domains:  []string{"*"},
+			session:  &sessionsapi.SessionState{},
+			expected: false,
+		}),
+		Entry("with a matching email domain and group", matchesSessionTableInput{
+			domains:  []string{"corp.com"},
+			groups:   []string{"admins"},
+			session:  &sessionsapi.SessionState{Email: "admin@corp.com", Groups: []string{"users"}},
+			expected: false,
+		}),
+		Entry("with a matching group", matchesSessionTableInput{
+			groups:   []string{"admins", "operators"},
+			session:  &sessionsapi.SessionState{Groups: []string{"users", "operators"}},
+			expected: true,
+		}),
+		Entry("with no matching group", matchesSessionTableInput{
+			groups:   []string{"admins"},
+			session:  &sessionsapi.SessionState{Groups: []string{"users"}},
+			expected: false,
+		}),
+		Entry("with matching claims", matchesSessionTableInput{
+			claims:   map[string][]string{"email": {"admin@example.com"}, "user": {"admin", "root"}},
+			session:  &sessionsapi.SessionState{Email: "admin@example.com", User: "root"},
+			expected: true,
+		}),
+		Entry("with a claim not matching", matchesSessionTableInput{
+			claims:   map[string][]string{"email": {"admin@example.com"}, "user": {"admin"}},
+			session:  &sessionsapi.SessionState{Email: "admin@example.com", User: "root"},
+			expected: false,
+		}),
+	)
+
+	It("returns an error for an invalid path regex", func() {
+		_, err := NewRule("rule", DenyPolicy, nil, "(bad[regex", nil, nil)
+		Expect(err).To(MatchError(ContainSubstring("error compiling path regex /(bad[regex/")))
+	})
+
+	It("returns an error for an invalid query parameter regex", func() {
+		_, err := NewRule("rule", DenyPolicy, nil, "", map[string]string{"format": "(bad"}, nil)
+		Expect(err).To(MatchError(ContainSubstring("error compiling query parameter format regex /(bad/")))
+	})
+
+	It("returns an error for an invalid host regex", func() {
+		rule, err := NewRule("rule", DenyPolicy, nil, "", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rule.AddHosts("^(bad")).To(MatchError(ContainSubstring("error compiling host regex /^(bad/")))
+	})
+
+	It("returns an error for an invalid header regex", func() {
+		rule, err := NewRule("rule", DenyPolicy, nil, "", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rule.AddHeader("X-Internal", "(bad")).To(MatchError(ContainSubstring("error compiling header X-Internal regex /(bad/")))
+	})
+
+	It("returns an error for an invalid IP", func() {
+		_, err := NewRule("rule", DenyPolicy, nil, "", nil, []string{"not-an-ip"})
+		Expect(err).To(MatchError("could not parse IP network (not-an-ip)"))
+	})
+
+	DescribeTable("ParseAction",
+		func(name string, expected Action, expectedErr string) {
+			action, err := ParseAction(name)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(action).To(Equal(expected))
+			Expect(action.String()).To(Equal(name))
+		},
+		Entry("error page", "error_page", ErrorPageAction, ""),
+		Entry("forbidden", "forbidden", ForbiddenAction, ""),
+		Entry("unauthorized", "unauthorized", UnauthorizedAction, ""),
+		Entry("sign in", "sign_in", SignInAction, ""),
+		Entry("JSON", "json", JSONAction, ""),
+		Entry("unknown", "redirect", ErrorPageAction, `unknown action "redirect"`),
+	)
+})
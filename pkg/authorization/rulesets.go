@@ -0,0 +1,99 @@
+package authorization
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultRuleSet is the name of the rule set built from the deny options
+const DefaultRuleSet = "default"
+
+// RuleSets holds named rules engines, one of which is active.
+// The active rule set can be switched at runtime, and the previously active
+// rule set is kept so that a switch can be rolled back.
+type RuleSets struct {
+	mu       sync.RWMutex
+	sets     map[string]*RulesEngine
+	active   string
+	previous string
+}
+
+// NewRuleSets constructs RuleSets from the named rules engines with the given
+// rule set active
+func NewRuleSets(active string, sets map[string]*RulesEngine) (*RuleSets, error) {
+	if _, ok := sets[active]; !ok {
+		return nil, fmt.Errorf("unknown rule set %q", active)
+	}
+	return &RuleSets{
+		sets:   sets,
+		active: active,
+	}, nil
+}
+
+// Active returns the name and rules engine of the active rule set
+func (r *RuleSets) Active() (string, *RulesEngine) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active, r.sets[r.active]
+}
+
+// Get returns the rules engine of the named rule set
+func (r *RuleSets) Get(name string) (*RulesEngine, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	engine, ok := r.sets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rule set %q", name)
+	}
+	return engine, nil
+}
+
+// Previous returns the name of the previously active rule set, or an empty
+// string if the active rule set has not been switched
+func (r *RuleSets) Previous() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.previous
+}
+
+// Names returns the names of all rule sets in alphabetical order
+func (r *RuleSets) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.sets))
+	for name := range r.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Switch makes the named rule set active, keeping the current rule set as
+// the previous rule set for Rollback.
+// Switching to the active rule set does nothing.
+func (r *RuleSets) Switch(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sets[name]; !ok {
+		return fmt.Errorf("unknown rule set %q", name)
+	}
+	if name == r.active {
+		return nil
+	}
+	r.previous, r.active = r.active, name
+	return nil
+}
+
+// Rollback makes the previous rule set active again, returning its name.
+// Rolling back twice restores the rule set that was rolled back.
+func (r *RuleSets) Rollback() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.previous == "" {
+		return "", errors.New("there is no previous rule set to roll back to")
+	}
+	r.previous, r.active = r.active, r.previous
+	return r.active, nil
+}
@@ -0,0 +1,79 @@
+package authorization
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RuleSets Suite", func() {
+	var blue, green *RulesEngine
+	var ruleSets *RuleSets
+
+	BeforeEach(func() {
+		blue = NewRulesEngine(nil, nil)
+		green = NewRulesEngine(nil, nil)
+
+		var err error
+		ruleSets, err = NewRuleSets("blue", map[string]*RulesEngine{"blue": blue, "green": green})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	expectActive := func(expectedName string, expectedEngine *RulesEngine) {
+		name, engine := ruleSets.Active()
+		Expect(name).To(Equal(expectedName))
+		Expect(engine).To(BeIdenticalTo(expectedEngine))
+	}
+
+	It("starts with the given rule set active", func() {
+		expectActive("blue", blue)
+		Expect(ruleSets.Previous()).To(BeEmpty())
+		Expect(ruleSets.Names()).To(Equal([]string{"blue", "green"}))
+	})
+
+	It("requires the active rule set to exist", func() {
+		_, err := NewRuleSets("red", map[string]*RulesEngine{"blue": blue})
+		Expect(err).To(MatchError("unknown rule set \"red\""))
+	})
+
+	It("gets rule sets by name", func() {
+		engine, err := ruleSets.Get("green")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(engine).To(BeIdenticalTo(green))
+
+		_, err = ruleSets.Get("red")
+		Expect(err).To(MatchError("unknown rule set \"red\""))
+	})
+
+	It("switches the active rule set", func() {
+		Expect(ruleSets.Switch("green")).To(Succeed())
+		expectActive("green", green)
+		Expect(ruleSets.Previous()).To(Equal("blue"))
+	})
+
+	It("does not switch to an unknown rule set", func() {
+		Expect(ruleSets.Switch("red")).To(MatchError("unknown rule set \"red\""))
+		expectActive("blue", blue)
+	})
+
+	It("keeps the previous rule set when switching to the active rule set", func() {
+		Expect(ruleSets.Switch("green")).To(Succeed())
+		Expect(ruleSets.Switch("green")).To(Succeed())
+		Expect(ruleSets.Previous()).To(Equal("blue"))
+	})
+
+	It("rolls back to the previous rule set", func() {
+		Expect(ruleSets.Switch("green")).To(Succeed())
+
+		name, err := ruleSets.Rollback()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(Equal("blue"))
+		expectActive("blue", blue)
+		Expect(ruleSets.Previous()).To(Equal("green"))
+	})
+
+	It("cannot roll back without a previous rule set", func() {
+		_, err := ruleSets.Rollback()
+		Expect(err).To(MatchError("there is no previous rule set to roll back to"))
+		expectActive("blue", blue)
+	})
+})
@@ -0,0 +1,194 @@
+package authorization
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization/index"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
+)
+
+// webhookCacheSize is the number of decisions a webhook caches before expired
+// decisions are removed
+const webhookCacheSize = 10000
+
+// Webhook asks an external HTTP endpoint to authorize requests.
+// The endpoint is sent a JSON description of the request and the session,
+// and responds with 200 OK to allow the request or 403 Forbidden to deny it.
+type Webhook struct {
+	url      string
+	timeout  time.Duration
+	failOpen bool
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]webhookDecision
+}
+
+// webhookDecision is a cached decision of a webhook
+type webhookDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// webhookRequest is the body of the requests sent to a webhook
+type webhookRequest struct {
+	Method   string          `json:"method"`
+	Host     string          `json:"host"`
+	Path     string          `json:"path"`
+	Query    string          `json:"query,omitempty"`
+	ClientIP string          `json:"clientIP,omitempty"`
+	Session  *webhookSession `json:"session,omitempty"`
+}
+
+// webhookSession is the session of the user making a request to a webhook
+type webhookSession struct {
+	User              string   `json:"user"`
+	Email             string   `json:"email"`
+	Groups            []string `json:"groups,omitempty"`
+	PreferredUsername string   `json:"preferredUsername,omitempty"`
+}
+
+// NewWebhook constructs a webhook calling the URL.
+// When the endpoint cannot be reached, times out or responds with another
+// status, the request is allowed if failOpen is set and denied otherwise.
+// Decisions are cached for cacheTTL, unless it is 0.
+func NewWebhook(webhookURL string, timeout time.Duration, failOpen bool, cacheTTL time.Duration) (*Webhook, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webhook URL %q: %v", webhookURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook URL %q must be an absolute http or https URL", webhookURL)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("webhook timeout (%s) must be positive", timeout)
+	}
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("webhook cache TTL (%s) must not be negative", cacheTTL)
+	}
+
+	return &Webhook{
+		url:      webhookURL,
+		timeout:  timeout,
+		failOpen: failOpen,
+		cacheTTL: cacheTTL,
+		cache:    map[string]webhookDecision{},
+	}, nil
+}
+
+// URL is the endpoint the webhook calls
+func (w *Webhook) URL() string {
+	return w.url
+}
+
+// allows checks whether the webhook allows the request
+func (w *Webhook) allows(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState) bool {
+	body, err := json.Marshal(newWebhookRequest(req, clientIP, session))
+	if err != nil {
+		logger.Errorf("Error encoding request for authorization webhook %s: %v", w.url, err)
+		return w.failOpen
+	}
+
+	key := string(body)
+	if allowed, ok := w.cached(key); ok {
+		return allowed
+	}
+
+	allowed, err := w.call(req.Context(), body)
+	if err != nil {
+		logger.Errorf("Error calling authorization webhook %s: %v", w.url, err)
+		return w.failOpen
+	}
+	w.store(key, allowed)
+	return allowed
+}
+
+// call sends the request body to the endpoint and returns its decision
+func (w *Webhook) call(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+func (w *Webhook) cached(key string) (bool, bool) {
+	if w.cacheTTL == 0 {
+		return false, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	decision, ok := w.cache[key]
+	if !ok || time.Now().After(decision.expires) {
+		return false, false
+	}
+	return decision.allowed, true
+}
+
+func (w *Webhook) store(key string, allowed bool) {
+	if w.cacheTTL == 0 || !memory.Allow(memory.AuthorizationWebhookCache) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if len(w.cache) >= webhookCacheSize {
+		for k, decision := range w.cache {
+			if now.After(decision.expires) {
+				delete(w.cache, k)
+			}
+		}
+	}
+	if len(w.cache) < webhookCacheSize {
+		w.cache[key] = webhookDecision{allowed: allowed, expires: now.Add(w.cacheTTL)}
+	}
+}
+
+func newWebhookRequest(req *http.Request, clientIP net.IP, session *sessionsapi.SessionState) *webhookRequest {
+	webhookReq := &webhookRequest{
+		Method: req.Method,
+		Host:   index.RequestHost(req),
+		Path:   req.URL.Path,
+		Query:  req.URL.RawQuery,
+	}
+	if clientIP != nil {
+		webhookReq.ClientIP = clientIP.String()
+	}
+	if session != nil {
+		webhookReq.Session = &webhookSession{
+			User:              session.User,
+			Email:             session.Email,
+			Groups:            session.Groups,
+			PreferredUsername: session.PreferredUsername,
+		}
+	}
+	return webhookReq
+}
@@ -0,0 +1,169 @@
+package authorization
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhook Suite", func() {
+	var (
+		server   *httptest.Server
+		status   int32
+		calls    int32
+		received webhookRequest
+	)
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&status, http.StatusOK)
+		atomic.StoreInt32(&calls, 0)
+		received = webhookRequest{}
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			Expect(req.Method).To(Equal(http.MethodPost))
+			Expect(json.NewDecoder(req.Body).Decode(&received)).To(Succeed())
+			if req.URL.Path == "/slow" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			rw.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	session := &sessionsapi.SessionState{
+		User:   "123456789",
+		Email:  "john.doe@example.com",
+		Groups: []string{"admins"},
+	}
+
+	type webhookTableInput struct {
+		path     string
+		status   int
+		failOpen bool
+		policy   Policy
+		expected bool
+	}
+
+	DescribeTable("matches",
+		func(in webhookTableInput) {
+			atomic.StoreInt32(&status, int32(in.status))
+			webhook, err := NewWebhook(server.URL+in.path, 50*time.Millisecond, in.failOpen, 0)
+			Expect(err).ToNot(HaveOccurred())
+			rule := &Rule{ID: "webhook", Policy: in.policy, Webhook: webhook}
+
+			req := httptest.NewRequest("GET", "http://app.example.com/admin?page=2", nil)
+			Expect(rule.matches(req, net.ParseIP("10.0.0.1"), session)).To(Equal(in.expected))
+		},
+		Entry("a deny rule when the webhook denies", webhookTableInput{
+			status:   http.StatusForbidden,
+			policy:   DenyPolicy,
+			expected: true,
+		}),
+		Entry("a deny rule when the webhook allows", webhookTableInput{
+			status:   http.StatusOK,
+			policy:   DenyPolicy,
+			expected: false,
+		}),
+		Entry("an allow rule when the webhook allows", webhookTableInput{
+			status:   http.StatusOK,
+			policy:   AllowPolicy,
+			expected: true,
+		}),
+		Entry("a deny rule when the webhook fails closed", webhookTableInput{
+			status:   http.StatusInternalServerError,
+			policy:   DenyPolicy,
+			expected: true,
+		}),
+		Entry("a deny rule when the webhook fails open", webhookTableInput{
+			status:   http.StatusInternalServerError,
+			failOpen: true,
+			policy:   DenyPolicy,
+			expected: false,
+		}),
+		Entry("a deny rule when the webhook times out", webhookTableInput{
+			path:     "/slow",
+			status:   http.StatusOK,
+			policy:   DenyPolicy,
+			expected: true,
+		}),
+	)
+
+	It("sends the request and session to the webhook", func() {
+		webhook, err := NewWebhook(server.URL, time.Second, false, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest("GET", "http://app.example.com/admin?page=2", nil)
+		Expect(webhook.allows(req, net.ParseIP("10.0.0.1"), session)).To(BeTrue())
+		Expect(received).To(Equal(webhookRequest{
+			Method:   "GET",
+			Host:     "app.example.com",
+			Path:     "/admin",
+			Query:    "page=2",
+			ClientIP: "10.0.0.1",
+			Session: &webhookSession{
+				User:   "123456789",
+				Email:  "john.doe@example.com",
+				Groups: []string{"admins"},
+			},
+		}))
+	})
+
+	It("does not match requests without a session", func() {
+		webhook, err := NewWebhook(server.URL, time.Second, false, 0)
+		Expect(err).ToNot(HaveOccurred())
+		rule := &Rule{ID: "webhook", Policy: AllowPolicy, Webhook: webhook}
+
+		req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		Expect(rule.matches(req, nil, nil)).To(BeFalse())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(0))
+	})
+
+	It("caches decisions", func() {
+		webhook, err := NewWebhook(server.URL, time.Second, false, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		Expect(webhook.allows(req, nil, session)).To(BeTrue())
+		atomic.StoreInt32(&status, http.StatusForbidden)
+		Expect(webhook.allows(req, nil, session)).To(BeTrue())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+
+		req = httptest.NewRequest("GET", "http://app.example.com/other", nil)
+		Expect(webhook.allows(req, nil, session)).To(BeFalse())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+
+	It("does not cache errors", func() {
+		webhook, err := NewWebhook(server.URL, time.Second, false, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		atomic.StoreInt32(&status, http.StatusBadGateway)
+		req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		Expect(webhook.allows(req, nil, session)).To(BeFalse())
+		atomic.StoreInt32(&status, http.StatusOK)
+		Expect(webhook.allows(req, nil, session)).To(BeTrue())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+
+	DescribeTable("NewWebhook",
+		func(url string, timeout, cacheTTL time.Duration, expectedErr string) {
+			_, err := NewWebhook(url, timeout, false, cacheTTL)
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("with a relative URL", "/authorize", time.Second, time.Duration(0), `webhook URL "/authorize" must be an absolute http or https URL`),
+		Entry("with an unsupported scheme", "ftp://example.com/", time.Second, time.Duration(0), `webhook URL "ftp://example.com/" must be an absolute http or https URL`),
+		Entry("without a timeout", "http://example.com/", time.Duration(0), time.Duration(0), "webhook timeout (0s) must be positive"),
+		Entry("with a negative cache TTL", "http://example.com/", time.Second, -time.Second, "webhook cache TTL (-1s) must not be negative"),
+	)
+})
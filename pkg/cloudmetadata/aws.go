@@ -0,0 +1,56 @@
+package cloudmetadata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+const (
+	awsMetadataEndpoint = "http://169.254.169.254"
+
+	// awsTokenTTL is the lifetime in seconds of IMDSv2 session tokens
+	awsTokenTTL = "60"
+)
+
+// awsProvider reads the CIDR blocks of the VPC of the primary network
+// interface from the EC2 instance metadata service (IMDSv2)
+type awsProvider struct {
+	endpoint string
+}
+
+// Name returns the name of the cloud provider
+func (p *awsProvider) Name() string {
+	return "aws"
+}
+
+// CIDRs returns the IPv4 CIDR blocks of the VPC
+func (p *awsProvider) CIDRs(ctx context.Context) ([]string, error) {
+	result := requests.New(p.endpoint+"/latest/api/token").
+		WithContext(ctx).
+		WithMethod("PUT").
+		SetHeader("X-aws-ec2-metadata-token-ttl-seconds", awsTokenTTL).
+		Do()
+	if result.Error() != nil {
+		return nil, fmt.Errorf("error requesting metadata token: %v", result.Error())
+	}
+	if result.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("error requesting metadata token: unexpected status \"%d\"", result.StatusCode())
+	}
+
+	header := http.Header{}
+	header.Set("X-aws-ec2-metadata-token", string(result.Body()))
+
+	mac, err := getText(ctx, p.endpoint+"/latest/meta-data/mac", header)
+	if err != nil {
+		return nil, fmt.Errorf("error reading instance MAC address: %v", err)
+	}
+	blocks, err := getText(ctx, fmt.Sprintf("%s/latest/meta-data/network/interfaces/macs/%s/vpc-ipv4-cidr-blocks", p.endpoint, mac), header)
+	if err != nil {
+		return nil, fmt.Errorf("error reading VPC CIDR blocks: %v", err)
+	}
+	return strings.Fields(blocks), nil
+}
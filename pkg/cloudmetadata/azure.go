@@ -0,0 +1,54 @@
+package cloudmetadata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+const azureMetadataEndpoint = "http://169.254.169.254"
+
+// azureNetwork is the network section of the Azure instance metadata
+type azureNetwork struct {
+	Interface []struct {
+		IPv4 struct {
+			Subnet []struct {
+				Address string `json:"address"`
+				Prefix  string `json:"prefix"`
+			} `json:"subnet"`
+		} `json:"ipv4"`
+	} `json:"interface"`
+}
+
+// azureProvider reads the subnets of the network interfaces of the virtual
+// machine from the Azure Instance Metadata Service
+type azureProvider struct {
+	endpoint string
+}
+
+// Name returns the name of the cloud provider
+func (p *azureProvider) Name() string {
+	return "azure"
+}
+
+// CIDRs returns the IPv4 subnets of the virtual machine
+func (p *azureProvider) CIDRs(ctx context.Context) ([]string, error) {
+	var network azureNetwork
+	err := requests.New(p.endpoint+"/metadata/instance/network?api-version=2021-02-01").
+		WithContext(ctx).
+		SetHeader("Metadata", "true").
+		Do().
+		UnmarshalInto(&network)
+	if err != nil {
+		return nil, fmt.Errorf("error reading network metadata: %v", err)
+	}
+
+	cidrs := []string{}
+	for _, iface := range network.Interface {
+		for _, subnet := range iface.IPv4.Subnet {
+			cidrs = append(cidrs, fmt.Sprintf("%s/%s", subnet.Address, subnet.Prefix))
+		}
+	}
+	return cidrs, nil
+}
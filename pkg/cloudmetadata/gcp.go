@@ -0,0 +1,59 @@
+package cloudmetadata
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+const gcpMetadataEndpoint = "http://metadata.google.internal"
+
+// gcpLoadBalancerRanges are the documented source ranges of Google Cloud
+// load balancers and health checks
+var gcpLoadBalancerRanges = []string{"35.191.0.0/16", "130.211.0.0/22"}
+
+// gcpNetworkInterface is a network interface in the GCE metadata
+type gcpNetworkInterface struct {
+	IP         string `json:"ip"`
+	SubnetMask string `json:"subnetmask"`
+}
+
+// gcpProvider reads the subnets of the network interfaces of the instance
+// from the GCE metadata server
+type gcpProvider struct {
+	endpoint string
+}
+
+// Name returns the name of the cloud provider
+func (p *gcpProvider) Name() string {
+	return "gcp"
+}
+
+// CIDRs returns the subnets of the instance and the ranges of Google Cloud
+// load balancers
+func (p *gcpProvider) CIDRs(ctx context.Context) ([]string, error) {
+	var interfaces []gcpNetworkInterface
+	err := requests.New(p.endpoint+"/computeMetadata/v1/instance/network-interfaces/?recursive=true").
+		WithContext(ctx).
+		SetHeader("Metadata-Flavor", "Google").
+		Do().
+		UnmarshalInto(&interfaces)
+	if err != nil {
+		return nil, fmt.Errorf("error reading network interfaces: %v", err)
+	}
+
+	cidrs := []string{}
+	for _, iface := range interfaces {
+		ip := net.ParseIP(iface.IP).To4()
+		mask := net.ParseIP(iface.SubnetMask).To4()
+		if ip == nil || mask == nil {
+			return nil, fmt.Errorf("invalid network interface %s/%s", iface.IP, iface.SubnetMask)
+		}
+		ipMask := net.IPMask(mask)
+		subnet := net.IPNet{IP: ip.Mask(ipMask), Mask: ipMask}
+		cidrs = append(cidrs, subnet.String())
+	}
+	return append(cidrs, gcpLoadBalancerRanges...), nil
+}
@@ -0,0 +1,51 @@
+package cloudmetadata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// Provider discovers the network ranges of the environment the proxy is
+// running in from the metadata service of a cloud provider
+type Provider interface {
+	// Name returns the name of the cloud provider
+	Name() string
+
+	// CIDRs returns the source ranges of the VPC and load balancers in
+	// CIDR format
+	CIDRs(ctx context.Context) ([]string, error)
+}
+
+// NewProvider creates the Provider for the named cloud provider.
+// One of "aws", "gcp" or "azure".
+func NewProvider(name string) (Provider, error) {
+	switch name {
+	case "aws":
+		return &awsProvider{endpoint: awsMetadataEndpoint}, nil
+	case "gcp":
+		return &gcpProvider{endpoint: gcpMetadataEndpoint}, nil
+	case "azure":
+		return &azureProvider{endpoint: azureMetadataEndpoint}, nil
+	default:
+		return nil, fmt.Errorf("unknown cloud provider %q", name)
+	}
+}
+
+// getText fetches a plain text metadata value
+func getText(ctx context.Context, endpoint string, header http.Header) (string, error) {
+	result := requests.New(endpoint).
+		WithContext(ctx).
+		WithHeaders(header).
+		Do()
+	if result.Error() != nil {
+		return "", result.Error()
+	}
+	if result.StatusCode() != http.StatusOK {
+		return "", fmt.Errorf("unexpected status \"%d\": %s", result.StatusCode(), result.Body())
+	}
+	return strings.TrimSpace(string(result.Body())), nil
+}
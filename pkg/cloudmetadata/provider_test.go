@@ -0,0 +1,109 @@
+package cloudmetadata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Providers", func() {
+	var server *httptest.Server
+	var handlers map[string]http.HandlerFunc
+
+	BeforeEach(func() {
+		handlers = map[string]http.HandlerFunc{}
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			handler, ok := handlers[req.Method+" "+req.URL.RequestURI()]
+			if !ok {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			handler(rw, req)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	respond := func(header, value, body string) http.HandlerFunc {
+		return func(rw http.ResponseWriter, req *http.Request) {
+			if req.Header.Get(header) != value {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, err := rw.Write([]byte(body))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	DescribeTable("NewProvider",
+		func(name string, expectedErr string) {
+			provider, err := NewProvider(name)
+			if expectedErr != "" {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provider.Name()).To(Equal(name))
+		},
+		Entry("with aws", "aws", ""),
+		Entry("with gcp", "gcp", ""),
+		Entry("with azure", "azure", ""),
+		Entry("with an unknown provider", "openstack", "unknown cloud provider \"openstack\""),
+	)
+
+	Context("aws", func() {
+		BeforeEach(func() {
+			handlers["PUT /latest/api/token"] = respond("X-aws-ec2-metadata-token-ttl-seconds", awsTokenTTL, "token")
+			handlers["GET /latest/meta-data/mac"] = respond("X-aws-ec2-metadata-token", "token", "0e:00:00:00:00:01")
+			handlers["GET /latest/meta-data/network/interfaces/macs/0e:00:00:00:00:01/vpc-ipv4-cidr-blocks"] =
+				respond("X-aws-ec2-metadata-token", "token", "10.0.0.0/16\n10.1.0.0/16\n")
+		})
+
+		It("returns the VPC CIDR blocks", func() {
+			provider := &awsProvider{endpoint: server.URL}
+			Expect(provider.CIDRs(context.Background())).To(Equal([]string{"10.0.0.0/16", "10.1.0.0/16"}))
+		})
+
+		It("errors when a token cannot be obtained", func() {
+			delete(handlers, "PUT /latest/api/token")
+			provider := &awsProvider{endpoint: server.URL}
+			_, err := provider.CIDRs(context.Background())
+			Expect(err).To(MatchError("error requesting metadata token: unexpected status \"404\""))
+		})
+	})
+
+	Context("gcp", func() {
+		It("returns the subnets and load balancer ranges", func() {
+			handlers["GET /computeMetadata/v1/instance/network-interfaces/?recursive=true"] = respond("Metadata-Flavor", "Google",
+				`[{"ip": "10.128.0.7", "subnetmask": "255.255.240.0", "gateway": "10.128.0.1"}]`)
+
+			provider := &gcpProvider{endpoint: server.URL}
+			Expect(provider.CIDRs(context.Background())).To(Equal([]string{"10.128.0.0/20", "35.191.0.0/16", "130.211.0.0/22"}))
+		})
+
+		It("errors on an invalid interface", func() {
+			handlers["GET /computeMetadata/v1/instance/network-interfaces/?recursive=true"] = respond("Metadata-Flavor", "Google",
+				`[{"ip": "10.128.0.7", "subnetmask": ""}]`)
+
+			provider := &gcpProvider{endpoint: server.URL}
+			_, err := provider.CIDRs(context.Background())
+			Expect(err).To(MatchError("invalid network interface 10.128.0.7/"))
+		})
+	})
+
+	Context("azure", func() {
+		It("returns the subnets", func() {
+			handlers["GET /metadata/instance/network?api-version=2021-02-01"] = respond("Metadata", "true",
+				`{"interface": [{"ipv4": {"subnet": [{"address": "10.1.0.0", "prefix": "24"}]}}, {"ipv4": {"subnet": [{"address": "10.2.0.0", "prefix": "26"}]}}]}`)
+
+			provider := &azureProvider{endpoint: server.URL}
+			Expect(provider.CIDRs(context.Background())).To(Equal([]string{"10.1.0.0/24", "10.2.0.0/26"}))
+		})
+	})
+})
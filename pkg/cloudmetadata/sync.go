@@ -0,0 +1,109 @@
+package cloudmetadata
+
+import (
+	"context"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// Target is a set of IP ranges that the discovered CIDRs are kept in sync
+// with, such as the trusted IPs allowlist
+type Target interface {
+	Add(ipStr string) error
+	Remove(ipStr string) (bool, error)
+	Entries() []string
+}
+
+// Syncer periodically fetches the CIDRs from a Provider and reconciles them
+// into a Target.
+// Ranges that were already in the Target are never removed by the Syncer.
+type Syncer struct {
+	provider Provider
+	target   Target
+	interval time.Duration
+	timeout  time.Duration
+
+	// synced are the ranges added to the target by the Syncer
+	synced map[string]struct{}
+}
+
+// NewSyncer constructs a Syncer for the provider and target
+func NewSyncer(provider Provider, target Target, interval time.Duration) *Syncer {
+	return &Syncer{
+		provider: provider,
+		target:   target,
+		interval: interval,
+		timeout:  10 * time.Second,
+		synced:   make(map[string]struct{}),
+	}
+}
+
+// Run syncs the CIDRs immediately and then on each interval until done is
+// closed. Errors are logged and the previous ranges are kept.
+func (s *Syncer) Run(done <-chan bool) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(); err != nil {
+			logger.Errorf("Error syncing trusted IPs from %s metadata: %v", s.provider.Name(), err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches the CIDRs from the provider once, adding new ranges to the
+// target and removing ranges that are no longer reported
+func (s *Syncer) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	cidrs, err := s.provider.CIDRs(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]struct{})
+	for _, entry := range s.target.Entries() {
+		existing[entry] = struct{}{}
+	}
+
+	current := make(map[string]struct{})
+	for _, cidr := range cidrs {
+		ipNet := ip.ParseIPNet(cidr)
+		if ipNet == nil {
+			logger.Errorf("Ignoring invalid CIDR %q from %s metadata", cidr, s.provider.Name())
+			continue
+		}
+		entry := ipNet.String()
+		current[entry] = struct{}{}
+
+		if _, ok := existing[entry]; ok {
+			continue
+		}
+		if err := s.target.Add(entry); err != nil {
+			return err
+		}
+		s.synced[entry] = struct{}{}
+		logger.Printf("Trusting %s from %s metadata", entry, s.provider.Name())
+	}
+
+	for entry := range s.synced {
+		if _, ok := current[entry]; ok {
+			continue
+		}
+		if _, err := s.target.Remove(entry); err != nil {
+			return err
+		}
+		delete(s.synced, entry)
+		logger.Printf("No longer trusting %s from %s metadata", entry, s.provider.Name())
+	}
+	return nil
+}
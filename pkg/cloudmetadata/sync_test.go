@@ -0,0 +1,75 @@
+package cloudmetadata
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeProvider struct {
+	cidrs []string
+	err   error
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) CIDRs(context.Context) ([]string, error) {
+	return f.cidrs, f.err
+}
+
+var _ = Describe("Syncer", func() {
+	var provider *fakeProvider
+	var target *allowlist.IPs
+	var syncer *Syncer
+
+	BeforeEach(func() {
+		provider = &fakeProvider{}
+		target = allowlist.NewIPs(nil)
+		Expect(target.Add("192.168.0.0/16")).To(Succeed())
+		syncer = NewSyncer(provider, target, 0)
+	})
+
+	It("adds the discovered ranges", func() {
+		provider.cidrs = []string{"10.0.0.0/16", "10.1.0.1"}
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("192.168.0.0/16", "10.0.0.0/16", "10.1.0.1/32"))
+	})
+
+	It("removes ranges that are no longer reported", func() {
+		provider.cidrs = []string{"10.0.0.0/16", "10.1.0.0/16"}
+		Expect(syncer.Sync()).To(Succeed())
+
+		provider.cidrs = []string{"10.1.0.0/16"}
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("192.168.0.0/16", "10.1.0.0/16"))
+	})
+
+	It("does not remove ranges it did not add", func() {
+		provider.cidrs = []string{"192.168.0.0/16"}
+		Expect(syncer.Sync()).To(Succeed())
+
+		provider.cidrs = []string{}
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("192.168.0.0/16"))
+	})
+
+	It("ignores invalid ranges", func() {
+		provider.cidrs = []string{"not-a-cidr", "10.0.0.0/16"}
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("192.168.0.0/16", "10.0.0.0/16"))
+	})
+
+	It("keeps the previous ranges when the provider errors", func() {
+		provider.cidrs = []string{"10.0.0.0/16"}
+		Expect(syncer.Sync()).To(Succeed())
+
+		provider.err = errors.New("metadata unavailable")
+		Expect(syncer.Sync()).To(MatchError("metadata unavailable"))
+		Expect(target.Entries()).To(ConsistOf("192.168.0.0/16", "10.0.0.0/16"))
+	})
+})
@@ -86,7 +86,7 @@ func (c *cfbCipher) Decrypt(ciphertext []byte) ([]byte, error) {
 }
 
 type gcmCipher struct {
-	cipher.Block
+	gcm cipher.AEAD
 }
 
 // NewGCMCipher returns a new AES GCM Cipher
@@ -95,36 +95,36 @@ func NewGCMCipher(secret []byte) (Cipher, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &gcmCipher{Block: c}, err
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmCipher{gcm: gcm}, nil
 }
 
 // Encrypt with AES GCM on raw bytes
 func (c *gcmCipher) Encrypt(value []byte) ([]byte, error) {
-	gcm, err := cipher.NewGCM(c.Block)
-	if err != nil {
-		return nil, err
-	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	nonceSize := c.gcm.NonceSize()
+	// Allocate the whole ciphertext up front so that Seal does not grow it
+	ciphertext := make([]byte, nonceSize, nonceSize+len(value)+c.gcm.Overhead())
+	nonce := ciphertext[:nonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 	// Using nonce as Seal's dst argument results in it being the first
 	// chunk of bytes in the ciphertext. Decrypt retrieves the nonce/IV from this.
-	ciphertext := gcm.Seal(nonce, nonce, value, nil)
-	return ciphertext, nil
+	return c.gcm.Seal(ciphertext, nonce, value, nil), nil
 }
 
 // Decrypt an AES GCM ciphertext
 func (c *gcmCipher) Decrypt(ciphertext []byte) ([]byte, error) {
-	gcm, err := cipher.NewGCM(c.Block)
-	if err != nil {
-		return nil, err
+	nonceSize := c.gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("encrypted value should be at least %d bytes, but is only %d bytes", nonceSize, len(ciphertext))
 	}
-
-	nonceSize := gcm.NonceSize()
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := c.gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
 		return nil, err
 	}
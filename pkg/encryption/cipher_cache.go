@@ -0,0 +1,76 @@
+package encryption
+
+import (
+	"container/list"
+	"sync"
+)
+
+// gcmCipherCacheSize is the number of GCM ciphers kept for reuse.
+// Session tickets each have their own secret, so this bounds the memory used
+// by the ciphers of active sessions.
+const gcmCipherCacheSize = 4096
+
+// gcmCiphers caches the GCM ciphers constructed by CachedGCMCipher
+var gcmCiphers = newCipherCache(gcmCipherCacheSize)
+
+// CachedGCMCipher returns an AES GCM Cipher for the secret, reusing the cipher
+// constructed for a previous call with the same secret when possible.
+// The ciphers are safe for concurrent use.
+func CachedGCMCipher(secret []byte) (Cipher, error) {
+	return gcmCiphers.get(secret, NewGCMCipher)
+}
+
+// cipherCache is a least recently used cache of ciphers keyed by their secret
+type cipherCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// cipherCacheEntry is the value of each element of the cache order
+type cipherCacheEntry struct {
+	secret string
+	cipher Cipher
+}
+
+func newCipherCache(size int) *cipherCache {
+	return &cipherCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the cached cipher for the secret, or constructs and caches a
+// new one
+func (c *cipherCache) get(secret []byte, newCipher func([]byte) (Cipher, error)) (Cipher, error) {
+	key := string(secret)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cipherCacheEntry).cipher, nil
+	}
+	c.mu.Unlock()
+
+	// Construct the cipher without holding the lock, another request may
+	// construct the same cipher concurrently
+	cipher, err := newCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&cipherCacheEntry{secret: key, cipher: cipher})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cipherCacheEntry).secret)
+		}
+	}
+	return cipher, nil
+}
@@ -0,0 +1,138 @@
+package encryption
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedGCMCipher(t *testing.T) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	c, err := CachedGCMCipher(secret)
+	assert.NoError(t, err)
+
+	cached, err := CachedGCMCipher([]byte("0123456789abcdefghijklmnopqrstuv"))
+	assert.NoError(t, err)
+	assert.True(t, c == cached)
+
+	other, err := CachedGCMCipher([]byte("abcdefghijklmnopqrstuv0123456789"))
+	assert.NoError(t, err)
+	assert.False(t, c == other)
+
+	encrypted, err := c.Encrypt([]byte("value"))
+	assert.NoError(t, err)
+	decrypted, err := cached.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), decrypted)
+
+	_, err = CachedGCMCipher([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestCipherCacheEviction(t *testing.T) {
+	cache := newCipherCache(2)
+	get := func(secret string) Cipher {
+		c, err := cache.get([]byte(secret), NewGCMCipher)
+		assert.NoError(t, err)
+		return c
+	}
+
+	first := get("0123456789abcdef")
+	second := get("fedcba9876543210")
+	// Use the first cipher so that the second is the least recently used
+	assert.True(t, first == get("0123456789abcdef"))
+	get("abcdef0123456789")
+
+	assert.Equal(t, 2, cache.order.Len())
+	assert.True(t, first == get("0123456789abcdef"))
+	assert.False(t, second == get("fedcba9876543210"))
+}
+
+func TestGCMDecryptShortCiphertext(t *testing.T) {
+	c, err := NewGCMCipher([]byte("0123456789abcdefghijklmnopqrstuv"))
+	assert.NoError(t, err)
+
+	_, err = c.Decrypt([]byte("short"))
+	assert.EqualError(t, err, "encrypted value should be at least 12 bytes, but is only 5 bytes")
+}
+
+func BenchmarkNewGCMCipher(b *testing.B) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewGCMCipher(secret); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCachedGCMCipher(b *testing.B) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := CachedGCMCipher(secret); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCiphers(b *testing.B) {
+	secret := []byte("0123456789abcdefghijklmnopqrstuv")
+	cfb, err := NewCFBCipher(secret)
+	assert.NoError(b, err)
+	gcm, err := NewGCMCipher(secret)
+	assert.NoError(b, err)
+	value := make([]byte, 1024)
+
+	for name, c := range map[string]Cipher{"CFB": cfb, "GCM": gcm, "Base64 CFB": NewBase64Cipher(cfb)} {
+		encrypted, err := c.Encrypt(value)
+		assert.NoError(b, err)
+
+		b.Run(fmt.Sprintf("%s Encrypt", name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Encrypt(value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("%s Decrypt", name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Decrypt(encrypted); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSignedCookie(b *testing.B) {
+	const seed = "0123456789abcdefghijklmnopqrstuv"
+	value := make([]byte, 1024)
+	now := time.Now()
+
+	signed, err := SignedValue(seed, "_oauth2_proxy", value, now)
+	assert.NoError(b, err)
+	cookie := &http.Cookie{Name: "_oauth2_proxy", Value: signed}
+
+	b.Run("Sign", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := SignedValue(seed, "_oauth2_proxy", value, now); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Validate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, ok := Validate(cookie, seed, time.Hour); !ok {
+				b.Fatal("cookie failed validation")
+			}
+		}
+	})
+}
@@ -35,32 +35,43 @@ func SecretBytes(secret string) []byte {
 
 // Validate ensures a cookie is properly signed
 func Validate(cookie *http.Cookie, seed string, expiration time.Duration) (value []byte, t time.Time, ok bool) {
+	value, t, ok = ParseSignedValue(cookie, seed)
+	if !ok {
+		return
+	}
+	// The expiration timestamp set when the cookie was created
+	// isn't sent back by the browser. Hence, we check whether the
+	// creation timestamp stored in the cookie falls within the
+	// window defined by (Now()-expiration, Now()].
+	if t.After(time.Now().Add(expiration*-1)) && t.Before(time.Now().Add(time.Minute*5)) {
+		// it's a valid cookie
+		return
+	}
+	return nil, t, false
+}
+
+// ParseSignedValue checks the signature of a cookie and returns the decoded
+// value and the time it was signed.
+// Unlike Validate, it does not check whether the cookie has expired.
+func ParseSignedValue(cookie *http.Cookie, seed string) (value []byte, t time.Time, ok bool) {
 	// value, timestamp, sig
 	parts := strings.Split(cookie.Value, "|")
 	if len(parts) != 3 {
 		return
 	}
-	if checkSignature(parts[2], seed, cookie.Name, parts[0], parts[1]) {
-		ts, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return
-		}
-		// The expiration timestamp set when the cookie was created
-		// isn't sent back by the browser. Hence, we check whether the
-		// creation timestamp stored in the cookie falls within the
-		// window defined by (Now()-expiration, Now()].
-		t = time.Unix(int64(ts), 0)
-		if t.After(time.Now().Add(expiration*-1)) && t.Before(time.Now().Add(time.Minute*5)) {
-			// it's a valid cookie. now get the contents
-			rawValue, err := base64.URLEncoding.DecodeString(parts[0])
-			if err == nil {
-				value = rawValue
-				ok = true
-				return
-			}
-		}
+	if !checkSignature(parts[2], seed, cookie.Name, parts[0], parts[1]) {
+		return
+	}
+	ts, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return
+	}
+	t = time.Unix(int64(ts), 0)
+	rawValue, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return
 	}
-	return
+	return rawValue, t, true
 }
 
 // SignedValue returns a cookie that is signed and can later be checked with Validate
@@ -4,6 +4,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options/util"
@@ -78,6 +80,11 @@ func newSecretInjector(name string, source *options.SecretSource) (valueInjector
 }
 
 func newClaimInjector(name string, source *options.ClaimSource) (valueInjector, error) {
+	claimValues, err := newClaimValues(source)
+	if err != nil {
+		return nil, err
+	}
+
 	switch {
 	case source.BasicAuthPassword != nil:
 		password, err := util.GetSecretValue(source.BasicAuthPassword)
@@ -85,34 +92,100 @@ func newClaimInjector(name string, source *options.ClaimSource) (valueInjector,
 			return nil, fmt.Errorf("error loading basicAuthPassword: %v", err)
 		}
 		return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
-			claimValues := session.GetClaim(source.Claim)
-			for _, claim := range claimValues {
-				if claim == "" {
-					continue
-				}
+			for _, claim := range claimValues(session) {
 				auth := claim + ":" + string(password)
 				header.Add(name, "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
 			}
 		}), nil
 	case source.Prefix != "":
 		return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
-			claimValues := session.GetClaim(source.Claim)
-			for _, claim := range claimValues {
-				if claim == "" {
-					continue
-				}
+			for _, claim := range claimValues(session) {
 				header.Add(name, source.Prefix+claim)
 			}
 		}), nil
 	default:
 		return newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
-			claimValues := session.GetClaim(source.Claim)
-			for _, claim := range claimValues {
-				if claim == "" {
-					continue
-				}
+			for _, claim := range claimValues(session) {
 				header.Add(name, claim)
 			}
 		}), nil
 	}
 }
+
+// claimSelector loads the non-empty values of a claim that match a regex
+type claimSelector struct {
+	claim   string
+	part    string
+	matches *regexp.Regexp
+}
+
+func newClaimSelector(claim, part, matches string) (claimSelector, error) {
+	selector := claimSelector{claim: claim, part: part}
+	switch part {
+	case "", options.ClaimPartLocal, options.ClaimPartDomain:
+	default:
+		return selector, fmt.Errorf("claim %q has unknown part %q", claim, part)
+	}
+	if matches != "" {
+		compiled, err := regexp.Compile(matches)
+		if err != nil {
+			return selector, fmt.Errorf("error compiling claim %q regex /%s/: %v", claim, matches, err)
+		}
+		selector.matches = compiled
+	}
+	return selector, nil
+}
+
+func (s claimSelector) values(session *sessionsapi.SessionState) []string {
+	values := []string{}
+	for _, value := range session.GetClaim(s.claim) {
+		value = claimPart(value, s.part)
+		if value == "" || (s.matches != nil && !s.matches.MatchString(value)) {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// claimPart returns the selected part of an email address claim value
+func claimPart(value, part string) string {
+	at := strings.LastIndex(value, "@")
+	switch {
+	case part == "":
+		return value
+	case at < 0:
+		// Not an email address, so the part is missing
+		return ""
+	case part == options.ClaimPartLocal:
+		return value[:at]
+	default:
+		return value[at+1:]
+	}
+}
+
+// newClaimValues returns a function loading the values of the first claim of
+// the source, or of its fallbacks, that has any values
+func newClaimValues(source *options.ClaimSource) (func(*sessionsapi.SessionState) []string, error) {
+	selector, err := newClaimSelector(source.Claim, source.Part, source.Matches)
+	if err != nil {
+		return nil, err
+	}
+	selectors := []claimSelector{selector}
+	for _, fallback := range source.Fallbacks {
+		selector, err := newClaimSelector(fallback.Claim, fallback.Part, fallback.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback: %v", err)
+		}
+		selectors = append(selectors, selector)
+	}
+
+	return func(session *sessionsapi.SessionState) []string {
+		for _, selector := range selectors {
+			if values := selector.values(session); len(values) > 0 {
+				return values
+			}
+		}
+		return nil
+	}, nil
+}
@@ -191,6 +191,168 @@ var _ = Describe("Injector Suite", func() {
 				},
 				expectedErr: nil,
 			}),
+			Entry("with a claim valued header using the claim", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "User",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:   "preferred_username",
+									Matches: "^[a-z.]+$",
+									Fallbacks: []options.ClaimFallback{
+										{
+											Claim: "email",
+											Part:  options.ClaimPartLocal,
+										},
+										{
+											Claim: "user",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{
+					"foo": []string{"bar", "baz"},
+				},
+				session: &sessionsapi.SessionState{
+					PreferredUsername: "john.doe",
+					Email:             "jdoe@example.com",
+					User:              "123456789",
+				},
+				expectedHeaders: http.Header{
+					"foo":  []string{"bar", "baz"},
+					"User": []string{"john.doe"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a claim valued header using a fallback when the claim does not match", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "User",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:   "preferred_username",
+									Matches: "^[a-z.]+$",
+									Fallbacks: []options.ClaimFallback{
+										{
+											Claim: "email",
+											Part:  options.ClaimPartLocal,
+										},
+										{
+											Claim: "user",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{
+					"foo": []string{"bar", "baz"},
+				},
+				session: &sessionsapi.SessionState{
+					PreferredUsername: "John Doe",
+					Email:             "jdoe@example.com",
+					User:              "123456789",
+				},
+				expectedHeaders: http.Header{
+					"foo":  []string{"bar", "baz"},
+					"User": []string{"jdoe"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a claim valued header using the last fallback", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "User",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:   "preferred_username",
+									Matches: "^[a-z.]+$",
+									Fallbacks: []options.ClaimFallback{
+										{
+											Claim: "email",
+											Part:  options.ClaimPartLocal,
+										},
+										{
+											Claim: "user",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{
+					"foo": []string{"bar", "baz"},
+				},
+				session: &sessionsapi.SessionState{
+					User: "123456789",
+				},
+				expectedHeaders: http.Header{
+					"foo":  []string{"bar", "baz"},
+					"User": []string{"123456789"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a claim valued header with no claim or fallback values", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "User",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim:   "preferred_username",
+									Matches: "^[a-z.]+$",
+									Fallbacks: []options.ClaimFallback{
+										{
+											Claim: "email",
+											Part:  options.ClaimPartLocal,
+										},
+										{
+											Claim: "user",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				initialHeaders: http.Header{
+					"foo": []string{"bar", "baz"},
+				},
+				session: &sessionsapi.SessionState{},
+				expectedHeaders: http.Header{
+					"foo": []string{"bar", "baz"},
+				},
+				expectedErr: nil,
+			}),
+			Entry("with a claim valued header with an invalid part", newInjectorTableInput{
+				headers: []options.Header{
+					{
+						Name: "User",
+						Values: []options.HeaderValue{
+							{
+								ClaimSource: &options.ClaimSource{
+									Claim: "email",
+									Fallbacks: []options.ClaimFallback{
+										{
+											Claim: "user",
+											Part:  "user",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				expectedErr: errors.New("error building injector for header \"User\": invalid fallback: claim \"user\" has unknown part \"user\""),
+			}),
 			Entry("with a basicAuthPassword and claim valued header", newInjectorTableInput{
 				headers: []options.Header{
 					{
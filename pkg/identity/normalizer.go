@@ -0,0 +1,128 @@
+package identity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// Normalizer rewrites the email address or user name of a user to a canonical
+// form, so that allowlists and upstreams see the same identity however the
+// provider spells it
+type Normalizer interface {
+	Normalize(identity string) string
+}
+
+// Normalizers applies each Normalizer in order
+type Normalizers []Normalizer
+
+// NewNormalizers creates the Normalizers configured in the options: case
+// folding, then plus address stripping, then domain aliasing.
+// It returns no Normalizers if none are configured.
+func NewNormalizers(opts *options.Options) (Normalizers, error) {
+	normalizers := Normalizers{}
+	if opts.IdentityLowercase {
+		normalizers = append(normalizers, caseFolding{})
+	}
+	if opts.IdentityStripPlusAddress {
+		normalizers = append(normalizers, plusAddressStripping{})
+	}
+	if len(opts.IdentityDomainAliases) > 0 {
+		aliases, err := parseDomainAliases(opts.IdentityDomainAliases)
+		if err != nil {
+			return nil, err
+		}
+		normalizers = append(normalizers, aliases)
+	}
+	return normalizers, nil
+}
+
+// Normalize applies each Normalizer to the identity in order
+func (n Normalizers) Normalize(identity string) string {
+	for _, normalizer := range n {
+		identity = normalizer.Normalize(identity)
+	}
+	return identity
+}
+
+// NormalizeSession normalizes the email and user of the session in place.
+// Normalizing is idempotent, so sessions can be normalized whenever they are
+// loaded.
+func (n Normalizers) NormalizeSession(s *sessionsapi.SessionState) {
+	if len(n) == 0 || s == nil {
+		return
+	}
+	s.Email = n.Normalize(s.Email)
+	s.User = n.Normalize(s.User)
+}
+
+// caseFolding lowercases identities
+type caseFolding struct{}
+
+func (caseFolding) Normalize(identity string) string {
+	return strings.ToLower(identity)
+}
+
+// plusAddressStripping removes the +tag from the local part of email
+// addresses, turning user+tag@example.com into user@example.com
+type plusAddressStripping struct{}
+
+func (plusAddressStripping) Normalize(identity string) string {
+	local, domain, ok := splitEmail(identity)
+	if !ok {
+		return identity
+	}
+	if i := strings.Index(local, "+"); i > 0 {
+		return local[:i] + "@" + domain
+	}
+	return identity
+}
+
+// domainAliases rewrites the domain of email addresses from an old domain to
+// the new one, keyed by the lowercase old domain
+type domainAliases map[string]string
+
+func (d domainAliases) Normalize(identity string) string {
+	local, domain, ok := splitEmail(identity)
+	if !ok {
+		return identity
+	}
+	if alias, ok := d[strings.ToLower(domain)]; ok {
+		return local + "@" + alias
+	}
+	return identity
+}
+
+// parseDomainAliases parses old=new domain aliases. An alias cannot map to a
+// domain that is itself aliased, so that normalizing is idempotent.
+func parseDomainAliases(aliases []string) (domainAliases, error) {
+	parsed := domainAliases{}
+	for _, alias := range aliases {
+		parts := strings.Split(alias, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(alias, "@") {
+			return nil, fmt.Errorf("invalid identity domain alias %q, expected old.example.com=new.example.com", alias)
+		}
+		old := strings.ToLower(parts[0])
+		if _, ok := parsed[old]; ok {
+			return nil, fmt.Errorf("identity domain %q is aliased more than once", parts[0])
+		}
+		parsed[old] = parts[1]
+	}
+	for old, alias := range parsed {
+		if _, ok := parsed[strings.ToLower(alias)]; ok {
+			return nil, fmt.Errorf("identity domain %q is aliased to %q, which is aliased itself", old, alias)
+		}
+	}
+	return parsed, nil
+}
+
+// splitEmail splits an email address into its local part and domain
+func splitEmail(identity string) (string, string, bool) {
+	i := strings.LastIndex(identity, "@")
+	if i <= 0 || i == len(identity)-1 {
+		return "", "", false
+	}
+	return identity[:i], identity[i+1:], true
+}
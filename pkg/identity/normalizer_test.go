@@ -0,0 +1,85 @@
+package identity
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Normalizer Suite", func() {
+	allNormalizers := func() Normalizers {
+		normalizers, err := NewNormalizers(&options.Options{
+			IdentityLowercase:        true,
+			IdentityStripPlusAddress: true,
+			IdentityDomainAliases:    []string{"old.example.com=example.com", "legacy.example.com=example.com"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return normalizers
+	}
+
+	DescribeTable("Normalize",
+		func(identity string, expected string) {
+			normalizers := allNormalizers()
+			Expect(normalizers.Normalize(identity)).To(Equal(expected))
+			// Normalizing is idempotent
+			Expect(normalizers.Normalize(expected)).To(Equal(expected))
+		},
+		Entry("an email", "user@example.com", "user@example.com"),
+		Entry("a mixed case email", "User@Example.COM", "user@example.com"),
+		Entry("a plus address", "user+tag@example.com", "user@example.com"),
+		Entry("an aliased domain", "user@old.example.com", "user@example.com"),
+		Entry("a mixed case aliased plus address", "User+Tag@Legacy.Example.com", "user@example.com"),
+		Entry("a user name", "User+Name", "user+name"),
+		Entry("a plus sign starting the local part", "+tag@example.com", "+tag@example.com"),
+		Entry("an empty identity", "", ""),
+	)
+
+	It("only applies the configured normalizers", func() {
+		normalizers, err := NewNormalizers(&options.Options{
+			IdentityDomainAliases: []string{"old.example.com=example.com"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(normalizers.Normalize("User+Tag@OLD.example.com")).To(Equal("User+Tag@example.com"))
+	})
+
+	It("normalizes the email and user of sessions", func() {
+		session := &sessionsapi.SessionState{
+			Email:             "User+Tag@old.example.com",
+			User:              "User",
+			PreferredUsername: "User",
+		}
+		allNormalizers().NormalizeSession(session)
+		Expect(session).To(Equal(&sessionsapi.SessionState{
+			Email:             "user@example.com",
+			User:              "user",
+			PreferredUsername: "User",
+		}))
+	})
+
+	It("does nothing when no normalizers are configured", func() {
+		normalizers, err := NewNormalizers(&options.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(normalizers).To(BeEmpty())
+
+		session := &sessionsapi.SessionState{Email: "User@Example.com"}
+		normalizers.NormalizeSession(session)
+		Expect(session.Email).To(Equal("User@Example.com"))
+	})
+
+	DescribeTable("invalid domain aliases",
+		func(aliases []string, expectedError string) {
+			_, err := NewNormalizers(&options.Options{IdentityDomainAliases: aliases})
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("without a new domain", []string{"old.example.com"},
+			`invalid identity domain alias "old.example.com", expected old.example.com=new.example.com`),
+		Entry("with an email", []string{"user@old.example.com=example.com"},
+			`invalid identity domain alias "user@old.example.com=example.com", expected old.example.com=new.example.com`),
+		Entry("aliased twice", []string{"old.example.com=example.com", "OLD.example.com=example.org"},
+			`identity domain "OLD.example.com" is aliased more than once`),
+		Entry("aliased to an aliased domain", []string{"old.example.com=legacy.example.com", "legacy.example.com=example.com"},
+			`identity domain "old.example.com" is aliased to "legacy.example.com", which is aliased itself`),
+	)
+})
@@ -58,6 +58,35 @@ func (p xForwardedForClientIPParser) GetRealClientIP(h http.Header) (net.IP, err
 	return ip, nil
 }
 
+// GetForwardedChain returns each of the addresses in the real client IP header
+// in order, starting with the client.
+// It returns nil if there is no parser or the header is not set.
+func GetForwardedChain(p ipapi.RealClientIPParser, h http.Header) []string {
+	parser, ok := p.(*xForwardedForClientIPParser)
+	if !ok {
+		return nil
+	}
+	value := h.Get(parser.header)
+	if value == "" {
+		return nil
+	}
+
+	chain := []string{}
+	for _, hop := range strings.Split(value, ",") {
+		chain = append(chain, strings.TrimSpace(hop))
+	}
+	return chain
+}
+
+// ParseForwardedHop parses an address from a real client IP header, which may
+// include a port
+func ParseForwardedHop(hop string) net.IP {
+	if ipHost, _, err := net.SplitHostPort(hop); err == nil {
+		hop = ipHost
+	}
+	return net.ParseIP(hop)
+}
+
 // GetClientIP obtains the perceived end-user IP address from headers if p != nil else from req.RemoteAddr.
 func GetClientIP(p ipapi.RealClientIPParser, req *http.Request) (net.IP, error) {
 	if p != nil {
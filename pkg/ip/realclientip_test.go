@@ -175,3 +175,19 @@ func TestGetClientString(t *testing.T) {
 		assert.Equal(t, test.expectedClientFull, clientFull)
 	}
 }
+
+func TestGetForwardedChain(t *testing.T) {
+	p, err := GetRealClientIPParser("X-Forwarded-For")
+	assert.Nil(t, err)
+
+	h := http.Header{}
+	assert.Nil(t, GetForwardedChain(p, h))
+	assert.Nil(t, GetForwardedChain(nil, h))
+
+	h.Set("X-Forwarded-For", "10.0.0.1, 192.168.0.1:8080,[::1]:443")
+	assert.Equal(t, []string{"10.0.0.1", "192.168.0.1:8080", "[::1]:443"}, GetForwardedChain(p, h))
+
+	assert.Equal(t, net.ParseIP("192.168.0.1"), ParseForwardedHop("192.168.0.1:8080"))
+	assert.Equal(t, net.ParseIP("::1"), ParseForwardedHop("[::1]:443"))
+	assert.Nil(t, ParseForwardedHop("unknown"))
+}
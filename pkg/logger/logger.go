@@ -35,6 +35,9 @@ const (
 	AuthFailure AuthStatus = "AuthFailure"
 	// AuthError indicates that an auth attempt has failed due to an error
 	AuthError AuthStatus = "AuthError"
+	// SpoofedClientIP indicates that the real client IP header of a request
+	// appears to have been spoofed
+	SpoofedClientIP AuthStatus = "SpoofedClientIP"
 
 	// Llongfile flag to log full file name and line number: /a/b/c/d.go:23
 	Llongfile = 1 << iota
@@ -63,7 +66,9 @@ type authLogMessageData struct {
 	Client,
 	Host,
 	Protocol,
+	Provider,
 	RequestMethod,
+	Tenant,
 	Timestamp,
 	UserAgent,
 	Username,
@@ -75,11 +80,14 @@ type reqLogMessageData struct {
 	Client,
 	Host,
 	Protocol,
+	Provider,
 	RequestDuration,
 	RequestMethod,
 	RequestURI,
 	ResponseSize,
+	Rule,
 	StatusCode,
+	Tenant,
 	Timestamp,
 	Upstream,
 	UserAgent,
@@ -89,6 +97,25 @@ type reqLogMessageData struct {
 // Returns the apparent "real client IP" as a string.
 type GetClientFunc = func(r *http.Request) string
 
+// AuthEvent is an auth log entry as passed to the AuthObserver
+type AuthEvent struct {
+	Time     time.Time
+	Client   string
+	Host     string
+	Method   string
+	Path     string
+	Username string
+	Status   AuthStatus
+	Message  string
+}
+
+// AuthObserver is called with every auth log entry, whether or not auth
+// logging is enabled. It must not block.
+type AuthObserver = func(AuthEvent)
+
+// IdentityRedactor redacts the username of auth and request log entries
+type IdentityRedactor = func(username string) string
+
 // A Logger represents an active logging object that generates lines of
 // output to an io.Writer passed through a formatter. Each logging
 // operation makes a single call to the Writer's Write method. A Logger
@@ -103,7 +130,11 @@ type Logger struct {
 	authEnabled    bool
 	reqEnabled     bool
 	getClientFunc  GetClientFunc
+	authObserver   AuthObserver
+	redactor       IdentityRedactor
 	excludePaths   map[string]struct{}
+	provider       string
+	tenant         string
 	stdLogTemplate *template.Template
 	authTemplate   *template.Template
 	reqTemplate    *template.Template
@@ -120,6 +151,8 @@ func New(flag int) *Logger {
 		reqEnabled:     true,
 		getClientFunc:  func(r *http.Request) string { return r.RemoteAddr },
 		excludePaths:   nil,
+		provider:       "-",
+		tenant:         "-",
 		stdLogTemplate: template.Must(template.New("std-log").Parse(DefaultStandardLoggingFormat)),
 		authTemplate:   template.Must(template.New("auth-log").Parse(DefaultAuthLoggingFormat)),
 		reqTemplate:    template.Must(template.New("req-log").Parse(DefaultRequestLoggingFormat)),
@@ -180,26 +213,46 @@ func (l *Logger) Output(lvl Level, calldepth int, message string) {
 // log request details. Remaining arguments are handled in the manner of
 // fmt.Sprintf. Writes a final newline to the end of every message.
 func (l *Logger) PrintAuthf(username string, req *http.Request, status AuthStatus, format string, a ...interface{}) {
+	l.mu.Lock()
+	observer := l.authObserver
+	l.mu.Unlock()
+	if observer != nil {
+		observer(AuthEvent{
+			Time:     time.Now(),
+			Client:   l.getClientFunc(req),
+			Host:     requestutil.GetRequestHost(req),
+			Method:   req.Method,
+			Path:     req.URL.Path,
+			Username: username,
+			Status:   status,
+			Message:  fmt.Sprintf(format, a...),
+		})
+	}
+
 	if !l.authEnabled {
 		return
 	}
 
 	now := time.Now()
 
-	if username == "" {
-		username = "-"
-	}
-
 	client := l.getClientFunc(req)
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if username == "" {
+		username = "-"
+	} else if l.redactor != nil {
+		username = l.redactor(username)
+	}
+
 	err := l.authTemplate.Execute(l.writer, authLogMessageData{
 		Client:        client,
 		Host:          requestutil.GetRequestHost(req),
 		Protocol:      req.Proto,
+		Provider:      l.provider,
 		RequestMethod: req.Method,
+		Tenant:        l.tenant,
 		Timestamp:     FormatTimestamp(now),
 		UserAgent:     fmt.Sprintf("%q", req.UserAgent()),
 		Username:      username,
@@ -219,7 +272,9 @@ func (l *Logger) PrintAuthf(username string, req *http.Request, status AuthStatu
 // PrintReq writes request details to the Logger using the http.Request,
 // url, and timestamp of the request.  Writes a final newline to the end
 // of every message.
-func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.URL, ts time.Time, status int, size int) {
+// The rule is the ID of the authorization rule that matched the request, if
+// any.
+func (l *Logger) PrintReq(username, upstream, rule string, req *http.Request, url url.URL, ts time.Time, status int, size int) {
 	if !l.reqEnabled {
 		return
 	}
@@ -238,6 +293,10 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 		upstream = "-"
 	}
 
+	if rule == "" {
+		rule = "-"
+	}
+
 	if url.User != nil && username == "-" {
 		if name := url.User.Username(); name != "" {
 			username = name
@@ -249,15 +308,22 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if username != "-" && l.redactor != nil {
+		username = l.redactor(username)
+	}
+
 	err := l.reqTemplate.Execute(l.writer, reqLogMessageData{
 		Client:          client,
 		Host:            requestutil.GetRequestHost(req),
 		Protocol:        req.Proto,
+		Provider:        l.provider,
 		RequestDuration: fmt.Sprintf("%0.3f", duration),
 		RequestMethod:   req.Method,
 		RequestURI:      fmt.Sprintf("%q", url.RequestURI()),
 		ResponseSize:    fmt.Sprintf("%d", size),
+		Rule:            rule,
 		StatusCode:      fmt.Sprintf("%d", status),
+		Tenant:          l.tenant,
 		Timestamp:       FormatTimestamp(ts),
 		Upstream:        upstream,
 		UserAgent:       fmt.Sprintf("%q", req.UserAgent()),
@@ -349,6 +415,22 @@ func (l *Logger) SetAuthEnabled(e bool) {
 	l.authEnabled = e
 }
 
+// SetAuthObserver sets the function called with every auth log entry, or
+// removes it if nil.
+func (l *Logger) SetAuthObserver(o AuthObserver) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.authObserver = o
+}
+
+// SetIdentityRedactor sets the function that redacts the usernames of auth
+// and request log entries, or removes it if nil.
+func (l *Logger) SetIdentityRedactor(r IdentityRedactor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redactor = r
+}
+
 // SetReqEnabled enabled or disables request logging.
 func (l *Logger) SetReqEnabled(e bool) {
 	l.mu.Lock()
@@ -363,6 +445,22 @@ func (l *Logger) SetGetClientFunc(f GetClientFunc) {
 	l.getClientFunc = f
 }
 
+// SetProvider sets the provider and tenant that authenticate users, which
+// are available to the auth and request logging templates.
+// Empty values are logged as "-".
+func (l *Logger) SetProvider(provider, tenant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if provider == "" {
+		provider = "-"
+	}
+	if tenant == "" {
+		tenant = "-"
+	}
+	l.provider = provider
+	l.tenant = tenant
+}
+
 // SetExcludePaths sets the paths to exclude from logging.
 func (l *Logger) SetExcludePaths(s []string) {
 	l.mu.Lock()
@@ -455,6 +553,12 @@ func SetGetClientFunc(f GetClientFunc) {
 	std.SetGetClientFunc(f)
 }
 
+// SetProvider sets the provider and tenant that authenticate users for the
+// standard logger.
+func SetProvider(provider, tenant string) {
+	std.SetProvider(provider, tenant)
+}
+
 // SetExcludePaths sets the path to exclude from logging, eg: health checks
 func SetExcludePaths(s []string) {
 	std.SetExcludePaths(s)
@@ -466,6 +570,18 @@ func SetStandardTemplate(t string) {
 	std.SetStandardTemplate(t)
 }
 
+// SetAuthObserver sets the function called with every auth log entry of the
+// standard logger, or removes it if nil.
+func SetAuthObserver(o AuthObserver) {
+	std.SetAuthObserver(o)
+}
+
+// SetIdentityRedactor sets the function that redacts the usernames of auth
+// and request log entries of the standard logger, or removes it if nil.
+func SetIdentityRedactor(r IdentityRedactor) {
+	std.SetIdentityRedactor(r)
+}
+
 // SetAuthTemplate sets the template for auth logging for the
 // standard logger.
 func SetAuthTemplate(t string) {
@@ -560,6 +676,6 @@ func PrintAuthf(username string, req *http.Request, status AuthStatus, format st
 }
 
 // PrintReq writes request details to the standard logger.
-func PrintReq(username, upstream string, req *http.Request, url url.URL, ts time.Time, status int, size int) {
-	std.PrintReq(username, upstream, req, url, ts, status, size)
+func PrintReq(username, upstream, rule string, req *http.Request, url url.URL, ts time.Time, status int, size int) {
+	std.PrintReq(username, upstream, rule, req, url, ts, status, size)
 }
@@ -0,0 +1,258 @@
+package memory
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Subsystem is a part of the proxy that holds memory the guard can cap
+type Subsystem string
+
+const (
+	// AuthorizationResultCache caches the deny rules matched by requests
+	AuthorizationResultCache Subsystem = "authorization_result_cache"
+
+	// AuthorizationWebhookCache caches the decisions of deny webhooks
+	AuthorizationWebhookCache Subsystem = "authorization_webhook_cache"
+
+	// SessionDegradedCache keeps the sessions served while the session
+	// store is degraded
+	SessionDegradedCache Subsystem = "session_degraded_cache"
+
+	// SessionWriteBatch queues deferred session writes
+	SessionWriteBatch Subsystem = "session_write_batch"
+
+	// ResponseBody buffers the bodies of responses to requests made by the
+	// proxy, such as to the provider
+	ResponseBody Subsystem = "response_body"
+)
+
+// Subsystems are all the subsystems the guard caps
+var Subsystems = []Subsystem{
+	AuthorizationResultCache,
+	AuthorizationWebhookCache,
+	SessionDegradedCache,
+	SessionWriteBatch,
+	ResponseBody,
+}
+
+const (
+	// sampleInterval is how often the memory in use is checked against the
+	// limit
+	sampleInterval = 2 * time.Second
+
+	// pressureGCPercent is the GOGC percentage while the memory in use is
+	// over the limit, so that garbage is collected more often
+	pressureGCPercent = 25
+
+	// recoveryFraction is the fraction of the limit, in tenths, the memory in
+	// use must fall below to leave the pressure state, so that the guard does
+	// not flap around the limit
+	recoveryFraction = 9
+)
+
+// Guard keeps the memory in use by the proxy under a soft limit. Over the
+// limit, garbage is collected more aggressively and the subsystems that ask
+// the guard stop growing their caches and queues, so that the proxy degrades
+// predictably instead of being killed for running out of memory.
+type Guard struct {
+	limit    uint64
+	inUse    uint64
+	pressure int32
+	episodes uint64
+
+	rejections map[Subsystem]*uint64
+
+	// gcPercent is the GOGC percentage restored once the pressure is over
+	gcPercent int
+
+	readMemory   func() uint64
+	setGCPercent func(int) int
+	freeOSMemory func()
+}
+
+// NewGuard constructs a Guard for the soft limit in bytes. A limit of 0
+// disables the guard.
+func NewGuard(limit uint64) *Guard {
+	g := &Guard{
+		limit:        limit,
+		rejections:   make(map[Subsystem]*uint64, len(Subsystems)),
+		readMemory:   readMemory,
+		setGCPercent: debug.SetGCPercent,
+		freeOSMemory: debug.FreeOSMemory,
+	}
+	for _, subsystem := range Subsystems {
+		g.rejections[subsystem] = new(uint64)
+	}
+	return g
+}
+
+// readMemory returns the memory obtained from the OS that has not been
+// returned to it, which is what counts towards a container memory limit
+func readMemory() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys - stats.HeapReleased
+}
+
+// Run checks the memory in use against the limit until stop is closed
+func (g *Guard) Run(stop <-chan struct{}) {
+	if g.limit == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		g.check(g.readMemory())
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check enters the pressure state when the memory in use reaches the limit,
+// and leaves it once the memory in use has fallen back below the recovery
+// fraction of the limit
+func (g *Guard) check(inUse uint64) {
+	atomic.StoreUint64(&g.inUse, inUse)
+	switch {
+	case !g.UnderPressure() && inUse >= g.limit:
+		atomic.StoreInt32(&g.pressure, 1)
+		atomic.AddUint64(&g.episodes, 1)
+		g.gcPercent = g.setGCPercent(pressureGCPercent)
+		g.freeOSMemory()
+		logger.Errorf("WARNING: The memory in use (%d MiB) reached the memory limit (%d MiB). Caches and queues stop growing until it falls below %d MiB.", inUse>>20, g.limit>>20, g.limit/10*recoveryFraction>>20)
+	case g.UnderPressure() && inUse < g.limit/10*recoveryFraction:
+		atomic.StoreInt32(&g.pressure, 0)
+		g.setGCPercent(g.gcPercent)
+		logger.Printf("The memory in use (%d MiB) is back under the memory limit (%d MiB)", inUse>>20, g.limit>>20)
+	}
+}
+
+// UnderPressure checks whether the memory in use is over the limit
+func (g *Guard) UnderPressure() bool {
+	return atomic.LoadInt32(&g.pressure) == 1
+}
+
+// Allow checks whether the subsystem may grow, counting a rejection if the
+// memory in use is over the limit
+func (g *Guard) Allow(subsystem Subsystem) bool {
+	if !g.UnderPressure() {
+		return true
+	}
+	g.Reject(subsystem)
+	return false
+}
+
+// Reject counts an allocation the subsystem refused to make
+func (g *Guard) Reject(subsystem Subsystem) {
+	if counter, ok := g.rejections[subsystem]; ok {
+		atomic.AddUint64(counter, 1)
+	}
+}
+
+// Descriptions of the guard metrics
+var (
+	limitDesc = prometheus.NewDesc(
+		"oauth2_proxy_memory_limit_bytes",
+		"The soft memory limit, or 0 if the memory guard is disabled.",
+		nil, nil,
+	)
+	inUseDesc = prometheus.NewDesc(
+		"oauth2_proxy_memory_in_use_bytes",
+		"The memory obtained from the OS and not returned to it, when last checked.",
+		nil, nil,
+	)
+	pressureDesc = prometheus.NewDesc(
+		"oauth2_proxy_memory_pressure",
+		"Whether the memory in use is over the soft memory limit.",
+		nil, nil,
+	)
+	episodesDesc = prometheus.NewDesc(
+		"oauth2_proxy_memory_pressure_episodes_total",
+		"The number of times the memory in use reached the soft memory limit.",
+		nil, nil,
+	)
+	rejectionsDesc = prometheus.NewDesc(
+		"oauth2_proxy_memory_rejections_total",
+		"The number of allocations refused to keep the memory in use bounded, by subsystem.",
+		[]string{"subsystem"}, nil,
+	)
+)
+
+// Ensure Guard implements the interface
+var _ prometheus.Collector = &Guard{}
+
+// Describe sends the descriptions of the guard metrics
+func (g *Guard) Describe(ch chan<- *prometheus.Desc) {
+	ch <- limitDesc
+	ch <- inUseDesc
+	ch <- pressureDesc
+	ch <- episodesDesc
+	ch <- rejectionsDesc
+}
+
+// Collect sends the memory limit, the memory in use, the pressure state and
+// the rejections by subsystem
+func (g *Guard) Collect(ch chan<- prometheus.Metric) {
+	pressure := 0.0
+	if g.UnderPressure() {
+		pressure = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(limitDesc, prometheus.GaugeValue, float64(g.limit))
+	ch <- prometheus.MustNewConstMetric(inUseDesc, prometheus.GaugeValue, float64(atomic.LoadUint64(&g.inUse)))
+	ch <- prometheus.MustNewConstMetric(pressureDesc, prometheus.GaugeValue, pressure)
+	ch <- prometheus.MustNewConstMetric(episodesDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&g.episodes)))
+	for _, subsystem := range Subsystems {
+		ch <- prometheus.MustNewConstMetric(rejectionsDesc, prometheus.CounterValue,
+			float64(atomic.LoadUint64(g.rejections[subsystem])), string(subsystem))
+	}
+}
+
+// defaultGuard guards the memory of the proxy. It is disabled until Start is
+// called.
+var defaultGuard = NewGuard(0)
+
+// Start enables the default guard with the soft limit in bytes, and checks
+// the memory in use in the background
+func Start(limit uint64) {
+	defaultGuard = NewGuard(limit)
+	go defaultGuard.Run(nil)
+}
+
+// Allow checks whether the subsystem may grow under the default guard
+func Allow(subsystem Subsystem) bool {
+	return defaultGuard.Allow(subsystem)
+}
+
+// Reject counts an allocation the subsystem refused to make with the default
+// guard
+func Reject(subsystem Subsystem) {
+	defaultGuard.Reject(subsystem)
+}
+
+// defaultGuardCollector collects the metrics of the default guard, which is
+// replaced when it is started
+type defaultGuardCollector struct{}
+
+func (defaultGuardCollector) Describe(ch chan<- *prometheus.Desc) {
+	defaultGuard.Describe(ch)
+}
+
+func (defaultGuardCollector) Collect(ch chan<- prometheus.Metric) {
+	defaultGuard.Collect(ch)
+}
+
+// MetricsCollector returns the collector of the metrics of the default guard
+func MetricsCollector() prometheus.Collector {
+	return defaultGuardCollector{}
+}
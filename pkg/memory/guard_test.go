@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Guard", func() {
+	const limit = 100 << 20
+
+	var guard *Guard
+	var gcPercent int
+	var freed int
+
+	BeforeEach(func() {
+		gcPercent = 100
+		freed = 0
+		guard = NewGuard(limit)
+		guard.setGCPercent = func(percent int) int {
+			previous := gcPercent
+			gcPercent = percent
+			return previous
+		}
+		guard.freeOSMemory = func() { freed++ }
+	})
+
+	It("allows subsystems to grow under the limit", func() {
+		guard.check(limit - 1)
+		Expect(guard.UnderPressure()).To(BeFalse())
+		Expect(guard.Allow(AuthorizationResultCache)).To(BeTrue())
+		Expect(gcPercent).To(Equal(100))
+	})
+
+	It("rejects growth over the limit until the memory in use recovers", func() {
+		guard.check(limit)
+		Expect(guard.UnderPressure()).To(BeTrue())
+		Expect(guard.Allow(AuthorizationResultCache)).To(BeFalse())
+		Expect(gcPercent).To(Equal(pressureGCPercent))
+		Expect(freed).To(Equal(1))
+
+		// Still within the recovery margin
+		guard.check(limit - 1)
+		Expect(guard.UnderPressure()).To(BeTrue())
+		Expect(freed).To(Equal(1))
+
+		guard.check(limit / 2)
+		Expect(guard.UnderPressure()).To(BeFalse())
+		Expect(guard.Allow(AuthorizationResultCache)).To(BeTrue())
+		Expect(gcPercent).To(Equal(100))
+	})
+
+	It("collects the metrics", func() {
+		guard.check(limit)
+		guard.Allow(SessionWriteBatch)
+		guard.Reject(ResponseBody)
+		guard.Reject(ResponseBody)
+
+		Expect(testutil.CollectAndCompare(guard, strings.NewReader(`
+# HELP oauth2_proxy_memory_limit_bytes The soft memory limit, or 0 if the memory guard is disabled.
+# TYPE oauth2_proxy_memory_limit_bytes gauge
+oauth2_proxy_memory_limit_bytes 1.048576e+08
+# HELP oauth2_proxy_memory_in_use_bytes The memory obtained from the OS and not returned to it, when last checked.
+# TYPE oauth2_proxy_memory_in_use_bytes gauge
+oauth2_proxy_memory_in_use_bytes 1.048576e+08
+# HELP oauth2_proxy_memory_pressure Whether the memory in use is over the soft memory limit.
+# TYPE oauth2_proxy_memory_pressure gauge
+oauth2_proxy_memory_pressure 1
+# HELP oauth2_proxy_memory_pressure_episodes_total The number of times the memory in use reached the soft memory limit.
+# TYPE oauth2_proxy_memory_pressure_episodes_total counter
+oauth2_proxy_memory_pressure_episodes_total 1
+# HELP oauth2_proxy_memory_rejections_total The number of allocations refused to keep the memory in use bounded, by subsystem.
+# TYPE oauth2_proxy_memory_rejections_total counter
+oauth2_proxy_memory_rejections_total{subsystem="authorization_result_cache"} 0
+oauth2_proxy_memory_rejections_total{subsystem="authorization_webhook_cache"} 0
+oauth2_proxy_memory_rejections_total{subsystem="response_body"} 2
+oauth2_proxy_memory_rejections_total{subsystem="session_degraded_cache"} 0
+oauth2_proxy_memory_rejections_total{subsystem="session_write_batch"} 1
+`))).To(Succeed())
+	})
+
+	It("is disabled without a limit", func() {
+		disabled := NewGuard(0)
+		stop := make(chan struct{})
+		close(stop)
+		disabled.Run(stop)
+		Expect(disabled.Allow(SessionDegradedCache)).To(BeTrue())
+	})
+})
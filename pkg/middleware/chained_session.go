@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/justinas/alice"
+	"github.com/mbland/hmacauth"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
+)
+
+// chainedIdentityDateWindow is how far the signed Date of a request with
+// identity headers may be from now
+const chainedIdentityDateWindow = time.Minute
+
+// NewChainedSessionLoader creates a session loader for requests proxied by
+// another oauth2-proxy in front of this one, so that users are not asked to
+// log in again.
+// With the jwt format the identity is read from the signed identity JWT,
+// whose audience must be the given audience, and which is only accepted for
+// the max lifetime after it was issued, and with the headers format
+// from the X-Forwarded-User, X-Forwarded-Email and X-Forwarded-Preferred-User
+// headers, verified with the GAP-Signature and a recent signed Date.
+// The signature data is the signature key of the proxy in front.
+func NewChainedSessionLoader(format options.IdentityFormat, sigData *options.SignatureData, audience string, maxLifetime time.Duration) alice.Constructor {
+	c := &chainedSessionLoader{
+		format:      format,
+		key:         []byte(sigData.Key),
+		audience:    audience,
+		maxLifetime: maxLifetime,
+		auth:        hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), upstream.SignatureHeader, upstream.SignatureHeaders),
+		now:         time.Now,
+	}
+	return c.loadSession
+}
+
+// chainedSessionLoader loads sessions from the identity passed on by another
+// oauth2-proxy
+type chainedSessionLoader struct {
+	format      options.IdentityFormat
+	key         []byte
+	audience    string
+	maxLifetime time.Duration
+	auth        hmacauth.HmacAuth
+	now         func() time.Time
+}
+
+// loadSession attempts to load a session from the identity in the request.
+// If there is no identity, or it cannot be verified, no session will be
+// loaded and the request will be passed to the next handler.
+// If a session was loaded by a previous handler, it will not be replaced.
+func (c *chainedSessionLoader) loadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middlewareapi.GetRequestScope(req)
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		if scope.Session != nil {
+			// The session was already loaded, pass to the next handler
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		var session *sessionsapi.SessionState
+		var err error
+		if c.format == options.IdentityFormatHeaders {
+			session, err = c.getHeadersSession(req)
+		} else {
+			session, err = c.getJWTSession(req)
+		}
+		if err != nil {
+			logger.Errorf("Error retrieving session from chained oauth2-proxy identity: %v", err)
+		}
+		if session != nil {
+			logger.PrintAuthf(session.User, req, logger.AuthSuccess, "Authenticated via chained oauth2-proxy identity")
+		}
+
+		// Add the session to the scope if it was found
+		scope.Session = session
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// getJWTSession loads a session from the identity JWT, which must be signed
+// with the signature key for the audience.
+// The JWT must have an expiry and an issue time, and is only accepted for the
+// max lifetime after it was issued, so that a JWT leaked from an upstream
+// cannot be replayed for as long as the session it was signed for.
+func (c *chainedSessionLoader) getJWTSession(req *http.Request) (*sessionsapi.SessionState, error) {
+	token := req.Header.Get(upstream.IdentityHeader)
+	if token == "" {
+		// No identity provided, so don't attempt to load a session
+		return nil, nil
+	}
+
+	claims := &upstream.IdentityClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+	_, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return c.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity JWT: %v", err)
+	}
+	if !claims.VerifyAudience(c.audience, true) {
+		return nil, fmt.Errorf("identity JWT audience %q is not %q", claims.Audience, c.audience)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("identity JWT has no subject")
+	}
+
+	now := c.now()
+	if !claims.VerifyExpiresAt(now.Unix(), true) {
+		return nil, errors.New("identity JWT has no expiry or has expired")
+	}
+	if claims.IssuedAt == 0 {
+		return nil, errors.New("identity JWT has no issue time")
+	}
+	issued := time.Unix(claims.IssuedAt, 0)
+	if now.Sub(issued) > c.maxLifetime {
+		return nil, fmt.Errorf("identity JWT was issued more than %s ago", c.maxLifetime)
+	}
+
+	expires := time.Unix(claims.ExpiresAt, 0)
+	if latest := issued.Add(c.maxLifetime); expires.After(latest) {
+		expires = latest
+	}
+	return &sessionsapi.SessionState{
+		CreatedAt:         &now,
+		ExpiresOn:         &expires,
+		User:              claims.Subject,
+		Email:             claims.Email,
+		PreferredUsername: claims.PreferredUsername,
+		Groups:            claims.Groups,
+	}, nil
+}
+
+// getHeadersSession loads a session from the identity headers, which must be
+// covered by a valid GAP-Signature with a recent Date.
+// Groups are not signed, so sessions loaded from headers have no groups.
+func (c *chainedSessionLoader) getHeadersSession(req *http.Request) (*sessionsapi.SessionState, error) {
+	if req.Header.Get(upstream.SignatureHeader) == "" {
+		// No signature provided, so don't attempt to load a session
+		return nil, nil
+	}
+
+	result, _, _ := c.auth.AuthenticateRequest(req)
+	if result != hmacauth.ResultMatch {
+		return nil, fmt.Errorf("invalid %s: %s", upstream.SignatureHeader, result)
+	}
+	if err := c.verifyDate(req); err != nil {
+		return nil, err
+	}
+
+	user := req.Header.Get("X-Forwarded-User")
+	if user == "" {
+		return nil, errors.New("signed request has no X-Forwarded-User header")
+	}
+
+	created := c.now()
+	return &sessionsapi.SessionState{
+		CreatedAt:         &created,
+		User:              user,
+		Email:             req.Header.Get("X-Forwarded-Email"),
+		PreferredUsername: req.Header.Get("X-Forwarded-Preferred-User"),
+	}, nil
+}
+
+// verifyDate checks that the signed Date header of the request is within the
+// chainedIdentityDateWindow of now, so that signed requests cannot be
+// replayed later
+func (c *chainedSessionLoader) verifyDate(req *http.Request) error {
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return errors.New("signed request has no valid Date header")
+	}
+	if age := c.now().Sub(date); age > chainedIdentityDateWindow || age < -chainedIdentityDateWindow {
+		return fmt.Errorf("signed request Date %q is not within %s of now", req.Header.Get("Date"), chainedIdentityDateWindow)
+	}
+	return nil
+}
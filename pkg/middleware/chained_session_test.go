@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mbland/hmacauth"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Chained Session Suite", func() {
+	const chainKey = "chain-secret"
+	const audience = "http://proxy.internal:4180"
+	const maxLifetime = time.Minute
+
+	sigData := &options.SignatureData{Hash: crypto.SHA256, Key: chainKey}
+	now := time.Unix(1600000000, 0)
+
+	loadSession := func(format options.IdentityFormat, req *http.Request, existing *sessionsapi.SessionState) *sessionsapi.SessionState {
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: existing})
+
+		loader := &chainedSessionLoader{
+			format:      format,
+			key:         []byte(sigData.Key),
+			audience:    audience,
+			maxLifetime: maxLifetime,
+			auth:        hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), upstream.SignatureHeader, upstream.SignatureHeaders),
+			now:         func() time.Time { return now },
+		}
+
+		var gotSession *sessionsapi.SessionState
+		handler := loader.loadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotSession = middlewareapi.GetRequestScope(r).Session
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return gotSession
+	}
+
+	Context("with the jwt format", func() {
+		sign := func(key string, claims *upstream.IdentityClaims) string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+			Expect(err).ToNot(HaveOccurred())
+			return token
+		}
+
+		newRequest := func(token string) *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			if token != "" {
+				req.Header.Set(upstream.IdentityHeader, token)
+			}
+			return req
+		}
+
+		claims := func(expiresAt time.Time) *upstream.IdentityClaims {
+			return &upstream.IdentityClaims{
+				Email:             "user@example.com",
+				PreferredUsername: "preferred",
+				Groups:            []string{"a", "b"},
+				StandardClaims: jwt.StandardClaims{
+					Subject:   "user",
+					Audience:  audience,
+					IssuedAt:  now.Add(-10 * time.Second).Unix(),
+					ExpiresAt: expiresAt.Unix(),
+				},
+			}
+		}
+
+		It("loads a session from a signed identity", func() {
+			session := loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, claims(time.Now().Add(time.Hour)))), nil)
+
+			// The session expires with the max lifetime of the identity
+			expiresAt := now.Add(maxLifetime - 10*time.Second)
+			Expect(session).To(Equal(&sessionsapi.SessionState{
+				CreatedAt:         &now,
+				ExpiresOn:         &expiresAt,
+				User:              "user",
+				Email:             "user@example.com",
+				PreferredUsername: "preferred",
+				Groups:            []string{"a", "b"},
+			}))
+		})
+
+		It("does not load a session without an identity", func() {
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(""), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an identity signed with another key", func() {
+			token := sign("other-secret", claims(time.Now().Add(time.Hour)))
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(token), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an expired identity", func() {
+			token := sign(chainKey, claims(time.Now().Add(-time.Minute)))
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(token), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an identity without an expiry", func() {
+			c := claims(time.Now())
+			c.ExpiresAt = 0
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, c)), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an identity without an issue time", func() {
+			c := claims(time.Now().Add(time.Hour))
+			c.IssuedAt = 0
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, c)), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an identity issued longer ago than its max lifetime", func() {
+			c := claims(time.Now().Add(time.Hour))
+			c.IssuedAt = now.Add(-2 * maxLifetime).Unix()
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, c)), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an identity for another audience", func() {
+			c := claims(time.Now().Add(time.Hour))
+			c.Audience = "http://other.internal:4180"
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, c)), nil)).To(BeNil())
+
+			c.Audience = ""
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, c)), nil)).To(BeNil())
+		})
+
+		It("does not load a session from an identity that is not valid yet", func() {
+			c := claims(time.Now().Add(time.Hour))
+			c.NotBefore = time.Now().Add(time.Minute).Unix()
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(sign(chainKey, c)), nil)).To(BeNil())
+		})
+
+		It("does not replace an existing session", func() {
+			existing := &sessionsapi.SessionState{User: "existing"}
+			token := sign(chainKey, claims(time.Now().Add(time.Hour)))
+			Expect(loadSession(options.IdentityFormatJWT, newRequest(token), existing)).To(Equal(existing))
+		})
+	})
+
+	Context("with the headers format", func() {
+		newSignedRequest := func(key string, date time.Time) *http.Request {
+			req := httptest.NewRequest("GET", "/app", nil)
+			if !date.IsZero() {
+				req.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+			}
+			req.Header.Set("X-Forwarded-User", "user")
+			req.Header.Set("X-Forwarded-Email", "user@example.com")
+			req.Header.Set("X-Forwarded-Preferred-User", "preferred")
+			if key != "" {
+				hmacauth.NewHmacAuth(crypto.SHA256, []byte(key), upstream.SignatureHeader, upstream.SignatureHeaders).SignRequest(req)
+			}
+			return req
+		}
+		newRequest := func(key string) *http.Request {
+			return newSignedRequest(key, now.Add(-10*time.Second))
+		}
+
+		It("loads a session from signed headers", func() {
+			Expect(loadSession(options.IdentityFormatHeaders, newRequest(chainKey), nil)).To(Equal(&sessionsapi.SessionState{
+				CreatedAt:         &now,
+				User:              "user",
+				Email:             "user@example.com",
+				PreferredUsername: "preferred",
+			}))
+		})
+
+		It("does not load a session from unsigned headers", func() {
+			Expect(loadSession(options.IdentityFormatHeaders, newRequest(""), nil)).To(BeNil())
+		})
+
+		It("does not load a session from headers signed with another key", func() {
+			Expect(loadSession(options.IdentityFormatHeaders, newRequest("other-secret"), nil)).To(BeNil())
+		})
+
+		It("does not load a session when a signed header is changed", func() {
+			req := newRequest(chainKey)
+			req.Header.Set("X-Forwarded-User", "admin")
+			Expect(loadSession(options.IdentityFormatHeaders, req, nil)).To(BeNil())
+		})
+
+		It("does not load a session from headers signed without a Date", func() {
+			Expect(loadSession(options.IdentityFormatHeaders, newSignedRequest(chainKey, time.Time{}), nil)).To(BeNil())
+		})
+
+		It("does not load a session from headers signed too long ago", func() {
+			Expect(loadSession(options.IdentityFormatHeaders, newSignedRequest(chainKey, now.Add(-2*time.Minute)), nil)).To(BeNil())
+			Expect(loadSession(options.IdentityFormatHeaders, newSignedRequest(chainKey, now.Add(2*time.Minute)), nil)).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+)
+
+// GetClientFingerprint derives a fingerprint of the client that made the
+// request from the given components, in order.
+// The value is a hash so that sessions do not disclose the client IP or
+// User-Agent. A client IP that cannot be determined is fingerprinted as
+// empty, so that the session cannot be matched by a client with a known IP.
+func GetClientFingerprint(req *http.Request, realClientIPParser ipapi.RealClientIPParser, components []string) string {
+	hash := sha256.New()
+	for _, component := range components {
+		var value string
+		switch component {
+		case options.FingerprintClientIP:
+			if clientIP, err := ip.GetClientIP(realClientIPParser, req); err == nil && clientIP != nil {
+				value = clientIP.String()
+			}
+		case options.FingerprintUserAgent:
+			value = req.UserAgent()
+		}
+		fmt.Fprintf(hash, "%s=%s\n", component, value)
+	}
+	return base64.RawURLEncoding.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fingerprint Suite", func() {
+	Context("GetClientFingerprint", func() {
+		fingerprint := func(remoteAddr, userAgent string, components ...string) string {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("User-Agent", userAgent)
+			return GetClientFingerprint(req, nil, components)
+		}
+
+		It("returns the same fingerprint for the same client", func() {
+			first := fingerprint("10.0.0.1:1234", "curl/7.64.1", options.FingerprintClientIP, options.FingerprintUserAgent)
+			Expect(first).ToNot(BeEmpty())
+			Expect(fingerprint("10.0.0.1:5678", "curl/7.64.1", options.FingerprintClientIP, options.FingerprintUserAgent)).To(Equal(first))
+		})
+
+		It("returns a different fingerprint for a different client IP", func() {
+			Expect(fingerprint("10.0.0.1:1234", "curl/7.64.1", options.FingerprintClientIP)).
+				ToNot(Equal(fingerprint("10.0.0.2:1234", "curl/7.64.1", options.FingerprintClientIP)))
+		})
+
+		It("returns a different fingerprint for a different User-Agent", func() {
+			Expect(fingerprint("10.0.0.1:1234", "curl/7.64.1", options.FingerprintUserAgent)).
+				ToNot(Equal(fingerprint("10.0.0.1:1234", "Mozilla/5.0", options.FingerprintUserAgent)))
+		})
+
+		It("ignores the components that are not configured", func() {
+			Expect(fingerprint("10.0.0.1:1234", "curl/7.64.1", options.FingerprintUserAgent)).
+				To(Equal(fingerprint("10.0.0.2:1234", "curl/7.64.1", options.FingerprintUserAgent)))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+// NewForwardedClientIP creates a new middleware that passes the real client IP
+// to upstreams.
+// When setRealIP is set, the X-Real-IP header is overwritten with the real
+// client IP. The forwardedFor mode determines whether the X-Forwarded-For
+// header is appended to by the upstream reverse proxy, replaced with the real
+// client IP, or replaced by an RFC 7239 Forwarded header.
+func NewForwardedClientIP(realClientIPParser ipapi.RealClientIPParser, setRealIP bool, forwardedFor string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		if !setRealIP && forwardedFor == options.ForwardedForAppend {
+			return next
+		}
+		return forwardClientIP(realClientIPParser, setRealIP, forwardedFor, next)
+	}
+}
+
+func forwardClientIP(realClientIPParser ipapi.RealClientIPParser, setRealIP bool, forwardedFor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		clientIP, err := ip.GetClientIP(realClientIPParser, req)
+		if err == nil && clientIP == nil {
+			// The real client IP header is not set, so the client is connected
+			// directly
+			clientIP, err = ip.GetClientIP(nil, req)
+		}
+		if err != nil {
+			logger.Errorf("Error obtaining real client IP to pass to upstream: %v", err)
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		if setRealIP {
+			req.Header.Set("X-Real-IP", clientIP.String())
+		}
+
+		switch forwardedFor {
+		case options.ForwardedForReplace:
+			// The upstream reverse proxy sets X-Forwarded-For to the address of
+			// the request when there is no existing header
+			req.Header.Del("X-Forwarded-For")
+			req = req.WithContext(req.Context())
+			req.RemoteAddr = net.JoinHostPort(clientIP.String(), remotePort(req))
+		case options.ForwardedForRFC7239:
+			// A nil value stops the upstream reverse proxy from setting the
+			// header
+			req.Header["X-Forwarded-For"] = nil
+			req.Header.Set("Forwarded", forwardedElement(req, clientIP))
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// remotePort returns the port of the connecting client, or 0 if it is unknown
+func remotePort(req *http.Request) string {
+	if _, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return port
+	}
+	return "0"
+}
+
+// forwardedElement builds an RFC 7239 Forwarded header element for the client
+// IP, and the host and protocol requested by the client
+func forwardedElement(req *http.Request, clientIP net.IP) string {
+	forwardedFor := clientIP.String()
+	if clientIP.To4() == nil {
+		forwardedFor = fmt.Sprintf("[%s]", forwardedFor)
+	}
+	proto := requestutil.GetRequestProto(req)
+	if proto == "" {
+		proto = "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+	}
+	host := requestutil.GetRequestHost(req)
+	return fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedValue(forwardedFor), forwardedValue(host), forwardedValue(proto))
+}
+
+// forwardedValue quotes a Forwarded header value unless it is a token
+func forwardedValue(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return fmt.Sprintf("%q", value)
+		}
+	}
+	return value
+}
+
+// isTokenChar checks whether the character may appear in an RFC 7230 token
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	default:
+		return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+	}
+}
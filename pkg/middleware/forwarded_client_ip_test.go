@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Forwarded Client IP Suite", func() {
+	type forwardedClientIPTableInput struct {
+		reverseProxy      bool
+		setRealIP         bool
+		forwardedFor      string
+		requestHeaders    map[string]string
+		expectedRealIP    string
+		expectedForwarded string
+		expectedXFF       []string
+	}
+
+	DescribeTable("when proxying a request",
+		func(in *forwardedClientIPTableInput) {
+			var upstreamHeaders http.Header
+			upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				upstreamHeaders = req.Header
+			}))
+			defer upstream.Close()
+			u, err := url.Parse(upstream.URL)
+			Expect(err).ToNot(HaveOccurred())
+			proxy := httputil.NewSingleHostReverseProxy(u)
+
+			var realClientIPParser ipapi.RealClientIPParser
+			if in.reverseProxy {
+				realClientIPParser, err = ip.GetRealClientIPParser("X-Forwarded-For")
+				Expect(err).ToNot(HaveOccurred())
+			}
+			handler := NewForwardedClientIP(realClientIPParser, in.setRealIP, in.forwardedFor)(proxy)
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = "10.0.0.2:43670"
+			for name, value := range in.requestHeaders {
+				req.Header.Set(name, value)
+			}
+
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			Expect(rw.Code).To(Equal(http.StatusOK))
+
+			Expect(upstreamHeaders.Get("X-Real-IP")).To(Equal(in.expectedRealIP))
+			Expect(upstreamHeaders.Get("Forwarded")).To(Equal(in.expectedForwarded))
+			Expect(upstreamHeaders.Values("X-Forwarded-For")).To(Equal(in.expectedXFF))
+		},
+		Entry("appends to X-Forwarded-For by default", &forwardedClientIPTableInput{
+			forwardedFor: options.ForwardedForAppend,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "203.0.113.7",
+				"X-Real-IP":       "203.0.113.8",
+			},
+			expectedRealIP: "203.0.113.8",
+			expectedXFF:    []string{"203.0.113.7, 10.0.0.2"},
+		}),
+		Entry("overwrites X-Real-IP with the remote address", &forwardedClientIPTableInput{
+			setRealIP:    true,
+			forwardedFor: options.ForwardedForAppend,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "203.0.113.7",
+				"X-Real-IP":       "203.0.113.8",
+			},
+			expectedRealIP: "10.0.0.2",
+			expectedXFF:    []string{"203.0.113.7, 10.0.0.2"},
+		}),
+		Entry("overwrites X-Real-IP with the real client IP", &forwardedClientIPTableInput{
+			reverseProxy: true,
+			setRealIP:    true,
+			forwardedFor: options.ForwardedForAppend,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "203.0.113.7",
+				"X-Real-IP":       "203.0.113.8",
+			},
+			expectedRealIP: "203.0.113.7",
+			expectedXFF:    []string{"203.0.113.7, 10.0.0.2"},
+		}),
+		Entry("replaces X-Forwarded-For with the real client IP", &forwardedClientIPTableInput{
+			reverseProxy: true,
+			forwardedFor: options.ForwardedForReplace,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "203.0.113.7, 198.51.100.1",
+			},
+			expectedXFF: []string{"203.0.113.7"},
+		}),
+		Entry("replaces X-Forwarded-For with the remote address", &forwardedClientIPTableInput{
+			forwardedFor: options.ForwardedForReplace,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "203.0.113.7",
+			},
+			expectedXFF: []string{"10.0.0.2"},
+		}),
+		Entry("sends a Forwarded header instead of X-Forwarded-For", &forwardedClientIPTableInput{
+			reverseProxy: true,
+			forwardedFor: options.ForwardedForRFC7239,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "203.0.113.7",
+				"Forwarded":       "for=198.51.100.1",
+			},
+			expectedForwarded: "for=203.0.113.7;host=example.com;proto=http",
+			expectedXFF:       nil,
+		}),
+		Entry("quotes IPv6 addresses in the Forwarded header", &forwardedClientIPTableInput{
+			reverseProxy: true,
+			forwardedFor: options.ForwardedForRFC7239,
+			requestHeaders: map[string]string{
+				"X-Forwarded-For": "2001:db8::1",
+			},
+			expectedForwarded: `for="[2001:db8::1]";host=example.com;proto=http`,
+			expectedXFF:       nil,
+		}),
+	)
+})
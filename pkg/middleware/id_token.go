@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// NewIDTokenMinimizer replaces the ID token of the session passed to upstreams
+// with a JWT containing only the allowed claims of the original token, signed
+// with the signing key.
+// The "exp" claim is always kept so that the minimized token expires with the
+// original. Tokens that cannot be minimized are removed rather than passed on.
+func NewIDTokenMinimizer(claims []string, signingKey []byte) alice.Constructor {
+	allowed := map[string]struct{}{"exp": {}}
+	for _, claim := range claims {
+		allowed[claim] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			scope := middlewareapi.GetRequestScope(req)
+			// If scope is nil, this will panic.
+			// A scope should always be injected before this handler is called.
+			if scope.Session != nil && scope.Session.IDToken != "" {
+				scope.Session = minimizeIDToken(scope.Session, allowed, signingKey)
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// minimizeIDToken returns a copy of the session with a minimized ID token, so
+// that the stored session keeps the original token
+func minimizeIDToken(session *sessionsapi.SessionState, allowed map[string]struct{}, signingKey []byte) *sessionsapi.SessionState {
+	minimized := *session
+	token, err := signMinimizedIDToken(session.IDToken, allowed, signingKey)
+	if err != nil {
+		logger.Errorf("Error minimizing ID token, removing it from the request: %v", err)
+	}
+	minimized.IDToken = token
+	return &minimized
+}
+
+func signMinimizedIDToken(idToken string, allowed map[string]struct{}, signingKey []byte) (string, error) {
+	// The ID token was verified when the session was created
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(idToken, claims); err != nil {
+		return "", fmt.Errorf("error parsing ID token: %v", err)
+	}
+
+	for claim := range claims {
+		if _, ok := allowed[claim]; !ok {
+			delete(claims, claim)
+		}
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey)
+	if err != nil {
+		return "", fmt.Errorf("error signing minimized ID token: %v", err)
+	}
+	return token, nil
+}
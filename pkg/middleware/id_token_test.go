@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/dgrijalva/jwt-go"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ID Token Minimizer Suite", func() {
+	signingKey := []byte("signing-key")
+
+	newIDToken := func() string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":   "123456789",
+			"email": "john.doe@example.com",
+			"name":  "John Doe",
+			"exp":   float64(4102444800),
+		}).SignedString([]byte("provider-key"))
+		Expect(err).ToNot(HaveOccurred())
+		return token
+	}
+
+	serve := func(session *sessionsapi.SessionState) *sessionsapi.SessionState {
+		scope := &middlewareapi.RequestScope{Session: session}
+		req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), scope)
+
+		var gotSession *sessionsapi.SessionState
+		handler := NewIDTokenMinimizer([]string{"sub"}, signingKey)(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			gotSession = middlewareapi.GetRequestScope(req).Session
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return gotSession
+	}
+
+	It("re-signs the ID token with only the allowed claims", func() {
+		session := &sessionsapi.SessionState{IDToken: newIDToken(), Email: "john.doe@example.com"}
+		gotSession := serve(session)
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(gotSession.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+			return signingKey, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims).To(Equal(jwt.MapClaims{
+			"sub": "123456789",
+			"exp": float64(4102444800),
+		}))
+		Expect(gotSession.Email).To(Equal("john.doe@example.com"))
+	})
+
+	It("does not modify the stored session", func() {
+		idToken := newIDToken()
+		session := &sessionsapi.SessionState{IDToken: idToken}
+		gotSession := serve(session)
+
+		Expect(gotSession.IDToken).ToNot(Equal(idToken))
+		Expect(session.IDToken).To(Equal(idToken))
+	})
+
+	It("removes ID tokens that cannot be parsed", func() {
+		gotSession := serve(&sessionsapi.SessionState{IDToken: "not-a-jwt", Email: "john.doe@example.com"})
+		Expect(gotSession.IDToken).To(BeEmpty())
+		Expect(gotSession.Email).To(Equal("john.doe@example.com"))
+	})
+
+	It("passes sessions without an ID token unchanged", func() {
+		session := &sessionsapi.SessionState{Email: "john.doe@example.com"}
+		Expect(serve(session)).To(BeIdenticalTo(session))
+		Expect(serve(nil)).To(BeNil())
+	})
+})
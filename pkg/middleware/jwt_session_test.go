@@ -502,5 +502,65 @@ Nnc3a3lGVWFCNUMxQnNJcnJMTWxka1dFaHluYmI4Ongtb2F1dGgtYmFzaWM=`
 				expectedErr: errors.New("email in id_token (foo@example.com) isn't verified"),
 			}),
 		)
+
+		type groupsTableInput struct {
+			groups         interface{}
+			expectedGroups []string
+			expectedErr    error
+		}
+
+		DescribeTable("when creating a session with a groups claim",
+			func(in groupsTableInput) {
+				verifier := func(ctx context.Context, token string) (*oidc.IDToken, error) {
+					oidcVerifier := oidc.NewVerifier(
+						"https://issuer.example.com",
+						noOpKeySet{},
+						&oidc.Config{ClientID: "asdf1234"},
+					)
+					return oidcVerifier.Verify(ctx, token)
+				}
+
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				Expect(err).ToNot(HaveOccurred())
+
+				claims := jwt.MapClaims{
+					"aud": "asdf1234",
+					"exp": expiresFuture.Unix(),
+					"iss": "https://issuer.example.com",
+					"sub": "123456789",
+				}
+				if in.groups != nil {
+					claims["roles"] = in.groups
+				}
+				rawIDToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+				Expect(err).ToNot(HaveOccurred())
+
+				session, err := middlewareapi.CreateTokenToSessionFuncWithGroups(verifier, "roles")(ctx, rawIDToken)
+				if in.expectedErr != nil {
+					Expect(err).To(MatchError(in.expectedErr))
+					Expect(session).To(BeNil())
+					return
+				}
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.User).To(Equal("123456789"))
+				Expect(session.Groups).To(Equal(in.expectedGroups))
+			},
+			Entry("with a list of groups", groupsTableInput{
+				groups:         []string{"admins", "devs"},
+				expectedGroups: []string{"admins", "devs"},
+			}),
+			Entry("with a single group", groupsTableInput{
+				groups:         "admins",
+				expectedGroups: []string{"admins"},
+			}),
+			Entry("without the groups claim", groupsTableInput{
+				expectedGroups: nil,
+			}),
+			Entry("with an invalid groups claim", groupsTableInput{
+				groups:      map[string]string{"group": "admins"},
+				expectedErr: errors.New(`bearer token claim "roles" must be a string or list of strings`),
+			}),
+		)
 	})
 })
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// OtherRoute is the route label of requests that match no route template
+const OtherRoute = "other"
+
+// metricMethods are the request methods used as the method label. Other
+// methods are labelled "other", so that the label has a bounded number of
+// values.
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// RouteTemplate matches request paths to a route, such as
+// /api/users/{id}. Each segment of the template matches the same segment of
+// the path, and a segment in braces matches any non-empty segment.
+type RouteTemplate struct {
+	template string
+	segments []string
+}
+
+// ParseRouteTemplate parses a route template, which must be an absolute path
+func ParseRouteTemplate(template string) (*RouteTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("route template %q must start with /", template)
+	}
+
+	segments := strings.Split(template[1:], "/")
+	for i, segment := range segments {
+		if !strings.ContainsAny(segment, "{}") {
+			continue
+		}
+		if len(segment) < 3 || !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") ||
+			strings.ContainsAny(segment[1:len(segment)-1], "{}") {
+			return nil, fmt.Errorf("route template %q has an invalid parameter %q: parameters must be whole segments such as {id}", template, segment)
+		}
+		// The name of parameters is only documentation
+		segments[i] = "{}"
+	}
+	return &RouteTemplate{template: template, segments: segments}, nil
+}
+
+// Matches checks whether the path matches the template
+func (t *RouteTemplate) Matches(path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	segments := strings.Split(path[1:], "/")
+	if len(segments) != len(t.segments) {
+		return false
+	}
+	for i, segment := range t.segments {
+		if segment == "{}" {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the template, which is the route label of matching requests
+func (t *RouteTemplate) String() string {
+	return t.template
+}
+
+// RequestMetrics counts the requests served by the proxy, and observes how
+// long they took, by route, method and status code.
+// Requests are labelled with the first route template their path matches
+// instead of the path, so that IDs in paths do not create a time series per
+// ID. Requests that match no template are labelled "other".
+type RequestMetrics struct {
+	routes   []*RouteTemplate
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// Ensure RequestMetrics implements the interface
+var _ prometheus.Collector = &RequestMetrics{}
+
+// NewRequestMetrics constructs the request metrics with the route templates,
+// which are matched in order
+func NewRequestMetrics(templates []string) (*RequestMetrics, error) {
+	routes := make([]*RouteTemplate, 0, len(templates))
+	for _, template := range templates {
+		route, err := ParseRouteTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	return &RequestMetrics{
+		routes: routes,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "oauth2_proxy_requests_total",
+			Help: "The number of requests served, by route template, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "oauth2_proxy_request_duration_seconds",
+			Help:    "How long requests took to serve, by route template, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+	}, nil
+}
+
+// Route returns the route label of the path
+func (m *RequestMetrics) Route(path string) string {
+	for _, route := range m.routes {
+		if route.Matches(path) {
+			return route.template
+		}
+	}
+	return OtherRoute
+}
+
+// Handler records the requests served by the next handler
+func (m *RequestMetrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		method := req.Method
+		if !metricMethods[method] {
+			method = "other"
+		}
+		labels := prometheus.Labels{"route": m.Route(req.URL.Path), "method": method}
+
+		// The code label is set by promhttp, which wraps the response writer
+		// keeping the interfaces it implements, e.g. for websockets
+		promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels), next),
+		).ServeHTTP(rw, req)
+	})
+}
+
+// Describe sends the descriptions of the request metrics
+func (m *RequestMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+// Collect sends the request metrics
+func (m *RequestMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Request Metrics Suite", func() {
+	DescribeTable("ParseRouteTemplate",
+		func(template string, expectedErr string) {
+			_, err := ParseRouteTemplate(template)
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expectedErr))
+			}
+		},
+		Entry("with a static path", "/oauth2/callback", ""),
+		Entry("with a parameter", "/api/users/{id}/groups", ""),
+		Entry("without a leading /", "api/users", `route template "api/users" must start with /`),
+		Entry("with a parameter in a segment", "/api/users/user-{id}",
+			`route template "/api/users/user-{id}" has an invalid parameter "user-{id}": parameters must be whole segments such as {id}`),
+		Entry("with an unnamed parameter", "/api/{}",
+			`route template "/api/{}" has an invalid parameter "{}": parameters must be whole segments such as {id}`),
+	)
+
+	DescribeTable("Route",
+		func(path string, expected string) {
+			metrics, err := NewRequestMetrics([]string{"/api/users/{id}", "/api/users/{id}/groups", "/api/{resource}/{id}", "/"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics.Route(path)).To(Equal(expected))
+		},
+		Entry("with a parameter", "/api/users/42", "/api/users/{id}"),
+		Entry("with a static segment after a parameter", "/api/users/42/groups", "/api/users/{id}/groups"),
+		Entry("with the first matching template", "/api/users/me", "/api/users/{id}"),
+		Entry("with a later template", "/api/teams/7", "/api/{resource}/{id}"),
+		Entry("with an empty parameter", "/api/users/", OtherRoute),
+		Entry("with more segments", "/api/users/42/groups/7", OtherRoute),
+		Entry("with the root", "/", "/"),
+		Entry("with an unknown path", "/static/app.js", OtherRoute),
+	)
+
+	It("counts requests by route template, method and status code", func() {
+		metrics, err := NewRequestMetrics([]string{"/api/users/{id}"})
+		Expect(err).ToNot(HaveOccurred())
+
+		handler := metrics.Handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/missing" {
+				http.NotFound(rw, req)
+				return
+			}
+			rw.WriteHeader(http.StatusAccepted)
+		}))
+		for _, r := range []struct{ method, path string }{
+			{"GET", "/api/users/1"},
+			{"GET", "/api/users/2"},
+			{"POST", "/api/users/3"},
+			{"PROPFIND", "/api/users/4"},
+			{"GET", "/missing"},
+		} {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.path, nil))
+		}
+
+		Expect(testutil.CollectAndCompare(metrics, strings.NewReader(`
+# HELP oauth2_proxy_requests_total The number of requests served, by route template, method and status code.
+# TYPE oauth2_proxy_requests_total counter
+oauth2_proxy_requests_total{code="202",method="GET",route="/api/users/{id}"} 2
+oauth2_proxy_requests_total{code="202",method="POST",route="/api/users/{id}"} 1
+oauth2_proxy_requests_total{code="202",method="other",route="/api/users/{id}"} 1
+oauth2_proxy_requests_total{code="404",method="GET",route="other"} 1
+`), "oauth2_proxy_requests_total")).To(Succeed())
+		Expect(testutil.CollectAndCount(metrics, "oauth2_proxy_request_duration_seconds")).To(Equal(4))
+	})
+})
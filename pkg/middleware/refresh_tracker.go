@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// maxInFlightRefreshes bounds the number of sessions tracked while they are
+// being refreshed.
+// Once reached, further refreshes are not tracked and run concurrently.
+const maxInFlightRefreshes = 10000
+
+const (
+	// refreshLockExpiration is how long a session refresh is locked for at
+	// most, should the instance refreshing it fail to release the lock
+	refreshLockExpiration = 30 * time.Second
+
+	// refreshLockWait is how long requests with an expired session wait for
+	// the refresh of another instance to complete
+	refreshLockWait = 5 * time.Second
+
+	// refreshLockPollInterval is how often a waiting request checks whether
+	// the refresh lock was released
+	refreshLockPollInterval = 100 * time.Millisecond
+)
+
+// errRefreshInProgress is returned when an expired session cannot be loaded
+// because a concurrent request is refreshing it
+var errRefreshInProgress = errors.New("session refresh in progress")
+
+// errRefreshBackedOff is returned when the provider failed to refresh a
+// session that can still be used until the refresh is retried
+var errRefreshBackedOff = errors.New("session refresh backed off")
+
+// inFlightRefreshes tracks the sessions that are currently being refreshed so
+// that concurrent requests with the same session do not each attempt to
+// redeem its refresh token.
+type inFlightRefreshes struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// start marks the session as being refreshed and returns a function to call
+// once the refresh completes.
+// It returns false if the session is already being refreshed by another
+// request.
+func (r *inFlightRefreshes) start(key string) (func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keys == nil {
+		r.keys = make(map[string]struct{})
+	}
+	if _, ok := r.keys[key]; ok {
+		return nil, false
+	}
+	if len(r.keys) >= maxInFlightRefreshes {
+		return func() {}, true
+	}
+
+	r.keys[key] = struct{}{}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.keys, key)
+	}, true
+}
+
+// Obtain marks the session with the key as being refreshed, until the
+// returned function is called. The expiration is not needed as the lock is
+// released when the refresh completes, even if it fails.
+func (r *inFlightRefreshes) Obtain(_ context.Context, key string, _ time.Duration) (func(), error) {
+	finish, ok := r.start(key)
+	if !ok {
+		return nil, sessionsapi.ErrLockNotObtained
+	}
+	return finish, nil
+}
+
+// Peek checks whether the session with the key is being refreshed
+func (r *inFlightRefreshes) Peek(_ context.Context, key string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.keys[key]
+	return ok, nil
+}
+
+// refreshKey identifies a session by a hash of its refresh token.
+// Sessions without a refresh token are not tracked.
+func refreshKey(session *sessionsapi.SessionState) string {
+	if session.RefreshToken == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(session.RefreshToken))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// AnnotationRequestHeaderPrefix is the prefix of the request headers that
+// the annotations of a session are injected as, followed by the key
+const AnnotationRequestHeaderPrefix = "X-Forwarded-Annotation-"
+
+// SessionAnnotationsOptions contains the requirements to construct the
+// session annotations middleware.
+// All options must be provided.
+type SessionAnnotationsOptions struct {
+	// Session storage backend, to save annotated sessions
+	SessionStore sessionsapi.SessionStore
+
+	// The response header upstreams annotate the session with, as key=value
+	Header string
+
+	// The annotation keys upstreams may set
+	Keys []string
+
+	// The largest total size in bytes of the keys and values of the
+	// annotations of a session, or 0 for no limit
+	MaxSize int
+}
+
+// NewSessionAnnotations creates a middleware that injects the annotations of
+// the session as request headers, and saves the annotations set by the
+// upstream response header with the session.
+// Annotation request headers presented by the client are always removed, and
+// the annotation response header is never passed on to the client.
+func NewSessionAnnotations(opts *SessionAnnotationsOptions) alice.Constructor {
+	keys := make(map[string]string, len(opts.Keys))
+	for _, key := range opts.Keys {
+		keys[strings.ToLower(key)] = key
+	}
+
+	a := &sessionAnnotations{
+		store:   opts.SessionStore,
+		header:  http.CanonicalHeaderKey(opts.Header),
+		keys:    keys,
+		maxSize: opts.MaxSize,
+	}
+	return a.annotate
+}
+
+// sessionAnnotations round-trips the annotations of sessions between
+// upstream responses and requests
+type sessionAnnotations struct {
+	store   sessionsapi.SessionStore
+	header  string
+	keys    map[string]string
+	maxSize int
+}
+
+func (a *sessionAnnotations) annotate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middlewareapi.GetRequestScope(req)
+
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		for name := range req.Header {
+			if strings.HasPrefix(name, AnnotationRequestHeaderPrefix) {
+				req.Header.Del(name)
+			}
+		}
+		if scope.Session != nil {
+			for key, value := range scope.Session.Annotations {
+				req.Header.Set(AnnotationRequestHeaderPrefix+key, value)
+			}
+		}
+
+		next.ServeHTTP(&annotatingResponseWriter{
+			ResponseWriter: rw,
+			annotations:    a,
+			req:            req,
+			scope:          scope,
+		}, req)
+	})
+}
+
+// update applies the annotations of the response header values to the
+// session, and saves it if they changed it.
+// Each value is a key=value annotation. An empty value removes the
+// annotation.
+func (a *sessionAnnotations) update(rw http.ResponseWriter, req *http.Request, scope *middlewareapi.RequestScope, values []string) {
+	session := scope.Session
+	if session == nil || !scope.StoredSession {
+		logger.Errorf("Ignoring session annotations of the upstream response to %s: the request has no stored session", req.URL.Path)
+		return
+	}
+
+	annotations := make(map[string]string, len(session.Annotations)+len(values))
+	for key, value := range session.Annotations {
+		annotations[key] = value
+	}
+	for _, value := range values {
+		parts := strings.SplitN(strings.TrimSpace(value), "=", 2)
+		if len(parts) != 2 {
+			logger.Errorf("Ignoring session annotation %q of the upstream response to %s: expected key=value", value, req.URL.Path)
+			continue
+		}
+		key, ok := a.keys[strings.ToLower(parts[0])]
+		if !ok {
+			logger.Errorf("Ignoring session annotation %q of the upstream response to %s: unknown key", parts[0], req.URL.Path)
+			continue
+		}
+		if parts[1] == "" {
+			delete(annotations, key)
+		} else {
+			annotations[key] = parts[1]
+		}
+	}
+
+	if size := annotationsSize(annotations); a.maxSize > 0 && size > a.maxSize {
+		logger.Errorf("WARNING: Ignoring session annotations of the upstream response to %s: %d bytes exceed the limit of %d bytes", req.URL.Path, size, a.maxSize)
+		return
+	}
+	if annotationsEqual(session.Annotations, annotations) {
+		return
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	session.Annotations = annotations
+	if err := a.store.Save(rw, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving annotated session: %v", err)
+	}
+}
+
+// annotationsSize is the total size of the keys and values of the
+// annotations
+func annotationsSize(annotations map[string]string) int {
+	size := 0
+	for key, value := range annotations {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+func annotationsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// annotatingResponseWriter takes the annotation header out of the upstream
+// response before its headers are written, so that the annotated session
+// cookie can still be set
+type annotatingResponseWriter struct {
+	http.ResponseWriter
+	annotations *sessionAnnotations
+	req         *http.Request
+	scope       *middlewareapi.RequestScope
+	wroteHeader bool
+}
+
+// WriteHeader updates the session annotations before writing the headers
+func (w *annotatingResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if values := w.Header().Values(w.annotations.header); len(values) > 0 {
+			w.Header().Del(w.annotations.header)
+			w.annotations.update(w.ResponseWriter, w.req, w.scope, values)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *annotatingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client
+func (w *annotatingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports websockets
+func (w *annotatingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker is not available on writer")
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session Annotations Suite", func() {
+	type annotationsTableInput struct {
+		session             *sessionsapi.SessionState
+		storedSession       bool
+		requestHeaders      http.Header
+		responseAnnotations []string
+		expectedHeaders     http.Header
+		expectedAnnotations map[string]string
+		expectedSaved       bool
+	}
+
+	DescribeTable("when serving a request",
+		func(in annotationsTableInput) {
+			scope := &middlewareapi.RequestScope{
+				Session:       in.session,
+				StoredSession: in.storedSession,
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req = middlewareapi.AddRequestScope(req, scope)
+			req.Header = in.requestHeaders.Clone()
+			rw := httptest.NewRecorder()
+
+			saved := false
+			store := &fakeSessionStore{
+				SaveFunc: func(rw http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+					saved = true
+					rw.Header().Add("Set-Cookie", "_oauth2_proxy=annotated")
+					return nil
+				},
+			}
+
+			var gotHeaders http.Header
+			handler := NewSessionAnnotations(&SessionAnnotationsOptions{
+				SessionStore: store,
+				Header:       "X-Session-Annotation",
+				Keys:         []string{"tenant", "locale"},
+				MaxSize:      32,
+			})(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				gotHeaders = req.Header.Clone()
+				for _, annotation := range in.responseAnnotations {
+					rw.Header().Add("X-Session-Annotation", annotation)
+				}
+				_, err := rw.Write([]byte("upstream"))
+				Expect(err).ToNot(HaveOccurred())
+			}))
+			handler.ServeHTTP(rw, req)
+
+			Expect(gotHeaders).To(Equal(in.expectedHeaders))
+			Expect(rw.Header().Values("X-Session-Annotation")).To(BeEmpty())
+			Expect(saved).To(Equal(in.expectedSaved))
+			if in.expectedSaved {
+				Expect(rw.Header().Get("Set-Cookie")).To(Equal("_oauth2_proxy=annotated"))
+			}
+			if in.session != nil {
+				Expect(in.session.Annotations).To(Equal(in.expectedAnnotations))
+			}
+		},
+		Entry("without a session, strips the annotation headers", annotationsTableInput{
+			requestHeaders: http.Header{
+				"X-Forwarded-Annotation-Tenant": []string{"spoofed"},
+				"Foo":                           []string{"bar"},
+			},
+			responseAnnotations: []string{"tenant=acme"},
+			expectedHeaders: http.Header{
+				"Foo": []string{"bar"},
+			},
+		}),
+		Entry("injects the annotations of the session", annotationsTableInput{
+			session: &sessionsapi.SessionState{
+				Annotations: map[string]string{"tenant": "acme"},
+			},
+			storedSession: true,
+			requestHeaders: http.Header{
+				"X-Forwarded-Annotation-Tenant": []string{"spoofed"},
+				"X-Forwarded-Annotation-Role":   []string{"admin"},
+			},
+			expectedHeaders: http.Header{
+				"X-Forwarded-Annotation-Tenant": []string{"acme"},
+			},
+			expectedAnnotations: map[string]string{"tenant": "acme"},
+		}),
+		Entry("saves the annotations of the upstream response", annotationsTableInput{
+			session: &sessionsapi.SessionState{
+				Annotations: map[string]string{"tenant": "acme"},
+			},
+			storedSession:       true,
+			requestHeaders:      http.Header{},
+			responseAnnotations: []string{"Tenant=example", "locale=en", "role=admin", "invalid"},
+			expectedHeaders: http.Header{
+				"X-Forwarded-Annotation-Tenant": []string{"acme"},
+			},
+			expectedAnnotations: map[string]string{"tenant": "example", "locale": "en"},
+			expectedSaved:       true,
+		}),
+		Entry("removes annotations with an empty value", annotationsTableInput{
+			session: &sessionsapi.SessionState{
+				Annotations: map[string]string{"tenant": "acme"},
+			},
+			storedSession:       true,
+			requestHeaders:      http.Header{},
+			responseAnnotations: []string{"tenant="},
+			expectedHeaders: http.Header{
+				"X-Forwarded-Annotation-Tenant": []string{"acme"},
+			},
+			expectedSaved: true,
+		}),
+		Entry("does not save unchanged annotations", annotationsTableInput{
+			session: &sessionsapi.SessionState{
+				Annotations: map[string]string{"tenant": "acme"},
+			},
+			storedSession:       true,
+			requestHeaders:      http.Header{},
+			responseAnnotations: []string{"tenant=acme"},
+			expectedHeaders: http.Header{
+				"X-Forwarded-Annotation-Tenant": []string{"acme"},
+			},
+			expectedAnnotations: map[string]string{"tenant": "acme"},
+		}),
+		Entry("ignores annotations over the size limit", annotationsTableInput{
+			session:             &sessionsapi.SessionState{},
+			storedSession:       true,
+			requestHeaders:      http.Header{},
+			responseAnnotations: []string{"tenant=a-tenant-with-a-very-long-name"},
+			expectedHeaders:     http.Header{},
+		}),
+		Entry("ignores annotations of sessions that are not stored", annotationsTableInput{
+			session:             &sessionsapi.SessionState{User: "bearer"},
+			requestHeaders:      http.Header{},
+			responseAnnotations: []string{"tenant=acme"},
+			expectedHeaders:     http.Header{},
+		}),
+	)
+})
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// TrustedIPSet reports whether an IP address is trusted
+type TrustedIPSet interface {
+	Has(net.IP) bool
+}
+
+// NewSpoofedClientIPDetection creates a new middleware that detects requests
+// whose real client IP header looks spoofed and logs them as a security
+// event.
+// A header is considered spoofed when it cannot be parsed, or when it claims
+// a trusted client IP that was forwarded by an untrusted hop, which could
+// have injected it.
+// When deny is set, these requests receive a 403 response.
+func NewSpoofedClientIPDetection(realClientIPParser ipapi.RealClientIPParser, trusted TrustedIPSet, deny bool) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return detectSpoofedClientIP(realClientIPParser, trusted, deny, next)
+	}
+}
+
+func detectSpoofedClientIP(realClientIPParser ipapi.RealClientIPParser, trusted TrustedIPSet, deny bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		chain := ip.GetForwardedChain(realClientIPParser, req.Header)
+		if len(chain) == 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		if reason := spoofedClientIPReason(realClientIPParser, trusted, req, chain); reason != "" {
+			logger.PrintAuthf("", req, logger.SpoofedClientIP, "%s; remote address %s; forwarded chain %q", reason, req.RemoteAddr, strings.Join(chain, ", "))
+			if deny {
+				http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// spoofedClientIPReason describes why the forwarded chain looks spoofed, or
+// returns an empty string if it does not
+func spoofedClientIPReason(realClientIPParser ipapi.RealClientIPParser, trusted TrustedIPSet, req *http.Request, chain []string) string {
+	clientIP, err := ip.GetClientIP(realClientIPParser, req)
+	if err != nil {
+		return err.Error()
+	}
+	if trusted == nil || !trusted.Has(clientIP) {
+		return ""
+	}
+
+	for _, hop := range chain[1:] {
+		if hopIP := ip.ParseForwardedHop(hop); hopIP == nil || !trusted.Has(hopIP) {
+			return fmt.Sprintf("trusted client IP %s forwarded by untrusted hop %s", clientIP, hop)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spoofed Client IP Detection Suite", func() {
+	var logs *bytes.Buffer
+
+	BeforeEach(func() {
+		logs = &bytes.Buffer{}
+		logger.SetOutput(logs)
+	})
+
+	AfterEach(func() {
+		logger.SetOutput(GinkgoWriter)
+	})
+
+	type spoofedClientIPTableInput struct {
+		forwardedFor string
+		deny         bool
+		expectedCode int
+		expectedLog  string
+	}
+
+	DescribeTable("when serving a request",
+		func(in *spoofedClientIPTableInput) {
+			parser, err := ip.GetRealClientIPParser("X-Forwarded-For")
+			Expect(err).ToNot(HaveOccurred())
+
+			trusted := ip.NewNetSet()
+			trusted.AddIPNet(*ip.ParseIPNet("10.0.0.0/8"))
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = "10.0.0.2:43670"
+			if in.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", in.forwardedFor)
+			}
+
+			rw := httptest.NewRecorder()
+			NewSpoofedClientIPDetection(parser, trusted, in.deny)(testHandler()).ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedCode))
+			if in.expectedLog == "" {
+				Expect(logs.String()).To(BeEmpty())
+			} else {
+				Expect(logs.String()).To(ContainSubstring("[SpoofedClientIP]"))
+				Expect(logs.String()).To(ContainSubstring(in.expectedLog))
+			}
+		},
+		Entry("without a forwarded header", &spoofedClientIPTableInput{
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with an untrusted client", &spoofedClientIPTableInput{
+			forwardedFor: "203.0.113.5, 10.0.0.1",
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with a trusted client forwarded by trusted hops", &spoofedClientIPTableInput{
+			forwardedFor: "10.1.2.3, 10.0.0.1:8080",
+			expectedCode: http.StatusOK,
+		}),
+		Entry("with a trusted client forwarded by an untrusted hop", &spoofedClientIPTableInput{
+			forwardedFor: "10.1.2.3, 203.0.113.5",
+			expectedCode: http.StatusOK,
+			expectedLog:  "trusted client IP 10.1.2.3 forwarded by untrusted hop 203.0.113.5; remote address 10.0.0.2:43670; forwarded chain \"10.1.2.3, 203.0.113.5\"",
+		}),
+		Entry("with an unparsable header", &spoofedClientIPTableInput{
+			forwardedFor: "not-an-ip",
+			expectedCode: http.StatusOK,
+			expectedLog:  "unable to parse ip (not-an-ip) from X-Forwarded-For header",
+		}),
+		Entry("with a spoofed header and deny enabled", &spoofedClientIPTableInput{
+			forwardedFor: "10.1.2.3, 203.0.113.5",
+			deny:         true,
+			expectedCode: http.StatusForbidden,
+			expectedLog:  "trusted client IP 10.1.2.3 forwarded by untrusted hop 203.0.113.5",
+		}),
+	)
+})
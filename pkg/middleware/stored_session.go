@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"github.com/justinas/alice"
+	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/failures"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
@@ -30,6 +32,31 @@ type StoredSessionLoaderOptions struct {
 	// If the sesssion is older than `RefreshPeriod` but the provider doesn't
 	// refresh it, we must re-validate using this validation.
 	ValidateSessionState func(context.Context, *sessionsapi.SessionState) bool
+
+	// How long after their expiry sessions are still accepted, to tolerate
+	// clock skew between the provider and the proxy
+	ClockSkew time.Duration
+
+	// The client components, "ip" and/or "user-agent", sessions are bound
+	// to. Sessions presented by a client with a different fingerprint are
+	// invalidated.
+	Fingerprint []string
+
+	// Parser of the real client IP, for fingerprints that include it
+	RealClientIPParser ipapi.RealClientIPParser
+
+	// Locks session refreshes across instances sharing the session store.
+	// Requests with an expired session that another instance is refreshing
+	// wait for the refresh, and load the refreshed session from the store.
+	// If nil, refreshes are locked in memory.
+	RefreshLocker sessionsapi.Locker
+
+	// How long to wait after the provider failed to refresh a session before
+	// refreshing it again, doubling with each failure in a row up to
+	// RefreshBackoffMax. The session is used as is until then, unless it
+	// expires. If 0, sessions that fail to refresh are invalidated.
+	RefreshBackoff    time.Duration
+	RefreshBackoffMax time.Duration
 }
 
 // NewStoredSessionLoader creates a new storedSessionLoader which loads
@@ -42,6 +69,12 @@ func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor
 		refreshPeriod:                      opts.RefreshPeriod,
 		refreshSessionWithProviderIfNeeded: opts.RefreshSessionIfNeeded,
 		validateSessionState:               opts.ValidateSessionState,
+		clockSkew:                          opts.ClockSkew,
+		fingerprint:                        opts.Fingerprint,
+		realClientIPParser:                 opts.RealClientIPParser,
+		refreshLocker:                      opts.RefreshLocker,
+		refreshBackoff:                     opts.RefreshBackoff,
+		refreshBackoffMax:                  opts.RefreshBackoffMax,
 	}
 	return ss.loadSession
 }
@@ -53,6 +86,13 @@ type storedSessionLoader struct {
 	refreshPeriod                      time.Duration
 	refreshSessionWithProviderIfNeeded func(context.Context, *sessionsapi.SessionState) (bool, error)
 	validateSessionState               func(context.Context, *sessionsapi.SessionState) bool
+	clockSkew                          time.Duration
+	fingerprint                        []string
+	realClientIPParser                 ipapi.RealClientIPParser
+	refreshLocker                      sessionsapi.Locker
+	refreshBackoff                     time.Duration
+	refreshBackoffMax                  time.Duration
+	inFlight                           inFlightRefreshes
 }
 
 // loadSession attempts to load a session as identified by the request cookies.
@@ -70,6 +110,21 @@ func (s *storedSessionLoader) loadSession(next http.Handler) http.Handler {
 		}
 
 		session, err := s.getValidatedSession(rw, req)
+		if err == errRefreshInProgress {
+			// The session is still valid once the concurrent refresh
+			// completes, so it must not be cleared
+			scope.RefreshInProgress = true
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if errors.Is(err, sessionsapi.ErrStoreUnavailable) {
+			// The session may still be valid once the store is available
+			// again, so the request continues unauthenticated without
+			// clearing the session
+			logger.Errorf("Error loading cookied session: %v", err)
+			next.ServeHTTP(rw, req)
+			return
+		}
 		if err != nil {
 			// In the case when there was an error loading the session,
 			// we should clear the session
@@ -82,6 +137,7 @@ func (s *storedSessionLoader) loadSession(next http.Handler) http.Handler {
 
 		// Add the session to the scope if it was found
 		scope.Session = session
+		scope.StoredSession = session != nil
 		next.ServeHTTP(rw, req)
 	})
 }
@@ -91,6 +147,7 @@ func (s *storedSessionLoader) loadSession(next http.Handler) http.Handler {
 func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *http.Request) (*sessionsapi.SessionState, error) {
 	session, err := s.store.Load(req)
 	if err != nil {
+		recordLoadFailure(req, err)
 		return nil, err
 	}
 	if session == nil {
@@ -98,14 +155,50 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 		return nil, nil
 	}
 
+	if len(s.fingerprint) > 0 {
+		err = s.validateFingerprint(req, session)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = s.refreshSessionIfNeeded(rw, req, session)
+	if err == errRefreshInProgress {
+		return nil, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error refreshing access token for session (%s): %v", session, err)
+		return nil, fmt.Errorf("error refreshing access token for session (%s): %w", session, err)
 	}
 
 	return session, nil
 }
 
+// recordLoadFailure counts session cookies that fail validation in the
+// authentication failures by cause
+func recordLoadFailure(req *http.Request, err error) {
+	switch {
+	case errors.Is(err, sessionsapi.ErrSignatureNotValid):
+		failures.Record(failures.BadSignature, req, err.Error())
+	case errors.Is(err, sessionsapi.ErrCookieExpired):
+		failures.Record(failures.ExpiredCookie, req, err.Error())
+	}
+}
+
+// validateFingerprint checks that the session is presented by the client it
+// was created by, so that a stolen session cookie cannot be replayed from
+// another client
+func (s *storedSessionLoader) validateFingerprint(req *http.Request, session *sessionsapi.SessionState) error {
+	switch session.Fingerprint {
+	case "":
+		return errors.New("session is not bound to a client fingerprint")
+	case GetClientFingerprint(req, s.realClientIPParser, s.fingerprint):
+		return nil
+	}
+
+	logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session presented by a client that does not match its fingerprint")
+	return errors.New("session is bound to a different client fingerprint")
+}
+
 // refreshSessionIfNeeded will attempt to refresh a session if the session
 // is older than the refresh period.
 // It is assumed that if the provider refreshes the session, the session is now
@@ -113,14 +206,43 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 // If the session requires refreshing but the provider does not refresh it,
 // we must validate the session to ensure that the returned session is still
 // valid.
+// If the session is already being refreshed by a concurrent request, it is
+// used as is until it expires, allowing for the clock skew, after which
+// errRefreshInProgress is returned.
+// If the provider failed to refresh the session recently, it is used as is
+// until the refresh backoff elapses, unless it expires.
 func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
 	if s.refreshPeriod <= time.Duration(0) || session.Age() < s.refreshPeriod {
 		// Refresh is disabled or the session is not old enough, do nothing
 		return nil
 	}
 
+	if s.refreshBackedOff(session) {
+		if session.IsExpiredWithSkew(s.clockSkew) {
+			return fmt.Errorf("session is expired, and its refresh is backed off after %d failures", session.RefreshFailures)
+		}
+		return nil
+	}
+
+	if key := refreshKey(session); key != "" {
+		release, err := s.lockRefresh(req.Context(), key)
+		switch {
+		case errors.Is(err, sessionsapi.ErrLockNotObtained):
+			return s.awaitConcurrentRefresh(req, key, session)
+		case err != nil:
+			// Refreshing without the lock is better than failing the request
+			logger.Errorf("Unable to lock the refresh of session %s, refreshing it anyway: %v", session, err)
+		default:
+			defer release()
+		}
+	}
+
 	logger.Printf("Refreshing %s old session cookie for %s (refresh after %s)", session.Age(), session, s.refreshPeriod)
 	refreshed, err := s.refreshSessionWithProvider(rw, req, session)
+	if err == errRefreshBackedOff {
+		// The session is used as is until the refresh is retried
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -132,32 +254,144 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 	return nil
 }
 
+// lockRefresh obtains the lock on refreshing the session with the key
+func (s *storedSessionLoader) lockRefresh(ctx context.Context, key string) (func(), error) {
+	if s.refreshLocker == nil {
+		return s.inFlight.Obtain(ctx, key, refreshLockExpiration)
+	}
+	return s.refreshLocker.Obtain(ctx, key, refreshLockExpiration)
+}
+
+// awaitConcurrentRefresh handles a session that is being refreshed by another
+// request. The session is used as is until it expires, allowing for the clock
+// skew. Once expired, if refreshes are locked across instances, the request
+// waits for the lock to be released and loads the refreshed session from the
+// store. Otherwise errRefreshInProgress is returned.
+func (s *storedSessionLoader) awaitConcurrentRefresh(req *http.Request, key string, session *sessionsapi.SessionState) error {
+	if !session.IsExpiredWithSkew(s.clockSkew) {
+		return nil
+	}
+	if s.refreshLocker == nil {
+		// The refreshed session is saved in the response to the request
+		// that refreshed it, so there is nothing to wait for
+		return errRefreshInProgress
+	}
+
+	ctx := req.Context()
+	ticker := time.NewTicker(refreshLockPollInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(refreshLockWait)
+	defer timeout.Stop()
+	for held := true; held; {
+		select {
+		case <-ctx.Done():
+			return errRefreshInProgress
+		case <-timeout.C:
+			return errRefreshInProgress
+		case <-ticker.C:
+		}
+
+		var err error
+		held, err = s.refreshLocker.Peek(ctx, key)
+		if err != nil {
+			logger.Errorf("Unable to check the refresh lock of session %s: %v", session, err)
+			return errRefreshInProgress
+		}
+	}
+
+	refreshed, err := s.store.Load(req)
+	if err != nil || refreshed == nil || refreshed.IsExpiredWithSkew(s.clockSkew) {
+		return errRefreshInProgress
+	}
+	*session = *refreshed
+	return nil
+}
+
 // refreshSessionWithProvider attempts to refresh the sessinon with the provider
 // and will save the session if it was updated.
 func (s *storedSessionLoader) refreshSessionWithProvider(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) (bool, error) {
 	refreshed, err := s.refreshSessionWithProviderIfNeeded(req.Context(), session)
-	if err != nil {
-		return false, fmt.Errorf("error refreshing access token: %v", err)
+	switch {
+	case errors.Is(err, sessionsapi.ErrTokenExpired), errors.Is(err, sessionsapi.ErrNotAuthorized):
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session can no longer be refreshed: %v", err)
+		return false, fmt.Errorf("error refreshing access token: %w", err)
+	case err != nil:
+		failures.Record(failures.ProviderError, req, fmt.Sprintf("error refreshing access token: %v", err))
+		err = fmt.Errorf("error refreshing access token: %w", err)
+		if s.refreshBackoff > 0 {
+			return false, s.backOffRefresh(rw, req, session, err)
+		}
+		return false, err
 	}
 
 	if !refreshed {
 		return false, nil
 	}
 
+	if s.refreshBackoff > 0 {
+		now := time.Now()
+		session.LastRefreshAttempt = &now
+		session.RefreshFailures = 0
+	}
+
 	// Because the session was refreshed, make sure to save it
 	err = s.store.Save(rw, req, session)
 	if err != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
-		return false, fmt.Errorf("error saving session: %v", err)
+		return false, fmt.Errorf("error saving session: %w", err)
 	}
 	return true, nil
 }
 
-// validateSession checks whether the session has expired and performs
-// provider validation on the session.
+// refreshBackedOff checks whether the provider failed to refresh the session
+// too recently to refresh it again
+func (s *storedSessionLoader) refreshBackedOff(session *sessionsapi.SessionState) bool {
+	if s.refreshBackoff <= 0 || session.RefreshFailures == 0 || session.LastRefreshAttempt == nil {
+		return false
+	}
+	return time.Since(*session.LastRefreshAttempt) < s.backoffAfter(session.RefreshFailures)
+}
+
+// backoffAfter is how long to wait before refreshing a session again after
+// the failures in a row, doubling from the refresh backoff with each failure
+// up to the maximum
+func (s *storedSessionLoader) backoffAfter(failures int) time.Duration {
+	backoff := s.refreshBackoff
+	for i := 1; i < failures && backoff < s.refreshBackoffMax; i++ {
+		backoff *= 2
+	}
+	if s.refreshBackoffMax >= s.refreshBackoff && backoff > s.refreshBackoffMax {
+		return s.refreshBackoffMax
+	}
+	return backoff
+}
+
+// backOffRefresh records that the provider failed to refresh the session, so
+// that it is not refreshed again until the backoff elapses. Sessions that
+// have not expired are saved with the failure and used as is until then, and
+// errRefreshBackedOff is returned. The refresh error is returned for expired
+// sessions.
+func (s *storedSessionLoader) backOffRefresh(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState, refreshErr error) error {
+	if session.IsExpiredWithSkew(s.clockSkew) {
+		return refreshErr
+	}
+
+	now := time.Now()
+	session.LastRefreshAttempt = &now
+	session.RefreshFailures++
+	logger.Errorf("Unable to refresh session %s, retrying in %s: %v", session, s.backoffAfter(session.RefreshFailures), refreshErr)
+	if err := s.store.Save(rw, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+		return fmt.Errorf("error saving session: %w", err)
+	}
+	return errRefreshBackedOff
+}
+
+// validateSession checks whether the session has expired, allowing for the
+// clock skew, and performs provider validation on the session.
 // An error implies the session is not longer valid.
 func (s *storedSessionLoader) validateSession(ctx context.Context, session *sessionsapi.SessionState) error {
-	if session.IsExpired() {
+	if session.IsExpiredWithSkew(s.clockSkew) {
 		return errors.New("session is expired")
 	}
 
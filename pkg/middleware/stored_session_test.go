@@ -9,6 +9,7 @@ import (
 	"time"
 
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -239,6 +240,32 @@ var _ = Describe("Stored Session Suite", func() {
 				validateSession: defaultValidateFunc,
 			}),
 		)
+
+		DescribeTable("when the session cannot be loaded",
+			func(loadErr error, expectCleared bool) {
+				cleared := false
+				store := &fakeSessionStore{
+					LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+						return nil, loadErr
+					},
+					ClearFunc: func(rw http.ResponseWriter, req *http.Request) error {
+						cleared = true
+						return nil
+					},
+				}
+
+				scope := &middlewareapi.RequestScope{}
+				req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), scope)
+				handler := NewStoredSessionLoader(&StoredSessionLoaderOptions{SessionStore: store})
+				handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+				Expect(scope.Session).To(BeNil())
+				Expect(cleared).To(Equal(expectCleared))
+			},
+			Entry("clears an invalid session", errors.New("invalid cookie"), true),
+			Entry("keeps the session when the store is unavailable",
+				fmt.Errorf("failed to load the session state with the ticket: %w", sessionsapi.ErrStoreUnavailable), false),
+		)
 	})
 
 	Context("refreshSessionIfNeeded", func() {
@@ -281,7 +308,7 @@ var _ = Describe("Stored Session Suite", func() {
 				req := httptest.NewRequest("", "/", nil)
 				err := s.refreshSessionIfNeeded(nil, req, in.session)
 				if in.expectedErr != nil {
-					Expect(err).To(MatchError(in.expectedErr))
+					Expect(err).To(MatchError(in.expectedErr.Error()))
 				} else {
 					Expect(err).ToNot(HaveOccurred())
 				}
@@ -364,6 +391,296 @@ var _ = Describe("Stored Session Suite", func() {
 		)
 	})
 
+	Context("with refresh backoff", func() {
+		var s *storedSessionLoader
+		var refreshes int
+		var saved *sessionsapi.SessionState
+
+		BeforeEach(func() {
+			refreshes = 0
+			saved = nil
+			s = &storedSessionLoader{
+				refreshPeriod: time.Minute,
+				store: &fakeSessionStore{
+					SaveFunc: func(_ http.ResponseWriter, _ *http.Request, ss *sessionsapi.SessionState) error {
+						saved = ss
+						return nil
+					},
+				},
+				refreshSessionWithProviderIfNeeded: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					refreshes++
+					if ss.RefreshToken == refresh {
+						return true, nil
+					}
+					return false, errors.New("provider unavailable")
+				},
+				validateSessionState: func(context.Context, *sessionsapi.SessionState) bool { return true },
+				refreshBackoff:       10 * time.Second,
+				refreshBackoffMax:    time.Minute,
+			}
+		})
+
+		newSession := func(refreshToken string, expiresIn time.Duration) *sessionsapi.SessionState {
+			created := time.Now().Add(-5 * time.Minute)
+			expires := time.Now().Add(expiresIn)
+			return &sessionsapi.SessionState{RefreshToken: refreshToken, CreatedAt: &created, ExpiresOn: &expires}
+		}
+
+		It("saves the failure and uses a session that has not expired", func() {
+			session := newSession("RefreshError", time.Minute)
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshes).To(Equal(1))
+			Expect(saved).To(Equal(session))
+			Expect(session.RefreshFailures).To(Equal(1))
+			Expect(session.LastRefreshAttempt).ToNot(BeNil())
+		})
+
+		It("does not refresh the session again until the backoff elapses", func() {
+			session := newSession("RefreshError", time.Minute)
+			lastAttempt := time.Now().Add(-15 * time.Second)
+			session.LastRefreshAttempt = &lastAttempt
+			session.RefreshFailures = 2
+
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshes).To(Equal(0))
+			Expect(saved).To(BeNil())
+		})
+
+		It("refreshes the session again once the backoff elapses", func() {
+			session := newSession(refresh, time.Minute)
+			lastAttempt := time.Now().Add(-15 * time.Second)
+			session.LastRefreshAttempt = &lastAttempt
+			session.RefreshFailures = 1
+
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshes).To(Equal(1))
+			Expect(session.RefreshFailures).To(Equal(0))
+			Expect(session.LastRefreshAttempt.After(lastAttempt)).To(BeTrue())
+		})
+
+		It("returns an error for an expired session", func() {
+			session := newSession("RefreshError", -time.Minute)
+			err := s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)
+			Expect(err).To(MatchError("error refreshing access token: provider unavailable"))
+			Expect(saved).To(BeNil())
+
+			lastAttempt := time.Now()
+			session.LastRefreshAttempt = &lastAttempt
+			session.RefreshFailures = 1
+			err = s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)
+			Expect(err).To(MatchError("session is expired, and its refresh is backed off after 1 failures"))
+			Expect(refreshes).To(Equal(1))
+		})
+
+		DescribeTable("backs off",
+			func(failures int, expected time.Duration) {
+				Expect(s.backoffAfter(failures)).To(Equal(expected))
+			},
+			Entry("after the first failure", 1, 10*time.Second),
+			Entry("doubling with each failure", 3, 40*time.Second),
+			Entry("up to the maximum", 4, time.Minute),
+			Entry("after many failures", 1000, time.Minute),
+		)
+	})
+
+	Context("with a concurrent refresh in progress", func() {
+		var s *storedSessionLoader
+		var refreshed bool
+
+		createdPast := time.Now().Add(-5 * time.Minute)
+		createdFuture := time.Now().Add(5 * time.Minute)
+
+		BeforeEach(func() {
+			refreshed = false
+			s = &storedSessionLoader{
+				refreshPeriod: 1 * time.Minute,
+				store:         &fakeSessionStore{},
+				refreshSessionWithProviderIfNeeded: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					refreshed = true
+					return true, nil
+				},
+				validateSessionState: func(_ context.Context, ss *sessionsapi.SessionState) bool {
+					return true
+				},
+			}
+
+			_, ok := s.inFlight.start(refreshKey(&sessionsapi.SessionState{RefreshToken: refresh}))
+			Expect(ok).To(BeTrue())
+		})
+
+		It("uses the session without refreshing it when it has not expired", func() {
+			session := &sessionsapi.SessionState{
+				RefreshToken: refresh,
+				CreatedAt:    &createdPast,
+				ExpiresOn:    &createdFuture,
+			}
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshed).To(BeFalse())
+		})
+
+		It("returns an error when the session has expired", func() {
+			session := &sessionsapi.SessionState{
+				RefreshToken: refresh,
+				CreatedAt:    &createdPast,
+				ExpiresOn:    &createdPast,
+			}
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(MatchError(errRefreshInProgress))
+			Expect(refreshed).To(BeFalse())
+		})
+
+		It("refreshes other sessions", func() {
+			session := &sessionsapi.SessionState{
+				RefreshToken: "Other",
+				CreatedAt:    &createdPast,
+				ExpiresOn:    &createdPast,
+			}
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshed).To(BeTrue())
+		})
+
+		It("marks the request scope without clearing the session", func() {
+			cleared := false
+			s.store = &fakeSessionStore{
+				LoadFunc: func(req *http.Request) (*sessionsapi.SessionState, error) {
+					return &sessionsapi.SessionState{
+						RefreshToken: refresh,
+						CreatedAt:    &createdPast,
+						ExpiresOn:    &createdPast,
+					}, nil
+				},
+				ClearFunc: func(rw http.ResponseWriter, req *http.Request) error {
+					cleared = true
+					return nil
+				},
+			}
+
+			scope := &middlewareapi.RequestScope{}
+			req := middlewareapi.AddRequestScope(httptest.NewRequest("", "/", nil), scope)
+			s.loadSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(scope.Session).To(BeNil())
+			Expect(scope.RefreshInProgress).To(BeTrue())
+			Expect(cleared).To(BeFalse())
+		})
+	})
+
+	Context("with a refresh locked by another instance", func() {
+		var s *storedSessionLoader
+		var locker *fakeLocker
+		var refreshed bool
+
+		createdPast := time.Now().Add(-5 * time.Minute)
+		createdFuture := time.Now().Add(5 * time.Minute)
+		expiredSession := func() *sessionsapi.SessionState {
+			return &sessionsapi.SessionState{
+				RefreshToken: refresh,
+				CreatedAt:    &createdPast,
+				ExpiresOn:    &createdPast,
+			}
+		}
+
+		BeforeEach(func() {
+			refreshed = false
+			locker = &fakeLocker{held: map[string]int{}}
+			s = &storedSessionLoader{
+				refreshPeriod: 1 * time.Minute,
+				refreshLocker: locker,
+				store: &fakeSessionStore{
+					LoadFunc: func(*http.Request) (*sessionsapi.SessionState, error) {
+						return &sessionsapi.SessionState{
+							AccessToken:  "Refreshed",
+							RefreshToken: "NewRefresh",
+							CreatedAt:    &createdFuture,
+							ExpiresOn:    &createdFuture,
+						}, nil
+					},
+				},
+				refreshSessionWithProviderIfNeeded: func(_ context.Context, ss *sessionsapi.SessionState) (bool, error) {
+					refreshed = true
+					return true, nil
+				},
+			}
+		})
+
+		It("refreshes the session while holding the lock", func() {
+			session := expiredSession()
+			Expect(s.refreshSessionIfNeeded(httptest.NewRecorder(), httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshed).To(BeTrue())
+			Expect(locker.obtained).To(Equal(1))
+			Expect(locker.held).To(BeEmpty())
+		})
+
+		It("waits for the refresh and loads the refreshed session", func() {
+			// The lock is released once it has been checked twice
+			locker.held[refreshKey(expiredSession())] = 2
+
+			session := expiredSession()
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshed).To(BeFalse())
+			Expect(session.AccessToken).To(Equal("Refreshed"))
+		})
+
+		It("uses a session that has not expired without waiting", func() {
+			locker.held[refreshKey(expiredSession())] = 2
+
+			session := expiredSession()
+			session.ExpiresOn = &createdFuture
+			Expect(s.refreshSessionIfNeeded(nil, httptest.NewRequest("", "/", nil), session)).To(Succeed())
+			Expect(refreshed).To(BeFalse())
+			Expect(session.AccessToken).To(BeEmpty())
+		})
+
+		It("returns an error when the request is cancelled while waiting", func() {
+			locker.held[refreshKey(expiredSession())] = -1
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest("", "/", nil).WithContext(ctx)
+			Expect(s.refreshSessionIfNeeded(nil, req, expiredSession())).To(MatchError(errRefreshInProgress))
+		})
+
+		It("refreshes the session when the lock cannot be obtained", func() {
+			locker.err = errors.New("redis unavailable")
+
+			Expect(s.refreshSessionIfNeeded(httptest.NewRecorder(), httptest.NewRequest("", "/", nil), expiredSession())).To(Succeed())
+			Expect(refreshed).To(BeTrue())
+		})
+	})
+
+	Context("inFlightRefreshes", func() {
+		It("locks a session until the refresh finishes", func() {
+			r := &inFlightRefreshes{}
+
+			release, err := r.Obtain(ctx, "key", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			held, err := r.Peek(ctx, "key")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(held).To(BeTrue())
+
+			_, err = r.Obtain(ctx, "key", time.Minute)
+			Expect(err).To(MatchError(sessionsapi.ErrLockNotObtained))
+
+			release()
+			held, err = r.Peek(ctx, "key")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(held).To(BeFalse())
+		})
+
+		It("tracks a session until the refresh finishes", func() {
+			r := &inFlightRefreshes{}
+
+			finish, ok := r.start("key")
+			Expect(ok).To(BeTrue())
+
+			_, ok = r.start("key")
+			Expect(ok).To(BeFalse())
+
+			finish()
+			_, ok = r.start("key")
+			Expect(ok).To(BeTrue())
+		})
+	})
+
 	Context("refreshSessionWithProvider", func() {
 		type refreshSessionWithProviderTableInput struct {
 			session         *sessionsapi.SessionState
@@ -403,7 +720,7 @@ var _ = Describe("Stored Session Suite", func() {
 				req := httptest.NewRequest("", "/", nil)
 				refreshed, err := s.refreshSessionWithProvider(nil, req, in.session)
 				if in.expectedErr != nil {
-					Expect(err).To(MatchError(in.expectedErr))
+					Expect(err).To(MatchError(in.expectedErr.Error()))
 				} else {
 					Expect(err).ToNot(HaveOccurred())
 				}
@@ -448,6 +765,39 @@ var _ = Describe("Stored Session Suite", func() {
 		)
 	})
 
+	Context("validateFingerprint", func() {
+		var s *storedSessionLoader
+		var req *http.Request
+
+		BeforeEach(func() {
+			s = &storedSessionLoader{
+				fingerprint: []string{options.FingerprintClientIP, options.FingerprintUserAgent},
+			}
+			req = httptest.NewRequest("", "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("User-Agent", "curl/7.64.1")
+		})
+
+		It("accepts a session presented by the client it is bound to", func() {
+			session := &sessionsapi.SessionState{
+				Fingerprint: GetClientFingerprint(req, nil, s.fingerprint),
+			}
+			Expect(s.validateFingerprint(req, session)).To(Succeed())
+		})
+
+		It("rejects a session presented by a different client", func() {
+			session := &sessionsapi.SessionState{
+				Fingerprint: GetClientFingerprint(req, nil, s.fingerprint),
+			}
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+			Expect(s.validateFingerprint(req, session)).To(MatchError("session is bound to a different client fingerprint"))
+		})
+
+		It("rejects a session that is not bound to a client", func() {
+			Expect(s.validateFingerprint(req, &sessionsapi.SessionState{})).To(MatchError("session is not bound to a client fingerprint"))
+		})
+	})
+
 	Context("validateSession", func() {
 		var s *storedSessionLoader
 
@@ -481,6 +831,16 @@ var _ = Describe("Stored Session Suite", func() {
 				}
 				Expect(s.validateSession(ctx, session)).To(MatchError("session is expired"))
 			})
+
+			It("does not return an error within the clock skew", func() {
+				s.clockSkew = 2 * time.Minute
+				expires := time.Now().Add(-1 * time.Minute)
+				session := &sessionsapi.SessionState{
+					AccessToken: "Valid",
+					ExpiresOn:   &expires,
+				}
+				Expect(s.validateSession(ctx, session)).To(Succeed())
+			})
 		})
 
 		Context("with an invalid session", func() {
@@ -496,6 +856,40 @@ var _ = Describe("Stored Session Suite", func() {
 	})
 })
 
+// fakeLocker holds the locks in held, with the number of times each can be
+// peeked before it is released, or a negative number to never release it
+type fakeLocker struct {
+	held     map[string]int
+	obtained int
+	err      error
+}
+
+func (f *fakeLocker) Obtain(_ context.Context, key string, _ time.Duration) (func(), error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if _, ok := f.held[key]; ok {
+		return nil, sessionsapi.ErrLockNotObtained
+	}
+	f.held[key] = -1
+	f.obtained++
+	return func() { delete(f.held, key) }, nil
+}
+
+func (f *fakeLocker) Peek(_ context.Context, key string) (bool, error) {
+	peeks, ok := f.held[key]
+	switch {
+	case !ok:
+		return false, nil
+	case peeks == 0:
+		delete(f.held, key)
+		return false, nil
+	case peeks > 0:
+		f.held[key] = peeks - 1
+	}
+	return true, nil
+}
+
 type fakeSessionStore struct {
 	SaveFunc  func(http.ResponseWriter, *http.Request, *sessionsapi.SessionState) error
 	LoadFunc  func(req *http.Request) (*sessionsapi.SessionState, error)
@@ -6,8 +6,21 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
 )
 
+// maxResponseBodySize is the largest response body buffered in bytes, or 0
+// for no limit
+var maxResponseBodySize int64
+
+// SetMaxResponseBodySize sets the largest response body in bytes that
+// requests buffer. Requests with larger response bodies fail. 0 removes the
+// limit.
+func SetMaxResponseBodySize(size int64) {
+	maxResponseBodySize = size
+}
+
 // Builder allows users to construct a request and then execute the
 // request via Do().
 // Do returns a Result which allows the user to get the body,
@@ -107,7 +120,7 @@ func (r *builder) do() Result {
 	}
 
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
 		r.result = &result{err: fmt.Errorf("error reading response body: %v", err)}
 		return r.result
@@ -116,3 +129,20 @@ func (r *builder) do() Result {
 	r.result = &result{response: resp, body: body}
 	return r.result
 }
+
+// readBody reads a response body up to the maximum response body size
+func readBody(body io.Reader) ([]byte, error) {
+	if maxResponseBodySize <= 0 {
+		return ioutil.ReadAll(body)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxResponseBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxResponseBodySize {
+		memory.Reject(memory.ResponseBody)
+		return nil, fmt.Errorf("the response body exceeds the limit of %d bytes", maxResponseBodySize)
+	}
+	return data, nil
+}
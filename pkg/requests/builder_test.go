@@ -245,6 +245,18 @@ var _ = Describe("Builder suite", func() {
 
 		assertJSONError(getBuilder, "invalid character 'O' looking for beginning of value")
 	})
+
+	Context("when the response body exceeds the maximum size", func() {
+		BeforeEach(func() {
+			SetMaxResponseBodySize(8)
+		})
+
+		AfterEach(func() {
+			SetMaxResponseBodySize(0)
+		})
+
+		assertRequestError(getBuilder, "the response body exceeds the limit of 8 bytes")
+	})
 })
 
 func assertSuccessfulRequest(builder func() Builder, expectedRequest testHTTPRequest) {
@@ -0,0 +1,88 @@
+package requests
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// directProxy is the egress proxy override value that disables proxying for
+// a destination
+const directProxy = "direct"
+
+// egressProxy overrides the proxy used for requests to matching hosts
+type egressProxy struct {
+	host  string
+	proxy *url.URL
+}
+
+// matches checks whether the host is matched using NO_PROXY semantics:
+// "example.com" matches the domain and its subdomains, ".example.com" only
+// matches subdomains and "*" matches every host
+func (e egressProxy) matches(host string) bool {
+	switch {
+	case e.host == "*":
+		return true
+	case strings.HasPrefix(e.host, "."):
+		return strings.HasSuffix(host, e.host)
+	default:
+		return host == e.host || strings.HasSuffix(host, "."+e.host)
+	}
+}
+
+// NewProxyFunc returns a function selecting the proxy for outbound requests.
+// Each override has the form "host=proxy-url" or "host=direct", and the first
+// override matching the destination host is used. Requests that match no
+// override use the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+// variables.
+func NewProxyFunc(overrides []string) (func(*http.Request) (*url.URL, error), error) {
+	proxies := make([]egressProxy, 0, len(overrides))
+	for _, override := range overrides {
+		proxy, err := parseEgressProxy(override)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, proxy := range proxies {
+			if proxy.matches(host) {
+				return proxy.proxy, nil
+			}
+		}
+		return http.ProxyFromEnvironment(req)
+	}, nil
+}
+
+func parseEgressProxy(override string) (egressProxy, error) {
+	parts := strings.SplitN(override, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return egressProxy{}, fmt.Errorf("egress proxy %q must have the form host=proxy-url", override)
+	}
+
+	host := strings.ToLower(parts[0])
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return egressProxy{}, fmt.Errorf("egress proxy %q must not set a port for host %q", override, h)
+	}
+	if parts[1] == directProxy {
+		return egressProxy{host: host}, nil
+	}
+
+	proxy, err := url.Parse(parts[1])
+	if err != nil {
+		return egressProxy{}, fmt.Errorf("egress proxy %q has an invalid proxy URL: %v", override, err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return egressProxy{}, fmt.Errorf("egress proxy %q must use an http, https or socks5 proxy URL", override)
+	}
+	if proxy.Host == "" {
+		return egressProxy{}, fmt.Errorf("egress proxy %q has no proxy host", override)
+	}
+	return egressProxy{host: host, proxy: proxy}, nil
+}
@@ -0,0 +1,86 @@
+package requests
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Egress Proxy Suite", func() {
+	type proxyFuncTableInput struct {
+		overrides     []string
+		requestURL    string
+		expectedProxy string
+		expectedErr   string
+	}
+
+	DescribeTable("NewProxyFunc",
+		func(in proxyFuncTableInput) {
+			proxyFunc, err := NewProxyFunc(in.overrides)
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+
+			req := httptest.NewRequest(http.MethodGet, in.requestURL, nil)
+			proxy, err := proxyFunc(req)
+			Expect(err).ToNot(HaveOccurred())
+			if in.expectedProxy == "" {
+				expected, err := http.ProxyFromEnvironment(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(proxy).To(Equal(expected))
+				return
+			}
+			Expect(proxy.String()).To(Equal(in.expectedProxy))
+		},
+		Entry("uses the environment without overrides", proxyFuncTableInput{
+			requestURL: "https://accounts.example.com/token",
+		}),
+		Entry("matches the host and its subdomains", proxyFuncTableInput{
+			overrides:     []string{"example.com=http://proxy.internal:3128"},
+			requestURL:    "https://accounts.example.com/token",
+			expectedProxy: "http://proxy.internal:3128",
+		}),
+		Entry("does not match other domains ending with the host", proxyFuncTableInput{
+			overrides:  []string{"example.com=http://proxy.internal:3128"},
+			requestURL: "https://notexample.com/token",
+		}),
+		Entry("only matches subdomains with a leading dot", proxyFuncTableInput{
+			overrides:  []string{".example.com=http://proxy.internal:3128"},
+			requestURL: "https://example.com/token",
+		}),
+		Entry("uses the first matching override", proxyFuncTableInput{
+			overrides: []string{
+				"accounts.example.com=socks5://proxy.internal:1080",
+				"*=http://proxy.internal:3128",
+			},
+			requestURL:    "https://ACCOUNTS.example.com:8443/token",
+			expectedProxy: "socks5://proxy.internal:1080",
+		}),
+		Entry("rejects overrides without a proxy", proxyFuncTableInput{
+			overrides:   []string{"example.com"},
+			expectedErr: `egress proxy "example.com" must have the form host=proxy-url`,
+		}),
+		Entry("rejects overrides with a port", proxyFuncTableInput{
+			overrides:   []string{"example.com:443=http://proxy.internal:3128"},
+			expectedErr: `egress proxy "example.com:443=http://proxy.internal:3128" must not set a port for host "example.com"`,
+		}),
+		Entry("rejects unsupported proxy schemes", proxyFuncTableInput{
+			overrides:   []string{"example.com=ftp://proxy.internal"},
+			expectedErr: `egress proxy "example.com=ftp://proxy.internal" must use an http, https or socks5 proxy URL`,
+		}),
+	)
+
+	It("does not proxy direct overrides", func() {
+		proxyFunc, err := NewProxyFunc([]string{"example.com=direct", "*=http://proxy.internal:3128"})
+		Expect(err).ToNot(HaveOccurred())
+
+		proxy, err := proxyFunc(httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(proxy).To(BeNil())
+	})
+})
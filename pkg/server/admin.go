@@ -0,0 +1,555 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/events"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/failures"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/header"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// headerInjectors holds the header injection configuration of the proxy so
+// that it can be evaluated by the admin API
+type headerInjectors struct {
+	request         header.Injector
+	response        header.Injector
+	requestStripped []string
+}
+
+func newHeaderInjectors(opts *options.Options) (*headerInjectors, error) {
+	request, err := header.NewInjector(opts.InjectRequestHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("error building request header injector: %v", err)
+	}
+	response, err := header.NewInjector(opts.InjectResponseHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("error building response header injector: %v", err)
+	}
+
+	stripped := []string{}
+	for _, h := range opts.InjectRequestHeaders {
+		if !h.PreserveRequestValue {
+			stripped = append(stripped, http.CanonicalHeaderKey(h.Name))
+		}
+	}
+
+	return &headerInjectors{
+		request:         request,
+		response:        response,
+		requestStripped: stripped,
+	}, nil
+}
+
+// headersDryRunRequest is a synthetic session and the headers of a request
+// made with it.
+// The session uses the SessionState field names, e.g. email, user, groups,
+// preferredUsername and accessToken.
+type headersDryRunRequest struct {
+	Session *sessionsapi.SessionState `json:"session"`
+	Headers http.Header               `json:"headers"`
+}
+
+// headersDryRunResponse describes how the headers of a request and its
+// response would be modified
+type headersDryRunResponse struct {
+	Request  headersDryRunResult `json:"request"`
+	Response headersDryRunResult `json:"response"`
+}
+
+type headersDryRunResult struct {
+	// Stripped are the headers given in the request that would be removed
+	Stripped []string `json:"stripped"`
+
+	// Injected are the headers that would be added
+	Injected http.Header `json:"injected"`
+
+	// Headers are the resulting request headers sent to the upstream
+	Headers http.Header `json:"headers,omitempty"`
+}
+
+// loadAdminToken reads the bearer token required by the admin API
+func loadAdminToken(opts *options.Options) (string, error) {
+	if opts.AdminTokenFile == "" {
+		return "", nil
+	}
+	contents, err := ioutil.ReadFile(opts.AdminTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read admin token file: %v", err)
+	}
+	token := strings.TrimSpace(string(contents))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", opts.AdminTokenFile)
+	}
+	return token, nil
+}
+
+// AdminHandler returns the handler for the admin API.
+// Requests must present the admin token as a bearer token. When no token is
+// configured all requests are rejected.
+// It should only be served on a trusted address as it may disclose secrets
+// from the configuration.
+func (p *OAuthProxy) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/headers/dry-run", p.headersDryRun)
+	mux.HandleFunc("/config", p.configDiff)
+	mux.Handle("/allowlist/routes", p.routesAllowlistEntries())
+	mux.Handle("/allowlist/ips", p.trustedIPsAllowlistEntries())
+	mux.HandleFunc("/authorization/rule-sets", p.ruleSetsStatus)
+	mux.HandleFunc("/authorization/rule-sets/active", p.switchRuleSet)
+	mux.HandleFunc("/authorization/rule-sets/rollback", p.rollbackRuleSet)
+	mux.Handle("/authorization/metrics", p.authorizationMetrics())
+	mux.Handle("/sessions/metrics", p.metricsHandler(sessionsapi.MetricsCollectors()...))
+	mux.HandleFunc("/authentication/failures", authenticationFailures)
+	mux.HandleFunc("/authentication/events", authenticationEvents)
+	mux.Handle("/authentication/metrics", p.metricsHandler(failures.MetricsCollector()))
+	mux.Handle("/memory/metrics", p.metricsHandler(memory.MetricsCollector()))
+	mux.Handle("/requests/metrics", p.requestMetricsHandler())
+	return p.authenticateAdmin(mux)
+}
+
+// authenticateAdmin rejects requests that do not present the admin token
+func (p *OAuthProxy) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		token, ok := bearerToken(req)
+		if !ok || p.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// bearerToken returns the token of the Bearer Authorization header of the
+// request. The scheme is case insensitive, and no other scheme is accepted.
+func bearerToken(req *http.Request) (string, bool) {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// allowlistEntry is the body of requests to add or remove an allowlist entry
+type allowlistEntry struct {
+	Entry string `json:"entry"`
+}
+
+// allowlistEntries is the response of the allowlist endpoints
+type allowlistEntries struct {
+	Entries []string `json:"entries"`
+}
+
+// allowlistEntriesHandler lists, adds and removes the entries of an
+// allowlist that can be modified at runtime
+type allowlistEntriesHandler struct {
+	name    string
+	entries func() []string
+	add     func(string) error
+	remove  func(string) (bool, error)
+}
+
+// routesAllowlistEntries manages the --skip-auth-route entries
+func (p *OAuthProxy) routesAllowlistEntries() *allowlistEntriesHandler {
+	return &allowlistEntriesHandler{
+		name:    "route",
+		entries: p.skipAuthRoutes.Entries,
+		add:     p.skipAuthRoutes.AddRoute,
+		remove:  p.skipAuthRoutes.RemoveRoute,
+	}
+}
+
+// trustedIPsAllowlistEntries manages the --trusted-ip entries
+func (p *OAuthProxy) trustedIPsAllowlistEntries() *allowlistEntriesHandler {
+	return &allowlistEntriesHandler{
+		name:    "trusted IP",
+		entries: p.trustedIPs.Entries,
+		add:     p.trustedIPs.Add,
+		remove:  p.trustedIPs.Remove,
+	}
+}
+
+func (h *allowlistEntriesHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		h.writeEntries(rw, http.StatusOK)
+	case http.MethodPost:
+		entry, err := decodeAllowlistEntry(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.add(entry); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid %s %q: %v", h.name, entry, err), http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Admin API added %s to allowlist: %s", h.name, entry)
+		h.writeEntries(rw, http.StatusCreated)
+	case http.MethodDelete:
+		entry, err := decodeAllowlistEntry(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		removed, err := h.remove(entry)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid %s %q: %v", h.name, entry, err), http.StatusBadRequest)
+			return
+		}
+		if !removed {
+			http.Error(rw, fmt.Sprintf("%s %q is not in the allowlist", h.name, entry), http.StatusNotFound)
+			return
+		}
+		logger.Printf("Admin API removed %s from allowlist: %s", h.name, entry)
+		h.writeEntries(rw, http.StatusOK)
+	default:
+		rw.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPost, http.MethodDelete}, ", "))
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *allowlistEntriesHandler) writeEntries(rw http.ResponseWriter, code int) {
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(code)
+	err := json.NewEncoder(rw).Encode(&allowlistEntries{Entries: h.entries()})
+	if err != nil {
+		logger.Errorf("Error encoding allowlist entries: %v", err)
+	}
+}
+
+func decodeAllowlistEntry(req *http.Request) (string, error) {
+	body := &allowlistEntry{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		return "", fmt.Errorf("invalid allowlist request: %v", err)
+	}
+	if body.Entry == "" {
+		return "", errors.New("invalid allowlist request: entry is required")
+	}
+	return body.Entry, nil
+}
+
+// ruleSetSwitch is the body of requests to switch the active rule set
+type ruleSetSwitch struct {
+	Name string `json:"name"`
+}
+
+// ruleSetsStatus is the response of the rule set endpoints
+type ruleSetsStatus struct {
+	Active   string   `json:"active"`
+	Previous string   `json:"previous,omitempty"`
+	RuleSets []string `json:"ruleSets"`
+}
+
+// ruleSetsStatus lists the deny rule sets and which is active
+func (p *OAuthProxy) ruleSetsStatus(rw http.ResponseWriter, req *http.Request) {
+	if !p.checkRuleSetsRequest(rw, req, http.MethodGet) {
+		return
+	}
+	p.writeRuleSetsStatus(rw)
+}
+
+// switchRuleSet atomically switches the active deny rule set
+func (p *OAuthProxy) switchRuleSet(rw http.ResponseWriter, req *http.Request) {
+	if !p.checkRuleSetsRequest(rw, req, http.MethodPut) {
+		return
+	}
+
+	body := &ruleSetSwitch{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		http.Error(rw, fmt.Sprintf("invalid rule set request: %v", err), http.StatusBadRequest)
+		return
+	}
+	previous, _ := p.authorizationRules.Active()
+	if err := p.authorizationRules.Switch(body.Name); err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Printf("Admin API switched active deny rule set from %q to %q", previous, body.Name)
+	p.writeRuleSetsStatus(rw)
+}
+
+// rollbackRuleSet makes the previously active deny rule set active again
+func (p *OAuthProxy) rollbackRuleSet(rw http.ResponseWriter, req *http.Request) {
+	if !p.checkRuleSetsRequest(rw, req, http.MethodPost) {
+		return
+	}
+
+	previous, _ := p.authorizationRules.Active()
+	name, err := p.authorizationRules.Rollback()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	logger.Printf("Admin API rolled back active deny rule set from %q to %q", previous, name)
+	p.writeRuleSetsStatus(rw)
+}
+
+// authorizationMetrics exposes the hits of each deny rule and index for
+// Prometheus
+func (p *OAuthProxy) authorizationMetrics() http.Handler {
+	if p.authorizationRules == nil {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			p.checkRuleSetsRequest(rw, req, http.MethodGet)
+		})
+	}
+	return p.metricsHandler(p.authorizationRules)
+}
+
+// requestMetricsHandler exposes the requests served by the proxy by route
+// template for Prometheus
+func (p *OAuthProxy) requestMetricsHandler() http.Handler {
+	if p.requestMetrics == nil {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			http.Error(rw, "request metrics are not enabled", http.StatusNotFound)
+		})
+	}
+	return p.metricsHandler(p.requestMetrics)
+}
+
+// metricsHandler exposes the metrics of the collectors for Prometheus, with
+// the provider labels of the proxy added to every metric.
+// Metrics that fail to be collected are logged and left out.
+func (p *OAuthProxy) metricsHandler(collectors ...prometheus.Collector) http.Handler {
+	registry := prometheus.NewRegistry()
+	prometheus.WrapRegistererWith(p.metricLabels, registry).MustRegister(collectors...)
+	metrics := promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:      metricsErrorLogger{},
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			rw.Header().Set("Allow", http.MethodGet)
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		metrics.ServeHTTP(rw, req)
+	})
+}
+
+// metricsErrorLogger logs the errors of collecting metrics
+type metricsErrorLogger struct{}
+
+func (metricsErrorLogger) Println(v ...interface{}) {
+	logger.Errorf("Error serving metrics: %s", fmt.Sprint(v...))
+}
+
+// providerMetricLabels are the provider and tenant labels that are added to
+// every metric, so that the metrics of a shared fleet can be split by
+// provider and tenant.
+// The tenant label is left out when no tenant is configured.
+func providerMetricLabels(provider, tenant string) prometheus.Labels {
+	labels := prometheus.Labels{"provider": provider}
+	if tenant != "" {
+		labels["tenant"] = tenant
+	}
+	return labels
+}
+
+// authenticationFailures reports the authentication failures by cause in
+// total and in the last hour, and the most recent failures
+func authenticationFailures(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	if err := json.NewEncoder(rw).Encode(failures.GetReport()); err != nil {
+		logger.Errorf("Error encoding authentication failures: %v", err)
+	}
+}
+
+// authenticationEventsKeepAlive is how often a comment is sent on idle event
+// streams, so that proxies in front of the admin API keep them open
+const authenticationEventsKeepAlive = 15 * time.Second
+
+// authenticationEvents streams the recent and new authentication and
+// authorization events, redacted, as server-sent events until the client
+// disconnects
+func authenticationEvents(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	recent, stream, cancel := events.Subscribe()
+	defer cancel()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	for _, event := range recent {
+		if err := writeAuthenticationEvent(rw, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(authenticationEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event := <-stream:
+			if err := writeAuthenticationEvent(rw, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeAuthenticationEvent writes an event as a server-sent event with JSON
+// data
+func writeAuthenticationEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("Error encoding authentication event: %v", err)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+func (p *OAuthProxy) checkRuleSetsRequest(rw http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		rw.Header().Set("Allow", method)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	if p.authorizationRules == nil {
+		http.Error(rw, "no deny rule sets are configured", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func (p *OAuthProxy) writeRuleSetsStatus(rw http.ResponseWriter) {
+	active, _ := p.authorizationRules.Active()
+	status := &ruleSetsStatus{
+		Active:   active,
+		Previous: p.authorizationRules.Previous(),
+		RuleSets: p.authorizationRules.Names(),
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	if err := json.NewEncoder(rw).Encode(status); err != nil {
+		logger.Errorf("Error encoding rule sets: %v", err)
+	}
+}
+
+// configDiff reports the options that differ from the defaults and from the
+// previous load, with secrets redacted
+func (p *OAuthProxy) configDiff(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if p.configReport == nil {
+		http.Error(rw, "no configuration report is available", http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	if err := json.NewEncoder(rw).Encode(p.configReport); err != nil {
+		logger.Errorf("Error encoding configuration report: %v", err)
+	}
+}
+
+// headersDryRun evaluates the header configuration against a synthetic
+// session and request without proxying anything
+func (p *OAuthProxy) headersDryRun(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	dryRun := &headersDryRunRequest{}
+	if err := json.NewDecoder(req.Body).Decode(dryRun); err != nil {
+		http.Error(rw, fmt.Sprintf("invalid dry run request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", applicationJSON)
+	err := json.NewEncoder(rw).Encode(p.headerInjectors.dryRun(dryRun.Session, dryRun.Headers))
+	if err != nil {
+		logger.Errorf("Error encoding headers dry run: %v", err)
+	}
+}
+
+// dryRun applies the header configuration in the same order as the headers
+// chain
+func (h *headerInjectors) dryRun(session *sessionsapi.SessionState, reqHeaders http.Header) *headersDryRunResponse {
+	headers := http.Header{}
+	for name, values := range reqHeaders {
+		headers[http.CanonicalHeaderKey(name)] = values
+	}
+
+	result := &headersDryRunResponse{
+		Request: headersDryRunResult{
+			Stripped: []string{},
+			Injected: http.Header{},
+		},
+		Response: headersDryRunResult{
+			Stripped: []string{},
+			Injected: http.Header{},
+		},
+	}
+
+	for _, name := range h.requestStripped {
+		if _, ok := headers[name]; ok {
+			result.Request.Stripped = append(result.Request.Stripped, name)
+			headers.Del(name)
+		}
+	}
+
+	h.request.Inject(result.Request.Injected, session)
+	h.request.Inject(headers, session)
+	for name, values := range headers {
+		if len(values) > 1 {
+			headers.Set(name, strings.Join(values, ","))
+		}
+	}
+	result.Request.Headers = headers
+
+	if session != nil {
+		// Set by addHeadersForProxying
+		if session.Email == "" {
+			result.Response.Injected.Set("GAP-Auth", session.User)
+		} else {
+			result.Response.Injected.Set("GAP-Auth", session.Email)
+		}
+	}
+	h.response.Inject(result.Response.Injected, session)
+
+	return result
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/admin"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// adminService serves the gRPC admin service, which manages the same
+// allowlists and deny rule sets as the admin API
+type adminService struct {
+	admin.UnimplementedAdminServer
+
+	proxy *OAuthProxy
+}
+
+// AdminService returns the gRPC admin service of the proxy
+func (p *OAuthProxy) AdminService() admin.AdminServer {
+	return &adminService{proxy: p}
+}
+
+// NewAdminGRPCServer constructs a gRPC server for the admin service that
+// requires clients to present a certificate signed by the client CA, and,
+// when allowed clients are configured, issued to one of them
+func NewAdminGRPCServer(opts *options.Options, service admin.AdminServer) (*grpc.Server, error) {
+	cert, err := tls.LoadX509KeyPair(opts.AdminGRPCTLSCertFile, opts.AdminGRPCTLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load admin gRPC certificate (%s, %s): %v", opts.AdminGRPCTLSCertFile, opts.AdminGRPCTLSKeyFile, err)
+	}
+	clientCAs, err := util.GetCertPool([]string{opts.AdminGRPCClientCAFile})
+	if err != nil {
+		return nil, fmt.Errorf("could not load admin gRPC client CA: %v", err)
+	}
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+	srv := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(authorizeAdminClients(opts.AdminGRPCClients)))
+	admin.RegisterAdminServer(srv, service)
+	return srv, nil
+}
+
+// authorizeAdminClients rejects calls from verified client certificates
+// whose common name and DNS names are not allowed.
+// All verified clients are allowed if none are configured.
+func authorizeAdminClients(allowed []string) grpc.UnaryServerInterceptor {
+	allowedClients := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		allowedClients[name] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		client, ok := adminClient(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "a verified client certificate is required")
+		}
+		if len(allowedClients) > 0 && !allowedClients[client.Subject.CommonName] && !containsAnyName(allowedClients, client.DNSNames) {
+			logger.Errorf("Admin gRPC service denied %s to client %q", info.FullMethod, client.Subject.CommonName)
+			return nil, status.Errorf(codes.PermissionDenied, "client %q is not allowed", client.Subject.CommonName)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func containsAnyName(names map[string]bool, values []string) bool {
+	for _, value := range values {
+		if names[value] {
+			return true
+		}
+	}
+	return false
+}
+
+// adminClient returns the verified client certificate of the call
+func adminClient(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return tlsInfo.State.VerifiedChains[0][0], true
+}
+
+func (s *adminService) ListAllowlist(_ context.Context, req *admin.ListAllowlistRequest) (*admin.AllowlistEntries, error) {
+	entries, err := s.allowlist(req.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+	return &admin.AllowlistEntries{Entries: entries.entries()}, nil
+}
+
+func (s *adminService) AddAllowlistEntry(_ context.Context, req *admin.AllowlistEntryRequest) (*admin.AllowlistEntries, error) {
+	entries, err := s.allowlist(req.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+	if req.Entry == "" {
+		return nil, status.Error(codes.InvalidArgument, "entry is required")
+	}
+	if err := entries.add(req.Entry); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: %v", entries.name, req.Entry, err)
+	}
+	logger.Printf("Admin gRPC service added %s to allowlist: %s", entries.name, req.Entry)
+	return &admin.AllowlistEntries{Entries: entries.entries()}, nil
+}
+
+func (s *adminService) RemoveAllowlistEntry(_ context.Context, req *admin.AllowlistEntryRequest) (*admin.AllowlistEntries, error) {
+	entries, err := s.allowlist(req.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+	if req.Entry == "" {
+		return nil, status.Error(codes.InvalidArgument, "entry is required")
+	}
+	removed, err := entries.remove(req.Entry)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: %v", entries.name, req.Entry, err)
+	}
+	if !removed {
+		return nil, status.Errorf(codes.NotFound, "%s %q is not in the allowlist", entries.name, req.Entry)
+	}
+	logger.Printf("Admin gRPC service removed %s from allowlist: %s", entries.name, req.Entry)
+	return &admin.AllowlistEntries{Entries: entries.entries()}, nil
+}
+
+// allowlist returns the allowlist that can be changed at runtime
+func (s *adminService) allowlist(allowlist admin.Allowlist) (*allowlistEntriesHandler, error) {
+	switch allowlist {
+	case admin.Allowlist_ALLOWLIST_ROUTES:
+		return s.proxy.routesAllowlistEntries(), nil
+	case admin.Allowlist_ALLOWLIST_TRUSTED_IPS:
+		return s.proxy.trustedIPsAllowlistEntries(), nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown allowlist %s", allowlist)
+	}
+}
+
+func (s *adminService) ListRules(_ context.Context, req *admin.ListRulesRequest) (*admin.Rules, error) {
+	name, engine, err := s.ruleSet(req.RuleSet)
+	if err != nil {
+		return nil, err
+	}
+	return newAdminRules(name, engine), nil
+}
+
+func (s *adminService) AddRule(_ context.Context, req *admin.AddRuleRequest) (*admin.Rules, error) {
+	name, engine, err := s.ruleSet(req.RuleSet)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := newAdminRule(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := engine.AddRule(rule); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	logger.Printf("Admin gRPC service added deny rule %s to rule set %q", rule, name)
+	return newAdminRules(name, engine), nil
+}
+
+func (s *adminService) RemoveRule(_ context.Context, req *admin.RemoveRuleRequest) (*admin.Rules, error) {
+	name, engine, err := s.ruleSet(req.RuleSet)
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if !engine.RemoveRule(req.Id) {
+		return nil, status.Errorf(codes.NotFound, "rule set %q has no rule %q", name, req.Id)
+	}
+	logger.Printf("Admin gRPC service removed deny rule %q from rule set %q", req.Id, name)
+	return newAdminRules(name, engine), nil
+}
+
+// ruleSet returns the named deny rule set, or the active rule set if the
+// name is empty
+func (s *adminService) ruleSet(name string) (string, *authorization.RulesEngine, error) {
+	ruleSets := s.proxy.authorizationRules
+	if ruleSets == nil {
+		return "", nil, status.Error(codes.FailedPrecondition, "no deny rule sets are configured")
+	}
+	if name == "" {
+		active, engine := ruleSets.Active()
+		return active, engine, nil
+	}
+	engine, err := ruleSets.Get(name)
+	if err != nil {
+		return "", nil, status.Error(codes.NotFound, err.Error())
+	}
+	return name, engine, nil
+}
+
+// newAdminRule builds the deny rule to add from the request
+func newAdminRule(req *admin.AddRuleRequest) (*authorization.Rule, error) {
+	if req.Id == "" {
+		return nil, errors.New("id is required")
+	}
+	if req.Path == "" && len(req.Ips) == 0 {
+		return nil, fmt.Errorf("rule %q must have a path or IPs", req.Id)
+	}
+	rule, err := authorization.NewRule(req.Id, authorization.DenyPolicy, req.Methods, req.Path, nil, req.Ips)
+	if err != nil {
+		return nil, err
+	}
+	rule.Description = req.Description
+	rule.Shadow = req.Shadow
+	if req.Action != "" {
+		if rule.Action, err = authorization.ParseAction(req.Action); err != nil {
+			return nil, err
+		}
+	}
+	return rule, nil
+}
+
+// newAdminRules lists the rules of the rule set in the order they are
+// currently checked
+func newAdminRules(name string, engine *authorization.RulesEngine) *admin.Rules {
+	rules := &admin.Rules{RuleSet: name}
+	for _, rule := range engine.Rules() {
+		rules.Rules = append(rules.Rules, &admin.Rule{
+			Id:          rule.ID,
+			Description: rule.Description,
+			Action:      rule.Action.String(),
+			Shadow:      rule.Shadow,
+			Hits:        rule.Hits(),
+		})
+	}
+	return rules
+}
+
+func (s *adminService) CheckRequest(_ context.Context, req *admin.CheckRequestRequest) (*admin.RulesCheck, error) {
+	checkReq, err := s.newCheckRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var session *sessionsapi.SessionState
+	if req.Session != nil {
+		session = &sessionsapi.SessionState{
+			User:              req.Session.User,
+			Email:             req.Session.Email,
+			Groups:            req.Session.Groups,
+			PreferredUsername: req.Session.PreferredUsername,
+		}
+	}
+
+	check := s.proxy.CheckRequest(checkReq, session)
+	return &admin.RulesCheck{
+		Decision:       check.Decision,
+		RuleSet:        check.RuleSet,
+		DenyRule:       check.DenyRule,
+		DenyAction:     check.DenyAction,
+		AllowlistEntry: check.AllowlistEntry,
+		SessionRules:   check.SessionRules,
+	}, nil
+}
+
+// newCheckRequest builds the request to check, as the proxy would receive
+// it. Behind a reverse proxy, the client IP is given in the real client IP
+// header.
+func (s *adminService) newCheckRequest(req *admin.CheckRequestRequest) (*http.Request, error) {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !strings.HasPrefix(req.Path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", req.Path)
+	}
+	checkReq, err := http.NewRequest(strings.ToUpper(method), "http://"+req.Host+req.Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	for name, value := range req.Headers {
+		checkReq.Header.Set(name, value)
+	}
+
+	if req.ClientIp != "" {
+		if net.ParseIP(req.ClientIp) == nil {
+			return nil, fmt.Errorf("invalid client IP %q", req.ClientIp)
+		}
+		checkReq.RemoteAddr = net.JoinHostPort(req.ClientIp, "0")
+		if s.proxy.realClientIPHeader != "" {
+			checkReq.Header.Set(s.proxy.realClientIPHeader, req.ClientIp)
+		}
+	}
+	return checkReq, nil
+}
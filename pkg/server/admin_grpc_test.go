@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/admin"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Admin gRPC service", func() {
+	var proxy *OAuthProxy
+	var service admin.AdminServer
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		routes := allowlist.NewRoutes()
+		Expect(routes.AddRoute("GET=^/health$")).To(Succeed())
+		ips := allowlist.NewIPs(nil)
+		Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+
+		denyAdmin, err := authorization.NewRule("deny-admin", authorization.DenyPolicy, nil, "^/admin/", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		ruleSets, err := authorization.NewRuleSets("blue", map[string]*authorization.RulesEngine{
+			"blue":  authorization.NewRulesEngine([]*authorization.Rule{denyAdmin}, nil),
+			"green": authorization.NewRulesEngine(nil, nil),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		proxy = &OAuthProxy{
+			skipAuthRoutes:     routes,
+			trustedIPs:         ips,
+			allowlists:         []allowlist.Allowlist{routes, ips},
+			authorizationRules: ruleSets,
+			upstreamSelector:   upstream.NewSelector(nil),
+		}
+		service = proxy.AdminService()
+	})
+
+	expectCode := func(err error, code codes.Code) {
+		Expect(err).To(HaveOccurred())
+		Expect(status.Code(err)).To(Equal(code))
+	}
+
+	Context("allowlists", func() {
+		It("lists, adds and removes routes", func() {
+			entries, err := service.ListAllowlist(ctx, &admin.ListAllowlistRequest{Allowlist: admin.Allowlist_ALLOWLIST_ROUTES})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries.Entries).To(Equal([]string{"GET=^/health$"}))
+
+			entry := &admin.AllowlistEntryRequest{Allowlist: admin.Allowlist_ALLOWLIST_ROUTES, Entry: "GET=^/metrics$"}
+			entries, err = service.AddAllowlistEntry(ctx, entry)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries.Entries).To(Equal([]string{"GET=^/health$", "GET=^/metrics$"}))
+
+			entries, err = service.RemoveAllowlistEntry(ctx, entry)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries.Entries).To(Equal([]string{"GET=^/health$"}))
+
+			_, err = service.RemoveAllowlistEntry(ctx, entry)
+			expectCode(err, codes.NotFound)
+		})
+
+		It("adds trusted IPs", func() {
+			entries, err := service.AddAllowlistEntry(ctx, &admin.AllowlistEntryRequest{Allowlist: admin.Allowlist_ALLOWLIST_TRUSTED_IPS, Entry: "192.168.0.1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries.Entries).To(ConsistOf("10.0.0.0/8", "192.168.0.1/32"))
+		})
+
+		DescribeTable("rejects invalid requests",
+			func(req *admin.AllowlistEntryRequest) {
+				_, err := service.AddAllowlistEntry(ctx, req)
+				expectCode(err, codes.InvalidArgument)
+			},
+			Entry("without an allowlist", &admin.AllowlistEntryRequest{Entry: "GET=^/metrics$"}),
+			Entry("without an entry", &admin.AllowlistEntryRequest{Allowlist: admin.Allowlist_ALLOWLIST_ROUTES}),
+			Entry("with an invalid route", &admin.AllowlistEntryRequest{Allowlist: admin.Allowlist_ALLOWLIST_ROUTES, Entry: "GET=^/(metrics$"}),
+			Entry("with an invalid IP", &admin.AllowlistEntryRequest{Allowlist: admin.Allowlist_ALLOWLIST_TRUSTED_IPS, Entry: "not-an-ip"}),
+		)
+	})
+
+	Context("deny rules", func() {
+		ruleIDs := func(rules *admin.Rules) []string {
+			ids := []string{}
+			for _, rule := range rules.Rules {
+				ids = append(ids, rule.Id)
+			}
+			return ids
+		}
+
+		It("lists the rules of the active rule set", func() {
+			rules, err := service.ListRules(ctx, &admin.ListRulesRequest{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rules.RuleSet).To(Equal("blue"))
+			Expect(rules.Rules).To(HaveLen(1))
+			Expect(rules.Rules[0].Id).To(Equal("deny-admin"))
+			Expect(rules.Rules[0].Action).To(Equal("error_page"))
+		})
+
+		It("adds and removes rules", func() {
+			rules, err := service.AddRule(ctx, &admin.AddRuleRequest{
+				Id:          "deny-debug",
+				Description: "debug endpoints",
+				Methods:     []string{"get"},
+				Path:        "^/debug/",
+				Action:      "forbidden",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ruleIDs(rules)).To(Equal([]string{"deny-admin", "deny-debug"}))
+			Expect(rules.Rules[1].Description).To(Equal("debug endpoints"))
+			Expect(rules.Rules[1].Action).To(Equal("forbidden"))
+
+			_, err = service.AddRule(ctx, &admin.AddRuleRequest{Id: "deny-debug", Path: "^/pprof/"})
+			expectCode(err, codes.AlreadyExists)
+
+			rules, err = service.RemoveRule(ctx, &admin.RemoveRuleRequest{Id: "deny-admin"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ruleIDs(rules)).To(Equal([]string{"deny-debug"}))
+
+			_, err = service.RemoveRule(ctx, &admin.RemoveRuleRequest{Id: "deny-admin"})
+			expectCode(err, codes.NotFound)
+		})
+
+		It("adds rules to other rule sets", func() {
+			rules, err := service.AddRule(ctx, &admin.AddRuleRequest{RuleSet: "green", Id: "deny-office", Ips: []string{"192.168.0.0/16"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rules.RuleSet).To(Equal("green"))
+			Expect(ruleIDs(rules)).To(Equal([]string{"deny-office"}))
+
+			_, err = service.ListRules(ctx, &admin.ListRulesRequest{RuleSet: "red"})
+			expectCode(err, codes.NotFound)
+		})
+
+		DescribeTable("rejects invalid rules",
+			func(req *admin.AddRuleRequest) {
+				_, err := service.AddRule(ctx, req)
+				expectCode(err, codes.InvalidArgument)
+			},
+			Entry("without an ID", &admin.AddRuleRequest{Path: "^/debug/"}),
+			Entry("without a path or IPs", &admin.AddRuleRequest{Id: "deny-all", Methods: []string{"GET"}}),
+			Entry("with an invalid path", &admin.AddRuleRequest{Id: "deny-debug", Path: "^/(debug/"}),
+			Entry("with an invalid IP", &admin.AddRuleRequest{Id: "deny-office", Ips: []string{"not-an-ip"}}),
+			Entry("with an unknown action", &admin.AddRuleRequest{Id: "deny-debug", Path: "^/debug/", Action: "teapot"}),
+		)
+
+		It("fails without rule sets", func() {
+			proxy.authorizationRules = nil
+			_, err := service.ListRules(ctx, &admin.ListRulesRequest{})
+			expectCode(err, codes.FailedPrecondition)
+		})
+	})
+
+	Context("request checks", func() {
+		DescribeTable("decides how requests are handled",
+			func(req *admin.CheckRequestRequest, expected *admin.RulesCheck) {
+				check, err := service.CheckRequest(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(check.Decision).To(Equal(expected.Decision))
+				Expect(check.RuleSet).To(Equal(expected.RuleSet))
+				Expect(check.DenyRule).To(Equal(expected.DenyRule))
+				Expect(check.AllowlistEntry).To(Equal(expected.AllowlistEntry))
+			},
+			Entry("denied by a rule", &admin.CheckRequestRequest{Host: "app.example.com", Path: "/admin/users"},
+				&admin.RulesCheck{Decision: DenyDecision, RuleSet: "blue", DenyRule: `"deny-admin"`}),
+			Entry("trusted by a route", &admin.CheckRequestRequest{Host: "app.example.com", Path: "/health"},
+				&admin.RulesCheck{Decision: SkipAuthDecision, RuleSet: "blue", AllowlistEntry: "route GET=^/health$"}),
+			Entry("trusted by an IP", &admin.CheckRequestRequest{Host: "app.example.com", Path: "/", ClientIp: "10.1.2.3"},
+				&admin.RulesCheck{Decision: SkipAuthDecision, RuleSet: "blue", AllowlistEntry: "trusted IP 10.1.2.3"}),
+			Entry("without a session", &admin.CheckRequestRequest{Host: "app.example.com", Path: "/"},
+				&admin.RulesCheck{Decision: AuthenticateDecision, RuleSet: "blue"}),
+			Entry("with a session", &admin.CheckRequestRequest{Host: "app.example.com", Path: "/", Session: &admin.Session{Email: "jane.doe@example.com"}},
+				&admin.RulesCheck{Decision: AllowDecision, RuleSet: "blue"}),
+		)
+
+		It("uses the rules added at runtime", func() {
+			_, err := service.AddRule(ctx, &admin.AddRuleRequest{Id: "deny-debug", Path: "^/debug/", Action: "json"})
+			Expect(err).ToNot(HaveOccurred())
+
+			check, err := service.CheckRequest(ctx, &admin.CheckRequestRequest{Method: "post", Host: "app.example.com", Path: "/debug/vars"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(check.Decision).To(Equal(DenyDecision))
+			Expect(check.DenyRule).To(Equal(`"deny-debug"`))
+			Expect(check.DenyAction).To(Equal("json"))
+		})
+
+		It("sets the real client IP header behind a reverse proxy", func() {
+			proxy.realClientIPHeader = "X-Forwarded-For"
+			req, err := service.(*adminService).newCheckRequest(&admin.CheckRequestRequest{Host: "app.example.com", Path: "/", ClientIp: "10.1.2.3"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(req.Header.Get("X-Forwarded-For")).To(Equal("10.1.2.3"))
+			Expect(req.RemoteAddr).To(Equal("10.1.2.3:0"))
+		})
+
+		DescribeTable("rejects invalid requests",
+			func(req *admin.CheckRequestRequest) {
+				_, err := service.CheckRequest(ctx, req)
+				expectCode(err, codes.InvalidArgument)
+			},
+			Entry("with a relative path", &admin.CheckRequestRequest{Host: "app.example.com", Path: "admin"}),
+			Entry("with an invalid client IP", &admin.CheckRequestRequest{Host: "app.example.com", Path: "/", ClientIp: "not-an-ip"}),
+		)
+	})
+
+	Context("mutual TLS", func() {
+		var dir string
+		var opts *options.Options
+		var ca *testCA
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "admin-grpc")
+			Expect(err).ToNot(HaveOccurred())
+
+			ca = newTestCA()
+			ca.writeCertificate(filepath.Join(dir, "ca.crt"))
+			ca.issue("127.0.0.1").write(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+
+			opts = &options.Options{
+				AdminGRPCTLSCertFile:  filepath.Join(dir, "server.crt"),
+				AdminGRPCTLSKeyFile:   filepath.Join(dir, "server.key"),
+				AdminGRPCClientCAFile: filepath.Join(dir, "ca.crt"),
+				AdminGRPCClients:      []string{"ops-tool"},
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		listAllowlist := func(clientCerts ...tls.Certificate) error {
+			srv, err := NewAdminGRPCServer(opts, service)
+			Expect(err).ToNot(HaveOccurred())
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			go func() {
+				_ = srv.Serve(listener)
+			}()
+			defer srv.Stop()
+
+			creds := credentials.NewTLS(&tls.Config{
+				Certificates: clientCerts,
+				RootCAs:      ca.pool(),
+				ServerName:   "127.0.0.1",
+				MinVersion:   tls.VersionTLS12,
+			})
+			dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			conn, err := grpc.DialContext(dialCtx, listener.Addr().String(), grpc.WithTransportCredentials(creds))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			_, err = admin.NewAdminClient(conn).ListAllowlist(dialCtx, &admin.ListAllowlistRequest{Allowlist: admin.Allowlist_ALLOWLIST_ROUTES})
+			return err
+		}
+
+		It("serves allowed clients", func() {
+			Expect(listAllowlist(ca.issue("ops-tool").tlsCertificate())).To(Succeed())
+		})
+
+		It("denies other clients signed by the CA", func() {
+			expectCode(listAllowlist(ca.issue("other-tool").tlsCertificate()), codes.PermissionDenied)
+		})
+
+		It("serves all clients signed by the CA without allowed clients", func() {
+			opts.AdminGRPCClients = nil
+			Expect(listAllowlist(ca.issue("other-tool").tlsCertificate())).To(Succeed())
+		})
+
+		It("rejects clients without a certificate", func() {
+			Expect(listAllowlist()).ToNot(Succeed())
+		})
+
+		It("rejects clients signed by another CA", func() {
+			Expect(listAllowlist(newTestCA().issue("ops-tool").tlsCertificate())).ToNot(Succeed())
+		})
+	})
+})
+
+// testCA issues certificates for the mutual TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// testCertificate is a certificate issued by a testCA
+type testCertificate struct {
+	der []byte
+	key *ecdsa.PrivateKey
+}
+
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "admin CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return &testCA{cert: cert, key: key}
+}
+
+// issue issues a certificate for the name, as the common name and, for an
+// IP address, as an IP SAN
+func (ca *testCA) issue(name string) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	Expect(err).ToNot(HaveOccurred())
+	return &testCertificate{der: der, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) writeCertificate(certFile string) {
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)).To(Succeed())
+}
+
+func (c *testCertificate) write(certFile, keyFile string) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(c.key)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)).To(Succeed())
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
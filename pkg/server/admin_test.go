@@ -0,0 +1,409 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/events"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin API", func() {
+	const adminToken = "admin-token"
+
+	adminRequest := func(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	Context("authentication", func() {
+		DescribeTable("rejects requests without the admin token",
+			func(proxyToken, authorization string, expectedCode int) {
+				proxy := &OAuthProxy{
+					adminToken:     proxyToken,
+					skipAuthRoutes: allowlist.NewRoutes(),
+					trustedIPs:     allowlist.NewIPs(nil),
+				}
+				req := httptest.NewRequest("GET", "/allowlist/routes", nil)
+				if authorization != "" {
+					req.Header.Set("Authorization", authorization)
+				}
+				rw := httptest.NewRecorder()
+				proxy.AdminHandler().ServeHTTP(rw, req)
+				Expect(rw.Code).To(Equal(expectedCode))
+			},
+			Entry("with the token", adminToken, "Bearer "+adminToken, http.StatusOK),
+			Entry("without a token", adminToken, "", http.StatusUnauthorized),
+			Entry("with the wrong token", adminToken, "Bearer wrong", http.StatusUnauthorized),
+			Entry("with no configured token", "", "Bearer ", http.StatusUnauthorized),
+			Entry("with a lower case scheme", adminToken, "bearer "+adminToken, http.StatusOK),
+			Entry("without a scheme", adminToken, adminToken, http.StatusUnauthorized),
+			Entry("with another scheme", adminToken, "Basic "+adminToken, http.StatusUnauthorized),
+		)
+	})
+
+	Context("allowlist entries", func() {
+		var handler http.Handler
+		var routes *allowlist.Routes
+		var ips *allowlist.IPs
+
+		BeforeEach(func() {
+			routes = allowlist.NewRoutes()
+			Expect(routes.AddRoute("GET=^/health$")).To(Succeed())
+			ips = allowlist.NewIPs(nil)
+			Expect(ips.Add("10.0.0.0/8")).To(Succeed())
+
+			handler = (&OAuthProxy{
+				adminToken:     adminToken,
+				skipAuthRoutes: routes,
+				trustedIPs:     ips,
+			}).AdminHandler()
+		})
+
+		entries := func(rw *httptest.ResponseRecorder) []string {
+			result := &allowlistEntries{}
+			Expect(json.Unmarshal(rw.Body.Bytes(), result)).To(Succeed())
+			return result.Entries
+		}
+
+		It("lists the routes", func() {
+			rw := adminRequest(handler, "GET", "/allowlist/routes", "")
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(entries(rw)).To(Equal([]string{"GET=^/health$"}))
+		})
+
+		It("adds and removes routes", func() {
+			rw := adminRequest(handler, "POST", "/allowlist/routes", `{"entry": "post=^/webhook$"}`)
+			Expect(rw.Code).To(Equal(http.StatusCreated))
+			Expect(entries(rw)).To(Equal([]string{"GET=^/health$", "POST=^/webhook$"}))
+
+			_, trusted := routes.IsTrusted(httptest.NewRequest("POST", "/webhook", nil))
+			Expect(trusted).To(BeTrue())
+
+			rw = adminRequest(handler, "DELETE", "/allowlist/routes", `{"entry": "GET=^/health$"}`)
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(entries(rw)).To(Equal([]string{"POST=^/webhook$"}))
+
+			_, trusted = routes.IsTrusted(httptest.NewRequest("GET", "/health", nil))
+			Expect(trusted).To(BeFalse())
+		})
+
+		It("adds and removes trusted IPs", func() {
+			rw := adminRequest(handler, "POST", "/allowlist/ips", `{"entry": "192.168.1.1"}`)
+			Expect(rw.Code).To(Equal(http.StatusCreated))
+			Expect(entries(rw)).To(Equal([]string{"10.0.0.0/8", "192.168.1.1/32"}))
+
+			rw = adminRequest(handler, "DELETE", "/allowlist/ips", `{"entry": "10.0.0.0/8"}`)
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(entries(rw)).To(Equal([]string{"192.168.1.1/32"}))
+		})
+
+		DescribeTable("rejects invalid requests",
+			func(method, path, body string, expectedCode int) {
+				rw := adminRequest(handler, method, path, body)
+				Expect(rw.Code).To(Equal(expectedCode))
+			},
+			Entry("invalid route regex", "POST", "/allowlist/routes", `{"entry": "GET=(/foo"}`, http.StatusBadRequest),
+			Entry("invalid IP", "POST", "/allowlist/ips", `{"entry": "not-an-ip"}`, http.StatusBadRequest),
+			Entry("missing entry", "POST", "/allowlist/ips", `{}`, http.StatusBadRequest),
+			Entry("invalid JSON", "DELETE", "/allowlist/routes", `entry`, http.StatusBadRequest),
+			Entry("unknown route", "DELETE", "/allowlist/routes", `{"entry": "^/unknown$"}`, http.StatusNotFound),
+			Entry("unknown IP", "DELETE", "/allowlist/ips", `{"entry": "127.0.0.1"}`, http.StatusNotFound),
+			Entry("unsupported method", "PUT", "/allowlist/ips", `{"entry": "127.0.0.1"}`, http.StatusMethodNotAllowed),
+		)
+	})
+
+	Context("deny rule sets", func() {
+		var handler http.Handler
+		var ruleSets *authorization.RuleSets
+
+		BeforeEach(func() {
+			var err error
+			ruleSets, err = authorization.NewRuleSets("blue", map[string]*authorization.RulesEngine{
+				"blue":  authorization.NewRulesEngine(nil, nil),
+				"green": authorization.NewRulesEngine(nil, nil),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			handler = (&OAuthProxy{
+				adminToken:         adminToken,
+				skipAuthRoutes:     allowlist.NewRoutes(),
+				trustedIPs:         allowlist.NewIPs(nil),
+				authorizationRules: ruleSets,
+			}).AdminHandler()
+		})
+
+		status := func(rw *httptest.ResponseRecorder) *ruleSetsStatus {
+			result := &ruleSetsStatus{}
+			Expect(json.Unmarshal(rw.Body.Bytes(), result)).To(Succeed())
+			return result
+		}
+
+		It("lists the rule sets", func() {
+			rw := adminRequest(handler, "GET", "/authorization/rule-sets", "")
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(status(rw)).To(Equal(&ruleSetsStatus{Active: "blue", RuleSets: []string{"blue", "green"}}))
+		})
+
+		It("switches and rolls back the active rule set", func() {
+			rw := adminRequest(handler, "PUT", "/authorization/rule-sets/active", `{"name": "green"}`)
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(status(rw)).To(Equal(&ruleSetsStatus{Active: "green", Previous: "blue", RuleSets: []string{"blue", "green"}}))
+			active, _ := ruleSets.Active()
+			Expect(active).To(Equal("green"))
+
+			rw = adminRequest(handler, "POST", "/authorization/rule-sets/rollback", "")
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(status(rw)).To(Equal(&ruleSetsStatus{Active: "blue", Previous: "green", RuleSets: []string{"blue", "green"}}))
+			active, _ = ruleSets.Active()
+			Expect(active).To(Equal("blue"))
+		})
+
+		DescribeTable("rejects invalid requests",
+			func(method, path, body string, expectedCode int) {
+				rw := adminRequest(handler, method, path, body)
+				Expect(rw.Code).To(Equal(expectedCode))
+			},
+			Entry("unknown rule set", "PUT", "/authorization/rule-sets/active", `{"name": "red"}`, http.StatusNotFound),
+			Entry("invalid JSON", "PUT", "/authorization/rule-sets/active", `name`, http.StatusBadRequest),
+			Entry("rollback without a previous rule set", "POST", "/authorization/rule-sets/rollback", "", http.StatusConflict),
+			Entry("unsupported method", "POST", "/authorization/rule-sets", "", http.StatusMethodNotAllowed),
+		)
+
+		It("exposes the rule hits as metrics", func() {
+			rule, err := authorization.NewRule("deny-admin", authorization.DenyPolicy, nil, "^/admin/", nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, engine := ruleSets.Active()
+			engine.SetRules([]*authorization.Rule{rule})
+			Expect(engine.Deny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeTrue())
+
+			rw := adminRequest(handler, "GET", "/authorization/metrics", "")
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(rw.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+			Expect(rw.Body.String()).To(ContainSubstring(`oauth2_proxy_authorization_rule_hits_total{policy="deny",rule="deny-admin",rule_set="blue",shadow="false"} 1` + "\n"))
+		})
+
+		It("responds not found without rule sets", func() {
+			handler = (&OAuthProxy{adminToken: adminToken}).AdminHandler()
+			rw := adminRequest(handler, "GET", "/authorization/rule-sets", "")
+			Expect(rw.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	It("exposes the session compression and size metrics", func() {
+		handler := (&OAuthProxy{adminToken: adminToken}).AdminHandler()
+		rw := adminRequest(handler, "GET", "/sessions/metrics", "")
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(ContainSubstring("# TYPE oauth2_proxy_session_encodings_total counter\n"))
+		Expect(rw.Body.String()).To(ContainSubstring("# TYPE oauth2_proxy_session_size_bytes histogram\n"))
+	})
+
+	It("labels the metrics with the provider and tenant", func() {
+		handler := (&OAuthProxy{adminToken: adminToken, metricLabels: providerMetricLabels("oidc", "team-a")}).AdminHandler()
+		rw := adminRequest(handler, "GET", "/authentication/metrics", "")
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(ContainSubstring(`oauth2_proxy_authentication_failures_total{cause="csrf_mismatch",provider="oidc",tenant="team-a"} `))
+		Expect(rw.Body.String()).To(ContainSubstring("# TYPE oauth2_proxy_authentication_failures_total counter\n"))
+	})
+
+	It("reports the authentication failures", func() {
+		handler := (&OAuthProxy{adminToken: adminToken}).AdminHandler()
+		rw := adminRequest(handler, "GET", "/authentication/failures", "")
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(ContainSubstring(`"lastHour":{`))
+
+		rw = adminRequest(handler, "GET", "/authentication/metrics", "")
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(ContainSubstring(`oauth2_proxy_authentication_failures_total{cause="csrf_mismatch"} `))
+	})
+
+	It("reports the memory guard metrics", func() {
+		handler := (&OAuthProxy{adminToken: adminToken}).AdminHandler()
+		rw := adminRequest(handler, "GET", "/memory/metrics", "")
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(ContainSubstring("oauth2_proxy_memory_pressure 0\n"))
+		Expect(rw.Body.String()).To(ContainSubstring(`oauth2_proxy_memory_rejections_total{subsystem="response_body"} `))
+	})
+
+	It("streams the recent authentication events", func() {
+		events.Publish(logger.AuthEvent{
+			Username: "alice@example.com",
+			Status:   logger.AuthSuccess,
+			Message:  "Authenticated via HtpasswdFile",
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("GET", "/authentication/events", nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		rw := httptest.NewRecorder()
+		(&OAuthProxy{adminToken: adminToken}).AdminHandler().ServeHTTP(rw, req)
+
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+		Expect(rw.Body.String()).To(ContainSubstring(`"status":"AuthSuccess","username":"a***@example.com"`))
+		Expect(rw.Body.String()).To(HaveSuffix("}\n\n"))
+	})
+
+	Context("configuration", func() {
+		It("reports the configuration changes", func() {
+			report := &options.ConfigReport{
+				Defaults: []options.ConfigChange{{Option: "provider", From: "google", To: "oidc"}},
+			}
+			handler := (&OAuthProxy{adminToken: adminToken, configReport: report}).AdminHandler()
+
+			rw := adminRequest(handler, "GET", "/config", "")
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(rw.Body.String()).To(MatchJSON(`{"defaults": [{"option": "provider", "from": "google", "to": "oidc"}], "previous": null}`))
+		})
+
+		It("responds not found without a report", func() {
+			handler := (&OAuthProxy{adminToken: adminToken}).AdminHandler()
+			rw := adminRequest(handler, "GET", "/config", "")
+			Expect(rw.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("only allows GET requests", func() {
+			handler := (&OAuthProxy{adminToken: adminToken, configReport: &options.ConfigReport{}}).AdminHandler()
+			rw := adminRequest(handler, "POST", "/config", "")
+			Expect(rw.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("headers dry run", func() {
+		var handler http.Handler
+
+		BeforeEach(func() {
+			injectors, err := newHeaderInjectors(&options.Options{
+				InjectRequestHeaders: []options.Header{
+					{
+						Name: "X-Forwarded-User",
+						Values: []options.HeaderValue{
+							{ClaimSource: &options.ClaimSource{Claim: "user"}},
+						},
+					},
+					{
+						Name:                 "X-Forwarded-Groups",
+						PreserveRequestValue: true,
+						Values: []options.HeaderValue{
+							{ClaimSource: &options.ClaimSource{Claim: "groups"}},
+						},
+					},
+				},
+				InjectResponseHeaders: []options.Header{
+					{
+						Name: "X-Auth-Request-Email",
+						Values: []options.HeaderValue{
+							{ClaimSource: &options.ClaimSource{Claim: "email"}},
+						},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			handler = (&OAuthProxy{
+				adminToken:      adminToken,
+				headerInjectors: injectors,
+			}).AdminHandler()
+		})
+
+		dryRun := func(body string) (*httptest.ResponseRecorder, *headersDryRunResponse) {
+			rw := adminRequest(handler, "POST", "/headers/dry-run", body)
+
+			result := &headersDryRunResponse{}
+			if rw.Code == http.StatusOK {
+				Expect(json.Unmarshal(rw.Body.Bytes(), result)).To(Succeed())
+			}
+			return rw, result
+		}
+
+		It("reports the headers that would be stripped and injected", func() {
+			rw, result := dryRun(`{
+				"session": {"user": "alice", "email": "alice@example.com", "groups": ["admins", "devs"]},
+				"headers": {"x-forwarded-user": ["mallory"], "X-Forwarded-Groups": ["existing"], "Accept": ["text/html"]}
+			}`)
+			Expect(rw.Code).To(Equal(http.StatusOK))
+
+			Expect(result.Request.Stripped).To(Equal([]string{"X-Forwarded-User"}))
+			Expect(result.Request.Injected).To(Equal(http.Header{
+				"X-Forwarded-User":   []string{"alice"},
+				"X-Forwarded-Groups": []string{"admins", "devs"},
+			}))
+			Expect(result.Request.Headers).To(Equal(http.Header{
+				"X-Forwarded-User":   []string{"alice"},
+				"X-Forwarded-Groups": []string{"existing,admins,devs"},
+				"Accept":             []string{"text/html"},
+			}))
+			Expect(result.Response.Injected).To(Equal(http.Header{
+				"Gap-Auth":             []string{"alice@example.com"},
+				"X-Auth-Request-Email": []string{"alice@example.com"},
+			}))
+		})
+
+		It("injects no session headers without a session", func() {
+			rw, result := dryRun(`{"headers": {"X-Forwarded-User": ["mallory"]}}`)
+			Expect(rw.Code).To(Equal(http.StatusOK))
+
+			Expect(result.Request.Stripped).To(Equal([]string{"X-Forwarded-User"}))
+			Expect(result.Request.Injected).To(BeEmpty())
+			Expect(result.Response.Injected).To(BeEmpty())
+		})
+
+		It("rejects invalid requests", func() {
+			rw, _ := dryRun(`{"session": "alice"}`)
+			Expect(rw.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("only allows POST requests", func() {
+			rw := adminRequest(handler, "GET", "/headers/dry-run", "")
+			Expect(rw.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("request metrics", func() {
+		It("reports the requests by route template", func() {
+			requestMetrics, err := middleware.NewRequestMetrics([]string{"/oauth2/sign_in", "/api/users/{id}"})
+			Expect(err).ToNot(HaveOccurred())
+			served := requestMetrics.Handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}))
+			served.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users/42", nil))
+			served.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users/43", nil))
+
+			handler := (&OAuthProxy{adminToken: adminToken, requestMetrics: requestMetrics}).AdminHandler()
+			rw := adminRequest(handler, "GET", "/requests/metrics", "")
+			Expect(rw.Code).To(Equal(http.StatusOK))
+			Expect(rw.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+			Expect(rw.Body.String()).To(ContainSubstring(`oauth2_proxy_requests_total{code="200",method="GET",route="/api/users/{id}"} 2` + "\n"))
+			Expect(rw.Body.String()).ToNot(ContainSubstring(`route="/api/users/42"`))
+		})
+
+		It("only allows GET requests", func() {
+			requestMetrics, err := middleware.NewRequestMetrics(nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler := (&OAuthProxy{adminToken: adminToken, requestMetrics: requestMetrics}).AdminHandler()
+			rw := adminRequest(handler, "POST", "/requests/metrics", "")
+			Expect(rw.Code).To(Equal(http.StatusMethodNotAllowed))
+			Expect(rw.Header().Get("Allow")).To(Equal("GET"))
+		})
+
+		It("responds not found without request metrics", func() {
+			handler := (&OAuthProxy{adminToken: adminToken}).AdminHandler()
+			rw := adminRequest(handler, "GET", "/requests/metrics", "")
+			Expect(rw.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+)
+
+const (
+	// handoffExpiry is how long a handoff code issued by the auth domain may
+	// be exchanged for a session cookie
+	handoffExpiry = time.Minute
+
+	// handoffCodeParam is the query parameter of the handoff code
+	handoffCodeParam = "code"
+
+	// handoffNonceParam is the query parameter of the nonce that binds a
+	// handoff to the browser that started the sign in
+	handoffNonceParam = "nonce"
+)
+
+// authDomain is the central domain that handles the OAuth2 flow for the
+// applications on other domains, and hands the session off to them
+type authDomain struct {
+	host   string
+	cipher encryption.Cipher
+	codes  sessionsapi.CodeStore
+}
+
+func newAuthDomain(opts *options.Options) (*authDomain, error) {
+	if opts.AuthDomain == "" {
+		return nil, nil
+	}
+	cipher, err := encryption.NewCFBCipher(encryption.SecretBytes(opts.Cookie.Secret))
+	if err != nil {
+		return nil, fmt.Errorf("error initialising auth domain handoff cipher: %v", err)
+	}
+	integrity, err := sessionsapi.NewIntegrityCheck([]byte(opts.Cookie.Secret), opts.Session.IntegrityStrict)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := sessions.NewCodeStore(&opts.Session, opts.Cookie.Name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("error initialising auth domain handoff codes: %v", err)
+	}
+	return &authDomain{
+		host:   strings.ToLower(opts.AuthDomain),
+		cipher: sessionsapi.WithIntegrityCheck(cipher, integrity),
+		codes:  codes,
+	}, nil
+}
+
+// handles checks whether the request was made to the auth domain
+func (a *authDomain) handles(req *http.Request) bool {
+	return requestHostname(req) == a.host
+}
+
+// handoff is what is stored under a handoff code: the encrypted session, and
+// the nonce the application set in a cookie when the sign in started
+type handoff struct {
+	Nonce   string `json:"n"`
+	Session []byte `json:"s"`
+}
+
+// handoffKey binds a handoff code to the host it is issued for, so that it
+// cannot be exchanged on another application
+func (a *authDomain) handoffKey(host string, code string) string {
+	return fmt.Sprintf("handoff:%s:%s", host, code)
+}
+
+// newHandoffCode stores the encrypted session for the host and the nonce
+// under a new random code, which can be exchanged for the session once
+func (a *authDomain) newHandoffCode(ctx context.Context, host string, nonce string, session *sessionsapi.SessionState) (string, error) {
+	encoded, err := session.EncodeSessionState(a.cipher, true)
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(handoff{Nonce: nonce, Session: encoded})
+	if err != nil {
+		return "", err
+	}
+	code, err := encryption.Nonce()
+	if err != nil {
+		return "", err
+	}
+	if err := a.codes.Save(ctx, a.handoffKey(host, code), value, handoffExpiry); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// exchangeHandoffCode takes the session stored under a handoff code issued
+// for the host. The nonce must be the one the code was issued with, so that
+// a code can only be exchanged by the browser that started the sign in.
+func (a *authDomain) exchangeHandoffCode(ctx context.Context, host string, code string, nonce string) (*sessionsapi.SessionState, error) {
+	if code == "" {
+		return nil, errors.New("handoff code is missing")
+	}
+	if nonce == "" {
+		return nil, errors.New("handoff nonce cookie is missing")
+	}
+	value, err := a.codes.Take(ctx, a.handoffKey(host, code))
+	if err == sessionsapi.ErrCodeNotFound {
+		return nil, errors.New("handoff code is invalid, has expired or was already used")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h := handoff{}
+	if err := json.Unmarshal(value, &h); err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(h.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("handoff was started by another browser")
+	}
+	return sessionsapi.DecodeSessionState(h.Session, a.cipher, true)
+}
+
+// handoffCookieName is the name of the cookie of the handoff nonce
+func (p *OAuthProxy) handoffCookieName() string {
+	return fmt.Sprintf("%s_handoff", p.CookieName)
+}
+
+// makeHandoffCookie creates the cookie of the nonce that binds a handoff to
+// the browser that started the sign in. It has no domain, so that only the
+// application that set it receives it.
+func (p *OAuthProxy) makeHandoffCookie(req *http.Request, value string, expiration time.Duration, now time.Time) *http.Cookie {
+	cookie := p.makeCookie(req, p.handoffCookieName(), value, expiration, now)
+	cookie.Domain = ""
+	return cookie
+}
+
+// redirectToAuthDomain sends a user signing in to an application to the auth
+// domain, which redirects back to the handoff endpoint of the application
+// once they are authenticated. The nonce of the handoff is set in a cookie
+// and passed along with the redirect.
+func (p *OAuthProxy) redirectToAuthDomain(rw http.ResponseWriter, req *http.Request, redirect string) {
+	nonce, err := encryption.Nonce()
+	if err != nil {
+		logger.Errorf("Error obtaining nonce: %v", err)
+		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	http.SetCookie(rw, p.makeHandoffCookie(req, nonce, p.CookieExpire, time.Now()))
+
+	handoff := url.URL{
+		Scheme: requestScheme(req),
+		Host:   requestutil.GetRequestHost(req),
+		Path:   p.HandoffPath,
+		RawQuery: url.Values{
+			"rd":              {redirect},
+			handoffNonceParam: {nonce},
+		}.Encode(),
+	}
+	signIn := url.URL{
+		Scheme:   requestScheme(req),
+		Host:     p.authDomain.host,
+		Path:     p.SignInPath,
+		RawQuery: url.Values{"rd": {handoff.String()}}.Encode(),
+	}
+	http.Redirect(rw, req, signIn.String(), http.StatusFound)
+}
+
+// handoffRedirect returns the handoff endpoint of the application the user
+// signed in for with a handoff code. The redirect is returned unchanged if it
+// is not the handoff endpoint with the nonce of an application that started
+// the sign in, such as a redirect on the auth domain.
+func (p *OAuthProxy) handoffRedirect(ctx context.Context, session *sessionsapi.SessionState, redirect string) (string, error) {
+	redirectURL, err := url.Parse(redirect)
+	if err != nil || redirectURL.Host == "" || strings.ToLower(redirectURL.Hostname()) == p.authDomain.host {
+		return redirect, nil
+	}
+	query := redirectURL.Query()
+	nonce := query.Get(handoffNonceParam)
+	if redirectURL.Path != p.HandoffPath || nonce == "" {
+		return redirect, nil
+	}
+
+	code, err := p.authDomain.newHandoffCode(ctx, strings.ToLower(redirectURL.Hostname()), nonce, session)
+	if err != nil {
+		return "", fmt.Errorf("error creating handoff code: %v", err)
+	}
+	handoff := url.URL{
+		Scheme: redirectURL.Scheme,
+		Host:   redirectURL.Host,
+		Path:   p.HandoffPath,
+		RawQuery: url.Values{
+			"rd":             {query.Get("rd")},
+			handoffCodeParam: {code},
+		}.Encode(),
+	}
+	return handoff.String(), nil
+}
+
+// Handoff exchanges a handoff code issued by the auth domain for a session
+// cookie of the application, if the nonce cookie set when the sign in started
+// matches the code.
+// The handoff endpoint only ever redirects while the code is in its URL, so
+// that the code is not left in a rendered page.
+func (p *OAuthProxy) Handoff(rw http.ResponseWriter, req *http.Request) {
+	if _, ok := req.URL.Query()[handoffCodeParam]; !ok {
+		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "Invalid handoff")
+		return
+	}
+	prepareNoCache(rw)
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+
+	nonce := ""
+	if c, err := req.Cookie(p.handoffCookieName()); err == nil {
+		nonce = c.Value
+		http.SetCookie(rw, p.makeHandoffCookie(req, "", time.Hour*-1, time.Now()))
+	}
+
+	session, err := p.authDomain.exchangeHandoffCode(req.Context(), requestHostname(req), req.URL.Query().Get(handoffCodeParam), nonce)
+	if err != nil {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Invalid authentication via auth domain handoff: %v", err)
+		http.Redirect(rw, req, p.HandoffPath, http.StatusFound)
+		return
+	}
+
+	redirect := req.URL.Query().Get("rd")
+	if !p.IsValidRedirect(redirect) {
+		redirect = "/"
+	}
+
+	err = p.SaveSession(rw, req, session)
+	if err != nil {
+		logger.Errorf("Error saving session during auth domain handoff: %v", err)
+		http.Redirect(rw, req, p.HandoffPath, http.StatusFound)
+		return
+	}
+	logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via auth domain handoff")
+	http.Redirect(rw, req, redirect, http.StatusFound)
+}
+
+// requestScheme returns the scheme the client used for the request
+func requestScheme(req *http.Request) string {
+	if proto := requestutil.GetRequestProto(req); proto != "" {
+		return proto
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHostname returns the host the client requested, without its port
+func requestHostname(req *http.Request) string {
+	host := requestutil.GetRequestHost(req)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
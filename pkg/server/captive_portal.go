@@ -0,0 +1,67 @@
+package server
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// captivePortalTemplate is the body of 511 responses, which refreshes to the
+// sign in page for captive portal assistants that show it to the user
+var captivePortalTemplate = template.Must(template.New("captive_portal").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{.}}">
+<title>Network Authentication Required</title>
+</head>
+<body>
+<p>You need to <a href="{{.}}">sign in</a> to access this network.</p>
+</body>
+</html>
+`))
+
+// isBrowserNavigation checks whether the request is a browser navigating to
+// a page, rather than a client such as the captive portal detection of an
+// operating system
+func isBrowserNavigation(req *http.Request) bool {
+	if req.Header.Get("Sec-Fetch-Mode") == "navigate" {
+		return true
+	}
+	for _, acceptValues := range req.Header.Values("Accept") {
+		for _, accept := range strings.Split(acceptValues, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+			if err == nil && mediaType == "text/html" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// networkAuthenticationRequired answers an unauthenticated request with 511
+// Network Authentication Required (RFC 6585), linking to the sign in flow
+// that returns the user to the requested page
+func (p *OAuthProxy) networkAuthenticationRequired(rw http.ResponseWriter, req *http.Request) {
+	redirect, err := p.getAppRedirect(req)
+	if err != nil {
+		logger.Errorf("Error obtaining redirect: %v", err)
+		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	signIn := url.URL{
+		Path:     p.SignInPath,
+		RawQuery: url.Values{"rd": {redirect}}.Encode(),
+	}
+
+	prepareNoCache(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusNetworkAuthenticationRequired)
+	if err := captivePortalTemplate.Execute(rw, signIn.String()); err != nil {
+		logger.Printf("Error rendering captive portal response: %v", err)
+	}
+}
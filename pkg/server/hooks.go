@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/justinas/alice"
+)
+
+// hooksPluginSymbol is the name of the Hooks variable a middleware plugin
+// must export
+const hooksPluginSymbol = "Hooks"
+
+// Hooks are custom middlewares run at defined stages of request processing.
+// They allow integrators to add their own logic to the proxy without
+// modifying it.
+type Hooks struct {
+	// PreAuth middlewares run for every request, after health checks and
+	// request logging but before any authentication.
+	PreAuth []alice.Constructor
+
+	// PostAuth middlewares run once a request has an authorized session,
+	// before headers are injected.
+	// The session can be read from the request scope.
+	// They do not run for requests that skip authentication.
+	PostAuth []alice.Constructor
+
+	// PreProxy middlewares run immediately before a request is proxied to
+	// the upstream, including requests that skip authentication.
+	PreProxy []alice.Constructor
+}
+
+// AddHooks appends the hooks to the existing middlewares for each stage.
+// It must not be called while the proxy is serving requests.
+func (p *OAuthProxy) AddHooks(hooks Hooks) {
+	p.preAuthChain = p.preAuthChain.Append(hooks.PreAuth...)
+	p.postAuthChain = p.postAuthChain.Append(hooks.PostAuth...)
+	p.preProxyChain = p.preProxyChain.Append(hooks.PreProxy...)
+}
+
+// loadHooksPlugin opens a Go plugin and looks up the Hooks it exports.
+// The plugin must be built against the same version of OAuth2 Proxy and
+// export a variable named Hooks of type server.Hooks.
+func loadHooksPlugin(path string) (Hooks, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return Hooks{}, fmt.Errorf("could not open middleware plugin %q: %v", path, err)
+	}
+
+	symbol, err := p.Lookup(hooksPluginSymbol)
+	if err != nil {
+		return Hooks{}, fmt.Errorf("could not load middleware plugin %q: %v", path, err)
+	}
+
+	hooks, ok := symbol.(*Hooks)
+	if !ok {
+		return Hooks{}, fmt.Errorf("middleware plugin %q exports %s as %T, expected *server.Hooks", path, hooksPluginSymbol, symbol)
+	}
+	return *hooks, nil
+}
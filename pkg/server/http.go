@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/admin"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+)
+
+// Server represents an HTTP server
+type Server struct {
+	Handler http.Handler
+	Opts    *options.Options
+	stop    chan struct{} // channel for waiting shutdown
+
+	// AdminHandler is served on the admin address, if one is configured
+	AdminHandler http.Handler
+
+	// AdminService is served on the gRPC admin address, if one is configured
+	AdminService admin.AdminServer
+
+	// Listeners are already bound listeners, such as sockets passed by
+	// systemd socket activation, served in place of the configured
+	// addresses. They are named "http", "https", "admin" and "admin-grpc".
+	Listeners map[string]net.Listener
+
+	// Ready is called, if set, once the server is listening for requests
+	Ready func()
+
+	// certificate is the TLS certificate served, if TLS is configured
+	certificate *certificate
+}
+
+// certificate is a TLS certificate that can be reloaded from its files
+type certificate struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// load reads the certificate from its files. The previous certificate
+// remains in use if they cannot be read.
+func (c *certificate) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// get returns the certificate for any TLS handshake
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// NewServer constructs a Server which serves the handler on the addresses
+// configured in the options
+func NewServer(handler http.Handler, opts *options.Options) *Server {
+	s := &Server{
+		Handler: handler,
+		Opts:    opts,
+		stop:    make(chan struct{}, 1),
+	}
+	if opts.TLSKeyFile != "" || opts.TLSCertFile != "" {
+		s.certificate = &certificate{
+			certFile: opts.TLSCertFile,
+			keyFile:  opts.TLSKeyFile,
+		}
+	}
+	return s
+}
+
+// Stop gracefully shuts down the server, waiting for active connections
+// to finish
+func (s *Server) Stop() {
+	s.stop <- struct{}{}
+}
+
+// Reload reloads the TLS certificate and key from their files, so that
+// renewed certificates are served without dropping any connections
+func (s *Server) Reload() {
+	if s.certificate == nil {
+		return
+	}
+	if err := s.certificate.load(); err != nil {
+		logger.Errorf("ERROR: reloading tls certificate (%s, %s) failed, keeping the previous certificate - %s", s.certificate.certFile, s.certificate.keyFile, err)
+		return
+	}
+	logger.Printf("HTTPS: reloaded the tls certificate %s", s.certificate.certFile)
+}
+
+// listen returns the listener with the name, if one is already bound, or
+// listens on the address
+func (s *Server) listen(name, networkType, listenAddr string) (net.Listener, error) {
+	if listener, ok := s.Listeners[name]; ok {
+		logger.Printf("%s: using the socket activated listener %s", name, listener.Addr())
+		return listener, nil
+	}
+	return net.Listen(networkType, listenAddr)
+}
+
+// ListenAndServe will serve traffic on HTTP or HTTPS depending on TLS options
+func (s *Server) ListenAndServe() {
+	for name, listener := range s.Listeners {
+		if name != "http" && name != "https" && name != "admin" && name != "admin-grpc" {
+			logger.Errorf("WARNING: Ignoring the socket activated listener %q on %s: its name must be http, https, admin or admin-grpc", name, listener.Addr())
+			listener.Close()
+		}
+	}
+
+	if s.AdminHandler != nil && s.Opts.AdminAddress != "" {
+		adminServer := s.serveAdmin()
+		defer func() {
+			if err := adminServer.Shutdown(context.Background()); err != nil {
+				logger.Printf("Admin server Shutdown: %v", err)
+			}
+		}()
+	}
+
+	if s.AdminService != nil && s.Opts.AdminGRPCAddress != "" {
+		adminGRPCServer := s.serveAdminGRPC()
+		defer adminGRPCServer.GracefulStop()
+	}
+
+	if s.Opts.TLSKeyFile != "" || s.Opts.TLSCertFile != "" {
+		s.ServeHTTPS()
+	} else {
+		s.ServeHTTP()
+	}
+}
+
+// ServeHTTP constructs a net.Listener and starts handling HTTP requests
+func (s *Server) ServeHTTP() {
+	networkType, listenAddr := parseListenAddress(s.Opts.HTTPAddress)
+
+	listener, err := s.listen("http", networkType, listenAddr)
+	if err != nil {
+		logger.Fatalf("FATAL: listen (%s, %s) failed - %s", networkType, listenAddr, err)
+	}
+	logger.Printf("HTTP: listening on %s", listener.Addr())
+	s.serve(listener, false)
+	logger.Printf("HTTP: closing %s", listener.Addr())
+}
+
+// serveAdmin starts handling admin API requests in the background.
+// The returned http.Server should be shut down once the main server stops.
+func (s *Server) serveAdmin() *http.Server {
+	networkType, listenAddr := parseListenAddress(s.Opts.AdminAddress)
+
+	listener, err := s.listen("admin", networkType, listenAddr)
+	if err != nil {
+		logger.Fatalf("FATAL: admin listen (%s, %s) failed - %s", networkType, listenAddr, err)
+	}
+	logger.Printf("Admin: listening on %s", listener.Addr())
+
+	srv := &http.Server{Handler: s.AdminHandler}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("ERROR: admin http.Serve() - %s", err)
+		}
+	}()
+	return srv
+}
+
+// parseListenAddress splits an address with an optional scheme (e.g.
+// unix:///path/to/socket) into the network type and address to listen on
+func parseListenAddress(address string) (string, string) {
+	var scheme string
+
+	i := strings.Index(address, "://")
+	if i > -1 {
+		scheme = address[0:i]
+	}
+
+	var networkType string
+	switch scheme {
+	case "", "http":
+		networkType = "tcp"
+	default:
+		networkType = scheme
+	}
+
+	slice := strings.SplitN(address, "//", 2)
+	return networkType, slice[len(slice)-1]
+}
+
+// serveAdminGRPC starts serving the gRPC admin service in the background.
+// The returned grpc.Server should be stopped once the main server stops.
+func (s *Server) serveAdminGRPC() *grpc.Server {
+	srv, err := NewAdminGRPCServer(s.Opts, s.AdminService)
+	if err != nil {
+		logger.Fatalf("FATAL: configuring admin gRPC server failed - %s", err)
+	}
+
+	listener, err := s.listen("admin-grpc", "tcp", s.Opts.AdminGRPCAddress)
+	if err != nil {
+		logger.Fatalf("FATAL: admin gRPC listen (%s) failed - %s", s.Opts.AdminGRPCAddress, err)
+	}
+	logger.Printf("Admin gRPC: listening on %s", listener.Addr())
+
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			logger.Errorf("ERROR: admin grpc.Serve() - %s", err)
+		}
+	}()
+	return srv
+}
+
+// ServeHTTPS constructs a net.Listener and starts handling HTTPS requests
+func (s *Server) ServeHTTPS() {
+	addr := s.Opts.HTTPSAddress
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		MaxVersion: tls.VersionTLS13,
+	}
+	if config.NextProtos == nil {
+		config.NextProtos = []string{"http/1.1"}
+		if s.Opts.HTTP2 {
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+
+	if s.certificate == nil {
+		s.certificate = &certificate{
+			certFile: s.Opts.TLSCertFile,
+			keyFile:  s.Opts.TLSKeyFile,
+		}
+	}
+	if err := s.certificate.load(); err != nil {
+		logger.Fatalf("FATAL: loading tls config (%s, %s) failed - %s", s.Opts.TLSCertFile, s.Opts.TLSKeyFile, err)
+	}
+	config.GetCertificate = s.certificate.get
+
+	ln, err := s.listen("https", "tcp", addr)
+	if err != nil {
+		logger.Fatalf("FATAL: listen (%s) failed - %s", addr, err)
+	}
+	logger.Printf("HTTPS: listening on %s", ln.Addr())
+
+	if tcpListener, ok := ln.(*net.TCPListener); ok {
+		ln = tcpKeepAliveListener{tcpListener}
+	}
+	tlsListener := tls.NewListener(ln, config)
+	s.serve(tlsListener, true)
+	logger.Printf("HTTPS: closing %s", tlsListener.Addr())
+}
+
+// newHTTPServer constructs the server for the main listener with the
+// configured timeouts.
+// HTTP/2 is served on TLS listeners when enabled, and without TLS (h2c) on
+// other listeners when enabled.
+func (s *Server) newHTTPServer(useTLS bool) (*http.Server, error) {
+	srv := &http.Server{
+		Handler:           s.Handler,
+		ReadHeaderTimeout: s.Opts.HTTPReadHeaderTimeout,
+		IdleTimeout:       s.Opts.HTTPIdleTimeout,
+	}
+	h2s := &http2.Server{
+		IdleTimeout:          s.Opts.HTTP2IdleTimeout,
+		MaxConcurrentStreams: uint32(s.Opts.HTTP2MaxStreams),
+	}
+	if h2s.IdleTimeout == 0 {
+		h2s.IdleTimeout = s.Opts.HTTPIdleTimeout
+	}
+
+	switch {
+	case useTLS && s.Opts.HTTP2:
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			return nil, err
+		}
+	case !useTLS && s.Opts.H2C:
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return srv, nil
+}
+
+func (s *Server) serve(listener net.Listener, useTLS bool) {
+	srv, err := s.newHTTPServer(useTLS)
+	if err != nil {
+		logger.Fatalf("FATAL: configuring http server failed - %s", err)
+	}
+
+	// See https://golang.org/pkg/net/http/#Server.Shutdown
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-s.stop // wait notification for stopping server
+
+		// We received an interrupt signal, shut down.
+		if err := srv.Shutdown(context.Background()); err != nil {
+			// Error from closing listeners, or context timeout:
+			logger.Printf("HTTP server Shutdown: %v", err)
+		}
+		close(idleConnsClosed)
+	}()
+
+	if s.Ready != nil {
+		s.Ready()
+	}
+	err = srv.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorf("ERROR: http.Serve() - %s", err)
+	}
+	<-idleConnsClosed
+}
+
+// tcpKeepAliveListener sets TCP keep-alive timeouts on accepted
+// connections. It's used by ListenAndServe and ListenAndServeTLS so
+// dead TCP connections (e.g. closing laptop mid-download) eventually
+// go away.
+type tcpKeepAliveListener struct {
+	*net.TCPListener
+}
+
+func (ln tcpKeepAliveListener) Accept() (net.Conn, error) {
+	tc, err := ln.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	err = tc.SetKeepAlive(true)
+	if err != nil {
+		logger.Printf("Error setting Keep-Alive: %v", err)
+	}
+	err = tc.SetKeepAlivePeriod(3 * time.Minute)
+	if err != nil {
+		logger.Printf("Error setting Keep-Alive period: %v", err)
+	}
+	return tc, nil
+}
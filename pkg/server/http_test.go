@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	opts := options.NewOptions()
+	stop := make(chan struct{}, 1)
+	srv := Server{Handler: http.DefaultServeMux, Opts: opts, stop: stop}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.ServeHTTP()
+	}()
+
+	stop <- struct{}{} // emulate catching signals
+
+	// An idiomatic for sync.WaitGroup with timeout
+	c := make(chan struct{})
+	go func() {
+		defer close(c)
+		wg.Wait()
+	}()
+	select {
+	case <-c:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Server should return gracefully but timeout has occurred")
+	}
+
+	assert.Len(t, stop, 0) // check if stop chan is empty
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	opts := options.NewOptions()
+	opts.HTTPReadHeaderTimeout = 10 * time.Second
+	opts.HTTPIdleTimeout = time.Minute
+	srv := Server{Handler: http.DefaultServeMux, Opts: opts}
+
+	httpServer, err := srv.newHTTPServer(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, httpServer.ReadHeaderTimeout)
+	assert.Equal(t, time.Minute, httpServer.IdleTimeout)
+	assert.Equal(t, http.DefaultServeMux, httpServer.Handler)
+	assert.Nil(t, httpServer.TLSNextProto)
+}
+
+func TestNewHTTPServerHTTP2(t *testing.T) {
+	opts := options.NewOptions()
+	opts.HTTP2 = true
+	srv := Server{Handler: http.DefaultServeMux, Opts: opts}
+
+	httpsServer, err := srv.newHTTPServer(true)
+	assert.NoError(t, err)
+	assert.Contains(t, httpsServer.TLSNextProto, "h2")
+
+	// HTTP/2 is only served without TLS with h2c
+	httpServer, err := srv.newHTTPServer(false)
+	assert.NoError(t, err)
+	assert.Equal(t, http.DefaultServeMux, httpServer.Handler)
+}
+
+func TestNewHTTPServerH2C(t *testing.T) {
+	opts := options.NewOptions()
+	opts.H2C = true
+	srv := Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.Proto))
+	}), Opts: opts}
+
+	httpServer, err := srv.newHTTPServer(false)
+	assert.NoError(t, err)
+	ts := httptest.NewServer(httpServer.Handler)
+	defer ts.Close()
+
+	// A client with prior knowledge speaks HTTP/2 over a plain connection
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := client.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0", string(body))
+
+	resp, err = http.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", string(body))
+}
+
+func TestServeActivatedListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	opts := options.NewOptions()
+	opts.HTTPAddress = "127.0.0.1:-1" // not listened on
+	ready := make(chan struct{})
+	srv := NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("activated"))
+	}), opts)
+	srv.Listeners = map[string]net.Listener{"http": listener}
+	srv.Ready = func() { close(ready) }
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.ListenAndServe()
+	}()
+	<-ready
+
+	resp, err := http.Get("http://" + listener.Addr().String())
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "activated", string(body))
+
+	srv.Stop()
+	<-done
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	opts := options.NewOptions()
+	opts.TLSCertFile = certFile
+	opts.TLSKeyFile = keyFile
+	srv := NewServer(http.DefaultServeMux, opts)
+	assert.NoError(t, srv.certificate.load())
+	assertCertificate(t, srv, "first")
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	srv.Reload()
+	assertCertificate(t, srv, "second")
+
+	// An invalid certificate keeps the previous one
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte("invalid"), 0600))
+	srv.Reload()
+	assertCertificate(t, srv, "second")
+}
+
+func assertCertificate(t *testing.T, srv *Server, commonName string) {
+	cert, err := srv.certificate.get(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, commonName, parsed.Subject.CommonName)
+}
+
+// writeTestCertificate writes a self signed certificate for the common name
+// and its key to the files
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+}
@@ -1,7 +1,7 @@
 // largely adapted from https://github.com/gorilla/handlers/blob/master/handlers.go
 // to add logging of request duration as last value (and drop referrer)
 
-package main
+package server
 
 import (
 	"bufio"
@@ -21,6 +21,7 @@ type responseLogger struct {
 	size     int
 	upstream string
 	authInfo string
+	rule     string
 }
 
 // Header returns the ResponseWriter's Header
@@ -49,6 +50,11 @@ func (l *responseLogger) ExtractGAPMetadata() {
 		l.authInfo = authInfo
 		l.w.Header().Del("GAP-Auth")
 	}
+	rule := l.w.Header().Get("GAP-Authorization-Rule")
+	if rule != "" {
+		l.rule = rule
+		l.w.Header().Del("GAP-Authorization-Rule")
+	}
 }
 
 // Write writes the response using the ResponseWriter
@@ -104,5 +110,5 @@ func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	url := *req.URL
 	responseLogger := &responseLogger{w: w}
 	h.handler.ServeHTTP(responseLogger, req)
-	logger.PrintReq(responseLogger.authInfo, responseLogger.upstream, req, url, t, responseLogger.Status(), responseLogger.Size())
+	logger.PrintReq(responseLogger.authInfo, responseLogger.upstream, responseLogger.rule, req, url, t, responseLogger.Status(), responseLogger.Size())
 }
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"bytes"
@@ -114,3 +114,50 @@ func TestLoggingHandler_ServeHTTP(t *testing.T) {
 		assert.Equal(t, test.ExpectedLogMessage, actual)
 	}
 }
+
+func TestLoggingHandlerRule(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	logger.SetOutput(buf)
+	logger.SetReqTemplate("{{.StatusCode}} {{.Rule}}")
+	logger.SetExcludePaths([]string{})
+	defer logger.SetReqTemplate(logger.DefaultRequestLoggingFormat)
+
+	h := LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/admin" {
+			w.Header().Set("GAP-Authorization-Rule", "deny-route-0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/admin", nil))
+	assert.Equal(t, "403 deny-route-0\n", buf.String())
+	assert.Empty(t, rw.Header().Get("GAP-Authorization-Rule"))
+
+	buf.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "200 -\n", buf.String())
+}
+
+func TestLoggingHandlerProvider(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	logger.SetOutput(buf)
+	logger.SetReqTemplate("{{.Provider}} {{.Tenant}} {{.StatusCode}}")
+	logger.SetExcludePaths([]string{})
+	defer logger.SetReqTemplate(logger.DefaultRequestLoggingFormat)
+	defer logger.SetProvider("", "")
+
+	h := LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "- - 200\n", buf.String())
+
+	buf.Reset()
+	logger.SetProvider("oidc", "team-a")
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "oidc team-a 200\n", buf.String())
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"context"
@@ -10,29 +10,51 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
 	ipapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/ip"
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/basic"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/failures"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cloudmetadata"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cookies"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/identity"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/telemetry"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/version"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
 	schemeHTTPS     = "https"
 	applicationJSON = "application/json"
+
+	// refreshRetryAfter is the number of seconds clients are told to wait
+	// before retrying a request made while its session is being refreshed
+	refreshRetryAfter = "1"
+
+	// denyRuleHeader is the response header identifying the deny rule that
+	// matched the request, when enabled for debugging
+	denyRuleHeader = "X-OAuth2-Proxy-Rule"
+
+	// gapRuleHeader passes the matched deny rule to the request logger, which
+	// removes it from the response
+	gapRuleHeader = "GAP-Authorization-Rule"
 )
 
 var (
@@ -42,17 +64,15 @@ var (
 	// ErrAccessDenied means the user should receive a 401 Unauthorized response
 	ErrAccessDenied = errors.New("access denied")
 
+	// ErrRefreshInProgress means the session has expired and is being
+	// refreshed by a concurrent request, so the request should be retried
+	ErrRefreshInProgress = errors.New("session refresh in progress")
+
 	// Used to check final redirects are not susceptible to open redirects.
 	// Matches //, /\ and both of these with whitespace in between (eg / / or / \).
 	invalidRedirectRegex = regexp.MustCompile(`[/\\](?:[\s\v]*|\.{1,2})[/\\]`)
 )
 
-// allowedRoute manages method + path based allowlists
-type allowedRoute struct {
-	method    string
-	pathRegex *regexp.Regexp
-}
-
 // OAuthProxy is the main authentication proxy
 type OAuthProxy struct {
 	CookieSeed     string
@@ -74,8 +94,23 @@ type OAuthProxy struct {
 	OAuthCallbackPath string
 	AuthOnlyPath      string
 	UserInfoPath      string
-
-	allowedRoutes        []allowedRoute
+	HandoffPath       string
+	ShareLinkPath     string
+	SessionJWKSPath   string
+
+	allowlists           []allowlist.Allowlist
+	authDomain           *authDomain
+	shareLinks           *shareLinks
+	sessionJWKS          []byte
+	identityNormalizers  identity.Normalizers
+	allowlistAuditSink   allowlist.AuditSink
+	authorizationRules   *authorization.RuleSets
+	upstreamSelector     *upstream.Selector
+	paramLimits          paramLimits
+	showDenyRule         bool
+	siemStreamer         *telemetry.SIEMStreamer
+	skipAuthRoutes       *allowlist.Routes
+	trustedIPs           *allowlist.IPs
 	redirectURL          *url.URL // the url to receive requests at
 	whitelistDomains     []string
 	provider             providers.Provider
@@ -90,23 +125,38 @@ type OAuthProxy struct {
 	PassBasicAuth        bool
 	SetBasicAuth         bool
 	SkipProviderButton   bool
+	captivePortal        bool
 	PassUserHeaders      bool
 	BasicAuthPassword    string
 	PassAccessToken      bool
 	SetAuthorization     bool
 	PassAuthorization    bool
 	PreferEmailToUser    bool
-	skipAuthPreflight    bool
 	skipJwtBearerTokens  bool
+	sessionFingerprint   []string
+	authRequestCacheTTL  time.Duration
 	templates            *template.Template
+	headerInjectors      *headerInjectors
+	adminToken           string
+	metricLabels         prometheus.Labels
+	requestMetrics       *middleware.RequestMetrics
+	configReport         *options.ConfigReport
 	realClientIPParser   ipapi.RealClientIPParser
-	trustedIPs           *ip.NetSet
+	realClientIPHeader   string // set when the client IP is taken from a header
 	Banner               string
 	Footer               string
 
-	sessionChain alice.Chain
-	headersChain alice.Chain
-	preAuthChain alice.Chain
+	sessionChain  alice.Chain
+	headersChain  alice.Chain
+	preAuthChain  alice.Chain
+	postAuthChain alice.Chain
+	preProxyChain alice.Chain
+
+	// syncers keep rules and allowlists up to date in the background, from
+	// when the proxy is constructed until it is stopped
+	syncers  []authorization.Syncer
+	done     chan bool
+	stopOnce sync.Once
 }
 
 // NewOAuthProxy creates a new instance of OAuthProxy from the options provided
@@ -118,10 +168,18 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 
 	templates := loadTemplates(opts.CustomTemplatesDir)
 	proxyErrorHandler := upstream.NewProxyErrorHandler(templates.Lookup("error.html"), opts.ProxyPrefix)
-	upstreamProxy, err := upstream.NewProxy(opts.UpstreamServers, opts.GetSignatureData(), proxyErrorHandler)
+	upstreamHosts, err := upstream.NewHostAllowlist(opts.UpstreamAllowedHosts)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising upstream host allowlist: %v", err)
+	}
+	upstreamProxy, err := upstream.NewProxy(opts.UpstreamServers, opts.GetSignatureData(), injectedRequestHeaderNames(opts), upstreamHosts, proxyErrorHandler)
 	if err != nil {
 		return nil, fmt.Errorf("error initialising upstream proxy: %v", err)
 	}
+	upstreamProxy = middleware.NewForwardedClientIP(opts.GetRealClientIPParser(), opts.UpstreamXRealIP, opts.UpstreamForwardedFor)(upstreamProxy)
+	if opts.ShareLinkMaxExpiry != 0 {
+		upstreamProxy = stripShareLinkToken(upstreamProxy)
+	}
 
 	if opts.SkipJwtBearerTokens {
 		logger.Printf("Skipping JWT tokens from configured OIDC issuer: %q", opts.OIDCIssuerURL)
@@ -142,15 +200,6 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 
 	logger.Printf("Cookie settings: name:%s secure(https):%v httponly:%v expiry:%s domains:%s path:%s samesite:%s refresh:%s", opts.Cookie.Name, opts.Cookie.Secure, opts.Cookie.HTTPOnly, opts.Cookie.Expire, strings.Join(opts.Cookie.Domains, ","), opts.Cookie.Path, opts.Cookie.SameSite, refresh)
 
-	trustedIPs := ip.NewNetSet()
-	for _, ipStr := range opts.TrustedIPs {
-		if ipNet := ip.ParseIPNet(ipStr); ipNet != nil {
-			trustedIPs.AddIPNet(*ipNet)
-		} else {
-			return nil, fmt.Errorf("could not parse IP network (%s)", ipStr)
-		}
-	}
-
 	var basicAuthValidator basic.Validator
 	if opts.HtpasswdFile != "" {
 		logger.Printf("using htpasswd file: %s", opts.HtpasswdFile)
@@ -161,22 +210,66 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		}
 	}
 
-	allowedRoutes, err := buildRoutesAllowlist(opts)
+	skipAuthRoutes, routeSyncers, err := buildRoutesAllowlist(opts)
+	if err != nil {
+		return nil, err
+	}
+	trustedIPs, trustedIPsSyncers, err := buildTrustedIPsAllowlist(opts)
+	if err != nil {
+		return nil, err
+	}
+	allowlists, err := buildAllowlists(opts, skipAuthRoutes, trustedIPs)
 	if err != nil {
 		return nil, err
 	}
 
-	preAuthChain, err := buildPreAuthChain(opts)
+	requestMetrics, err := middleware.NewRequestMetrics(buildRouteTemplates(opts))
+	if err != nil {
+		return nil, fmt.Errorf("could not build request metrics: %v", err)
+	}
+	preAuthChain, err := buildPreAuthChain(opts, trustedIPs, requestMetrics)
 	if err != nil {
 		return nil, fmt.Errorf("could not build pre-auth chain: %v", err)
 	}
-	sessionChain := buildSessionChain(opts, sessionStore, basicAuthValidator)
-	headersChain, err := buildHeadersChain(opts)
+	refreshLocker, err := sessions.NewRefreshLocker(&opts.Session, &opts.Cookie)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising session refresh locks: %v", err)
+	}
+	sessionChain := buildSessionChain(opts, sessionStore, refreshLocker, basicAuthValidator)
+	headersChain, err := buildHeadersChain(opts, sessionStore)
 	if err != nil {
 		return nil, fmt.Errorf("could not build headers chain: %v", err)
 	}
+	headerInjectors, err := newHeaderInjectors(opts)
+	if err != nil {
+		return nil, err
+	}
+	adminToken, err := loadAdminToken(opts)
+	if err != nil {
+		return nil, err
+	}
+	authDomain, err := newAuthDomain(opts)
+	if err != nil {
+		return nil, err
+	}
+	sessionJWKS, err := loadSessionJWKS(opts)
+	if err != nil {
+		return nil, err
+	}
+	identityNormalizers, err := identity.NewNormalizers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var siemStreamer *telemetry.SIEMStreamer
+	if opts.Telemetry.SIEM.URL != "" {
+		siemStreamer, err = telemetry.NewSIEMStreamer(opts.Telemetry.SIEM)
+		if err != nil {
+			return nil, fmt.Errorf("could not create SIEM streamer: %v", err)
+		}
+	}
 
-	return &OAuthProxy{
+	p := &OAuthProxy{
 		CookieName:     opts.Cookie.Name,
 		CSRFCookieName: fmt.Sprintf("%v_%v", opts.Cookie.Name, "csrf"),
 		CookieSeed:     opts.Cookie.Secret,
@@ -196,6 +289,9 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		OAuthCallbackPath: fmt.Sprintf("%s/callback", opts.ProxyPrefix),
 		AuthOnlyPath:      fmt.Sprintf("%s/auth", opts.ProxyPrefix),
 		UserInfoPath:      fmt.Sprintf("%s/userinfo", opts.ProxyPrefix),
+		HandoffPath:       fmt.Sprintf("%s/handoff", opts.ProxyPrefix),
+		ShareLinkPath:     fmt.Sprintf("%s/share", opts.ProxyPrefix),
+		SessionJWKSPath:   fmt.Sprintf("%s/jwks.json", opts.ProxyPrefix),
 
 		ProxyPrefix:          opts.ProxyPrefix,
 		provider:             opts.GetProvider(),
@@ -203,14 +299,32 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		sessionStore:         sessionStore,
 		serveMux:             upstreamProxy,
 		redirectURL:          redirectURL,
-		allowedRoutes:        allowedRoutes,
+		allowlists:           allowlists,
+		authDomain:           authDomain,
+		shareLinks:           newShareLinks(opts),
+		sessionJWKS:          sessionJWKS,
+		identityNormalizers:  identityNormalizers,
+		skipAuthRoutes:       skipAuthRoutes,
+		trustedIPs:           trustedIPs,
+		allowlistAuditSink:   allowlist.LoggerAuditSink{},
+		authorizationRules:   opts.GetAuthorizationRules(),
+		upstreamSelector:     upstream.NewSelector(opts.UpstreamServers),
+		paramLimits:          newParamLimits(opts),
+		showDenyRule:         opts.DenyRuleHeader,
+		siemStreamer:         siemStreamer,
 		whitelistDomains:     opts.WhitelistDomains,
-		skipAuthPreflight:    opts.SkipAuthPreflight,
 		skipJwtBearerTokens:  opts.SkipJwtBearerTokens,
+		sessionFingerprint:   opts.Session.Fingerprint,
+		authRequestCacheTTL:  opts.AuthRequestCacheTTL,
 		realClientIPParser:   opts.GetRealClientIPParser(),
 		SkipProviderButton:   opts.SkipProviderButton,
+		captivePortal:        opts.CaptivePortal,
 		templates:            templates,
-		trustedIPs:           trustedIPs,
+		headerInjectors:      headerInjectors,
+		adminToken:           adminToken,
+		metricLabels:         providerMetricLabels(opts.ProviderType, opts.Tenant),
+		requestMetrics:       requestMetrics,
+		configReport:         opts.GetConfigReport(),
 		Banner:               opts.Banner,
 		Footer:               opts.Footer,
 		SignInMessage:        buildSignInMessage(opts),
@@ -220,14 +334,69 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		sessionChain:        sessionChain,
 		headersChain:        headersChain,
 		preAuthChain:        preAuthChain,
-	}, nil
+		postAuthChain:       alice.New(),
+		preProxyChain:       alice.New(),
+	}
+
+	if opts.ReverseProxy {
+		p.realClientIPHeader = opts.RealClientIPHeader
+	}
+
+	for _, path := range opts.MiddlewarePlugins {
+		logger.Printf("loading middleware plugin: %s", path)
+		hooks, err := loadHooksPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		p.AddHooks(hooks)
+	}
+
+	p.syncers = append(p.syncers, opts.GetRuleSyncers()...)
+	p.syncers = append(p.syncers, routeSyncers...)
+	p.syncers = append(p.syncers, trustedIPsSyncers...)
+	p.startSyncers()
+
+	return p, nil
+}
+
+// startSyncers runs the syncers of the proxy in the background until the
+// proxy is stopped
+func (p *OAuthProxy) startSyncers() {
+	p.done = make(chan bool)
+	for _, syncer := range p.syncers {
+		go syncer.Run(p.done)
+	}
+}
+
+// Stop stops the background syncing of rules and allowlists started when
+// the proxy was constructed. The proxy keeps serving requests with the
+// rules and allowlists last synced.
+func (p *OAuthProxy) Stop() {
+	p.stopOnce.Do(func() {
+		if p.done != nil {
+			close(p.done)
+		}
+	})
+}
+
+// buildRouteTemplates returns the route templates request metrics label
+// requests with: the endpoints of the proxy, then the configured templates.
+func buildRouteTemplates(opts *options.Options) []string {
+	templates := []string{"/robots.txt"}
+	if opts.PingPath != "" {
+		templates = append(templates, opts.PingPath)
+	}
+	for _, endpoint := range []string{"sign_in", "sign_out", "start", "callback", "auth", "userinfo", "handoff", "share", "jwks.json"} {
+		templates = append(templates, fmt.Sprintf("%s/%s", opts.ProxyPrefix, endpoint))
+	}
+	return append(templates, opts.MetricsRouteTemplates...)
 }
 
 // buildPreAuthChain constructs a chain that should process every request before
 // the OAuth2 Proxy authentication logic kicks in.
 // For example forcing HTTPS or health checks.
-func buildPreAuthChain(opts *options.Options) (alice.Chain, error) {
-	chain := alice.New(middleware.NewScope(opts.ReverseProxy))
+func buildPreAuthChain(opts *options.Options, trustedIPs *allowlist.IPs, requestMetrics *middleware.RequestMetrics) (alice.Chain, error) {
+	chain := alice.New(middleware.NewScope(opts.ReverseProxy), requestMetrics.Handler)
 
 	if opts.ForceHTTPS {
 		_, httpsPort, err := net.SplitHostPort(opts.HTTPSAddress)
@@ -252,10 +421,14 @@ func buildPreAuthChain(opts *options.Options) (alice.Chain, error) {
 		chain = chain.Append(LoggingHandler, middleware.NewHealthCheck(healthCheckPaths, healthCheckUserAgents))
 	}
 
+	if opts.ReverseProxy {
+		chain = chain.Append(middleware.NewSpoofedClientIPDetection(opts.GetRealClientIPParser(), trustedIPs, opts.DenySpoofedClientIP))
+	}
+
 	return chain, nil
 }
 
-func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionStore, validator basic.Validator) alice.Chain {
+func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionStore, refreshLocker sessionsapi.Locker, validator basic.Validator) alice.Chain {
 	chain := alice.New()
 
 	if opts.SkipJwtBearerTokens {
@@ -265,7 +438,7 @@ func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionSt
 
 		for _, verifier := range opts.GetJWTBearerVerifiers() {
 			sessionLoaders = append(sessionLoaders,
-				middlewareapi.CreateTokenToSessionFunc(verifier.Verify))
+				middlewareapi.CreateTokenToSessionFuncWithGroups(verifier.Verify, opts.OIDCGroupsClaim))
 		}
 
 		chain = chain.Append(middleware.NewJwtSessionLoader(sessionLoaders))
@@ -275,17 +448,37 @@ func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionSt
 		chain = chain.Append(middleware.NewBasicAuthSessionLoader(validator))
 	}
 
+	if sigData := opts.GetChainedSignatureData(); sigData != nil {
+		chain = chain.Append(middleware.NewChainedSessionLoader(options.IdentityFormat(opts.ChainedIdentityFormat), sigData, opts.ChainedIdentityAudience, opts.ChainedIdentityMaxLifetime))
+	}
+
 	chain = chain.Append(middleware.NewStoredSessionLoader(&middleware.StoredSessionLoaderOptions{
 		SessionStore:           sessionStore,
 		RefreshPeriod:          opts.Cookie.Refresh,
 		RefreshSessionIfNeeded: opts.GetProvider().RefreshSessionIfNeeded,
 		ValidateSessionState:   opts.GetProvider().ValidateSession,
+		ClockSkew:              opts.Session.ClockSkew,
+		Fingerprint:            opts.Session.Fingerprint,
+		RealClientIPParser:     opts.GetRealClientIPParser(),
+		RefreshLocker:          refreshLocker,
+		RefreshBackoff:         opts.Session.RefreshBackoff,
+		RefreshBackoffMax:      opts.Session.RefreshBackoffMax,
 	}))
 
 	return chain
 }
 
-func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
+// injectedRequestHeaderNames returns the canonical names of the request
+// headers that identify the user to upstreams
+func injectedRequestHeaderNames(opts *options.Options) []string {
+	names := make([]string, 0, len(opts.InjectRequestHeaders))
+	for _, h := range opts.InjectRequestHeaders {
+		names = append(names, http.CanonicalHeaderKey(h.Name))
+	}
+	return names
+}
+
+func buildHeadersChain(opts *options.Options, sessionStore sessionsapi.SessionStore) (alice.Chain, error) {
 	requestInjector, err := middleware.NewRequestHeaderInjector(opts.InjectRequestHeaders)
 	if err != nil {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
@@ -296,7 +489,19 @@ func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
 	}
 
-	return alice.New(requestInjector, responseInjector), nil
+	chain := alice.New()
+	if len(opts.IDTokenClaims) > 0 {
+		chain = chain.Append(middleware.NewIDTokenMinimizer(opts.IDTokenClaims, []byte(opts.GetSignatureData().Key)))
+	}
+	if opts.Session.AnnotationHeader != "" {
+		chain = chain.Append(middleware.NewSessionAnnotations(&middleware.SessionAnnotationsOptions{
+			SessionStore: sessionStore,
+			Header:       opts.Session.AnnotationHeader,
+			Keys:         opts.Session.AnnotationKeys,
+			MaxSize:      opts.Session.AnnotationsMaxSize,
+		}))
+	}
+	return chain.Append(requestInjector, responseInjector), nil
 }
 
 func buildSignInMessage(opts *options.Options) string {
@@ -317,53 +522,178 @@ func buildSignInMessage(opts *options.Options) string {
 	return msg
 }
 
-// buildRoutesAllowlist builds an []allowedRoute  list from either the legacy
-// SkipAuthRegex option (paths only support) or newer SkipAuthRoutes option
-// (method=path support)
-func buildRoutesAllowlist(opts *options.Options) ([]allowedRoute, error) {
-	routes := make([]allowedRoute, 0, len(opts.SkipAuthRegex)+len(opts.SkipAuthRoutes))
+// buildAllowlists builds the allowlists used to determine whether a request
+// may skip authentication, and logs the entries of each.
+// The routes and trusted IPs allowlists are given so that they can be
+// modified at runtime through the admin API.
+func buildAllowlists(opts *options.Options, routes *allowlist.Routes, trustedIPs *allowlist.IPs) ([]allowlist.Allowlist, error) {
+	allowlists := []allowlist.Allowlist{}
 
-	for _, path := range opts.SkipAuthRegex {
-		compiledRegex, err := regexp.Compile(path)
+	if opts.SkipAuthPreflight {
+		allowlists = append(allowlists, allowlist.NewPreflight())
+	}
+
+	allowlists = append(allowlists, routes, trustedIPs)
+
+	if len(opts.SkipAuthUserAgents) > 0 {
+		userAgents, err := buildUserAgentsAllowlist(opts)
 		if err != nil {
 			return nil, err
 		}
-		logger.Printf("Skipping auth - Method: ALL | Path: %s", path)
-		routes = append(routes, allowedRoute{
-			method:    "",
-			pathRegex: compiledRegex,
-		})
+		allowlists = append(allowlists, userAgents)
 	}
 
-	for _, methodPath := range opts.SkipAuthRoutes {
-		var (
-			method string
-			path   string
-		)
-
-		parts := strings.SplitN(methodPath, "=", 2)
-		if len(parts) == 1 {
-			method = ""
-			path = parts[0]
-		} else {
-			method = strings.ToUpper(parts[0])
-			path = parts[1]
+	if opts.SkipAuthHtpasswdFile != "" {
+		validator, err := basic.NewHTPasswdValidator(opts.SkipAuthHtpasswdFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load skip auth htpasswd file: %v", err)
 		}
+		allowlists = append(allowlists, allowlist.NewBasicAuth(validator, opts.SkipAuthHtpasswdFile))
+	}
 
-		compiledRegex, err := regexp.Compile(path)
+	for _, trustedRequest := range opts.TrustedRequests {
+		all, err := buildTrustedRequestAllowlist(opts, trustedRequest)
 		if err != nil {
 			return nil, err
 		}
-		logger.Printf("Skipping auth - Method: %s | Path: %s", method, path)
-		routes = append(routes, allowedRoute{
-			method:    method,
-			pathRegex: compiledRegex,
-		})
+		allowlists = append(allowlists, all)
 	}
 
+	if links := newShareLinks(opts); links != nil {
+		allowlists = append(allowlists, links)
+	}
+
+	for _, a := range allowlists {
+		for _, msg := range a.LogMessages() {
+			logger.Print(msg)
+		}
+	}
+	return allowlists, nil
+}
+
+// buildUserAgentsAllowlist builds a User-Agent allowlist from the
+// SkipAuthUserAgents option, restricted to SkipAuthUserAgentIPs if given
+func buildUserAgentsAllowlist(opts *options.Options) (*allowlist.UserAgents, error) {
+	var ips *allowlist.IPs
+	if len(opts.SkipAuthUserAgentIPs) > 0 {
+		ips = allowlist.NewIPs(opts.GetRealClientIPParser())
+		for _, ipStr := range opts.SkipAuthUserAgentIPs {
+			if err := ips.Add(ipStr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	userAgents := allowlist.NewUserAgents(ips)
+	for _, regex := range opts.SkipAuthUserAgents {
+		if err := userAgents.Add(regex); err != nil {
+			return nil, err
+		}
+	}
+	return userAgents, nil
+}
+
+// buildTrustedIPsAllowlist builds an IP allowlist from the TrustedIPs option.
+// When a cloud provider is configured, it also returns the syncer that keeps
+// the CIDR ranges discovered from its metadata in sync with the allowlist.
+func buildTrustedIPsAllowlist(opts *options.Options) (*allowlist.IPs, []authorization.Syncer, error) {
+	trustedIPs, err := newTrustedIPsAllowlist(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var syncers []authorization.Syncer
+	if opts.TrustedIPCloudProvider != "" {
+		provider, err := cloudmetadata.NewProvider(opts.TrustedIPCloudProvider)
+		if err != nil {
+			return nil, nil, err
+		}
+		syncers = append(syncers, cloudmetadata.NewSyncer(provider, trustedIPs, opts.TrustedIPCloudRefresh))
+	}
+	return trustedIPs, syncers, nil
+}
+
+// newTrustedIPsAllowlist builds an IP allowlist from only the configured
+// TrustedIPs
+func newTrustedIPsAllowlist(opts *options.Options) (*allowlist.IPs, error) {
+	trustedIPs := allowlist.NewIPs(opts.GetRealClientIPParser())
+	for _, ipStr := range opts.TrustedIPs {
+		if err := trustedIPs.Add(ipStr); err != nil {
+			return nil, err
+		}
+	}
+	return trustedIPs, nil
+}
+
+// buildRoutesAllowlist builds a route allowlist from either the legacy
+// SkipAuthRegex option (paths only support) or newer SkipAuthRoutes option
+// (method=path support).
+// It also returns the syncers that keep the routes published by upstreams
+// with PublishRoutes in sync with the allowlist.
+func buildRoutesAllowlist(opts *options.Options) (*allowlist.Routes, []authorization.Syncer, error) {
+	routes, err := newRoutesAllowlist(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var syncers []authorization.Syncer
+	for _, u := range opts.UpstreamServers {
+		if !u.PublishRoutes {
+			continue
+		}
+		syncer, err := upstream.NewRoutesSyncer(u, opts.GetSignatureData(), routes, opts.PublishedRoutesRefresh)
+		if err != nil {
+			return nil, nil, err
+		}
+		syncers = append(syncers, syncer)
+	}
+
+	return routes, syncers, nil
+}
+
+// newRoutesAllowlist builds a route allowlist from only the configured
+// SkipAuthRegex and SkipAuthRoutes
+func newRoutesAllowlist(opts *options.Options) (*allowlist.Routes, error) {
+	routes := allowlist.NewRoutes()
+
+	policy := allowlist.AllMethods
+	if opts.SkipAuthRegexSafeMethods {
+		policy = allowlist.SafeMethods
+	}
+	for _, path := range opts.SkipAuthRegex {
+		if err := routes.AddGlobalRegex(path, policy); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, methodPath := range opts.SkipAuthRoutes {
+		if err := routes.AddRoute(methodPath); err != nil {
+			return nil, err
+		}
+	}
 	return routes, nil
 }
 
+// buildTrustedRequestAllowlist builds an allowlist that requires both a
+// route and an IP of a TrustedRequest to match
+func buildTrustedRequestAllowlist(opts *options.Options, trustedRequest options.TrustedRequest) (*allowlist.All, error) {
+	routes := allowlist.NewRoutes()
+	for _, methodPath := range trustedRequest.Routes {
+		if err := routes.AddRoute(methodPath); err != nil {
+			return nil, err
+		}
+	}
+
+	ips := allowlist.NewIPs(opts.GetRealClientIPParser())
+	for _, ipStr := range trustedRequest.IPs {
+		if err := ips.Add(ipStr); err != nil {
+			return nil, err
+		}
+	}
+
+	return allowlist.NewAll(routes, ips), nil
+}
+
 // MakeCSRFCookie creates a cookie for CSRF
 func (p *OAuthProxy) MakeCSRFCookie(req *http.Request, value string, expiration time.Duration, now time.Time) *http.Cookie {
 	return p.makeCookie(req, p.CSRFCookieName, value, expiration, now)
@@ -418,6 +748,9 @@ func (p *OAuthProxy) LoadCookiedSession(req *http.Request) (*sessionsapi.Session
 
 // SaveSession creates a new session cookie value and sets this on the response
 func (p *OAuthProxy) SaveSession(rw http.ResponseWriter, req *http.Request, s *sessionsapi.SessionState) error {
+	if len(p.sessionFingerprint) > 0 {
+		s.Fingerprint = middleware.GetClientFingerprint(req, p.realClientIPParser, p.sessionFingerprint)
+	}
 	return p.sessionStore.Save(rw, req, s)
 }
 
@@ -474,6 +807,14 @@ func (p *OAuthProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 func (p *OAuthProxy) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 	if req.URL.Path != p.AuthOnlyPath && strings.HasPrefix(req.URL.Path, p.ProxyPrefix) {
 		prepareNoCache(rw)
+		if !p.checkParams(rw, req) {
+			return
+		}
+	}
+
+	if rule := p.deniedBy(rw, req, nil); rule != nil {
+		p.denyRequest(rw, req, rule)
+		return
 	}
 
 	switch path := req.URL.Path; {
@@ -489,6 +830,12 @@ func (p *OAuthProxy) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.OAuthStart(rw, req)
 	case path == p.OAuthCallbackPath:
 		p.OAuthCallback(rw, req)
+	case path == p.HandoffPath && p.authDomain != nil:
+		p.Handoff(rw, req)
+	case path == p.ShareLinkPath && p.shareLinks != nil:
+		p.ShareLink(rw, req)
+	case path == p.SessionJWKSPath && p.sessionJWKS != nil:
+		p.SessionJWKS(rw)
 	case path == p.AuthOnlyPath:
 		p.AuthOnly(rw, req)
 	case path == p.UserInfoPath:
@@ -498,6 +845,21 @@ func (p *OAuthProxy) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// checkParams rejects requests to the OAuth endpoints with a malformed state,
+// rd or login_hint parameter before they reach cookies or the provider
+func (p *OAuthProxy) checkParams(rw http.ResponseWriter, req *http.Request) bool {
+	param, err := p.paramLimits.check(req)
+	if err == nil {
+		return true
+	}
+	logger.Errorf("Rejecting request with an invalid %s parameter: %v", param, err)
+	if param == "state" {
+		failures.Record(failures.StateMismatch, req, err.Error())
+	}
+	p.ErrorPage(rw, http.StatusBadRequest, "Bad Request", err.Error())
+	return false
+}
+
 // RobotsTxt disallows scraping pages from the OAuthProxy
 func (p *OAuthProxy) RobotsTxt(rw http.ResponseWriter) {
 	_, err := fmt.Fprintf(rw, "User-agent: *\nDisallow: /")
@@ -528,40 +890,110 @@ func (p *OAuthProxy) ErrorPage(rw http.ResponseWriter, code int, title string, m
 	}
 }
 
-// IsAllowedRequest is used to check if auth should be skipped for this request
-func (p *OAuthProxy) IsAllowedRequest(req *http.Request) bool {
-	isPreflightRequestAllowed := p.skipAuthPreflight && req.Method == "OPTIONS"
-	return isPreflightRequestAllowed || p.isAllowedRoute(req) || p.isTrustedIP(req)
+// deniedBy returns the deny rule in the active rule set that matches the
+// request, or nil if the request is not denied.
+// Deny rules are checked before the allowlists and authentication without a
+// session, and again for authenticated requests when rules have session
+// conditions, such as required groups or claims.
+// Only the rules for the upstream the request is routed to are checked.
+func (p *OAuthProxy) deniedBy(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) *authorization.Rule {
+	if p.authorizationRules == nil {
+		return nil
+	}
+	name, engine := p.authorizationRules.Active()
+	rules := engine.ForUpstream(p.upstreamSelector.UpstreamID(req))
+	if session != nil && !rules.HasSessionRules() {
+		return nil
+	}
+	rule := rules.MatchDeny(req, session)
+	if rule == nil {
+		return nil
+	}
+
+	email := ""
+	if session != nil {
+		email = session.Email
+	}
+	logger.PrintAuthf(email, req, logger.AuthFailure, "Request denied by rule %s of authorization rule set %q", rule, name)
+	p.setDenyRuleHeaders(rw, rule)
+	p.recordDecision(req, session, name, rule)
+	return rule
 }
 
-// IsAllowedRoute is used to check if the request method & path is allowed without auth
-func (p *OAuthProxy) isAllowedRoute(req *http.Request) bool {
-	for _, route := range p.allowedRoutes {
-		if (route.method == "" || req.Method == route.method) && route.pathRegex.MatchString(req.URL.Path) {
-			return true
-		}
+// recordDecision streams the authorization decision for the request to the
+// SIEM endpoint, if configured. A nil rule records an allowed request.
+func (p *OAuthProxy) recordDecision(req *http.Request, session *sessionsapi.SessionState, ruleSet string, rule *authorization.Rule) {
+	if p.siemStreamer == nil {
+		return
 	}
-	return false
+	event := telemetry.Event{
+		Timestamp: time.Now(),
+		Allowed:   rule == nil,
+		RuleSet:   ruleSet,
+		Method:    req.Method,
+		Host:      req.Host,
+		Path:      req.URL.Path,
+		Query:     req.URL.RawQuery,
+		ClientIP:  ip.GetClientString(p.realClientIPParser, req, false),
+	}
+	if rule != nil {
+		event.Rule = rule.ID
+		event.Action = rule.Action.String()
+	}
+	if session != nil {
+		event.User = session.User
+		event.Email = session.Email
+	}
+	p.siemStreamer.Record(event)
 }
 
-// isTrustedIP is used to check if a request comes from a trusted client IP address.
-func (p *OAuthProxy) isTrustedIP(req *http.Request) bool {
-	if p.trustedIPs == nil {
-		return false
+// denyRequest responds to a request denied by the rule using the rule's action
+func (p *OAuthProxy) denyRequest(rw http.ResponseWriter, req *http.Request, rule *authorization.Rule) {
+	switch rule.Action {
+	case authorization.ForbiddenAction:
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case authorization.UnauthorizedAction:
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	case authorization.SignInAction:
+		if isAjax(req) {
+			p.errorJSON(rw, http.StatusUnauthorized)
+			return
+		}
+		p.promptSignIn(rw, req)
+	case authorization.JSONAction:
+		p.errorJSON(rw, http.StatusForbidden)
+	default:
+		p.ErrorPage(rw, http.StatusForbidden, "Forbidden", "Access to this resource is denied")
 	}
+}
 
-	remoteAddr, err := ip.GetClientIP(p.realClientIPParser, req)
-	if err != nil {
-		logger.Errorf("Error obtaining real IP for trusted IP list: %v", err)
-		// Possibly spoofed X-Real-IP header
-		return false
+// setDenyRuleHeaders identifies the deny rule that matched the request to the
+// request logger, and to the client when enabled
+func (p *OAuthProxy) setDenyRuleHeaders(rw http.ResponseWriter, rule *authorization.Rule) {
+	rw.Header().Set(gapRuleHeader, rule.ID)
+	if p.showDenyRule {
+		rw.Header().Set(denyRuleHeader, rule.ID)
 	}
+}
 
-	if remoteAddr == nil {
-		return false
+// IsAllowedRequest is used to check if auth should be skipped for this request.
+// Trusted requests are recorded in the allowlist audit sink.
+func (p *OAuthProxy) IsAllowedRequest(req *http.Request) bool {
+	entry, ok := trustedBy(p.allowlists, req)
+	if ok && p.allowlistAuditSink != nil {
+		p.allowlistAuditSink.Record(allowlist.NewAuditRecord(req, entry, p.realClientIPParser))
 	}
+	return ok
+}
 
-	return p.trustedIPs.Has(remoteAddr)
+// trustedBy returns the entry of the first allowlist that trusts the request
+func trustedBy(allowlists []allowlist.Allowlist, req *http.Request) (string, bool) {
+	for _, a := range allowlists {
+		if entry, ok := a.IsTrusted(req); ok {
+			return entry, true
+		}
+	}
+	return "", false
 }
 
 // SignInPage writes the sing in template to the response
@@ -601,7 +1033,7 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 		SignInMessage: template.HTML(p.SignInMessage),
 		CustomLogin:   p.displayHtpasswdForm,
 		Redirect:      redirectURL,
-		Version:       VERSION,
+		Version:       version.VERSION,
 		ProxyPrefix:   p.ProxyPrefix,
 		Footer:        template.HTML(p.Footer),
 	}
@@ -642,6 +1074,10 @@ func (p *OAuthProxy) SignIn(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
 		return
 	}
+	if p.authDomain != nil && !p.authDomain.handles(req) {
+		p.redirectToAuthDomain(rw, req, redirect)
+		return
+	}
 
 	user, ok := p.ManualSignIn(req)
 	if ok {
@@ -662,11 +1098,14 @@ func (p *OAuthProxy) SignIn(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-//UserInfo endpoint outputs session email and preferred username in JSON format
+// UserInfo endpoint outputs session email and preferred username in JSON format
 func (p *OAuthProxy) UserInfo(rw http.ResponseWriter, req *http.Request) {
 
 	session, err := p.getAuthenticatedSession(rw, req)
 	if err != nil {
+		if err == ErrRefreshInProgress {
+			rw.Header().Set("Retry-After", refreshRetryAfter)
+		}
 		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -725,6 +1164,10 @@ func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
 		return
 	}
+	if p.authDomain != nil && !p.authDomain.handles(req) {
+		p.redirectToAuthDomain(rw, req, redirect)
+		return
+	}
 	redirectURI := p.getOAuthRedirectURI(req)
 	http.Redirect(rw, req, p.provider.GetLoginURL(redirectURI, fmt.Sprintf("%v:%v", nonce, redirect)), http.StatusFound)
 }
@@ -744,6 +1187,7 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	errorString := req.Form.Get("error")
 	if errorString != "" {
 		logger.Errorf("Error while parsing OAuth2 callback: %s", errorString)
+		failures.Record(failures.ProviderError, req, fmt.Sprintf("provider returned error %q", errorString))
 		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", errorString)
 		return
 	}
@@ -751,6 +1195,7 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	session, err := p.redeemCode(req)
 	if err != nil {
 		logger.Errorf("Error redeeming code during OAuth2 callback: %v", err)
+		failures.Record(failures.ProviderError, req, fmt.Sprintf("error redeeming code: %v", err))
 		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", "Internal Error")
 		return
 	}
@@ -758,13 +1203,16 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	err = p.enrichSessionState(req.Context(), session)
 	if err != nil {
 		logger.Errorf("Error creating session during OAuth2 callback: %v", err)
+		failures.Record(failures.ProviderError, req, fmt.Sprintf("error creating session: %v", err))
 		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", "Internal Error")
 		return
 	}
+	p.identityNormalizers.NormalizeSession(session)
 
 	state := strings.SplitN(req.Form.Get("state"), ":", 2)
 	if len(state) != 2 {
 		logger.Error("Error while parsing OAuth2 state: invalid length")
+		failures.Record(failures.StateMismatch, req, "state has an invalid length")
 		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", "Invalid State")
 		return
 	}
@@ -773,12 +1221,14 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	c, err := req.Cookie(p.CSRFCookieName)
 	if err != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unable to obtain CSRF cookie")
+		failures.Record(failures.CSRFMismatch, req, "CSRF cookie missing")
 		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", err.Error())
 		return
 	}
 	p.ClearCSRFCookie(rw, req)
 	if c.Value != nonce {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: CSRF token mismatch, potential attack")
+		failures.Record(failures.CSRFMismatch, req, "CSRF token does not match the state")
 		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "CSRF Failed")
 		return
 	}
@@ -795,11 +1245,24 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	if p.Validator(session.Email) && authorized {
 		logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via OAuth2: %s", session)
 		err := p.SaveSession(rw, req, session)
+		if errors.Is(err, sessionsapi.ErrStoreUnavailable) {
+			logger.Errorf("Error saving session state for %s: %v", remoteAddr, err)
+			p.ErrorPage(rw, http.StatusServiceUnavailable, "Service Unavailable", "The session store is unavailable, please try again later")
+			return
+		}
 		if err != nil {
 			logger.Errorf("Error saving session state for %s: %v", remoteAddr, err)
 			p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
 			return
 		}
+		if p.authDomain != nil {
+			redirect, err = p.handoffRedirect(req.Context(), session, redirect)
+			if err != nil {
+				logger.Errorf("Error handing off session for %s: %v", remoteAddr, err)
+				p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
+				return
+			}
+		}
 		http.Redirect(rw, req, redirect, http.StatusFound)
 	} else {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unauthorized")
@@ -838,6 +1301,7 @@ func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.Sess
 func (p *OAuthProxy) AuthOnly(rw http.ResponseWriter, req *http.Request) {
 	session, err := p.getAuthenticatedSession(rw, req)
 	if err != nil {
+		p.setAuthOnlyCacheHeaders(rw, nil)
 		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
@@ -845,20 +1309,61 @@ func (p *OAuthProxy) AuthOnly(rw http.ResponseWriter, req *http.Request) {
 	// Unauthorized cases need to return 403 to prevent infinite redirects with
 	// subrequest architectures
 	if !authOnlyAuthorize(req, session) {
+		p.setAuthOnlyCacheHeaders(rw, nil)
 		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
+	if rule := p.deniedBy(rw, req, session); rule != nil {
+		// Unless the rule asks the user to sign in again
+		code := http.StatusForbidden
+		if rule.Action == authorization.SignInAction || rule.Action == authorization.UnauthorizedAction {
+			code = http.StatusUnauthorized
+		}
+		p.setAuthOnlyCacheHeaders(rw, nil)
+		http.Error(rw, http.StatusText(code), code)
+		return
+	}
+	p.recordDecision(req, session, "", nil)
 
 	// we are authenticated
 	p.addHeadersForProxying(rw, session)
-	p.headersChain.Then(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+	p.postAuthChain.Extend(p.headersChain).Then(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		p.setAuthOnlyCacheHeaders(rw, session)
 		rw.WriteHeader(http.StatusAccepted)
 	})).ServeHTTP(rw, req)
 }
 
+// setAuthOnlyCacheHeaders allows caches in front of the auth endpoint, such
+// as the nginx proxy_cache of an auth_request location, to reuse the
+// decision for an authenticated session for the AuthRequestCacheTTL.
+// The cache must key on the cookies and Authorization header of the request,
+// and the decision is never cached beyond the expiry of the session.
+// Responses without a session, or that set a cookie, are never cached.
+func (p *OAuthProxy) setAuthOnlyCacheHeaders(rw http.ResponseWriter, session *sessionsapi.SessionState) {
+	if p.authRequestCacheTTL <= 0 {
+		return
+	}
+	rw.Header().Set("Vary", "Cookie, Authorization")
+
+	ttl := p.authRequestCacheTTL
+	if session != nil && session.ExpiresOn != nil {
+		if remaining := time.Until(*session.ExpiresOn); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	seconds := int64(ttl / time.Second)
+	if session == nil || seconds <= 0 || len(rw.Header().Values("Set-Cookie")) > 0 {
+		prepareNoCache(rw)
+		return
+	}
+
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", seconds))
+	rw.Header().Set("X-Accel-Expires", strconv.FormatInt(seconds, 10))
+}
+
 // SkipAuthProxy proxies allowlisted requests and skips authentication
 func (p *OAuthProxy) SkipAuthProxy(rw http.ResponseWriter, req *http.Request) {
-	p.headersChain.Then(p.serveMux).ServeHTTP(rw, req)
+	p.headersChain.Extend(p.preProxyChain).Then(p.serveMux).ServeHTTP(rw, req)
 }
 
 // Proxy proxies the user request if the user is authenticated else it prompts
@@ -867,23 +1372,34 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	session, err := p.getAuthenticatedSession(rw, req)
 	switch err {
 	case nil:
+		if rule := p.deniedBy(rw, req, session); rule != nil {
+			p.denyRequest(rw, req, rule)
+			return
+		}
+		p.recordDecision(req, session, "", nil)
+
 		// we are authenticated
 		p.addHeadersForProxying(rw, session)
-		p.headersChain.Then(p.serveMux).ServeHTTP(rw, req)
-	case ErrNeedsLogin:
+		p.postAuthChain.Extend(p.headersChain).Extend(p.preProxyChain).Then(p.serveMux).ServeHTTP(rw, req)
+	case ErrNeedsLogin, ErrRefreshInProgress:
 		// we need to send the user to a login screen
 		if isAjax(req) {
 			// no point redirecting an AJAX request
+			if err == ErrRefreshInProgress {
+				// the session will be valid once the concurrent refresh
+				// completes, so tell the client to retry
+				rw.Header().Set("Retry-After", refreshRetryAfter)
+			}
 			p.errorJSON(rw, http.StatusUnauthorized)
 			return
 		}
-
-		if p.SkipProviderButton {
-			p.OAuthStart(rw, req)
-		} else {
-			p.SignInPage(rw, req, http.StatusForbidden)
+		if p.captivePortal && !isBrowserNavigation(req) {
+			p.networkAuthenticationRequired(rw, req)
+			return
 		}
 
+		p.promptSignIn(rw, req)
+
 	case ErrAccessDenied:
 		p.ErrorPage(rw, http.StatusUnauthorized, "Permission Denied", "Unauthorized")
 
@@ -895,6 +1411,18 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// promptSignIn sends the user to sign in to access the requested page
+func (p *OAuthProxy) promptSignIn(rw http.ResponseWriter, req *http.Request) {
+	switch {
+	case p.authDomain != nil && !p.authDomain.handles(req):
+		p.SignIn(rw, req)
+	case p.SkipProviderButton:
+		p.OAuthStart(rw, req)
+	default:
+		p.SignInPage(rw, req, http.StatusForbidden)
+	}
+}
+
 // See https://developers.google.com/web/fundamentals/performance/optimizing-content-efficiency/http-caching?hl=en
 var noCacheHeaders = map[string]string{
 	"Expires":         time.Unix(0, 0).Format(time.RFC1123),
@@ -1091,15 +1619,24 @@ func validOptionalPort(port string) bool {
 // Set-Cookie headers may be set on the response as a side-effect of calling this method.
 func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.Request) (*sessionsapi.SessionState, error) {
 	var session *sessionsapi.SessionState
+	var refreshInProgress bool
 
 	getSession := p.sessionChain.Then(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		session = middlewareapi.GetRequestScope(req).Session
+		scope := middlewareapi.GetRequestScope(req)
+		session = scope.Session
+		refreshInProgress = scope.RefreshInProgress
 	}))
 	getSession.ServeHTTP(rw, req)
 
+	if session == nil && refreshInProgress {
+		return nil, ErrRefreshInProgress
+	}
 	if session == nil {
 		return nil, ErrNeedsLogin
 	}
+	// Sessions saved before the normalization was configured, and sessions
+	// from bearer tokens and basic auth, are normalized when they are used
+	p.identityNormalizers.NormalizeSession(session)
 
 	invalidEmail := session.Email != "" && !p.Validator(session.Email)
 	authorized, err := p.provider.Authorize(req.Context(), session)
@@ -1126,7 +1663,7 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 // TODO (@NickMeves): This method is a placeholder to be extended but currently
 // fails the linter. Remove the nolint when functionality expands.
 //
-//nolint:S1008
+// nolint:S1008
 func authOnlyAuthorize(req *http.Request, s *sessionsapi.SessionState) bool {
 	// Allow secondary group restrictions based on the `allowed_groups`
 	// querystring parameter
@@ -1,10 +1,11 @@
-package main
+package server
 
 import (
 	"bufio"
 	"context"
 	"crypto"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -22,6 +24,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	sessionscookie "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
@@ -1194,6 +1197,31 @@ func TestUserInfoEndpointAccepted(t *testing.T) {
 	}
 }
 
+func TestUserInfoEndpointNormalizesIdentity(t *testing.T) {
+	test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.IdentityLowercase = true
+		opts.IdentityStripPlusAddress = true
+		opts.IdentityDomainAliases = []string{"old.example.com=example.com"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.req, _ = http.NewRequest("GET", test.opts.ProxyPrefix+"/userinfo", nil)
+
+	// A session saved before the normalization was configured
+	err = test.SaveSession(&sessions.SessionState{
+		User:        "John.Doe",
+		Email:       "John.Doe+Test@Old.Example.com",
+		AccessToken: "my_access_token",
+	})
+	assert.NoError(t, err)
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusOK, test.rw.Code)
+	bodyBytes, _ := ioutil.ReadAll(test.rw.Body)
+	assert.Equal(t, "{\"user\":\"john.doe\",\"email\":\"john.doe@example.com\"}\n", string(bodyBytes))
+}
+
 func TestUserInfoEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
 	test, err := NewUserInfoEndpointTest()
 	if err != nil {
@@ -1246,6 +1274,87 @@ func TestAuthOnlyEndpointUnauthorizedOnNoCookieSetError(t *testing.T) {
 	assert.Equal(t, "Unauthorized\n", string(bodyBytes))
 }
 
+func TestAuthOnlyEndpointCacheHeaders(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		cacheTTL             time.Duration
+		sessionExpiresIn     time.Duration
+		noSession            bool
+		expectedStatusCode   int
+		expectedCacheControl string
+		expectedAccelExpires string
+		expectedVary         string
+	}{
+		{
+			name:               "caching disabled",
+			expectedStatusCode: http.StatusAccepted,
+		},
+		{
+			name:                 "caching enabled",
+			cacheTTL:             5 * time.Second,
+			expectedStatusCode:   http.StatusAccepted,
+			expectedCacheControl: "max-age=5",
+			expectedAccelExpires: "5",
+			expectedVary:         "Cookie, Authorization",
+		},
+		{
+			name:               "session expiring before the TTL",
+			cacheTTL:           time.Hour,
+			sessionExpiresIn:   30 * time.Minute,
+			expectedStatusCode: http.StatusAccepted,
+			expectedVary:       "Cookie, Authorization",
+		},
+		{
+			name:                 "unauthenticated",
+			cacheTTL:             5 * time.Second,
+			noSession:            true,
+			expectedStatusCode:   http.StatusUnauthorized,
+			expectedCacheControl: "no-cache, no-store, must-revalidate, max-age=0",
+			expectedAccelExpires: "0",
+			expectedVary:         "Cookie, Authorization",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test, err := NewAuthOnlyEndpointTest("", func(opts *options.Options) {
+				opts.AuthRequestCacheTTL = tc.cacheTTL
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tc.noSession {
+				created := time.Now()
+				session := &sessions.SessionState{
+					Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created}
+				if tc.sessionExpiresIn > 0 {
+					expires := created.Add(tc.sessionExpiresIn)
+					session.ExpiresOn = &expires
+				}
+				err = test.SaveSession(session)
+				assert.NoError(t, err)
+				// Saving the session set its cookie on the recorder
+				test.rw = httptest.NewRecorder()
+			}
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedStatusCode, test.rw.Code)
+			assert.Equal(t, tc.expectedVary, test.rw.Header().Get("Vary"))
+			if tc.sessionExpiresIn > 0 {
+				// The remaining lifetime of the session is truncated to seconds
+				maxAge, err := strconv.Atoi(test.rw.Header().Get("X-Accel-Expires"))
+				assert.NoError(t, err)
+				assert.InDelta(t, tc.sessionExpiresIn.Seconds(), maxAge, 5)
+				assert.Equal(t, fmt.Sprintf("max-age=%d", maxAge), test.rw.Header().Get("Cache-Control"))
+				return
+			}
+			assert.Equal(t, tc.expectedCacheControl, test.rw.Header().Get("Cache-Control"))
+			assert.Equal(t, tc.expectedAccelExpires, test.rw.Header().Get("X-Accel-Expires"))
+		})
+	}
+}
+
 func TestAuthOnlyEndpointUnauthorizedOnExpiration(t *testing.T) {
 	test, err := NewAuthOnlyEndpointTest("", func(opts *options.Options) {
 		opts.Cookie.Expire = time.Duration(24) * time.Hour
@@ -1575,6 +1684,280 @@ func TestAuthSkippedForPreflightRequests(t *testing.T) {
 	assert.Equal(t, "response", rw.Body.String())
 }
 
+func newAuthDomainTest() (*ProcessCookieTest, error) {
+	return NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.AuthDomain = "auth.example.com"
+		opts.RawRedirectURL = "http://auth.example.com/oauth2/callback"
+		opts.WhitelistDomains = []string{".example.com"}
+	})
+}
+
+func TestAuthDomainRedirectsToSignIn(t *testing.T) {
+	test, err := newAuthDomainTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://app.example.com/page?q=1", nil)
+	test.proxy.ServeHTTP(test.rw, req)
+	assert.Equal(t, http.StatusFound, test.rw.Code)
+	signIn, err := url.Parse(test.rw.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "auth.example.com", signIn.Host)
+	assert.Equal(t, "/oauth2/sign_in", signIn.Path)
+
+	handoffURL, err := url.Parse(signIn.Query().Get("rd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app.example.com", handoffURL.Host)
+	assert.Equal(t, "/oauth2/handoff", handoffURL.Path)
+	assert.Equal(t, "/page?q=1", handoffURL.Query().Get("rd"))
+
+	cookies := test.rw.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "_oauth2_proxy_handoff", cookies[0].Name)
+	assert.Equal(t, "", cookies[0].Domain)
+	assert.Len(t, cookies[0].Value, 32)
+	assert.Equal(t, cookies[0].Value, handoffURL.Query().Get("nonce"))
+
+	rw := httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "http://auth.example.com/page", nil)
+	test.proxy.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+}
+
+func TestAuthDomainHandoff(t *testing.T) {
+	test, err := newAuthDomainTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now()
+	session := &sessions.SessionState{Email: "john.doe@example.com", AccessToken: "my_access_token", CreatedAt: &created}
+	start := "http://app.example.com/oauth2/handoff?rd=%2Fpage%3Fq%3D1&nonce=nonce"
+	nonceCookie := &http.Cookie{Name: "_oauth2_proxy_handoff", Value: "nonce"}
+
+	redirect, err := test.proxy.handoffRedirect(context.Background(), session, "http://auth.example.com/page")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://auth.example.com/page", redirect)
+
+	redirect, err = test.proxy.handoffRedirect(context.Background(), session, "http://app.example.com/page?q=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://app.example.com/page?q=1", redirect)
+
+	redirect, err = test.proxy.handoffRedirect(context.Background(), session, start)
+	assert.NoError(t, err)
+	handoffURL, err := url.Parse(redirect)
+	assert.NoError(t, err)
+	assert.Equal(t, "app.example.com", handoffURL.Host)
+	assert.Equal(t, "/oauth2/handoff", handoffURL.Path)
+	assert.Equal(t, "/page?q=1", handoffURL.Query().Get("rd"))
+	assert.Len(t, handoffURL.Query().Get("code"), 32)
+	assert.Empty(t, handoffURL.Query().Get("nonce"))
+
+	t.Run("on another host", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", strings.Replace(redirect, "app.example.com", "other.example.com", 1), nil)
+		test.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/oauth2/handoff", rw.Header().Get("Location"))
+		assert.Empty(t, rw.Result().Cookies())
+	})
+
+	t.Run("without a code", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://app.example.com/oauth2/handoff", nil)
+		test.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Empty(t, rw.Result().Cookies())
+	})
+
+	t.Run("without the nonce cookie", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", redirect, nil)
+		test.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/oauth2/handoff", rw.Header().Get("Location"))
+		assert.Empty(t, rw.Result().Cookies())
+	})
+
+	t.Run("with the nonce cookie of another sign in", func(t *testing.T) {
+		other, err := test.proxy.handoffRedirect(context.Background(), session, strings.Replace(start, "nonce=nonce", "nonce=other", 1))
+		assert.NoError(t, err)
+
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", other, nil)
+		req.AddCookie(nonceCookie)
+		test.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/oauth2/handoff", rw.Header().Get("Location"))
+		cookies := rw.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, "_oauth2_proxy_handoff", cookies[0].Name)
+		assert.Equal(t, "", cookies[0].Value)
+	})
+
+	t.Run("on the application host", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", redirect, nil)
+		req.AddCookie(nonceCookie)
+		test.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/page?q=1", rw.Header().Get("Location"))
+
+		req = httptest.NewRequest("GET", "http://app.example.com/page", nil)
+		for _, cookie := range rw.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		loaded, err := test.proxy.LoadCookiedSession(req)
+		assert.NoError(t, err)
+		assert.Equal(t, session.Email, loaded.Email)
+		assert.Equal(t, session.AccessToken, loaded.AccessToken)
+	})
+
+	t.Run("used again", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", redirect, nil)
+		req.AddCookie(nonceCookie)
+		test.proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusFound, rw.Code)
+		assert.Equal(t, "/oauth2/handoff", rw.Header().Get("Location"))
+	})
+}
+
+func TestShareLinks(t *testing.T) {
+	test, err := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.ShareLinkMaxExpiry = time.Hour
+		opts.DenyRoutes = []string{"GET=^/private/"}
+		opts.DenyRuleHeader = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now()
+	err = test.SaveSession(&sessions.SessionState{Email: "john.doe@example.com", AccessToken: "my_access_token", CreatedAt: &created})
+	assert.NoError(t, err)
+
+	shareRequest := func(method string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "http://app.example.com/oauth2/share", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range append(test.rw.Result().Cookies(), cookies...) {
+			req.AddCookie(cookie)
+		}
+		test.proxy.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := shareRequest("GET", nil, nil)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	csrf := struct {
+		CSRFToken string `json:"csrfToken"`
+	}{}
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &csrf))
+	assert.NotEmpty(t, csrf.CSRFToken)
+	csrfCookies := rw.Result().Cookies()
+	assert.Len(t, csrfCookies, 1)
+
+	share := func(method string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("csrf_token", csrf.CSRFToken)
+		return shareRequest(method, form, csrfCookies)
+	}
+	shareURL := func(rw *httptest.ResponseRecorder) string {
+		link := struct {
+			URL string `json:"url"`
+		}{}
+		assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &link))
+		return link.URL
+	}
+
+	rw = share("POST", url.Values{"path": {"/dashboards/1"}, "method": {"get"}, "expires_in": {"10m"}})
+	assert.Equal(t, http.StatusOK, rw.Code)
+	link := shareURL(rw)
+	assert.True(t, strings.HasPrefix(link, "http://app.example.com/dashboards/1?oauth2_share_token="))
+
+	assert.True(t, test.proxy.IsAllowedRequest(httptest.NewRequest("GET", link, nil)))
+	assert.False(t, test.proxy.IsAllowedRequest(httptest.NewRequest("POST", link, nil)))
+	assert.False(t, test.proxy.IsAllowedRequest(httptest.NewRequest("GET", strings.Replace(link, "/dashboards/1", "/dashboards/2", 1), nil)))
+	assert.False(t, test.proxy.IsAllowedRequest(httptest.NewRequest("GET", strings.Replace(link, "app.example.com", "other.example.com", 1), nil)))
+
+	t.Run("that have expired", func(t *testing.T) {
+		test.proxy.shareLinks.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+		defer func() { test.proxy.shareLinks.now = time.Now }()
+
+		rw := share("POST", url.Values{"path": {"/dashboards/1"}, "method": {"GET"}})
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.False(t, test.proxy.IsAllowedRequest(httptest.NewRequest("GET", shareURL(rw), nil)))
+	})
+
+	t.Run("for a method that is not denied", func(t *testing.T) {
+		rw := share("POST", url.Values{"path": {"/private/page"}, "method": {"POST"}})
+		assert.Equal(t, http.StatusOK, rw.Code)
+		link := shareURL(rw)
+
+		assert.True(t, test.proxy.IsAllowedRequest(httptest.NewRequest("POST", link, nil)))
+		assert.False(t, test.proxy.IsAllowedRequest(httptest.NewRequest("GET", link, nil)))
+	})
+
+	t.Run("that cannot be minted", func(t *testing.T) {
+		rw := share("POST", url.Values{"path": {"/private/page"}, "method": {"GET"}})
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.Empty(t, rw.Header().Get(denyRuleHeader))
+		assert.Empty(t, rw.Header().Get(gapRuleHeader))
+
+		assert.Equal(t, http.StatusBadRequest, share("POST", url.Values{"path": {"/private/page"}}).Code)
+		assert.Equal(t, http.StatusBadRequest, share("POST", url.Values{"path": {"/oauth2/userinfo"}, "method": {"GET"}}).Code)
+		assert.Equal(t, http.StatusBadRequest, share("POST", url.Values{"path": {"/page"}, "method": {"GET"}, "expires_in": {"2h"}}).Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, share("PUT", url.Values{"path": {"/page"}, "method": {"GET"}}).Code)
+	})
+
+	t.Run("without the CSRF token", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, shareRequest("POST", url.Values{"path": {"/page"}}, csrfCookies).Code)
+		assert.Equal(t, http.StatusForbidden, shareRequest("POST", url.Values{"path": {"/page"}, "csrf_token": {csrf.CSRFToken}}, nil).Code)
+		assert.Equal(t, http.StatusForbidden, shareRequest("POST", url.Values{"path": {"/page"}, "csrf_token": {"other"}}, csrfCookies).Code)
+	})
+}
+
+func TestStripShareLinkToken(t *testing.T) {
+	testCases := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{
+			name:     "without a token",
+			target:   "/page?a=1&b=2",
+			expected: "/page?a=1&b=2",
+		},
+		{
+			name:     "with only a token",
+			target:   "/page?oauth2_share_token=abc",
+			expected: "/page",
+		},
+		{
+			name:     "with a token between other parameters",
+			target:   "/page?b=2&oauth2_share_token=abc&a=1",
+			expected: "/page?b=2&a=1",
+		},
+		{
+			name:     "with an escaped token parameter",
+			target:   "/page?oauth2%5Fshare%5Ftoken=abc&a=1",
+			expected: "/page?a=1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var proxied *http.Request
+			handler := stripShareLinkToken(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				proxied = req
+			}))
+			req := httptest.NewRequest("GET", "http://app.example.com"+tc.target, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.expected, proxied.URL.RequestURI())
+		})
+	}
+}
+
 type SignatureAuthenticator struct {
 	auth hmacauth.HmacAuth
 }
@@ -1995,6 +2378,64 @@ func TestAjaxForbiddendRequest(t *testing.T) {
 	assert.NotEqual(t, applicationJSON, mime)
 }
 
+func TestCaptivePortal(t *testing.T) {
+	opts := baseTestOptions()
+	opts.CaptivePortal = true
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+	proxy, err := NewOAuthProxy(opts, func(email string) bool {
+		return true
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		header       http.Header
+		expectedCode int
+	}{
+		{
+			name:         "captive portal detection",
+			header:       http.Header{"User-Agent": []string{"CaptiveNetworkSupport-407.0.1 wispr"}},
+			expectedCode: http.StatusNetworkAuthenticationRequired,
+		},
+		{
+			name:         "non-browser client accepting anything",
+			header:       http.Header{"Accept": []string{"*/*"}},
+			expectedCode: http.StatusNetworkAuthenticationRequired,
+		},
+		{
+			name:         "browser accepting HTML",
+			header:       http.Header{"Accept": []string{"text/html,application/xhtml+xml,*/*;q=0.8"}},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "browser navigation",
+			header:       http.Header{"Sec-Fetch-Mode": []string{"navigate"}},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "AJAX request",
+			header:       http.Header{"Accept": []string{applicationJSON}},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/hotspot-detect.html?x=1", nil)
+			req.Header = tc.header
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+
+			if tc.expectedCode == http.StatusNetworkAuthenticationRequired {
+				assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+				assert.Contains(t, rw.Header().Get("Cache-Control"), "no-cache")
+				assert.Contains(t, rw.Body.String(), `<meta http-equiv="refresh" content="0; url=/oauth2/sign_in?rd=%2Fhotspot-detect.html%3Fx%3D1">`)
+			}
+		})
+	}
+}
+
 func TestClearSplitCookie(t *testing.T) {
 	opts := baseTestOptions()
 	opts.Cookie.Secret = base64CookieSecret
@@ -2514,6 +2955,113 @@ func TestTrustedIPs(t *testing.T) {
 	}
 }
 
+func TestDenyRules(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		remoteAddr   string
+		expectedCode int
+		expectedRule string
+	}{
+		{
+			name:         "TrustedIP",
+			method:       "GET",
+			path:         "/",
+			remoteAddr:   "127.0.0.1:43670",
+			expectedCode: 200,
+		},
+		{
+			name:         "DeniedRouteFromTrustedIP",
+			method:       "POST",
+			path:         "/admin/users",
+			remoteAddr:   "127.0.0.1:43670",
+			expectedCode: 403,
+			expectedRule: "deny-route-0",
+		},
+		{
+			name:         "OtherMethodFromTrustedIP",
+			method:       "GET",
+			path:         "/admin/users",
+			remoteAddr:   "127.0.0.1:43670",
+			expectedCode: 200,
+		},
+		{
+			name:         "DeniedIPOnSkipAuthRoute",
+			method:       "GET",
+			path:         "/public",
+			remoteAddr:   "192.168.0.1:43670",
+			expectedCode: 403,
+			expectedRule: "deny-ip-0",
+		},
+		{
+			name:         "DeniedIPOnSignIn",
+			method:       "GET",
+			path:         "/oauth2/start",
+			remoteAddr:   "192.168.0.1:43670",
+			expectedCode: 403,
+			expectedRule: "deny-ip-0",
+		},
+		{
+			name:         "SkipAuthRoute",
+			method:       "GET",
+			path:         "/public",
+			remoteAddr:   "10.0.0.1:43670",
+			expectedCode: 200,
+		},
+		{
+			name:         "DeniedRouteWithUnauthorizedAction",
+			method:       "GET",
+			path:         "/private/page",
+			remoteAddr:   "127.0.0.1:43670",
+			expectedCode: 401,
+			expectedRule: "deny-route-1",
+		},
+		{
+			name:         "DeniedRouteWithSignInAction",
+			method:       "GET",
+			path:         "/login/page",
+			remoteAddr:   "127.0.0.1:43670",
+			expectedCode: 302,
+			expectedRule: "deny-route-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.UpstreamServers = options.Upstreams{
+				{
+					ID:     "static",
+					Path:   "/",
+					Static: true,
+				},
+			}
+			opts.TrustedIPs = []string{"127.0.0.1"}
+			opts.SkipAuthRoutes = []string{"GET=^/public$"}
+			opts.DenyRoutes = []string{"POST=^/admin/", "GET=^/private/", "GET=^/login/"}
+			opts.DenyIPs = []string{"192.168.0.0/16"}
+			opts.DenyActions = []string{"deny-route-1=unauthorized", "deny-route-2=sign_in"}
+			opts.DenyRuleHeader = true
+			opts.SkipProviderButton = true
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tt.expectedCode, rw.Code)
+			assert.Equal(t, tt.expectedRule, rw.Header().Get("X-OAuth2-Proxy-Rule"))
+			// The request logger consumes the GAP header so it never reaches clients
+			assert.Equal(t, "", rw.Header().Get("GAP-Authorization-Rule"))
+		})
+	}
+}
+
 func Test_buildRoutesAllowlist(t *testing.T) {
 	type expectedAllowedRoute struct {
 		method      string
@@ -2653,18 +3201,23 @@ func Test_buildRoutesAllowlist(t *testing.T) {
 				SkipAuthRegex:  tc.skipAuthRegex,
 				SkipAuthRoutes: tc.skipAuthRoutes,
 			}
-			routes, err := buildRoutesAllowlist(opts)
+			routes, _, err := buildRoutesAllowlist(opts)
 			if tc.shouldError {
 				assert.Error(t, err)
 				return
 			}
 			assert.NoError(t, err)
 
-			for i, route := range routes {
-				assert.Greater(t, len(tc.expectedRoutes), i)
-				assert.Equal(t, route.method, tc.expectedRoutes[i].method)
-				assert.Equal(t, route.pathRegex.String(), tc.expectedRoutes[i].regexString)
+			expectedMessages := make([]string, 0, len(tc.expectedRoutes))
+			for _, route := range tc.expectedRoutes {
+				method := route.method
+				if method == "" {
+					method = "ALL"
+				}
+				expectedMessages = append(expectedMessages,
+					fmt.Sprintf("Skipping auth - Method: %s | Path: %s", method, route.regexString))
 			}
+			assert.Equal(t, expectedMessages, routes.LogMessages())
 		})
 	}
 }
@@ -2748,7 +3301,7 @@ func TestAllowedRequest(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			req, err := http.NewRequest(tc.method, tc.url, nil)
 			assert.NoError(t, err)
-			assert.Equal(t, tc.allowed, proxy.isAllowedRoute(req))
+			assert.Equal(t, tc.allowed, proxy.IsAllowedRequest(req))
 
 			rw := httptest.NewRecorder()
 			proxy.ServeHTTP(rw, req)
@@ -2938,3 +3491,131 @@ func TestAuthOnlyAllowedGroups(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthOnlySessionDenyRules(t *testing.T) {
+	testCases := []struct {
+		name               string
+		groups             []string
+		expectedStatusCode int
+	}{
+		{
+			name:               "UserNotInDeniedGroup",
+			groups:             []string{"employees"},
+			expectedStatusCode: http.StatusAccepted,
+		},
+		{
+			name:               "UserInDeniedGroup",
+			groups:             []string{"employees", "contractors"},
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			created := time.Now()
+			session := &sessions.SessionState{
+				Groups:      tc.groups,
+				Email:       "test",
+				AccessToken: "oauth_token",
+				CreatedAt:   &created,
+			}
+
+			test, err := NewAuthOnlyEndpointTest("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rule, err := authorization.NewRule("deny-contractors", authorization.DenyPolicy, nil, "", nil, nil)
+			assert.NoError(t, err)
+			rule.Groups = []string{"contractors"}
+			test.proxy.authorizationRules, err = authorization.NewRuleSets(authorization.DefaultRuleSet, map[string]*authorization.RulesEngine{
+				authorization.DefaultRuleSet: authorization.NewRulesEngine([]*authorization.Rule{rule}, nil),
+			})
+			assert.NoError(t, err)
+
+			err = test.SaveSession(session)
+			assert.NoError(t, err)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+
+			assert.Equal(t, tc.expectedStatusCode, test.rw.Code)
+		})
+	}
+}
+
+func TestAuthOnlyDenyExpressions(t *testing.T) {
+	testCases := []struct {
+		name               string
+		groups             []string
+		expectedStatusCode int
+	}{
+		{
+			name:               "UserInAdminsGroup",
+			groups:             []string{"employees", "admins"},
+			expectedStatusCode: http.StatusAccepted,
+		},
+		{
+			name:               "UserNotInAdminsGroup",
+			groups:             []string{"employees"},
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			created := time.Now()
+			session := &sessions.SessionState{
+				Groups:      tc.groups,
+				Email:       "test",
+				AccessToken: "oauth_token",
+				CreatedAt:   &created,
+			}
+
+			test, err := NewAuthOnlyEndpointTest("", func(opts *options.Options) {
+				opts.DenyExpressions = []string{"!('admins' in session.groups)"}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = test.SaveSession(session)
+			assert.NoError(t, err)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+
+			assert.Equal(t, tc.expectedStatusCode, test.rw.Code)
+		})
+	}
+}
+
+type fakeSyncer struct {
+	stopped chan struct{}
+}
+
+func (s *fakeSyncer) Run(done <-chan bool) {
+	<-done
+	close(s.stopped)
+}
+
+func TestStopStopsSyncers(t *testing.T) {
+	syncers := []*fakeSyncer{
+		{stopped: make(chan struct{})},
+		{stopped: make(chan struct{})},
+	}
+	p := &OAuthProxy{}
+	for _, syncer := range syncers {
+		p.syncers = append(p.syncers, syncer)
+	}
+	p.startSyncers()
+
+	p.Stop()
+	p.Stop()
+
+	for _, syncer := range syncers {
+		select {
+		case <-syncer.stopped:
+		case <-time.After(time.Second):
+			t.Fatal("syncer was not stopped")
+		}
+	}
+}
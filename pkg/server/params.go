@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// stateNonceRegex matches the nonce the state starts with, which is the CSRF
+// token set by OAuthStart
+var stateNonceRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// paramLimits are the maximum lengths of the parameters of the OAuth flow.
+// A limit of 0 does not cap the length.
+type paramLimits struct {
+	state     int
+	redirect  int
+	loginHint int
+}
+
+// newParamLimits constructs the parameter limits from the options
+func newParamLimits(opts *options.Options) paramLimits {
+	return paramLimits{
+		state:     opts.MaxStateLength,
+		redirect:  opts.MaxRedirectLength,
+		loginHint: opts.MaxLoginHintLength,
+	}
+}
+
+// check validates the state, rd and login_hint parameters of the request,
+// returning the parameter and an error describing why it is malformed.
+// The state must be a nonce and a redirect separated by a colon, and no
+// parameter may contain invalid UTF-8 or control characters.
+func (l paramLimits) check(req *http.Request) (string, error) {
+	if err := req.ParseForm(); err != nil {
+		// The handler reports the error
+		return "", nil
+	}
+
+	for _, param := range []struct {
+		name  string
+		limit int
+	}{
+		{name: "state", limit: l.state},
+		{name: "rd", limit: l.redirect},
+		{name: "login_hint", limit: l.loginHint},
+	} {
+		value := req.Form.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if param.limit > 0 && len(value) > param.limit {
+			return param.name, fmt.Errorf("%s parameter is too long (%d bytes, the maximum is %d)", param.name, len(value), param.limit)
+		}
+		if !validParamValue(value) {
+			return param.name, fmt.Errorf("%s parameter contains invalid characters", param.name)
+		}
+	}
+
+	if state := req.Form.Get("state"); state != "" {
+		parts := strings.SplitN(state, ":", 2)
+		if len(parts) != 2 || !stateNonceRegex.MatchString(parts[0]) {
+			return "state", fmt.Errorf("state parameter is malformed")
+		}
+	}
+	return "", nil
+}
+
+// validParamValue checks that the value is valid UTF-8 without control
+// characters
+func validParamValue(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamLimitsCheck(t *testing.T) {
+	limits := paramLimits{state: 64, redirect: 32, loginHint: 16}
+
+	testCases := []struct {
+		name          string
+		query         string
+		expectedParam string
+		expectedErr   string
+	}{
+		{
+			name:  "no parameters",
+			query: "",
+		},
+		{
+			name:  "valid parameters",
+			query: "state=0123abcd:/app&rd=/app&login_hint=user@example.com",
+		},
+		{
+			name:  "state without a redirect",
+			query: "state=nonce:",
+		},
+		{
+			name:          "state too long",
+			query:         "state=abcd:/" + strings.Repeat("a", 64),
+			expectedParam: "state",
+			expectedErr:   "state parameter is too long (70 bytes, the maximum is 64)",
+		},
+		{
+			name:          "state without a nonce",
+			query:         "state=:/app",
+			expectedParam: "state",
+			expectedErr:   "state parameter is malformed",
+		},
+		{
+			name:          "state without a separator",
+			query:         "state=0123abcd",
+			expectedParam: "state",
+			expectedErr:   "state parameter is malformed",
+		},
+		{
+			name:          "state with an invalid nonce",
+			query:         "state=01%2023:/app",
+			expectedParam: "state",
+			expectedErr:   "state parameter is malformed",
+		},
+		{
+			name:          "redirect too long",
+			query:         "rd=/" + strings.Repeat("a", 32),
+			expectedParam: "rd",
+			expectedErr:   "rd parameter is too long (33 bytes, the maximum is 32)",
+		},
+		{
+			name:          "redirect with a control character",
+			query:         "rd=/app%0d%0aSet-Cookie:x",
+			expectedParam: "rd",
+			expectedErr:   "rd parameter contains invalid characters",
+		},
+		{
+			name:          "login hint with invalid UTF-8",
+			query:         "login_hint=user%ff",
+			expectedParam: "login_hint",
+			expectedErr:   "login_hint parameter contains invalid characters",
+		},
+		{
+			name:          "login hint too long",
+			query:         "login_hint=" + strings.Repeat("a", 17),
+			expectedParam: "login_hint",
+			expectedErr:   "login_hint parameter is too long (17 bytes, the maximum is 16)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/oauth2/callback?"+tc.query, nil)
+			param, err := limits.check(req)
+			assert.Equal(t, tc.expectedParam, param)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+
+	t.Run("no limits", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/oauth2/start?rd=/"+strings.Repeat("a", 4096), nil)
+		param, err := paramLimits{}.check(req)
+		assert.Equal(t, "", param)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
+)
+
+const (
+	// DenyDecision is the decision for requests denied by a deny rule
+	DenyDecision = "deny"
+
+	// SkipAuthDecision is the decision for requests trusted by an allowlist,
+	// which are proxied without authentication
+	SkipAuthDecision = "skip_auth"
+
+	// AuthenticateDecision is the decision for requests that must be
+	// authenticated
+	AuthenticateDecision = "authenticate"
+
+	// AllowDecision is the decision for authenticated requests that are not
+	// denied by a deny rule with session conditions
+	AllowDecision = "allow"
+)
+
+// RulesCheck is how the proxy handles a request, according to its deny rules
+// and allowlists
+type RulesCheck struct {
+	Decision string `json:"decision"`
+
+	// RuleSet is the name of the active deny rule set
+	RuleSet string `json:"ruleSet,omitempty"`
+
+	// DenyRule and DenyAction are the deny rule that matched the request and
+	// how the proxy responds to it
+	DenyRule   string `json:"denyRule,omitempty"`
+	DenyAction string `json:"denyAction,omitempty"`
+
+	// AllowlistEntry is the allowlist entry that trusted the request
+	AllowlistEntry string `json:"allowlistEntry,omitempty"`
+
+	// SessionRules is set when deny rules have session conditions, and so
+	// are checked again once the request is authenticated
+	SessionRules bool `json:"sessionRules,omitempty"`
+}
+
+// CheckRules validates the deny rules and allowlists in the options and
+// checks how the proxy would handle the request before authentication.
+// Routes published by upstreams and trusted IPs discovered from cloud
+// metadata are not included, as they are only known at runtime.
+func CheckRules(opts *options.Options, req *http.Request) (*RulesCheck, error) {
+	return CheckSessionRules(opts, req, nil)
+}
+
+// CheckSessionRules is CheckRules for a request made with the session.
+// Requests that must be authenticated are checked again against the deny
+// rules with session conditions, and are allowed if none match.
+// Authorization by the provider, such as the allowed groups and emails, is
+// not checked.
+func CheckSessionRules(opts *options.Options, req *http.Request, session *sessionsapi.SessionState) (*RulesCheck, error) {
+	if err := validation.ValidateRules(opts); err != nil {
+		return nil, err
+	}
+	routes, err := newRoutesAllowlist(opts)
+	if err != nil {
+		return nil, err
+	}
+	trustedIPs, err := newTrustedIPsAllowlist(opts)
+	if err != nil {
+		return nil, err
+	}
+	allowlists, err := buildAllowlists(opts, routes, trustedIPs)
+	if err != nil {
+		return nil, err
+	}
+	upstreamID := upstream.NewSelector(opts.UpstreamServers).UpstreamID(req)
+	return checkRequest(opts.GetAuthorizationRules(), upstreamID, allowlists, req, session), nil
+}
+
+// CheckRequest is CheckSessionRules for the deny rules and allowlists of the
+// running proxy, including the changes made through the admin APIs and the
+// routes and trusted IPs only known at runtime.
+// The request is not proxied or recorded, but counts as a hit of the rules
+// it matches.
+func (p *OAuthProxy) CheckRequest(req *http.Request, session *sessionsapi.SessionState) *RulesCheck {
+	return checkRequest(p.authorizationRules, p.upstreamSelector.UpstreamID(req), p.allowlists, req, session)
+}
+
+// checkRequest decides how a request routed to the upstream is handled by
+// the active deny rule set, if there is one, and the allowlists
+func checkRequest(ruleSets *authorization.RuleSets, upstreamID string, allowlists []allowlist.Allowlist, req *http.Request, session *sessionsapi.SessionState) *RulesCheck {
+	check := &RulesCheck{}
+
+	var rules *authorization.UpstreamRules
+	if ruleSets != nil {
+		name, engine := ruleSets.Active()
+		rules = engine.ForUpstream(upstreamID)
+		check.RuleSet = name
+		check.SessionRules = rules.HasSessionRules()
+		if rule := rules.MatchDeny(req, nil); rule != nil {
+			check.deny(rule)
+			return check
+		}
+	}
+
+	if entry, ok := trustedBy(allowlists, req); ok {
+		check.Decision = SkipAuthDecision
+		check.AllowlistEntry = entry
+		return check
+	}
+
+	if session == nil {
+		check.Decision = AuthenticateDecision
+		return check
+	}
+	if rules != nil && rules.HasSessionRules() {
+		if rule := rules.MatchDeny(req, session); rule != nil {
+			check.deny(rule)
+			return check
+		}
+	}
+	check.Decision = AllowDecision
+	return check
+}
+
+// deny records the deny rule that matched the request
+func (c *RulesCheck) deny(rule *authorization.Rule) {
+	c.Decision = DenyDecision
+	c.DenyRule = rule.String()
+	c.DenyAction = rule.Action.String()
+}
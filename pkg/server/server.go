@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
+)
+
+// New constructs an http.Handler serving the complete OAuth2 Proxy
+// middleware chain, as run by the oauth2-proxy binary, so that it can be
+// embedded within other Go services.
+// The options are validated before the handler is built; use
+// options.NewOptions to start from the default configuration.
+// Any middlewares given wrap the proxy, the first being the outermost.
+func New(opts *options.Options, middlewares ...alice.Constructor) (http.Handler, error) {
+	return NewWithHooks(opts, Hooks{}, middlewares...)
+}
+
+// NewWithHooks constructs the same handler as New, additionally running the
+// hooks at their stages of request processing.
+// Hooks loaded from middleware plugins in the options run before these.
+func NewWithHooks(opts *options.Options, hooks Hooks, middlewares ...alice.Constructor) (http.Handler, error) {
+	proxy, err := NewProxy(opts)
+	if err != nil {
+		return nil, err
+	}
+	proxy.AddHooks(hooks)
+
+	return alice.New(middlewares...).Then(proxy), nil
+}
+
+// NewProxy validates the options and constructs the OAuthProxy with an
+// email validator built from them.
+// The proxy syncs its rules and allowlists in the background until Stop is
+// called.
+func NewProxy(opts *options.Options) (*OAuthProxy, error) {
+	if err := validation.Validate(opts); err != nil {
+		return nil, err
+	}
+
+	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
+	proxy, err := NewOAuthProxy(opts, validator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise OAuth2 Proxy: %v", err)
+	}
+	return proxy, nil
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("New", func() {
+	var opts *options.Options
+
+	BeforeEach(func() {
+		opts = options.NewOptions()
+		opts.UpstreamServers = options.Upstreams{
+			{
+				ID:   "upstream",
+				Path: "/",
+				URI:  "http://127.0.0.1:8080/",
+			},
+		}
+		opts.Cookie.Secret = "0123456789abcdef"
+		opts.ClientID = "client-id"
+		opts.ClientSecret = "client-secret"
+		opts.EmailDomains = []string{"*"}
+	})
+
+	It("serves the proxy", func() {
+		handler, err := New(opts)
+		Expect(err).ToNot(HaveOccurred())
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/ping", nil))
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Body.String()).To(Equal("OK"))
+	})
+
+	It("wraps the proxy with the given middlewares in order", func() {
+		addHeader := func(value string) func(http.Handler) http.Handler {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					rw.Header().Add("X-Middleware", value)
+					next.ServeHTTP(rw, req)
+				})
+			}
+		}
+
+		handler, err := New(opts, addHeader("first"), addHeader("second"))
+		Expect(err).ToNot(HaveOccurred())
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/ping", nil))
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(rw.Header().Values("X-Middleware")).To(Equal([]string{"first", "second"}))
+	})
+
+	It("returns an error for invalid options", func() {
+		opts.ClientID = ""
+
+		_, err := New(opts)
+		Expect(err).To(MatchError(ContainSubstring("missing setting: client-id")))
+	})
+})
+
+var _ = Describe("Hooks", func() {
+	var (
+		opts     *options.Options
+		upstream *httptest.Server
+		htpasswd *os.File
+		stages   []string
+		hooks    Hooks
+	)
+
+	record := func(stage string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if session := middlewareapi.GetRequestScope(req).Session; session != nil {
+					stage += " " + session.User
+				}
+				stages = append(stages, stage)
+				next.ServeHTTP(rw, req)
+			})
+		}
+	}
+
+	BeforeEach(func() {
+		stages = []string{}
+		upstream = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			stages = append(stages, "upstream")
+		}))
+
+		var err error
+		htpasswd, err = ioutil.TempFile("", "htpasswd")
+		Expect(err).ToNot(HaveOccurred())
+		// admin:Adm1n1str$t0r
+		_, err = htpasswd.WriteString("admin:{SHA}gXQeRH0bcaCfhAk2gOLm1uaePMA=\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(htpasswd.Close()).To(Succeed())
+
+		opts = options.NewOptions()
+		opts.UpstreamServers = options.Upstreams{
+			{
+				ID:   "upstream",
+				Path: "/",
+				URI:  upstream.URL,
+			},
+		}
+		opts.Cookie.Secret = "0123456789abcdef"
+		opts.ClientID = "client-id"
+		opts.ClientSecret = "client-secret"
+		opts.EmailDomains = []string{"*"}
+		opts.HtpasswdFile = htpasswd.Name()
+		opts.SkipAuthRoutes = []string{"^/public"}
+
+		hooks = Hooks{
+			PreAuth:  []alice.Constructor{record("pre-auth")},
+			PostAuth: []alice.Constructor{record("post-auth")},
+			PreProxy: []alice.Constructor{record("pre-proxy")},
+		}
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+		Expect(os.Remove(htpasswd.Name())).To(Succeed())
+	})
+
+	It("runs each stage in order for authenticated requests", func() {
+		handler, err := NewWithHooks(opts, hooks)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest("GET", "/private", nil)
+		req.SetBasicAuth("admin", "Adm1n1str$t0r")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(stages).To(Equal([]string{"pre-auth", "post-auth admin", "pre-proxy admin", "upstream"}))
+	})
+
+	It("skips the post-auth stage for requests that skip authentication", func() {
+		handler, err := NewWithHooks(opts, hooks)
+		Expect(err).ToNot(HaveOccurred())
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/public", nil))
+
+		Expect(rw.Code).To(Equal(http.StatusOK))
+		Expect(stages).To(Equal([]string{"pre-auth", "pre-proxy", "upstream"}))
+	})
+
+	It("only runs the pre-auth stage for unauthenticated requests", func() {
+		handler, err := NewWithHooks(opts, hooks)
+		Expect(err).ToNot(HaveOccurred())
+
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/private", nil))
+
+		Expect(rw.Code).To(Equal(http.StatusForbidden))
+		Expect(stages).To(Equal([]string{"pre-auth"}))
+	})
+
+	It("returns an error when a middleware plugin cannot be loaded", func() {
+		opts.MiddlewarePlugins = []string{"/does/not/exist.so"}
+
+		_, err := NewWithHooks(opts, hooks)
+		Expect(err).To(MatchError(ContainSubstring(`could not open middleware plugin "/does/not/exist.so"`)))
+	})
+})
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
+)
+
+// loadSessionJWKS returns the JSON encoded key set that sessions stored as
+// JWTs in cookies are validated with, or nil if sessions are not stored as
+// JWTs
+func loadSessionJWKS(opts *options.Options) ([]byte, error) {
+	if opts.Session.Type != options.CookieSessionStoreType || opts.Session.Cookie.Format != options.CookieFormatJWT {
+		return nil, nil
+	}
+	keys, err := cookie.LoadJWTKeys(&opts.Session.Cookie)
+	if err != nil {
+		return nil, err
+	}
+	jwks, err := json.Marshal(keys.PublicKeySet())
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the session JWKS: %v", err)
+	}
+	return jwks, nil
+}
+
+// SessionJWKS serves the key set that other services validate JWT cookie
+// sessions with
+func (p *OAuthProxy) SessionJWKS(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if _, err := rw.Write(p.sessionJWKS); err != nil {
+		logger.Printf("Error writing session JWKS: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authentication/failures"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+const (
+	// shareLinkTokenParam is the query parameter of the token of a share link
+	shareLinkTokenParam = "oauth2_share_token"
+
+	// shareLinkCSRFParam is the form value of the CSRF token that must be
+	// posted to mint a share link
+	shareLinkCSRFParam = "csrf_token"
+)
+
+// shareLinks is an allowlist of the requests made with share links: signed,
+// expiring URLs for a path that authenticated users mint to share the page
+// with people without an account
+type shareLinks struct {
+	seed      string
+	maxExpiry time.Duration
+	now       func() time.Time
+}
+
+// shareLink is the request a share link token allows, and who issued it
+type shareLink struct {
+	Method    string `json:"m"`
+	Path      string `json:"p"`
+	IssuedBy  string `json:"i"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func newShareLinks(opts *options.Options) *shareLinks {
+	if opts.ShareLinkMaxExpiry == 0 {
+		return nil
+	}
+	return &shareLinks{
+		seed:      opts.Cookie.Secret,
+		maxExpiry: opts.ShareLinkMaxExpiry,
+		now:       time.Now,
+	}
+}
+
+// shareLinkKey binds a share link token to the host it is issued for, so that
+// it cannot be used on another application
+func (s *shareLinks) shareLinkKey(host string) string {
+	return fmt.Sprintf("share:%s", host)
+}
+
+// newToken signs a token allowing requests with the method to the path on the
+// host until it expires
+func (s *shareLinks) newToken(host string, link shareLink) (string, error) {
+	value, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+	return encryption.SignedValue(s.seed, s.shareLinkKey(host), value, s.now())
+}
+
+// IsTrusted checks whether the request was made with a valid share link for
+// its host, method and path
+func (s *shareLinks) IsTrusted(req *http.Request) (string, bool) {
+	token := req.URL.Query().Get(shareLinkTokenParam)
+	if token == "" {
+		return "", false
+	}
+	value, _, ok := encryption.ParseSignedValue(&http.Cookie{Name: s.shareLinkKey(requestHostname(req)), Value: token}, s.seed)
+	if !ok {
+		return "", false
+	}
+
+	link := shareLink{}
+	if err := json.Unmarshal(value, &link); err != nil {
+		return "", false
+	}
+	if !s.now().Before(time.Unix(link.ExpiresAt, 0)) || link.Path != req.URL.Path || link.Method != req.Method {
+		return "", false
+	}
+	return fmt.Sprintf("share link for %s issued by %s", link.Path, link.IssuedBy), true
+}
+
+// LogMessages describes the share links allowlist
+func (s *shareLinks) LogMessages() []string {
+	return []string{fmt.Sprintf("Share links expiring within %s are trusted", s.maxExpiry)}
+}
+
+// stripShareLinkToken removes the share link token from the query of
+// requests before they are proxied, so that upstreams never see it.
+// The order of the other query parameters is kept.
+func stripShareLinkToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get(shareLinkTokenParam) == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		params := strings.Split(req.URL.RawQuery, "&")
+		kept := params[:0]
+		for _, param := range params {
+			key := strings.SplitN(param, "=", 2)[0]
+			if key, err := url.QueryUnescape(key); err == nil && key == shareLinkTokenParam {
+				continue
+			}
+			kept = append(kept, param)
+		}
+
+		rawQuery := strings.Join(kept, "&")
+		if query, _ := url.ParseQuery(rawQuery); query.Get(shareLinkTokenParam) != "" {
+			// The token was joined to another parameter with a semicolon
+			query.Del(shareLinkTokenParam)
+			rawQuery = query.Encode()
+		}
+
+		req = req.Clone(req.Context())
+		req.URL.RawQuery = rawQuery
+		req.RequestURI = req.URL.RequestURI()
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// ShareLink mints a share link for an authenticated user from the path,
+// method and expires_in form values. Users can only share requests that they
+// are not denied themselves.
+// The csrf_token form value must match the CSRF cookie set when the token
+// was fetched with a GET request, so that other sites cannot mint links on
+// behalf of the user.
+func (p *OAuthProxy) ShareLink(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := p.getAuthenticatedSession(rw, req)
+	if err != nil {
+		if err == ErrRefreshInProgress {
+			rw.Header().Set("Retry-After", refreshRetryAfter)
+		}
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		p.shareLinkCSRFToken(rw, req)
+		return
+	}
+	if !p.validShareLinkCSRFToken(req) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Share link CSRF token mismatch, potential attack")
+		failures.Record(failures.CSRFMismatch, req, "share link CSRF token does not match the cookie")
+		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "CSRF Failed")
+		return
+	}
+
+	link, err := p.parseShareLink(req)
+	if err != nil {
+		p.ErrorPage(rw, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+
+	// The shared request is only checked against the deny rules, so the
+	// headers identifying a matched rule must not end up in the response
+	shared := req.Clone(req.Context())
+	shared.Method = link.Method
+	shared.URL = &url.URL{Path: link.Path}
+	discard := &discardResponseWriter{header: http.Header{}}
+	if rule := p.deniedBy(discard, shared, nil); rule != nil {
+		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "The path cannot be shared")
+		return
+	}
+	if rule := p.deniedBy(discard, shared, session); rule != nil {
+		p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "The path cannot be shared")
+		return
+	}
+
+	link.IssuedBy = session.Email
+	token, err := p.shareLinks.newToken(requestHostname(req), link)
+	if err != nil {
+		logger.Errorf("Error creating share link: %v", err)
+		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	sharedURL := url.URL{
+		Scheme:   requestScheme(req),
+		Host:     requestutil.GetRequestHost(req),
+		Path:     link.Path,
+		RawQuery: url.Values{shareLinkTokenParam: {token}}.Encode(),
+	}
+	logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Issued share link for %s %s", shared.Method, link.Path)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(rw).Encode(struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{
+		URL:       sharedURL.String(),
+		ExpiresAt: time.Unix(link.ExpiresAt, 0).UTC(),
+	})
+	if err != nil {
+		logger.Printf("Error encoding share link: %v", err)
+	}
+}
+
+// shareLinkCSRFCookieName is the name of the cookie of the CSRF token for
+// minting share links
+func (p *OAuthProxy) shareLinkCSRFCookieName() string {
+	return fmt.Sprintf("%s_share_csrf", p.CookieName)
+}
+
+// shareLinkCSRFToken sets a new CSRF token for minting share links in a
+// cookie and returns it in the response, to be posted with the share link
+// form values
+func (p *OAuthProxy) shareLinkCSRFToken(rw http.ResponseWriter, req *http.Request) {
+	prepareNoCache(rw)
+	token, err := encryption.Nonce()
+	if err != nil {
+		logger.Errorf("Error obtaining nonce: %v", err)
+		p.ErrorPage(rw, http.StatusInternalServerError, "Internal Server Error", err.Error())
+		return
+	}
+	http.SetCookie(rw, p.makeCookie(req, p.shareLinkCSRFCookieName(), token, p.CookieExpire, time.Now()))
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(rw).Encode(struct {
+		CSRFToken string `json:"csrfToken"`
+	}{
+		CSRFToken: token,
+	})
+	if err != nil {
+		logger.Printf("Error encoding share link CSRF token: %v", err)
+	}
+}
+
+// validShareLinkCSRFToken checks that the posted CSRF token matches the
+// CSRF token cookie
+func (p *OAuthProxy) validShareLinkCSRFToken(req *http.Request) bool {
+	c, err := req.Cookie(p.shareLinkCSRFCookieName())
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(req.PostFormValue(shareLinkCSRFParam))) == 1
+}
+
+// parseShareLink reads the share link requested by the form values.
+// The method is required, so that a link never allows more than the request
+// checked against the deny rules. The link expires after the maximum expiry
+// unless expires_in is shorter.
+func (p *OAuthProxy) parseShareLink(req *http.Request) (shareLink, error) {
+	method := strings.ToUpper(req.FormValue("method"))
+	if method == "" {
+		return shareLink{}, errors.New("method is required")
+	}
+
+	path := req.FormValue("path")
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return shareLink{}, fmt.Errorf("path %q must be an absolute path", path)
+	}
+	if strings.HasPrefix(path, p.ProxyPrefix+"/") || path == p.ProxyPrefix {
+		return shareLink{}, fmt.Errorf("path %q cannot be an OAuth2 Proxy endpoint", path)
+	}
+
+	expiry := p.shareLinks.maxExpiry
+	if expiresIn := req.FormValue("expires_in"); expiresIn != "" {
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil || d <= 0 || d > p.shareLinks.maxExpiry {
+			return shareLink{}, fmt.Errorf("expires_in %q must be a duration up to %s", expiresIn, p.shareLinks.maxExpiry)
+		}
+		expiry = d
+	}
+
+	return shareLink{
+		Method:    method,
+		Path:      path,
+		ExpiresAt: p.shareLinks.now().Add(expiry).Unix(),
+	}, nil
+}
+
+// discardResponseWriter drops the headers and body written to it, for checks
+// that are not part of the response
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (rw *discardResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (rw *discardResponseWriter) WriteHeader(int) {}
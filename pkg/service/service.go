@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Name is the name the proxy runs under as a Windows service
+const Name = "oauth2-proxy"
+
+// Handler is how the service manager runs and controls the proxy
+type Handler struct {
+	// Serve serves requests until the proxy is stopped
+	Serve func()
+
+	// Stop gracefully stops the proxy, so that Serve returns once the
+	// active connections have finished
+	Stop func()
+
+	// Reload reloads what can change without restarting the proxy
+	Reload func()
+}
+
+// Run runs the proxy under the service manager that started it, and returns
+// once Serve returns.
+// As a Windows service, the proxy is controlled by the service control
+// manager. Otherwise it is stopped by SIGINT or SIGTERM and reloaded by
+// SIGHUP, and tells systemd when it is reloading and stopping.
+func Run(h Handler) error {
+	isService, err := isWindowsService()
+	if err != nil {
+		return fmt.Errorf("unable to determine whether the proxy runs as a Windows service: %v", err)
+	}
+	if isService {
+		return runWindowsService(h)
+	}
+
+	runWithSignals(h)
+	return nil
+}
+
+// runWithSignals serves until the proxy is stopped by a signal
+func runWithSignals(h Handler) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Serve()
+	}()
+
+	stopping := false
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-signals:
+			switch {
+			case sig == syscall.SIGHUP:
+				notify(stateReloading)
+				h.Reload()
+				notify(stateReady)
+			case !stopping:
+				stopping = true
+				notify(stateStopping)
+				h.Stop()
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	// listenFDsStart is the first file descriptor passed by systemd socket
+	// activation
+	listenFDsStart = 3
+
+	// stateReady tells systemd that the proxy is serving requests
+	stateReady = "READY=1"
+
+	// stateReloading tells systemd that the proxy is reloading, until it is
+	// ready again
+	stateReloading = "RELOADING=1"
+
+	// stateStopping tells systemd that the proxy is shutting down
+	stateStopping = "STOPPING=1"
+)
+
+// NotifyReady tells systemd that the proxy is serving requests
+func NotifyReady() {
+	notify(stateReady)
+}
+
+// notify sends a state to systemd, for services of Type=notify, logging any
+// error.
+// It does nothing if the proxy was not started by systemd with a notify
+// socket.
+func notify(state string) {
+	if err := sendNotification(os.Getenv("NOTIFY_SOCKET"), state); err != nil {
+		logger.Errorf("ERROR: %v", err)
+	}
+}
+
+// sendNotification sends a state to the systemd notify socket, if there is
+// one
+func sendNotification(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are given with a leading @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("unable to connect to the systemd notify socket: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("unable to notify systemd: %v", err)
+	}
+	return nil
+}
+
+// Listeners returns the sockets passed by systemd socket activation, by
+// their FileDescriptorName.
+// It returns no listeners if the proxy was not socket activated. The
+// activation environment is cleared so that it is not inherited by any
+// child processes.
+func Listeners() (map[string]net.Listener, error) {
+	count, names, err := parseListenEnv(os.Getpid(), os.Getenv)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make(map[string]net.Listener, count)
+	for i := 0; i < count; i++ {
+		name := names[i]
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("more than one socket activated listener is named %q", name)
+		}
+
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket activated file descriptor %d (%s) is not a listener: %v", listenFDsStart+i, name, err)
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
+
+// parseListenEnv reads the number and names of the file descriptors passed
+// to the process with the pid by systemd socket activation.
+// Descriptors without a name are named "unknown", like systemd does.
+func parseListenEnv(pid int, getenv func(string) string) (int, []string, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		// The descriptors were passed to another process
+		return 0, nil, nil
+	}
+
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return 0, nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+
+	names := make([]string, count)
+	fdNames := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	for i := range names {
+		names[i] = "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			names[i] = fdNames[i]
+		}
+	}
+	return count, names, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Systemd Suite", func() {
+	type parseListenEnvTableInput struct {
+		env           map[string]string
+		expectedCount int
+		expectedNames []string
+		expectedErr   string
+	}
+
+	DescribeTable("parseListenEnv",
+		func(in parseListenEnvTableInput) {
+			count, names, err := parseListenEnv(1234, func(key string) string {
+				return in.env[key]
+			})
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(in.expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(in.expectedCount))
+			Expect(names).To(Equal(in.expectedNames))
+		},
+		Entry("without socket activation", parseListenEnvTableInput{
+			env: map[string]string{},
+		}),
+		Entry("with sockets passed to another process", parseListenEnvTableInput{
+			env: map[string]string{
+				"LISTEN_PID": "1",
+				"LISTEN_FDS": "1",
+			},
+		}),
+		Entry("with named sockets", parseListenEnvTableInput{
+			env: map[string]string{
+				"LISTEN_PID":     "1234",
+				"LISTEN_FDS":     "2",
+				"LISTEN_FDNAMES": "https:admin",
+			},
+			expectedCount: 2,
+			expectedNames: []string{"https", "admin"},
+		}),
+		Entry("with unnamed sockets", parseListenEnvTableInput{
+			env: map[string]string{
+				"LISTEN_PID": "1234",
+				"LISTEN_FDS": "1",
+			},
+			expectedCount: 1,
+			expectedNames: []string{"unknown"},
+		}),
+		Entry("with an invalid count", parseListenEnvTableInput{
+			env: map[string]string{
+				"LISTEN_PID": "1234",
+				"LISTEN_FDS": "two",
+			},
+			expectedErr: `invalid LISTEN_FDS "two"`,
+		}),
+	)
+
+	Context("sendNotification", func() {
+		var dir string
+		var socket *net.UnixConn
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "notify")
+			Expect(err).ToNot(HaveOccurred())
+
+			socket, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			socket.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("sends the state to the notify socket", func() {
+			Expect(sendNotification(filepath.Join(dir, "notify.sock"), stateReady)).To(Succeed())
+
+			buf := make([]byte, 64)
+			n, err := socket.Read(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(buf[:n])).To(Equal("READY=1"))
+		})
+
+		It("does nothing without a notify socket", func() {
+			Expect(sendNotification("", stateReady)).To(Succeed())
+		})
+
+		It("returns an error when the notify socket is missing", func() {
+			err := sendNotification(filepath.Join(dir, "missing.sock"), stateReady)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,61 @@
+// +build windows
+
+package service
+
+import (
+	"golang.org/x/sys/windows/svc"
+)
+
+// isWindowsService checks whether the proxy was started by the Windows
+// service control manager, rather than from an interactive session
+func isWindowsService() (bool, error) {
+	interactive, err := svc.IsAnInteractiveSession()
+	return !interactive, err
+}
+
+// runWindowsService runs the proxy as a Windows service until it is stopped
+func runWindowsService(h Handler) error {
+	return svc.Run(Name, &windowsService{handler: h})
+}
+
+// windowsService controls the proxy on the requests of the service control
+// manager.
+// Stop and shutdown requests stop the proxy gracefully, and parameter change
+// requests (sc.exe control oauth2-proxy paramchange) reload it.
+type windowsService struct {
+	handler Handler
+}
+
+// Execute serves requests until the proxy is stopped
+func (s *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	status <- svc.Status{State: svc.StartPending}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.handler.Serve()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	stopping := false
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.ParamChange:
+				s.handler.Reload()
+			case svc.Stop, svc.Shutdown:
+				if !stopping {
+					stopping = true
+					status <- svc.Status{State: svc.StopPending}
+					s.handler.Stop()
+				}
+			}
+		}
+	}
+}
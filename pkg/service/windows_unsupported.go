@@ -0,0 +1,14 @@
+// +build !windows
+
+package service
+
+import "errors"
+
+// isWindowsService is always false on other platforms
+func isWindowsService() (bool, error) {
+	return false, nil
+}
+
+func runWindowsService(Handler) error {
+	return errors.New("windows services are not supported on this platform")
+}
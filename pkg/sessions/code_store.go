@@ -0,0 +1,77 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/redis"
+)
+
+// NewCodeStore creates the CodeStore of single use codes, with the prefix
+// prepended to the codes. Like refresh locks, codes are stored in redis when
+// sessions or their tokens are, so that they are shared by all instances.
+// Otherwise they are stored in memory, and can only be taken on the instance
+// that saved them.
+func NewCodeStore(opts *options.SessionOptions, prefix string) (sessions.CodeStore, error) {
+	if opts.Type != options.RedisSessionStoreType && !opts.Cookie.OverflowToRedis && !opts.Cookie.SplitTokens {
+		return newMemoryCodeStore(), nil
+	}
+	store, err := redis.NewRedisCodeStore(opts, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// memoryCodeStore stores codes in memory until they are taken or expire
+type memoryCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]memoryCode
+	now   func() time.Time
+}
+
+type memoryCode struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryCodeStore() *memoryCodeStore {
+	return &memoryCodeStore{
+		codes: make(map[string]memoryCode),
+		now:   time.Now,
+	}
+}
+
+// Save stores the value under the code, removing expired codes
+func (s *memoryCodeStore) Save(_ context.Context, code string, value []byte, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for c, stored := range s.codes {
+		if !now.Before(stored.expiresAt) {
+			delete(s.codes, c)
+		}
+	}
+	s.codes[code] = memoryCode{value: value, expiresAt: now.Add(expiration)}
+	return nil
+}
+
+// Take returns and removes the value of the code, unless it has expired
+func (s *memoryCodeStore) Take(_ context.Context, code string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.codes[code]
+	if !ok {
+		return nil, sessions.ErrCodeNotFound
+	}
+	delete(s.codes, code)
+	if !s.now().Before(stored.expiresAt) {
+		return nil, sessions.ErrCodeNotFound
+	}
+	return stored.value, nil
+}
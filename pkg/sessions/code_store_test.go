@@ -0,0 +1,89 @@
+package sessions_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewCodeStore", func() {
+	ctx := context.Background()
+
+	codeStoreTests := func(newStore func() sessionsapi.CodeStore) {
+		It("takes a saved code once", func() {
+			store := newStore()
+			Expect(store.Save(ctx, "code", []byte("value"), time.Minute)).To(Succeed())
+
+			value, err := store.Take(ctx, "code")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal([]byte("value")))
+
+			_, err = store.Take(ctx, "code")
+			Expect(err).To(Equal(sessionsapi.ErrCodeNotFound))
+		})
+
+		It("does not take an unknown code", func() {
+			_, err := newStore().Take(ctx, "unknown")
+			Expect(err).To(Equal(sessionsapi.ErrCodeNotFound))
+		})
+	}
+
+	Context("with cookie sessions", func() {
+		codeStoreTests(func() sessionsapi.CodeStore {
+			store, err := sessions.NewCodeStore(&options.SessionOptions{Type: options.CookieSessionStoreType}, "prefix-")
+			Expect(err).ToNot(HaveOccurred())
+			return store
+		})
+
+		It("does not take an expired code", func() {
+			store, err := sessions.NewCodeStore(&options.SessionOptions{Type: options.CookieSessionStoreType}, "prefix-")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.Save(ctx, "code", []byte("value"), -time.Second)).To(Succeed())
+
+			_, err = store.Take(ctx, "code")
+			Expect(err).To(Equal(sessionsapi.ErrCodeNotFound))
+		})
+	})
+
+	Context("with redis sessions", func() {
+		var mr *miniredis.Miniredis
+
+		BeforeEach(func() {
+			var err error
+			mr, err = miniredis.Run()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			mr.Close()
+		})
+
+		newRedisStore := func() sessionsapi.CodeStore {
+			opts := &options.SessionOptions{Type: options.RedisSessionStoreType}
+			opts.Redis.ConnectionURL = "redis://" + mr.Addr()
+			store, err := sessions.NewCodeStore(opts, "prefix-")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store).To(BeAssignableToTypeOf(&redis.CodeStore{}))
+			return store
+		}
+
+		codeStoreTests(newRedisStore)
+
+		It("shares codes between instances", func() {
+			Expect(newRedisStore().Save(ctx, "code", []byte("value"), time.Minute)).To(Succeed())
+			Expect(mr.Exists("prefix-code")).To(BeTrue())
+
+			value, err := newRedisStore().Take(ctx, "code")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal([]byte("value")))
+			Expect(mr.Exists("prefix-code")).To(BeFalse())
+		})
+	})
+})
@@ -3,6 +3,7 @@ package cookie
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"regexp"
 	"time"
@@ -30,20 +31,67 @@ type SessionStore struct {
 	Cookie       *options.Cookie
 	CookieCipher encryption.Cipher
 	Minimal      bool
+
+	// Fields are the session fields stored in the cookie, or all fields if
+	// empty
+	Fields []string
+
+	// Compression is the algorithm sessions are compressed with at the
+	// CompressionLevel, and CompressionThreshold the size in bytes of the
+	// smallest sessions that are compressed
+	Compression          sessions.Compression
+	CompressionLevel     int
+	CompressionThreshold int
+
+	// SizeWarningThreshold is the size in bytes of the session cookies above
+	// which a warning is logged, or 0 to never warn
+	SizeWarningThreshold int
+
+	// JWTKeys sign the sessions as JWTs instead of encrypting them with the
+	// CookieCipher, if set, for the JWTAudience
+	JWTKeys     *sessions.JWTKeys
+	JWTAudience string
 }
 
 // Save takes a sessions.SessionState and stores the information from it
 // within Cookies set on the HTTP response writer
 func (s *SessionStore) Save(rw http.ResponseWriter, req *http.Request, ss *sessions.SessionState) error {
+	cookies, err := s.SessionCookies(req, ss)
+	if err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		http.SetCookie(rw, c)
+	}
+	return nil
+}
+
+// SessionCookies returns the cookies that Save sets to store the session
+func (s *SessionStore) SessionCookies(req *http.Request, ss *sessions.SessionState) ([]*http.Cookie, error) {
 	if ss.CreatedAt == nil || ss.CreatedAt.IsZero() {
 		now := time.Now()
 		ss.CreatedAt = &now
 	}
 	value, err := s.cookieForSession(ss)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return s.setSessionCookie(rw, req, value, *ss.CreatedAt)
+	cookies, err := s.makeSessionCookie(req, value, *ss.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for _, c := range cookies {
+		size += len(c.String())
+	}
+	sessions.ObserveCookieSessionSize(size)
+	if s.SizeWarningThreshold > 0 && size > s.SizeWarningThreshold {
+		logger.Errorf("WARNING: The session cookies for %s (%d bytes in %d cookies) exceed session_cookie_size_warning_threshold (%d bytes). "+
+			"Large or split cookies may be dropped by browsers and proxies, consider session_cookie_fields or a redis session store.",
+			ss.Email, size, len(cookies), s.SizeWarningThreshold)
+	}
+	return cookies, nil
 }
 
 // Load reads sessions.SessionState information from Cookies within the
@@ -54,9 +102,12 @@ func (s *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
 		// always http.ErrNoCookie
 		return nil, fmt.Errorf("cookie %q not present", s.Cookie.Name)
 	}
-	val, _, ok := encryption.Validate(c, s.Cookie.Secret, s.Cookie.Expire)
-	if !ok {
-		return nil, errors.New("cookie signature not valid")
+	if s.JWTKeys != nil {
+		return s.loadJWT(c)
+	}
+	val, err := sessions.ValidateCookie(c, s.Cookie.Secret, s.Cookie.Expire)
+	if err != nil {
+		return nil, err
 	}
 
 	session, err := sessions.DecodeSessionState(val, s.CookieCipher, true)
@@ -66,6 +117,19 @@ func (s *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
 	return session, nil
 }
 
+// loadJWT decodes a session stored as a JWT for the JWTAudience. Like signed
+// cookies, the session expires with the cookie, which is its "exp" claim.
+func (s *SessionStore) loadJWT(c *http.Cookie) (*sessions.SessionState, error) {
+	session, err := sessions.DecodeSessionStateJWT(c.Value, s.JWTKeys, s.JWTAudience, time.Now())
+	if errors.Is(err, sessions.ErrCookieExpired) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", sessions.ErrSignatureNotValid, err)
+	}
+	return session, nil
+}
+
 // Clear clears any saved session information by writing a cookie to
 // clear the session
 func (s *SessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
@@ -85,35 +149,32 @@ func (s *SessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
 
 // cookieForSession serializes a session state for storage in a cookie
 func (s *SessionStore) cookieForSession(ss *sessions.SessionState) ([]byte, error) {
-	if s.Minimal && (ss.AccessToken != "" || ss.IDToken != "" || ss.RefreshToken != "") {
+	if len(s.Fields) > 0 {
+		ss = ss.PruneFields(s.Fields)
+	} else if s.Minimal && (ss.AccessToken != "" || ss.IDToken != "" || ss.RefreshToken != "") {
 		minimal := *ss
 		minimal.AccessToken = ""
 		minimal.IDToken = ""
 		minimal.RefreshToken = ""
-
-		return minimal.EncodeSessionState(s.CookieCipher, true)
+		ss = &minimal
 	}
 
-	return ss.EncodeSessionState(s.CookieCipher, true)
-}
-
-// setSessionCookie adds the user's session cookie to the response
-func (s *SessionStore) setSessionCookie(rw http.ResponseWriter, req *http.Request, val []byte, created time.Time) error {
-	cookies, err := s.makeSessionCookie(req, val, created)
-	if err != nil {
-		return err
-	}
-	for _, c := range cookies {
-		http.SetCookie(rw, c)
+	if s.JWTKeys != nil {
+		token, err := ss.EncodeSessionStateJWT(s.JWTKeys, s.JWTAudience, ss.CreatedAt.Add(s.Cookie.Expire))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(token), nil
 	}
-	return nil
+	return ss.EncodeSessionStateWith(s.CookieCipher, s.Compression, s.CompressionLevel, s.CompressionThreshold)
 }
 
 // makeSessionCookie creates an http.Cookie containing the authenticated user's
 // authentication details
 func (s *SessionStore) makeSessionCookie(req *http.Request, value []byte, now time.Time) ([]*http.Cookie, error) {
 	strValue := string(value)
-	if strValue != "" {
+	// JWTs are signed already, and are read by other services as they are
+	if strValue != "" && s.JWTKeys == nil {
 		var err error
 		strValue, err = encryption.SignedValue(s.Cookie.Secret, s.Cookie.Name, value, now)
 		if err != nil {
@@ -145,12 +206,49 @@ func NewCookieSessionStore(opts *options.SessionOptions, cookieOpts *options.Coo
 	if err != nil {
 		return nil, fmt.Errorf("error initialising cipher: %v", err)
 	}
+	integrity, err := sessions.NewIntegrityCheck([]byte(cookieOpts.Secret), opts.IntegrityStrict)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := sessions.Compression(opts.Cookie.Compression)
+	if compression == "" {
+		compression = sessions.LZ4Compression
+	}
 
-	return &SessionStore{
-		CookieCipher: cipher,
+	store := &SessionStore{
+		CookieCipher: sessions.WithIntegrityCheck(cipher, integrity),
 		Cookie:       cookieOpts,
 		Minimal:      opts.Cookie.Minimal,
-	}, nil
+		Fields:       opts.Cookie.Fields,
+
+		Compression:          compression,
+		CompressionLevel:     opts.Cookie.CompressionLevel,
+		CompressionThreshold: opts.Cookie.CompressionThreshold,
+		SizeWarningThreshold: opts.Cookie.SizeWarningThreshold,
+	}
+	if opts.Cookie.Format == options.CookieFormatJWT {
+		store.JWTKeys, err = LoadJWTKeys(&opts.Cookie)
+		if err != nil {
+			return nil, err
+		}
+		store.JWTAudience = opts.Cookie.JWTAudience
+	}
+	return store, nil
+}
+
+// LoadJWTKeys loads the keys of JWT cookie sessions, from the key file and
+// the encryption key of the options
+func LoadJWTKeys(opts *options.CookieStoreOptions) (*sessions.JWTKeys, error) {
+	privateKey, err := ioutil.ReadFile(opts.JWTKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read session JWT key file: %v", err)
+	}
+	var encryptionKey []byte
+	if opts.JWTEncryptionKey != "" {
+		encryptionKey = encryption.SecretBytes(opts.JWTEncryptionKey)
+	}
+	return sessions.NewJWTKeys(privateKey, encryptionKey)
 }
 
 // splitCookie reads the full cookie generated to store the session and splits
@@ -1,9 +1,18 @@
 package cookie
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	mathrand "math/rand"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -32,6 +41,88 @@ var _ = Describe("Cookie SessionStore Tests", func() {
 		}, nil)
 })
 
+func Test_cookieForSessionFields(t *testing.T) {
+	store, err := NewCookieSessionStore(&options.SessionOptions{
+		Cookie: options.CookieStoreOptions{Fields: []string{"access_token", "email"}},
+	}, &options.Cookie{Secret: "0123456789abcdef"})
+	assert.NoError(t, err)
+	s := store.(*SessionStore)
+
+	value, err := s.cookieForSession(&sessionsapi.SessionState{
+		AccessToken: "access.token",
+		IDToken:     "id.token",
+		Email:       "email@email.email",
+		User:        "some.user",
+	})
+	assert.NoError(t, err)
+
+	ss, err := sessionsapi.DecodeSessionState(value, s.CookieCipher, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &sessionsapi.SessionState{AccessToken: "access.token", Email: "email@email.email"}, ss)
+}
+
+func Test_jwtSessionCookies(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	keyFile, err := ioutil.TempFile("", "session-jwt-key")
+	assert.NoError(t, err)
+	defer os.Remove(keyFile.Name())
+	assert.NoError(t, pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, keyFile.Close())
+
+	store, err := NewCookieSessionStore(&options.SessionOptions{
+		Cookie: options.CookieStoreOptions{Format: options.CookieFormatJWT, JWTKeyFile: keyFile.Name(), JWTAudience: "sidecars"},
+	}, &options.Cookie{Name: "_oauth2_proxy", Secret: "0123456789abcdef", Expire: time.Hour})
+	assert.NoError(t, err)
+
+	created := time.Now().Truncate(time.Second)
+	ss := &sessionsapi.SessionState{CreatedAt: &created, Email: "email@email.email", User: "some.user"}
+	req := httptest.NewRequest("GET", "/", nil)
+	cookies, err := store.(*SessionStore).SessionCookies(req, ss)
+	assert.NoError(t, err)
+	assert.Len(t, cookies, 1)
+	// The cookie is the JWT, not a value signed with the cookie secret
+	assert.Len(t, strings.Split(cookies[0].Value, "."), 3)
+
+	req.AddCookie(cookies[0])
+	loaded, err := store.Load(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "email@email.email", loaded.Email)
+	assert.Equal(t, "some.user", loaded.User)
+	assert.True(t, created.Equal(*loaded.CreatedAt))
+
+	// The session expires with the cookie
+	expired := created.Add(-2 * time.Hour)
+	ss.CreatedAt = &expired
+	cookies, err = store.(*SessionStore).SessionCookies(req, ss)
+	assert.NoError(t, err)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	_, err = store.Load(req)
+	assert.Equal(t, sessionsapi.ErrCookieExpired, err)
+
+	// Sessions for another audience are rejected
+	other, err := NewCookieSessionStore(&options.SessionOptions{
+		Cookie: options.CookieStoreOptions{Format: options.CookieFormatJWT, JWTKeyFile: keyFile.Name(), JWTAudience: "other"},
+	}, &options.Cookie{Name: "_oauth2_proxy", Secret: "0123456789abcdef", Expire: time.Hour})
+	assert.NoError(t, err)
+	ss.CreatedAt = &created
+	cookies, err = other.(*SessionStore).SessionCookies(req, ss)
+	assert.NoError(t, err)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	_, err = store.Load(req)
+	assert.True(t, errors.Is(err, sessionsapi.ErrSignatureNotValid))
+
+	// Tampered sessions are rejected
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "_oauth2_proxy", Value: cookies[0].Value + "x"})
+	_, err = store.Load(req)
+	assert.True(t, errors.Is(err, sessionsapi.ErrSignatureNotValid))
+}
+
 func Test_copyCookie(t *testing.T) {
 	expire, _ := time.Parse(time.RFC3339, "2020-03-17T00:00:00Z")
 	c := &http.Cookie{
@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
+)
+
+// Ensure overflowSessionStore implements the interface
+var _ sessions.SessionStore = &overflowSessionStore{}
+
+// overflowSessionStore stores sessions in cookies, unless the Set-Cookie
+// headers would be larger than a CDN or WAF in front of the proxy accepts.
+// Those sessions are stored server side instead, with only a ticket in the
+// cookie. Without a server side store, oversized sessions are only logged.
+type overflowSessionStore struct {
+	cookies *cookie.SessionStore
+	server  sessions.SessionStore
+	maxSize int
+}
+
+// Save stores the session in cookies if they fit within the maximum size,
+// otherwise in the server side store
+func (s *overflowSessionStore) Save(rw http.ResponseWriter, req *http.Request, ss *sessions.SessionState) error {
+	cookies, err := s.cookies.SessionCookies(req, ss)
+	if err != nil {
+		return err
+	}
+
+	size := setCookieSize(cookies)
+	if size > s.maxSize {
+		if s.server != nil {
+			logger.Errorf("WARNING: The session cookies for %s (%d bytes) exceed session_cookie_max_size (%d bytes) and would be dropped by the edge. Storing the session in redis instead.", sessions.RedactIdentity(ss.Email), size, s.maxSize)
+			if err := s.cookies.Clear(rw, req); err != nil {
+				return err
+			}
+			return s.server.Save(rw, req, ss)
+		}
+		logger.Errorf("WARNING: The session cookies for %s (%d bytes) exceed session_cookie_max_size (%d bytes) and may be dropped by the edge. Please use server side session storage (eg. Redis) instead.", sessions.RedactIdentity(ss.Email), size, s.maxSize)
+	}
+
+	if s.server != nil {
+		// Remove a previous server side session. The request may carry a
+		// cookie session instead of a ticket, so errors are ignored.
+		s.clearServerSession(rw, req, cookies)
+	}
+	for _, c := range cookies {
+		http.SetCookie(rw, c)
+	}
+	return nil
+}
+
+// clearServerSession clears the server side session, keeping only the
+// clearing cookies that the session cookies do not replace, so that clients
+// are not sent two cookies with the same name
+func (s *overflowSessionStore) clearServerSession(rw http.ResponseWriter, req *http.Request, cookies []*http.Cookie) {
+	cleared := &headerWriter{ResponseWriter: rw, header: http.Header{}}
+	_ = s.server.Clear(cleared, req)
+
+	replaced := make(map[string]bool, len(cookies))
+	for _, c := range cookies {
+		replaced[c.Name] = true
+	}
+	for _, c := range (&http.Response{Header: cleared.header}).Cookies() {
+		if !replaced[c.Name] {
+			http.SetCookie(rw, c)
+		}
+	}
+}
+
+// headerWriter collects the headers written to the response without
+// sending them
+type headerWriter struct {
+	http.ResponseWriter
+	header http.Header
+}
+
+// Header returns the collected headers
+func (w *headerWriter) Header() http.Header {
+	return w.header
+}
+
+// Load reads the session from the server side store when the request has a
+// ticket, and otherwise from the session cookies
+func (s *overflowSessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
+	if s.server != nil {
+		if session, err := s.server.Load(req); err == nil {
+			return session, nil
+		}
+	}
+	return s.cookies.Load(req)
+}
+
+// Clear clears the session cookies and any server side session
+func (s *overflowSessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
+	if s.server != nil {
+		if err := s.server.Clear(rw, req); err != nil {
+			logger.Printf("Unable to clear server side session: %v", err)
+		}
+	}
+	return s.cookies.Clear(rw, req)
+}
+
+// setCookieSize is the size of the Set-Cookie headers for the cookies
+func setCookieSize(cookies []*http.Cookie) int {
+	size := 0
+	for _, c := range cookies {
+		size += len("Set-Cookie: ") + len(c.String())
+	}
+	return size
+}
@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
+)
+
+// BatchingStore wraps a Store and defers updates to existing sessions, so
+// that frequent updates to the same session are coalesced into a single write
+// per flush interval.
+// Deferred updates are visible to Load straight away, but other instances
+// sharing the Store load the previous version of the session until the
+// update is flushed.
+type BatchingStore struct {
+	Store
+
+	interval time.Duration
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	pending   map[string]*pendingWrite
+	scheduled bool
+
+	// writeMutex is held while writing to the Store, so that a flush cannot
+	// overwrite a session saved or cleared while it is in progress
+	writeMutex sync.Mutex
+}
+
+// pendingWrite is the latest deferred update to a session
+type pendingWrite struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewBatchingStore creates a BatchingStore that flushes deferred updates to
+// the Store at the interval
+func NewBatchingStore(store Store, interval time.Duration) *BatchingStore {
+	return &BatchingStore{
+		Store:    store,
+		interval: interval,
+		now:      time.Now,
+		pending:  map[string]*pendingWrite{},
+	}
+}
+
+// Save writes the session to the Store immediately, replacing any deferred
+// update
+func (b *BatchingStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+
+	b.mutex.Lock()
+	delete(b.pending, key)
+	b.mutex.Unlock()
+
+	return b.Store.Save(ctx, key, value, exp)
+}
+
+// SaveDeferred queues the session to be written with the next flush,
+// replacing any earlier deferred update for the same key.
+// While memory is under pressure, the session is written immediately instead.
+func (b *BatchingStore) SaveDeferred(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	if !memory.Allow(memory.SessionWriteBatch) {
+		return b.Save(ctx, key, value, exp)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.pending[key] = &pendingWrite{
+		value:   value,
+		expires: b.now().Add(exp),
+	}
+	if !b.scheduled {
+		b.scheduled = true
+		time.AfterFunc(b.interval, b.Flush)
+	}
+	return nil
+}
+
+// Load returns the deferred update for the key if there is one, or else
+// loads the session from the Store
+func (b *BatchingStore) Load(ctx context.Context, key string) ([]byte, error) {
+	b.mutex.Lock()
+	write, ok := b.pending[key]
+	b.mutex.Unlock()
+
+	if ok && write.expires.After(b.now()) {
+		return write.value, nil
+	}
+	return b.Store.Load(ctx, key)
+}
+
+// Clear drops any deferred update for the key and clears the session from
+// the Store
+func (b *BatchingStore) Clear(ctx context.Context, key string) error {
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+
+	b.mutex.Lock()
+	delete(b.pending, key)
+	b.mutex.Unlock()
+
+	return b.Store.Clear(ctx, key)
+}
+
+// Flush writes the deferred updates to the Store, in a single round trip if
+// the Store is a MultiStore.
+// Updates that fail to be written are logged and dropped, leaving the
+// previous version of the session in the Store.
+func (b *BatchingStore) Flush() {
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+
+	b.mutex.Lock()
+	pending := b.pending
+	b.pending = map[string]*pendingWrite{}
+	b.scheduled = false
+	b.mutex.Unlock()
+
+	now := b.now()
+	writes := []Write{}
+	for key, write := range pending {
+		exp := write.expires.Sub(now)
+		if exp <= 0 {
+			// The session expired before it was flushed
+			continue
+		}
+		writes = append(writes, Write{Key: key, Value: write.value, Expiration: exp})
+	}
+	if len(writes) == 0 {
+		return
+	}
+
+	if multi, ok := b.Store.(MultiStore); ok {
+		if err := multi.SaveMulti(context.Background(), writes); err != nil {
+			logger.Errorf("Error flushing %d deferred session updates: %v", len(writes), err)
+		}
+		return
+	}
+	for _, write := range writes {
+		if err := b.Store.Save(context.Background(), write.Key, write.Value, write.Expiration); err != nil {
+			logger.Errorf("Error flushing deferred session update: %v", err)
+		}
+	}
+}
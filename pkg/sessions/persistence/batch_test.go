@@ -0,0 +1,124 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingStore counts the writes to a Store
+type countingStore struct {
+	Store
+	saves int
+}
+
+func (c *countingStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	c.saves++
+	return c.Store.Save(ctx, key, value, exp)
+}
+
+// multiStore counts the batches of writes to a Store
+type multiStore struct {
+	Store
+	batches int
+}
+
+func (m *multiStore) SaveMulti(ctx context.Context, writes []Write) error {
+	m.batches++
+	for _, write := range writes {
+		if err := m.Store.Save(ctx, write.Key, write.Value, write.Expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Batching Store Tests", func() {
+	const key = "ticket"
+	ctx := context.Background()
+
+	var store *countingStore
+	var batching *BatchingStore
+	BeforeEach(func() {
+		store = &countingStore{Store: tests.NewMockStore()}
+		// A long interval so that the tests flush explicitly
+		batching = NewBatchingStore(store, time.Hour)
+	})
+
+	It("loads deferred updates before they are flushed", func() {
+		Expect(batching.SaveDeferred(ctx, key, []byte("update"), time.Minute)).To(Succeed())
+		Expect(store.saves).To(Equal(0))
+
+		value, err := batching.Load(ctx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("update")))
+	})
+
+	It("coalesces deferred updates to the same session", func() {
+		Expect(batching.SaveDeferred(ctx, key, []byte("first"), time.Minute)).To(Succeed())
+		Expect(batching.SaveDeferred(ctx, key, []byte("second"), time.Minute)).To(Succeed())
+		batching.Flush()
+
+		Expect(store.saves).To(Equal(1))
+		value, err := store.Load(ctx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("second")))
+	})
+
+	It("replaces deferred updates with an immediate save", func() {
+		Expect(batching.SaveDeferred(ctx, key, []byte("deferred"), time.Minute)).To(Succeed())
+		Expect(batching.Save(ctx, key, []byte("immediate"), time.Minute)).To(Succeed())
+		batching.Flush()
+
+		Expect(store.saves).To(Equal(1))
+		value, err := store.Load(ctx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal([]byte("immediate")))
+	})
+
+	It("does not flush updates to cleared sessions", func() {
+		Expect(batching.SaveDeferred(ctx, key, []byte("update"), time.Minute)).To(Succeed())
+		Expect(batching.Clear(ctx, key)).To(Succeed())
+		batching.Flush()
+
+		Expect(store.saves).To(Equal(0))
+		_, err := batching.Load(ctx, key)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not flush updates to sessions that have expired", func() {
+		now := time.Now()
+		batching.now = func() time.Time { return now }
+		Expect(batching.SaveDeferred(ctx, key, []byte("update"), time.Minute)).To(Succeed())
+
+		now = now.Add(2 * time.Minute)
+		batching.Flush()
+		Expect(store.saves).To(Equal(0))
+	})
+
+	It("flushes deferred updates in a single batch to a MultiStore", func() {
+		multi := &multiStore{Store: store}
+		batching = NewBatchingStore(multi, time.Hour)
+		Expect(batching.SaveDeferred(ctx, "first", []byte("one"), time.Minute)).To(Succeed())
+		Expect(batching.SaveDeferred(ctx, "second", []byte("two"), time.Minute)).To(Succeed())
+		batching.Flush()
+
+		Expect(multi.batches).To(Equal(1))
+		Expect(store.saves).To(Equal(2))
+	})
+
+	It("flushes deferred updates after the interval", func() {
+		batching = NewBatchingStore(store, 10*time.Millisecond)
+		Expect(batching.SaveDeferred(ctx, key, []byte("update"), time.Minute)).To(Succeed())
+
+		Eventually(func() ([]byte, error) {
+			// Wait for any flush in progress before reading the store
+			batching.writeMutex.Lock()
+			defer batching.writeMutex.Unlock()
+			return store.Load(ctx, key)
+		}).Should(Equal([]byte("update")))
+	})
+})
@@ -0,0 +1,186 @@
+package persistence
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/memory"
+)
+
+// LatencyBudget tracks how long sessions take to load from the Store.
+// When loads exceed the budget a number of times in a row, the Manager can
+// degrade for a bounded window to validating sessions by the signature of
+// their ticket cookie only, using the last version of each session loaded or
+// saved by this instance, so that a slow Store does not slow down every
+// request.
+type LatencyBudget struct {
+	budget     time.Duration
+	violations int
+	window     time.Duration
+	now        func() time.Time
+
+	mutex         sync.Mutex
+	consecutive   int
+	degradedUntil time.Time
+
+	// sessions are kept only when degrading is enabled
+	sessions *sessionCache
+}
+
+// NewLatencyBudget creates a LatencyBudget for session loads.
+// Loads exceeding the budget violations times in a row are logged, and when
+// the window is set, the Manager degrades to cookie signature validation for
+// the window, with up to cacheSize sessions known.
+func NewLatencyBudget(budget time.Duration, violations int, window time.Duration, cacheSize int) *LatencyBudget {
+	b := &LatencyBudget{
+		budget:     budget,
+		violations: violations,
+		window:     window,
+		now:        time.Now,
+	}
+	if window > 0 {
+		b.sessions = newSessionCache(cacheSize)
+	}
+	return b
+}
+
+// observe records the time taken by a session load, and degrades once loads
+// have exceeded the budget too many times in a row
+func (b *LatencyBudget) observe(elapsed time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if elapsed <= b.budget {
+		b.consecutive = 0
+		return
+	}
+	b.consecutive++
+	if b.consecutive < b.violations {
+		return
+	}
+	b.consecutive = 0
+
+	if b.window == 0 {
+		logger.Errorf("WARNING: %d session loads in a row exceeded the latency budget of %s, the last took %s", b.violations, b.budget, elapsed)
+		return
+	}
+	b.degradedUntil = b.now().Add(b.window)
+	logger.Errorf("WARNING: %d session loads in a row exceeded the latency budget of %s, the last took %s. "+
+		"Validating sessions by cookie signature only, without the session store, until %s",
+		b.violations, b.budget, elapsed, b.degradedUntil.Format(time.RFC3339))
+}
+
+// degraded checks whether sessions are validated by cookie signature only
+func (b *LatencyBudget) degraded() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.degradedUntil.IsZero() {
+		return false
+	}
+	if b.now().Before(b.degradedUntil) {
+		return true
+	}
+	b.degradedUntil = time.Time{}
+	logger.Errorf("WARNING: Degraded mode ended, loading sessions from the session store again")
+	return false
+}
+
+// remember keeps a copy of the latest version of the session with the ticket
+// ID, to use while degraded
+func (b *LatencyBudget) remember(id string, s *sessions.SessionState) {
+	if b.sessions != nil {
+		copied := *s
+		b.sessions.add(id, &copied)
+	}
+}
+
+// forget drops the session with the ticket ID, so that it cannot be used
+// once it has been cleared
+func (b *LatencyBudget) forget(id string) {
+	if b.sessions != nil {
+		b.sessions.remove(id)
+	}
+}
+
+// lookup returns a copy of the latest version of the session with the ticket
+// ID known to this instance
+func (b *LatencyBudget) lookup(id string) (*sessions.SessionState, bool) {
+	if b.sessions == nil {
+		return nil, false
+	}
+	s, ok := b.sessions.get(id)
+	if !ok {
+		return nil, false
+	}
+	copied := *s
+	return &copied, true
+}
+
+// sessionEntry is a session in the sessionCache
+type sessionEntry struct {
+	id      string
+	session *sessions.SessionState
+}
+
+// sessionCache is a least recently used cache of sessions by ticket ID
+type sessionCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newSessionCache(size int) *sessionCache {
+	return &sessionCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the session with the ticket ID, and whether it was found
+func (c *sessionCache) get(id string) (*sessions.SessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*sessionEntry).session, true
+}
+
+// add stores the session with the ticket ID, evicting the least recently used
+// session if the cache is full
+func (c *sessionCache) add(id string, s *sessions.SessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[id]; ok {
+		element.Value.(*sessionEntry).session = s
+		c.order.MoveToFront(element)
+		return
+	}
+	if !memory.Allow(memory.SessionDegradedCache) {
+		return
+	}
+	c.entries[id] = c.order.PushFront(&sessionEntry{id: id, session: s})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sessionEntry).id)
+	}
+}
+
+// remove drops the session with the ticket ID
+func (c *sessionCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
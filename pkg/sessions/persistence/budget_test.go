@@ -0,0 +1,151 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// slowStore delays loads from a Store, or fails them while it is down
+type slowStore struct {
+	Store
+	delay time.Duration
+	down  bool
+}
+
+func (s *slowStore) Load(ctx context.Context, key string) ([]byte, error) {
+	if s.down {
+		return nil, errors.New("store is down")
+	}
+	time.Sleep(s.delay)
+	return s.Store.Load(ctx, key)
+}
+
+var _ = Describe("Latency Budget Tests", func() {
+	var now time.Time
+	var budget *LatencyBudget
+	BeforeEach(func() {
+		now = time.Unix(1600000000, 0)
+		budget = NewLatencyBudget(100*time.Millisecond, 3, time.Minute, 2)
+		budget.now = func() time.Time { return now }
+	})
+
+	Context("observe", func() {
+		It("degrades after too many slow loads in a row", func() {
+			budget.observe(time.Second)
+			budget.observe(time.Second)
+			Expect(budget.degraded()).To(BeFalse())
+
+			budget.observe(time.Second)
+			Expect(budget.degraded()).To(BeTrue())
+		})
+
+		It("does not degrade when a load within the budget interrupts the slow loads", func() {
+			budget.observe(time.Second)
+			budget.observe(time.Second)
+			budget.observe(10 * time.Millisecond)
+			budget.observe(time.Second)
+			Expect(budget.degraded()).To(BeFalse())
+		})
+
+		It("recovers once the window has passed", func() {
+			for i := 0; i < 3; i++ {
+				budget.observe(time.Second)
+			}
+			now = now.Add(59 * time.Second)
+			Expect(budget.degraded()).To(BeTrue())
+
+			now = now.Add(time.Second)
+			Expect(budget.degraded()).To(BeFalse())
+		})
+
+		It("never degrades without a window", func() {
+			budget = NewLatencyBudget(100*time.Millisecond, 1, 0, 2)
+			budget.observe(time.Second)
+			Expect(budget.degraded()).To(BeFalse())
+
+			budget.remember("ticket", &sessionsapi.SessionState{User: "user"})
+			_, ok := budget.lookup("ticket")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("sessions", func() {
+		It("evicts the least recently used session", func() {
+			budget.remember("a", &sessionsapi.SessionState{User: "a"})
+			budget.remember("b", &sessionsapi.SessionState{User: "b"})
+			_, ok := budget.lookup("a")
+			Expect(ok).To(BeTrue())
+
+			budget.remember("c", &sessionsapi.SessionState{User: "c"})
+			_, ok = budget.lookup("b")
+			Expect(ok).To(BeFalse())
+			_, ok = budget.lookup("a")
+			Expect(ok).To(BeTrue())
+			_, ok = budget.lookup("c")
+			Expect(ok).To(BeTrue())
+		})
+
+		It("forgets cleared sessions", func() {
+			budget.remember("a", &sessionsapi.SessionState{User: "a"})
+			budget.forget("a")
+			_, ok := budget.lookup("a")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("with a Manager", func() {
+		var store *slowStore
+		var manager *Manager
+		BeforeEach(func() {
+			store = &slowStore{Store: tests.NewMockStore(), delay: 5 * time.Millisecond}
+			manager = NewManager(store, &options.Cookie{
+				Name:   "_oauth2_proxy",
+				Secret: "0123456789abcdefghijklmnopqrstuv",
+				Expire: time.Hour,
+			})
+			manager.Budget = NewLatencyBudget(time.Millisecond, 1, time.Minute, 10)
+		})
+
+		saveSession := func() *http.Request {
+			rw := httptest.NewRecorder()
+			Expect(manager.Save(rw, httptest.NewRequest("GET", "/", nil), &sessionsapi.SessionState{User: "user"})).To(Succeed())
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for _, cookie := range rw.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			return req
+		}
+
+		It("loads known sessions without the store once degraded", func() {
+			req := saveSession()
+			_, err := manager.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manager.Budget.degraded()).To(BeTrue())
+
+			store.down = true
+			session, err := manager.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.User).To(Equal("user"))
+		})
+
+		It("rejects cleared sessions once degraded", func() {
+			req := saveSession()
+			_, err := manager.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(manager.Clear(httptest.NewRecorder(), req)).To(Succeed())
+			_, err = manager.Load(req)
+			Expect(errors.Is(err, sessionsapi.ErrStoreUnavailable)).To(BeTrue())
+		})
+	})
+})
@@ -13,3 +13,26 @@ type Store interface {
 	Load(context.Context, string) ([]byte, error)
 	Clear(context.Context, string) error
 }
+
+// Write is a value to save to a Store under a key
+type Write struct {
+	Key        string
+	Value      []byte
+	Expiration time.Duration
+}
+
+// MultiStore is a Store that can save several values in a single round trip,
+// either all of them or none
+type MultiStore interface {
+	Store
+	SaveMulti(context.Context, []Write) error
+}
+
+// DeferredStore is a Store that can defer writes, so that frequent updates
+// to existing sessions are batched.
+// The Manager defers updates to sessions that already have a ticket, and
+// saves new sessions immediately.
+type DeferredStore interface {
+	Store
+	SaveDeferred(context.Context, string, []byte, time.Duration) error
+}
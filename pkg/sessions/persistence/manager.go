@@ -14,6 +14,18 @@ import (
 type Manager struct {
 	Store   Store
 	Options *options.Cookie
+
+	// Encoding is the encoding of the sessions saved in the Store, or
+	// MessagePack if empty. Sessions in any encoding can be loaded.
+	Encoding sessions.Encoding
+
+	// Integrity, if set, is the integrity check that sessions saved in the
+	// Store are sealed and verified with
+	Integrity *sessions.IntegrityCheck
+
+	// Budget, if set, tracks the latency of session loads and may bypass the
+	// Store while it is slow
+	Budget *LatencyBudget
 }
 
 // NewManager creates a Manager that can wrap a Store and manage the
@@ -28,26 +40,34 @@ func NewManager(store Store, cookieOpts *options.Cookie) *Manager {
 // Save saves a session in a persistent Store. Save will generate (or reuse an
 // existing) ticket which manages unique per session encryption & retrieval
 // from the persistent data store.
+// If the Store is a DeferredStore, updates to sessions with an existing
+// ticket are deferred.
 func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState) error {
 	if s.CreatedAt == nil || s.CreatedAt.IsZero() {
 		now := time.Now()
 		s.CreatedAt = &now
 	}
 
+	save := m.Store.Save
 	tckt, err := decodeTicketFromRequest(req, m.Options)
 	if err != nil {
 		tckt, err = newTicket(m.Options)
 		if err != nil {
 			return fmt.Errorf("error creating a session ticket: %v", err)
 		}
+	} else if deferred, ok := m.Store.(DeferredStore); ok {
+		save = deferred.SaveDeferred
 	}
 
-	err = tckt.saveSession(s, func(key string, val []byte, exp time.Duration) error {
-		return m.Store.Save(req.Context(), key, val, exp)
+	err = tckt.saveSession(s, m.Encoding, m.Integrity, func(key string, val []byte, exp time.Duration) error {
+		return save(req.Context(), key, val, exp)
 	})
 	if err != nil {
 		return err
 	}
+	if m.Budget != nil {
+		m.Budget.remember(tckt.id, s)
+	}
 
 	return tckt.setCookie(rw, req, s)
 }
@@ -59,10 +79,30 @@ func (m *Manager) Load(req *http.Request) (*sessions.SessionState, error) {
 	if err != nil {
 		return nil, err
 	}
+	if m.Budget == nil {
+		return tckt.loadSession(m.Integrity, func(key string) ([]byte, error) {
+			return m.Store.Load(req.Context(), key)
+		})
+	}
 
-	return tckt.loadSession(func(key string) ([]byte, error) {
+	if m.Budget.degraded() {
+		// The ticket cookie signature has been validated, so the session
+		// this instance last knew for the ticket can be trusted
+		if session, ok := m.Budget.lookup(tckt.id); ok {
+			return session, nil
+		}
+		return nil, fmt.Errorf("%w: the session store is bypassed while session loads are slow", sessions.ErrStoreUnavailable)
+	}
+
+	start := time.Now()
+	session, err := tckt.loadSession(m.Integrity, func(key string) ([]byte, error) {
 		return m.Store.Load(req.Context(), key)
 	})
+	m.Budget.observe(time.Since(start))
+	if err == nil {
+		m.Budget.remember(tckt.id, session)
+	}
+	return session, err
 }
 
 // Clear clears any saved session information for a given ticket cookie.
@@ -84,6 +124,9 @@ func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
 	}
 
 	tckt.clearCookie(rw, req)
+	if m.Budget != nil {
+		m.Budget.forget(tckt.id)
+	}
 	return tckt.clearSession(func(key string) error {
 		return m.Store.Clear(req.Context(), key)
 	})
@@ -96,23 +96,27 @@ func decodeTicketFromRequest(req *http.Request, cookieOpts *options.Cookie) (*ti
 	}
 
 	// An existing cookie exists, try to retrieve the ticket
-	val, _, ok := encryption.Validate(requestCookie, cookieOpts.Secret, cookieOpts.Expire)
-	if !ok {
-		return nil, fmt.Errorf("session ticket cookie failed validation: %v", err)
+	val, err := sessions.ValidateCookie(requestCookie, cookieOpts.Secret, cookieOpts.Expire)
+	if err != nil {
+		return nil, fmt.Errorf("session ticket cookie failed validation: %w", err)
 	}
 
 	// Valid cookie, decode the ticket
 	return decodeTicket(string(val), cookieOpts)
 }
 
-// saveSession encodes the SessionState with the ticket's secret and persists
-// it to disk via the passed saveFunc.
-func (t *ticket) saveSession(s *sessions.SessionState, saver saveFunc) error {
+// saveSession encodes the SessionState in the encoding with the ticket's
+// secret, sealed with the integrity check if set, and persists it to disk via
+// the passed saveFunc.
+func (t *ticket) saveSession(s *sessions.SessionState, encoding sessions.Encoding, integrity *sessions.IntegrityCheck, saver saveFunc) error {
 	c, err := t.makeCipher()
 	if err != nil {
 		return err
 	}
-	ciphertext, err := s.EncodeSessionState(c, false)
+	if integrity != nil {
+		c = sessions.WithIntegrityCheck(c, integrity)
+	}
+	ciphertext, err := s.EncodeSessionStateAs(c, encoding)
 	if err != nil {
 		return fmt.Errorf("failed to encode the session state with the ticket: %v", err)
 	}
@@ -121,16 +125,20 @@ func (t *ticket) saveSession(s *sessions.SessionState, saver saveFunc) error {
 
 // loadSession loads a session from the disk store via the passed loadFunc
 // using the ticket.id as the key. It then decodes the SessionState using
-// ticket.secret to make the AES-GCM cipher.
-func (t *ticket) loadSession(loader loadFunc) (*sessions.SessionState, error) {
+// ticket.secret to make the AES-GCM cipher, verifying it with the integrity
+// check if set.
+func (t *ticket) loadSession(integrity *sessions.IntegrityCheck, loader loadFunc) (*sessions.SessionState, error) {
 	ciphertext, err := loader(t.id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load the session state with the ticket: %v", err)
+		return nil, fmt.Errorf("failed to load the session state with the ticket: %w", err)
 	}
 	c, err := t.makeCipher()
 	if err != nil {
 		return nil, err
 	}
+	if integrity != nil {
+		c = sessions.WithIntegrityCheck(c, integrity)
+	}
 
 	return sessions.DecodeSessionState(ciphertext, c, false)
 }
@@ -189,9 +197,10 @@ func (t *ticket) makeCookie(req *http.Request, value string, expires time.Durati
 	), nil
 }
 
-// makeCipher makes a AES-GCM cipher out of the ticket's secret
+// makeCipher makes a AES-GCM cipher out of the ticket's secret, reusing the
+// cipher of a previous request with the same ticket when possible
 func (t *ticket) makeCipher() (encryption.Cipher, error) {
-	c, err := encryption.NewGCMCipher(t.secret)
+	c, err := encryption.CachedGCMCipher(t.secret)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make an AES-GCM cipher from the ticket secret: %v", err)
 	}
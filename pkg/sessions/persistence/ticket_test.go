@@ -71,7 +71,7 @@ var _ = Describe("Session Ticket Tests", func() {
 
 			ss := &sessions.SessionState{User: "foobar"}
 			store := map[string][]byte{}
-			err = t.saveSession(ss, func(k string, v []byte, e time.Duration) error {
+			err = t.saveSession(ss, sessions.MsgpackEncoding, nil, func(k string, v []byte, e time.Duration) error {
 				store[k] = v
 				return nil
 			})
@@ -82,12 +82,38 @@ var _ = Describe("Session Ticket Tests", func() {
 			Expect(stored).To(Equal(ss))
 		})
 
+		It("saves sessions in the encoding", func() {
+			t, err := newTicket(&options.Cookie{Name: "dummy"})
+			Expect(err).ToNot(HaveOccurred())
+
+			c, err := t.makeCipher()
+			Expect(err).ToNot(HaveOccurred())
+
+			ss := &sessions.SessionState{User: "foobar", Groups: []string{"admins"}}
+			store := map[string][]byte{}
+			err = t.saveSession(ss, sessions.ProtobufEncoding, nil, func(k string, v []byte, e time.Duration) error {
+				store[k] = v
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			plaintext, err := c.Decrypt(store[t.id])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plaintext[0]).To(Equal(byte(0x01)))
+
+			stored, err := sessions.DecodeSessionState(store[t.id], c, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stored).To(Equal(ss))
+		})
+
 		It("errors when the saveFunc errors", func() {
 			t, err := newTicket(&options.Cookie{Name: "dummy"})
 			Expect(err).ToNot(HaveOccurred())
 
 			err = t.saveSession(
 				&sessions.SessionState{User: "foobar"},
+				sessions.MsgpackEncoding,
+				nil,
 				func(k string, v []byte, e time.Duration) error {
 					return errors.New("save error")
 				})
@@ -104,7 +130,7 @@ var _ = Describe("Session Ticket Tests", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			ss := &sessions.SessionState{User: "foobar"}
-			loadedSession, err := t.loadSession(func(k string) ([]byte, error) {
+			loadedSession, err := t.loadSession(nil, func(k string) ([]byte, error) {
 				return ss.EncodeSessionState(c, false)
 			})
 			Expect(err).ToNot(HaveOccurred())
@@ -115,11 +141,11 @@ var _ = Describe("Session Ticket Tests", func() {
 			t, err := newTicket(&options.Cookie{Name: "dummy"})
 			Expect(err).ToNot(HaveOccurred())
 
-			data, err := t.loadSession(func(k string) ([]byte, error) {
+			data, err := t.loadSession(nil, func(k string) ([]byte, error) {
 				return nil, errors.New("load error")
 			})
 			Expect(data).To(BeNil())
-			Expect(err).To(MatchError(errors.New("failed to load the session state with the ticket: load error")))
+			Expect(err).To(MatchError("failed to load the session state with the ticket: load error"))
 		})
 	})
 
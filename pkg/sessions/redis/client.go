@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
 )
 
 // Client is wrapper interface for redis.Client and redis.ClusterClient.
@@ -12,8 +13,31 @@ type Client interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
 	Del(ctx context.Context, key string) error
+	SetMulti(ctx context.Context, writes []persistence.Write) error
+	SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error)
+	DelIfEqual(ctx context.Context, key string, value []byte) error
+	GetDel(ctx context.Context, key string) ([]byte, error)
 }
 
+// delIfEqualScript deletes a key only if it still has the value, so that a
+// lock that expired and was obtained by another request is not released
+var delIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// getDelScript gets and deletes a key atomically, so that its value can only
+// be taken once
+var getDelScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	redis.call("DEL", KEYS[1])
+end
+return value
+`)
+
 var _ Client = (*client)(nil)
 
 type client struct {
@@ -36,6 +60,31 @@ func (c *client) Del(ctx context.Context, key string) error {
 	return c.Client.Del(ctx, key).Err()
 }
 
+func (c *client) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	return c.Client.SetNX(ctx, key, value, expiration).Result()
+}
+
+func (c *client) DelIfEqual(ctx context.Context, key string, value []byte) error {
+	return delIfEqualScript.Run(ctx, c.Client, []string{key}, value).Err()
+}
+
+func (c *client) GetDel(ctx context.Context, key string) ([]byte, error) {
+	value, err := getDelScript.Run(ctx, c.Client, []string{key}).Text()
+	return []byte(value), err
+}
+
+// SetMulti sets the values in a single MULTI/EXEC transaction, so that either
+// all or none of them are set
+func (c *client) SetMulti(ctx context.Context, writes []persistence.Write) error {
+	_, err := c.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, write := range writes {
+			pipe.Set(ctx, write.Key, write.Value, write.Expiration)
+		}
+		return nil
+	})
+	return err
+}
+
 var _ Client = (*clusterClient)(nil)
 
 type clusterClient struct {
@@ -57,3 +106,29 @@ func (c *clusterClient) Set(ctx context.Context, key string, value []byte, expir
 func (c *clusterClient) Del(ctx context.Context, key string) error {
 	return c.ClusterClient.Del(ctx, key).Err()
 }
+
+func (c *clusterClient) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	return c.ClusterClient.SetNX(ctx, key, value, expiration).Result()
+}
+
+func (c *clusterClient) DelIfEqual(ctx context.Context, key string, value []byte) error {
+	return delIfEqualScript.Run(ctx, c.ClusterClient, []string{key}, value).Err()
+}
+
+func (c *clusterClient) GetDel(ctx context.Context, key string) ([]byte, error) {
+	value, err := getDelScript.Run(ctx, c.ClusterClient, []string{key}).Text()
+	return []byte(value), err
+}
+
+// SetMulti sets the values in a single pipeline.
+// Keys in a cluster are spread over nodes, so unlike the standalone client the
+// values are not set in a transaction, and some may be set when others fail.
+func (c *clusterClient) SetMulti(ctx context.Context, writes []persistence.Write) error {
+	_, err := c.ClusterClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, write := range writes {
+			pipe.Set(ctx, write.Key, write.Value, write.Expiration)
+		}
+		return nil
+	})
+	return err
+}
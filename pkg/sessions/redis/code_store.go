@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+// Ensure CodeStore implements the interface
+var _ sessions.CodeStore = &CodeStore{}
+
+// CodeStore stores values under single use codes in redis, so that a code
+// saved by one instance can be taken by any instance using the same redis
+type CodeStore struct {
+	Client Client
+
+	// Prefix is prepended to the codes
+	Prefix string
+}
+
+// NewRedisCodeStore constructs a CodeStore with its own redis client
+func NewRedisCodeStore(opts *options.SessionOptions, prefix string) (*CodeStore, error) {
+	client, err := NewRedisClient(opts.Redis)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing redis client: %v", err)
+	}
+	return &CodeStore{
+		Client: client,
+		Prefix: prefix,
+	}, nil
+}
+
+// Save sets the code to the value until the expiration
+func (s *CodeStore) Save(ctx context.Context, code string, value []byte, expiration time.Duration) error {
+	if err := s.Client.Set(ctx, s.Prefix+code, value, expiration); err != nil {
+		return fmt.Errorf("%w: error saving code: %v", sessions.ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// Take gets and deletes the code in a single script, so that concurrent
+// requests cannot both take it
+func (s *CodeStore) Take(ctx context.Context, code string) ([]byte, error) {
+	value, err := s.Client.GetDel(ctx, s.Prefix+code)
+	switch {
+	case err == redis.Nil:
+		return nil, sessions.ErrCodeNotFound
+	case err != nil:
+		return nil, fmt.Errorf("%w: error taking code: %v", sessions.ErrStoreUnavailable, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// Ensure Locker implements the interface
+var _ sessions.Locker = &Locker{}
+
+// Locker locks sessions in redis with SET NX PX, so that the lock is shared
+// by all instances using the same redis
+type Locker struct {
+	Client Client
+
+	// Prefix is prepended to the keys of the locks
+	Prefix string
+}
+
+// NewRedisLocker constructs a Locker with its own redis client, prefixing
+// the keys of the locks with the cookie name
+func NewRedisLocker(opts *options.SessionOptions, cookieOpts *options.Cookie) (*Locker, error) {
+	client, err := NewRedisClient(opts.Redis)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing redis client: %v", err)
+	}
+	return &Locker{
+		Client: client,
+		Prefix: cookieOpts.Name + "-lock-",
+	}, nil
+}
+
+// Obtain sets the lock key to a random token if it is not already set.
+// Releasing the lock deletes the key only if it still has the token, so that
+// a lock that expired and was obtained by another request is left in place.
+func (l *Locker) Obtain(ctx context.Context, key string, expiration time.Duration) (func(), error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("error generating lock token: %v", err)
+	}
+
+	ok, err := l.Client.SetNX(ctx, l.Prefix+key, token, expiration)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error obtaining redis lock: %v", sessions.ErrStoreUnavailable, err)
+	}
+	if !ok {
+		return nil, sessions.ErrLockNotObtained
+	}
+
+	release := func() {
+		// The request may have been cancelled, but the lock must still be
+		// released
+		if err := l.Client.DelIfEqual(context.Background(), l.Prefix+key, token); err != nil {
+			logger.Errorf("Error releasing redis lock, it is held until it expires: %v", err)
+		}
+	}
+	return release, nil
+}
+
+// Peek checks whether the lock key is set
+func (l *Locker) Peek(ctx context.Context, key string) (bool, error) {
+	_, err := l.Client.Get(ctx, l.Prefix+key)
+	switch {
+	case err == redis.Nil:
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("%w: error checking redis lock: %v", sessions.ErrStoreUnavailable, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,208 @@
+// +build integration
+
+package redis
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The integration tests run the session store tests against real Redis
+// servers, configured with the environment variables below. Each context is
+// skipped when its variables are not set.
+//
+//	OAUTH2_PROXY_TEST_REDIS_URL              standalone server, eg. redis://localhost:6379
+//	OAUTH2_PROXY_TEST_REDIS_SENTINEL_URL     sentinel, eg. redis://localhost:26379
+//	OAUTH2_PROXY_TEST_REDIS_SENTINEL_MASTER  sentinel master name, defaults to mymaster
+//	OAUTH2_PROXY_TEST_REDIS_CLUSTER_URLS     comma separated cluster nodes
+//
+// contrib/local-environment/docker-compose-redis.yaml starts a standalone
+// server and a sentinel:
+//
+//	make local-env-redis-up
+//	OAUTH2_PROXY_TEST_REDIS_URL=redis://localhost:6379 go test -tags integration ./pkg/sessions/redis/
+const (
+	redisURLEnv            = "OAUTH2_PROXY_TEST_REDIS_URL"
+	redisSentinelURLEnv    = "OAUTH2_PROXY_TEST_REDIS_SENTINEL_URL"
+	redisSentinelMasterEnv = "OAUTH2_PROXY_TEST_REDIS_SENTINEL_MASTER"
+	redisClusterURLsEnv    = "OAUTH2_PROXY_TEST_REDIS_CLUSTER_URLS"
+)
+
+// fastForwardKeys simulates the passing of time on a real Redis server by
+// reducing the TTL of every key, deleting those that would have expired
+func fastForwardKeys(ctx context.Context, client *redis.Client, d time.Duration) error {
+	keys, err := client.Keys(ctx, "*").Result()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		ttl, err := client.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		switch {
+		case ttl < 0:
+			// The key has no expiry
+		case ttl <= d:
+			err = client.Del(ctx, key).Err()
+		default:
+			err = client.PExpire(ctx, key, ttl-d).Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Redis SessionStore Integration Tests", func() {
+	// helper interface to allow us to close client connections
+	// All non-nil redis clients should implement this
+	type closer interface {
+		Close() error
+	}
+
+	var ss sessionsapi.SessionStore
+
+	JustAfterEach(func() {
+		// Release any connections immediately after the test ends
+		if redisManager, ok := ss.(*persistence.Manager); ok {
+			if redisManager.Store.(*SessionStore).Client != nil {
+				Expect(redisManager.Store.(*SessionStore).Client.(closer).Close()).To(Succeed())
+			}
+		}
+	})
+
+	Context("with a standalone server", func() {
+		redisURL := os.Getenv(redisURLEnv)
+		if redisURL == "" {
+			It("is skipped", func() { Skip(redisURLEnv + " is not set") })
+			return
+		}
+
+		var client *redis.Client
+
+		BeforeEach(func() {
+			opt, err := redis.ParseURL(redisURL)
+			Expect(err).ToNot(HaveOccurred())
+			client = redis.NewClient(opt)
+		})
+
+		AfterEach(func() {
+			Expect(client.Close()).To(Succeed())
+		})
+
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				opts.Type = options.RedisSessionStoreType
+				opts.Redis.ConnectionURL = redisURL
+
+				// Capture the session store so that we can close the client
+				var err error
+				ss, err = NewRedisSessionStore(opts, cookieOpts)
+				return ss, err
+			},
+			func(d time.Duration) error {
+				return fastForwardKeys(context.Background(), client, d)
+			},
+		)
+	})
+
+	Context("with sentinel", func() {
+		sentinelURL := os.Getenv(redisSentinelURLEnv)
+		if sentinelURL == "" {
+			It("is skipped", func() { Skip(redisSentinelURLEnv + " is not set") })
+			return
+		}
+		masterName := os.Getenv(redisSentinelMasterEnv)
+		if masterName == "" {
+			masterName = "mymaster"
+		}
+
+		var client *redis.Client
+
+		BeforeEach(func() {
+			opt, err := redis.ParseURL(sentinelURL)
+			Expect(err).ToNot(HaveOccurred())
+			client = redis.NewFailoverClient(&redis.FailoverOptions{
+				MasterName:    masterName,
+				SentinelAddrs: []string{opt.Addr},
+			})
+		})
+
+		AfterEach(func() {
+			Expect(client.Close()).To(Succeed())
+		})
+
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				opts.Type = options.RedisSessionStoreType
+				opts.Redis.SentinelConnectionURLs = []string{sentinelURL}
+				opts.Redis.UseSentinel = true
+				opts.Redis.SentinelMasterName = masterName
+
+				// Capture the session store so that we can close the client
+				var err error
+				ss, err = NewRedisSessionStore(opts, cookieOpts)
+				return ss, err
+			},
+			func(d time.Duration) error {
+				return fastForwardKeys(context.Background(), client, d)
+			},
+		)
+	})
+
+	Context("with cluster", func() {
+		clusterURLs := os.Getenv(redisClusterURLsEnv)
+		if clusterURLs == "" {
+			It("is skipped", func() { Skip(redisClusterURLsEnv + " is not set") })
+			return
+		}
+		urls := strings.Split(clusterURLs, ",")
+
+		var client *redis.ClusterClient
+
+		BeforeEach(func() {
+			addrs := []string{}
+			for _, u := range urls {
+				opt, err := redis.ParseURL(u)
+				Expect(err).ToNot(HaveOccurred())
+				addrs = append(addrs, opt.Addr)
+			}
+			client = redis.NewClusterClient(&redis.ClusterOptions{Addrs: addrs})
+		})
+
+		AfterEach(func() {
+			Expect(client.Close()).To(Succeed())
+		})
+
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				opts.Type = options.RedisSessionStoreType
+				opts.Redis.ClusterConnectionURLs = urls
+				opts.Redis.UseCluster = true
+
+				// Capture the session store so that we can close the client
+				var err error
+				ss, err = NewRedisSessionStore(opts, cookieOpts)
+				return ss, err
+			},
+			func(d time.Duration) error {
+				ctx := context.Background()
+				return client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+					return fastForwardKeys(ctx, master, d)
+				})
+			},
+		)
+	})
+})
@@ -14,6 +14,10 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
 )
 
+// degradedSessionCacheSize is the number of sessions kept in memory to
+// validate by cookie signature only while redis is slow
+const degradedSessionCacheSize = 10000
+
 // SessionStore is an implementation of the persistence.Store
 // interface that stores sessions in redis
 type SessionStore struct {
@@ -28,10 +32,26 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 		return nil, fmt.Errorf("error constructing redis client: %v", err)
 	}
 
+	integrity, err := sessions.NewIntegrityCheck([]byte(cookieOpts.Secret), opts.IntegrityStrict)
+	if err != nil {
+		return nil, err
+	}
+
 	rs := &SessionStore{
 		Client: client,
 	}
-	return persistence.NewManager(rs, cookieOpts), nil
+	var manager *persistence.Manager
+	if opts.WriteBatchInterval > 0 {
+		manager = persistence.NewManager(persistence.NewBatchingStore(rs, opts.WriteBatchInterval), cookieOpts)
+	} else {
+		manager = persistence.NewManager(rs, cookieOpts)
+	}
+	manager.Encoding = sessions.Encoding(opts.Encoding)
+	manager.Integrity = integrity
+	if opts.LoadLatencyBudget > 0 {
+		manager.Budget = persistence.NewLatencyBudget(opts.LoadLatencyBudget, opts.LoadBudgetViolations, opts.DegradedModeWindow, degradedSessionCacheSize)
+	}
+	return manager, nil
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -39,7 +59,16 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
 	err := store.Client.Set(ctx, key, value, exp)
 	if err != nil {
-		return fmt.Errorf("error saving redis session: %v", err)
+		return fmt.Errorf("%w: error saving redis session: %v", sessions.ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+// SaveMulti stores several sessions in redis in a single round trip
+func (store *SessionStore) SaveMulti(ctx context.Context, writes []persistence.Write) error {
+	err := store.Client.SetMulti(ctx, writes)
+	if err != nil {
+		return fmt.Errorf("%w: error saving redis sessions: %v", sessions.ErrStoreUnavailable, err)
 	}
 	return nil
 }
@@ -48,9 +77,12 @@ func (store *SessionStore) Save(ctx context.Context, key string, value []byte, e
 // cookie within the HTTP request object
 func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
 	value, err := store.Client.Get(ctx, key)
-	if err != nil {
+	if err == redis.Nil {
 		return nil, fmt.Errorf("error loading redis session: %v", err)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: error loading redis session: %v", sessions.ErrStoreUnavailable, err)
+	}
 	return value, nil
 }
 
@@ -59,7 +91,7 @@ func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error)
 func (store *SessionStore) Clear(ctx context.Context, key string) error {
 	err := store.Client.Del(ctx, key)
 	if err != nil {
-		return fmt.Errorf("error clearing the session from redis: %v", err)
+		return fmt.Errorf("%w: error clearing the session from redis: %v", sessions.ErrStoreUnavailable, err)
 	}
 	return nil
 }
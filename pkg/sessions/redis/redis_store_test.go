@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"testing"
@@ -16,6 +17,7 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/persistence"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -223,4 +225,96 @@ var _ = Describe("Redis SessionStore Tests", func() {
 			)
 		})
 	})
+
+	Context("SaveMulti", func() {
+		writes := []persistence.Write{
+			{Key: "first", Value: []byte("one"), Expiration: time.Minute},
+			{Key: "second", Value: []byte("two"), Expiration: time.Hour},
+		}
+
+		DescribeTable("saves every value with its expiration",
+			func(opts options.RedisStoreOptions) {
+				if opts.UseCluster {
+					opts.ClusterConnectionURLs = []string{"redis://" + mr.Addr()}
+				} else {
+					opts.ConnectionURL = "redis://" + mr.Addr()
+				}
+				client, err := NewRedisClient(opts)
+				Expect(err).ToNot(HaveOccurred())
+				defer client.(closer).Close()
+
+				store := &SessionStore{Client: client}
+				Expect(store.SaveMulti(context.Background(), writes)).To(Succeed())
+
+				for _, write := range writes {
+					value, err := mr.Get(write.Key)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(value).To(Equal(string(write.Value)))
+					Expect(mr.TTL(write.Key)).To(Equal(write.Expiration))
+				}
+			},
+			Entry("with a standalone client", options.RedisStoreOptions{}),
+			Entry("with a cluster client", options.RedisStoreOptions{UseCluster: true}),
+		)
+
+		It("returns ErrStoreUnavailable when redis cannot be reached", func() {
+			client, err := NewRedisClient(options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()})
+			Expect(err).ToNot(HaveOccurred())
+			defer client.(closer).Close()
+			mr.Close()
+
+			store := &SessionStore{Client: client}
+			err = store.SaveMulti(context.Background(), writes)
+			Expect(errors.Is(err, sessionsapi.ErrStoreUnavailable)).To(BeTrue())
+		})
+	})
+
+	Context("Locker", func() {
+		DescribeTable("locks a key until it is released",
+			func(opts options.RedisStoreOptions) {
+				if opts.UseCluster {
+					opts.ClusterConnectionURLs = []string{"redis://" + mr.Addr()}
+				} else {
+					opts.ConnectionURL = "redis://" + mr.Addr()
+				}
+				locker, err := NewRedisLocker(&options.SessionOptions{Redis: opts}, &options.Cookie{Name: "_oauth2_proxy"})
+				Expect(err).ToNot(HaveOccurred())
+				defer locker.Client.(closer).Close()
+				ctx := context.Background()
+
+				release, err := locker.Obtain(ctx, "key", time.Minute)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(mr.TTL("_oauth2_proxy-lock-key")).To(Equal(time.Minute))
+				held, err := locker.Peek(ctx, "key")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(held).To(BeTrue())
+
+				_, err = locker.Obtain(ctx, "key", time.Minute)
+				Expect(err).To(MatchError(sessionsapi.ErrLockNotObtained))
+
+				release()
+				held, err = locker.Peek(ctx, "key")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(held).To(BeFalse())
+			},
+			Entry("with a standalone client", options.RedisStoreOptions{}),
+			Entry("with a cluster client", options.RedisStoreOptions{UseCluster: true}),
+		)
+
+		It("does not release a lock obtained by another request after it expired", func() {
+			locker, err := NewRedisLocker(&options.SessionOptions{Redis: options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()}}, &options.Cookie{Name: "_oauth2_proxy"})
+			Expect(err).ToNot(HaveOccurred())
+			defer locker.Client.(closer).Close()
+			ctx := context.Background()
+
+			release, err := locker.Obtain(ctx, "key", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			mr.FastForward(time.Minute)
+
+			_, err = locker.Obtain(ctx, "key", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			release()
+			Expect(mr.Exists("_oauth2_proxy-lock-key")).To(BeTrue())
+		})
+	})
 })
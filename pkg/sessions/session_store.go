@@ -13,6 +13,12 @@ import (
 func NewSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
 	switch opts.Type {
 	case options.CookieSessionStoreType:
+		if opts.Cookie.SplitTokens {
+			return newSplitTokenSessionStore(opts, cookieOpts)
+		}
+		if opts.Cookie.MaxSize > 0 {
+			return newOverflowSessionStore(opts, cookieOpts)
+		}
 		return cookie.NewCookieSessionStore(opts, cookieOpts)
 	case options.RedisSessionStoreType:
 		return redis.NewRedisSessionStore(opts, cookieOpts)
@@ -20,3 +26,61 @@ func NewSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (
 		return nil, fmt.Errorf("unknown session store type '%s'", opts.Type)
 	}
 }
+
+// NewRefreshLocker creates the Locker of session refreshes. Sessions stored
+// in redis, or with their tokens stored there, are locked in redis so that
+// the lock is shared by all instances. It returns nil for other sessions,
+// which are locked in memory by each instance.
+func NewRefreshLocker(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.Locker, error) {
+	if opts.Type != options.RedisSessionStoreType && !opts.Cookie.OverflowToRedis && !opts.Cookie.SplitTokens {
+		return nil, nil
+	}
+	locker, err := redis.NewRedisLocker(opts, cookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	return locker, nil
+}
+
+// newOverflowSessionStore creates a cookie SessionStore that checks the size
+// of the session cookies, and stores oversized sessions in redis if enabled
+func newOverflowSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	cookies, err := cookie.NewCookieSessionStore(opts, cookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	store := &overflowSessionStore{
+		cookies: cookies.(*cookie.SessionStore),
+		maxSize: opts.Cookie.MaxSize,
+	}
+
+	if opts.Cookie.OverflowToRedis {
+		store.server, err = redis.NewRedisSessionStore(opts, cookieOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// newSplitTokenSessionStore creates a cookie SessionStore that keeps the OAuth
+// tokens of sessions in redis, with a ticket to them in the <name>_tokens
+// cookie
+func newSplitTokenSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	cookies, err := cookie.NewCookieSessionStore(opts, cookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	store := &splitTokenSessionStore{
+		cookies: cookies.(*cookie.SessionStore),
+	}
+	store.cookies.Fields = identityFields
+
+	tokenCookieOpts := *cookieOpts
+	tokenCookieOpts.Name = cookieOpts.Name + "_tokens"
+	store.tokens, err = redis.NewRedisSessionStore(opts, &tokenCookieOpts)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
@@ -3,10 +3,15 @@ package sessions_test
 import (
 	"encoding/base64"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions"
 	sessionscookie "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
@@ -61,6 +66,128 @@ var _ = Describe("NewSessionStore", func() {
 		})
 	})
 
+	Context("with type 'cookie' and a maximum cookie size", func() {
+		var mr *miniredis.Miniredis
+
+		BeforeEach(func() {
+			var err error
+			mr, err = miniredis.Run()
+			Expect(err).ToNot(HaveOccurred())
+
+			opts.Type = options.CookieSessionStoreType
+			opts.Cookie.MaxSize = 1024
+			opts.Cookie.OverflowToRedis = true
+			opts.Redis.ConnectionURL = "redis://" + mr.Addr()
+		})
+
+		AfterEach(func() {
+			mr.Close()
+		})
+
+		// roundTrip saves the session and loads it from a request with the
+		// cookies set by the response
+		roundTrip := func(ss sessionsapi.SessionStore, session *sessionsapi.SessionState) (*http.Response, *sessionsapi.SessionState) {
+			rw := httptest.NewRecorder()
+			Expect(ss.Save(rw, httptest.NewRequest("GET", "/", nil), session)).To(Succeed())
+
+			resp := rw.Result()
+			req := httptest.NewRequest("GET", "/", nil)
+			for _, c := range resp.Cookies() {
+				req.AddCookie(c)
+			}
+			loaded, err := ss.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			return resp, loaded
+		}
+
+		It("stores small sessions in cookies", func() {
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, loaded := roundTrip(ss, &sessionsapi.SessionState{Email: "user@example.com"})
+			Expect(loaded.Email).To(Equal("user@example.com"))
+			Expect(mr.Keys()).To(BeEmpty())
+		})
+
+		It("stores sessions with oversized cookies in redis", func() {
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+
+			session := &sessionsapi.SessionState{
+				Email:       "user@example.com",
+				AccessToken: strings.Repeat("a", 2048),
+			}
+			resp, loaded := roundTrip(ss, session)
+			Expect(loaded.Email).To(Equal("user@example.com"))
+			Expect(loaded.AccessToken).To(Equal(session.AccessToken))
+			Expect(mr.Keys()).To(HaveLen(1))
+			for _, c := range resp.Cookies() {
+				Expect(len(c.String())).To(BeNumerically("<", 1024))
+			}
+		})
+	})
+
+	Context("with type 'cookie' and split tokens", func() {
+		var mr *miniredis.Miniredis
+
+		BeforeEach(func() {
+			var err error
+			mr, err = miniredis.Run()
+			Expect(err).ToNot(HaveOccurred())
+
+			opts.Type = options.CookieSessionStoreType
+			opts.Cookie.SplitTokens = true
+			opts.Redis.ConnectionURL = "redis://" + mr.Addr()
+		})
+
+		AfterEach(func() {
+			mr.Close()
+		})
+
+		It("stores the tokens in redis and the identity in cookies", func() {
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+
+			session := &sessionsapi.SessionState{
+				Email:        "user@example.com",
+				User:         "user",
+				AccessToken:  strings.Repeat("a", 2048),
+				RefreshToken: "refresh",
+			}
+			rw := httptest.NewRecorder()
+			Expect(ss.Save(rw, httptest.NewRequest("GET", "/", nil), session)).To(Succeed())
+			Expect(mr.Keys()).To(HaveLen(1))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			cookieNames := []string{}
+			for _, c := range rw.Result().Cookies() {
+				Expect(len(c.String())).To(BeNumerically("<", 1024))
+				cookieNames = append(cookieNames, c.Name)
+				req.AddCookie(c)
+			}
+			Expect(cookieNames).To(ConsistOf("_oauth2_proxy", "_oauth2_proxy_tokens"))
+
+			loaded, err := ss.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Email).To(Equal("user@example.com"))
+			Expect(loaded.User).To(Equal("user"))
+			Expect(loaded.AccessToken).To(Equal(session.AccessToken))
+			Expect(loaded.RefreshToken).To(Equal("refresh"))
+
+			// The identity cookie alone carries no tokens
+			identityReq := httptest.NewRequest("GET", "/", nil)
+			for _, c := range rw.Result().Cookies() {
+				if c.Name == "_oauth2_proxy" {
+					identityReq.AddCookie(c)
+				}
+			}
+			identity, err := ss.Load(identityReq)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(identity.Email).To(Equal("user@example.com"))
+			Expect(identity.AccessToken).To(BeEmpty())
+		})
+	})
+
 	Context("with type 'redis'", func() {
 		BeforeEach(func() {
 			opts.Type = options.RedisSessionStoreType
@@ -0,0 +1,77 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
+)
+
+// Ensure splitTokenSessionStore implements the interface
+var _ sessions.SessionStore = &splitTokenSessionStore{}
+
+// identityFields are the session fields kept in the cookies of split token
+// sessions. The creation and expiry times are always kept.
+var identityFields = []string{"email", "user", "groups", "preferred_username", "claims"}
+
+// splitTokenSessionStore stores the identity of sessions in cookies, and
+// their OAuth tokens server side, with a ticket to them in a separate cookie.
+// This keeps the cookies small while the tokens remain available to the
+// headers injected upstream.
+type splitTokenSessionStore struct {
+	cookies *cookie.SessionStore
+	tokens  sessions.SessionStore
+}
+
+// Save stores the tokens of the session server side, and the rest of the
+// session in cookies. Sessions without tokens clear any stored tokens.
+func (s *splitTokenSessionStore) Save(rw http.ResponseWriter, req *http.Request, ss *sessions.SessionState) error {
+	if ss.AccessToken == "" && ss.IDToken == "" && ss.RefreshToken == "" {
+		if err := s.tokens.Clear(rw, req); err != nil {
+			logger.Printf("Unable to clear server side session tokens: %v", err)
+		}
+	} else {
+		tokens := &sessions.SessionState{
+			CreatedAt:    ss.CreatedAt,
+			ExpiresOn:    ss.ExpiresOn,
+			AccessToken:  ss.AccessToken,
+			IDToken:      ss.IDToken,
+			RefreshToken: ss.RefreshToken,
+		}
+		if err := s.tokens.Save(rw, req, tokens); err != nil {
+			return fmt.Errorf("error saving the session tokens: %w", err)
+		}
+	}
+	return s.cookies.Save(rw, req, ss)
+}
+
+// Load reads the session from the cookies, and adds the tokens from the
+// server side store when the request has a ticket to them
+func (s *splitTokenSessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
+	session, err := s.cookies.Load(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.tokens.Load(req)
+	if err == http.ErrNoCookie {
+		return session, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading the session tokens: %w", err)
+	}
+	session.AccessToken = tokens.AccessToken
+	session.IDToken = tokens.IDToken
+	session.RefreshToken = tokens.RefreshToken
+	return session, nil
+}
+
+// Clear clears the session cookies and the server side tokens
+func (s *splitTokenSessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
+	if err := s.tokens.Clear(rw, req); err != nil {
+		logger.Printf("Unable to clear server side session tokens: %v", err)
+	}
+	return s.cookies.Clear(rw, req)
+}
@@ -0,0 +1,182 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/version"
+)
+
+const (
+	ecsVersion = "1.12.0"
+	siemVendor = "OAuth2 Proxy"
+)
+
+// ecsEvent is an Event as an Elastic Common Schema document
+type ecsEvent struct {
+	Timestamp string       `json:"@timestamp"`
+	ECS       ecsVersioned `json:"ecs"`
+	Event     ecsEventInfo `json:"event"`
+	Rule      *ecsRule     `json:"rule,omitempty"`
+	HTTP      ecsHTTP      `json:"http"`
+	URL       ecsURL       `json:"url"`
+	Source    *ecsSource   `json:"source,omitempty"`
+	User      *ecsUser     `json:"user,omitempty"`
+	Observer  ecsObserver  `json:"observer"`
+}
+
+type ecsVersioned struct {
+	Version string `json:"version"`
+}
+
+type ecsEventInfo struct {
+	Kind     string   `json:"kind"`
+	Category []string `json:"category"`
+	Type     []string `json:"type"`
+	Action   string   `json:"action"`
+	Outcome  string   `json:"outcome"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+type ecsRule struct {
+	ID      string `json:"id"`
+	Ruleset string `json:"ruleset,omitempty"`
+}
+
+type ecsHTTP struct {
+	Request struct {
+		Method string `json:"method"`
+	} `json:"request"`
+}
+
+type ecsURL struct {
+	Domain string `json:"domain,omitempty"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+}
+
+type ecsSource struct {
+	IP string `json:"ip"`
+}
+
+type ecsUser struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type ecsObserver struct {
+	Product string `json:"product"`
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+// formatECS formats the events as newline delimited ECS JSON documents
+func formatECS(events []Event) []byte {
+	var buf bytes.Buffer
+	for _, event := range events {
+		data, err := json.Marshal(newECSEvent(event))
+		if err != nil {
+			logger.Errorf("Error marshalling SIEM event: %v", err)
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func newECSEvent(event Event) *ecsEvent {
+	e := &ecsEvent{
+		Timestamp: event.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		ECS:       ecsVersioned{Version: ecsVersion},
+	}
+
+	e.Event.Kind = "event"
+	e.Event.Category = []string{"web"}
+	if event.Allowed {
+		e.Event.Type = []string{"allowed"}
+		e.Event.Action = "authorization-allow"
+		e.Event.Outcome = "success"
+	} else {
+		e.Event.Type = []string{"denied"}
+		e.Event.Action = "authorization-deny"
+		e.Event.Outcome = "failure"
+		e.Event.Reason = event.Action
+	}
+	if event.Rule != "" {
+		e.Rule = &ecsRule{ID: event.Rule, Ruleset: event.RuleSet}
+	}
+
+	e.HTTP.Request.Method = event.Method
+	e.URL.Domain = event.Host
+	e.URL.Path = event.Path
+	e.URL.Query = event.Query
+	if event.ClientIP != "" {
+		e.Source = &ecsSource{IP: event.ClientIP}
+	}
+	if event.User != "" || event.Email != "" {
+		e.User = &ecsUser{Name: event.User, Email: event.Email}
+	}
+
+	e.Observer.Product = siemVendor
+	e.Observer.Type = "proxy"
+	e.Observer.Version = version.VERSION
+	return e
+}
+
+// formatCEF formats the events as Common Event Format lines
+func formatCEF(events []Event) []byte {
+	var buf bytes.Buffer
+	for _, event := range events {
+		signature, name, severity := "authorization-deny", "Request denied", 5
+		if event.Allowed {
+			signature, name, severity = "authorization-allow", "Request allowed", 1
+		}
+		fmt.Fprintf(&buf, "CEF:0|%s|%s|%s|%s|%s|%d|",
+			cefHeader(siemVendor), cefHeader(siemVendor), cefHeader(version.VERSION), signature, name, severity)
+
+		ext := []string{
+			fmt.Sprintf("rt=%d", event.Timestamp.UnixNano()/1e6),
+			"requestMethod=" + cefValue(event.Method),
+			"dhost=" + cefValue(event.Host),
+			"request=" + cefValue(event.Path),
+		}
+		if event.Query != "" {
+			ext = append(ext, "cs3Label=query", "cs3="+cefValue(event.Query))
+		}
+		if event.ClientIP != "" {
+			ext = append(ext, "src="+cefValue(event.ClientIP))
+		}
+		if event.User != "" {
+			ext = append(ext, "suser="+cefValue(event.User))
+		}
+		if event.Email != "" {
+			ext = append(ext, "cs4Label=email", "cs4="+cefValue(event.Email))
+		}
+		if event.RuleSet != "" {
+			ext = append(ext, "cs1Label=ruleSet", "cs1="+cefValue(event.RuleSet))
+		}
+		if event.Rule != "" {
+			ext = append(ext, "cs2Label=rule", "cs2="+cefValue(event.Rule))
+		}
+		if event.Action != "" {
+			ext = append(ext, "act="+cefValue(event.Action))
+		}
+		buf.WriteString(strings.Join(ext, " "))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// cefHeader escapes a value of a CEF header field
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// cefValue escapes a value of a CEF extension field
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
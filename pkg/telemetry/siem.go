@@ -0,0 +1,234 @@
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// siemMaxAttempts is the number of times a batch is sent before it is dropped
+const siemMaxAttempts = 3
+
+// Event is an authorization decision streamed to a SIEM
+type Event struct {
+	Timestamp time.Time
+	Allowed   bool
+
+	// RuleSet, Rule and Action are the active deny rule set, and the deny
+	// rule that matched a denied request and how the proxy responded
+	RuleSet string
+	Rule    string
+	Action  string
+
+	Method   string
+	Host     string
+	Path     string
+	Query    string
+	ClientIP string
+
+	User  string
+	Email string
+}
+
+// SIEMStreamer sends authorization decisions to a SIEM HTTP endpoint in
+// batches. Events are queued without blocking requests: when the endpoint
+// falls behind and the queue is full, new events are dropped and counted.
+type SIEMStreamer struct {
+	url             string
+	format          string
+	allowSampleRate float64
+	batchSize       int
+	flushInterval   time.Duration
+	client          *http.Client
+
+	events  chan Event
+	dropped uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// ValidateSIEMOptions checks that a SIEMStreamer can be constructed from the
+// options
+func ValidateSIEMOptions(opts options.SIEMOptions) error {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return fmt.Errorf("error parsing SIEM URL %q: %v", opts.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("SIEM URL %q must be an absolute http or https URL", opts.URL)
+	}
+	if opts.Format != options.ECSFormat && opts.Format != options.CEFFormat {
+		return fmt.Errorf("unknown SIEM format %q", opts.Format)
+	}
+	if opts.AllowSampleRate < 0 || opts.AllowSampleRate > 1 {
+		return fmt.Errorf("SIEM allow sample rate (%v) must be between 0 and 1", opts.AllowSampleRate)
+	}
+	if opts.BatchSize <= 0 {
+		return fmt.Errorf("SIEM batch size (%d) must be positive", opts.BatchSize)
+	}
+	if opts.QueueSize < opts.BatchSize {
+		return fmt.Errorf("SIEM queue size (%d) must be at least the batch size (%d)", opts.QueueSize, opts.BatchSize)
+	}
+	if opts.FlushInterval <= 0 {
+		return fmt.Errorf("SIEM flush interval (%s) must be positive", opts.FlushInterval)
+	}
+	if opts.Timeout <= 0 {
+		return fmt.Errorf("SIEM timeout (%s) must be positive", opts.Timeout)
+	}
+	return nil
+}
+
+// NewSIEMStreamer constructs a SIEMStreamer from the options and starts
+// sending its events
+func NewSIEMStreamer(opts options.SIEMOptions) (*SIEMStreamer, error) {
+	if err := ValidateSIEMOptions(opts); err != nil {
+		return nil, err
+	}
+
+	s := &SIEMStreamer{
+		url:             opts.URL,
+		format:          opts.Format,
+		allowSampleRate: opts.AllowSampleRate,
+		batchSize:       opts.BatchSize,
+		flushInterval:   opts.FlushInterval,
+		client:          &http.Client{Timeout: opts.Timeout},
+		events:          make(chan Event, opts.QueueSize),
+		done:            make(chan struct{}),
+		stopped:         make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Record queues the event to be sent. All denied requests are sent, and
+// allowed requests at the allow sample rate.
+func (s *SIEMStreamer) Record(event Event) {
+	if event.Allowed && (s.allowSampleRate == 0 || rand.Float64() >= s.allowSampleRate) {
+		return
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped is the number of events dropped because the queue was full or the
+// endpoint could not be reached
+func (s *SIEMStreamer) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close sends the queued events and stops the streamer
+func (s *SIEMStreamer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	<-s.stopped
+}
+
+// run collects events into batches, which are sent when they are full or
+// the flush interval passes
+func (s *SIEMStreamer) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var reported uint64
+	batch := make([]Event, 0, s.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			s.send(batch)
+			batch = make([]Event, 0, s.batchSize)
+		}
+	}
+
+	for {
+		select {
+		case event := <-s.events:
+			batch = append(batch, event)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if dropped := s.Dropped(); dropped > reported {
+				logger.Errorf("WARNING: Dropped %d events for SIEM endpoint %s as it is falling behind", dropped-reported, s.url)
+				reported = dropped
+			}
+		case <-s.done:
+			for {
+				select {
+				case event := <-s.events:
+					batch = append(batch, event)
+					if len(batch) >= s.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts the batch to the endpoint, retrying failed requests with a
+// backoff. Events keep queueing while a batch is retried.
+func (s *SIEMStreamer) send(batch []Event) {
+	var body []byte
+	var contentType string
+	switch s.format {
+	case options.CEFFormat:
+		body, contentType = formatCEF(batch), "text/plain"
+	default:
+		body, contentType = formatECS(batch), "application/x-ndjson"
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := s.post(body, contentType)
+		if err == nil {
+			return
+		}
+		if attempt == siemMaxAttempts {
+			atomic.AddUint64(&s.dropped, uint64(len(batch)))
+			logger.Errorf("Error sending %d events to SIEM endpoint %s, dropping them: %v", len(batch), s.url, err)
+			return
+		}
+		logger.Errorf("Error sending %d events to SIEM endpoint %s, retrying in %s: %v", len(batch), s.url, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a request with the body to the endpoint
+func (s *SIEMStreamer) post(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
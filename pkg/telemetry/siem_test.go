@@ -0,0 +1,201 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SIEM Streamer Suite", func() {
+	var (
+		server       *httptest.Server
+		mu           sync.Mutex
+		bodies       [][]byte
+		contentTypes []string
+	)
+
+	BeforeEach(func() {
+		bodies, contentTypes = nil, nil
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).ToNot(HaveOccurred())
+			mu.Lock()
+			defer mu.Unlock()
+			bodies = append(bodies, body)
+			contentTypes = append(contentTypes, req.Header.Get("Content-Type"))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// received returns the bodies and content types of the requests to the
+	// endpoint
+	received := func() ([][]byte, []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]byte{}, bodies...), append([]string{}, contentTypes...)
+	}
+
+	newOptions := func() options.SIEMOptions {
+		return options.SIEMOptions{
+			URL:           server.URL,
+			Format:        options.ECSFormat,
+			BatchSize:     2,
+			FlushInterval: time.Hour,
+			QueueSize:     10,
+			Timeout:       time.Second,
+		}
+	}
+
+	denied := Event{
+		Timestamp: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		RuleSet:   "default",
+		Rule:      "deny-admin",
+		Action:    "forbidden",
+		Method:    "GET",
+		Host:      "app.example.com",
+		Path:      "/admin",
+		ClientIP:  "10.1.2.3",
+		Email:     "user@example.com",
+	}
+
+	It("sends denied requests in batches", func() {
+		streamer, err := NewSIEMStreamer(newOptions())
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 3; i++ {
+			streamer.Record(denied)
+		}
+		Eventually(func() [][]byte {
+			requests, _ := received()
+			return requests
+		}).Should(HaveLen(1))
+
+		streamer.Close()
+		requests, types := received()
+		Expect(requests).To(HaveLen(2))
+		Expect(types).To(ConsistOf("application/x-ndjson", "application/x-ndjson"))
+		Expect(bytes.Count(requests[0], []byte("\n"))).To(Equal(2))
+		Expect(bytes.Count(requests[1], []byte("\n"))).To(Equal(1))
+	})
+
+	It("formats events as ECS documents", func() {
+		streamer, err := NewSIEMStreamer(newOptions())
+		Expect(err).ToNot(HaveOccurred())
+		streamer.Record(denied)
+		streamer.Close()
+
+		requests, _ := received()
+		Expect(requests).To(HaveLen(1))
+		scanner := bufio.NewScanner(bytes.NewReader(requests[0]))
+		Expect(scanner.Scan()).To(BeTrue())
+		var doc map[string]interface{}
+		Expect(json.Unmarshal(scanner.Bytes(), &doc)).To(Succeed())
+		Expect(doc["@timestamp"]).To(Equal("2021-03-04T05:06:07.000Z"))
+		Expect(doc["event"]).To(HaveKeyWithValue("action", "authorization-deny"))
+		Expect(doc["event"]).To(HaveKeyWithValue("outcome", "failure"))
+		Expect(doc["event"]).To(HaveKeyWithValue("reason", "forbidden"))
+		Expect(doc["rule"]).To(Equal(map[string]interface{}{"id": "deny-admin", "ruleset": "default"}))
+		Expect(doc["url"]).To(Equal(map[string]interface{}{"domain": "app.example.com", "path": "/admin"}))
+		Expect(doc["source"]).To(Equal(map[string]interface{}{"ip": "10.1.2.3"}))
+		Expect(doc["user"]).To(Equal(map[string]interface{}{"email": "user@example.com"}))
+	})
+
+	It("formats events as CEF lines", func() {
+		opts := newOptions()
+		opts.Format = options.CEFFormat
+		streamer, err := NewSIEMStreamer(opts)
+		Expect(err).ToNot(HaveOccurred())
+		streamer.Record(denied)
+		streamer.Close()
+
+		requests, types := received()
+		Expect(requests).To(HaveLen(1))
+		Expect(types).To(ConsistOf("text/plain"))
+		Expect(string(requests[0])).To(MatchRegexp(`^CEF:0\|OAuth2 Proxy\|OAuth2 Proxy\|[^|]*\|authorization-deny\|Request denied\|5\|`))
+		Expect(string(requests[0])).To(HaveSuffix("rt=1614834367000 requestMethod=GET dhost=app.example.com request=/admin src=10.1.2.3 " +
+			"cs4Label=email cs4=user@example.com cs1Label=ruleSet cs1=default cs2Label=rule cs2=deny-admin act=forbidden\n"))
+	})
+
+	It("escapes CEF values", func() {
+		Expect(cefHeader(`a|b\c`)).To(Equal(`a\|b\\c`))
+		Expect(cefValue("a=b\\c\nd")).To(Equal(`a\=b\\c\nd`))
+	})
+
+	DescribeTable("samples allowed requests",
+		func(rate float64, sent int) {
+			opts := newOptions()
+			opts.AllowSampleRate = rate
+			streamer, err := NewSIEMStreamer(opts)
+			Expect(err).ToNot(HaveOccurred())
+
+			allowed := denied
+			allowed.Allowed = true
+			streamer.Record(allowed)
+			streamer.Close()
+			requests, _ := received()
+			Expect(requests).To(HaveLen(sent))
+		},
+		Entry("without sampling", 0.0, 0),
+		Entry("with all allowed requests", 1.0, 1),
+	)
+
+	It("drops events when the queue is full", func() {
+		// Block the endpoint so that events queue
+		block := make(chan struct{})
+		blocked := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			<-block
+		}))
+		defer blocked.Close()
+
+		opts := newOptions()
+		opts.URL = blocked.URL
+		opts.BatchSize = 1
+		opts.QueueSize = 1
+		streamer, err := NewSIEMStreamer(opts)
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 5; i++ {
+			streamer.Record(denied)
+		}
+		Expect(streamer.Dropped()).To(BeNumerically(">=", 3))
+		close(block)
+		streamer.Close()
+	})
+
+	DescribeTable("ValidateSIEMOptions",
+		func(modify func(*options.SIEMOptions), expected string) {
+			opts := newOptions()
+			modify(&opts)
+			err := ValidateSIEMOptions(opts)
+			if expected == "" {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expected))
+			}
+		},
+		Entry("valid options", func(*options.SIEMOptions) {}, ""),
+		Entry("relative URL", func(o *options.SIEMOptions) { o.URL = "/siem" },
+			`SIEM URL "/siem" must be an absolute http or https URL`),
+		Entry("unknown format", func(o *options.SIEMOptions) { o.Format = "leef" },
+			`unknown SIEM format "leef"`),
+		Entry("sample rate above 1", func(o *options.SIEMOptions) { o.AllowSampleRate = 1.5 },
+			"SIEM allow sample rate (1.5) must be between 0 and 1"),
+		Entry("queue smaller than a batch", func(o *options.SIEMOptions) { o.QueueSize = 1 },
+			"SIEM queue size (1) must be at least the batch size (2)"),
+		Entry("no flush interval", func(o *options.SIEMOptions) { o.FlushInterval = 0 },
+			"SIEM flush interval (0s) must be positive"),
+	)
+})
@@ -0,0 +1,162 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// blockedRanges are the link-local and cloud metadata ranges that upstream
+// connections are never made to, unless a CIDR in the allowlist contains
+// the address
+var blockedRanges = []string{
+	"169.254.0.0/16",
+	"fe80::/10",
+	"fd00:ec2::254/128",
+}
+
+// HostAllowlist restricts the hosts that requests are proxied to.
+// Connections to link-local and cloud metadata addresses are always refused
+// unless explicitly allowed by a CIDR, and when hosts or CIDRs are given,
+// connections to any other host are refused.
+type HostAllowlist struct {
+	hosts   map[string]bool
+	cidrs   []*net.IPNet
+	blocked []*net.IPNet
+	dialer  *net.Dialer
+}
+
+// NewHostAllowlist constructs a HostAllowlist from host names and CIDR
+// ranges. An empty allowlist allows every host outside the blocked ranges.
+func NewHostAllowlist(entries []string) (*HostAllowlist, error) {
+	a := &HostAllowlist{
+		hosts: map[string]bool{},
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			_, cidr, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid upstream allowed host %q: %v", entry, err)
+			}
+			a.cidrs = append(a.cidrs, cidr)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			a.cidrs = append(a.cidrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		if entry == "" {
+			return nil, fmt.Errorf("invalid upstream allowed host %q", entry)
+		}
+		a.hosts[strings.ToLower(entry)] = true
+	}
+	for _, r := range blockedRanges {
+		_, cidr, _ := net.ParseCIDR(r)
+		a.blocked = append(a.blocked, cidr)
+	}
+	return a, nil
+}
+
+// CheckHost checks that the host of an upstream URI may be proxied to.
+// Host names are checked before they are resolved, so only an IP address
+// can be checked against the blocked ranges.
+func (a *HostAllowlist) CheckHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return a.checkIP(host, ip)
+	}
+	if len(a.hosts) == 0 && len(a.cidrs) == 0 {
+		return nil
+	}
+	if !a.hosts[strings.ToLower(host)] {
+		return fmt.Errorf("host %q is not an allowed upstream host", host)
+	}
+	return nil
+}
+
+// checkIP checks that the address the host resolved to may be connected to
+func (a *HostAllowlist) checkIP(host string, ip net.IP) error {
+	for _, cidr := range a.cidrs {
+		if cidr.Contains(ip) {
+			return nil
+		}
+	}
+	for _, cidr := range a.blocked {
+		if cidr.Contains(ip) {
+			return fmt.Errorf("host %q resolves to blocked address %s", host, ip)
+		}
+	}
+	if len(a.hosts) == 0 && len(a.cidrs) == 0 {
+		return nil
+	}
+	if !a.hosts[strings.ToLower(host)] {
+		return fmt.Errorf("host %q is not an allowed upstream host", host)
+	}
+	return nil
+}
+
+// DialContext resolves the host of the address and connects to the first
+// of its addresses allowed by the allowlist, so that a host name cannot be
+// used to reach a blocked address
+func (a *HostAllowlist) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if err = a.checkIP(host, ip.IP); err != nil {
+			continue
+		}
+		var conn net.Conn
+		conn, err = a.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("host %q has no addresses", host)
+	}
+	return nil, err
+}
+
+// Dial is DialContext without a context, for the websocket proxy
+func (a *HostAllowlist) Dial(network, addr string) (net.Conn, error) {
+	return a.DialContext(context.Background(), network, addr)
+}
+
+// DialTLS returns a Dial that connects through the allowlist and then
+// completes a TLS handshake with the config, for wss upstreams of the
+// websocket proxy, which would otherwise dial them itself
+func (a *HostAllowlist) DialTLS(config *tls.Config) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := a.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &tls.Config{}
+		if config != nil {
+			c = config.Clone()
+		}
+		if c.ServerName == "" {
+			c.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, c)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HostAllowlist", func() {
+	DescribeTable("CheckHost",
+		func(entries []string, host string, expectedErr string) {
+			hosts, err := NewHostAllowlist(entries)
+			Expect(err).ToNot(HaveOccurred())
+			if expectedErr == "" {
+				Expect(hosts.CheckHost(host)).To(Succeed())
+			} else {
+				Expect(hosts.CheckHost(host)).To(MatchError(expectedErr))
+			}
+		},
+		Entry("any host without an allowlist", nil, "backend.internal", ""),
+		Entry("an IP without an allowlist", nil, "10.0.0.1", ""),
+		Entry("the metadata IP without an allowlist", nil, "169.254.169.254", `host "169.254.169.254" resolves to blocked address 169.254.169.254`),
+		Entry("an IPv6 link-local IP", nil, "fe80::1", `host "fe80::1" resolves to blocked address fe80::1`),
+		Entry("an allowed host", []string{"Backend.Internal"}, "backend.internal", ""),
+		Entry("a host that is not allowed", []string{"backend.internal"}, "admin.internal", `host "admin.internal" is not an allowed upstream host`),
+		Entry("an IP in an allowed CIDR", []string{"10.0.0.0/8"}, "10.1.2.3", ""),
+		Entry("an IP outside the allowed CIDRs", []string{"10.0.0.0/8"}, "192.168.0.1", `host "192.168.0.1" is not an allowed upstream host`),
+		Entry("an allowed IP", []string{"192.168.0.1"}, "192.168.0.1", ""),
+		Entry("a link-local IP allowed by a CIDR", []string{"169.254.10.0/24"}, "169.254.10.1", ""),
+	)
+
+	It("rejects invalid entries", func() {
+		_, err := NewHostAllowlist([]string{"10.0.0.0/33"})
+		Expect(err).To(MatchError(`invalid upstream allowed host "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`))
+	})
+
+	Context("DialContext", func() {
+		var server *httptest.Server
+		var addr string
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+			u, err := url.Parse(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+			addr = u.Host
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("connects to allowed addresses", func() {
+			hosts, err := NewHostAllowlist([]string{"127.0.0.0/8"})
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := hosts.DialContext(context.Background(), "tcp", addr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.Close()).To(Succeed())
+		})
+
+		It("refuses addresses that are not allowed", func() {
+			hosts, err := NewHostAllowlist([]string{"backend.internal"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = hosts.DialContext(context.Background(), "tcp", addr)
+			Expect(err).To(MatchError(ContainSubstring("is not an allowed upstream host")))
+		})
+	})
+
+	Context("DialTLS", func() {
+		var server *httptest.Server
+		var addr string
+
+		BeforeEach(func() {
+			server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+			u, err := url.Parse(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+			addr = u.Host
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("connects to allowed addresses over TLS", func() {
+			hosts, err := NewHostAllowlist([]string{"127.0.0.0/8"})
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := hosts.DialTLS(&tls.Config{InsecureSkipVerify: true})("tcp", addr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn).To(BeAssignableToTypeOf(&tls.Conn{}))
+			Expect(conn.Close()).To(Succeed())
+		})
+
+		It("verifies the certificate of the upstream", func() {
+			hosts, err := NewHostAllowlist([]string{"127.0.0.0/8"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = hosts.DialTLS(nil)("tcp", addr)
+			Expect(err).To(MatchError(ContainSubstring("certificate")))
+		})
+
+		It("refuses addresses that are not allowed", func() {
+			hosts, err := NewHostAllowlist([]string{"backend.internal"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = hosts.DialTLS(&tls.Config{InsecureSkipVerify: true})("tcp", addr)
+			Expect(err).To(MatchError(ContainSubstring("is not an allowed upstream host")))
+		})
+	})
+})
@@ -2,13 +2,17 @@ package upstream
 
 import (
 	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/yhat/wsutil"
 )
 
@@ -39,17 +43,18 @@ var SignatureHeaders = []string{
 
 // newHTTPUpstreamProxy creates a new httpUpstreamProxy that can serve requests
 // to a single upstream host.
-func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, errorHandler ProxyErrorHandler) http.Handler {
+// Connections to the upstream are restricted by the host allowlist, if any.
+func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, injectedHeaders []string, hosts *HostAllowlist, errorHandler ProxyErrorHandler) http.Handler {
 	// Set path to empty so that request paths start at the server root
 	u.Path = ""
 
 	// Create a ReverseProxy
-	proxy := newReverseProxy(u, upstream, errorHandler)
+	proxy := newReverseProxy(u, upstream, hosts, errorHandler)
 
 	// Set up a WebSocket proxy if required
 	var wsProxy http.Handler
 	if upstream.ProxyWebSockets == nil || *upstream.ProxyWebSockets {
-		wsProxy = newWebSocketReverseProxy(u, upstream.InsecureSkipTLSVerify)
+		wsProxy = newWebSocketReverseProxy(u, upstream.InsecureSkipTLSVerify, hosts)
 	}
 
 	var auth hmacauth.HmacAuth
@@ -58,27 +63,42 @@ func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *option
 	}
 
 	return &httpUpstreamProxy{
-		upstream:  upstream.ID,
-		handler:   proxy,
-		wsHandler: wsProxy,
-		auth:      auth,
+		upstream:     upstream.ID,
+		handler:      proxy,
+		wsHandler:    wsProxy,
+		auth:         auth,
+		identity:     newIdentityPropagator(upstream, sigData, injectedHeaders),
+		errorHandler: errorHandler,
+		now:          time.Now,
 	}
 }
 
 // httpUpstreamProxy represents a single HTTP(S) upstream proxy
 type httpUpstreamProxy struct {
-	upstream  string
-	handler   http.Handler
-	wsHandler http.Handler
-	auth      hmacauth.HmacAuth
+	upstream     string
+	handler      http.Handler
+	wsHandler    http.Handler
+	auth         hmacauth.HmacAuth
+	identity     *identityPropagator
+	errorHandler ProxyErrorHandler
+	now          func() time.Time
 }
 
 // ServeHTTP proxies requests to the upstream provider while signing the
 // request headers
 func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	rw.Header().Set("GAP-Upstream-Address", h.upstream)
+	if h.identity != nil {
+		if err := h.identity.propagate(req); err != nil {
+			h.handleError(rw, req, fmt.Errorf("error propagating identity to upstream %q: %v", h.upstream, err))
+			return
+		}
+	}
 	if h.auth != nil {
 		req.Header.Set("GAP-Auth", rw.Header().Get("GAP-Auth"))
+		// Sign the time of the request, so that a chained oauth2-proxy can
+		// reject signed requests that are replayed later
+		req.Header.Set("Date", h.now().UTC().Format(http.TimeFormat))
 		h.auth.SignRequest(req)
 	}
 	if h.wsHandler != nil && strings.EqualFold(req.Header.Get("Connection"), "upgrade") && req.Header.Get("Upgrade") == "websocket" {
@@ -88,11 +108,22 @@ func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	}
 }
 
+// handleError renders the error page, or a plain error if there is no error
+// handler
+func (h *httpUpstreamProxy) handleError(rw http.ResponseWriter, req *http.Request, err error) {
+	if h.errorHandler != nil {
+		h.errorHandler(rw, req, err)
+		return
+	}
+	logger.Errorf("Error proxying to upstream server: %v", err)
+	http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
 // newReverseProxy creates a new reverse proxy for proxying requests to upstream
 // servers based on the upstream configuration provided.
 // The proxy should render an error page if there are failures connecting to the
 // upstream server.
-func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler ProxyErrorHandler) http.Handler {
+func newReverseProxy(target *url.URL, upstream options.Upstream, hosts *HostAllowlist, errorHandler ProxyErrorHandler) http.Handler {
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
 	// Configure options on the SingleHostReverseProxy
@@ -104,12 +135,24 @@ func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler Pr
 
 	// InsecureSkipVerify is a configurable option we allow
 	/* #nosec G402 */
+	var transport *http.Transport
 	if upstream.InsecureSkipTLSVerify {
-		proxy.Transport = &http.Transport{
+		transport = &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		}
 	}
 
+	// Only connect to the addresses allowed by the host allowlist
+	if hosts != nil {
+		if transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		transport.DialContext = hosts.DialContext
+	}
+	if transport != nil {
+		proxy.Transport = transport
+	}
+
 	// Set the request director based on the PassHostHeader option
 	if upstream.PassHostHeader != nil && !*upstream.PassHostHeader {
 		setProxyUpstreamHostHeader(proxy, target)
@@ -151,15 +194,33 @@ func setProxyDirector(proxy *httputil.ReverseProxy) {
 }
 
 // newWebSocketReverseProxy creates a new reverse proxy for proxying websocket connections.
-func newWebSocketReverseProxy(u *url.URL, skipTLSVerify bool) http.Handler {
+func newWebSocketReverseProxy(u *url.URL, skipTLSVerify bool, hosts *HostAllowlist) http.Handler {
 	// This should create the correct scheme for insecure vs secure connections
 	wsScheme := "ws" + strings.TrimPrefix(u.Scheme, "http")
 	wsURL := &url.URL{Scheme: wsScheme, Host: u.Host}
 
-	wsProxy := wsutil.NewSingleHostReverseProxy(wsURL)
+	var tlsConfig *tls.Config
 	/* #nosec G402 */
 	if skipTLSVerify {
-		wsProxy.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	if hosts == nil {
+		wsProxy := wsutil.NewSingleHostReverseProxy(wsURL)
+		wsProxy.TLSClientConfig = tlsConfig
+		return wsProxy
+	}
+
+	// The websocket proxy dials wss upstreams itself, ignoring Dial, so they
+	// are proxied as ws over a TLS connection dialled through the allowlist
+	dial := hosts.Dial
+	if wsScheme == "wss" {
+		if wsURL.Port() == "" {
+			wsURL.Host = net.JoinHostPort(wsURL.Hostname(), "443")
+		}
+		wsURL.Scheme = "ws"
+		dial = hosts.DialTLS(tlsConfig)
+	}
+	wsProxy := wsutil.NewSingleHostReverseProxy(wsURL)
+	wsProxy.Dial = dial
 	return wsProxy
 }
@@ -26,6 +26,7 @@ var _ = Describe("HTTP Upstream Suite", func() {
 	const flushInterval1s = options.Duration(1 * time.Second)
 	truth := true
 	falsum := false
+	now := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
 
 	type httpUpstreamTableInput struct {
 		id               string
@@ -66,7 +67,8 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			u, err := url.Parse(*in.serverAddr)
 			Expect(err).ToNot(HaveOccurred())
 
-			handler := newHTTPUpstreamProxy(upstream, u, in.signatureData, in.errorHandler)
+			handler := newHTTPUpstreamProxy(upstream, u, in.signatureData, nil, nil, in.errorHandler)
+			handler.(*httpUpstreamProxy).now = func() time.Time { return now }
 			handler.ServeHTTP(rw, req)
 
 			Expect(rw.Code).To(Equal(in.expectedResponse.code))
@@ -213,8 +215,9 @@ var _ = Describe("HTTP Upstream Suite", func() {
 					Method: "GET",
 					URL:    "http://example.localhost/withSignature",
 					Header: map[string][]string{
+						"Date":       {"Mon, 02 Jan 2006 15:04:05 GMT"},
 						gapAuth:      {""},
-						gapSignature: {"sha256 osMWI8Rr0Zr5HgNq6wakrgJITVJQMmFN1fXCesrqrmM="},
+						gapSignature: {"sha256 Krmru0Rb8W1CLpp43FbzMtKgSKv5EfPf5WHrhlTqAyE="},
 					},
 					Body:       []byte{},
 					Host:       "example.localhost",
@@ -270,7 +273,7 @@ var _ = Describe("HTTP Upstream Suite", func() {
 		u, err := url.Parse(serverAddr)
 		Expect(err).ToNot(HaveOccurred())
 
-		handler := newHTTPUpstreamProxy(upstream, u, nil, nil)
+		handler := newHTTPUpstreamProxy(upstream, u, nil, nil, nil, nil)
 		httpUpstream, ok := handler.(*httpUpstreamProxy)
 		Expect(ok).To(BeTrue())
 
@@ -308,7 +311,7 @@ var _ = Describe("HTTP Upstream Suite", func() {
 				ProxyWebSockets:       &in.proxyWebSockets,
 			}
 
-			handler := newHTTPUpstreamProxy(upstream, u, in.sigData, in.errorHandler)
+			handler := newHTTPUpstreamProxy(upstream, u, in.sigData, nil, nil, in.errorHandler)
 			upstreamProxy, ok := handler.(*httpUpstreamProxy)
 			Expect(ok).To(BeTrue())
 
@@ -382,7 +385,7 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			u, err := url.Parse(serverAddr)
 			Expect(err).ToNot(HaveOccurred())
 
-			handler := newHTTPUpstreamProxy(upstream, u, nil, nil)
+			handler := newHTTPUpstreamProxy(upstream, u, nil, nil, nil, nil)
 			proxyServer = httptest.NewServer(handler)
 		})
 
@@ -417,4 +420,60 @@ var _ = Describe("HTTP Upstream Suite", func() {
 			Expect(response.Header.Get(gapUpstream)).To(Equal("websocketProxy"))
 		})
 	})
+
+	Context("with a wss websocket proxy and a host allowlist", func() {
+		var tlsServer *httptest.Server
+
+		BeforeEach(func() {
+			tlsServer = httptest.NewTLSServer(&testHTTPUpstream{})
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+		})
+
+		newProxyServer := func(allowed []string) *httptest.Server {
+			upstream := options.Upstream{
+				ID:                    "websocketProxy",
+				PassHostHeader:        &truth,
+				ProxyWebSockets:       &truth,
+				InsecureSkipTLSVerify: true,
+			}
+
+			u, err := url.Parse(tlsServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			hosts, err := NewHostAllowlist(allowed)
+			Expect(err).ToNot(HaveOccurred())
+
+			return httptest.NewServer(newHTTPUpstreamProxy(upstream, u, nil, nil, hosts, nil))
+		}
+
+		It("will proxy websockets to an allowed host", func() {
+			proxyServer := newProxyServer([]string{"127.0.0.0/8"})
+			defer proxyServer.Close()
+
+			origin := "http://example.localhost"
+			message := "Hello, world!"
+
+			ws, err := websocket.Dial(fmt.Sprintf("ws://%s/", proxyServer.Listener.Addr().String()), "", origin)
+			Expect(err).ToNot(HaveOccurred())
+			defer ws.Close()
+
+			Expect(websocket.Message.Send(ws, []byte(message))).To(Succeed())
+			var response testWebSocketResponse
+			Expect(websocket.JSON.Receive(ws, &response)).To(Succeed())
+			Expect(response).To(Equal(testWebSocketResponse{
+				Message: message,
+				Origin:  origin,
+			}))
+		})
+
+		It("will not proxy websockets to a host that is not allowed", func() {
+			proxyServer := newProxyServer([]string{"backend.internal"})
+			defer proxyServer.Close()
+
+			_, err := websocket.Dial(fmt.Sprintf("ws://%s/", proxyServer.Listener.Addr().String()), "", "http://example.localhost")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
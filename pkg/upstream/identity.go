@@ -0,0 +1,137 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+)
+
+const (
+	// IdentityHeader is the name of the request header containing the signed
+	// identity JWT for upstreams using the jwt identity format
+	IdentityHeader = "X-Forwarded-Identity"
+
+	// identityJWTExpiry is the lifetime of identity JWTs for sessions without
+	// an expiry
+	identityJWTExpiry = 5 * time.Minute
+)
+
+// IdentityClaims are the claims of the identity JWT passed to upstreams, and
+// consumed by chained oauth2-proxy instances
+type IdentityClaims struct {
+	Email             string   `json:"email,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	jwt.StandardClaims
+}
+
+// identityPropagator conveys the identity of the authenticated user to an
+// upstream in the format configured for it
+type identityPropagator struct {
+	format          options.IdentityFormat
+	injectedHeaders []string
+	signingKey      []byte
+	audience        string
+	now             func() time.Time
+}
+
+// newIdentityPropagator creates an identityPropagator for the upstream.
+// It returns nil for the headers format, as the injected headers are already
+// set on the request.
+func newIdentityPropagator(upstream options.Upstream, sigData *options.SignatureData, injectedHeaders []string) *identityPropagator {
+	if upstream.IdentityFormat == "" || upstream.IdentityFormat == options.IdentityFormatHeaders {
+		return nil
+	}
+
+	i := &identityPropagator{
+		format:          upstream.IdentityFormat,
+		injectedHeaders: injectedHeaders,
+		now:             time.Now,
+	}
+	if sigData != nil {
+		i.signingKey = []byte(sigData.Key)
+	}
+	// The identity JWT is only meant for the upstream, so that a chained
+	// oauth2-proxy can reject JWTs signed for other upstreams
+	if u, err := url.Parse(upstream.URI); err == nil && u.Host != "" {
+		i.audience = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+	}
+	return i
+}
+
+// propagate replaces the injected identity headers on the request with the
+// configured format
+func (i *identityPropagator) propagate(req *http.Request) error {
+	for _, header := range i.injectedHeaders {
+		req.Header.Del(header)
+	}
+
+	var session *sessionsapi.SessionState
+	if scope := middlewareapi.GetRequestScope(req); scope != nil {
+		session = scope.Session
+	}
+	if session == nil {
+		return nil
+	}
+
+	switch i.format {
+	case options.IdentityFormatIDToken:
+		setBearerToken(req, session.IDToken)
+	case options.IdentityFormatAccessToken:
+		setBearerToken(req, session.AccessToken)
+	case options.IdentityFormatJWT:
+		token, err := i.signIdentity(session)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(IdentityHeader, token)
+	}
+	return nil
+}
+
+// signIdentity creates a JWT describing the user, signed with the signature
+// key
+func (i *identityPropagator) signIdentity(session *sessionsapi.SessionState) (string, error) {
+	if len(i.signingKey) == 0 {
+		return "", fmt.Errorf("identity format %q requires a signature key", i.format)
+	}
+
+	now := i.now()
+	expiry := now.Add(identityJWTExpiry)
+	if session.ExpiresOn != nil && !session.ExpiresOn.IsZero() {
+		expiry = *session.ExpiresOn
+	}
+
+	claims := &IdentityClaims{
+		Email:             session.Email,
+		PreferredUsername: session.PreferredUsername,
+		Groups:            session.Groups,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   session.User,
+			Audience:  i.audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiry.Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(i.signingKey)
+	if err != nil {
+		return "", fmt.Errorf("error signing identity JWT: %v", err)
+	}
+	return token, nil
+}
+
+// setBearerToken replaces any Authorization header on the request with the
+// token
+func setBearerToken(req *http.Request, token string) {
+	req.Header.Del("Authorization")
+	if token == "" {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+}
@@ -0,0 +1,155 @@
+package upstream
+
+import (
+	"net/http/httptest"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Identity Propagation Suite", func() {
+	const signingKey = "identity-secret"
+	injectedHeaders := []string{"X-Forwarded-User", "X-Forwarded-Email"}
+	now := time.Unix(1600000000, 0)
+
+	newSession := func() *sessionsapi.SessionState {
+		return &sessionsapi.SessionState{
+			User:              "user",
+			Email:             "user@example.com",
+			PreferredUsername: "preferred",
+			Groups:            []string{"a", "b"},
+			IDToken:           "id.token",
+			AccessToken:       "access.token",
+		}
+	}
+
+	propagate := func(format options.IdentityFormat, session *sessionsapi.SessionState) (map[string]string, error) {
+		upstream := options.Upstream{ID: "foo", URI: "http://proxy.internal:4180/base", IdentityFormat: format}
+		propagator := newIdentityPropagator(upstream, &options.SignatureData{Key: signingKey}, injectedHeaders)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-User", "user")
+		req.Header.Set("X-Forwarded-Email", "user@example.com")
+		req.Header.Set("Authorization", "Bearer client")
+		req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: session})
+
+		if propagator != nil {
+			propagator.now = func() time.Time { return now }
+			if err := propagator.propagate(req); err != nil {
+				return nil, err
+			}
+		}
+
+		headers := map[string]string{}
+		for name := range req.Header {
+			headers[name] = req.Header.Get(name)
+		}
+		return headers, nil
+	}
+
+	type identityTableInput struct {
+		format          options.IdentityFormat
+		expectedHeaders map[string]string
+	}
+
+	DescribeTable("propagate",
+		func(in identityTableInput) {
+			headers, err := propagate(in.format, newSession())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers).To(Equal(in.expectedHeaders))
+		},
+		Entry("with the default format", identityTableInput{
+			format: "",
+			expectedHeaders: map[string]string{
+				"X-Forwarded-User":  "user",
+				"X-Forwarded-Email": "user@example.com",
+				"Authorization":     "Bearer client",
+			},
+		}),
+		Entry("with the headers format", identityTableInput{
+			format: options.IdentityFormatHeaders,
+			expectedHeaders: map[string]string{
+				"X-Forwarded-User":  "user",
+				"X-Forwarded-Email": "user@example.com",
+				"Authorization":     "Bearer client",
+			},
+		}),
+		Entry("with the idToken format", identityTableInput{
+			format: options.IdentityFormatIDToken,
+			expectedHeaders: map[string]string{
+				"Authorization": "Bearer id.token",
+			},
+		}),
+		Entry("with the accessToken format", identityTableInput{
+			format: options.IdentityFormatAccessToken,
+			expectedHeaders: map[string]string{
+				"Authorization": "Bearer access.token",
+			},
+		}),
+		Entry("with the none format", identityTableInput{
+			format: options.IdentityFormatNone,
+			expectedHeaders: map[string]string{
+				"Authorization": "Bearer client",
+			},
+		}),
+	)
+
+	Context("with the jwt format", func() {
+		parse := func(token string) *IdentityClaims {
+			claims := &IdentityClaims{}
+			parser := &jwt.Parser{SkipClaimsValidation: true}
+			_, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+				return []byte(signingKey), nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return claims
+		}
+
+		It("passes a signed identity JWT", func() {
+			headers, err := propagate(options.IdentityFormatJWT, newSession())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers).To(HaveLen(2))
+			Expect(headers).To(HaveKeyWithValue("Authorization", "Bearer client"))
+
+			claims := parse(headers[IdentityHeader])
+			Expect(claims.Subject).To(Equal("user"))
+			Expect(claims.Email).To(Equal("user@example.com"))
+			Expect(claims.PreferredUsername).To(Equal("preferred"))
+			Expect(claims.Groups).To(Equal([]string{"a", "b"}))
+			Expect(claims.Audience).To(Equal("http://proxy.internal:4180"))
+			Expect(claims.IssuedAt).To(Equal(now.Unix()))
+			Expect(claims.ExpiresAt).To(Equal(now.Add(identityJWTExpiry).Unix()))
+		})
+
+		It("expires the JWT with the session", func() {
+			session := newSession()
+			expiresOn := now.Add(time.Hour)
+			session.ExpiresOn = &expiresOn
+
+			headers, err := propagate(options.IdentityFormatJWT, session)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parse(headers[IdentityHeader]).ExpiresAt).To(Equal(expiresOn.Unix()))
+		})
+
+		It("errors without a signature key", func() {
+			upstream := options.Upstream{ID: "foo", IdentityFormat: options.IdentityFormatJWT}
+			propagator := newIdentityPropagator(upstream, nil, injectedHeaders)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req = middlewareapi.AddRequestScope(req, &middlewareapi.RequestScope{Session: newSession()})
+			Expect(propagator.propagate(req)).To(MatchError("identity format \"jwt\" requires a signature key"))
+		})
+	})
+
+	It("removes the injected headers without a session", func() {
+		headers, err := propagate(options.IdentityFormatIDToken, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(headers).To(Equal(map[string]string{"Authorization": "Bearer client"}))
+	})
+})
@@ -16,7 +16,10 @@ type ProxyErrorHandler func(http.ResponseWriter, *http.Request, error)
 
 // NewProxy creates a new multiUpstreamProxy that can serve requests directed to
 // multiple upstreams.
-func NewProxy(upstreams options.Upstreams, sigData *options.SignatureData, errorHandler ProxyErrorHandler) (http.Handler, error) {
+// The injected headers are the request headers set by the proxy to identify
+// the user, which are removed for upstreams with another identity format.
+// HTTP upstreams must be allowed by the host allowlist, if any.
+func NewProxy(upstreams options.Upstreams, sigData *options.SignatureData, injectedHeaders []string, hosts *HostAllowlist, errorHandler ProxyErrorHandler) (http.Handler, error) {
 	m := &multiUpstreamProxy{
 		serveMux: http.NewServeMux(),
 	}
@@ -35,7 +38,12 @@ func NewProxy(upstreams options.Upstreams, sigData *options.SignatureData, error
 		case fileScheme:
 			m.registerFileServer(upstream, u)
 		case httpScheme, httpsScheme:
-			m.registerHTTPUpstreamProxy(upstream, u, sigData, errorHandler)
+			if hosts != nil {
+				if err := hosts.CheckHost(u.Hostname()); err != nil {
+					return nil, fmt.Errorf("upstream %q: %v", upstream.ID, err)
+				}
+			}
+			m.registerHTTPUpstreamProxy(upstream, u, sigData, injectedHeaders, hosts, errorHandler)
 		default:
 			return nil, fmt.Errorf("unknown scheme for upstream %q: %q", upstream.ID, u.Scheme)
 		}
@@ -67,9 +75,9 @@ func (m *multiUpstreamProxy) registerFileServer(upstream options.Upstream, u *ur
 }
 
 // registerHTTPUpstreamProxy registers a new httpUpstreamProxy based on the configuration given.
-func (m *multiUpstreamProxy) registerHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, errorHandler ProxyErrorHandler) {
+func (m *multiUpstreamProxy) registerHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, injectedHeaders []string, hosts *HostAllowlist, errorHandler ProxyErrorHandler) {
 	logger.Printf("mapping path %q => upstream %q", upstream.Path, upstream.URI)
-	m.serveMux.Handle(upstream.Path, newHTTPUpstreamProxy(upstream, u, sigData, errorHandler))
+	m.serveMux.Handle(upstream.Path, newHTTPUpstreamProxy(upstream, u, sigData, injectedHeaders, hosts, errorHandler))
 }
 
 // NewProxyErrorHandler creates a ProxyErrorHandler using the template given.
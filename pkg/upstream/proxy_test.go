@@ -56,7 +56,7 @@ var _ = Describe("Proxy Suite", func() {
 			},
 		}
 
-		upstreamServer, err = NewProxy(upstreams, sigData, errorHandler)
+		upstreamServer, err = NewProxy(upstreams, sigData, nil, nil, errorHandler)
 		Expect(err).ToNot(HaveOccurred())
 	})
 
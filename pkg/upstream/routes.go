@@ -0,0 +1,249 @@
+package upstream
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
+)
+
+const (
+	// PublishedRoutesPath is the path on the upstream server that public
+	// routes are published at
+	PublishedRoutesPath = "/.well-known/oauth2-proxy-routes.json"
+
+	// RoutesSignatureHeader is the name of the response header containing
+	// the base64 encoded HMAC of the published routes, created with the
+	// signature key
+	RoutesSignatureHeader = "Oauth2-Proxy-Routes-Signature"
+)
+
+// publishedRoutes is the document published by an upstream.
+// Routes are in the method=path_regex format of the skip_auth_routes option.
+type publishedRoutes struct {
+	Routes []string `json:"routes"`
+}
+
+// RoutesTarget is a set of routes that the published routes are kept in sync
+// with, such as the skip auth routes allowlist
+type RoutesTarget interface {
+	AddRoute(methodPath string) error
+	RemoveRoute(methodPath string) (bool, error)
+	Entries() []string
+}
+
+// RoutesSyncer periodically fetches the routes published by an upstream and
+// reconciles them into a RoutesTarget.
+// Routes that were already in the RoutesTarget are never removed by the
+// RoutesSyncer.
+type RoutesSyncer struct {
+	upstream string
+	path     string
+	endpoint string
+	sigData  *options.SignatureData
+	target   RoutesTarget
+	interval time.Duration
+	timeout  time.Duration
+
+	// synced are the routes added to the target by the RoutesSyncer
+	synced map[string]struct{}
+}
+
+// NewRoutesSyncer constructs a RoutesSyncer for the upstream.
+// The published routes must be signed with the signature key.
+func NewRoutesSyncer(upstream options.Upstream, sigData *options.SignatureData, target RoutesTarget, interval time.Duration) (*RoutesSyncer, error) {
+	if sigData == nil {
+		return nil, fmt.Errorf("upstream %q publishes routes, but no signature key is set", upstream.ID)
+	}
+	u, err := url.Parse(upstream.URI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URI %q: %v", upstream.URI, err)
+	}
+	u.Path = PublishedRoutesPath
+	u.RawQuery = ""
+
+	return &RoutesSyncer{
+		upstream: upstream.ID,
+		path:     upstream.Path,
+		endpoint: u.String(),
+		sigData:  sigData,
+		target:   target,
+		interval: interval,
+		timeout:  10 * time.Second,
+		synced:   make(map[string]struct{}),
+	}, nil
+}
+
+// Run syncs the routes immediately and then on each interval until done is
+// closed. Errors are logged and the previous routes are kept.
+func (s *RoutesSyncer) Run(done <-chan bool) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(); err != nil {
+			logger.Errorf("Error syncing routes published by upstream %q: %v", s.upstream, err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches the published routes once, adding new routes to the target
+// and removing routes that are no longer published.
+// Routes outside of the upstream path are ignored.
+func (s *RoutesSyncer) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	routes, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]struct{})
+	for _, entry := range s.target.Entries() {
+		existing[entry] = struct{}{}
+	}
+
+	current := make(map[string]struct{})
+	for _, published := range routes {
+		route, err := s.validateRoute(published)
+		if err != nil {
+			logger.Errorf("Ignoring route %q published by upstream %q: %v", published, s.upstream, err)
+			continue
+		}
+		current[route] = struct{}{}
+
+		if _, ok := existing[route]; ok {
+			continue
+		}
+		if err := s.target.AddRoute(route); err != nil {
+			return err
+		}
+		s.synced[route] = struct{}{}
+		logger.Printf("Skipping auth for route %q published by upstream %q", route, s.upstream)
+	}
+
+	for route := range s.synced {
+		if _, ok := current[route]; ok {
+			continue
+		}
+		if _, err := s.target.RemoveRoute(route); err != nil {
+			return err
+		}
+		delete(s.synced, route)
+		logger.Printf("No longer skipping auth for route %q published by upstream %q", route, s.upstream)
+	}
+	return nil
+}
+
+// fetch requests the published routes and verifies their signature
+func (s *RoutesSyncer) fetch(ctx context.Context) ([]string, error) {
+	result := requests.New(s.endpoint).
+		WithContext(ctx).
+		Do()
+	if result.Error() != nil {
+		return nil, result.Error()
+	}
+	if result.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status \"%d\": %s", result.StatusCode(), result.Body())
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(result.Headers().Get(RoutesSignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("missing or invalid %s header", RoutesSignatureHeader)
+	}
+	if !hmac.Equal(signature, s.sign(result.Body())) {
+		return nil, errors.New("published routes signature does not match")
+	}
+
+	published := &publishedRoutes{}
+	if err := json.Unmarshal(result.Body(), published); err != nil {
+		return nil, fmt.Errorf("error unmarshalling published routes: %v", err)
+	}
+	return published.Routes, nil
+}
+
+func (s *RoutesSyncer) sign(body []byte) []byte {
+	h := hmac.New(s.sigData.Hash.New, []byte(s.sigData.Key))
+	_, _ = h.Write(body)
+	return h.Sum(nil)
+}
+
+// validateRoute checks that the route only matches paths served by the
+// upstream, so that an upstream cannot skip authentication for other
+// upstreams.
+// It returns the route in the format listed by the target.
+func (s *RoutesSyncer) validateRoute(methodPath string) (string, error) {
+	route, err := allowlist.NormalizeRoute(methodPath)
+	if err != nil {
+		return "", err
+	}
+
+	path, _ := util.SplitDescription(methodPath)
+	if parts := strings.SplitN(path, "=", 2); len(parts) == 2 {
+		path = parts[1]
+	}
+	if !strings.HasPrefix(path, "^") {
+		return "", errors.New("path regex must be anchored with ^")
+	}
+
+	prefix, exact, err := literalPathPrefix(path)
+	if err != nil {
+		return "", err
+	}
+	// The prefix must end at a path segment boundary of the upstream path,
+	// so that the upstream /app cannot publish routes for /apple
+	upstreamPath := strings.TrimSuffix(s.path, "/")
+	if !strings.HasPrefix(prefix, upstreamPath+"/") && !(exact && prefix == s.path) {
+		return "", fmt.Errorf("path regex must start with the upstream path %q", s.path)
+	}
+	return route, nil
+}
+
+// literalPathPrefix returns the literal that every path matched by the
+// anchored path regex starts with, and whether the regex only matches that
+// literal
+func literalPathPrefix(path string) (string, bool, error) {
+	re, err := syntax.Parse(path, syntax.Perl)
+	if err != nil {
+		return "", false, err
+	}
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+
+	var prefix []rune
+	i := 0
+	for ; i < len(subs); i++ {
+		if i == 0 && subs[i].Op == syntax.OpBeginText {
+			continue
+		}
+		if subs[i].Op != syntax.OpLiteral || subs[i].Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix = append(prefix, subs[i].Rune...)
+	}
+	exact := i == len(subs)-1 && subs[i].Op == syntax.OpEndText
+	return string(prefix), exact, nil
+}
@@ -0,0 +1,140 @@
+package upstream
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/allowlist"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RoutesSyncer", func() {
+	const signingKey = "routes-secret"
+
+	var server *httptest.Server
+	var body string
+	var signature string
+	var target *allowlist.Routes
+	var syncer *RoutesSyncer
+
+	sign := func(key, body string) string {
+		h := hmac.New(crypto.SHA256.New, []byte(key))
+		_, _ = h.Write([]byte(body))
+		return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+
+	publish := func(routes string) {
+		body = `{"routes": [` + routes + `]}`
+		signature = sign(signingKey, body)
+	}
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != PublishedRoutesPath {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			rw.Header().Set(RoutesSignatureHeader, signature)
+			_, _ = rw.Write([]byte(body))
+		}))
+
+		target = allowlist.NewRoutes()
+		Expect(target.AddRoute("GET=^/ping$")).To(Succeed())
+
+		var err error
+		syncer, err = NewRoutesSyncer(options.Upstream{
+			ID:   "app",
+			Path: "/app/",
+			URI:  server.URL + "/base",
+		}, &options.SignatureData{Hash: crypto.SHA256, Key: signingKey}, target, 0)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("adds the published routes", func() {
+		publish(`"GET=^/app/public/", "^/app/assets/"`)
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "GET=^/app/public/", "^/app/assets/"))
+	})
+
+	It("removes routes that are no longer published", func() {
+		publish(`"GET=^/app/public/", "^/app/assets/"`)
+		Expect(syncer.Sync()).To(Succeed())
+
+		publish(`"^/app/assets/"`)
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "^/app/assets/"))
+	})
+
+	It("does not remove routes it did not add", func() {
+		Expect(target.AddRoute("^/app/health$")).To(Succeed())
+		publish(`"^/app/health$"`)
+		Expect(syncer.Sync()).To(Succeed())
+
+		publish(``)
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "^/app/health$"))
+	})
+
+	It("normalizes the published routes", func() {
+		publish(`"get=^/app/public/", "=^/app/assets/"`)
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "GET=^/app/public/", "^/app/assets/"))
+	})
+
+	It("ignores routes outside of the upstream path", func() {
+		publish(`"GET=^/admin/", "/app/unanchored", "^/app/|^/admin/", "^/app/(public|private)/"`)
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "^/app/(public|private)/"))
+	})
+
+	It("compares routes with the upstream path at path segment boundaries", func() {
+		var err error
+		syncer, err = NewRoutesSyncer(options.Upstream{
+			ID:   "app",
+			Path: "/app",
+			URI:  server.URL,
+		}, &options.SignatureData{Hash: crypto.SHA256, Key: signingKey}, target, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		publish(`"^/apple.*", "^/app", "^/app/(a|b)", "GET=^/app$"`)
+		Expect(syncer.Sync()).To(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "^/app/(a|b)", "GET=^/app$"))
+	})
+
+	It("rejects routes with an invalid signature", func() {
+		publish(`"GET=^/app/public/"`)
+		signature = sign("other-secret", body)
+		Expect(syncer.Sync()).To(MatchError("published routes signature does not match"))
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$"))
+	})
+
+	It("rejects routes without a signature", func() {
+		publish(`"GET=^/app/public/"`)
+		signature = ""
+		Expect(syncer.Sync()).To(MatchError("missing or invalid Oauth2-Proxy-Routes-Signature header"))
+	})
+
+	It("keeps the previous routes when the upstream is unavailable", func() {
+		publish(`"GET=^/app/public/"`)
+		Expect(syncer.Sync()).To(Succeed())
+
+		server.Close()
+		Expect(syncer.Sync()).ToNot(Succeed())
+		Expect(target.Entries()).To(ConsistOf("GET=^/ping$", "GET=^/app/public/"))
+	})
+
+	It("requires a signature key", func() {
+		_, err := NewRoutesSyncer(options.Upstream{ID: "app", Path: "/app/", URI: server.URL}, nil, target, 0)
+		Expect(err).To(MatchError("upstream \"app\" publishes routes, but no signature key is set"))
+	})
+})
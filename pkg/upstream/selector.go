@@ -0,0 +1,34 @@
+package upstream
+
+import (
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// Selector finds the upstream a request is routed to, matching the request
+// path against the upstream paths in the same way as the upstream proxy.
+type Selector struct {
+	serveMux *http.ServeMux
+	ids      map[string]string
+}
+
+// NewSelector creates a Selector for the given upstreams
+func NewSelector(upstreams options.Upstreams) *Selector {
+	s := &Selector{
+		serveMux: http.NewServeMux(),
+		ids:      map[string]string{},
+	}
+	for _, upstream := range upstreams {
+		s.serveMux.Handle(upstream.Path, http.NotFoundHandler())
+		s.ids[upstream.Path] = upstream.ID
+	}
+	return s
+}
+
+// UpstreamID returns the ID of the upstream the request is routed to, or an
+// empty string if no upstream path matches the request
+func (s *Selector) UpstreamID(req *http.Request) string {
+	_, pattern := s.serveMux.Handler(req)
+	return s.ids[pattern]
+}
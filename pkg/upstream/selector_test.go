@@ -0,0 +1,35 @@
+package upstream
+
+import (
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Selector", func() {
+	selector := NewSelector(options.Upstreams{
+		{ID: "root", Path: "/"},
+		{ID: "api", Path: "/api/"},
+		{ID: "health", Path: "/health"},
+	})
+
+	DescribeTable("UpstreamID",
+		func(path, expected string) {
+			req := httptest.NewRequest("GET", path, nil)
+			Expect(selector.UpstreamID(req)).To(Equal(expected))
+		},
+		Entry("the root upstream", "/index.html", "root"),
+		Entry("a subtree upstream", "/api/users", "api"),
+		Entry("an exact path upstream", "/health", "health"),
+		Entry("below an exact path upstream", "/health/live", "root"),
+	)
+
+	It("returns no upstream when no path matches", func() {
+		selector := NewSelector(options.Upstreams{{ID: "api", Path: "/api/"}})
+		req := httptest.NewRequest("GET", "/other", nil)
+		Expect(selector.UpstreamID(req)).To(BeEmpty())
+	})
+})
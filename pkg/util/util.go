@@ -4,8 +4,23 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"strings"
 )
 
+// descriptionSeparator separates an optional human readable description from
+// a configured route or rule, e.g. "POST=^/webhooks/stripe$ # Stripe webhook"
+const descriptionSeparator = " # "
+
+// SplitDescription splits an entry into its value and its optional
+// description
+func SplitDescription(entry string) (string, string) {
+	parts := strings.SplitN(entry, descriptionSeparator, 2)
+	if len(parts) == 1 {
+		return entry, ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
 func GetCertPool(paths []string) (*x509.CertPool, error) {
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("invalid empty list of Root CAs file paths")
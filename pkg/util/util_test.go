@@ -95,3 +95,13 @@ func TestGetCertPool(t *testing.T) {
 	expectedSubjects := []string{testCA1Subj, testCA2Subj}
 	assert.Equal(t, expectedSubjects, got)
 }
+
+func TestSplitDescription(t *testing.T) {
+	value, description := SplitDescription("POST=^/webhooks/stripe$ # exempt Stripe webhook")
+	assert.Equal(t, "POST=^/webhooks/stripe$", value)
+	assert.Equal(t, "exempt Stripe webhook", description)
+
+	value, description = SplitDescription("GET=^/path#fragment$")
+	assert.Equal(t, "GET=^/path#fragment$", value)
+	assert.Equal(t, "", description)
+}
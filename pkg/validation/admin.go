@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/middleware"
+)
+
+// validateAdminAddress ensures the admin API is only served on a unix socket
+// or a loopback address, as it is not exposed through the proxy
+func validateAdminAddress(o *options.Options) []string {
+	if o.AdminAddress == "" || strings.HasPrefix(o.AdminAddress, "unix://") {
+		return []string{}
+	}
+
+	host, _, err := net.SplitHostPort(strings.TrimPrefix(o.AdminAddress, "http://"))
+	if err != nil {
+		return []string{fmt.Sprintf("invalid admin_address (%s): %v", o.AdminAddress, err)}
+	}
+
+	if host == "localhost" {
+		return []string{}
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return []string{fmt.Sprintf("admin_address (%s) must be a loopback address or unix socket", o.AdminAddress)}
+	}
+	return []string{}
+}
+
+// validateAdminTokenFile ensures the admin API cannot be served without
+// authentication
+func validateAdminTokenFile(o *options.Options) []string {
+	if o.AdminAddress != "" && o.AdminTokenFile == "" {
+		return []string{"admin_token_file is required when admin_address is set"}
+	}
+	return []string{}
+}
+
+// validateAdminGRPC ensures the gRPC admin service is only served with
+// mutual TLS
+func validateAdminGRPC(o *options.Options) []string {
+	if o.AdminGRPCAddress == "" {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if _, _, err := net.SplitHostPort(o.AdminGRPCAddress); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid admin_grpc_address (%s): %v", o.AdminGRPCAddress, err))
+	}
+	if o.AdminGRPCTLSCertFile == "" || o.AdminGRPCTLSKeyFile == "" {
+		msgs = append(msgs, "admin_grpc_tls_cert_file and admin_grpc_tls_key_file are required when admin_grpc_address is set")
+	}
+	if o.AdminGRPCClientCAFile == "" {
+		msgs = append(msgs, "admin_grpc_client_ca_file is required when admin_grpc_address is set")
+	}
+	return msgs
+}
+
+// validateMetricsRouteTemplates ensures the route templates request metrics
+// are labelled with can be parsed
+func validateMetricsRouteTemplates(o *options.Options) []string {
+	msgs := []string{}
+	for _, template := range o.MetricsRouteTemplates {
+		if _, err := middleware.ParseRouteTemplate(template); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid metrics_route_templates: %v", err))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,90 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin", func() {
+	DescribeTable("validateAdminAddress",
+		func(address string, errStrings []string) {
+			opts := &options.Options{
+				AdminAddress: address,
+			}
+			Expect(validateAdminAddress(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("No admin address", "", []string{}),
+		Entry("Unix socket", "unix:///var/run/oauth2-proxy-admin.sock", []string{}),
+		Entry("IPv4 loopback", "127.0.0.1:4181", []string{}),
+		Entry("IPv6 loopback", "[::1]:4181", []string{}),
+		Entry("localhost", "http://localhost:4181", []string{}),
+		Entry("All interfaces", ":4181", []string{
+			"admin_address (:4181) must be a loopback address or unix socket",
+		}),
+		Entry("Non-loopback address", "10.0.0.1:4181", []string{
+			"admin_address (10.0.0.1:4181) must be a loopback address or unix socket",
+		}),
+		Entry("Missing port", "127.0.0.1", []string{
+			"invalid admin_address (127.0.0.1): address 127.0.0.1: missing port in address",
+		}),
+	)
+
+	DescribeTable("validateAdminTokenFile",
+		func(address, tokenFile string, errStrings []string) {
+			opts := &options.Options{
+				AdminAddress:   address,
+				AdminTokenFile: tokenFile,
+			}
+			Expect(validateAdminTokenFile(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("No admin address", "", "", []string{}),
+		Entry("Admin address with token file", "127.0.0.1:4181", "/etc/oauth2-proxy/admin-token", []string{}),
+		Entry("Admin address without token file", "127.0.0.1:4181", "", []string{
+			"admin_token_file is required when admin_address is set",
+		}),
+	)
+
+	DescribeTable("validateAdminGRPC",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateAdminGRPC(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("No gRPC admin address", &options.Options{}, []string{}),
+		Entry("gRPC admin address with mutual TLS", &options.Options{
+			AdminGRPCAddress:      ":4182",
+			AdminGRPCTLSCertFile:  "/etc/oauth2-proxy/admin.crt",
+			AdminGRPCTLSKeyFile:   "/etc/oauth2-proxy/admin.key",
+			AdminGRPCClientCAFile: "/etc/oauth2-proxy/admin-clients.crt",
+		}, []string{}),
+		Entry("gRPC admin address without TLS", &options.Options{
+			AdminGRPCAddress: "127.0.0.1:4182",
+		}, []string{
+			"admin_grpc_tls_cert_file and admin_grpc_tls_key_file are required when admin_grpc_address is set",
+			"admin_grpc_client_ca_file is required when admin_grpc_address is set",
+		}),
+		Entry("gRPC admin address without a port", &options.Options{
+			AdminGRPCAddress:      "127.0.0.1",
+			AdminGRPCTLSCertFile:  "/etc/oauth2-proxy/admin.crt",
+			AdminGRPCTLSKeyFile:   "/etc/oauth2-proxy/admin.key",
+			AdminGRPCClientCAFile: "/etc/oauth2-proxy/admin-clients.crt",
+		}, []string{
+			"invalid admin_grpc_address (127.0.0.1): address 127.0.0.1: missing port in address",
+		}),
+	)
+
+	DescribeTable("validateMetricsRouteTemplates",
+		func(templates []string, errStrings []string) {
+			opts := &options.Options{
+				MetricsRouteTemplates: templates,
+			}
+			Expect(validateMetricsRouteTemplates(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("No templates", []string{}, []string{}),
+		Entry("Valid templates", []string{"/api/users/{id}", "/health"}, []string{}),
+		Entry("Invalid templates", []string{"api/users", "/api/users/{id}", "/api/user-{id}"}, []string{
+			`invalid metrics_route_templates: route template "api/users" must start with /`,
+			`invalid metrics_route_templates: route template "/api/user-{id}" has an invalid parameter "user-{id}": parameters must be whole segments such as {id}`,
+		}),
+	)
+})
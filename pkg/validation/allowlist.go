@@ -7,7 +7,11 @@ import (
 	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cloudmetadata"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
 )
 
 func validateAllowlists(o *options.Options) []string {
@@ -16,22 +20,33 @@ func validateAllowlists(o *options.Options) []string {
 	msgs = append(msgs, validateRoutes(o)...)
 	msgs = append(msgs, validateRegexes(o)...)
 	msgs = append(msgs, validateTrustedIPs(o)...)
+	msgs = append(msgs, validateTrustedIPCloudProvider(o)...)
+	msgs = append(msgs, validateUserAgents(o)...)
+	msgs = append(msgs, validateTrustedRequests(o)...)
 
-	if len(o.TrustedIPs) > 0 && o.ReverseProxy {
+	if (len(o.TrustedIPs) > 0 || o.TrustedIPCloudProvider != "") && o.ReverseProxy {
 		_, err := fmt.Fprintln(os.Stderr, "WARNING: mixing --trusted-ip with --reverse-proxy is a potential security vulnerability. An attacker can inject a trusted IP into an X-Real-IP or X-Forwarded-For header if they aren't properly protected outside of oauth2-proxy")
 		if err != nil {
 			panic(err)
 		}
 	}
 
+	if len(o.SkipAuthUserAgents) > 0 && len(o.SkipAuthUserAgentIPs) == 0 {
+		_, err := fmt.Fprintln(os.Stderr, "WARNING: --skip-auth-user-agent without --skip-auth-user-agent-ip allows any client to skip authentication by setting a matching User-Agent header")
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	return msgs
 }
 
 // validateRoutes validates method=path routes passed with options.SkipAuthRoutes
 func validateRoutes(o *options.Options) []string {
 	msgs := []string{}
-	for _, route := range o.SkipAuthRoutes {
+	for _, entry := range o.SkipAuthRoutes {
 		var regex string
+		route, _ := util.SplitDescription(entry)
 		parts := strings.SplitN(route, "=", 2)
 		if len(parts) == 1 {
 			regex = parts[0]
@@ -41,7 +56,9 @@ func validateRoutes(o *options.Options) []string {
 		_, err := regexp.Compile(regex)
 		if err != nil {
 			msgs = append(msgs, fmt.Sprintf("error compiling regex /%s/: %v", regex, err))
+			continue
 		}
+		msgs = append(msgs, validateRegexAnchoring(o, regex)...)
 	}
 	return msgs
 }
@@ -53,11 +70,34 @@ func validateRegexes(o *options.Options) []string {
 		_, err := regexp.Compile(regex)
 		if err != nil {
 			msgs = append(msgs, fmt.Sprintf("error compiling regex /%s/: %v", regex, err))
+			continue
 		}
+		msgs = append(msgs, validateRegexAnchoring(o, regex)...)
 	}
 	return msgs
 }
 
+// validateRegexAnchoring checks that a path regex is anchored with ^ and $,
+// as an unanchored regex also matches any path containing the intended path.
+// An unanchored regex is logged as a warning, or rejected when
+// options.StrictRegex is set.
+func validateRegexAnchoring(o *options.Options, regex string) []string {
+	anchored, examples, err := authorization.CheckPathAnchoring(regex)
+	if err != nil || anchored {
+		return []string{}
+	}
+
+	msg := fmt.Sprintf("regex /%s/ is not fully anchored with ^ and $", regex)
+	if len(examples) > 0 {
+		msg = fmt.Sprintf("%s and also matches paths such as %s", msg, strings.Join(examples, ", "))
+	}
+	if o.StrictRegex {
+		return []string{msg}
+	}
+	logger.Printf("WARNING: %s", msg)
+	return []string{}
+}
+
 // validateTrustedIPs validates IP/CIDRs for IP based allowlists
 func validateTrustedIPs(o *options.Options) []string {
 	msgs := []string{}
@@ -68,3 +108,68 @@ func validateTrustedIPs(o *options.Options) []string {
 	}
 	return msgs
 }
+
+// validateTrustedIPCloudProvider validates the cloud provider that trusted
+// IPs are discovered from
+func validateTrustedIPCloudProvider(o *options.Options) []string {
+	msgs := []string{}
+	if o.TrustedIPCloudProvider == "" {
+		return msgs
+	}
+	if _, err := cloudmetadata.NewProvider(o.TrustedIPCloudProvider); err != nil {
+		msgs = append(msgs, fmt.Sprintf("trusted_ip_cloud_provider: %v", err))
+	}
+	if o.TrustedIPCloudRefresh <= 0 {
+		msgs = append(msgs, "trusted_ip_cloud_refresh must be greater than 0")
+	}
+	return msgs
+}
+
+// validateUserAgents validates User-Agent regexes and the IP/CIDRs they are
+// restricted to
+func validateUserAgents(o *options.Options) []string {
+	msgs := []string{}
+	for _, regex := range o.SkipAuthUserAgents {
+		_, err := regexp.Compile(regex)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("error compiling regex /%s/: %v", regex, err))
+		}
+	}
+	for i, ipStr := range o.SkipAuthUserAgentIPs {
+		if nil == ip.ParseIPNet(ipStr) {
+			msgs = append(msgs, fmt.Sprintf("skip_auth_user_agent_ips[%d] (%s) could not be recognized", i, ipStr))
+		}
+	}
+	if len(o.SkipAuthUserAgentIPs) > 0 && len(o.SkipAuthUserAgents) == 0 {
+		msgs = append(msgs, "skip_auth_user_agent_ips requires skip_auth_user_agents to be set")
+	}
+	return msgs
+}
+
+// validateTrustedRequests validates that each TrustedRequest has valid routes
+// and IP/CIDRs, and sets both so that neither can be trusted on its own
+func validateTrustedRequests(o *options.Options) []string {
+	msgs := []string{}
+	for i, trustedRequest := range o.TrustedRequests {
+		if len(trustedRequest.Routes) == 0 {
+			msgs = append(msgs, fmt.Sprintf("trustedRequests[%d] must have at least one route", i))
+		}
+		if len(trustedRequest.IPs) == 0 {
+			msgs = append(msgs, fmt.Sprintf("trustedRequests[%d] must have at least one IP", i))
+		}
+
+		for _, route := range trustedRequest.Routes {
+			parts := strings.SplitN(route, "=", 2)
+			regex := parts[len(parts)-1]
+			if _, err := regexp.Compile(regex); err != nil {
+				msgs = append(msgs, fmt.Sprintf("trustedRequests[%d]: error compiling regex /%s/: %v", i, regex, err))
+			}
+		}
+		for j, ipStr := range trustedRequest.IPs {
+			if nil == ip.ParseIPNet(ipStr) {
+				msgs = append(msgs, fmt.Sprintf("trustedRequests[%d].ips[%d] (%s) could not be recognized", i, j, ipStr))
+			}
+		}
+	}
+	return msgs
+}
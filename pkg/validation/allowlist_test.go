@@ -1,6 +1,8 @@
 package validation
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -11,11 +13,13 @@ import (
 var _ = Describe("Allowlist", func() {
 	type validateRoutesTableInput struct {
 		routes     []string
+		strict     bool
 		errStrings []string
 	}
 
 	type validateRegexesTableInput struct {
 		regexes    []string
+		strict     bool
 		errStrings []string
 	}
 
@@ -28,6 +32,7 @@ var _ = Describe("Allowlist", func() {
 		func(r *validateRoutesTableInput) {
 			opts := &options.Options{
 				SkipAuthRoutes: r.routes,
+				StrictRegex:    r.strict,
 			}
 			Expect(validateRoutes(opts)).To(ConsistOf(r.errStrings))
 		},
@@ -54,12 +59,35 @@ var _ = Describe("Allowlist", func() {
 				"error compiling regex /^]/foo/bar[$/: error parsing regexp: missing closing ]: `[$`",
 			},
 		}),
+		Entry("Anchored regexes are accepted with strict regexes", &validateRoutesTableInput{
+			routes: []string{
+				"GET=^/foo$",
+				"POST=^/foo/bar/.*",
+				"^/ping$|^/health$",
+			},
+			strict:     true,
+			errStrings: []string{},
+		}),
+		Entry("Unanchored regexes are rejected with strict regexes", &validateRoutesTableInput{
+			routes: []string{
+				"/foo",
+				"POST=^/foo/bar",
+				"PUT=/foo/bar$ # description",
+			},
+			strict: true,
+			errStrings: []string{
+				"regex //foo/ is not fully anchored with ^ and $ and also matches paths such as /unexpected/foo, /foo/unexpected",
+				"regex /^/foo/bar/ is not fully anchored with ^ and $ and also matches paths such as /foo/bar/unexpected",
+				"regex //foo/bar$/ is not fully anchored with ^ and $ and also matches paths such as /unexpected/foo/bar",
+			},
+		}),
 	)
 
 	DescribeTable("validateRegexes",
 		func(r *validateRegexesTableInput) {
 			opts := &options.Options{
 				SkipAuthRegex: r.regexes,
+				StrictRegex:   r.strict,
 			}
 			Expect(validateRegexes(opts)).To(ConsistOf(r.errStrings))
 		},
@@ -86,6 +114,16 @@ var _ = Describe("Allowlist", func() {
 				"error compiling regex /^]/foo/bar[$/: error parsing regexp: missing closing ]: `[$`",
 			},
 		}),
+		Entry("Unanchored regexes are rejected with strict regexes", &validateRegexesTableInput{
+			regexes: []string{
+				"^/foo$",
+				"/foo/bar",
+			},
+			strict: true,
+			errStrings: []string{
+				"regex //foo/bar/ is not fully anchored with ^ and $ and also matches paths such as /unexpected/foo/bar, /foo/bar/unexpected",
+			},
+		}),
 	)
 
 	DescribeTable("validateTrustedIPs",
@@ -122,4 +160,111 @@ var _ = Describe("Allowlist", func() {
 			},
 		}),
 	)
+
+	DescribeTable("validateTrustedIPCloudProvider",
+		func(provider string, refresh time.Duration, errStrings []string) {
+			opts := &options.Options{
+				TrustedIPCloudProvider: provider,
+				TrustedIPCloudRefresh:  refresh,
+			}
+			Expect(validateTrustedIPCloudProvider(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("No provider", "", time.Duration(0), []string{}),
+		Entry("Valid provider", "gcp", time.Minute, []string{}),
+		Entry("Unknown provider", "openstack", time.Minute, []string{
+			"trusted_ip_cloud_provider: unknown cloud provider \"openstack\"",
+		}),
+		Entry("Invalid refresh interval", "aws", time.Duration(0), []string{
+			"trusted_ip_cloud_refresh must be greater than 0",
+		}),
+	)
+
+	type validateUserAgentsTableInput struct {
+		userAgents []string
+		ips        []string
+		errStrings []string
+	}
+
+	DescribeTable("validateUserAgents",
+		func(u *validateUserAgentsTableInput) {
+			opts := &options.Options{
+				SkipAuthUserAgents:   u.userAgents,
+				SkipAuthUserAgentIPs: u.ips,
+			}
+			Expect(validateUserAgents(opts)).To(ConsistOf(u.errStrings))
+		},
+		Entry("Valid User-Agents and IPs", &validateUserAgentsTableInput{
+			userAgents: []string{"^kube-probe/.*", "^Pingdom.*"},
+			ips:        []string{"10.0.0.0/8", "::1"},
+			errStrings: []string{},
+		}),
+		Entry("Bad regexes do not compile", &validateUserAgentsTableInput{
+			userAgents: []string{"^kube-probe/(.*"},
+			errStrings: []string{
+				"error compiling regex /^kube-probe/(.*/: error parsing regexp: missing closing ): `^kube-probe/(.*`",
+			},
+		}),
+		Entry("Invalid IPs", &validateUserAgentsTableInput{
+			userAgents: []string{"^kube-probe/.*"},
+			ips:        []string{"alkwlkbn/32"},
+			errStrings: []string{
+				"skip_auth_user_agent_ips[0] (alkwlkbn/32) could not be recognized",
+			},
+		}),
+		Entry("IPs without User-Agents", &validateUserAgentsTableInput{
+			ips: []string{"10.0.0.0/8"},
+			errStrings: []string{
+				"skip_auth_user_agent_ips requires skip_auth_user_agents to be set",
+			},
+		}),
+	)
+
+	type validateTrustedRequestsTableInput struct {
+		trustedRequests []options.TrustedRequest
+		errStrings      []string
+	}
+
+	DescribeTable("validateTrustedRequests",
+		func(t *validateTrustedRequestsTableInput) {
+			opts := &options.Options{
+				TrustedRequests: t.trustedRequests,
+			}
+			Expect(validateTrustedRequests(opts)).To(ConsistOf(t.errStrings))
+		},
+		Entry("Valid routes and IPs", &validateTrustedRequestsTableInput{
+			trustedRequests: []options.TrustedRequest{
+				{
+					Routes: []string{"POST=^/internal/.*", "^/metrics$"},
+					IPs:    []string{"10.0.0.0/8", "::1"},
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("Missing routes and IPs", &validateTrustedRequestsTableInput{
+			trustedRequests: []options.TrustedRequest{
+				{
+					IPs: []string{"10.0.0.0/8"},
+				},
+				{
+					Routes: []string{"POST=^/internal/.*"},
+				},
+			},
+			errStrings: []string{
+				"trustedRequests[0] must have at least one route",
+				"trustedRequests[1] must have at least one IP",
+			},
+		}),
+		Entry("Bad regexes and invalid IPs", &validateTrustedRequestsTableInput{
+			trustedRequests: []options.TrustedRequest{
+				{
+					Routes: []string{"POST=^/internal/(.*"},
+					IPs:    []string{"10.0.0.0/8", "alkwlkbn/32"},
+				},
+			},
+			errStrings: []string{
+				"trustedRequests[0]: error compiling regex /^/internal/(.*/: error parsing regexp: missing closing ): `^/internal/(.*`",
+				"trustedRequests[0].ips[1] (alkwlkbn/32) could not be recognized",
+			},
+		}),
+	)
 })
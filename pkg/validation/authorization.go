@@ -0,0 +1,469 @@
+package validation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
+)
+
+// defaultDenyAction is the key of the action for deny rules without their
+// own action
+const defaultDenyAction = "*"
+
+// denyRuleSetFile is the format of the files loaded by options.DenyRuleSets
+type denyRuleSetFile struct {
+	DenyRoutes      []string            `json:"deny_routes"`
+	DenyIPs         []string            `json:"deny_ips"`
+	DenyWebhooks    []string            `json:"deny_webhooks"`
+	DenyOPAPolicies []string            `json:"deny_opa_policies"`
+	DenyExpressions []string            `json:"deny_expressions"`
+	DenyActions     map[string]string   `json:"deny_actions"`
+	DenyShadowRules []string            `json:"deny_shadow_rules"`
+	DenyUpstreams   map[string][]string `json:"deny_upstreams"`
+
+	// DenyIPFeeds are only set for the default rule set, as the feeds are
+	// synced in the background
+	DenyIPFeeds []string `json:"-"`
+}
+
+// validateAuthorizationRules builds the default deny rule set from
+// options.DenyRoutes, options.DenyIPs and options.DenyExpressions, and any
+// named rule sets from options.DenyRuleSets, and sets the resulting rule sets
+// on the options.
+// It must be called after the real client IP parser has been configured.
+func validateAuthorizationRules(o *options.Options) []string {
+	msgs := []string{}
+	sets := map[string]*authorization.RulesEngine{}
+
+	if o.DenyRulesCacheSize < 0 {
+		msgs = append(msgs, fmt.Sprintf("deny_rules_cache_size (%d) must not be negative", o.DenyRulesCacheSize))
+	}
+	msgs = append(msgs, validateDenyRulesOptimization(o)...)
+
+	actions, actionMsgs := parseDenyActions(o.DenyActions)
+	msgs = append(msgs, actionMsgs...)
+	upstreams, upstreamMsgs := parseDenyUpstreams(o.DenyUpstreams)
+	msgs = append(msgs, upstreamMsgs...)
+	engine, engineMsgs := buildDenyRulesEngine(o, &denyRuleSetFile{
+		DenyRoutes:      o.DenyRoutes,
+		DenyIPs:         o.DenyIPs,
+		DenyWebhooks:    o.DenyWebhooks,
+		DenyOPAPolicies: o.DenyOPAPolicies,
+		DenyExpressions: o.DenyExpressions,
+		DenyActions:     actions,
+		DenyShadowRules: o.DenyShadowRules,
+		DenyUpstreams:   upstreams,
+		DenyIPFeeds:     o.DenyIPFeeds,
+	})
+	msgs = append(msgs, engineMsgs...)
+	sets[authorization.DefaultRuleSet] = engine
+	syncers, feedMsgs := newIPFeedSyncers(o, engine)
+	msgs = append(msgs, feedMsgs...)
+
+	for i, ruleSet := range o.DenyRuleSets {
+		name, engine, err := loadDenyRuleSet(o, ruleSet)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_rule_sets[%d]: %v", i, err))
+			continue
+		}
+		if _, ok := sets[name]; ok {
+			msgs = append(msgs, fmt.Sprintf("deny_rule_sets[%d]: rule set %q is defined more than once", i, name))
+			continue
+		}
+		sets[name] = engine
+	}
+
+	for i, ruleSetURL := range o.DenyRuleSetURLs {
+		name, engine, syncer, err := newDenyRuleSetSyncer(o, ruleSetURL)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_rule_set_urls[%d]: %v", i, err))
+			continue
+		}
+		if _, ok := sets[name]; ok {
+			msgs = append(msgs, fmt.Sprintf("deny_rule_set_urls[%d]: rule set %q is defined more than once", i, name))
+			continue
+		}
+		sets[name] = engine
+		syncers = append(syncers, syncer)
+	}
+
+	active := o.ActiveDenyRuleSet
+	if active == "" {
+		active = authorization.DefaultRuleSet
+	}
+	if _, ok := sets[active]; !ok {
+		msgs = append(msgs, fmt.Sprintf("active_deny_rule_set %q is not a deny rule set", active))
+	}
+
+	if len(msgs) == 0 {
+		ruleSets, err := authorization.NewRuleSets(active, sets)
+		if err != nil {
+			return append(msgs, err.Error())
+		}
+		o.SetAuthorizationRules(ruleSets)
+		o.SetRuleSyncers(syncers)
+	}
+	return msgs
+}
+
+// loadDenyRuleSet loads a rule set in the format name=path
+func loadDenyRuleSet(o *options.Options, ruleSet string) (string, *authorization.RulesEngine, error) {
+	parts := strings.SplitN(ruleSet, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, fmt.Errorf("invalid rule set %q, expected name=path", ruleSet)
+	}
+	name, path := parts[0], parts[1]
+	if name == authorization.DefaultRuleSet {
+		return "", nil, fmt.Errorf("rule set name %q is reserved for --deny-route and --deny-ip", name)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read rule set %q: %v", name, err)
+	}
+	rules, err := parseDenyRuleSet(o, name, contents)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, newDenyRulesEngine(o, rules), nil
+}
+
+// newDenyRuleSetSyncer creates the engine of a rule set in the format name=url
+// and a syncer that replaces its rules with the fetched rule set.
+// The engine has no rules until the rule set is first fetched.
+func newDenyRuleSetSyncer(o *options.Options, ruleSetURL string) (string, *authorization.RulesEngine, *authorization.BundleSyncer, error) {
+	parts := strings.SplitN(ruleSetURL, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, nil, fmt.Errorf("invalid rule set %q, expected name=url", ruleSetURL)
+	}
+	name, bundleURL := parts[0], parts[1]
+	if name == authorization.DefaultRuleSet {
+		return "", nil, nil, fmt.Errorf("rule set name %q is reserved for --deny-route and --deny-ip", name)
+	}
+	sigData := o.GetSignatureData()
+	if sigData == nil {
+		return "", nil, nil, fmt.Errorf("rule set %q is fetched from %s, but no signature key is set", name, bundleURL)
+	}
+
+	engine := newDenyRulesEngine(o, nil)
+	parse := func(contents []byte) ([]*authorization.Rule, error) {
+		return parseDenyRuleSet(o, name, contents)
+	}
+	syncer, err := authorization.NewBundleSyncer(name, bundleURL, sigData.Hash, sigData.Key, engine, parse, o.DenyRuleSetRefresh)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return name, engine, syncer, nil
+}
+
+// parseDenyRuleSet builds the rules of the named rule set from its YAML
+// contents
+func parseDenyRuleSet(o *options.Options, name string, contents []byte) ([]*authorization.Rule, error) {
+	file := &denyRuleSetFile{}
+	if err := yaml.Unmarshal(contents, file); err != nil {
+		return nil, fmt.Errorf("could not parse rule set %q: %v", name, err)
+	}
+
+	rules, msgs := buildDenyRules(o, file)
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("invalid rule set %q: %s", name, strings.Join(msgs, ", "))
+	}
+	return rules, nil
+}
+
+// parseDenyActions parses deny actions in the format rule-id=action
+func parseDenyActions(denyActions []string) (map[string]string, []string) {
+	msgs := []string{}
+	actions := map[string]string{}
+	for i, denyAction := range denyActions {
+		parts := strings.SplitN(denyAction, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			msgs = append(msgs, fmt.Sprintf("deny_actions[%d]: invalid action %q, expected rule-id=action", i, denyAction))
+			continue
+		}
+		actions[parts[0]] = parts[1]
+	}
+	return actions, msgs
+}
+
+// parseDenyUpstreams parses rule upstreams in the format rule-id=upstream-id,
+// returning the upstream IDs keyed by rule ID
+func parseDenyUpstreams(denyUpstreams []string) (map[string][]string, []string) {
+	msgs := []string{}
+	upstreams := map[string][]string{}
+	for i, denyUpstream := range denyUpstreams {
+		parts := strings.SplitN(denyUpstream, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			msgs = append(msgs, fmt.Sprintf("deny_upstreams[%d]: invalid upstream %q, expected rule-id=upstream-id", i, denyUpstream))
+			continue
+		}
+		upstreams[parts[0]] = append(upstreams[parts[0]], parts[1])
+	}
+	return upstreams, msgs
+}
+
+// buildDenyRulesEngine builds a rules engine with the rules of the rule set
+func buildDenyRulesEngine(o *options.Options, file *denyRuleSetFile) (*authorization.RulesEngine, []string) {
+	rules, msgs := buildDenyRules(o, file)
+	return newDenyRulesEngine(o, rules), msgs
+}
+
+// buildDenyRules builds rules denying the routes and IPs, and the
+// authenticated requests matching the expressions or denied by the webhooks
+// and OPA policies.
+// The actions are keyed by rule ID, and the "*" action applies to rules
+// without one.
+func buildDenyRules(o *options.Options, file *denyRuleSetFile) ([]*authorization.Rule, []string) {
+	msgs := []string{}
+	rules := []*authorization.Rule{}
+
+	for i, entry := range file.DenyRoutes {
+		route, description := util.SplitDescription(entry)
+		methods, path := splitRoute(route)
+		rule, err := authorization.NewRule(fmt.Sprintf("deny-route-%d", i), authorization.DenyPolicy, methods, path, nil, nil)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_routes[%d]: %v", i, err))
+			continue
+		}
+		rule.Description = description
+		rules = append(rules, rule)
+	}
+
+	for i, entry := range file.DenyExpressions {
+		source, description := util.SplitDescription(entry)
+		expression, err := authorization.NewExpression(source)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_expressions[%d]: %v", i, err))
+			continue
+		}
+		rules = append(rules, &authorization.Rule{
+			ID:          fmt.Sprintf("deny-expression-%d", i),
+			Description: description,
+			Policy:      authorization.DenyPolicy,
+			Expression:  expression,
+		})
+	}
+
+	for i, entry := range file.DenyOPAPolicies {
+		path, description := util.SplitDescription(entry)
+		policy, err := authorization.NewOPAPolicy(path)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_opa_policies[%d]: %v", i, err))
+			continue
+		}
+		rules = append(rules, &authorization.Rule{
+			ID:          fmt.Sprintf("deny-opa-%d", i),
+			Description: description,
+			Policy:      authorization.DenyPolicy,
+			OPAPolicy:   policy,
+		})
+	}
+
+	for i, entry := range file.DenyWebhooks {
+		webhookURL, description := util.SplitDescription(entry)
+		webhook, err := authorization.NewWebhook(webhookURL, o.DenyWebhookTimeout, o.DenyWebhookFailOpen, o.DenyWebhookCacheTTL)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_webhooks[%d]: %v", i, err))
+			continue
+		}
+		rules = append(rules, &authorization.Rule{
+			ID:          fmt.Sprintf("deny-webhook-%d", i),
+			Description: description,
+			Policy:      authorization.DenyPolicy,
+			Webhook:     webhook,
+		})
+	}
+
+	for i, entry := range file.DenyIPFeeds {
+		_, description := util.SplitDescription(entry)
+		rule := authorization.NewIPFeedRule(fmt.Sprintf("deny-ip-feed-%d", i))
+		rule.Description = description
+		rules = append(rules, rule)
+	}
+
+	for i, entry := range file.DenyIPs {
+		ipStr, description := util.SplitDescription(entry)
+		rule, err := authorization.NewRule(fmt.Sprintf("deny-ip-%d", i), authorization.DenyPolicy, nil, "", nil, []string{ipStr})
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_ips[%d] (%s) could not be recognized", i, ipStr))
+			continue
+		}
+		rule.Description = description
+		rules = append(rules, rule)
+	}
+
+	msgs = append(msgs, setDenyActions(rules, file.DenyActions)...)
+	msgs = append(msgs, setShadowRules(rules, file.DenyShadowRules)...)
+	msgs = append(msgs, setRuleUpstreams(o, rules, file.DenyUpstreams)...)
+	return rules, msgs
+}
+
+// newIPFeedSyncers creates a syncer for the rule of each IP feed in the
+// default rule set.
+// Feeds from local files are loaded immediately, so that their rules are
+// enforced from startup.
+func newIPFeedSyncers(o *options.Options, engine *authorization.RulesEngine) ([]authorization.Syncer, []string) {
+	byID := map[string]*authorization.Rule{}
+	for _, rule := range engine.Rules() {
+		byID[rule.ID] = rule
+	}
+
+	msgs := []string{}
+	syncers := []authorization.Syncer{}
+	for i, entry := range o.DenyIPFeeds {
+		source, _ := util.SplitDescription(entry)
+		syncer := authorization.NewIPFeedSyncer(source, engine, byID[fmt.Sprintf("deny-ip-feed-%d", i)], o.DenyIPFeedRefresh)
+		if !syncer.IsURL() {
+			if err := syncer.Sync(); err != nil {
+				msgs = append(msgs, fmt.Sprintf("deny_ip_feeds[%d]: %v", i, err))
+				continue
+			}
+		}
+		syncers = append(syncers, syncer)
+	}
+	return syncers, msgs
+}
+
+// validateDenyRulesOptimization checks the index options, and that rules are
+// not reordered when optimization is disabled for reproducible evaluation
+func validateDenyRulesOptimization(o *options.Options) []string {
+	msgs := []string{}
+	known := map[string]bool{}
+	for _, name := range authorization.IndexNames {
+		known[name] = true
+	}
+	for i, name := range o.DenyRulesDisabledIndices {
+		if !known[name] {
+			msgs = append(msgs, fmt.Sprintf("deny_rules_disabled_indices[%d]: unknown index %q, expected one of %s",
+				i, name, strings.Join(authorization.IndexNames, ", ")))
+		}
+	}
+	if o.DenyRulesIndexThreshold < 0 {
+		msgs = append(msgs, fmt.Sprintf("deny_rules_index_threshold (%d) must not be negative", o.DenyRulesIndexThreshold))
+	}
+	if o.DenyRulesIndexReorderRate < 0 || o.DenyRulesIndexReorderRate > 1 {
+		msgs = append(msgs, fmt.Sprintf("deny_rules_index_reorder_rate (%v) must be between 0 and 1", o.DenyRulesIndexReorderRate))
+	}
+	if !o.DenyRulesOptimize && o.ReorderDenyRules {
+		msgs = append(msgs, "reorder_deny_rules cannot be used when deny_rules_optimize is disabled")
+	}
+	return msgs
+}
+
+// newDenyRulesEngine constructs a rules engine for the rules, with the
+// indexing, hit reordering and result cache configured by the options
+func newDenyRulesEngine(o *options.Options, rules []*authorization.Rule) *authorization.RulesEngine {
+	engine := authorization.NewRulesEngine(rules, o.GetRealClientIPParser())
+	engine.SetOptions(authorization.EngineOptions{
+		OptimizeThreshold: o.DenyRulesIndexThreshold,
+		ReorderSampleRate: o.DenyRulesIndexReorderRate,
+	})
+	if o.ReorderDenyRules {
+		engine.EnableHitReordering()
+	}
+	if o.DenyRulesCacheSize > 0 {
+		engine.EnableResultCache(o.DenyRulesCacheSize)
+	}
+	if !o.DenyRulesOptimize {
+		engine.DisableOptimization()
+	}
+	if len(o.DenyRulesDisabledIndices) > 0 {
+		engine.DisableIndices(o.DenyRulesDisabledIndices)
+	}
+	return engine
+}
+
+// setDenyActions sets the action of each rule from the actions keyed by rule
+// ID, or the "*" action
+func setDenyActions(rules []*authorization.Rule, actions map[string]string) []string {
+	msgs := []string{}
+	ids := map[string]bool{defaultDenyAction: true}
+	for _, rule := range rules {
+		ids[rule.ID] = true
+		name, ok := actions[rule.ID]
+		if !ok {
+			name, ok = actions[defaultDenyAction]
+		}
+		if !ok {
+			continue
+		}
+		action, err := authorization.ParseAction(name)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("deny_actions[%s]: %v", rule.ID, err))
+			continue
+		}
+		rule.Action = action
+	}
+
+	for id := range actions {
+		if !ids[id] {
+			msgs = append(msgs, fmt.Sprintf("deny_actions[%s]: no deny rule has this ID", id))
+		}
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+// setShadowRules marks the rules with the given IDs as shadow rules, which are
+// logged but not enforced
+func setShadowRules(rules []*authorization.Rule, ids []string) []string {
+	msgs := []string{}
+	byID := map[string]*authorization.Rule{}
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+	for i, id := range ids {
+		rule, ok := byID[id]
+		if !ok {
+			msgs = append(msgs, fmt.Sprintf("deny_shadow_rules[%d]: no deny rule has the ID %q", i, id))
+			continue
+		}
+		rule.Shadow = true
+	}
+	return msgs
+}
+
+// setRuleUpstreams scopes the rules to the upstreams keyed by rule ID, which
+// must be the IDs of configured upstreams
+func setRuleUpstreams(o *options.Options, rules []*authorization.Rule, upstreams map[string][]string) []string {
+	msgs := []string{}
+	known := map[string]bool{}
+	for _, upstream := range o.UpstreamServers {
+		known[upstream.ID] = true
+	}
+	byID := map[string]*authorization.Rule{}
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+	for id, upstreamIDs := range upstreams {
+		rule, ok := byID[id]
+		if !ok {
+			msgs = append(msgs, fmt.Sprintf("deny_upstreams[%s]: no deny rule has this ID", id))
+			continue
+		}
+		for _, upstreamID := range upstreamIDs {
+			if !known[upstreamID] {
+				msgs = append(msgs, fmt.Sprintf("deny_upstreams[%s]: no upstream has the ID %q", id, upstreamID))
+			}
+		}
+		rule.Upstreams = upstreamIDs
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+// splitRoute splits a route in the format method=path_regex.
+// If no method is given, the route matches all methods.
+func splitRoute(route string) ([]string, string) {
+	parts := strings.SplitN(route, "=", 2)
+	if len(parts) == 1 || parts[0] == "" {
+		return nil, parts[len(parts)-1]
+	}
+	return []string{parts[0]}, parts[1]
+}
@@ -0,0 +1,445 @@
+package validation
+
+import (
+	"crypto"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/authorization"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authorization", func() {
+	type validateAuthorizationRulesTableInput struct {
+		denyRoutes      []string
+		denyIPs         []string
+		denyWebhooks    []string
+		denyOPAPolicies []string
+		denyExpressions []string
+		denyActions     []string
+		shadowRules     []string
+		denyUpstreams   []string
+		cacheSize       int
+		unoptimized     bool
+		reorder         bool
+		disabledIndices []string
+		indexThreshold  int
+		reorderRate     float64
+		errStrings      []string
+	}
+
+	DescribeTable("validateAuthorizationRules",
+		func(in validateAuthorizationRulesTableInput) {
+			opts := &options.Options{
+				DenyRoutes:         in.denyRoutes,
+				DenyIPs:            in.denyIPs,
+				DenyWebhooks:       in.denyWebhooks,
+				DenyWebhookTimeout: time.Second,
+				DenyOPAPolicies:    in.denyOPAPolicies,
+				DenyExpressions:    in.denyExpressions,
+				DenyActions:        in.denyActions,
+				DenyShadowRules:    in.shadowRules,
+				DenyUpstreams:      in.denyUpstreams,
+				DenyRulesCacheSize: in.cacheSize,
+				DenyRulesOptimize:  !in.unoptimized,
+				ReorderDenyRules:   in.reorder,
+				UpstreamServers:    options.Upstreams{{ID: "api", Path: "/api/"}, {ID: "web", Path: "/"}},
+
+				DenyRulesDisabledIndices:  in.disabledIndices,
+				DenyRulesIndexThreshold:   in.indexThreshold,
+				DenyRulesIndexReorderRate: in.reorderRate,
+			}
+			Expect(validateAuthorizationRules(opts)).To(ConsistOf(in.errStrings))
+
+			if len(in.errStrings) == 0 {
+				Expect(opts.GetAuthorizationRules()).ToNot(BeNil())
+			} else {
+				Expect(opts.GetAuthorizationRules()).To(BeNil())
+			}
+		},
+		Entry("No deny rules", validateAuthorizationRulesTableInput{
+			errStrings: []string{},
+		}),
+		Entry("Valid deny routes and IPs", validateAuthorizationRulesTableInput{
+			denyRoutes: []string{"^/admin/", "POST=^/api/", "=^/with=equals$"},
+			denyIPs:    []string{"192.168.0.1", "10.0.0.0/8", "::1"},
+			errStrings: []string{},
+		}),
+		Entry("Invalid deny route", validateAuthorizationRulesTableInput{
+			denyRoutes: []string{"GET=^/valid$", "POST=(bad[regex"},
+			errStrings: []string{
+				"deny_routes[1]: error compiling path regex /(bad[regex/: error parsing regexp: missing closing ]: `[regex`",
+			},
+		}),
+		Entry("Invalid deny IP", validateAuthorizationRulesTableInput{
+			denyIPs: []string{"10.0.0.0/8", "not-an-ip"},
+			errStrings: []string{
+				"deny_ips[1] (not-an-ip) could not be recognized",
+			},
+		}),
+		Entry("Valid deny webhooks", validateAuthorizationRulesTableInput{
+			denyWebhooks: []string{"http://127.0.0.1:8080/authorize"},
+			denyActions:  []string{"deny-webhook-0=sign_in"},
+			errStrings:   []string{},
+		}),
+		Entry("Invalid deny webhook", validateAuthorizationRulesTableInput{
+			denyWebhooks: []string{"http://127.0.0.1:8080/authorize", "/authorize"},
+			errStrings: []string{
+				"deny_webhooks[1]: webhook URL \"/authorize\" must be an absolute http or https URL",
+			},
+		}),
+		Entry("Missing deny OPA policy", validateAuthorizationRulesTableInput{
+			denyOPAPolicies: []string{"/does/not/exist.rego"},
+			errStrings: []string{
+				"deny_opa_policies[0]: could not read OPA policy /does/not/exist.rego: open /does/not/exist.rego: no such file or directory",
+			},
+		}),
+		Entry("Valid deny expressions", validateAuthorizationRulesTableInput{
+			denyExpressions: []string{"request.path.startsWith('/admin') && !('admins' in session.groups) # Admins only"},
+			denyActions:     []string{"deny-expression-0=json"},
+			errStrings:      []string{},
+		}),
+		Entry("Invalid deny expression", validateAuthorizationRulesTableInput{
+			denyExpressions: []string{"'admins' in session.groups", "request.path.size()"},
+			errStrings: []string{
+				"deny_expressions[1]: CEL expression \"request.path.size()\" must evaluate to a bool",
+			},
+		}),
+		Entry("Valid deny actions", validateAuthorizationRulesTableInput{
+			denyRoutes:  []string{"^/admin/", "^/api/"},
+			denyIPs:     []string{"10.0.0.0/8"},
+			denyActions: []string{"*=json", "deny-route-0=sign_in"},
+			errStrings:  []string{},
+		}),
+		Entry("Invalid deny actions", validateAuthorizationRulesTableInput{
+			denyRoutes:  []string{"^/admin/"},
+			denyActions: []string{"deny-route-0", "deny-route-0=redirect", "deny-ip-0=json"},
+			errStrings: []string{
+				"deny_actions[0]: invalid action \"deny-route-0\", expected rule-id=action",
+				"deny_actions[deny-route-0]: unknown action \"redirect\"",
+				"deny_actions[deny-ip-0]: no deny rule has this ID",
+			},
+		}),
+		Entry("Valid shadow rules", validateAuthorizationRulesTableInput{
+			denyRoutes:  []string{"^/admin/"},
+			denyIPs:     []string{"10.0.0.0/8"},
+			shadowRules: []string{"deny-ip-0"},
+			errStrings:  []string{},
+		}),
+		Entry("Unknown shadow rule", validateAuthorizationRulesTableInput{
+			denyRoutes:  []string{"^/admin/"},
+			shadowRules: []string{"deny-route-0", "deny-route-1"},
+			errStrings: []string{
+				"deny_shadow_rules[1]: no deny rule has the ID \"deny-route-1\"",
+			},
+		}),
+		Entry("Valid deny upstreams", validateAuthorizationRulesTableInput{
+			denyRoutes:    []string{"^/admin/"},
+			denyIPs:       []string{"10.0.0.0/8"},
+			denyUpstreams: []string{"deny-route-0=api", "deny-route-0=web", "deny-ip-0=web"},
+			errStrings:    []string{},
+		}),
+		Entry("Invalid deny upstreams", validateAuthorizationRulesTableInput{
+			denyRoutes:    []string{"^/admin/"},
+			denyUpstreams: []string{"deny-route-0", "deny-route-0=admin", "deny-ip-0=api"},
+			errStrings: []string{
+				"deny_upstreams[0]: invalid upstream \"deny-route-0\", expected rule-id=upstream-id",
+				"deny_upstreams[deny-route-0]: no upstream has the ID \"admin\"",
+				"deny_upstreams[deny-ip-0]: no deny rule has this ID",
+			},
+		}),
+		Entry("Deny rules with a result cache", validateAuthorizationRulesTableInput{
+			denyRoutes: []string{"^/admin/"},
+			cacheSize:  1000,
+			errStrings: []string{},
+		}),
+		Entry("Negative result cache size", validateAuthorizationRulesTableInput{
+			denyRoutes: []string{"^/admin/"},
+			cacheSize:  -1,
+			errStrings: []string{
+				"deny_rules_cache_size (-1) must not be negative",
+			},
+		}),
+		Entry("Deny rules without optimization", validateAuthorizationRulesTableInput{
+			denyRoutes:  []string{"^/admin/"},
+			unoptimized: true,
+			errStrings:  []string{},
+		}),
+		Entry("Deny rules with disabled indices", validateAuthorizationRulesTableInput{
+			denyRoutes:      []string{"^/admin/"},
+			disabledIndices: []string{"path", "headers"},
+			errStrings:      []string{},
+		}),
+		Entry("Unknown disabled index", validateAuthorizationRulesTableInput{
+			denyRoutes:      []string{"^/admin/"},
+			disabledIndices: []string{"path", "query"},
+			errStrings: []string{
+				"deny_rules_disabled_indices[1]: unknown index \"query\", expected one of path, host, methods, headers, ips",
+			},
+		}),
+		Entry("Deny rules with index options", validateAuthorizationRulesTableInput{
+			denyRoutes:     []string{"^/admin/"},
+			indexThreshold: 100,
+			reorderRate:    0.5,
+			errStrings:     []string{},
+		}),
+		Entry("Invalid index options", validateAuthorizationRulesTableInput{
+			denyRoutes:     []string{"^/admin/"},
+			indexThreshold: -1,
+			reorderRate:    1.5,
+			errStrings: []string{
+				"deny_rules_index_threshold (-1) must not be negative",
+				"deny_rules_index_reorder_rate (1.5) must be between 0 and 1",
+			},
+		}),
+		Entry("Hit reordering without optimization", validateAuthorizationRulesTableInput{
+			denyRoutes:  []string{"^/admin/"},
+			unoptimized: true,
+			reorder:     true,
+			errStrings: []string{
+				"reorder_deny_rules cannot be used when deny_rules_optimize is disabled",
+			},
+		}),
+	)
+
+	It("builds deny rules from the routes and IPs", func() {
+		opts := &options.Options{
+			DenyRoutes:  []string{"POST=^/api/"},
+			DenyIPs:     []string{"192.168.0.0/16"},
+			DenyActions: []string{"deny-ip-0=forbidden"},
+		}
+		Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+		name, rules := opts.GetAuthorizationRules().Active()
+		Expect(name).To(Equal("default"))
+
+		req := httptest.NewRequest("POST", "/api/users", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		Expect(rules.Deny(req, nil)).To(BeTrue())
+		Expect(rules.MatchDeny(req, nil).Action).To(Equal(authorization.ErrorPageAction))
+
+		req = httptest.NewRequest("GET", "/api/users", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		Expect(rules.Deny(req, nil)).To(BeFalse())
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		Expect(rules.Deny(req, nil)).To(BeTrue())
+		Expect(rules.MatchDeny(req, nil).Action).To(Equal(authorization.ForbiddenAction))
+
+		Expect(rules.Allow(req, nil)).To(BeFalse())
+	})
+
+	It("does not enforce shadow rules", func() {
+		opts := &options.Options{
+			DenyRoutes:      []string{"^/admin/", "^/"},
+			DenyShadowRules: []string{"deny-route-1"},
+		}
+		Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+		_, rules := opts.GetAuthorizationRules().Active()
+
+		Expect(rules.Deny(httptest.NewRequest("GET", "/admin/users", nil), nil)).To(BeTrue())
+		Expect(rules.Deny(httptest.NewRequest("GET", "/users", nil), nil)).To(BeFalse())
+		Expect(rules.Rules()[1].Shadow).To(BeTrue())
+		Expect(rules.Rules()[1].Hits()).To(Equal(uint64(1)))
+	})
+
+	It("sets the descriptions of deny rules", func() {
+		opts := &options.Options{
+			DenyRoutes: []string{"^/admin/ # admin UI is internal only"},
+			DenyIPs:    []string{"10.0.0.0/8"},
+		}
+		Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+		_, rules := opts.GetAuthorizationRules().Active()
+
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		Expect(rules.MatchDeny(req, nil).String()).To(Equal(`"deny-route-0" (admin UI is internal only)`))
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		Expect(rules.MatchDeny(req, nil).String()).To(Equal(`"deny-ip-0"`))
+	})
+
+	Context("with deny rule sets", func() {
+		var dir string
+
+		writeRuleSet := func(name, contents string) string {
+			path := dir + "/" + name + ".yaml"
+			Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+			return name + "=" + path
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "oauth2-proxy-deny-rule-sets")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("loads the rule sets and activates the configured rule set", func() {
+			opts := &options.Options{
+				DenyRoutes: []string{"^/admin/"},
+				DenyRuleSets: []string{
+					writeRuleSet("blue", "deny_routes:\n- ^/blue/\n"),
+					writeRuleSet("green", "deny_routes:\n- ^/green/\ndeny_ips:\n- 192.168.0.0/16\ndeny_actions:\n  deny-ip-0: json\n"),
+				},
+				ActiveDenyRuleSet: "green",
+			}
+			Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+
+			ruleSets := opts.GetAuthorizationRules()
+			Expect(ruleSets.Names()).To(Equal([]string{"blue", "default", "green"}))
+			name, rules := ruleSets.Active()
+			Expect(name).To(Equal("green"))
+
+			req := httptest.NewRequest("GET", "/green/", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			Expect(rules.Deny(req, nil)).To(BeTrue())
+
+			req = httptest.NewRequest("GET", "/admin/", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			Expect(rules.Deny(req, nil)).To(BeFalse())
+
+			req.RemoteAddr = "192.168.1.1:1234"
+			Expect(rules.Deny(req, nil)).To(BeTrue())
+			Expect(rules.MatchDeny(req, nil).Action).To(Equal(authorization.JSONAction))
+		})
+
+		It("scopes rules in rule sets to upstreams", func() {
+			opts := &options.Options{
+				DenyRuleSets: []string{
+					writeRuleSet("blue", "deny_routes:\n- ^/admin/\ndeny_upstreams:\n  deny-route-0:\n  - api\n"),
+				},
+				ActiveDenyRuleSet: "blue",
+				UpstreamServers:   options.Upstreams{{ID: "api", Path: "/api/"}, {ID: "web", Path: "/"}},
+			}
+			Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+			_, rules := opts.GetAuthorizationRules().Active()
+
+			req := httptest.NewRequest("GET", "/admin/", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			Expect(rules.ForUpstream("api").MatchDeny(req, nil)).ToNot(BeNil())
+			Expect(rules.ForUpstream("web").MatchDeny(req, nil)).To(BeNil())
+		})
+
+		It("activates the default rule set when none is configured", func() {
+			opts := &options.Options{
+				DenyRuleSets: []string{writeRuleSet("blue", "deny_routes:\n- ^/blue/\n")},
+			}
+			Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+			name, _ := opts.GetAuthorizationRules().Active()
+			Expect(name).To(Equal("default"))
+		})
+
+		It("rejects invalid rule sets", func() {
+			opts := &options.Options{
+				DenyRuleSets: []string{
+					"blue",
+					"default=" + dir + "/default.yaml",
+					"green=" + dir + "/missing.yaml",
+					writeRuleSet("red", "deny_ips:\n- not-an-ip\n"),
+					writeRuleSet("yellow", "deny_routes:\n- ^/yellow/\n"),
+					writeRuleSet("yellow", "deny_routes:\n- ^/yellow/\n"),
+				},
+				ActiveDenyRuleSet: "purple",
+			}
+			Expect(validateAuthorizationRules(opts)).To(ConsistOf(
+				"deny_rule_sets[0]: invalid rule set \"blue\", expected name=path",
+				"deny_rule_sets[1]: rule set name \"default\" is reserved for --deny-route and --deny-ip",
+				"deny_rule_sets[2]: could not read rule set \"green\": open "+dir+"/missing.yaml: no such file or directory",
+				"deny_rule_sets[3]: invalid rule set \"red\": deny_ips[0] (not-an-ip) could not be recognized",
+				"deny_rule_sets[5]: rule set \"yellow\" is defined more than once",
+				"active_deny_rule_set \"purple\" is not a deny rule set",
+			))
+			Expect(opts.GetAuthorizationRules()).To(BeNil())
+		})
+	})
+
+	Context("with deny IP feeds", func() {
+		It("loads the feeds from local files into the default rule set", func() {
+			file, err := ioutil.TempFile("", "oauth2-proxy-ip-feed")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(file.Name())
+			_, err = file.WriteString("10.0.0.0/8\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			opts := &options.Options{
+				DenyIPFeeds: []string{file.Name() + " # known scanners", "https://feeds.example.com/drop.txt"},
+				DenyActions: []string{"deny-ip-feed-0=forbidden"},
+				DenyRoutes:  []string{"^/admin/"},
+			}
+			Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+			Expect(opts.GetRuleSyncers()).To(HaveLen(2))
+
+			_, rules := opts.GetAuthorizationRules().Active()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			rule := rules.MatchDeny(req, nil)
+			Expect(rule.String()).To(Equal(`"deny-ip-feed-0" (known scanners)`))
+			Expect(rule.Action).To(Equal(authorization.ForbiddenAction))
+
+			req.RemoteAddr = "192.168.0.1:1234"
+			Expect(rules.MatchDeny(req, nil)).To(BeNil())
+		})
+
+		It("rejects feeds that cannot be read", func() {
+			opts := &options.Options{
+				DenyIPFeeds: []string{"/nonexistent/drop.txt"},
+			}
+			Expect(validateAuthorizationRules(opts)).To(ConsistOf(
+				"deny_ip_feeds[0]: could not read IP feed: open /nonexistent/drop.txt: no such file or directory",
+			))
+		})
+	})
+
+	Context("with deny rule set URLs", func() {
+		It("creates an empty rule set synced from each URL", func() {
+			opts := &options.Options{
+				DenyRuleSetURLs: []string{
+					"blue=https://policy.example.com/blue.yaml",
+					"green=gs://policies/green.yaml",
+				},
+				ActiveDenyRuleSet: "blue",
+			}
+			opts.SetSignatureData(&options.SignatureData{Hash: crypto.SHA256, Key: "secret"})
+			Expect(validateAuthorizationRules(opts)).To(BeEmpty())
+
+			ruleSets := opts.GetAuthorizationRules()
+			Expect(ruleSets.Names()).To(Equal([]string{"blue", "default", "green"}))
+			_, rules := ruleSets.Active()
+			Expect(rules.Rules()).To(BeEmpty())
+			Expect(opts.GetRuleSyncers()).To(HaveLen(2))
+		})
+
+		It("rejects invalid rule set URLs", func() {
+			opts := &options.Options{
+				DenyRuleSetURLs: []string{"blue", "default=https://policy.example.com/default.yaml", "red=ftp://policy.example.com/red.yaml"},
+			}
+			opts.SetSignatureData(&options.SignatureData{Hash: crypto.SHA256, Key: "secret"})
+			Expect(validateAuthorizationRules(opts)).To(ConsistOf(
+				"deny_rule_set_urls[0]: invalid rule set \"blue\", expected name=url",
+				"deny_rule_set_urls[1]: rule set name \"default\" is reserved for --deny-route and --deny-ip",
+				"deny_rule_set_urls[2]: invalid bundle URL \"ftp://policy.example.com/red.yaml\": unsupported scheme \"ftp\"",
+			))
+			Expect(opts.GetRuleSyncers()).To(BeNil())
+		})
+
+		It("requires a signature key", func() {
+			opts := &options.Options{
+				DenyRuleSetURLs: []string{"blue=https://policy.example.com/blue.yaml"},
+			}
+			Expect(validateAuthorizationRules(opts)).To(ConsistOf(
+				"deny_rule_set_urls[0]: rule set \"blue\" is fetched from https://policy.example.com/blue.yaml, but no signature key is set",
+			))
+		})
+	})
+})
@@ -1,9 +1,11 @@
 package validation
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
@@ -48,6 +50,15 @@ func validateCookieName(name string) []string {
 	return msgs
 }
 
+// ValidateCookieSecret checks that the secret can be used to create the
+// session cookie cipher, using the same rules as the cookie_secret option
+func ValidateCookieSecret(secret string) error {
+	if msgs := validateCookieSecret(secret); len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
 func validateCookieSecret(secret string) []string {
 	if secret == "" {
 		return []string{"missing setting: cookie-secret"}
@@ -2,6 +2,7 @@ package validation
 
 import (
 	"fmt"
+	"regexp"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 )
@@ -55,9 +56,33 @@ func validateHeaderValueClaimSource(claim options.ClaimSource) []string {
 	if claim.Claim == "" {
 		msgs = append(msgs, "claim should not be empty")
 	}
+	msgs = append(msgs, validateClaimSelector(claim.Part, claim.Matches)...)
+
+	for i, fallback := range claim.Fallbacks {
+		fallbackMsgs := validateClaimSelector(fallback.Part, fallback.Matches)
+		if fallback.Claim == "" {
+			fallbackMsgs = append(fallbackMsgs, "claim should not be empty")
+		}
+		msgs = append(msgs, prefixValues(fmt.Sprintf("invalid fallbacks[%d]: ", i), fallbackMsgs...)...)
+	}
 
 	if claim.BasicAuthPassword != nil {
 		msgs = append(msgs, prefixValues("invalid basicAuthPassword: ", validateSecretSource(*claim.BasicAuthPassword))...)
 	}
 	return msgs
 }
+
+func validateClaimSelector(part, matches string) []string {
+	msgs := []string{}
+
+	switch part {
+	case "", options.ClaimPartLocal, options.ClaimPartDomain:
+	default:
+		msgs = append(msgs, fmt.Sprintf("part %q should be one of %q or %q", part, options.ClaimPartLocal, options.ClaimPartDomain))
+	}
+
+	if _, err := regexp.Compile(matches); err != nil {
+		msgs = append(msgs, fmt.Sprintf("error compiling matches regex /%s/: %v", matches, err))
+	}
+	return msgs
+}
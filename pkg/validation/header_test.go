@@ -160,5 +160,55 @@ var _ = Describe("Headers", func() {
 				"invalid header \"With-Invalid-Basic-Auth\": invalid values: invalid basicAuthPassword: error loading secret from environent: no value for for key \"UNKNOWN_ENV\"",
 			},
 		}),
+		Entry("with a header with valid claim fallbacks", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Fallbacks",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim:   "preferred_username",
+								Matches: "^[a-z]+$",
+								Fallbacks: []options.ClaimFallback{
+									{
+										Claim: "email",
+										Part:  options.ClaimPartLocal,
+									},
+									{
+										Claim: "user",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedMsgs: []string{},
+		}),
+		Entry("with a header with invalid claim fallbacks", validateHeaderTableInput{
+			headers: []options.Header{
+				{
+					Name: "With-Invalid-Fallbacks",
+					Values: []options.HeaderValue{
+						{
+							ClaimSource: &options.ClaimSource{
+								Claim: "email",
+								Part:  "user",
+								Fallbacks: []options.ClaimFallback{
+									{
+										Matches: "(",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedMsgs: []string{
+				"invalid header \"With-Invalid-Fallbacks\": invalid values: part \"user\" should be one of \"localPart\" or \"domain\"",
+				"invalid header \"With-Invalid-Fallbacks\": invalid values: invalid fallbacks[0]: error compiling matches regex /(/: error parsing regexp: missing closing ): `(`",
+				"invalid header \"With-Invalid-Fallbacks\": invalid values: invalid fallbacks[0]: claim should not be empty",
+			},
+		}),
 	)
 })
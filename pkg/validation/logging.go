@@ -1,9 +1,11 @@
 package validation
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
@@ -60,3 +62,24 @@ func configureLogger(o options.Logging, msgs []string) []string {
 
 	return msgs
 }
+
+// configureIdentityRedaction sets how identities are redacted in session
+// summaries and in the usernames of auth and request logs. Identities are
+// hashed with the cookie secret as the key.
+func configureIdentityRedaction(o *options.Options, msgs []string) []string {
+	redaction := o.Logging.RedactIdentity
+	if redaction == "" {
+		redaction = string(sessionsapi.NoRedaction)
+	}
+	if !sessionsapi.IsRedaction(redaction) {
+		return append(msgs, fmt.Sprintf("logging_redact_identity (%s) must be one of none, mask or hash", redaction))
+	}
+
+	sessionsapi.SetIdentityRedaction(sessionsapi.Redaction(redaction), []byte(o.Cookie.Secret))
+	if sessionsapi.Redaction(redaction) == sessionsapi.NoRedaction {
+		logger.SetIdentityRedactor(nil)
+	} else {
+		logger.SetIdentityRedactor(sessionsapi.RedactIdentity)
+	}
+	return msgs
+}
@@ -2,8 +2,8 @@ package validation
 
 import (
 	"context"
-	"crypto"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -15,6 +15,7 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/identity"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
@@ -27,31 +28,34 @@ import (
 func Validate(o *options.Options) error {
 	msgs := validateCookie(o.Cookie)
 	msgs = append(msgs, validateSessionCookieMinimal(o)...)
+	msgs = append(msgs, validateSessionCookieFields(o)...)
+	msgs = append(msgs, validateSessionCookieCompression(o)...)
+	msgs = append(msgs, validateSessionCookieMaxSize(o)...)
+	msgs = append(msgs, validateSessionCookieSplitTokens(o)...)
+	msgs = append(msgs, validateSessionCookieFormat(o)...)
+	msgs = append(msgs, validateSessionFingerprint(o)...)
+	msgs = append(msgs, validateSessionWriteBatchInterval(o)...)
+	msgs = append(msgs, validateSessionClaims(o)...)
+	msgs = append(msgs, validateSessionLoadLatencyBudget(o)...)
+	msgs = append(msgs, validateSessionEncoding(o)...)
+	msgs = append(msgs, validateSessionClockSkew(o)...)
+	msgs = append(msgs, validateSessionLegacyCutoff(o)...)
+	msgs = append(msgs, validateSessionRefreshBackoff(o)...)
+	msgs = append(msgs, validateSessionAnnotations(o)...)
+	msgs = append(msgs, validateAuthRequestCacheTTL(o)...)
+	msgs = append(msgs, validateHTTPServer(o)...)
+	msgs = append(msgs, validateParamLimits(o)...)
+	msgs = append(msgs, validateMemoryLimits(o)...)
+	msgs = append(msgs, validateAdminAddress(o)...)
+	msgs = append(msgs, validateAdminTokenFile(o)...)
+	msgs = append(msgs, validateAdminGRPC(o)...)
+	msgs = append(msgs, validateMetricsRouteTemplates(o)...)
 	msgs = append(msgs, validateRedisSessionStore(o)...)
+	msgs = append(msgs, validateTelemetry(o)...)
 	msgs = append(msgs, prefixValues("injectRequestHeaders: ", validateHeaders(o.InjectRequestHeaders)...)...)
 	msgs = append(msgs, prefixValues("injectResponseHeaders: ", validateHeaders(o.InjectResponseHeaders)...)...)
 
-	if o.SSLInsecureSkipVerify {
-		// InsecureSkipVerify is a configurable option we allow
-		/* #nosec G402 */
-		insecureTransport := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		http.DefaultClient = &http.Client{Transport: insecureTransport}
-	} else if len(o.ProviderCAFiles) > 0 {
-		pool, err := util.GetCertPool(o.ProviderCAFiles)
-		if err == nil {
-			transport := &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs: pool,
-				},
-			}
-
-			http.DefaultClient = &http.Client{Transport: transport}
-		} else {
-			msgs = append(msgs, fmt.Sprintf("unable to load provider CA file(s): %v", err))
-		}
-	}
+	msgs = append(msgs, configureProviderTransport(o)...)
 
 	if o.ClientID == "" {
 		msgs = append(msgs, "missing setting: client-id")
@@ -175,8 +179,12 @@ func Validate(o *options.Options) error {
 	var redirectURL *url.URL
 	redirectURL, msgs = parseURL(o.RawRedirectURL, "redirect", msgs)
 	o.SetRedirectURL(redirectURL)
+	msgs = append(msgs, validateAuthDomain(o)...)
+	msgs = append(msgs, validateShareLinkMaxExpiry(o)...)
+	msgs = append(msgs, validateIdentityNormalization(o)...)
 
 	msgs = append(msgs, validateUpstreams(o.UpstreamServers)...)
+	msgs = append(msgs, validateUpstreamAllowedHosts(o)...)
 	msgs = parseProviderInfo(o, msgs)
 
 	if len(o.GoogleGroups) > 0 || o.GoogleAdminEmail != "" || o.GoogleServiceAccountJSON != "" {
@@ -192,8 +200,34 @@ func Validate(o *options.Options) error {
 	}
 
 	msgs = parseSignatureKey(o, msgs)
+	msgs = parseChainedIdentity(o, msgs)
+	msgs = append(msgs, validateUpstreamSigning(o)...)
+	msgs = append(msgs, validateUpstreamForwardedFor(o)...)
 	msgs = configureLogger(o.Logging, msgs)
+	msgs = configureIdentityRedaction(o, msgs)
+	msgs = append(msgs, validateRules(o)...)
 
+	if len(msgs) != 0 {
+		return fmt.Errorf("invalid configuration:\n  %s",
+			strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
+
+// ValidateRules validates only the options needed to decide whether requests
+// are denied or may skip authentication: the real client IP header, the
+// allowlists and the deny rules.
+// The deny rules are set on the options as by Validate.
+func ValidateRules(o *options.Options) error {
+	if msgs := validateRules(o); len(msgs) != 0 {
+		return fmt.Errorf("invalid configuration:\n  %s",
+			strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
+
+func validateRules(o *options.Options) []string {
+	msgs := []string{}
 	if o.ReverseProxy {
 		parser, err := ip.GetRealClientIPParser(o.RealClientIPHeader)
 		if err != nil {
@@ -209,12 +243,8 @@ func Validate(o *options.Options) error {
 
 	// Do this after ReverseProxy validation for TrustedIP coordinated checks
 	msgs = append(msgs, validateAllowlists(o)...)
-
-	if len(msgs) != 0 {
-		return fmt.Errorf("invalid configuration:\n  %s",
-			strings.Join(msgs, "\n  "))
-	}
-	return nil
+	msgs = append(msgs, validateAuthorizationRules(o)...)
+	return msgs
 }
 
 func parseProviderInfo(o *options.Options, msgs []string) []string {
@@ -237,6 +267,8 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 	p.AllowUnverifiedEmail = o.InsecureOIDCAllowUnverifiedEmail
 	p.EmailClaim = o.OIDCEmailClaim
 	p.GroupsClaim = o.OIDCGroupsClaim
+	p.SessionClaims = o.Session.Claims
+	p.SessionClaimsMaxSize = o.Session.ClaimsMaxSize
 	p.Verifier = o.GetOIDCVerifier()
 
 	// TODO (@NickMeves) - Remove This
@@ -353,21 +385,58 @@ func parseSignatureKey(o *options.Options, msgs []string) []string {
 		return msgs
 	}
 
-	components := strings.Split(o.SignatureKey, ":")
+	sigData, err := parseSignatureSpec(o.SignatureKey)
+	if err != nil {
+		return append(msgs, err.Error())
+	}
+	o.SetSignatureData(sigData)
+	return msgs
+}
+
+// parseChainedIdentity parses the signature key of the oauth2-proxy in front
+// of this one, whose identity is accepted as a session
+func parseChainedIdentity(o *options.Options, msgs []string) []string {
+	format := options.IdentityFormat(o.ChainedIdentityFormat)
+	switch format {
+	case "", options.IdentityFormatJWT, options.IdentityFormatHeaders:
+	default:
+		msgs = append(msgs, fmt.Sprintf("chained_identity_format (%q) must be one of %q or %q",
+			o.ChainedIdentityFormat, options.IdentityFormatJWT, options.IdentityFormatHeaders))
+	}
+	if o.ChainedIdentityKey == "" {
+		return msgs
+	}
+
+	if format == "" || format == options.IdentityFormatJWT {
+		if o.ChainedIdentityAudience == "" {
+			msgs = append(msgs, "chained_identity_audience must be set with the jwt chained identity format")
+		}
+		if o.ChainedIdentityMaxLifetime <= 0 {
+			msgs = append(msgs, fmt.Sprintf("chained_identity_max_lifetime (%s) must be positive with the jwt chained identity format", o.ChainedIdentityMaxLifetime))
+		}
+	}
+
+	sigData, err := parseSignatureSpec(o.ChainedIdentityKey)
+	if err != nil {
+		return append(msgs, "chained_identity_key: "+err.Error())
+	}
+	o.SetChainedSignatureData(sigData)
+	return msgs
+}
+
+// parseSignatureSpec parses a signature key in the algorithm:secretkey format
+func parseSignatureSpec(spec string) (*options.SignatureData, error) {
+	components := strings.Split(spec, ":")
 	if len(components) != 2 {
-		return append(msgs, "invalid signature hash:key spec: "+
-			o.SignatureKey)
+		return nil, errors.New("invalid signature hash:key spec: " + spec)
 	}
 
 	algorithm, secretKey := components[0], components[1]
-	var hash crypto.Hash
-	var err error
-	if hash, err = hmacauth.DigestNameToCryptoHash(algorithm); err != nil {
-		return append(msgs, "unsupported signature hash algorithm: "+
-			o.SignatureKey)
+	hash, err := hmacauth.DigestNameToCryptoHash(algorithm)
+	if err != nil {
+		return nil, errors.New("unsupported signature hash algorithm: " + spec)
 	}
-	o.SetSignatureData(&options.SignatureData{Hash: hash, Key: secretKey})
-	return msgs
+	return &options.SignatureData{Hash: hash, Key: secretKey}, nil
 }
 
 // parseJwtIssuers takes in an array of strings in the form of issuer=audience
@@ -423,3 +492,69 @@ func parseURL(toParse string, urltype string, msgs []string) (*url.URL, []string
 	}
 	return parsed, msgs
 }
+
+// configureProviderTransport replaces the default HTTP client, used for
+// requests to the provider, when its TLS or proxy settings are configured
+func configureProviderTransport(o *options.Options) []string {
+	if !o.SSLInsecureSkipVerify && len(o.ProviderCAFiles) == 0 && len(o.EgressProxies) == 0 {
+		return nil
+	}
+
+	// Cloning the default transport keeps its timeouts and its use of the
+	// proxy environment variables
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.SSLInsecureSkipVerify {
+		// InsecureSkipVerify is a configurable option we allow
+		/* #nosec G402 */
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if len(o.ProviderCAFiles) > 0 {
+		pool, err := util.GetCertPool(o.ProviderCAFiles)
+		if err != nil {
+			return []string{fmt.Sprintf("unable to load provider CA file(s): %v", err)}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	proxy, err := requests.NewProxyFunc(o.EgressProxies)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid egress-proxy: %v", err)}
+	}
+	transport.Proxy = proxy
+
+	http.DefaultClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// validateAuthDomain checks that the auth domain is a host name that receives
+// the OAuth2 callback
+func validateAuthDomain(o *options.Options) []string {
+	if o.AuthDomain == "" {
+		return nil
+	}
+	if strings.ContainsAny(o.AuthDomain, "/:") {
+		return []string{fmt.Sprintf("auth_domain (%s) must be a host name without a scheme, port or path", o.AuthDomain)}
+	}
+	redirectURL := o.GetRedirectURL()
+	if redirectURL != nil && redirectURL.Host != "" && !strings.EqualFold(redirectURL.Hostname(), o.AuthDomain) {
+		return []string{fmt.Sprintf("auth_domain (%s) must be the host of the redirect_url (%s)", o.AuthDomain, o.RawRedirectURL)}
+	}
+	return nil
+}
+
+// validateShareLinkMaxExpiry checks that share links, which skip
+// authentication, expire
+func validateShareLinkMaxExpiry(o *options.Options) []string {
+	if o.ShareLinkMaxExpiry < 0 {
+		return []string{fmt.Sprintf("share_link_max_expiry (%s) must not be negative", o.ShareLinkMaxExpiry)}
+	}
+	return nil
+}
+
+// validateIdentityNormalization checks that the identity domain aliases can
+// be parsed
+func validateIdentityNormalization(o *options.Options) []string {
+	if _, err := identity.NewNormalizers(o); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
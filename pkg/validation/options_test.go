@@ -3,6 +3,7 @@ package validation
 import (
 	"crypto"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -223,6 +225,52 @@ func TestValidateSignatureKeyUnsupportedAlgorithm(t *testing.T) {
 		"  unsupported signature hash algorithm: "+o.SignatureKey)
 }
 
+func TestValidateChainedIdentity(t *testing.T) {
+	o := testOptions()
+	o.ChainedIdentityKey = "sha256:chain-secret"
+	o.ChainedIdentityAudience = "http://proxy.internal:4180"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, crypto.SHA256, o.GetChainedSignatureData().Hash)
+	assert.Equal(t, "chain-secret", o.GetChainedSignatureData().Key)
+	assert.Nil(t, o.GetSignatureData())
+}
+
+func TestValidateChainedIdentityInvalid(t *testing.T) {
+	o := testOptions()
+	o.ChainedIdentityKey = "invalid spec"
+	o.ChainedIdentityFormat = "cookie"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  chained_identity_format (\"cookie\") must be one of \"jwt\" or \"headers\"\n"+
+		"  chained_identity_key: invalid signature hash:key spec: invalid spec", err.Error())
+}
+
+func TestValidateChainedIdentityAudience(t *testing.T) {
+	o := testOptions()
+	o.ChainedIdentityKey = "sha256:chain-secret"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  chained_identity_audience must be set with the jwt chained identity format", err.Error())
+
+	o = testOptions()
+	o.ChainedIdentityKey = "sha256:chain-secret"
+	o.ChainedIdentityFormat = "headers"
+	assert.Equal(t, nil, Validate(o))
+}
+
+func TestValidateChainedIdentityMaxLifetime(t *testing.T) {
+	o := testOptions()
+	o.ChainedIdentityKey = "sha256:chain-secret"
+	o.ChainedIdentityAudience = "http://proxy.internal:4180"
+	o.ChainedIdentityMaxLifetime = 0
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  chained_identity_max_lifetime (0s) must be positive with the jwt chained identity format", err.Error())
+
+	o.ChainedIdentityFormat = "headers"
+	assert.Equal(t, nil, Validate(o))
+}
+
 func TestSkipOIDCDiscovery(t *testing.T) {
 	o := testOptions()
 	o.ProviderType = "oidc"
@@ -297,3 +345,83 @@ func TestProviderCAFilesError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unable to load provider CA file(s)")
 }
+
+func TestEgressProxy(t *testing.T) {
+	defaultClient := http.DefaultClient
+	defer func() { http.DefaultClient = defaultClient }()
+
+	o := testOptions()
+	o.EgressProxies = []string{"example.com=http://proxy.internal:3128"}
+	assert.Equal(t, nil, Validate(o))
+
+	transport, ok := http.DefaultClient.Transport.(*http.Transport)
+	assert.True(t, ok)
+	req, err := http.NewRequest(http.MethodGet, "https://login.example.com/token", nil)
+	assert.NoError(t, err)
+	proxy, err := transport.Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.internal:3128", proxy.String())
+}
+
+func TestEgressProxyError(t *testing.T) {
+	o := testOptions()
+	o.EgressProxies = []string{"example.com"}
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid egress-proxy: egress proxy "example.com" must have the form host=proxy-url`)
+}
+
+func TestAuthDomain(t *testing.T) {
+	o := testOptions()
+	o.AuthDomain = "auth.example.com"
+	o.RawRedirectURL = "https://auth.example.com/oauth2/callback"
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.AuthDomain = "https://auth.example.com"
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{"auth_domain (https://auth.example.com) must be a host name without a scheme, port or path"}), err.Error())
+
+	o = testOptions()
+	o.AuthDomain = "auth.example.com"
+	o.RawRedirectURL = "https://app.example.com/oauth2/callback"
+	err = Validate(o)
+	assert.Equal(t, errorMsg([]string{"auth_domain (auth.example.com) must be the host of the redirect_url (https://app.example.com/oauth2/callback)"}), err.Error())
+}
+
+func TestShareLinkMaxExpiry(t *testing.T) {
+	o := testOptions()
+	o.ShareLinkMaxExpiry = 24 * time.Hour
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.ShareLinkMaxExpiry = -time.Hour
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{"share_link_max_expiry (-1h0m0s) must not be negative"}), err.Error())
+}
+
+func TestIdentityNormalization(t *testing.T) {
+	o := testOptions()
+	o.IdentityLowercase = true
+	o.IdentityDomainAliases = []string{"old.example.com=example.com"}
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.IdentityDomainAliases = []string{"old.example.com"}
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{"invalid identity domain alias \"old.example.com\", expected old.example.com=new.example.com"}), err.Error())
+}
+
+func TestIdentityRedaction(t *testing.T) {
+	defer sessionsapi.SetIdentityRedaction(sessionsapi.NoRedaction, nil)
+
+	o := testOptions()
+	o.Logging.RedactIdentity = "mask"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, "a***@example.com", sessionsapi.RedactIdentity("alice@example.com"))
+
+	o = testOptions()
+	o.Logging.RedactIdentity = "encrypt"
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{"logging_redact_identity (encrypt) must be one of none, mask or hash"}), err.Error())
+}
@@ -0,0 +1,61 @@
+package validation
+
+import (
+	"fmt"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// validateHTTPServer validates the protocol and timeout options of the main
+// listener
+func validateHTTPServer(o *options.Options) []string {
+	msgs := []string{}
+
+	if o.H2C && (o.TLSCertFile != "" || o.TLSKeyFile != "") {
+		msgs = append(msgs, "h2c cannot be used with tls_cert_file or tls_key_file, as only the HTTPS address is served when TLS is configured")
+	}
+	if o.HTTP2MaxStreams < 0 {
+		msgs = append(msgs, fmt.Sprintf("http2_max_streams (%d) must not be negative", o.HTTP2MaxStreams))
+	}
+	if o.HTTPReadHeaderTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("http_read_header_timeout (%s) must not be negative", o.HTTPReadHeaderTimeout))
+	}
+	if o.HTTPIdleTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("http_idle_timeout (%s) must not be negative", o.HTTPIdleTimeout))
+	}
+	if o.HTTP2IdleTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("http2_idle_timeout (%s) must not be negative", o.HTTP2IdleTimeout))
+	}
+	return msgs
+}
+
+// validateParamLimits validates the length limits of the OAuth flow
+// parameters
+func validateParamLimits(o *options.Options) []string {
+	msgs := []string{}
+
+	if o.MaxStateLength < 0 {
+		msgs = append(msgs, fmt.Sprintf("max_state_length (%d) must not be negative", o.MaxStateLength))
+	}
+	if o.MaxRedirectLength < 0 {
+		msgs = append(msgs, fmt.Sprintf("max_redirect_length (%d) must not be negative", o.MaxRedirectLength))
+	}
+	if o.MaxLoginHintLength < 0 {
+		msgs = append(msgs, fmt.Sprintf("max_login_hint_length (%d) must not be negative", o.MaxLoginHintLength))
+	}
+	return msgs
+}
+
+// validateMemoryLimits validates the soft memory limit and the size limit of
+// buffered response bodies
+func validateMemoryLimits(o *options.Options) []string {
+	msgs := []string{}
+
+	if o.MemoryLimitMB < 0 {
+		msgs = append(msgs, fmt.Sprintf("memory_limit_mb (%d) must not be negative", o.MemoryLimitMB))
+	}
+	if o.MaxResponseBodySize < 0 {
+		msgs = append(msgs, fmt.Sprintf("max_response_body_size (%d) must not be negative", o.MaxResponseBodySize))
+	}
+	return msgs
+}
@@ -0,0 +1,79 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTP Server", func() {
+	DescribeTable("validateHTTPServer",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateHTTPServer(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("Defaults", &options.Options{}, []string{}),
+		Entry("HTTP/2 with timeouts", &options.Options{
+			HTTP2:                 true,
+			TLSCertFile:           "cert.pem",
+			TLSKeyFile:            "key.pem",
+			HTTPReadHeaderTimeout: 10 * time.Second,
+			HTTPIdleTimeout:       time.Minute,
+			HTTP2IdleTimeout:      5 * time.Minute,
+			HTTP2MaxStreams:       100,
+		}, []string{}),
+		Entry("h2c without TLS", &options.Options{H2C: true}, []string{}),
+		Entry("h2c with TLS", &options.Options{
+			H2C:         true,
+			TLSCertFile: "cert.pem",
+			TLSKeyFile:  "key.pem",
+		}, []string{
+			"h2c cannot be used with tls_cert_file or tls_key_file, as only the HTTPS address is served when TLS is configured",
+		}),
+		Entry("Negative limits", &options.Options{
+			HTTPReadHeaderTimeout: -time.Second,
+			HTTPIdleTimeout:       -time.Second,
+			HTTP2IdleTimeout:      -time.Second,
+			HTTP2MaxStreams:       -1,
+		}, []string{
+			"http2_max_streams (-1) must not be negative",
+			"http_read_header_timeout (-1s) must not be negative",
+			"http_idle_timeout (-1s) must not be negative",
+			"http2_idle_timeout (-1s) must not be negative",
+		}),
+	)
+
+	DescribeTable("validateParamLimits",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateParamLimits(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("Defaults", options.NewOptions(), []string{}),
+		Entry("No limits", &options.Options{}, []string{}),
+		Entry("Negative limits", &options.Options{
+			MaxStateLength:     -1,
+			MaxRedirectLength:  -1,
+			MaxLoginHintLength: -1,
+		}, []string{
+			"max_state_length (-1) must not be negative",
+			"max_redirect_length (-1) must not be negative",
+			"max_login_hint_length (-1) must not be negative",
+		}),
+	)
+
+	DescribeTable("validateMemoryLimits",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateMemoryLimits(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("Defaults", options.NewOptions(), []string{}),
+		Entry("Memory limit", &options.Options{MemoryLimitMB: 256}, []string{}),
+		Entry("Negative limits", &options.Options{
+			MemoryLimitMB:       -1,
+			MaxResponseBodySize: -1,
+		}, []string{
+			"memory_limit_mb (-1) must not be negative",
+			"max_response_body_size (-1) must not be negative",
+		}),
+	)
+})
@@ -3,10 +3,14 @@ package validation
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	sessioncookie "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/cookie"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/sessions/redis"
 )
 
@@ -38,10 +42,337 @@ func validateSessionCookieMinimal(o *options.Options) []string {
 	return msgs
 }
 
+// validateSessionCookieFields ensures that cookie sessions keep the fields
+// needed by the injected headers and cookie refreshes
+func validateSessionCookieFields(o *options.Options) []string {
+	if len(o.Session.Cookie.Fields) == 0 {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Session.Cookie.Minimal {
+		msgs = append(msgs, "session_cookie_fields cannot be used with session_cookie_minimal")
+	}
+
+	kept := map[string]bool{}
+	for _, field := range o.Session.Cookie.Fields {
+		if !sessionsapi.IsPrunableField(field) {
+			msgs = append(msgs, fmt.Sprintf("session_cookie_fields contains unknown field %q", field))
+		}
+		kept[field] = true
+	}
+
+	for _, header := range append(o.InjectRequestHeaders, o.InjectResponseHeaders...) {
+		for _, value := range header.Values {
+			if value.ClaimSource == nil {
+				continue
+			}
+			claim := value.ClaimSource.Claim
+			if sessionsapi.IsPrunableField(claim) && !kept[claim] {
+				msgs = append(msgs,
+					fmt.Sprintf("%s claim for header %q requires %s in sessions. session_cookie_fields must include it", claim, header.Name, claim))
+			}
+		}
+	}
+
+	if o.Cookie.Refresh != time.Duration(0) && !kept["refresh_token"] {
+		msgs = append(msgs,
+			"cookie_refresh > 0 requires refresh_token in sessions. session_cookie_fields must include it")
+	}
+	if len(o.Session.Claims) > 0 && !kept["claims"] {
+		msgs = append(msgs,
+			"session_claims requires claims in sessions. session_cookie_fields must include it")
+	}
+	return msgs
+}
+
+// validateSessionCookieCompression ensures that the compression algorithm is
+// supported at the compression level, and the compression and size warning
+// thresholds are sizes
+func validateSessionCookieCompression(o *options.Options) []string {
+	msgs := []string{}
+	compression := o.Session.Cookie.Compression
+	if compression == "" {
+		compression = string(sessionsapi.LZ4Compression)
+	}
+	if !sessionsapi.IsCompression(compression) {
+		msgs = append(msgs, fmt.Sprintf("session_cookie_compression (%s) must be one of lz4, zstd, snappy or none", compression))
+	} else if min, max := sessionsapi.CompressionLevels(sessionsapi.Compression(compression)); min == max && o.Session.Cookie.CompressionLevel != min {
+		msgs = append(msgs, fmt.Sprintf("session_cookie_compression_level (%d) cannot be set when session_cookie_compression is %s", o.Session.Cookie.CompressionLevel, compression))
+	} else if level := o.Session.Cookie.CompressionLevel; level < min || level > max {
+		msgs = append(msgs, fmt.Sprintf("session_cookie_compression_level (%d) must be between %d and %d for %s compression", level, min, max, compression))
+	}
+	if o.Session.Cookie.CompressionThreshold < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_cookie_compression_threshold (%d) must not be negative", o.Session.Cookie.CompressionThreshold))
+	}
+	if o.Session.Cookie.SizeWarningThreshold < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_cookie_size_warning_threshold (%d) must not be negative", o.Session.Cookie.SizeWarningThreshold))
+	}
+	return msgs
+}
+
+// validateSessionCookieMaxSize ensures that sessions can only overflow to
+// redis when there is a maximum cookie size
+func validateSessionCookieMaxSize(o *options.Options) []string {
+	cookie := o.Session.Cookie
+	if cookie.MaxSize < 0 {
+		return []string{fmt.Sprintf("session_cookie_max_size (%d) must not be negative", cookie.MaxSize)}
+	}
+	if cookie.OverflowToRedis && cookie.MaxSize == 0 {
+		return []string{"session_cookie_overflow_to_redis requires session_cookie_max_size to be set"}
+	}
+	if cookie.OverflowToRedis && o.Session.Type != options.CookieSessionStoreType {
+		return []string{"session_cookie_overflow_to_redis requires the cookie session store"}
+	}
+	return []string{}
+}
+
+// validateSessionCookieSplitTokens ensures that the tokens of cookie sessions
+// are only split off when the cookies keep the identity, and are not sized
+// for overflowing to redis instead
+func validateSessionCookieSplitTokens(o *options.Options) []string {
+	cookie := o.Session.Cookie
+	if !cookie.SplitTokens {
+		return []string{}
+	}
+
+	msgs := []string{}
+	if o.Session.Type != options.CookieSessionStoreType {
+		msgs = append(msgs, "session_cookie_split_tokens requires the cookie session store")
+	}
+	if cookie.Minimal {
+		msgs = append(msgs, "session_cookie_split_tokens cannot be used with session_cookie_minimal")
+	}
+	if len(cookie.Fields) > 0 {
+		msgs = append(msgs, "session_cookie_split_tokens cannot be used with session_cookie_fields")
+	}
+	if cookie.MaxSize > 0 {
+		msgs = append(msgs, "session_cookie_split_tokens cannot be used with session_cookie_max_size")
+	}
+	return msgs
+}
+
+// validateSessionCookieFormat ensures that sessions are only stored as JWTs in
+// cookies, and that the keys of the JWTs can be loaded
+func validateSessionCookieFormat(o *options.Options) []string {
+	cookie := o.Session.Cookie
+	switch cookie.Format {
+	case "", options.CookieFormatEncrypted:
+		return []string{}
+	case options.CookieFormatJWT:
+	default:
+		return []string{fmt.Sprintf("session_cookie_format (%s) must be one of encrypted or jwt", cookie.Format)}
+	}
+
+	msgs := []string{}
+	if o.Session.Type != options.CookieSessionStoreType {
+		msgs = append(msgs, "session_cookie_format jwt requires the cookie session store")
+	}
+	if cookie.OverflowToRedis || cookie.SplitTokens {
+		msgs = append(msgs, "session_cookie_format jwt cannot be used with session_cookie_overflow_to_redis or session_cookie_split_tokens")
+	}
+	if cookie.JWTAudience == "" {
+		msgs = append(msgs, "session_cookie_jwt_audience is required when session_cookie_format is jwt")
+	}
+	if cookie.JWTEncryptionKey == "" && jwtCookieHasTokens(cookie) {
+		msgs = append(msgs, "session_cookie_format jwt without session_cookie_jwt_encryption_key requires session_cookie_minimal, or session_cookie_fields without the tokens, so that the tokens can't be read from the cookie")
+	}
+	if cookie.JWTKeyFile == "" {
+		return append(msgs, "session_cookie_jwt_key_file is required when session_cookie_format is jwt")
+	}
+	if _, err := sessioncookie.LoadJWTKeys(&cookie); err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to load the session JWT keys: %v", err))
+	}
+	return msgs
+}
+
+// jwtCookieHasTokens checks whether the OAuth tokens of sessions are stored
+// in JWT cookies, where they can be read unless the JWTs are encrypted
+func jwtCookieHasTokens(cookie options.CookieStoreOptions) bool {
+	if len(cookie.Fields) == 0 {
+		return !cookie.Minimal
+	}
+	for _, field := range cookie.Fields {
+		switch field {
+		case "access_token", "id_token", "refresh_token":
+			return true
+		}
+	}
+	return false
+}
+
+// storesSessionsInRedis checks whether any sessions, or their tokens, are
+// stored in redis
+func storesSessionsInRedis(o *options.Options) bool {
+	cookie := o.Session.Cookie
+	return o.Session.Type == options.RedisSessionStoreType || cookie.OverflowToRedis || cookie.SplitTokens
+}
+
+// validateSessionFingerprint checks that sessions are only bound to known
+// client components, each given once
+func validateSessionFingerprint(o *options.Options) []string {
+	msgs := []string{}
+	seen := make(map[string]bool, len(o.Session.Fingerprint))
+	for _, component := range o.Session.Fingerprint {
+		switch {
+		case component != options.FingerprintClientIP && component != options.FingerprintUserAgent:
+			msgs = append(msgs, fmt.Sprintf("session_fingerprint component %q is not one of %q or %q", component, options.FingerprintClientIP, options.FingerprintUserAgent))
+		case seen[component]:
+			msgs = append(msgs, fmt.Sprintf("session_fingerprint component %q is given more than once", component))
+		}
+		seen[component] = true
+	}
+	return msgs
+}
+
+// validateSessionWriteBatchInterval checks that session updates are only
+// batched for sessions stored in redis
+func validateSessionWriteBatchInterval(o *options.Options) []string {
+	interval := o.Session.WriteBatchInterval
+	switch {
+	case interval < 0:
+		return []string{fmt.Sprintf("session_write_batch_interval (%s) must not be negative", interval)}
+	case interval > 0 && !storesSessionsInRedis(o):
+		return []string{"session_write_batch_interval requires sessions to be stored in redis"}
+	}
+	return []string{}
+}
+
+// validateSessionClockSkew checks that the clock skew tolerance is not
+// negative
+func validateSessionClockSkew(o *options.Options) []string {
+	if o.Session.ClockSkew < 0 {
+		return []string{fmt.Sprintf("session_clock_skew (%s) must not be negative", o.Session.ClockSkew)}
+	}
+	return []string{}
+}
+
+// validateSessionLegacyCutoff checks that the time until which legacy
+// sessions are decoded can be parsed
+func validateSessionLegacyCutoff(o *options.Options) []string {
+	if _, err := sessionsapi.ParseLegacyCutoff(o.Session.LegacyCutoff); err != nil {
+		return []string{err.Error()}
+	}
+	return []string{}
+}
+
+// validateSessionRefreshBackoff checks that the refresh backoff is not
+// negative, and does not exceed its maximum
+func validateSessionRefreshBackoff(o *options.Options) []string {
+	backoff, max := o.Session.RefreshBackoff, o.Session.RefreshBackoffMax
+	switch {
+	case backoff < 0:
+		return []string{fmt.Sprintf("session_refresh_backoff (%s) must not be negative", backoff)}
+	case backoff > 0 && max < backoff:
+		return []string{fmt.Sprintf("session_refresh_backoff_max (%s) must be at least session_refresh_backoff (%s)", max, backoff)}
+	}
+	return []string{}
+}
+
+// validateSessionEncoding checks that the session encoding is supported, and
+// that sessions are only protobuf encoded in redis
+func validateSessionEncoding(o *options.Options) []string {
+	encoding := o.Session.Encoding
+	switch {
+	case encoding != "" && !sessionsapi.IsEncoding(encoding):
+		return []string{fmt.Sprintf("session_encoding (%s) must be one of msgpack or protobuf", encoding)}
+	case sessionsapi.Encoding(encoding) == sessionsapi.ProtobufEncoding && !storesSessionsInRedis(o):
+		return []string{"session_encoding protobuf requires sessions to be stored in redis"}
+	}
+	return []string{}
+}
+
+// validateSessionLoadLatencyBudget checks that the latency budget applies to
+// sessions stored in redis, and that degrading depends on the budget
+func validateSessionLoadLatencyBudget(o *options.Options) []string {
+	session := o.Session
+	msgs := []string{}
+	if session.LoadLatencyBudget < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_load_latency_budget (%s) must not be negative", session.LoadLatencyBudget))
+	}
+	if session.DegradedModeWindow < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_degraded_mode_window (%s) must not be negative", session.DegradedModeWindow))
+	}
+	if session.LoadLatencyBudget > 0 && session.LoadBudgetViolations < 1 {
+		msgs = append(msgs, fmt.Sprintf("session_load_budget_violations (%d) must be at least 1", session.LoadBudgetViolations))
+	}
+	if session.LoadLatencyBudget > 0 && !storesSessionsInRedis(o) {
+		msgs = append(msgs, "session_load_latency_budget requires sessions to be stored in redis")
+	}
+	if session.DegradedModeWindow > 0 && session.LoadLatencyBudget == 0 {
+		msgs = append(msgs, "session_degraded_mode_window requires session_load_latency_budget to be set")
+	}
+	return msgs
+}
+
+// validateSessionClaims ensures that the claims carried in sessions can be
+// read by GetClaim and have a size limit that makes sense
+func validateSessionClaims(o *options.Options) []string {
+	msgs := []string{}
+	for _, claim := range o.Session.Claims {
+		switch {
+		case claim == "":
+			msgs = append(msgs, "session_claims cannot contain an empty claim name")
+		case sessionsapi.IsBuiltinClaim(claim):
+			msgs = append(msgs, fmt.Sprintf("session_claims cannot contain %q, which is always read from the session fields", claim))
+		}
+	}
+	if o.Session.ClaimsMaxSize < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_claims_max_size (%d) must not be negative", o.Session.ClaimsMaxSize))
+	}
+	return msgs
+}
+
+// annotationNamePattern matches the names that session annotations can have,
+// as they are used in header names
+var annotationNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// validateSessionAnnotations ensures that upstreams can only set known
+// annotation keys, which can be injected as request headers
+func validateSessionAnnotations(o *options.Options) []string {
+	session := o.Session
+	msgs := []string{}
+	switch {
+	case session.AnnotationHeader == "" && len(session.AnnotationKeys) > 0:
+		msgs = append(msgs, "session_annotation_keys requires session_annotation_header to be set")
+	case session.AnnotationHeader != "" && len(session.AnnotationKeys) == 0:
+		msgs = append(msgs, "session_annotation_header requires session_annotation_keys to be set")
+	case session.AnnotationHeader != "" && !annotationNamePattern.MatchString(session.AnnotationHeader):
+		msgs = append(msgs, fmt.Sprintf("session_annotation_header (%s) is not a valid header name", session.AnnotationHeader))
+	}
+
+	seen := map[string]bool{}
+	for _, key := range session.AnnotationKeys {
+		switch {
+		case !annotationNamePattern.MatchString(key):
+			msgs = append(msgs, fmt.Sprintf("session_annotation_keys cannot contain %q, keys may only contain letters, digits and dashes", key))
+		case seen[strings.ToLower(key)]:
+			msgs = append(msgs, fmt.Sprintf("session_annotation_keys contains %q more than once", key))
+		}
+		seen[strings.ToLower(key)] = true
+	}
+	if session.AnnotationsMaxSize < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_annotations_max_size (%d) must not be negative", session.AnnotationsMaxSize))
+	}
+	return msgs
+}
+
+// validateAuthRequestCacheTTL checks that cached auth decisions cannot bypass
+// checks that depend on more than the request headers
+func validateAuthRequestCacheTTL(o *options.Options) []string {
+	if o.AuthRequestCacheTTL < 0 {
+		return []string{fmt.Sprintf("auth_request_cache_ttl (%s) must not be negative", o.AuthRequestCacheTTL)}
+	}
+	if o.AuthRequestCacheTTL > 0 && len(o.Session.Fingerprint) > 0 {
+		return []string{"auth_request_cache_ttl cannot be used with session_fingerprint, as a cache cannot verify the client fingerprint"}
+	}
+	return []string{}
+}
+
 // validateRedisSessionStore builds a Redis Client from the options and
 // attempts to connect, Set, Get and Del a random health check key
 func validateRedisSessionStore(o *options.Options) []string {
-	if o.Session.Type != options.RedisSessionStoreType {
+	if !storesSessionsInRedis(o) {
 		return []string{}
 	}
 
@@ -184,6 +184,480 @@ var _ = Describe("Sessions", func() {
 		}),
 	)
 
+	DescribeTable("validateSessionCookieFields",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateSessionCookieFields(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("All fields", &options.Options{}, []string{}),
+		Entry("Valid fields", &options.Options{
+			Session: options.SessionOptions{
+				Cookie: options.CookieStoreOptions{
+					Fields: []string{"access_token", "email", "groups"},
+				},
+			},
+		}, []string{}),
+		Entry("Unknown field with minimal sessions", &options.Options{
+			Session: options.SessionOptions{
+				Cookie: options.CookieStoreOptions{
+					Minimal: true,
+					Fields:  []string{"email", "created_at"},
+				},
+			},
+		}, []string{
+			"session_cookie_fields cannot be used with session_cookie_minimal",
+			"session_cookie_fields contains unknown field \"created_at\"",
+		}),
+		Entry("Fields required by headers and cookie refresh", &options.Options{
+			Cookie: options.Cookie{
+				Refresh: time.Hour,
+			},
+			Session: options.SessionOptions{
+				Cookie: options.CookieStoreOptions{
+					Fields: []string{"email"},
+				},
+			},
+			InjectRequestHeaders: []options.Header{
+				{
+					Name: "X-Email",
+					Values: []options.HeaderValue{
+						{ClaimSource: &options.ClaimSource{Claim: "email"}},
+					},
+				},
+				{
+					Name: "X-ID-Token",
+					Values: []options.HeaderValue{
+						{ClaimSource: &options.ClaimSource{Claim: "id_token"}},
+					},
+				},
+			},
+		}, []string{
+			"id_token claim for header \"X-ID-Token\" requires id_token in sessions. session_cookie_fields must include it",
+			"cookie_refresh > 0 requires refresh_token in sessions. session_cookie_fields must include it",
+		}),
+		Entry("Claims carried in sessions", &options.Options{
+			Session: options.SessionOptions{
+				Claims: []string{"department"},
+				Cookie: options.CookieStoreOptions{
+					Fields: []string{"email"},
+				},
+			},
+		}, []string{
+			"session_claims requires claims in sessions. session_cookie_fields must include it",
+		}),
+	)
+
+	DescribeTable("validateSessionCookieCompression",
+		func(compression string, level int, threshold int, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Cookie: options.CookieStoreOptions{
+						Compression:          compression,
+						CompressionLevel:     level,
+						CompressionThreshold: threshold,
+					},
+				},
+			}
+			Expect(validateSessionCookieCompression(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("compress all sessions", "lz4", 0, 0, []string{}),
+		Entry("compress large sessions", "lz4", 0, 512, []string{}),
+		Entry("compress with zstd", "zstd", 0, 0, []string{}),
+		Entry("compress with snappy", "snappy", 0, 0, []string{}),
+		Entry("no compression", "none", 0, 0, []string{}),
+		Entry("default compression", "", 0, 0, []string{}),
+		Entry("lz4 level", "lz4", 9, 0, []string{}),
+		Entry("zstd level", "zstd", 22, 0, []string{}),
+		Entry("default compression level", "", 5, 0, []string{}),
+		Entry("unknown compression", "gzip", 0, 0, []string{"session_cookie_compression (gzip) must be one of lz4, zstd, snappy or none"}),
+		Entry("negative threshold", "lz4", 0, -1, []string{"session_cookie_compression_threshold (-1) must not be negative"}),
+		Entry("lz4 level out of range", "lz4", 10, 0, []string{"session_cookie_compression_level (10) must be between 0 and 9 for lz4 compression"}),
+		Entry("zstd level out of range", "zstd", 23, 0, []string{"session_cookie_compression_level (23) must be between 0 and 22 for zstd compression"}),
+		Entry("negative level", "zstd", -1, 0, []string{"session_cookie_compression_level (-1) must be between 0 and 22 for zstd compression"}),
+		Entry("snappy level", "snappy", 1, 0, []string{"session_cookie_compression_level (1) cannot be set when session_cookie_compression is snappy"}),
+		Entry("level without compression", "none", 3, 0, []string{"session_cookie_compression_level (3) cannot be set when session_cookie_compression is none"}),
+	)
+
+	It("rejects a negative session cookie size warning threshold", func() {
+		opts := &options.Options{
+			Session: options.SessionOptions{
+				Cookie: options.CookieStoreOptions{
+					SizeWarningThreshold: -1,
+				},
+			},
+		}
+		Expect(validateSessionCookieCompression(opts)).To(ConsistOf("session_cookie_size_warning_threshold (-1) must not be negative"))
+	})
+
+	DescribeTable("validateSessionCookieMaxSize",
+		func(sessionType string, cookie options.CookieStoreOptions, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Type:   sessionType,
+					Cookie: cookie,
+				},
+			}
+			Expect(validateSessionCookieMaxSize(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("no maximum size", options.CookieSessionStoreType, options.CookieStoreOptions{}, []string{}),
+		Entry("maximum size", options.CookieSessionStoreType, options.CookieStoreOptions{MaxSize: 8192}, []string{}),
+		Entry("overflow to redis", options.CookieSessionStoreType, options.CookieStoreOptions{MaxSize: 8192, OverflowToRedis: true}, []string{}),
+		Entry("negative maximum size", options.CookieSessionStoreType, options.CookieStoreOptions{MaxSize: -1}, []string{
+			"session_cookie_max_size (-1) must not be negative",
+		}),
+		Entry("overflow to redis without maximum size", options.CookieSessionStoreType, options.CookieStoreOptions{OverflowToRedis: true}, []string{
+			"session_cookie_overflow_to_redis requires session_cookie_max_size to be set",
+		}),
+		Entry("overflow to redis with redis sessions", options.RedisSessionStoreType, options.CookieStoreOptions{MaxSize: 8192, OverflowToRedis: true}, []string{
+			"session_cookie_overflow_to_redis requires the cookie session store",
+		}),
+	)
+
+	DescribeTable("validateSessionCookieSplitTokens",
+		func(sessionType string, cookie options.CookieStoreOptions, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Type:   sessionType,
+					Cookie: cookie,
+				},
+			}
+			Expect(validateSessionCookieSplitTokens(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("no split tokens", options.CookieSessionStoreType, options.CookieStoreOptions{Minimal: true}, []string{}),
+		Entry("split tokens", options.CookieSessionStoreType, options.CookieStoreOptions{SplitTokens: true}, []string{}),
+		Entry("split tokens with redis sessions", options.RedisSessionStoreType, options.CookieStoreOptions{SplitTokens: true}, []string{
+			"session_cookie_split_tokens requires the cookie session store",
+		}),
+		Entry("split tokens with pruned cookies", options.CookieSessionStoreType, options.CookieStoreOptions{
+			SplitTokens: true,
+			Minimal:     true,
+			Fields:      []string{"email"},
+			MaxSize:     8192,
+		}, []string{
+			"session_cookie_split_tokens cannot be used with session_cookie_minimal",
+			"session_cookie_split_tokens cannot be used with session_cookie_fields",
+			"session_cookie_split_tokens cannot be used with session_cookie_max_size",
+		}),
+	)
+
+	const jwtTokensMsg = "session_cookie_format jwt without session_cookie_jwt_encryption_key requires session_cookie_minimal, or session_cookie_fields without the tokens, so that the tokens can't be read from the cookie"
+
+	DescribeTable("validateSessionCookieFormat",
+		func(sessionType string, cookie options.CookieStoreOptions, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Type:   sessionType,
+					Cookie: cookie,
+				},
+			}
+			Expect(validateSessionCookieFormat(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("encrypted sessions", options.CookieSessionStoreType, options.CookieStoreOptions{Format: options.CookieFormatEncrypted}, []string{}),
+		Entry("unknown format", options.CookieSessionStoreType, options.CookieStoreOptions{Format: "paseto"}, []string{
+			"session_cookie_format (paseto) must be one of encrypted or jwt",
+		}),
+		Entry("JWT sessions without a key", options.CookieSessionStoreType, options.CookieStoreOptions{
+			Format:      options.CookieFormatJWT,
+			JWTAudience: "sidecars",
+			Minimal:     true,
+		}, []string{
+			"session_cookie_jwt_key_file is required when session_cookie_format is jwt",
+		}),
+		Entry("JWT sessions with a missing key file", options.CookieSessionStoreType, options.CookieStoreOptions{
+			Format:           options.CookieFormatJWT,
+			JWTKeyFile:       "/nonexistent/session-jwt-key.pem",
+			JWTAudience:      "sidecars",
+			JWTEncryptionKey: "0123456789abcdef",
+		}, []string{
+			"unable to load the session JWT keys: could not read session JWT key file: open /nonexistent/session-jwt-key.pem: no such file or directory",
+		}),
+		Entry("JWT sessions in redis", options.RedisSessionStoreType, options.CookieStoreOptions{
+			Format:      options.CookieFormatJWT,
+			SplitTokens: true,
+			JWTAudience: "sidecars",
+			Minimal:     true,
+		}, []string{
+			"session_cookie_format jwt requires the cookie session store",
+			"session_cookie_format jwt cannot be used with session_cookie_overflow_to_redis or session_cookie_split_tokens",
+			"session_cookie_jwt_key_file is required when session_cookie_format is jwt",
+		}),
+		Entry("JWT sessions without an audience", options.CookieSessionStoreType, options.CookieStoreOptions{
+			Format:     options.CookieFormatJWT,
+			JWTKeyFile: "/nonexistent/session-jwt-key.pem",
+			Minimal:    true,
+		}, []string{
+			"session_cookie_jwt_audience is required when session_cookie_format is jwt",
+			"unable to load the session JWT keys: could not read session JWT key file: open /nonexistent/session-jwt-key.pem: no such file or directory",
+		}),
+		Entry("signed JWT sessions with tokens", options.CookieSessionStoreType, options.CookieStoreOptions{
+			Format:      options.CookieFormatJWT,
+			JWTAudience: "sidecars",
+		}, []string{
+			jwtTokensMsg,
+			"session_cookie_jwt_key_file is required when session_cookie_format is jwt",
+		}),
+		Entry("signed JWT sessions with token fields", options.CookieSessionStoreType, options.CookieStoreOptions{
+			Format:      options.CookieFormatJWT,
+			JWTAudience: "sidecars",
+			Minimal:     true,
+			Fields:      []string{"email", "refresh_token"},
+		}, []string{
+			jwtTokensMsg,
+			"session_cookie_jwt_key_file is required when session_cookie_format is jwt",
+		}),
+		Entry("signed JWT sessions without token fields", options.CookieSessionStoreType, options.CookieStoreOptions{
+			Format:      options.CookieFormatJWT,
+			JWTAudience: "sidecars",
+			Fields:      []string{"email", "groups"},
+		}, []string{
+			"session_cookie_jwt_key_file is required when session_cookie_format is jwt",
+		}),
+	)
+
+	DescribeTable("validateSessionFingerprint",
+		func(fingerprint []string, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Fingerprint: fingerprint,
+				},
+			}
+			Expect(validateSessionFingerprint(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("fingerprint disabled", nil, []string{}),
+		Entry("client IP and User-Agent", []string{"ip", "user-agent"}, []string{}),
+		Entry("unknown component", []string{"ip", "accept-language"}, []string{
+			`session_fingerprint component "accept-language" is not one of "ip" or "user-agent"`,
+		}),
+		Entry("repeated component", []string{"user-agent", "user-agent"}, []string{
+			`session_fingerprint component "user-agent" is given more than once`,
+		}),
+	)
+
+	DescribeTable("validateSessionWriteBatchInterval",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateSessionWriteBatchInterval(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("batching disabled", &options.Options{}, []string{}),
+		Entry("batching with redis sessions", &options.Options{
+			Session: options.SessionOptions{
+				Type:               options.RedisSessionStoreType,
+				WriteBatchInterval: time.Second,
+			},
+		}, []string{}),
+		Entry("batching with cookie sessions overflowing to redis", &options.Options{
+			Session: options.SessionOptions{
+				Type:               options.CookieSessionStoreType,
+				WriteBatchInterval: time.Second,
+				Cookie: options.CookieStoreOptions{
+					OverflowToRedis: true,
+				},
+			},
+		}, []string{}),
+		Entry("batching with cookie sessions", &options.Options{
+			Session: options.SessionOptions{
+				Type:               options.CookieSessionStoreType,
+				WriteBatchInterval: time.Second,
+			},
+		}, []string{"session_write_batch_interval requires sessions to be stored in redis"}),
+		Entry("negative interval", &options.Options{
+			Session: options.SessionOptions{
+				Type:               options.RedisSessionStoreType,
+				WriteBatchInterval: -time.Second,
+			},
+		}, []string{"session_write_batch_interval (-1s) must not be negative"}),
+	)
+
+	DescribeTable("validateSessionClockSkew",
+		func(skew time.Duration, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					ClockSkew: skew,
+				},
+			}
+			Expect(validateSessionClockSkew(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("no skew", time.Duration(0), []string{}),
+		Entry("skew", 30*time.Second, []string{}),
+		Entry("negative skew", -time.Second, []string{"session_clock_skew (-1s) must not be negative"}),
+	)
+
+	DescribeTable("validateSessionLegacyCutoff",
+		func(cutoff string, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					LegacyCutoff: cutoff,
+				},
+			}
+			Expect(validateSessionLegacyCutoff(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("no cutoff", "", []string{}),
+		Entry("a date", "2021-06-30", []string{}),
+		Entry("an RFC3339 time", "2021-06-30T12:00:00Z", []string{}),
+		Entry("an invalid cutoff", "next month", []string{
+			"session_legacy_cutoff (next month) must be a date, e.g. 2006-01-02, or an RFC3339 time",
+		}),
+	)
+
+	DescribeTable("validateSessionRefreshBackoff",
+		func(backoff, max time.Duration, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					RefreshBackoff:    backoff,
+					RefreshBackoffMax: max,
+				},
+			}
+			Expect(validateSessionRefreshBackoff(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("no backoff", time.Duration(0), time.Duration(0), []string{}),
+		Entry("backoff", 10*time.Second, 5*time.Minute, []string{}),
+		Entry("negative backoff", -time.Second, 5*time.Minute, []string{"session_refresh_backoff (-1s) must not be negative"}),
+		Entry("backoff above the maximum", time.Minute, 30*time.Second, []string{
+			"session_refresh_backoff_max (30s) must be at least session_refresh_backoff (1m0s)",
+		}),
+	)
+
+	DescribeTable("validateSessionEncoding",
+		func(sessionType string, encoding string, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Type:     sessionType,
+					Encoding: encoding,
+				},
+			}
+			Expect(validateSessionEncoding(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("default encoding", options.CookieSessionStoreType, "", []string{}),
+		Entry("msgpack cookie sessions", options.CookieSessionStoreType, "msgpack", []string{}),
+		Entry("protobuf redis sessions", options.RedisSessionStoreType, "protobuf", []string{}),
+		Entry("protobuf cookie sessions", options.CookieSessionStoreType, "protobuf", []string{"session_encoding protobuf requires sessions to be stored in redis"}),
+		Entry("unknown encoding", options.RedisSessionStoreType, "json", []string{"session_encoding (json) must be one of msgpack or protobuf"}),
+	)
+
+	DescribeTable("validateSessionLoadLatencyBudget",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateSessionLoadLatencyBudget(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("budget disabled", &options.Options{}, []string{}),
+		Entry("budget with redis sessions", &options.Options{
+			Session: options.SessionOptions{
+				Type:                 options.RedisSessionStoreType,
+				LoadLatencyBudget:    50 * time.Millisecond,
+				LoadBudgetViolations: 5,
+				DegradedModeWindow:   time.Minute,
+			},
+		}, []string{}),
+		Entry("budget with cookie sessions overflowing to redis", &options.Options{
+			Session: options.SessionOptions{
+				Type:                 options.CookieSessionStoreType,
+				LoadLatencyBudget:    50 * time.Millisecond,
+				LoadBudgetViolations: 5,
+				Cookie: options.CookieStoreOptions{
+					OverflowToRedis: true,
+				},
+			},
+		}, []string{}),
+		Entry("budget with cookie sessions", &options.Options{
+			Session: options.SessionOptions{
+				Type:                 options.CookieSessionStoreType,
+				LoadLatencyBudget:    50 * time.Millisecond,
+				LoadBudgetViolations: 5,
+			},
+		}, []string{"session_load_latency_budget requires sessions to be stored in redis"}),
+		Entry("budget without violations", &options.Options{
+			Session: options.SessionOptions{
+				Type:              options.RedisSessionStoreType,
+				LoadLatencyBudget: 50 * time.Millisecond,
+			},
+		}, []string{"session_load_budget_violations (0) must be at least 1"}),
+		Entry("window without a budget", &options.Options{
+			Session: options.SessionOptions{
+				Type:               options.RedisSessionStoreType,
+				DegradedModeWindow: time.Minute,
+			},
+		}, []string{"session_degraded_mode_window requires session_load_latency_budget to be set"}),
+		Entry("negative durations", &options.Options{
+			Session: options.SessionOptions{
+				Type:               options.RedisSessionStoreType,
+				LoadLatencyBudget:  -time.Second,
+				DegradedModeWindow: -time.Minute,
+			},
+		}, []string{
+			"session_load_latency_budget (-1s) must not be negative",
+			"session_degraded_mode_window (-1m0s) must not be negative",
+		}),
+	)
+
+	DescribeTable("validateSessionAnnotations",
+		func(header string, keys []string, maxSize int, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					AnnotationHeader:   header,
+					AnnotationKeys:     keys,
+					AnnotationsMaxSize: maxSize,
+				},
+			}
+			Expect(validateSessionAnnotations(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("annotations disabled", "", []string{}, 512, []string{}),
+		Entry("annotations", "X-Session-Annotation", []string{"tenant", "locale"}, 512, []string{}),
+		Entry("keys without a header", "", []string{"tenant"}, 512, []string{
+			"session_annotation_keys requires session_annotation_header to be set",
+		}),
+		Entry("a header without keys", "X-Session-Annotation", []string{}, 512, []string{
+			"session_annotation_header requires session_annotation_keys to be set",
+		}),
+		Entry("an invalid header", "X Session Annotation", []string{"tenant"}, 512, []string{
+			"session_annotation_header (X Session Annotation) is not a valid header name",
+		}),
+		Entry("invalid and duplicate keys", "X-Session-Annotation", []string{"tenant", "Tenant", "tenant_id"}, 512, []string{
+			"session_annotation_keys contains \"Tenant\" more than once",
+			"session_annotation_keys cannot contain \"tenant_id\", keys may only contain letters, digits and dashes",
+		}),
+		Entry("a negative size limit", "X-Session-Annotation", []string{"tenant"}, -1, []string{
+			"session_annotations_max_size (-1) must not be negative",
+		}),
+	)
+
+	DescribeTable("validateSessionClaims",
+		func(claims []string, maxSize int, errStrings []string) {
+			opts := &options.Options{
+				Session: options.SessionOptions{
+					Claims:        claims,
+					ClaimsMaxSize: maxSize,
+				},
+			}
+			Expect(validateSessionClaims(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("no claims", []string{}, 1024, []string{}),
+		Entry("provider specific claims", []string{"department", "roles"}, 1024, []string{}),
+		Entry("claims without a size limit", []string{"department"}, 0, []string{}),
+		Entry("empty and built-in claims", []string{"", "email"}, 1024, []string{
+			"session_claims cannot contain an empty claim name",
+			"session_claims cannot contain \"email\", which is always read from the session fields",
+		}),
+		Entry("negative size limit", []string{"department"}, -1, []string{
+			"session_claims_max_size (-1) must not be negative",
+		}),
+	)
+
+	DescribeTable("validateAuthRequestCacheTTL",
+		func(opts *options.Options, errStrings []string) {
+			Expect(validateAuthRequestCacheTTL(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("caching disabled", &options.Options{}, []string{}),
+		Entry("caching enabled", &options.Options{
+			AuthRequestCacheTTL: 5 * time.Second,
+		}, []string{}),
+		Entry("negative TTL", &options.Options{
+			AuthRequestCacheTTL: -time.Second,
+		}, []string{"auth_request_cache_ttl (-1s) must not be negative"}),
+		Entry("caching with a session fingerprint", &options.Options{
+			AuthRequestCacheTTL: 5 * time.Second,
+			Session: options.SessionOptions{
+				Fingerprint: []string{"ip"},
+			},
+		}, []string{"auth_request_cache_ttl cannot be used with session_fingerprint, as a cache cannot verify the client fingerprint"}),
+	)
+
 	const (
 		clusterAndSentinelMsg     = "unable to initialize a redis client: options redis-use-sentinel and redis-use-cluster are mutually exclusive"
 		parseWrongSchemeMsg       = "unable to initialize a redis client: unable to parse redis url: redis: invalid URL scheme: https"
@@ -0,0 +1,18 @@
+package validation
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/telemetry"
+)
+
+// validateTelemetry checks the options of the SIEM streamer when it is
+// enabled
+func validateTelemetry(o *options.Options) []string {
+	if o.Telemetry.SIEM.URL == "" {
+		return []string{}
+	}
+	if err := telemetry.ValidateSIEMOptions(o.Telemetry.SIEM); err != nil {
+		return []string{err.Error()}
+	}
+	return []string{}
+}
@@ -0,0 +1,34 @@
+package validation
+
+import (
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Telemetry", func() {
+	DescribeTable("validateTelemetry",
+		func(siem options.SIEMOptions, errStrings []string) {
+			opts := &options.Options{
+				Telemetry: options.Telemetry{SIEM: siem},
+			}
+			Expect(validateTelemetry(opts)).To(ConsistOf(errStrings))
+		},
+		Entry("SIEM streaming disabled", options.SIEMOptions{}, []string{}),
+		Entry("SIEM streaming", options.SIEMOptions{
+			URL:           "https://siem.example.com/events",
+			Format:        options.CEFFormat,
+			BatchSize:     100,
+			FlushInterval: 5 * time.Second,
+			QueueSize:     10000,
+			Timeout:       10 * time.Second,
+		}, []string{}),
+		Entry("SIEM streaming to a relative URL", options.SIEMOptions{
+			URL:    "/events",
+			Format: options.ECSFormat,
+		}, []string{`SIEM URL "/events" must be an absolute http or https URL`}),
+	)
+})
@@ -3,8 +3,10 @@ package validation
 import (
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/upstream"
 )
 
 func validateUpstreams(upstreams options.Upstreams) []string {
@@ -15,10 +17,35 @@ func validateUpstreams(upstreams options.Upstreams) []string {
 	for _, upstream := range upstreams {
 		msgs = append(msgs, validateUpstream(upstream, ids, paths)...)
 	}
+	msgs = append(msgs, validateUpstreamPublishedRoutesPaths(upstreams)...)
 
 	return msgs
 }
 
+// validateUpstreamAllowedHosts checks the upstream host allowlist and that
+// the hosts of the HTTP upstreams are allowed by it
+func validateUpstreamAllowedHosts(o *options.Options) []string {
+	hosts, err := upstream.NewHostAllowlist(o.UpstreamAllowedHosts)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	msgs := []string{}
+	for _, u := range o.UpstreamServers {
+		if u.Static {
+			continue
+		}
+		uri, err := url.Parse(u.URI)
+		if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") {
+			continue
+		}
+		if err := hosts.CheckHost(uri.Hostname()); err != nil {
+			msgs = append(msgs, fmt.Sprintf("upstream %q: %v", u.ID, err))
+		}
+	}
+	return msgs
+}
+
 // validateUpstream validates that the upstream has valid options and that
 // the ids and paths are unique across all options
 func validateUpstream(upstream options.Upstream, ids, paths map[string]struct{}) []string {
@@ -45,6 +72,87 @@ func validateUpstream(upstream options.Upstream, ids, paths map[string]struct{})
 
 	msgs = append(msgs, validateUpstreamURI(upstream)...)
 	msgs = append(msgs, validateStaticUpstream(upstream)...)
+	msgs = append(msgs, validateUpstreamIdentityFormat(upstream)...)
+	msgs = append(msgs, validateUpstreamPublishRoutes(upstream)...)
+	return msgs
+}
+
+// validateUpstreamIdentityFormat checks that the identity format is known
+func validateUpstreamIdentityFormat(upstream options.Upstream) []string {
+	switch upstream.IdentityFormat {
+	case "", options.IdentityFormatHeaders, options.IdentityFormatIDToken,
+		options.IdentityFormatAccessToken, options.IdentityFormatJWT, options.IdentityFormatNone:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("upstream %q has invalid identityFormat %q", upstream.ID, upstream.IdentityFormat)}
+	}
+}
+
+// validateUpstreamSigning checks that a signature key is configured when any
+// upstream expects a signed identity JWT or publishes signed routes, or when
+// the ID token is re-signed with reduced claims
+func validateUpstreamSigning(o *options.Options) []string {
+	msgs := []string{}
+	if o.SignatureKey != "" {
+		return msgs
+	}
+	if len(o.IDTokenClaims) > 0 {
+		msgs = append(msgs, "id_token_claims is set, but no signature_key is set")
+	}
+	for _, upstream := range o.UpstreamServers {
+		if upstream.IdentityFormat == options.IdentityFormatJWT {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has identityFormat %q, but no signature_key is set", upstream.ID, upstream.IdentityFormat))
+		}
+		if upstream.PublishRoutes {
+			msgs = append(msgs, fmt.Sprintf("upstream %q has publishRoutes, but no signature_key is set", upstream.ID))
+		}
+	}
+	return msgs
+}
+
+// validateUpstreamForwardedFor checks that the client IP is passed to
+// upstreams in a known way
+func validateUpstreamForwardedFor(o *options.Options) []string {
+	switch o.UpstreamForwardedFor {
+	case options.ForwardedForAppend, options.ForwardedForReplace, options.ForwardedForRFC7239:
+		return []string{}
+	default:
+		return []string{fmt.Sprintf("upstream_forwarded_for (%q) must be one of ['append', 'replace', 'forwarded']", o.UpstreamForwardedFor)}
+	}
+}
+
+// validateUpstreamPublishRoutes checks that an upstream publishing routes is
+// an HTTP(S) upstream serving a subtree of paths
+func validateUpstreamPublishRoutes(upstream options.Upstream) []string {
+	msgs := []string{}
+	if !upstream.PublishRoutes {
+		return msgs
+	}
+
+	if u, err := url.Parse(upstream.URI); upstream.Static || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has publishRoutes, but is not an http(s) upstream", upstream.ID))
+	}
+	if !strings.HasSuffix(upstream.Path, "/") {
+		msgs = append(msgs, fmt.Sprintf("upstream %q has publishRoutes, but its path %q does not end with /", upstream.ID, upstream.Path))
+	}
+	return msgs
+}
+
+// validateUpstreamPublishedRoutesPaths checks that no other upstream serves
+// paths within an upstream publishing routes, as the published routes could
+// otherwise skip authentication for the other upstream
+func validateUpstreamPublishedRoutesPaths(upstreams options.Upstreams) []string {
+	msgs := []string{}
+	for _, upstream := range upstreams {
+		if !upstream.PublishRoutes {
+			continue
+		}
+		for _, other := range upstreams {
+			if other.ID != upstream.ID && strings.HasPrefix(other.Path, upstream.Path) {
+				msgs = append(msgs, fmt.Sprintf("upstream %q cannot publish routes as its path %q contains the path of upstream %q", upstream.ID, upstream.Path, other.ID))
+			}
+		}
+	}
 	return msgs
 }
 
@@ -48,6 +48,10 @@ var _ = Describe("Upstreams", func() {
 	multipleIDsMsg := "multiple upstreams found with id \"foo\": upstream ids must be unique"
 	multiplePathsMsg := "multiple upstreams found with path \"/foo\": upstream paths must be unique"
 	staticCodeMsg := "upstream \"foo\" has staticCode (200), but is not a static upstream, set 'static' for a static response"
+	invalidIdentityFormatMsg := "upstream \"foo\" has invalid identityFormat \"cookie\""
+	publishRoutesSchemeMsg := "upstream \"foo\" has publishRoutes, but is not an http(s) upstream"
+	publishRoutesPathMsg := "upstream \"foo\" has publishRoutes, but its path \"/foo\" does not end with /"
+	publishRoutesOverlapMsg := "upstream \"foo\" cannot publish routes as its path \"/foo/\" contains the path of upstream \"bar\""
 
 	DescribeTable("validateUpstreams",
 		func(o *validateUpstreamTableInput) {
@@ -186,5 +190,133 @@ var _ = Describe("Upstreams", func() {
 			},
 			errStrings: []string{emptyURIMsg, staticCodeMsg},
 		}),
+		Entry("with a valid identity format", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:             "foo",
+					Path:           "/foo",
+					URI:            "http://foo",
+					IdentityFormat: options.IdentityFormatIDToken,
+				},
+			},
+			errStrings: []string{},
+		}),
+		Entry("with an invalid identity format", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:             "foo",
+					Path:           "/foo",
+					URI:            "http://foo",
+					IdentityFormat: "cookie",
+				},
+			},
+			errStrings: []string{invalidIdentityFormatMsg},
+		}),
+		Entry("with an upstream publishing routes", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:            "foo",
+					Path:          "/foo/",
+					URI:           "http://foo",
+					PublishRoutes: true,
+				},
+				validHTTPUpstream,
+			},
+			errStrings: []string{},
+		}),
+		Entry("with a file upstream publishing routes", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:            "foo",
+					Path:          "/foo/",
+					URI:           "file://var/lib/foo",
+					PublishRoutes: true,
+				},
+			},
+			errStrings: []string{publishRoutesSchemeMsg},
+		}),
+		Entry("with an upstream publishing routes without a subtree path", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:            "foo",
+					Path:          "/foo",
+					URI:           "http://foo",
+					PublishRoutes: true,
+				},
+			},
+			errStrings: []string{publishRoutesPathMsg},
+		}),
+		Entry("with an upstream publishing routes containing another upstream", &validateUpstreamTableInput{
+			upstreams: options.Upstreams{
+				{
+					ID:            "foo",
+					Path:          "/foo/",
+					URI:           "http://foo",
+					PublishRoutes: true,
+				},
+				{
+					ID:   "bar",
+					Path: "/foo/bar/",
+					URI:  "http://bar",
+				},
+			},
+			errStrings: []string{publishRoutesOverlapMsg},
+		}),
+	)
+
+	DescribeTable("validateUpstreamSigning",
+		func(signatureKey string, format options.IdentityFormat, publishRoutes bool, errStrings []string) {
+			o := &options.Options{
+				SignatureKey: signatureKey,
+				UpstreamServers: options.Upstreams{
+					{
+						ID:             "foo",
+						Path:           "/foo/",
+						URI:            "http://foo",
+						IdentityFormat: format,
+						PublishRoutes:  publishRoutes,
+					},
+				},
+			}
+			Expect(validateUpstreamSigning(o)).To(ConsistOf(errStrings))
+		},
+		Entry("with the jwt format and a signature key", "sha256:secret", options.IdentityFormatJWT, false, []string{}),
+		Entry("with the jwt format and no signature key", "", options.IdentityFormatJWT, false,
+			[]string{"upstream \"foo\" has identityFormat \"jwt\", but no signature_key is set"}),
+		Entry("with the headers format and no signature key", "", options.IdentityFormatHeaders, false, []string{}),
+		Entry("with published routes and a signature key", "sha256:secret", options.IdentityFormatHeaders, true, []string{}),
+		Entry("with published routes and no signature key", "", options.IdentityFormatHeaders, true,
+			[]string{"upstream \"foo\" has publishRoutes, but no signature_key is set"}),
 	)
+
+	It("requires a signature key to minimize the ID token", func() {
+		o := &options.Options{IDTokenClaims: []string{"sub"}}
+		Expect(validateUpstreamSigning(o)).To(ConsistOf("id_token_claims is set, but no signature_key is set"))
+
+		o.SignatureKey = "sha256:secret"
+		Expect(validateUpstreamSigning(o)).To(BeEmpty())
+	})
+
+	It("checks the upstream hosts against the allowlist", func() {
+		o := &options.Options{
+			UpstreamAllowedHosts: []string{"backend.internal", "10.0.0.0/8"},
+			UpstreamServers: options.Upstreams{
+				{ID: "backend", Path: "/", URI: "http://backend.internal:8080"},
+				{ID: "internal", Path: "/internal/", URI: "https://10.0.0.1"},
+				{ID: "admin", Path: "/admin/", URI: "http://admin.internal"},
+				{ID: "metadata", Path: "/metadata/", URI: "http://169.254.169.254"},
+				{ID: "static", Path: "/static/", Static: true},
+				{ID: "files", Path: "/files/", URI: "file:///var/www"},
+			},
+		}
+		Expect(validateUpstreamAllowedHosts(o)).To(ConsistOf(
+			"upstream \"admin\": host \"admin.internal\" is not an allowed upstream host",
+			"upstream \"metadata\": host \"169.254.169.254\" resolves to blocked address 169.254.169.254",
+		))
+
+		o.UpstreamAllowedHosts = []string{"not-a-cidr/8"}
+		Expect(validateUpstreamAllowedHosts(o)).To(ConsistOf(
+			"invalid upstream allowed host \"not-a-cidr/8\": invalid CIDR address: not-a-cidr/8",
+		))
+	})
 })
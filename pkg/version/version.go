@@ -1,4 +1,4 @@
-package main
+package version
 
 // VERSION contains version information
 var VERSION = "undefined"
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/ghodss/yaml"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/server"
+	"github.com/spf13/pflag"
+)
+
+const policyTestUsage = `usage:
+  oauth2-proxy policy-test --cases <file> [--config <file>] [--alpha-config <file>] [<option flags>]
+
+Loads the configuration, including any option flags, and checks each request
+in the YAML cases file against the deny rules and allowlists. For example:
+
+  - name: admin writes need the admins group
+    method: POST
+    path: /admin/users
+    ip: 10.0.0.1
+    groups: [developers]
+    expect: deny
+
+The expected decision is one of deny, skip_auth, authenticate or allow.
+Requests with groups, which may be an empty list, are made by an
+authenticated user and are either denied or allowed. The command exits with
+an error if any decision differs from the expected one.`
+
+// policyTestCase is a request and how the proxy is expected to handle it
+type policyTestCase struct {
+	Name   string    `json:"name,omitempty"`
+	Method string    `json:"method,omitempty"`
+	Host   string    `json:"host,omitempty"`
+	Path   string    `json:"path"`
+	IP     string    `json:"ip,omitempty"`
+	Groups *[]string `json:"groups,omitempty"`
+	Expect string    `json:"expect"`
+}
+
+// policyTestDecisions are the decisions that test cases can expect
+var policyTestDecisions = map[string]bool{
+	server.DenyDecision:         true,
+	server.SkipAuthDecision:     true,
+	server.AuthenticateDecision: true,
+	server.AllowDecision:        true,
+}
+
+// runPolicyTestCommand checks the configured policy against a table of
+// requests and expected decisions
+func runPolicyTestCommand(args []string) error {
+	// Keep the output of the command separate from the configuration logs
+	logger.SetOutput(os.Stderr)
+	return policyTest(os.Stdout, args)
+}
+
+// policyTest writes a line for each test case with whether the decision for
+// the request was the expected one, and returns an error if any was not
+func policyTest(w io.Writer, args []string) error {
+	// Only the config files are needed before the options are loaded, the
+	// rest of the flags are parsed with the option flags
+	configFlagSet := pflag.NewFlagSet("policy-test", pflag.ContinueOnError)
+	config := configFlagSet.String("config", "", "path to config file")
+	alphaConfig := configFlagSet.String("alpha-config", "", "path to alpha config file")
+	configFlagSet.ParseErrorsWhitelist.UnknownFlags = true
+	if err := configFlagSet.Parse(args); err != nil {
+		return err
+	}
+
+	flagSet := pflag.NewFlagSet("policy-test", pflag.ContinueOnError)
+	flagSet.AddFlagSet(configFlagSet)
+	casesFile := flagSet.String("cases", "", "path to the YAML file of test cases")
+
+	opts, err := loadConfiguration(*config, *alphaConfig, flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *casesFile == "" {
+		return errors.New(policyTestUsage)
+	}
+
+	cases, err := loadPolicyTestCases(*casesFile)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for i, tc := range cases {
+		req, err := newRulesTestRequest(opts, tc.Method, tc.Host, tc.Path, tc.IP, []string{})
+		if err != nil {
+			return fmt.Errorf("case %d (%s): %v", i, tc.Name, err)
+		}
+		var session *sessionsapi.SessionState
+		if tc.Groups != nil {
+			session = &sessionsapi.SessionState{Groups: *tc.Groups}
+		}
+
+		check, err := server.CheckSessionRules(opts, req, session)
+		if err != nil {
+			return err
+		}
+		if check.Decision == tc.Expect {
+			fmt.Fprintf(w, "PASS %s: %s\n", tc.Name, check.Decision)
+			continue
+		}
+
+		failures++
+		fmt.Fprintf(w, "FAIL %s: expected %s, got %s%s\n", tc.Name, tc.Expect, check.Decision, policyTestReason(check))
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d policy test cases failed", failures, len(cases))
+	}
+	return nil
+}
+
+// loadPolicyTestCases reads the test cases, defaulting the name, method and
+// host of each request
+func loadPolicyTestCases(path string) ([]policyTestCase, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read policy test cases: %v", err)
+	}
+	cases := []policyTestCase{}
+	if err := yaml.Unmarshal(data, &cases); err != nil {
+		return nil, fmt.Errorf("unable to parse policy test cases: %v", err)
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no policy test cases in %s", path)
+	}
+
+	for i := range cases {
+		tc := &cases[i]
+		if tc.Method == "" {
+			tc.Method = http.MethodGet
+		}
+		if tc.Host == "" {
+			tc.Host = "localhost"
+		}
+		if tc.Name == "" {
+			tc.Name = fmt.Sprintf("%s %s", tc.Method, tc.Path)
+		}
+		if !policyTestDecisions[tc.Expect] {
+			return nil, fmt.Errorf("case %d (%s) expects unknown decision %q, expected one of deny, skip_auth, authenticate or allow", i, tc.Name, tc.Expect)
+		}
+	}
+	return cases, nil
+}
+
+// policyTestReason describes the rule or allowlist entry behind a decision
+func policyTestReason(check *server.RulesCheck) string {
+	switch {
+	case check.DenyRule != "":
+		return fmt.Sprintf(" (deny rule %s)", check.DenyRule)
+	case check.AllowlistEntry != "":
+		return fmt.Sprintf(" (allowlist entry %s)", check.AllowlistEntry)
+	default:
+		return ""
+	}
+}
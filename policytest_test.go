@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testPolicyTestOPAPolicy = `package oauth2_proxy
+
+default allow = false
+
+allow {
+	input.session.groups[_] == "admins"
+}
+
+allow {
+	input.method == "GET"
+}
+`
+
+var _ = Describe("Policy Test Command Suite", func() {
+	var dir string
+	var policyFlags []string
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oauth2-proxy-policy-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		policy := filepath.Join(dir, "policy.rego")
+		Expect(ioutil.WriteFile(policy, []byte(testPolicyTestOPAPolicy), 0600)).To(Succeed())
+		policyFlags = []string{
+			"--deny-ip", "192.168.0.0/16",
+			"--skip-auth-route", "GET=^/health$",
+			"--deny-opa-policy", policy,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	writeCases := func(cases string) string {
+		path := filepath.Join(dir, "cases.yaml")
+		Expect(ioutil.WriteFile(path, []byte(cases), 0600)).To(Succeed())
+		return path
+	}
+
+	It("passes when every decision is the expected one", func() {
+		cases := writeCases(`
+- path: /health
+  expect: skip_auth
+- name: denied IP
+  method: POST
+  path: /admin/users
+  ip: 192.168.1.1
+  expect: deny
+- path: /admin/users
+  expect: authenticate
+- name: developers cannot write
+  method: POST
+  path: /admin/users
+  groups: [developers]
+  expect: deny
+- name: admins can write
+  method: POST
+  path: /admin/users
+  groups: [admins]
+  expect: allow
+- name: users without groups can read
+  path: /admin/users
+  groups: []
+  expect: allow
+`)
+		buf := bytes.NewBuffer(nil)
+		Expect(policyTest(buf, append([]string{"--cases", cases}, policyFlags...))).To(Succeed())
+		Expect(buf.String()).To(Equal(
+			"PASS GET /health: skip_auth\n" +
+				"PASS denied IP: deny\n" +
+				"PASS GET /admin/users: authenticate\n" +
+				"PASS developers cannot write: deny\n" +
+				"PASS admins can write: allow\n" +
+				"PASS users without groups can read: allow\n",
+		))
+	})
+
+	It("fails when a decision is not the expected one", func() {
+		cases := writeCases(`
+- name: developers can write
+  method: POST
+  path: /admin/users
+  groups: [developers]
+  expect: allow
+- path: /health
+  expect: skip_auth
+`)
+		buf := bytes.NewBuffer(nil)
+		err := policyTest(buf, append([]string{"--cases", cases}, policyFlags...))
+		Expect(err).To(MatchError("1 of 2 policy test cases failed"))
+		Expect(buf.String()).To(Equal(
+			"FAIL developers can write: expected allow, got deny (deny rule \"deny-opa-0\")\n" +
+				"PASS GET /health: skip_auth\n",
+		))
+	})
+
+	It("rejects cases with an unknown decision", func() {
+		cases := writeCases(`
+- path: /
+  expect: allowed
+`)
+		err := policyTest(bytes.NewBuffer(nil), append([]string{"--cases", cases}, policyFlags...))
+		Expect(err).To(MatchError(`case 0 (GET /) expects unknown decision "allowed", expected one of deny, skip_auth, authenticate or allow`))
+	})
+
+	It("prints the usage without a cases file", func() {
+		Expect(policyTest(bytes.NewBuffer(nil), policyFlags)).To(MatchError(policyTestUsage))
+	})
+})
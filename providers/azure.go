@@ -3,10 +3,13 @@ package providers
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/bitly/go-simplejson"
@@ -149,13 +152,15 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (*
 	created := time.Now()
 	expires := time.Unix(jsonResponse.ExpiresOn, 0)
 
-	return &sessions.SessionState{
+	s := &sessions.SessionState{
 		AccessToken:  jsonResponse.AccessToken,
 		IDToken:      jsonResponse.IDToken,
 		CreatedAt:    &created,
 		ExpiresOn:    &expires,
 		RefreshToken: jsonResponse.RefreshToken,
-	}, nil
+	}
+	setGroupsFromAzureIDToken(s)
+	return s, nil
 }
 
 // RefreshSessionIfNeeded checks if the session has expired and uses the
@@ -169,7 +174,7 @@ func (p *AzureProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions.
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	logger.Printf("refreshed id token %s (expired on %s)\n", s, origExpiration)
@@ -190,13 +195,16 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 		IDToken      string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&jsonResponse)
+		Do()
+	if isInvalidGrant(result.StatusCode(), result.Body()) {
+		return fmt.Errorf("%w: unexpected status \"%d\": %s", sessions.ErrTokenExpired, result.StatusCode(), result.Body())
+	}
+	err = result.UnmarshalInto(&jsonResponse)
 
 	if err != nil {
 		return
@@ -209,9 +217,49 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 	s.RefreshToken = jsonResponse.RefreshToken
 	s.CreatedAt = &now
 	s.ExpiresOn = &expires
+	setGroupsFromAzureIDToken(s)
 	return
 }
 
+// setGroupsFromAzureIDToken sets the groups of the session to the group object
+// IDs in the groups claim of its ID token, which Azure only includes when the
+// application is configured to emit group claims.
+// The ID token is received directly from the token endpoint, so its
+// signature is not verified.
+// The groups are left unchanged if the ID token cannot be read.
+func setGroupsFromAzureIDToken(s *sessions.SessionState) {
+	if s.IDToken == "" {
+		return
+	}
+
+	parts := strings.Split(s.IDToken, ".")
+	if len(parts) != 3 {
+		logger.Errorf("Unable to read groups from Azure ID token: malformed token")
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		logger.Errorf("Unable to read groups from Azure ID token: %v", err)
+		return
+	}
+
+	var c struct {
+		Groups     []string          `json:"groups"`
+		ClaimNames map[string]string `json:"_claim_names"`
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		logger.Errorf("Unable to read groups from Azure ID token: %v", err)
+		return
+	}
+	if _, ok := c.ClaimNames["groups"]; ok {
+		// Azure leaves out the groups claim when the user is in too many
+		// groups to fit in a token
+		logger.Errorf("Unable to read groups from Azure ID token: the user is a member of too many groups")
+		return
+	}
+	s.Groups = c.Groups
+}
+
 func makeAzureHeader(accessToken string) http.Header {
 	return makeAuthorizationHeader(tokenTypeBearer, accessToken, nil)
 }
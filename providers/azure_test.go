@@ -2,6 +2,8 @@ package providers
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -266,3 +268,38 @@ func TestAzureProviderRefreshWhenExpired(t *testing.T) {
 	assert.Equal(t, "new_some_id_token", session.IDToken)
 	assert.Equal(t, timestamp, session.ExpiresOn.UTC())
 }
+
+func TestAzureProviderRedeemReadsGroups(t *testing.T) {
+	testCases := map[string]struct {
+		claims         string
+		expectedGroups []string
+	}{
+		"With groups": {
+			claims:         `{"sub":"user","groups":["6f2f3c2a-admins","0b1e2d3c-devs"]}`,
+			expectedGroups: []string{"6f2f3c2a-admins", "0b1e2d3c-devs"},
+		},
+		"Without groups": {
+			claims:         `{"sub":"user"}`,
+			expectedGroups: nil,
+		},
+		"With too many groups": {
+			claims:         `{"sub":"user","_claim_names":{"groups":"src1"}}`,
+			expectedGroups: nil,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			idToken := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(tc.claims)) + ".signature"
+			b := testAzureBackend(fmt.Sprintf(`{ "access_token": "some_access_token", "expires_on": "1136239445", "id_token": %q }`, idToken))
+			defer b.Close()
+			bURL, _ := url.Parse(b.URL)
+			p := testAzureProvider(bURL.Host)
+
+			session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(session.Groups).To(Equal(tc.expectedGroups))
+		})
+	}
+}
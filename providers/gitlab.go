@@ -132,7 +132,7 @@ func (p *GitLabProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	logger.Printf("refreshed id token %s (expired on %s)\n", s, origExpiration)
@@ -158,7 +158,7 @@ func (p *GitLabProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 	}
 	token, err := c.TokenSource(ctx, t).Token()
 	if err != nil {
-		return fmt.Errorf("failed to get token: %v", err)
+		return fmt.Errorf("failed to get token: %w", wrapRefreshError(err))
 	}
 	newSession, err := p.createSession(ctx, token)
 	if err != nil {
@@ -334,7 +334,7 @@ func (p *GitLabProvider) addProjectsToSession(ctx context.Context, s *sessions.S
 			if perms.AccessLevel >= project.AccessLevel {
 				s.Groups = append(s.Groups, fmt.Sprintf("project:%s", project.Name))
 			} else {
-				logger.Errorf("Warning: user %q does not have the minimum required access level for project %q", s.Email, project.Name)
+				logger.Errorf("Warning: user %q does not have the minimum required access level for project %q", sessions.RedactIdentity(s.Email), project.Name)
 			}
 		} else {
 			logger.Errorf("Warning: project %s is archived", project.Name)
@@ -251,7 +251,7 @@ func userInGroup(service *admin.Service, group string, email string) bool {
 		req := service.Members.Get(group, email)
 		r, err := req.Do()
 		if err != nil {
-			logger.Errorf("error using get API to check member %s of google group %s: user not in the group", email, group)
+			logger.Errorf("error using get API to check member %s of google group %s: user not in the group", sessions.RedactIdentity(email), group)
 			return false
 		}
 
@@ -283,7 +283,7 @@ func (p *GoogleProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions
 	//
 	// re-check that the user is in the proper google group(s)
 	if !p.groupValidator(s) {
-		return false, fmt.Errorf("%s is no longer in the group(s)", s.Email)
+		return false, fmt.Errorf("%w: %s is no longer in the group(s)", sessions.ErrNotAuthorized, s.Email)
 	}
 
 	origExpiration := s.ExpiresOn
@@ -314,13 +314,16 @@ func (p *GoogleProvider) redeemRefreshToken(ctx context.Context, refreshToken st
 		IDToken     string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&data)
+		Do()
+	if isInvalidGrant(result.StatusCode(), result.Body()) {
+		return "", "", 0, fmt.Errorf("%w: unexpected status \"%d\": %s", sessions.ErrTokenExpired, result.StatusCode(), result.Body())
+	}
+	err = result.UnmarshalInto(&data)
 	if err != nil {
 		return "", "", 0, err
 	}
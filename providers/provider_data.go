@@ -45,6 +45,11 @@ type ProviderData struct {
 	GroupsClaim          string
 	Verifier             *oidc.IDTokenVerifier
 
+	// SessionClaims are the provider specific claims to carry in sessions, up
+	// to SessionClaimsMaxSize bytes once encoded (0 for no limit)
+	SessionClaims        []string
+	SessionClaimsMaxSize int
+
 	// Universal Group authorization data structure
 	// any provider can set to consume
 	AllowedGroups map[string]struct{}
@@ -154,6 +159,7 @@ func (p *ProviderData) buildSessionFromClaims(idToken *oidc.IDToken) (*sessions.
 	ss.User = claims.Subject
 	ss.Email = claims.Email
 	ss.Groups = claims.Groups
+	ss.Claims = p.extractSessionClaims(claims.raw)
 
 	// TODO (@NickMeves) Deprecate for dynamic claim to session mapping
 	if pref, ok := claims.raw["preferred_username"].(string); ok {
@@ -223,3 +229,40 @@ func (p *ProviderData) extractGroups(claims map[string]interface{}) []string {
 	}
 	return groups
 }
+
+// extractSessionClaims copies the configured session claims that are present.
+// Claims that would take the encoded claims over the size limit are left out
+// with a warning, to keep session cookies manageable.
+func (p *ProviderData) extractSessionClaims(claims map[string]interface{}) map[string]interface{} {
+	if len(p.SessionClaims) == 0 {
+		return nil
+	}
+
+	sessionClaims := map[string]interface{}{}
+	for _, name := range p.SessionClaims {
+		value, ok := claims[name]
+		if !ok {
+			continue
+		}
+		sessionClaims[name] = value
+		if p.SessionClaimsMaxSize == 0 {
+			continue
+		}
+
+		size, err := sessions.ClaimsSize(sessionClaims)
+		if err != nil {
+			logger.Errorf("Warning: unable to carry claim %q in the session: %v", name, err)
+			delete(sessionClaims, name)
+			continue
+		}
+		if size > p.SessionClaimsMaxSize {
+			logger.Errorf("Warning: claim %q was left out of the session as the session claims would exceed %d bytes", name, p.SessionClaimsMaxSize)
+			delete(sessionClaims, name)
+		}
+	}
+
+	if len(sessionClaims) == 0 {
+		return nil
+	}
+	return sessionClaims
+}
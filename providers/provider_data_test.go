@@ -209,6 +209,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 		AllowUnverified bool
 		EmailClaim      string
 		GroupsClaim     string
+		SessionClaims   []string
 		ExpectedError   error
 		ExpectedSession *sessions.SessionState
 	}{
@@ -315,6 +316,23 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Jane Dobbs",
 			},
 		},
+		"Session Claims": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			SessionClaims:   []string{"roles", "picture", "alskdjfsalkdjf"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+				Claims: map[string]interface{}{
+					"roles":   []interface{}{"test:c", "test:d"},
+					"picture": "http://mugbook.com/janed/me.jpg",
+				},
+			},
+		},
 	}
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
@@ -330,6 +348,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 			provider.AllowUnverifiedEmail = tc.AllowUnverified
 			provider.EmailClaim = tc.EmailClaim
 			provider.GroupsClaim = tc.GroupsClaim
+			provider.SessionClaims = tc.SessionClaims
 
 			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
 			g.Expect(err).ToNot(HaveOccurred())
@@ -348,6 +367,64 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 	}
 }
 
+func TestProviderData_extractSessionClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"email":      "this@does.not.matter.com",
+		"department": "engineering",
+		"roles":      []interface{}{"admin", strings.Repeat("x", 100)},
+	}
+
+	testCases := map[string]struct {
+		SessionClaims  []string
+		MaxSize        int
+		ExpectedClaims map[string]interface{}
+	}{
+		"Within The Limit": {
+			SessionClaims: []string{"department", "roles"},
+			MaxSize:       1024,
+			ExpectedClaims: map[string]interface{}{
+				"department": "engineering",
+				"roles":      []interface{}{"admin", strings.Repeat("x", 100)},
+			},
+		},
+		"Claims Over The Limit Are Left Out": {
+			SessionClaims: []string{"department", "roles"},
+			MaxSize:       64,
+			ExpectedClaims: map[string]interface{}{
+				"department": "engineering",
+			},
+		},
+		"No Limit": {
+			SessionClaims: []string{"department", "roles"},
+			MaxSize:       0,
+			ExpectedClaims: map[string]interface{}{
+				"department": "engineering",
+				"roles":      []interface{}{"admin", strings.Repeat("x", 100)},
+			},
+		},
+		"Missing Claims Return Nil": {
+			SessionClaims:  []string{"alskdjfsalkdjf"},
+			MaxSize:        1024,
+			ExpectedClaims: nil,
+		},
+		"No Session Claims Return Nil": {
+			MaxSize:        1024,
+			ExpectedClaims: nil,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				SessionClaims:        tc.SessionClaims,
+				SessionClaimsMaxSize: tc.MaxSize,
+			}
+			g.Expect(provider.extractSessionClaims(claims)).To(Equal(tc.ExpectedClaims))
+		})
+	}
+}
+
 func TestProviderData_extractGroups(t *testing.T) {
 	testCases := map[string]struct {
 		Claims         map[string]interface{}
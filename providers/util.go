@@ -1,12 +1,15 @@
 package providers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 
 	"github.com/bitly/go-simplejson"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"golang.org/x/oauth2"
 )
 
@@ -98,3 +101,19 @@ func coerceArray(sj *simplejson.Json, key string) []interface{} {
 	}
 	return []interface{}{single}
 }
+
+// isInvalidGrant checks whether a token endpoint response rejects the grant,
+// which for a refresh token means it has expired or was revoked
+func isInvalidGrant(status int, body []byte) bool {
+	return status == http.StatusBadRequest && bytes.Contains(body, []byte("invalid_grant"))
+}
+
+// wrapRefreshError wraps an error refreshing a token with the oauth2 library
+// in sessions.ErrTokenExpired if the refresh token was rejected
+func wrapRefreshError(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil && isInvalidGrant(retrieveErr.Response.StatusCode, retrieveErr.Body) {
+		return fmt.Errorf("%w: %v", sessions.ErrTokenExpired, err)
+	}
+	return err
+}
@@ -1,9 +1,12 @@
 package providers
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"testing"
 
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	. "github.com/onsi/gomega"
 	"golang.org/x/oauth2"
 )
@@ -111,3 +114,47 @@ func Test_formatGroup(t *testing.T) {
 		})
 	}
 }
+
+func Test_wrapRefreshError(t *testing.T) {
+	testCases := map[string]struct {
+		err     error
+		expired bool
+	}{
+		"Invalid Grant": {
+			err: &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: http.StatusBadRequest},
+				Body:     []byte(`{"error":"invalid_grant"}`),
+			},
+			expired: true,
+		},
+		"Server Error": {
+			err: &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: http.StatusInternalServerError},
+				Body:     []byte(`{"error":"invalid_grant"}`),
+			},
+			expired: false,
+		},
+		"Other Bad Request": {
+			err: &oauth2.RetrieveError{
+				Response: &http.Response{StatusCode: http.StatusBadRequest},
+				Body:     []byte(`{"error":"invalid_request"}`),
+			},
+			expired: false,
+		},
+		"Network Error": {
+			err:     errors.New("connection refused"),
+			expired: false,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			err := wrapRefreshError(tc.err)
+			g.Expect(errors.Is(err, sessions.ErrTokenExpired)).To(Equal(tc.expired))
+			if !tc.expired {
+				g.Expect(err).To(Equal(tc.err))
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/server"
+	"github.com/spf13/pflag"
+)
+
+const rulesUsage = `usage:
+  oauth2-proxy rules test [--config <file>] [--alpha-config <file>] --path <path> [--method GET]
+    [--host <host>] [--ip <client ip>] [--header "<name>: <value>"] [<option flags>]
+
+Loads the configuration, including any option flags, and prints whether the
+request would be denied by a deny rule, skip authentication through an
+allowlist, or need to be authenticated.`
+
+// runRulesCommand checks how the proxy handles a request with the loaded
+// configuration
+func runRulesCommand(args []string) error {
+	// Keep the output of the command separate from the configuration logs
+	logger.SetOutput(os.Stderr)
+	return rulesCommand(os.Stdout, args)
+}
+
+// rulesCommand runs the rules subcommand given as the first argument.
+// The only subcommand is test, which writes the result of the check as JSON.
+func rulesCommand(w io.Writer, args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return errors.New(rulesUsage)
+	}
+	args = args[1:]
+
+	// Only the config files are needed before the options are loaded, the
+	// rest of the flags are parsed with the option flags
+	configFlagSet := pflag.NewFlagSet("rules test", pflag.ContinueOnError)
+	config := configFlagSet.String("config", "", "path to config file")
+	alphaConfig := configFlagSet.String("alpha-config", "", "path to alpha config file")
+	configFlagSet.ParseErrorsWhitelist.UnknownFlags = true
+	if err := configFlagSet.Parse(args); err != nil {
+		return err
+	}
+
+	flagSet := pflag.NewFlagSet("rules test", pflag.ContinueOnError)
+	flagSet.AddFlagSet(configFlagSet)
+	method := flagSet.String("method", http.MethodGet, "the method of the request")
+	host := flagSet.String("host", "localhost", "the host of the request")
+	path := flagSet.String("path", "", "the path of the request, with any query string")
+	clientIP := flagSet.String("ip", "", "the IP of the client making the request")
+	headers := flagSet.StringArray("header", []string{}, "a request header in the format \"name: value\" (may be given multiple times)")
+
+	opts, err := loadConfiguration(*config, *alphaConfig, flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New(rulesUsage)
+	}
+
+	req, err := newRulesTestRequest(opts, *method, *host, *path, *clientIP, *headers)
+	if err != nil {
+		return err
+	}
+	check, err := server.CheckRules(opts, req)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(check, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal rules check: %v", err)
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// newRulesTestRequest builds the request to check.
+// With --reverse-proxy, the client IP is given in the real client IP header,
+// as the proxy would receive it.
+func newRulesTestRequest(opts *options.Options, method, host, path, clientIP string, headers []string) (*http.Request, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	req, err := http.NewRequest(strings.ToUpper(method), "http://"+host+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"name: value\"", header)
+		}
+		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	if clientIP != "" {
+		if net.ParseIP(clientIP) == nil {
+			return nil, fmt.Errorf("invalid client IP %q", clientIP)
+		}
+		req.RemoteAddr = net.JoinHostPort(clientIP, "0")
+		if opts.ReverseProxy {
+			req.Header.Set(opts.RealClientIPHeader, clientIP)
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/server"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules Command Suite", func() {
+	ruleFlags := []string{
+		"--deny-route", "POST=^/admin/ # admin writes",
+		"--deny-ip", "192.168.0.0/16",
+		"--deny-action", "deny-ip-0=forbidden",
+		"--skip-auth-route", "GET=^/health$",
+		"--trusted-ip", "10.0.0.0/8",
+	}
+
+	DescribeTable("rules test",
+		func(args []string, expected *server.RulesCheck) {
+			buf := bytes.NewBuffer(nil)
+			Expect(rulesCommand(buf, append(append([]string{"test"}, ruleFlags...), args...))).To(Succeed())
+
+			check := &server.RulesCheck{}
+			Expect(json.Unmarshal(buf.Bytes(), check)).To(Succeed())
+			Expect(check).To(Equal(expected))
+		},
+		Entry("with a denied route", []string{"--method", "post", "--path", "/admin/users"}, &server.RulesCheck{
+			Decision:   server.DenyDecision,
+			RuleSet:    "default",
+			DenyRule:   `"deny-route-0" (admin writes)`,
+			DenyAction: "error_page",
+		}),
+		Entry("with a denied IP", []string{"--path", "/", "--ip", "192.168.1.1"}, &server.RulesCheck{
+			Decision:   server.DenyDecision,
+			RuleSet:    "default",
+			DenyRule:   `"deny-ip-0"`,
+			DenyAction: "forbidden",
+		}),
+		Entry("with an allowlisted route", []string{"--path", "/health"}, &server.RulesCheck{
+			Decision:       server.SkipAuthDecision,
+			RuleSet:        "default",
+			AllowlistEntry: "route GET=^/health$",
+		}),
+		Entry("with a trusted IP behind a reverse proxy", []string{"--path", "/", "--ip", "10.0.0.1", "--reverse-proxy"}, &server.RulesCheck{
+			Decision:       server.SkipAuthDecision,
+			RuleSet:        "default",
+			AllowlistEntry: "trusted IP 10.0.0.1",
+		}),
+		Entry("with a request that must be authenticated", []string{"--path", "/admin/users", "--header", "X-Test: value"}, &server.RulesCheck{
+			Decision: server.AuthenticateDecision,
+			RuleSet:  "default",
+		}),
+	)
+
+	It("prints the usage without the test subcommand or a path", func() {
+		Expect(rulesCommand(bytes.NewBuffer(nil), []string{})).To(MatchError(rulesUsage))
+		Expect(rulesCommand(bytes.NewBuffer(nil), []string{"test", "--method", "GET"})).To(MatchError(rulesUsage))
+	})
+
+	It("rejects invalid requests and rules", func() {
+		err := rulesCommand(bytes.NewBuffer(nil), []string{"test", "--path", "/", "--header", "X-Test"})
+		Expect(err).To(MatchError(`invalid header "X-Test", expected "name: value"`))
+
+		err = rulesCommand(bytes.NewBuffer(nil), []string{"test", "--path", "/", "--ip", "not-an-ip"})
+		Expect(err).To(MatchError(`invalid client IP "not-an-ip"`))
+
+		err = rulesCommand(bytes.NewBuffer(nil), []string{"test", "--path", "/", "--deny-ip", "not-an-ip"})
+		Expect(err).To(MatchError("invalid configuration:\n  deny_ips[0] (not-an-ip) could not be recognized"))
+	})
+})
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/validation"
+	"github.com/spf13/pflag"
+)
+
+const secretUsage = `usage:
+  oauth2-proxy secret generate [--bytes 32]
+  oauth2-proxy secret check <value>`
+
+// runSecretCommand generates new cookie secrets or checks existing ones
+// can be used to create the session cookie cipher.
+func runSecretCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(secretUsage)
+	}
+
+	switch args[0] {
+	case "generate":
+		return generateSecret(os.Stdout, args[1:])
+	case "check":
+		return checkSecret(os.Stdout, args[1:])
+	default:
+		return fmt.Errorf("unknown secret command %q\n%s", args[0], secretUsage)
+	}
+}
+
+// generateSecret writes a random base64url encoded secret of a valid AES
+// key length.
+func generateSecret(w io.Writer, args []string) error {
+	flagSet := pflag.NewFlagSet("secret generate", pflag.ContinueOnError)
+	size := flagSet.Int("bytes", 32, "number of random bytes in the secret (one of 16, 24 or 32)")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	switch *size {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("--bytes must be one of 16, 24 or 32 to create an AES cipher, but is %d", *size)
+	}
+
+	secret := make([]byte, *size)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("unable to generate secret: %v", err)
+	}
+	encoded := base64.URLEncoding.EncodeToString(secret)
+
+	// Make sure the runtime will interpret the secret the same way
+	if err := validation.ValidateCookieSecret(encoded); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, encoded)
+	return err
+}
+
+// checkSecret validates a secret using the same logic as the cookie_secret
+// option and describes how it will be interpreted.
+func checkSecret(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New(secretUsage)
+	}
+	secret := args[0]
+
+	if err := validation.ValidateCookieSecret(secret); err != nil {
+		return err
+	}
+
+	secretBytes := encryption.SecretBytes(secret)
+	encoding := "base64 decoded"
+	if bytes.Equal(secretBytes, []byte(secret)) {
+		encoding = "raw"
+	}
+
+	_, err := fmt.Fprintf(w, "secret is valid: %d bytes (%s)\n", len(secretBytes), encoding)
+	return err
+}
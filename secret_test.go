@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secret Command Suite", func() {
+	DescribeTable("generateSecret",
+		func(args []string, expectedBytes int) {
+			buf := bytes.NewBuffer(nil)
+			Expect(generateSecret(buf, args)).To(Succeed())
+
+			secret := strings.TrimSpace(buf.String())
+			Expect(encryption.SecretBytes(secret)).To(HaveLen(expectedBytes))
+		},
+		Entry("with the default size", []string{}, 32),
+		Entry("with 16 bytes", []string{"--bytes", "16"}, 16),
+		Entry("with 24 bytes", []string{"--bytes=24"}, 24),
+	)
+
+	It("generateSecret rejects invalid sizes", func() {
+		err := generateSecret(bytes.NewBuffer(nil), []string{"--bytes", "20"})
+		Expect(err).To(MatchError("--bytes must be one of 16, 24 or 32 to create an AES cipher, but is 20"))
+	})
+
+	It("generateSecret returns different secrets each time", func() {
+		first := bytes.NewBuffer(nil)
+		second := bytes.NewBuffer(nil)
+		Expect(generateSecret(first, []string{})).To(Succeed())
+		Expect(generateSecret(second, []string{})).To(Succeed())
+		Expect(first.String()).ToNot(Equal(second.String()))
+	})
+
+	DescribeTable("checkSecret",
+		func(secret string, expectedOutput string, expectedErr string) {
+			buf := bytes.NewBuffer(nil)
+			err := checkSecret(buf, []string{secret})
+			if expectedErr != "" {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buf.String()).To(Equal(expectedOutput))
+		},
+		Entry("with a base64 encoded 32 byte secret", "yzYHPr9WEkqrUz9skOGhM8z_0JmvjJhs-vUZMi4gr10=", "secret is valid: 32 bytes (base64 decoded)\n", ""),
+		Entry("with a raw 16 byte secret", "0123456789abcdef", "secret is valid: 16 bytes (raw)\n", ""),
+		Entry("with an invalid length", "too-short", "", "cookie_secret must be 16, 24, or 32 bytes to create an AES cipher, but is 9 bytes"),
+		Entry("with an empty secret", "", "", "missing setting: cookie-secret"),
+	)
+
+	It("checkSecret requires a single value", func() {
+		Expect(checkSecret(bytes.NewBuffer(nil), []string{})).To(MatchError(secretUsage))
+	})
+})